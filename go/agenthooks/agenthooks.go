@@ -0,0 +1,217 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package agenthooks wraps an SSH agent so that other parts of the extension
+// (e.g., auditing, metrics, policy and confirmation) can observe and refuse
+// operations on it, without each of them wrapping the agent or the key
+// manager in its own way.
+package agenthooks
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Hooks are invoked around operations on an Agent.  Any of the hooks may be
+// nil.  A Before hook may refuse the operation by returning an error; the
+// operation is then not performed, and the error is returned to the caller.
+// After hooks are invoked for every operation, including those refused by
+// a Before hook, with the error (if any) returned to the caller.
+//
+// Hooks may be invoked concurrently, and must not block for long; signature
+// requests are processed concurrently.  The exception is BeforeSign, which
+// may wait (with a timeout) for the user to confirm a signature; only that
+// request is delayed.
+type Hooks struct {
+	// BeforeAdd is invoked before a key is added.
+	BeforeAdd func(key agent.AddedKey) error
+	// AfterAdd is invoked after a key is added.
+	AfterAdd func(key agent.AddedKey, err error)
+	// BeforeRemove is invoked before a key is removed.
+	BeforeRemove func(key ssh.PublicKey) error
+	// AfterRemove is invoked after a key is removed.
+	AfterRemove func(key ssh.PublicKey, err error)
+	// AfterList is invoked after the keys are listed.
+	AfterList func(keys []*agent.Key, err error)
+	// BeforeSign is invoked before data is signed.  key is the loaded
+	// key (including its comment) that will be used; if no such key is
+	// loaded, only its Format and Blob are set.
+	BeforeSign func(key *agent.Key, data []byte) error
+	// AfterSign is invoked after data is signed.
+	AfterSign func(key *agent.Key, data []byte, sig *ssh.Signature, err error)
+}
+
+// Agent is an agent.Agent that invokes the installed hooks around each
+// operation on the underlying agent.  Operations without hooks (e.g.,
+// RemoveAll and Lock) are passed through unchanged.
+type Agent struct {
+	agent.Agent
+
+	mu    sync.Mutex
+	hooks []*Hooks
+}
+
+// New returns an Agent that wraps a.  No hooks are installed.
+func New(a agent.Agent) *Agent {
+	return &Agent{Agent: a}
+}
+
+// Install adds hooks that are invoked around each subsequent operation.
+// Hooks are invoked in the order in which they were installed; the first
+// Before hook to refuse an operation prevents later ones from running.
+func (a *Agent) Install(h *Hooks) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.hooks = append(a.hooks, h)
+}
+
+// installed returns the hooks that are currently installed.
+func (a *Agent) installed() []*Hooks {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.hooks
+}
+
+// Add implements agent.Agent.Add.
+func (a *Agent) Add(key agent.AddedKey) error {
+	hooks := a.installed()
+	err := func() error {
+		for _, h := range hooks {
+			if h.BeforeAdd == nil {
+				continue
+			}
+			if err := h.BeforeAdd(key); err != nil {
+				return err
+			}
+		}
+		return a.Agent.Add(key)
+	}()
+	for _, h := range hooks {
+		if h.AfterAdd != nil {
+			h.AfterAdd(key, err)
+		}
+	}
+	return err
+}
+
+// Remove implements agent.Agent.Remove.
+func (a *Agent) Remove(key ssh.PublicKey) error {
+	hooks := a.installed()
+	err := func() error {
+		for _, h := range hooks {
+			if h.BeforeRemove == nil {
+				continue
+			}
+			if err := h.BeforeRemove(key); err != nil {
+				return err
+			}
+		}
+		return a.Agent.Remove(key)
+	}()
+	for _, h := range hooks {
+		if h.AfterRemove != nil {
+			h.AfterRemove(key, err)
+		}
+	}
+	return err
+}
+
+// List implements agent.Agent.List.
+func (a *Agent) List() ([]*agent.Key, error) {
+	keys, err := a.Agent.List()
+	for _, h := range a.installed() {
+		if h.AfterList != nil {
+			h.AfterList(keys, err)
+		}
+	}
+	return keys, err
+}
+
+// loadedKey returns the loaded key with the specified public key, or a key
+// with only its Format and Blob set if it is not loaded.
+func (a *Agent) loadedKey(key ssh.PublicKey) *agent.Key {
+	blob := key.Marshal()
+	if keys, err := a.Agent.List(); err == nil {
+		for _, l := range keys {
+			if bytes.Equal(l.Blob, blob) {
+				return l
+			}
+		}
+	}
+	return &agent.Key{Format: key.Type(), Blob: blob}
+}
+
+// Sign implements agent.Agent.Sign.
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	hooks := a.installed()
+	if len(hooks) == 0 {
+		return a.Agent.Sign(key, data)
+	}
+
+	loaded := a.loadedKey(key)
+	sig, err := func() (*ssh.Signature, error) {
+		for _, h := range hooks {
+			if h.BeforeSign == nil {
+				continue
+			}
+			if err := h.BeforeSign(loaded, data); err != nil {
+				return nil, err
+			}
+		}
+		return a.Agent.Sign(key, data)
+	}()
+	for _, h := range hooks {
+		if h.AfterSign != nil {
+			h.AfterSign(loaded, data, sig, err)
+		}
+	}
+	return sig, err
+}
+
+// Signers implements agent.Agent.Signers.  Signatures made using the
+// returned signers are made by Sign, so the hooks are invoked for them too.
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	signers, err := a.Agent.Signers()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ssh.Signer
+	for _, s := range signers {
+		result = append(result, &signer{a: a, pub: s.PublicKey()})
+	}
+	return result, nil
+}
+
+// signer is an ssh.Signer that signs using an Agent.
+type signer struct {
+	a   *Agent
+	pub ssh.PublicKey
+}
+
+// PublicKey implements ssh.Signer.PublicKey.
+func (s *signer) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+// Sign implements ssh.Signer.Sign.
+func (s *signer) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.a.Sign(s.pub, data)
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agenthooks
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newKey(comment string) (agent.AddedKey, ssh.PublicKey) {
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse private key: %v", err))
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create signer: %v", err))
+	}
+	return agent.AddedKey{PrivateKey: priv, Comment: comment}, signer.PublicKey()
+}
+
+func errStr(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// recorder returns hooks that record each invocation in events, prefixed
+// with name.  If refuse is set, its Before hooks refuse every operation.
+func recorder(name string, refuse bool, events *[]string) *Hooks {
+	before := func(op string) error {
+		*events = append(*events, fmt.Sprintf("%s: before %s", name, op))
+		if refuse {
+			return fmt.Errorf("%s refused %s", name, op)
+		}
+		return nil
+	}
+	after := func(op string, err error) {
+		*events = append(*events, fmt.Sprintf("%s: after %s (%s)", name, op, errStr(err)))
+	}
+	return &Hooks{
+		BeforeAdd: func(key agent.AddedKey) error {
+			return before("add " + key.Comment)
+		},
+		AfterAdd: func(key agent.AddedKey, err error) {
+			after("add "+key.Comment, err)
+		},
+		BeforeRemove: func(key ssh.PublicKey) error {
+			return before("remove")
+		},
+		AfterRemove: func(key ssh.PublicKey, err error) {
+			after("remove", err)
+		},
+		AfterList: func(keys []*agent.Key, err error) {
+			after(fmt.Sprintf("list %d", len(keys)), err)
+		},
+		BeforeSign: func(key *agent.Key, data []byte) error {
+			return before("sign " + key.Comment)
+		},
+		AfterSign: func(key *agent.Key, data []byte, sig *ssh.Signature, err error) {
+			after(fmt.Sprintf("sign %s %v", key.Comment, sig != nil), err)
+		},
+	}
+}
+
+func TestHooks(t *testing.T) {
+	key, pub := newKey("some-key")
+
+	testcases := []struct {
+		description string
+		preload     bool
+		refuse      bool
+		op          func(a *Agent) error
+		wantErr     error
+		wantEvents  []string
+	}{
+		{
+			description: "add",
+			op: func(a *Agent) error {
+				return a.Add(key)
+			},
+			wantEvents: []string{
+				"first: before add some-key",
+				"second: before add some-key",
+				"first: after add some-key ()",
+				"second: after add some-key ()",
+			},
+		},
+		{
+			description: "add refused",
+			refuse:      true,
+			op: func(a *Agent) error {
+				return a.Add(key)
+			},
+			wantErr: errors.New("first refused add some-key"),
+			wantEvents: []string{
+				"first: before add some-key",
+				"first: after add some-key (first refused add some-key)",
+				"second: after add some-key (first refused add some-key)",
+			},
+		},
+		{
+			description: "remove",
+			preload:     true,
+			op: func(a *Agent) error {
+				return a.Remove(pub)
+			},
+			wantEvents: []string{
+				"first: before remove",
+				"second: before remove",
+				"first: after remove ()",
+				"second: after remove ()",
+			},
+		},
+		{
+			description: "list",
+			preload:     true,
+			op: func(a *Agent) error {
+				_, err := a.List()
+				return err
+			},
+			wantEvents: []string{
+				"first: after list 1 ()",
+				"second: after list 1 ()",
+			},
+		},
+		{
+			description: "sign",
+			preload:     true,
+			op: func(a *Agent) error {
+				_, err := a.Sign(pub, []byte("some-data"))
+				return err
+			},
+			wantEvents: []string{
+				"first: before sign some-key",
+				"second: before sign some-key",
+				"first: after sign some-key true ()",
+				"second: after sign some-key true ()",
+			},
+		},
+		{
+			description: "sign refused",
+			preload:     true,
+			refuse:      true,
+			op: func(a *Agent) error {
+				_, err := a.Sign(pub, []byte("some-data"))
+				return err
+			},
+			wantErr: errors.New("first refused sign some-key"),
+			wantEvents: []string{
+				"first: before sign some-key",
+				"first: after sign some-key false (first refused sign some-key)",
+				"second: after sign some-key false (first refused sign some-key)",
+			},
+		},
+		{
+			description: "sign with key not loaded",
+			op: func(a *Agent) error {
+				_, err := a.Sign(pub, []byte("some-data"))
+				return err
+			},
+			wantErr: errors.New("not found"),
+			wantEvents: []string{
+				"first: before sign ",
+				"second: before sign ",
+				"first: after sign  false (not found)",
+				"second: after sign  false (not found)",
+			},
+		},
+		{
+			description: "sign using signer",
+			preload:     true,
+			op: func(a *Agent) error {
+				signers, err := a.Signers()
+				if err != nil {
+					return err
+				}
+				_, err = signers[0].Sign(rand.Reader, []byte("some-data"))
+				return err
+			},
+			wantEvents: []string{
+				"first: before sign some-key",
+				"second: before sign some-key",
+				"first: after sign some-key true ()",
+				"second: after sign some-key true ()",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		a := New(agent.NewKeyring())
+		if tc.preload {
+			if err := a.Add(key); err != nil {
+				t.Fatalf("%s: failed to add key: %v", tc.description, err)
+			}
+		}
+		var events []string
+		a.Install(recorder("first", tc.refuse, &events))
+		a.Install(recorder("second", false, &events))
+
+		err := tc.op(a)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(events, tc.wantEvents); diff != nil {
+			t.Errorf("%s: incorrect events; -got +want: %s", tc.description, diff)
+		}
+	}
+}
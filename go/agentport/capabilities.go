@@ -0,0 +1,198 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Message types used by clients to invoke protocol extensions.  See
+	// https://tools.ietf.org/html/draft-miller-ssh-agent-02#section-4.7.
+	agentSuccess   = 6
+	agentExtension = 27
+
+	// ProtocolVersion is the version of the capabilities protocol
+	// implemented by the agent.
+	ProtocolVersion = 1
+
+	// QueryExtension is the extension with which clients list the
+	// extensions supported by the agent.
+	QueryExtension = "query"
+	// CapabilitiesExtension is the extension with which clients negotiate
+	// the features they will use (see Negotiate).
+	CapabilitiesExtension = "capabilities@chrome-ssh-agent"
+)
+
+// Features that may be negotiated using CapabilitiesExtension.
+const (
+	// FeatureRSASHA2 indicates that signatures using RSA keys may be
+	// requested with the rsa-sha2-256 and rsa-sha2-512 algorithms.
+	FeatureRSASHA2 = "rsa-sha2"
+	// FeatureCertificates indicates that keys loaded with an OpenSSH
+	// certificate are listed by their certificate.
+	FeatureCertificates = "certificates"
+	// FeatureSecurityKeys indicates that FIDO security keys (sk-ecdsa
+	// and sk-ed25519) may be used.
+	FeatureSecurityKeys = "sk"
+	// FeatureExtensions indicates that the agent supports protocol
+	// extensions (see Extensions).
+	FeatureExtensions = "extensions"
+)
+
+var (
+	// Features lists the features supported by the agent.  Signatures
+	// using RSA keys are always made with ssh-rsa, and security keys
+	// cannot be used from an extension, so those are not included.
+	Features = []string{FeatureCertificates, FeatureExtensions}
+	// Extensions lists the protocol extensions supported by the agent.
+	Extensions = []string{QueryExtension, CapabilitiesExtension}
+)
+
+// CapabilitiesObserver is implemented by agents that are told when a client
+// negotiates capabilities (e.g., to record them for diagnostics).  Serve
+// notifies the agent it serves, if it implements the interface.
+type CapabilitiesObserver interface {
+	// NegotiatedCapabilities is invoked with the protocol version and the
+	// features negotiated by a client.
+	NegotiatedCapabilities(version uint32, features []string)
+}
+
+// extensionMsg is a request to invoke a protocol extension.
+type extensionMsg struct {
+	ExtensionType string `sshtype:"27"`
+	Contents      []byte `ssh:"rest"`
+}
+
+// capabilitiesRequest is the contents of a request to negotiate
+// capabilities.  Version is the highest protocol version supported by the
+// client, and Features lists the features it would like to use.
+type capabilitiesRequest struct {
+	Version  uint32
+	Features []string
+}
+
+// capabilitiesReply is the contents of the reply to a request to negotiate
+// capabilities.  Version is the protocol version to be used, Features lists
+// the features supported by the agent, and Negotiated lists those that both
+// the agent and the client support.
+type capabilitiesReply struct {
+	Version    uint32
+	Features   []string
+	Negotiated []string
+}
+
+// Negotiate returns the protocol version and features to be used with a
+// client that supports clientVersion and clientFeatures.  It fails if the
+// client supports no protocol version.
+func Negotiate(clientVersion uint32, clientFeatures []string) (version uint32, features []string, err error) {
+	if clientVersion == 0 {
+		return 0, nil, fmt.Errorf("unsupported protocol version %d", clientVersion)
+	}
+	version = ProtocolVersion
+	if clientVersion < version {
+		version = clientVersion
+	}
+
+	supported := make(map[string]bool)
+	for _, f := range Features {
+		supported[f] = true
+	}
+	features = []string{}
+	for _, f := range clientFeatures {
+		if supported[f] {
+			features = append(features, f)
+			delete(supported, f)
+		}
+	}
+	return version, features, nil
+}
+
+// isExtensionRequest determines if the framed request data asks to invoke a
+// protocol extension.
+func isExtensionRequest(data []byte) bool {
+	return len(data) > 4 && data[4] == agentExtension
+}
+
+// frame returns the framed reply containing msg.
+func frame(msg []byte) []byte {
+	result := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(result, uint32(len(msg)))
+	copy(result[4:], msg)
+	return result
+}
+
+// success returns the framed reply indicating success, followed by contents.
+func success(contents []byte) []byte {
+	return frame(append([]byte{agentSuccess}, contents...))
+}
+
+// processExtension processes a framed request to invoke a protocol
+// extension, and returns the framed reply.  Clients negotiating
+// capabilities are reported to a, if it implements CapabilitiesObserver.
+// Requests for unknown extensions fail, as the protocol specifies.
+func processExtension(a interface{}, data []byte) []byte {
+	var req extensionMsg
+	if err := ssh.Unmarshal(data[4:], &req); err != nil {
+		log.Printf("agent: invalid extension request: %v", err)
+		return failure
+	}
+
+	switch req.ExtensionType {
+	case QueryExtension:
+		var contents []byte
+		for _, e := range Extensions {
+			contents = append(contents, ssh.Marshal(struct{ Name string }{e})...)
+		}
+		return success(contents)
+	case CapabilitiesExtension:
+		var caps capabilitiesRequest
+		if err := ssh.Unmarshal(req.Contents, &caps); err != nil {
+			log.Printf("agent: invalid capabilities request: %v", err)
+			return failure
+		}
+		version, features, err := Negotiate(caps.Version, caps.Features)
+		if err != nil {
+			log.Printf("agent: failed to negotiate capabilities: %v", err)
+			return failure
+		}
+		if o, ok := a.(CapabilitiesObserver); ok {
+			o.NegotiatedCapabilities(version, features)
+		}
+		return success(ssh.Marshal(&capabilitiesReply{
+			Version:    version,
+			Features:   Features,
+			Negotiated: features,
+		}))
+	}
+
+	log.Printf("agent: refused unknown extension %q", req.ExtensionType)
+	return failure
+}
+
+// DescribeCapabilities describes the protocol version and features
+// negotiated by a client, for display to the user.
+func DescribeCapabilities(version uint32, features []string) string {
+	list := strings.Join(features, ", ")
+	if list == "" {
+		list = "none"
+	}
+	return fmt.Sprintf("protocol version %d; features: %s", version, list)
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+// observingAgent is a blockingAgent that records the capabilities
+// negotiated by clients.
+type observingAgent struct {
+	*blockingAgent
+	negotiated []string
+}
+
+func (a *observingAgent) NegotiatedCapabilities(version uint32, features []string) {
+	a.negotiated = append(a.negotiated, DescribeCapabilities(version, features))
+}
+
+// extensionRequest returns a request to invoke the named extension.
+func extensionRequest(name string, contents []byte) []byte {
+	return ssh.Marshal(&extensionMsg{
+		ExtensionType: name,
+		Contents:      contents,
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	testcases := []struct {
+		description  string
+		version      uint32
+		features     []string
+		wantVersion  uint32
+		wantFeatures []string
+		wantErr      error
+	}{
+		{
+			description:  "same version",
+			version:      ProtocolVersion,
+			features:     []string{FeatureCertificates, FeatureRSASHA2},
+			wantVersion:  ProtocolVersion,
+			wantFeatures: []string{FeatureCertificates},
+		},
+		{
+			description:  "newer client",
+			version:      ProtocolVersion + 1,
+			features:     []string{FeatureExtensions, FeatureCertificates, "future-feature"},
+			wantVersion:  ProtocolVersion,
+			wantFeatures: []string{FeatureExtensions, FeatureCertificates},
+		},
+		{
+			description:  "duplicate features",
+			version:      ProtocolVersion,
+			features:     []string{FeatureCertificates, FeatureCertificates},
+			wantVersion:  ProtocolVersion,
+			wantFeatures: []string{FeatureCertificates},
+		},
+		{
+			description:  "no features",
+			version:      ProtocolVersion,
+			wantVersion:  ProtocolVersion,
+			wantFeatures: []string{},
+		},
+		{
+			description: "unsupported version",
+			version:     0,
+			wantErr:     errors.New("unsupported protocol version 0"),
+		},
+	}
+
+	for _, tc := range testcases {
+		version, features, err := Negotiate(tc.version, tc.features)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if version != tc.wantVersion {
+			t.Errorf("%s: incorrect version; got %d, want %d", tc.description, version, tc.wantVersion)
+		}
+		if diff := pretty.Diff(features, tc.wantFeatures); diff != nil {
+			t.Errorf("%s: incorrect features; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestServeExtensions(t *testing.T) {
+	var query []byte
+	for _, e := range []string{QueryExtension, CapabilitiesExtension} {
+		query = append(query, ssh.Marshal(struct{ Name string }{e})...)
+	}
+	caps := ssh.Marshal(&capabilitiesReply{
+		Version:    ProtocolVersion,
+		Features:   []string{FeatureCertificates, FeatureExtensions},
+		Negotiated: []string{FeatureCertificates},
+	})
+
+	testcases := []struct {
+		description    string
+		req            []byte
+		want           []byte
+		wantNegotiated []string
+	}{
+		{
+			description: "query extensions",
+			req:         extensionRequest(QueryExtension, nil),
+			want:        append([]byte{agentSuccess}, query...),
+		},
+		{
+			description: "negotiate capabilities",
+			req: extensionRequest(CapabilitiesExtension, ssh.Marshal(&capabilitiesRequest{
+				Version:  ProtocolVersion,
+				Features: []string{FeatureRSASHA2, FeatureCertificates, FeatureSecurityKeys},
+			})),
+			want:           append([]byte{agentSuccess}, caps...),
+			wantNegotiated: []string{"protocol version 1; features: certificates"},
+		},
+		{
+			description: "unsupported protocol version",
+			req: extensionRequest(CapabilitiesExtension, ssh.Marshal(&capabilitiesRequest{
+				Features: []string{FeatureCertificates},
+			})),
+			want: []byte{agentFailure},
+		},
+		{
+			description: "invalid capabilities request",
+			req:         extensionRequest(CapabilitiesExtension, []byte{0, 0}),
+			want:        []byte{agentFailure},
+		},
+		{
+			description: "unknown extension",
+			req:         extensionRequest("session-bind@openssh.com", nil),
+			want:        []byte{agentFailure},
+		},
+		{
+			description: "invalid request",
+			req:         []byte{agentExtension, 0, 0},
+			want:        []byte{agentFailure},
+		},
+	}
+
+	for _, tc := range testcases {
+		a := &observingAgent{blockingAgent: newBlockingAgent(nil)}
+		client, server := newConn()
+		go Serve(a, server, DefaultParallelism)
+
+		// The reply is as expected, and the connection remains usable.
+		for _, req := range [][]byte{tc.req, {11}} {
+			if err := writeMessage(client, req); err != nil {
+				t.Fatalf("%s: failed to write request: %v", tc.description, err)
+			}
+		}
+		for _, want := range [][]byte{tc.want, {12, 0, 0, 0, 0}} {
+			rsp, err := readMessage(client)
+			if err != nil {
+				t.Fatalf("%s: failed to read reply: %v", tc.description, err)
+			}
+			if diff := pretty.Diff(rsp, want); diff != nil {
+				t.Errorf("%s: incorrect reply; -got +want: %s", tc.description, diff)
+			}
+		}
+		if diff := pretty.Diff(a.negotiated, tc.wantNegotiated); diff != nil {
+			t.Errorf("%s: incorrect negotiated capabilities; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestDescribeCapabilities(t *testing.T) {
+	if got, want := DescribeCapabilities(1, nil), "protocol version 1; features: none"; got != want {
+		t.Errorf("incorrect description; got %q, want %q", got, want)
+	}
+	if got, want := DescribeCapabilities(1, []string{FeatureCertificates, FeatureExtensions}), "protocol version 1; features: certificates, extensions"; got != want {
+		t.Errorf("incorrect description; got %q, want %q", got, want)
+	}
+}
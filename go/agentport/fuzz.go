@@ -0,0 +1,70 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build gofuzz
+// +build gofuzz
+
+package agentport
+
+import (
+	"bytes"
+
+	"github.com/google/chrome-ssh-agent/go/agenthooks"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// fuzzKey is loaded into the agent against which fuzzed requests are
+// processed, so that sign requests naming it reach the signing code.  It is
+// derived from a fixed seed so that failing inputs can be reproduced.
+var fuzzKey ed25519.PrivateKey
+
+func init() {
+	var err error
+	if _, fuzzKey, err = ed25519.GenerateKey(bytes.NewReader(make([]byte, 32))); err != nil {
+		panic(err)
+	}
+}
+
+// Fuzz processes data as a stream of framed agent requests from a client,
+// as the extension does for each connection.  It is the entry point for
+// go-fuzz; see the 'fuzz' target in the Makefile.
+func Fuzz(data []byte) int {
+	// Requests may add and remove keys, so each input uses a new agent.
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: &fuzzKey, Comment: "fuzz-key"}); err != nil {
+		panic(err)
+	}
+	// The extension serves the agent with hooks installed, which match
+	// the key in each sign request against the loaded keys.
+	hooked := agenthooks.New(keyring)
+	hooked.Install(&agenthooks.Hooks{})
+
+	r := bytes.NewReader(data)
+	processed := 0
+	for {
+		req, err := readRequest(r)
+		if err != nil {
+			break
+		}
+		if rep := process(hooked, req); len(rep) < 4 {
+			panic("reply is missing its length")
+		}
+		processed++
+	}
+	if processed == 0 {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build gofuzz
+// +build gofuzz
+
+package agentport
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// fuzzCorpus is the directory containing inputs for Fuzz.  Inputs that
+// previously caused failures are kept here so that they are not
+// reintroduced.
+const fuzzCorpus = "testdata/fuzz/corpus"
+
+func TestFuzzCorpus(t *testing.T) {
+	files, err := ioutil.ReadDir(fuzzCorpus)
+	if err != nil {
+		t.Fatalf("failed to list corpus: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("corpus is empty")
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(fuzzCorpus, f.Name()))
+		if err != nil {
+			t.Fatalf("%s: failed to read input: %v", f.Name(), err)
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s: panicked: %v", f.Name(), r)
+				}
+			}()
+			Fuzz(data)
+		}()
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// DefaultParallelism is the number of sign requests processed
+	// concurrently for a single connection if none is configured.
+	DefaultParallelism = 4
+	// MaxParallelism is the largest number of sign requests that may be
+	// processed concurrently for a single connection.
+	MaxParallelism = 16
+
+	// maxRequestBytes is the largest request that is accepted; it matches
+	// the limit imposed by agent.ServeAgent.
+	maxRequestBytes = 16 << 20
+
+	// Message types from the SSH agent protocol.  See
+	// https://tools.ietf.org/html/draft-miller-ssh-agent-02.
+	agentFailure     = 5
+	agentSignRequest = 13
+)
+
+var (
+	// failure is the framed reply sent when a request could not be
+	// processed.
+	failure = []byte{0, 0, 0, 1, agentFailure}
+)
+
+// request is a framed request read from a client, and the channel on which
+// its framed reply is delivered.
+type request struct {
+	data  []byte
+	reply chan []byte
+}
+
+// Serve serves the SSH agent protocol on c using a, like agent.ServeAgent.
+// Clients may pipeline requests (for example, when multiplexing many
+// channels over a single connection); up to parallelism sign requests are
+// processed concurrently, and replies are always written in the order the
+// requests were received.  All other requests wait for outstanding sign
+// requests to complete, and are processed one at a time, so that they
+// observe (and affect) the keyring in order.  Serve returns when reading a
+// request or writing a reply fails.
+func Serve(a agent.Agent, c io.ReadWriter, parallelism int) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if parallelism > MaxParallelism {
+		parallelism = MaxParallelism
+	}
+
+	// Replies are written in order by a single writer.  Requests are
+	// queued for it before they are processed; the queue is large enough
+	// that reading only blocks once the limit on outstanding sign
+	// requests is reached.
+	pending := make(chan *request, parallelism)
+	writeErr := make(chan error, 1)
+	go func() {
+		var err error
+		for req := range pending {
+			rep := <-req.reply
+			if err == nil {
+				_, err = c.Write(rep)
+			}
+		}
+		writeErr <- err
+	}()
+
+	var signing sync.WaitGroup
+	slots := make(chan struct{}, parallelism)
+	var err error
+	for {
+		var data []byte
+		if data, err = readRequest(c); err != nil {
+			break
+		}
+
+		req := &request{
+			data:  data,
+			reply: make(chan []byte, 1),
+		}
+		if len(data) > 4 && data[4] == agentSignRequest {
+			slots <- struct{}{}
+			signing.Add(1)
+			pending <- req
+			go func() {
+				defer signing.Done()
+				req.reply <- process(a, req.data)
+				<-slots
+			}()
+			continue
+		}
+
+		signing.Wait()
+		pending <- req
+		req.reply <- process(a, req.data)
+	}
+
+	signing.Wait()
+	close(pending)
+	if werr := <-writeErr; werr != nil {
+		return werr
+	}
+	return err
+}
+
+// readRequest reads a single framed request from r.  The returned request
+// includes the length prefix.
+func readRequest(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	l := binary.BigEndian.Uint32(length[:])
+	if l > maxRequestBytes {
+		return nil, fmt.Errorf("agent: request too large: %d", l)
+	}
+
+	data := make([]byte, 4+l)
+	copy(data, length[:])
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// oneShot is an io.ReadWriter that supplies a single request to
+// agent.ServeAgent, and collects its reply.
+type oneShot struct {
+	req *bytes.Reader
+	rep bytes.Buffer
+}
+
+func (o *oneShot) Read(p []byte) (int, error) {
+	return o.req.Read(p)
+}
+
+func (o *oneShot) Write(p []byte) (int, error) {
+	return o.rep.Write(p)
+}
+
+// process processes a single framed request using a, and returns the framed
+// reply.  The request is processed by agent.ServeAgent, which returns once
+// it reaches the end of the request.
+func process(a agent.Agent, data []byte) []byte {
+	// agent.ServeAgent assumes that every request includes a message
+	// type, and panics on an empty request.
+	if len(data) <= 4 {
+		return failure
+	}
+	if isSmartcardRequest(data) {
+		return processSmartcard(data)
+	}
+	if isExtensionRequest(data) {
+		return processExtension(a, data)
+	}
+
+	o := &oneShot{req: bytes.NewReader(data)}
+	agent.ServeAgent(a, o)
+	if o.rep.Len() == 0 {
+		return failure
+	}
+	return o.rep.Bytes()
+}
@@ -0,0 +1,282 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// blockingAgent is an agent.Agent whose Sign blocks until released, and
+// which records how many signatures are in progress.
+type blockingAgent struct {
+	mu        sync.Mutex
+	active    int
+	maxActive int
+	// listedWhileSigning indicates that List was invoked while a
+	// signature was in progress.
+	listedWhileSigning bool
+	release            map[string]chan bool
+}
+
+func newBlockingAgent(data []string) *blockingAgent {
+	a := &blockingAgent{release: make(map[string]chan bool)}
+	for _, d := range data {
+		a.release[d] = make(chan bool, 1)
+	}
+	return a
+}
+
+func (a *blockingAgent) Active() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.active
+}
+
+func (a *blockingAgent) List() ([]*agent.Key, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.active > 0 {
+		a.listedWhileSigning = true
+	}
+	return nil, nil
+}
+
+func (a *blockingAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	a.mu.Lock()
+	a.active++
+	if a.active > a.maxActive {
+		a.maxActive = a.active
+	}
+	release := a.release[string(data)]
+	a.mu.Unlock()
+
+	<-release
+
+	a.mu.Lock()
+	a.active--
+	a.mu.Unlock()
+	return &ssh.Signature{Format: "test", Blob: data}, nil
+}
+
+func (a *blockingAgent) Add(key agent.AddedKey) error   { return errors.New("unsupported") }
+func (a *blockingAgent) Remove(key ssh.PublicKey) error { return errors.New("unsupported") }
+func (a *blockingAgent) RemoveAll() error               { return errors.New("unsupported") }
+func (a *blockingAgent) Lock(passphrase []byte) error   { return errors.New("unsupported") }
+func (a *blockingAgent) Unlock(passphrase []byte) error { return errors.New("unsupported") }
+func (a *blockingAgent) Signers() ([]ssh.Signer, error) { return nil, errors.New("unsupported") }
+
+// conn is one end of a connection between a client and the agent.
+type conn struct {
+	io.Reader
+	io.Writer
+}
+
+// newConn returns the client and agent ends of a connection.
+func newConn() (client, server *conn) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	return &conn{Reader: cr, Writer: cw}, &conn{Reader: sr, Writer: sw}
+}
+
+// writeMessage writes a framed message to w.
+func writeMessage(w io.Writer, msg []byte) error {
+	framed := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(framed, uint32(len(msg)))
+	copy(framed[4:], msg)
+	_, err := w.Write(framed)
+	return err
+}
+
+// readMessage reads a framed message from r.
+func readMessage(r io.Reader) ([]byte, error) {
+	msg, err := readRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return msg[4:], nil
+}
+
+// signRequest returns a sign request for data.
+func signRequest(data string) []byte {
+	signer, err := ssh.ParsePrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse private key: %v", err))
+	}
+	return ssh.Marshal(struct {
+		KeyBlob []byte `sshtype:"13"`
+		Data    []byte
+		Flags   uint32
+	}{
+		KeyBlob: signer.PublicKey().Marshal(),
+		Data:    []byte(data),
+	})
+}
+
+// signedData returns the data signed in a sign response.
+func signedData(rsp []byte) (string, error) {
+	var msg struct {
+		SigBlob []byte `sshtype:"14"`
+	}
+	if err := ssh.Unmarshal(rsp, &msg); err != nil {
+		return "", fmt.Errorf("failed to parse sign response: %v", err)
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(msg.SigBlob, &sig); err != nil {
+		return "", fmt.Errorf("failed to parse signature: %v", err)
+	}
+	return string(sig.Blob), nil
+}
+
+// waitActive waits until want signatures are in progress.
+func waitActive(a *blockingAgent, want int) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Active() != want {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("got %d signatures in progress, want %d", a.Active(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+func TestServe(t *testing.T) {
+	data := []string{"data-1", "data-2", "data-3", "data-4"}
+	testcases := []struct {
+		description string
+		parallelism int
+		wantActive  int
+	}{
+		{
+			description: "serial",
+			parallelism: 1,
+			wantActive:  1,
+		},
+		{
+			description: "limited",
+			parallelism: 2,
+			wantActive:  2,
+		},
+		{
+			description: "more than requests",
+			parallelism: 8,
+			wantActive:  4,
+		},
+		{
+			description: "invalid parallelism is serial",
+			parallelism: 0,
+			wantActive:  1,
+		},
+	}
+
+	for _, tc := range testcases {
+		a := newBlockingAgent(data)
+		client, server := newConn()
+		done := make(chan error, 1)
+		go func() {
+			done <- Serve(a, server, tc.parallelism)
+		}()
+
+		// Pipeline the sign requests, followed by a request to list keys.
+		go func() {
+			for _, d := range data {
+				if err := writeMessage(client, signRequest(d)); err != nil {
+					t.Errorf("%s: failed to write sign request: %v", tc.description, err)
+				}
+			}
+			if err := writeMessage(client, []byte{11}); err != nil {
+				t.Errorf("%s: failed to write list request: %v", tc.description, err)
+			}
+		}()
+
+		// Release the signatures in reverse order once the expected
+		// number are in progress.
+		if err := waitActive(a, tc.wantActive); err != nil {
+			t.Errorf("%s: %v", tc.description, err)
+		}
+		for i := len(data) - 1; i >= 0; i-- {
+			a.release[data[i]] <- true
+		}
+
+		// Replies are in the order of the requests.
+		var got []string
+		for range data {
+			rsp, err := readMessage(client)
+			if err != nil {
+				t.Fatalf("%s: failed to read reply: %v", tc.description, err)
+			}
+			d, err := signedData(rsp)
+			if err != nil {
+				t.Errorf("%s: %v", tc.description, err)
+			}
+			got = append(got, d)
+		}
+		if diff := pretty.Diff(got, data); diff != nil {
+			t.Errorf("%s: incorrect reply order; -got +want: %s", tc.description, diff)
+		}
+		rsp, err := readMessage(client)
+		if err != nil {
+			t.Fatalf("%s: failed to read list reply: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(rsp, []byte{12, 0, 0, 0, 0}); diff != nil {
+			t.Errorf("%s: incorrect list reply; -got +want: %s", tc.description, diff)
+		}
+
+		if diff := pretty.Diff(a.maxActive, tc.wantActive); diff != nil {
+			t.Errorf("%s: incorrect maximum parallelism; -got +want: %s", tc.description, diff)
+		}
+		if a.listedWhileSigning {
+			t.Errorf("%s: keys listed while signatures were in progress", tc.description)
+		}
+
+		// Serving stops when the client disconnects.
+		client.Writer.(*io.PipeWriter).Close()
+		if err := <-done; err != io.EOF {
+			t.Errorf("%s: incorrect error when client disconnected; got %v, want %v", tc.description, err, io.EOF)
+		}
+	}
+}
+
+func TestServeInvalidRequest(t *testing.T) {
+	client, server := newConn()
+	go Serve(newBlockingAgent(nil), server, DefaultParallelism)
+
+	// Requests that cannot be parsed (including empty requests) produce a
+	// failure reply, and the connection remains usable.
+	for _, req := range [][]byte{{13, 1}, {}, {11}} {
+		if err := writeMessage(client, req); err != nil {
+			t.Fatalf("failed to write request: %v", err)
+		}
+	}
+	for _, want := range [][]byte{{agentFailure}, {agentFailure}, {12, 0, 0, 0, 0}} {
+		rsp, err := readMessage(client)
+		if err != nil {
+			t.Fatalf("failed to read reply: %v", err)
+		}
+		if diff := pretty.Diff(rsp, want); diff != nil {
+			t.Errorf("incorrect reply; -got +want: %s", diff)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/storage"
+)
+
+const (
+	// ParallelismKey is the key under which the number of sign requests
+	// processed concurrently is stored.  It is stored per-device; it is
+	// never synced.
+	ParallelismKey = "agent.parallelism"
+)
+
+// ReadParallelism returns the number of sign requests to process
+// concurrently for each connection, or DefaultParallelism if none (or an
+// invalid number) has been configured.  callback is invoked with the result.
+func ReadParallelism(store storage.Settings, callback func(parallelism int, err error)) {
+	store.GetItems([]string{ParallelismKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(DefaultParallelism, fmt.Errorf("failed to read parallelism: %v", err))
+			return
+		}
+		// Numbers are decoded from storage as float64.
+		n, _ := data[ParallelismKey].(float64)
+		if n < 1 || n > MaxParallelism {
+			callback(DefaultParallelism, nil)
+			return
+		}
+		callback(int(n), nil)
+	})
+}
+
+// WriteParallelism stores the number of sign requests to process
+// concurrently for each connection.  callback is invoked when complete.
+func WriteParallelism(store storage.Settings, parallelism int, callback func(err error)) {
+	if parallelism < 1 || parallelism > MaxParallelism {
+		callback(fmt.Errorf("parallelism must be between 1 and %d", MaxParallelism))
+		return
+	}
+	store.Set(map[string]interface{}{ParallelismKey: float64(parallelism)}, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to write parallelism: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
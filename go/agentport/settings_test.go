@@ -0,0 +1,96 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/kr/pretty"
+)
+
+func TestParallelismSetting(t *testing.T) {
+	testcases := []struct {
+		description string
+		stored      interface{}
+		want        int
+	}{
+		{
+			description: "not configured",
+			want:        DefaultParallelism,
+		},
+		{
+			description: "configured",
+			stored:      float64(8),
+			want:        8,
+		},
+		{
+			description: "too small",
+			stored:      float64(0),
+			want:        DefaultParallelism,
+		},
+		{
+			description: "too large",
+			stored:      float64(MaxParallelism + 1),
+			want:        DefaultParallelism,
+		},
+		{
+			description: "not a number",
+			stored:      "8",
+			want:        DefaultParallelism,
+		},
+	}
+
+	for _, tc := range testcases {
+		store := fakes.NewMemStorage()
+		if tc.stored != nil {
+			store.Set(map[string]interface{}{ParallelismKey: tc.stored}, func(err error) {
+				if err != nil {
+					t.Fatalf("%s: failed to store parallelism: %v", tc.description, err)
+				}
+			})
+		}
+		ReadParallelism(store, func(parallelism int, err error) {
+			if err != nil {
+				t.Errorf("%s: failed to read parallelism: %v", tc.description, err)
+			}
+			if diff := pretty.Diff(parallelism, tc.want); diff != nil {
+				t.Errorf("%s: incorrect parallelism; -got +want: %s", tc.description, diff)
+			}
+		})
+	}
+}
+
+func TestWriteParallelism(t *testing.T) {
+	store := fakes.NewMemStorage()
+	WriteParallelism(store, 2, func(err error) {
+		if err != nil {
+			t.Errorf("failed to write parallelism: %v", err)
+		}
+	})
+	WriteParallelism(store, MaxParallelism+1, func(err error) {
+		if err == nil {
+			t.Errorf("invalid parallelism unexpectedly written")
+		}
+	})
+	ReadParallelism(store, func(parallelism int, err error) {
+		if err != nil {
+			t.Errorf("failed to read parallelism: %v", err)
+		}
+		if diff := pretty.Diff(parallelism, 2); diff != nil {
+			t.Errorf("incorrect parallelism; -got +want: %s", diff)
+		}
+	})
+}
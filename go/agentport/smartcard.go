@@ -0,0 +1,77 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"log"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Message types used by clients to add and remove keys held on a
+	// smartcard (e.g., 'ssh-add -s' and 'ssh-add -e' with a PKCS#11
+	// provider).
+	agentAddSmartcardKey            = 20
+	agentRemoveSmartcardKey         = 21
+	agentAddSmartcardKeyConstrained = 26
+)
+
+// smartcardKeyMsg is a request to add or remove the keys held on a
+// smartcard.  Requests to add keys may be followed by constraints, which
+// are ignored.
+type smartcardKeyMsg struct {
+	ReaderID string `sshtype:"20|21|26"`
+	PIN      []byte
+	Rest     []byte `ssh:"rest"`
+}
+
+// isSmartcardRequest determines if the framed request data asks to add or
+// remove smartcard keys.
+func isSmartcardRequest(data []byte) bool {
+	if len(data) <= 4 {
+		return false
+	}
+	switch data[4] {
+	case agentAddSmartcardKey, agentRemoveSmartcardKey, agentAddSmartcardKeyConstrained:
+		return true
+	}
+	return false
+}
+
+// processSmartcard processes a framed request to add or remove smartcard
+// keys, and returns the framed reply.  Keys can only be used from a
+// smartcard through a PKCS#11 provider loaded into the agent's process,
+// which an extension cannot do, so such requests always fail.  They are
+// refused explicitly, rather than as unknown messages, so that clients
+// probing for smartcard support receive the failure the protocol specifies
+// and the reason is logged.
+func processSmartcard(data []byte) []byte {
+	op := "add"
+	if data[4] == agentRemoveSmartcardKey {
+		op = "remove"
+	}
+
+	var req smartcardKeyMsg
+	if err := ssh.Unmarshal(data[4:], &req); err != nil {
+		log.Printf("agent: invalid request to %s smartcard keys: %v", op, err)
+		return failure
+	}
+	for i := range req.PIN {
+		req.PIN[i] = 0
+	}
+	log.Printf("agent: refused to %s smartcard keys from %q: smartcard keys are not supported", op, req.ReaderID)
+	return failure
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"testing"
+
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestServeSmartcard(t *testing.T) {
+	add := ssh.Marshal(struct {
+		Type     byte
+		ReaderID string
+		PIN      string
+	}{agentAddSmartcardKey, "/usr/lib/opensc-pkcs11.so", "1234"})
+	addConstrained := ssh.Marshal(struct {
+		Type     byte
+		ReaderID string
+		PIN      string
+		Lifetime byte
+		Seconds  uint32
+	}{agentAddSmartcardKeyConstrained, "/usr/lib/opensc-pkcs11.so", "1234", 1, 60})
+	remove := ssh.Marshal(struct {
+		Type     byte
+		ReaderID string
+		PIN      string
+	}{agentRemoveSmartcardKey, "/usr/lib/opensc-pkcs11.so", ""})
+
+	testcases := []struct {
+		description string
+		req         []byte
+	}{
+		{
+			description: "add smartcard key",
+			req:         add,
+		},
+		{
+			description: "add smartcard key with constraints",
+			req:         addConstrained,
+		},
+		{
+			description: "remove smartcard key",
+			req:         remove,
+		},
+		{
+			description: "invalid request",
+			req:         []byte{agentAddSmartcardKey, 0, 0},
+		},
+	}
+
+	for _, tc := range testcases {
+		client, server := newConn()
+		go Serve(newBlockingAgent(nil), server, DefaultParallelism)
+
+		// The request fails, and the connection remains usable.
+		for _, req := range [][]byte{tc.req, {11}} {
+			if err := writeMessage(client, req); err != nil {
+				t.Fatalf("%s: failed to write request: %v", tc.description, err)
+			}
+		}
+		for _, want := range [][]byte{{agentFailure}, {12, 0, 0, 0, 0}} {
+			rsp, err := readMessage(client)
+			if err != nil {
+				t.Fatalf("%s: failed to read reply: %v", tc.description, err)
+			}
+			if diff := pretty.Diff(rsp, want); diff != nil {
+				t.Errorf("%s: incorrect reply; -got +want: %s", tc.description, diff)
+			}
+		}
+	}
+}
+
+func TestSmartcardPINCleared(t *testing.T) {
+	req := ssh.Marshal(struct {
+		Type     byte
+		ReaderID string
+		PIN      string
+	}{agentAddSmartcardKey, "reader", "1234"})
+	data := append([]byte{0, 0, 0, byte(len(req))}, req...)
+
+	processSmartcard(data)
+	var got smartcardKeyMsg
+	if err := ssh.Unmarshal(data[4:], &got); err != nil {
+		t.Fatalf("failed to parse request: %v", err)
+	}
+	if diff := pretty.Diff(got.PIN, []byte{0, 0, 0, 0}); diff != nil {
+		t.Errorf("PIN not cleared; -got +want: %s", diff)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package attestation produces provenance statements for keys generated by
+// the extension.  A statement records where and how a key was generated, and
+// is signed by the key itself at the time it is generated.
+//
+// The signature proves that the statement was produced by a holder of the
+// private key; it does not prove the claims made in the statement.  No
+// hardware-backed attestation key is available to the extension, so
+// administrators must trust the extension's claim that the key was generated
+// by it and that the private key has not been revealed outside of it.
+package attestation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Version is the version of the statement format.
+	Version = 1
+	// Generator identifies the software that generated the key.
+	Generator = "chrome-ssh-agent"
+)
+
+// Statement describes how a key was generated.
+type Statement struct {
+	// Version is the version of the statement format.
+	Version int `json:"version"`
+	// Generator identifies the software that generated the key.
+	Generator string `json:"generator"`
+	// ExtensionID is the ID of the extension that generated the key.
+	ExtensionID string `json:"extensionId"`
+	// Provider is the name of the provider that generated the key.
+	Provider string `json:"provider"`
+	// PublicKey is the public key, in authorized_keys format.
+	PublicKey string `json:"publicKey"`
+	// Fingerprint is the SHA256 fingerprint of the public key.
+	Fingerprint string `json:"fingerprint"`
+	// Created is the time the key was generated, in milliseconds since
+	// the Unix epoch.
+	Created int64 `json:"created"`
+	// Exported indicates that the private key has been revealed outside
+	// of the extension.  Generated keys are never displayed, so this is
+	// false when the statement is produced.
+	Exported bool `json:"exported"`
+}
+
+// Attestation is a statement signed by the key it describes.
+type Attestation struct {
+	// Statement is the base64-encoded JSON statement.
+	Statement string `json:"statement"`
+	// Format is the format of the signature (e.g., 'ecdsa-sha2-nistp256').
+	Format string `json:"format"`
+	// Signature is the base64-encoded signature over the decoded
+	// statement.
+	Signature string `json:"signature"`
+}
+
+// New returns an attestation for the key held by signer.  The public key and
+// fingerprint in stmt are populated from signer, and the statement is signed
+// using randomness from r.
+func New(stmt *Statement, signer ssh.Signer, r io.Reader) (*Attestation, error) {
+	pub := signer.PublicKey()
+	stmt.Version = Version
+	stmt.Generator = Generator
+	stmt.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	stmt.Fingerprint = ssh.FingerprintSHA256(pub)
+
+	data, err := json.Marshal(stmt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode statement: %v", err)
+	}
+	sig, err := signer.Sign(r, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign statement: %v", err)
+	}
+	return &Attestation{
+		Statement: base64.StdEncoding.EncodeToString(data),
+		Format:    sig.Format,
+		Signature: base64.StdEncoding.EncodeToString(sig.Blob),
+	}, nil
+}
+
+// Marshal returns the attestation as indented JSON.
+func (a *Attestation) Marshal() (string, error) {
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode attestation: %v", err)
+	}
+	return string(b), nil
+}
+
+// Parse parses an attestation produced by Marshal.
+func Parse(s string) (*Attestation, error) {
+	var a Attestation
+	if err := json.Unmarshal([]byte(s), &a); err != nil {
+		return nil, fmt.Errorf("failed to parse attestation: %v", err)
+	}
+	return &a, nil
+}
+
+// Verify verifies that the attestation was signed by the key described in
+// its statement, and returns the statement.
+func (a *Attestation) Verify() (*Statement, error) {
+	data, err := base64.StdEncoding.DecodeString(a.Statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode statement: %v", err)
+	}
+	blob, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %v", err)
+	}
+
+	var stmt Statement
+	if err := json.Unmarshal(data, &stmt); err != nil {
+		return nil, fmt.Errorf("failed to parse statement: %v", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(stmt.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	if stmt.Fingerprint != ssh.FingerprintSHA256(pub) {
+		return nil, errors.New("fingerprint does not match public key")
+	}
+	if err := pub.Verify(data, &ssh.Signature{Format: a.Format, Blob: blob}); err != nil {
+		return nil, fmt.Errorf("signature is invalid: %v", err)
+	}
+	return &stmt, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attestation
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(seed string) ssh.Signer {
+	_, signer, err := provider.GenerateKey(provider.NewSoftware(provider.NewDeterministicRand(seed)), "")
+	if err != nil {
+		panic(err)
+	}
+	return signer
+}
+
+func TestVerify(t *testing.T) {
+	signer := newSigner("key")
+	other := newSigner("other")
+	r := provider.NewDeterministicRand("sign")
+
+	// otherStatement replaces the statement with one describing a
+	// different key.
+	otherStatement := func(a *Attestation) {
+		o, err := New(&Statement{ExtensionID: "ext"}, other, r)
+		if err != nil {
+			panic(err)
+		}
+		a.Statement = o.Statement
+	}
+
+	testcases := []struct {
+		description string
+		modify      func(a *Attestation)
+		wantErr     error
+	}{
+		{
+			description: "valid attestation",
+		},
+		{
+			description: "statement replaced",
+			modify:      otherStatement,
+			wantErr:     errors.New("signature is invalid: ssh: signature did not verify"),
+		},
+		{
+			description: "statement modified",
+			modify: func(a *Attestation) {
+				a.Statement = base64.StdEncoding.EncodeToString([]byte(`{"publicKey": "bogus"}`))
+			},
+			wantErr: errors.New("failed to parse public key: ssh: no key found"),
+		},
+	}
+
+	for _, tc := range testcases {
+		a, err := New(&Statement{ExtensionID: "ext", Provider: provider.SoftwareName, Created: 1000}, signer, r)
+		if err != nil {
+			t.Fatalf("%s: failed to create attestation: %v", tc.description, err)
+		}
+		if tc.modify != nil {
+			tc.modify(a)
+		}
+
+		s, err := a.Marshal()
+		if err != nil {
+			t.Fatalf("%s: failed to marshal attestation: %v", tc.description, err)
+		}
+		parsed, err := Parse(s)
+		if err != nil {
+			t.Fatalf("%s: failed to parse attestation: %v", tc.description, err)
+		}
+
+		stmt, err := parsed.Verify()
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if err != nil {
+			continue
+		}
+		want := &Statement{
+			Version:     Version,
+			Generator:   Generator,
+			ExtensionID: "ext",
+			Provider:    provider.SoftwareName,
+			PublicKey:   stmt.PublicKey,
+			Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+			Created:     1000,
+		}
+		if diff := pretty.Diff(stmt, want); diff != nil {
+			t.Errorf("%s: incorrect statement; -got +want: %s", tc.description, diff)
+		}
+	}
+}
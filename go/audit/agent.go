@@ -0,0 +1,79 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/agentport"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Requesters of operations on the agent, for clients that connect to it
+// directly rather than through the bridge.
+const (
+	// NativeHostRequester identifies the native messaging host, which
+	// relays requests from local command-line clients.
+	NativeHostRequester = "native-host"
+)
+
+// NegotiateAction is the action recorded when a client connected to the
+// agent negotiates capabilities (see agentport.CapabilitiesExtension).
+const NegotiateAction = "negotiate"
+
+// ExtensionRequester returns the requester identifying another extension
+// that connected to the agent.
+func ExtensionRequester(id string) string {
+	return "chrome-extension://" + id
+}
+
+// signAuditor is an agent.Agent that records each signature request in the
+// audit log, attributed to the client that made it.
+type signAuditor struct {
+	agent.Agent
+	log       *Log
+	requester string
+}
+
+// NewAgent returns an agent.Agent that performs operations using a, and
+// records each signature request in log as made by requester.  A separate
+// Agent should be used for each client connected to the agent.
+func NewAgent(a agent.Agent, log *Log, requester string) agent.Agent {
+	return &signAuditor{
+		Agent:     a,
+		log:       log,
+		requester: requester,
+	}
+}
+
+// Sign implements agent.Agent.Sign.
+func (s *signAuditor) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	sig, err := s.Agent.Sign(key, data)
+	fp := ssh.FingerprintSHA256(key)
+	if err != nil {
+		s.log.Record(NewEntry("sign", s.requester, fp, false, fmt.Sprintf("failed to sign: %v", err)), nil)
+		return nil, err
+	}
+	s.log.Record(NewEntry("sign", s.requester, fp, true, "signed"), nil)
+	return sig, nil
+}
+
+// NegotiatedCapabilities implements agentport.CapabilitiesObserver.  The
+// negotiated capabilities are recorded, so that diagnostics can show which
+// features each client uses.
+func (s *signAuditor) NegotiatedCapabilities(version uint32, features []string) {
+	s.log.Record(NewEntry(NegotiateAction, s.requester, "", true, agentport.DescribeCapabilities(version, features)), nil)
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/agentport"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// entrySummary summarizes an entry for comparison.
+type entrySummary struct {
+	Action    string
+	Requester string
+	Key       string
+	Allowed   bool
+	Detail    string
+}
+
+func TestAgent(t *testing.T) {
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		t.Fatalf("failed to parse private key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	pub := signer.PublicKey()
+
+	testcases := []struct {
+		description string
+		load        bool
+		wantErr     error
+		wantEntry   *entrySummary
+	}{
+		{
+			description: "record signature",
+			load:        true,
+			wantEntry: &entrySummary{
+				Action:    "sign",
+				Requester: ExtensionRequester("some-id"),
+				Key:       ssh.FingerprintSHA256(pub),
+				Allowed:   true,
+				Detail:    "signed",
+			},
+		},
+		{
+			description: "record failure",
+			wantErr:     errors.New("not found"),
+			wantEntry: &entrySummary{
+				Action:    "sign",
+				Requester: ExtensionRequester("some-id"),
+				Key:       ssh.FingerprintSHA256(pub),
+				Detail:    "failed to sign: not found",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		keyring := agent.NewKeyring()
+		if tc.load {
+			if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+				t.Fatalf("%s: failed to load key: %v", tc.description, err)
+			}
+		}
+		log := NewLog(fakes.NewMemStorage(), 10)
+		var got *Entry
+		log.Subscribe(func(e *Entry) { got = e })
+
+		a := NewAgent(keyring, log, ExtensionRequester("some-id"))
+		_, err := a.Sign(pub, []byte("some-data"))
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if got == nil {
+			t.Errorf("%s: no entry recorded", tc.description)
+			continue
+		}
+		summary := &entrySummary{
+			Action:    got.Action,
+			Requester: got.Requester,
+			Key:       got.Key,
+			Allowed:   got.Allowed,
+			Detail:    got.Detail,
+		}
+		if diff := pretty.Diff(summary, tc.wantEntry); diff != nil {
+			t.Errorf("%s: incorrect entry; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestAgentNegotiatedCapabilities(t *testing.T) {
+	log := NewLog(fakes.NewMemStorage(), 10)
+	var got *Entry
+	log.Subscribe(func(e *Entry) { got = e })
+
+	a := NewAgent(agent.NewKeyring(), log, NativeHostRequester)
+	o, ok := a.(agentport.CapabilitiesObserver)
+	if !ok {
+		t.Fatalf("agent does not observe negotiated capabilities")
+	}
+	o.NegotiatedCapabilities(1, []string{agentport.FeatureCertificates})
+	if got == nil {
+		t.Fatalf("no entry recorded")
+	}
+	summary := &entrySummary{
+		Action:    got.Action,
+		Requester: got.Requester,
+		Key:       got.Key,
+		Allowed:   got.Allowed,
+		Detail:    got.Detail,
+	}
+	want := &entrySummary{
+		Action:    NegotiateAction,
+		Requester: NativeHostRequester,
+		Allowed:   true,
+		Detail:    "protocol version 1; features: certificates",
+	}
+	if diff := pretty.Diff(summary, want); diff != nil {
+		t.Errorf("incorrect entry; -got +want: %s", diff)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit records security-relevant events (e.g., requests for
+// signatures) so that they can be reviewed later.
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/redact"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// Entry is a single event recorded in the audit log.
+type Entry struct {
+	*js.Object
+	// Time is the time at which the event occurred, in milliseconds since
+	// the Unix epoch.
+	Time int64 `js:"time"`
+	// Action describes the event (e.g., 'sign').
+	Action string `js:"action"`
+	// Requester identifies who requested the action (e.g., a web origin,
+	// or the extension connected to the agent).
+	Requester string `js:"requester"`
+	// Page is the URL (without query or fragment) of the page from which
+	// the request was made, if known.
+	Page string `js:"page"`
+	// Key identifies the key involved, if any.
+	Key string `js:"key"`
+	// Allowed indicates if the action was permitted.
+	Allowed bool `js:"allowed"`
+	// Detail is a human-readable description of the outcome.
+	Detail string `js:"detail"`
+}
+
+// NewEntry returns a new entry for an event that occurred at the current
+// time.  Sensitive material (e.g., a private key quoted in an error) is
+// redacted from detail.
+func NewEntry(action, requester, key string, allowed bool, detail string) *Entry {
+	e := &Entry{Object: js.Global.Get("Object").New()}
+	e.Time = time.Now().UnixNano() / int64(time.Millisecond)
+	e.Action = action
+	e.Requester = requester
+	e.Key = key
+	e.Allowed = allowed
+	e.Detail = redact.String(detail)
+	return e
+}
+
+const (
+	// storageKey is the key under which the log is stored.
+	storageKey = "audit"
+	// DefaultSize is the number of entries retained in the audit log.
+	DefaultSize = 1000
+)
+
+// Log is an audit log kept in persistent storage.  Only the most recent
+// entries are retained.
+type Log struct {
+	store storage.Store
+	max   int
+	// queue contains entries waiting to be written.
+	queue []*pendingEntry
+	// writing indicates that entries are currently being written.
+	writing bool
+	// subscribers are invoked with each entry as it is recorded.
+	subscribers []func(e *Entry)
+}
+
+// pendingEntry is an entry waiting to be written to storage.
+type pendingEntry struct {
+	entry    *Entry
+	callback func(err error)
+}
+
+// NewLog returns a Log that stores up to max entries in the supplied storage.
+func NewLog(store storage.Store, max int) *Log {
+	return &Log{
+		store: store,
+		max:   max,
+	}
+}
+
+// parseEntries returns the entries contained in data read from storage.
+func parseEntries(data map[string]interface{}) []*Entry {
+	raw, _ := data[storageKey].([]interface{})
+	var result []*Entry
+	for _, r := range raw {
+		m, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		o := js.Global.Get("Object").New()
+		for k, v := range m {
+			o.Set(k, v)
+		}
+		result = append(result, &Entry{Object: o})
+	}
+	return result
+}
+
+// Entries returns the entries in the log, oldest first. callback is invoked
+// with the result.
+func (l *Log) Entries(callback func(entries []*Entry, err error)) {
+	l.store.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read audit log: %v", err))
+			return
+		}
+		callback(parseEntries(data), nil)
+	})
+}
+
+// Subscribe registers a callback that is invoked with each entry as it is
+// recorded, before it is written to storage.
+func (l *Log) Subscribe(callback func(e *Entry)) {
+	l.subscribers = append(l.subscribers, callback)
+}
+
+// Record adds an entry to the log. callback is invoked when complete; it may
+// be nil if the caller is not interested in the result.
+//
+// Concurrent calls are serialized so that no entries are lost.
+func (l *Log) Record(e *Entry, callback func(err error)) {
+	if callback == nil {
+		callback = func(err error) {}
+	}
+	for _, sub := range l.subscribers {
+		sub(e)
+	}
+	l.queue = append(l.queue, &pendingEntry{entry: e, callback: callback})
+	if !l.writing {
+		l.flush()
+	}
+}
+
+// flush writes all queued entries to storage.
+func (l *Log) flush() {
+	if len(l.queue) == 0 {
+		l.writing = false
+		return
+	}
+	l.writing = true
+	batch := l.queue
+	l.queue = nil
+
+	done := func(err error) {
+		for _, p := range batch {
+			p.callback(err)
+		}
+		l.flush()
+	}
+
+	l.store.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			done(fmt.Errorf("failed to read audit log: %v", err))
+			return
+		}
+
+		entries := parseEntries(data)
+		for _, p := range batch {
+			entries = append(entries, p.entry)
+		}
+		if len(entries) > l.max {
+			entries = entries[len(entries)-l.max:]
+		}
+
+		l.store.Set(map[string]interface{}{storageKey: entries}, func(err error) {
+			if err != nil {
+				done(fmt.Errorf("failed to write audit log: %v", err))
+				return
+			}
+			done(nil)
+		})
+	})
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authorizedkeys generates entries for OpenSSH authorized_keys files,
+// along with shell commands that install them on a server.
+//
+// See the AUTHORIZED_KEYS FILE FORMAT section of sshd(8) for details on the
+// file format.
+package authorizedkeys
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Restrictions limit how a key may be used when logging into a server.
+type Restrictions struct {
+	// From lists the patterns (e.g., '192.168.1.0/24' or '*.example.com')
+	// matching the source addresses from which the key may be used. If
+	// empty, the key may be used from any address.
+	From []string
+	// Command is a command executed whenever the key is used, in place of
+	// any command requested by the client.  If empty, the client's command
+	// is executed.
+	Command string
+	// ExpiryTime is the time after which the key may no longer be used.
+	// If zero, the key does not expire.  sshd interprets the time in the
+	// server's local time zone.
+	ExpiryTime time.Time
+	// NoAgentForwarding disables forwarding of the SSH agent.
+	NoAgentForwarding bool
+	// NoPortForwarding disables TCP port forwarding.
+	NoPortForwarding bool
+	// NoX11Forwarding disables X11 forwarding.
+	NoX11Forwarding bool
+	// NoPTY disables allocation of a terminal.
+	NoPTY bool
+	// PermitOpen lists the destinations (in 'host:port' form) to which
+	// local port forwarding is permitted.  If empty, forwarding is not
+	// limited to specific destinations.
+	PermitOpen []string
+}
+
+// ParseList splits a comma- or whitespace-separated list (e.g., of source
+// address patterns), as entered by the user.
+func ParseList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// ParseExpiry parses an expiry date (in YYYY-MM-DD form, as produced by an
+// HTML date input).  The key expires at the start of the specified day.  The
+// zero time is returned for an empty string.
+func ParseExpiry(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry date %q", s)
+	}
+	return t, nil
+}
+
+// expiryFormat is the format of the expiry-time option.
+const expiryFormat = "200601021504"
+
+// quoteOption returns an option value enclosed in double quotes, as required
+// by the authorized_keys format.
+func quoteOption(v string) string {
+	return `"` + strings.Replace(v, `"`, `\"`, -1) + `"`
+}
+
+// Options returns the option string corresponding to the restrictions, in
+// the form expected at the start of an authorized_keys entry.  The empty
+// string is returned if there are no restrictions.
+func (r *Restrictions) Options() (string, error) {
+	if r == nil {
+		return "", nil
+	}
+
+	var opts []string
+	if len(r.From) > 0 {
+		for _, f := range r.From {
+			if f == "" || strings.ContainsAny(f, "\", \t\n") {
+				return "", fmt.Errorf("invalid source address pattern %q", f)
+			}
+		}
+		opts = append(opts, "from="+quoteOption(strings.Join(r.From, ",")))
+	}
+	if r.Command != "" {
+		if strings.ContainsAny(r.Command, "\n") {
+			return "", fmt.Errorf("command must not contain newlines")
+		}
+		opts = append(opts, "command="+quoteOption(r.Command))
+	}
+	if !r.ExpiryTime.IsZero() {
+		opts = append(opts, "expiry-time="+quoteOption(r.ExpiryTime.Format(expiryFormat)))
+	}
+	if r.NoAgentForwarding {
+		opts = append(opts, "no-agent-forwarding")
+	}
+	if r.NoPortForwarding {
+		opts = append(opts, "no-port-forwarding")
+	}
+	if r.NoX11Forwarding {
+		opts = append(opts, "no-X11-forwarding")
+	}
+	if r.NoPTY {
+		opts = append(opts, "no-pty")
+	}
+	for _, p := range r.PermitOpen {
+		i := strings.LastIndex(p, ":")
+		if i <= 0 || i == len(p)-1 || strings.ContainsAny(p, "\", \t\n") {
+			return "", fmt.Errorf("invalid permitted destination %q; must be host:port", p)
+		}
+		opts = append(opts, "permitopen="+quoteOption(p))
+	}
+	return strings.Join(opts, ","), nil
+}
+
+// Line returns the authorized_keys entry for the specified public key. The
+// supplied comment (typically the name of the key) is appended to the entry,
+// and the entry is prefixed with any restrictions.
+func Line(pub ssh.PublicKey, comment string, r *Restrictions) (string, error) {
+	opts, err := r.Options()
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	if opts != "" {
+		parts = append(parts, opts)
+	}
+	parts = append(parts, pub.Type(), base64.StdEncoding.EncodeToString(pub.Marshal()))
+	if c := strings.TrimSpace(comment); c != "" {
+		parts = append(parts, strings.Replace(c, "\n", " ", -1))
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// shellQuote returns s quoted such that it is interpreted literally by a
+// POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+const (
+	// authorizedKeysPath is the location of the authorized_keys file on
+	// the server.
+	authorizedKeysPath = "~/.ssh/authorized_keys"
+)
+
+// InstallCommand returns a shell command that appends the supplied entry to
+// the authorized_keys file on the server.  If oneLiner is true, the command
+// also creates the ~/.ssh directory if needed and sets the permissions
+// required by sshd, so that it can be pasted into a fresh account.
+func InstallCommand(line string, oneLiner bool) string {
+	appendCmd := fmt.Sprintf("echo %s >> %s", shellQuote(line), authorizedKeysPath)
+	if !oneLiner {
+		return appendCmd
+	}
+	return strings.Join([]string{
+		"mkdir -p ~/.ssh",
+		"chmod 700 ~/.ssh",
+		appendCmd,
+		"chmod 600 " + authorizedKeysPath,
+	}, " && ")
+}
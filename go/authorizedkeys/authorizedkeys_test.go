@@ -0,0 +1,225 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authorizedkeys
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+func testPublicKey(t *testing.T) ssh.PublicKey {
+	b, err := base64.StdEncoding.DecodeString(testdata.ValidPrivateKeyBlob)
+	if err != nil {
+		t.Fatalf("failed to decode blob: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+	return pub
+}
+
+func TestParseExpiry(t *testing.T) {
+	testcases := []struct {
+		description string
+		in          string
+		want        time.Time
+		wantErr     error
+	}{
+		{
+			description: "empty",
+			in:          "",
+		},
+		{
+			description: "date",
+			in:          "2019-03-04",
+			want:        time.Date(2019, 3, 4, 0, 0, 0, 0, time.Local),
+		},
+		{
+			description: "invalid",
+			in:          "next week",
+			wantErr:     errors.New(`invalid expiry date "next week"`),
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := ParseExpiry(tc.in)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("%s: incorrect expiry; got %v, want %v", tc.description, got, tc.want)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	testcases := []struct {
+		description string
+		in          string
+		want        []string
+	}{
+		{
+			description: "empty",
+			in:          "  ",
+		},
+		{
+			description: "comma-separated",
+			in:          "10.0.0.0/8,*.example.com",
+			want:        []string{"10.0.0.0/8", "*.example.com"},
+		},
+		{
+			description: "mixed separators",
+			in:          "10.0.0.1, 10.0.0.2\t!10.0.0.3",
+			want:        []string{"10.0.0.1", "10.0.0.2", "!10.0.0.3"},
+		},
+	}
+
+	for _, tc := range testcases {
+		if diff := pretty.Diff(ParseList(tc.in), tc.want); diff != nil {
+			t.Errorf("%s: incorrect patterns; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestLine(t *testing.T) {
+	pub := testPublicKey(t)
+	key := "ssh-rsa " + testdata.ValidPrivateKeyBlob
+
+	testcases := []struct {
+		description  string
+		comment      string
+		restrictions *Restrictions
+		want         string
+		wantErr      error
+	}{
+		{
+			description: "no restrictions",
+			comment:     "my-key",
+			want:        key + " my-key",
+		},
+		{
+			description: "no comment",
+			want:        key,
+		},
+		{
+			description:  "source addresses",
+			comment:      "my-key",
+			restrictions: &Restrictions{From: []string{"10.0.0.0/8", "*.example.com"}},
+			want:         `from="10.0.0.0/8,*.example.com" ` + key + " my-key",
+		},
+		{
+			description:  "forced command",
+			comment:      "my-key",
+			restrictions: &Restrictions{Command: `echo "hi"`},
+			want:         `command="echo \"hi\"" ` + key + " my-key",
+		},
+		{
+			description: "all restrictions",
+			comment:     "my-key",
+			restrictions: &Restrictions{
+				From:    []string{"10.0.0.1"},
+				Command: "/usr/bin/backup",
+			},
+			want: `from="10.0.0.1",command="/usr/bin/backup" ` + key + " my-key",
+		},
+		{
+			description: "forwarding restrictions",
+			comment:     "my-key",
+			restrictions: &Restrictions{
+				NoAgentForwarding: true,
+				NoPortForwarding:  true,
+				NoX11Forwarding:   true,
+				NoPTY:             true,
+			},
+			want: "no-agent-forwarding,no-port-forwarding,no-X11-forwarding,no-pty " + key + " my-key",
+		},
+		{
+			description:  "permitted destinations",
+			comment:      "my-key",
+			restrictions: &Restrictions{PermitOpen: []string{"localhost:8080", "[::1]:22"}},
+			want:         `permitopen="localhost:8080",permitopen="[::1]:22" ` + key + " my-key",
+		},
+		{
+			description:  "expiry time",
+			comment:      "my-key",
+			restrictions: &Restrictions{ExpiryTime: time.Date(2019, 3, 4, 5, 6, 0, 0, time.Local)},
+			want:         `expiry-time="201903040506" ` + key + " my-key",
+		},
+		{
+			description:  "invalid permitted destination",
+			restrictions: &Restrictions{PermitOpen: []string{"localhost"}},
+			wantErr:      errors.New(`invalid permitted destination "localhost"; must be host:port`),
+		},
+		{
+			description:  "invalid source address",
+			restrictions: &Restrictions{From: []string{`bad"pattern`}},
+			wantErr:      errors.New(`invalid source address pattern "bad\"pattern"`),
+		},
+		{
+			description:  "invalid command",
+			restrictions: &Restrictions{Command: "a\nb"},
+			wantErr:      errors.New("command must not contain newlines"),
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := Line(pub, tc.comment, tc.restrictions)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if got != tc.want {
+			t.Errorf("%s: incorrect line; got %q, want %q", tc.description, got, tc.want)
+		}
+	}
+}
+
+func TestInstallCommand(t *testing.T) {
+	testcases := []struct {
+		description string
+		line        string
+		oneLiner    bool
+		want        string
+	}{
+		{
+			description: "append only",
+			line:        "ssh-rsa AAAA my-key",
+			want:        "echo 'ssh-rsa AAAA my-key' >> ~/.ssh/authorized_keys",
+		},
+		{
+			description: "one-liner",
+			line:        "ssh-rsa AAAA my-key",
+			oneLiner:    true,
+			want:        "mkdir -p ~/.ssh && chmod 700 ~/.ssh && echo 'ssh-rsa AAAA my-key' >> ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys",
+		},
+		{
+			description: "quote single quotes",
+			line:        "ssh-rsa AAAA bob's key",
+			want:        `echo 'ssh-rsa AAAA bob'\''s key' >> ~/.ssh/authorized_keys`,
+		},
+	}
+
+	for _, tc := range testcases {
+		if got := InstallCommand(tc.line, tc.oneLiner); got != tc.want {
+			t.Errorf("%s: incorrect command; got %q, want %q", tc.description, got, tc.want)
+		}
+	}
+}
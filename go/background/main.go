@@ -15,29 +15,577 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"time"
 
+	"github.com/google/chrome-ssh-agent/go/agenthooks"
 	"github.com/google/chrome-ssh-agent/go/agentport"
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/bridge"
 	"github.com/google/chrome-ssh-agent/go/chrome"
+	"github.com/google/chrome-ssh-agent/go/entropy"
+	"github.com/google/chrome-ssh-agent/go/external"
+	"github.com/google/chrome-ssh-agent/go/faults"
+	"github.com/google/chrome-ssh-agent/go/keyring"
 	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/nativemsg"
+	"github.com/google/chrome-ssh-agent/go/netclient"
+	"github.com/google/chrome-ssh-agent/go/notify"
+	"github.com/google/chrome-ssh-agent/go/permissions"
+	"github.com/google/chrome-ssh-agent/go/popup"
+	"github.com/google/chrome-ssh-agent/go/presence"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/google/chrome-ssh-agent/go/provisioning"
+	"github.com/google/chrome-ssh-agent/go/redact"
+	"github.com/google/chrome-ssh-agent/go/remote"
+	"github.com/google/chrome-ssh-agent/go/replay"
+	"github.com/google/chrome-ssh-agent/go/rsaaccel"
+	"github.com/google/chrome-ssh-agent/go/selfcheck"
+	"github.com/google/chrome-ssh-agent/go/toolbar"
+	"github.com/google/chrome-ssh-agent/go/transport"
 
 	"github.com/gopherjs/gopherjs/js"
-	"golang.org/x/crypto/ssh/agent"
 )
 
+const (
+	// quickLockCommand is the name of the command, declared in the
+	// manifest, whose keyboard shortcut unloads all keys.
+	quickLockCommand = "quick-lock"
+	// scheduleAlarm is the name of the alarm on which keys are loaded
+	// and unloaded according to their schedules.
+	scheduleAlarm = "key-schedule"
+)
+
+// deviceName returns a human-readable name for this device, which is recorded
+// with the keys configured on it.
+func deviceName() string {
+	return fmt.Sprintf("Chrome on %s", js.Global.Get("navigator").Get("platform").String())
+}
+
 func main() {
+	// Keep private keys and other sensitive material out of the console,
+	// whatever the errors being logged happen to quote.
+	log.SetOutput(redact.NewWriter(os.Stderr))
 
-	// Create a keyring with loaded keys.
-	a := agent.NewKeyring()
+	// Create a keyring with loaded keys. Clients listing keys always see a
+	// consistent snapshot of the keyring.
+	a := keyring.New()
+	// Sign with RSA keys using the browser's native BigInt where
+	// available; math/big is slow when transpiled to Javascript.
+	if exp := rsaaccel.BigIntExp(); exp != nil {
+		a.SetRSAExp(exp)
+	}
 
 	// Create a wrapper that can update the loaded keys. Exposed the
 	// wrapper so it can be used by other pages in the extension.
 	c := chrome.New(nil)
-	mgr := keys.NewManager(a, c.SyncStorage())
+
+	// During development, storage may be made slow and unreliable, and
+	// the background page restarted at random (see the faults package).
+	// Faults are never injected unless configured.
+	injector := faults.New()
+	injector.Load(c.LocalStorage(), func(err error) {
+		if err != nil {
+			log.Printf("Failed to configure fault injection: %v", err)
+		}
+	})
+	c.LocalStorage().OnChanged(func(changes map[string]interface{}) {
+		if faults.Changed(changes) {
+			injector.Load(c.LocalStorage(), nil)
+		}
+	})
+	injector.OnRestart(func() {
+		js.Global.Get("location").Call("reload")
+	})
+	syncStorage := injector.Wrap(c.SyncStorage(), "sync")
+	localStorage := injector.Wrap(c.LocalStorage(), "local")
+
+	// Display the number of loaded keys, whether the agent is locked,
+	// and alerts on the toolbar icon.
+	status := toolbar.New(c)
+	a.Subscribe(status.KeyringChanged)
+	toolbar.Serve(status, c)
+
+	// Deliver notifications through the channel selected in settings,
+	// and switch channels whenever the setting changes.
+	notifier := notify.NewSelector(map[notify.Kind]notify.Notifier{
+		notify.System: notify.NewSystem(c),
+		notify.Toast:  notify.NewToast(c),
+		notify.Badge:  notify.NewBadge(status),
+		notify.None:   notify.NewNone(),
+	})
+	reloadNotifier := func() {
+		notifier.Reload(localStorage, func(err error) {
+			if err != nil {
+				log.Printf("Failed to select notification channel: %v", err)
+			}
+		})
+	}
+	reloadNotifier()
+	c.LocalStorage().OnChanged(func(changes map[string]interface{}) {
+		if _, ok := changes[notify.SettingKey]; ok {
+			reloadNotifier()
+		}
+	})
+
+	// Check that random numbers can be generated, so that a slow or
+	// broken random number generator is reported before keys are added.
+	go func() {
+		if err := entropy.Reader.Check(); err != nil {
+			log.Printf("Random number generator check failed: %v", err)
+			notifier.Notify("Random numbers unavailable", fmt.Sprintf("Keys and key IDs cannot be generated: %v", err))
+		}
+	}()
+
+	auditLog := audit.NewLog(localStorage, audit.DefaultSize)
+	auditLog.Subscribe(status.Audited)
+
+	// Refuse signatures using canary keys, and raise the alarm when a
+	// client asks for one.
+	canaries := keys.NewCanaryGuard(func(id keys.ID, name string) {
+		auditLog.Record(audit.NewEntry("sign", "agent", string(id), false, fmt.Sprintf("refused signature using canary key %q", name)), nil)
+		notifier.Notify("Canary key used", fmt.Sprintf("A client asked to sign using the canary key %q. The client, or a host to which the agent was forwarded, may be compromised.", name))
+	})
+
+	// Ask the user for confirmation codes and passphrases in a popup
+	// window, so that they can respond even if no extension page is
+	// open.
+	prompter := popup.New(c, popup.Page, popup.Width, popup.Height)
+
+	// Hold signatures using keys configured with an authenticator secret
+	// until the user enters a code.
+	confirmations := keys.NewTOTPGuard(keys.DefaultTOTPTimeout, func(ch *keys.TOTPChallenge) {
+		notifier.Notify("Confirmation code required", fmt.Sprintf("A client is requesting a signature using the key %q. Enter the code from your authenticator app in the window that opened to allow it.", ch.Name))
+		prompter.Show()
+	})
+	keys.ServeTOTP(confirmations, c)
+
+	// Hold signatures using remote keys until the user allows them,
+	// since the data to be signed is sent to the signing service, and
+	// likewise those using keys added with the confirm constraint.
+	approvals := keys.NewConfirmGuard(keys.DefaultConfirmTimeout, func(r *keys.ConfirmRequest) {
+		notifier.Notify("Signature requires approval", fmt.Sprintf("A client is requesting a signature using the key %q. Allow or deny it in the window that opened.", r.Name))
+		prompter.Show()
+	})
+	keys.ServeConfirm(approvals, c)
+
+	// Hold keys loaded on demand until the user enters their passphrase.
+	passphrases := keys.NewPassphrasePrompts(keys.DefaultPassphraseTimeout, func(r *keys.PassphraseRequest) {
+		prompter.Show()
+	})
+	keys.ServePassphrasePrompts(passphrases, c)
+
+	// Everything other than the toolbar uses the keyring through hooks,
+	// so that subsystems can observe and refuse operations on it.
+	hooked := agenthooks.New(a)
+	hooked.Install(canaries.Hooks())
+	hooked.Install(confirmations.Hooks())
+	hooked.Install(approvals.Hooks())
+
+	// Track the state of each key as it is loaded and unloaded.
+	lifecycle := keys.NewLifecycle()
+	lifecycle.Subscribe(func(e *keys.Event) {
+		log.Printf("Key %s moved from %s to %s", e.ID, e.From, e.To)
+	})
+
+	// Requests to the network are retried if they fail transiently, and
+	// wait while the browser is offline. Signature requests wait no
+	// longer than the remote provider does.
+	fetcher := netclient.New(c)
+	signer := netclient.New(c, netclient.WithQueue(netclient.DefaultQueueLimit, remote.DefaultTimeout))
+	for _, n := range []*netclient.Client{fetcher, signer} {
+		n.SetOnline(c.Online())
+		c.OnOnlineChanged(n.SetOnline)
+	}
+
+	// Keys held by a remote signing service are loaded by their own
+	// provider, which sends each signature request to the service.
+	providers := provider.NewRegistry(provider.NewSoftware(nil))
+	providers.Register(remote.NewProvider(signer, remote.DefaultTimeout))
+
+	storage := keys.NewSyncMerger(syncStorage, keys.MergeKeepBoth)
+	prov := provisioning.New(localStorage, fetcher, auditLog)
+	mgr := keys.NewManager(hooked, storage, localStorage,
+		keys.WithDeviceName(deviceName()),
+		keys.WithExtensionID(c.ExtensionID()),
+		keys.WithAuditLog(auditLog),
+		keys.WithLoadPolicy(prov.Allowed),
+		keys.WithCanaryGuard(canaries),
+		keys.WithTOTPGuard(confirmations),
+		keys.WithConfirmGuard(approvals),
+		keys.WithProviders(providers),
+		keys.WithLifecycle(lifecycle),
+		keys.WithExpiries(a),
+		keys.WithRelabeler(a),
+		keys.WithRetryPolicy(keys.DefaultRetryPolicy),
+		keys.WithThrottlePolicy(keys.DefaultThrottlePolicy),
+		keys.WithVaultKDF(c.DeriveKey))
 	keys.NewServer(mgr, c)
 
-	c.OnConnectExternal(func(port *js.Object) {
-		log.Printf("Starting agent for new port")
-		go agent.ServeAgent(a, agentport.New(port))
+	// Clients connected to the agent may ask to sign using a configured
+	// key that is not loaded; if enabled in settings, it is loaded on
+	// demand, asking the user for its passphrase if it has one.
+	onDemand := keys.NewOnDemandAgent(hooked, mgr, localStorage, keys.WithPassphrasePrompts(passphrases))
+
+	// Allow the options page to wipe all data (e.g., before handing the
+	// device to someone else).  Storage is cleared directly rather than
+	// through the merger, so that every item is deleted.
+	keys.ServeWipe(keys.NewWiper(mgr, hooked, syncStorage, localStorage), c)
+
+	// Periodically unload keys whose configured key no longer exists,
+	// such as after a removal synced from another device.
+	keys.NewReconciler(mgr, lifecycle, keys.DefaultReconcilePolicy).Start(func(orphans []*keys.LoadedKey, err error) {
+		for _, o := range orphans {
+			log.Printf("Found orphaned key %s", o.ID())
+		}
+		if err != nil {
+			log.Printf("Failed to unload orphaned keys: %v", err)
+		}
+	})
+
+	// Share the keys loaded on this device with the user's other
+	// devices, if enabled, and unload keys when another device asks.
+	// Updates run separately from keyring changes, since they may
+	// themselves unload keys.
+	publisher := presence.NewPublisher(presence.New(syncStorage, localStorage), mgr, func(unloaded []*keys.LoadedKey, err error) {
+		for _, l := range unloaded {
+			auditLog.Record(audit.NewEntry("remote-unload", "sync", string(l.ID()), true, "unloaded at the request of another device"), nil)
+		}
+		if len(unloaded) > 0 {
+			notifier.Notify("Keys unloaded", fmt.Sprintf("Unloaded %d keys at the request of another of your devices.", len(unloaded)))
+		}
+		if err != nil {
+			log.Printf("Failed to share loaded keys: %v", err)
+		}
+	})
+	a.Subscribe(func(s *keyring.State) {
+		go publisher.Update()
+	})
+	updatePresence := func(changes map[string]interface{}) {
+		if presence.Changed(changes) {
+			publisher.Update()
+		}
+	}
+	c.SyncStorage().OnChanged(updatePresence)
+	c.LocalStorage().OnChanged(updatePresence)
+	publisher.Update()
+
+	// Unload all keys at once and lock the vault when the quick-lock
+	// shortcut is pressed, and confirm that they are gone.
+	c.OnCommand(func(command string) {
+		if command != quickLockCommand {
+			return
+		}
+		keys.QuickLock(mgr, func(result *keys.Result, err error) {
+			if err != nil {
+				log.Printf("Quick lock failed: %v", err)
+				auditLog.Record(audit.NewEntry("quick-lock", "shortcut", "", false, err.Error()), nil)
+				notifier.Notify("Quick lock failed", fmt.Sprintf("Some keys may still be loaded, or the vault unlocked: %v", err))
+				return
+			}
+			auditLog.Record(audit.NewEntry("quick-lock", "shortcut", "", true, fmt.Sprintf("unloaded %d keys", result.Succeeded)), nil)
+			notifier.Notify("Keys locked", fmt.Sprintf("Unloaded %d keys and locked the vault; no keys are loaded in the agent.", result.Succeeded))
+		})
+	})
+
+	// Lock the agent when the machine is locked or left idle, as chosen
+	// in the options.  Chrome reports the machine as idle once it has
+	// gone unused for the period in the auto-lock policy.
+	applyAutoLockPolicy := func() {
+		mgr.AutoLockPolicy(func(policy *keys.AutoLockPolicy, err error) {
+			if err != nil {
+				log.Printf("Failed to read auto-lock policy: %v", err)
+				return
+			}
+			if policy.Idle > 0 {
+				c.SetIdleDetectionInterval(policy.Idle)
+			}
+		})
+	}
+	applyAutoLockPolicy()
+	c.LocalStorage().OnChanged(func(changes map[string]interface{}) {
+		if keys.AutoLockPolicyChanged(changes) {
+			applyAutoLockPolicy()
+		}
+	})
+	c.OnIdleStateChanged(func(state string) {
+		keys.AutoLock(mgr, keys.IdleState(state), func(result *keys.Result, err error) {
+			if result == nil && err == nil {
+				// The policy does not lock the agent in this
+				// state.
+				return
+			}
+			if err == nil {
+				err = result.Err()
+			}
+			if err != nil {
+				log.Printf("Auto-lock failed: %v", err)
+				auditLog.Record(audit.NewEntry("auto-lock", "idle", "", false, err.Error()), nil)
+				notifier.Notify("Auto-lock failed", fmt.Sprintf("Some keys may still be loaded: %v", err))
+				return
+			}
+			auditLog.Record(audit.NewEntry("auto-lock", "idle", "", true, fmt.Sprintf("unloaded %d keys when the machine was %s", result.Succeeded, state)), nil)
+		})
+	})
+
+	// Provision keys as configured by an administrator, both at startup
+	// and whenever the policy changes.
+	provision := func() {
+		provisioning.ReadPolicy(c.ManagedStorage(), func(policy *provisioning.Policy, err error) {
+			if err != nil {
+				log.Printf("Failed to read provisioning policy: %v", err)
+				return
+			}
+			if policy == nil {
+				return
+			}
+			prov.Sync(mgr, policy, func(result *keys.Result, err error) {
+				if err == nil {
+					err = result.Err()
+				}
+				if err != nil {
+					log.Printf("Failed to provision keys: %v", err)
+					notifier.Notify("Failed to provision keys", err.Error())
+					return
+				}
+				log.Printf("Provisioned keys: %s", result.Summary())
+			})
+		})
+	}
+	provision()
+	c.ManagedStorage().OnChanged(func(changes map[string]interface{}) {
+		provision()
+	})
+
+	// Unload keys revoked on another device as soon as the revocation
+	// is synced to this one.
+	enforceRevocations := func() {
+		keys.EnforceRevocations(mgr, func(unloaded []*keys.LoadedKey, err error) {
+			for _, l := range unloaded {
+				auditLog.Record(audit.NewEntry("revoke", "sync", string(l.ID()), true, fmt.Sprintf("unloaded revoked key %q", l.Nickname())), nil)
+			}
+			if len(unloaded) > 0 {
+				notifier.Notify("Revoked keys unloaded", fmt.Sprintf("Unloaded %d keys revoked on another of your devices.", len(unloaded)))
+			}
+			if err != nil {
+				log.Printf("Failed to unload revoked keys: %v", err)
+			}
+		})
+	}
+
+	// Reconcile keys that are delivered by Chrome Sync from other devices.
+	c.SyncStorage().OnChanged(func(changes map[string]interface{}) {
+		storage.OnChanged(changes, func(conflicts []*keys.Conflict, err error) {
+			if keys.KeysChanged(changes) {
+				enforceRevocations()
+			}
+			if err != nil {
+				log.Printf("Failed to merge synced keys: %v", err)
+				return
+			}
+			for _, c := range conflicts {
+				log.Printf("Resolved conflict between local key %q and synced key %q", c.Local, c.Remote)
+			}
+			if len(conflicts) > 0 {
+				notifier.Notify("Resolved conflicting keys", fmt.Sprintf("%d keys synced from another device conflicted with keys on this device; both copies were kept", len(conflicts)))
+			}
+		})
+	})
+
+	// Allow approved web applications to request signatures.
+	acl := bridge.NewACL(localStorage, c)
+	bridgeServer := bridge.NewServer(hooked, acl, auditLog, approvals, c)
+	bridge.InjectApproved(c, acl)
+
+	// Process pipelined sign requests concurrently, up to the limit
+	// configured in settings.  New connections use the current limit.
+	parallelism := agentport.DefaultParallelism
+	reloadParallelism := func() {
+		agentport.ReadParallelism(localStorage, func(n int, err error) {
+			if err != nil {
+				log.Printf("Failed to read agent parallelism: %v", err)
+			}
+			parallelism = n
+		})
+	}
+	reloadParallelism()
+	c.LocalStorage().OnChanged(func(changes map[string]interface{}) {
+		if _, ok := changes[agentport.ParallelismKey]; ok {
+			reloadParallelism()
+		}
+	})
+
+	// Serve the agent over each transport.  Every transport shares the
+	// same agent; only how clients connect, and which are admitted,
+	// differs.  Each client sees only the keys in the profile named by
+	// the channel on which it connected, so that (for example) a session
+	// using the work profile never sees personal keys.
+	server := transport.NewServer(keys.NewProfileRouter(onDemand, mgr), auditLog, func() int {
+		return parallelism
+	})
+
+	// Clients may add keys with the confirm constraint (e.g., using
+	// 'ssh-add -c'); each signature using one is held until the user
+	// allows it, naming the connection that asked.  The bridge asks the
+	// user to allow every signature requested by a web application, so
+	// needs no interceptor of its own.
+	server.Intercept(keys.NewConfirmInterceptor(approvals, a))
+
+	// Flag signature requests that duplicate a recent request from
+	// another connection (or web application), which may be a replayed
+	// request, and refuse them if configured to.
+	replays := replay.NewDetector(func(d *replay.Duplicate) {
+		auditLog.Record(audit.NewEntry("replay", d.Conn, d.Fingerprint, !d.Blocked, d.String()), nil)
+		notifier.Notify("Duplicate signature request", fmt.Sprintf("A client (%s) asked for a signature that %s requested %v earlier; the request may have been replayed.", d.Conn, d.Original, d.Age.Round(time.Second)))
+	})
+	reloadReplays := func() {
+		replay.ReadSettings(localStorage, func(s *replay.Settings, err error) {
+			if err != nil {
+				log.Printf("Failed to read replay detection settings: %v", err)
+			}
+			replays.Configure(s)
+		})
+	}
+	reloadReplays()
+	c.LocalStorage().OnChanged(func(changes map[string]interface{}) {
+		if replay.Changed(changes) {
+			reloadReplays()
+		}
+	})
+	server.DetectReplays(replays)
+	bridgeServer.DetectReplays(replays)
+
+	// Load and unload keys at the times in their schedules.  Chrome
+	// fires the alarm every minute; events scheduled earlier today are
+	// handled once the keys marked to be loaded on startup are loaded,
+	// so that keys scheduled to be loaded this morning are loaded if
+	// Chrome starts later in the day.
+	now := time.Now()
+	scheduler := keys.NewScheduler(mgr, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+	runSchedule := func() {
+		scheduler.Run(time.Now(), func(loaded, unloaded *keys.Result, err error) {
+			for _, r := range []*keys.Result{loaded, unloaded} {
+				for _, item := range r.Items {
+					detail := fmt.Sprintf("%sed on schedule", r.Action)
+					if item.Err != nil {
+						detail = fmt.Sprintf("failed to %s on schedule: %v", r.Action, item.Err)
+					}
+					auditLog.Record(audit.NewEntry("schedule", "schedule", string(item.ID), item.Err == nil, detail), nil)
+				}
+			}
+			if err == nil {
+				err = loaded.Err()
+			}
+			if err == nil {
+				err = unloaded.Err()
+			}
+			if err != nil {
+				log.Printf("Failed to load or unload scheduled keys: %v", err)
+				notifier.Notify("Scheduled keys", fmt.Sprintf("Some keys could not be loaded or unloaded on schedule: %v", err))
+			}
+		})
+	}
+	c.CreateAlarm(scheduleAlarm, time.Minute)
+	c.OnAlarm(func(name string) {
+		if name == scheduleAlarm {
+			runSchedule()
+		}
+	})
+
+	// Load the keys marked to be loaded on startup, so that they are
+	// available after Chrome restarts without visiting the options page.
+	mgr.LoadOnStartup(func(loaded int, err error) {
+		if loaded > 0 {
+			log.Printf("Loaded %d keys on startup", loaded)
+		}
+		if err != nil {
+			log.Printf("Failed to load keys on startup: %v", err)
+		}
+		runSchedule()
+	})
+
+	// Upgrade keys configured or loaded by older versions of the
+	// extension.  What was upgraded is stored for display on the options
+	// page, and included in diagnostic bundles.
+	mgr.Upgrade(func(result *keys.UpgradeResult, err error) {
+		if err != nil {
+			log.Printf("Failed to upgrade keys: %v", err)
+			return
+		}
+		if result.Empty() {
+			return
+		}
+		log.Printf("Upgraded %d configured and %d loaded keys", len(result.Keys), len(result.Loaded))
+		keys.WriteUpgradeReport(localStorage, result, func(err error) {
+			if err != nil {
+				log.Printf("Failed to store upgrade report: %v", err)
+			}
+		})
+	})
+
+	// Check the integrity of stored keys at startup, and that the agent
+	// answers requests, if enabled in settings.  The outcome is shown
+	// on the toolbar icon and stored for display on the options page.
+	selfcheck.ReadSettings(localStorage, func(s *selfcheck.Settings, err error) {
+		if err != nil {
+			log.Printf("Failed to read self-check settings: %v", err)
+		}
+		if !s.Enabled {
+			return
+		}
+		selfcheck.Run(mgr, onDemand, s.Sample, time.Now, func(r *selfcheck.Report) {
+			log.Printf("Self-check completed: %s", r.Health)
+			for _, p := range r.Problems() {
+				log.Printf("Self-check problem: %s", p)
+			}
+			status.SetHealth(r.Health, r.Problems())
+			selfcheck.WriteReport(localStorage, r, func(err error) {
+				if err != nil {
+					log.Printf("Failed to store self-check report: %v", err)
+				}
+			})
+		})
+	})
+
+	// Serve the agent to local clients via the native messaging host, if
+	// it is installed.  Communicating with the host requires an optional
+	// permission, which the user grants by enabling command-line clients;
+	// connect at startup if it is held, and whenever it is granted.
+	native := transport.NewNative(c, nativemsg.HostName)
+	server.Add(native, transport.AllowAll)
+	permissions.Granted(c, permissions.NativeMessaging, func(granted bool, err error) {
+		if err != nil {
+			log.Printf("Failed to check native messaging permission: %v", err)
+			return
+		}
+		if granted {
+			native.Connect()
+		}
+	})
+	c.OnPermissionsChanged(func(perms []string, added bool) {
+		if !permissions.Contains(perms, permissions.NativeMessaging) {
+			return
+		}
+		if added {
+			native.Connect()
+			return
+		}
+		native.Disconnect()
+	})
+
+	// Other extensions may only use the agent once the user approves
+	// them; until then, their connections are queued.
+	extensions := external.NewACL(localStorage, c)
+	gate := external.NewTransportACL(extensions, func(id string) {
+		auditLog.Record(audit.NewEntry("connect", audit.ExtensionRequester(id), "", false, "awaiting approval"), nil)
+		notifier.Notify("Extension waiting for approval", fmt.Sprintf("The extension %s is asking to use your keys. Open the extension's options to approve or deny it.", id))
+	})
+	c.LocalStorage().OnChanged(func(changes map[string]interface{}) {
+		if external.Changed(changes) {
+			gate.Update()
+		}
 	})
+	server.Add(transport.NewExternal(c), gate)
 }
@@ -0,0 +1,193 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/storage"
+)
+
+// OriginPermissions grants and relinquishes access to web origins. See
+// chrome.C.RequestOrigins() for details; using this interface allows for
+// alternate implementations during testing.
+type OriginPermissions interface {
+	// RequestOrigins requests access to the specified origins.
+	RequestOrigins(origins []string, callback func(granted bool, err error))
+
+	// RemoveOrigins relinquishes access to the specified origins.
+	RemoveOrigins(origins []string, callback func(err error))
+}
+
+const (
+	// originsKey is the key under which approved origins are stored.
+	originsKey = "bridge.origins"
+)
+
+// ACL is the set of web origins that the user has approved to use the
+// bridge.  Approvals are stored per-device; they are never synced.
+type ACL struct {
+	store storage.Store
+	perms OriginPermissions
+}
+
+// NewACL returns an ACL that keeps approved origins in the supplied storage,
+// and uses perms to obtain access to them.
+func NewACL(store storage.Store, perms OriginPermissions) *ACL {
+	return &ACL{
+		store: store,
+		perms: perms,
+	}
+}
+
+// NormalizeOrigin validates that s is a URL for a web origin that may be
+// approved, and returns the canonical form of the origin (e.g.,
+// 'https://example.com').  Only HTTPS origins may be approved.
+func NormalizeOrigin(s string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("invalid origin: %v", err)
+	}
+	if u.Scheme != "https" {
+		return "", errors.New("origin must use https")
+	}
+	if u.Host == "" {
+		return "", errors.New("origin must include a host")
+	}
+	return fmt.Sprintf("%s://%s", u.Scheme, strings.ToLower(u.Host)), nil
+}
+
+// pattern returns the match pattern used to request access to an origin.
+func pattern(origin string) string {
+	return origin + "/*"
+}
+
+// Origins returns the approved origins.  callback is invoked with the result.
+func (a *ACL) Origins(callback func(origins []string, err error)) {
+	a.store.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read approved origins: %v", err))
+			return
+		}
+
+		raw, _ := data[originsKey].([]interface{})
+		var result []string
+		for _, r := range raw {
+			if o, ok := r.(string); ok {
+				result = append(result, o)
+			}
+		}
+		callback(result, nil)
+	})
+}
+
+// Allowed determines if the origin of the specified URL has been approved.
+// callback is invoked with the result.
+func (a *ACL) Allowed(rawURL string, callback func(allowed bool, err error)) {
+	origin, err := NormalizeOrigin(rawURL)
+	if err != nil {
+		callback(false, nil)
+		return
+	}
+
+	a.Origins(func(origins []string, err error) {
+		if err != nil {
+			callback(false, err)
+			return
+		}
+		for _, o := range origins {
+			if o == origin {
+				callback(true, nil)
+				return
+			}
+		}
+		callback(false, nil)
+	})
+}
+
+// write stores the supplied set of approved origins.
+func (a *ACL) write(origins []string, callback func(err error)) {
+	a.store.Set(map[string]interface{}{originsKey: origins}, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to write approved origins: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// Allow approves an origin.  Access to the origin is requested from the
+// user; as such, this must be invoked in response to a user gesture.
+// callback is invoked when complete.
+func (a *ACL) Allow(rawOrigin string, callback func(err error)) {
+	origin, err := NormalizeOrigin(rawOrigin)
+	if err != nil {
+		callback(err)
+		return
+	}
+
+	a.perms.RequestOrigins([]string{pattern(origin)}, func(granted bool, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		if !granted {
+			callback(fmt.Errorf("access to %s was not granted", origin))
+			return
+		}
+
+		a.Origins(func(origins []string, err error) {
+			if err != nil {
+				callback(err)
+				return
+			}
+			for _, o := range origins {
+				if o == origin {
+					callback(nil)
+					return
+				}
+			}
+			a.write(append(origins, origin), callback)
+		})
+	})
+}
+
+// Revoke withdraws approval for an origin.  callback is invoked when
+// complete.
+func (a *ACL) Revoke(origin string, callback func(err error)) {
+	a.Origins(func(origins []string, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+
+		var remaining []string
+		for _, o := range origins {
+			if o != origin {
+				remaining = append(remaining, o)
+			}
+		}
+		a.write(remaining, func(err error) {
+			if err != nil {
+				callback(err)
+				return
+			}
+			a.perms.RemoveOrigins([]string{pattern(origin)}, callback)
+		})
+	})
+}
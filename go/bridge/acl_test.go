@@ -0,0 +1,157 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bridge
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/kr/pretty"
+)
+
+func TestNormalizeOrigin(t *testing.T) {
+	testcases := []struct {
+		description string
+		origin      string
+		want        string
+		wantErr     error
+	}{
+		{
+			description: "origin",
+			origin:      "https://example.com",
+			want:        "https://example.com",
+		},
+		{
+			description: "strip path and lowercase host",
+			origin:      " https://Example.COM/some/path?q=1 ",
+			want:        "https://example.com",
+		},
+		{
+			description: "preserve port",
+			origin:      "https://example.com:8443/",
+			want:        "https://example.com:8443",
+		},
+		{
+			description: "reject http",
+			origin:      "http://example.com",
+			wantErr:     errors.New("origin must use https"),
+		},
+		{
+			description: "reject missing host",
+			origin:      "example.com",
+			wantErr:     errors.New("origin must use https"),
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := NormalizeOrigin(tc.origin)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect origin; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestAllowRevoke(t *testing.T) {
+	testcases := []struct {
+		description string
+		deny        bool
+		allow       []string
+		revoke      []string
+		wantOrigins []string
+		wantPerms   map[string]bool
+		wantErr     error
+	}{
+		{
+			description: "allow origins",
+			allow:       []string{"https://a.example.com", "https://B.example.com/path"},
+			wantOrigins: []string{"https://a.example.com", "https://b.example.com"},
+			wantPerms: map[string]bool{
+				"https://a.example.com/*": true,
+				"https://b.example.com/*": true,
+			},
+		},
+		{
+			description: "allow origin twice",
+			allow:       []string{"https://a.example.com", "https://a.example.com/"},
+			wantOrigins: []string{"https://a.example.com"},
+			wantPerms: map[string]bool{
+				"https://a.example.com/*": true,
+			},
+		},
+		{
+			description: "revoke origin",
+			allow:       []string{"https://a.example.com", "https://b.example.com"},
+			revoke:      []string{"https://a.example.com"},
+			wantOrigins: []string{"https://b.example.com"},
+			wantPerms: map[string]bool{
+				"https://b.example.com/*": true,
+			},
+		},
+		{
+			description: "user declines permission",
+			deny:        true,
+			allow:       []string{"https://a.example.com"},
+			wantPerms:   map[string]bool{},
+			wantErr:     errors.New("access to https://a.example.com was not granted"),
+		},
+		{
+			description: "reject invalid origin",
+			allow:       []string{"http://a.example.com"},
+			wantPerms:   map[string]bool{},
+			wantErr:     errors.New("origin must use https"),
+		},
+	}
+
+	for _, tc := range testcases {
+		perms := fakes.NewPermissions()
+		perms.Deny = tc.deny
+		acl := NewACL(fakes.NewMemStorage(), perms)
+
+		var err error
+		for _, o := range tc.allow {
+			acl.Allow(o, func(e error) {
+				if e != nil {
+					err = e
+				}
+			})
+		}
+		for _, o := range tc.revoke {
+			acl.Revoke(o, func(e error) {
+				if e != nil {
+					err = e
+				}
+			})
+		}
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+
+		acl.Origins(func(origins []string, err error) {
+			if err != nil {
+				t.Errorf("%s: failed to get origins: %v", tc.description, err)
+			}
+			if diff := pretty.Diff(origins, tc.wantOrigins); diff != nil {
+				t.Errorf("%s: incorrect origins; -got +want: %s", tc.description, diff)
+			}
+		})
+		if diff := pretty.Diff(perms.Origins, tc.wantPerms); diff != nil {
+			t.Errorf("%s: incorrect permissions; -got +want: %s", tc.description, diff)
+		}
+	}
+}
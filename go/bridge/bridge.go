@@ -0,0 +1,471 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bridge allows web applications approved by the user (e.g., web IDEs
+// that speak git-over-ssh) to request SSH signatures from the agent.
+//
+// A content script injected into approved pages relays requests posted by the
+// page (using window.postMessage) to a Server running in the background page.
+// The user is asked to allow each signature request from the background page
+// (see keys.ConfirmGuard), rather than in the requesting page's tab where the
+// page could interfere with the dialog.  Every request is recorded in the
+// audit log.
+package bridge
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/redact"
+	"github.com/google/chrome-ssh-agent/go/replay"
+	"github.com/google/chrome-ssh-agent/go/signreq"
+	"github.com/google/chrome-ssh-agent/go/transport"
+	"github.com/gopherjs/gopherjs/js"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Define a distinct type for each message.  These are embedded in each
+// message, and are distinct from those used by keys.Server.
+const (
+	msgTypeList int = 2000 + iota
+	msgTypeListRsp
+	msgTypeSign
+	msgTypeSignRsp
+)
+
+// msgHeader are the common fields included in every message (as an embedded
+// type).
+type msgHeader struct {
+	*js.Object
+	Type int `js:"type"`
+}
+
+// PublicKey is a public key made available to an approved web application.
+type PublicKey struct {
+	*js.Object
+	// Type is the type of key (e.g., 'ssh-rsa').
+	Type string `js:"type"`
+	// Blob is the base64-encoded public key material.
+	Blob string `js:"blob"`
+	// Comment is the comment for the key.
+	Comment string `js:"comment"`
+}
+
+type msgList struct {
+	*msgHeader
+}
+
+type rspList struct {
+	*msgHeader
+	Keys []*PublicKey `js:"keys"`
+	Err  string       `js:"err"`
+}
+
+type msgSign struct {
+	*msgHeader
+	Blob string `js:"blob"`
+	Data string `js:"data"`
+}
+
+type rspSign struct {
+	*msgHeader
+	Format    string `js:"format"`
+	Signature string `js:"signature"`
+	Err       string `js:"err"`
+}
+
+// errStr converts an error to a string, redacting any sensitive material it
+// contains. A nil error is converted to the empty string.
+func errStr(err error) string {
+	if err == nil {
+		return ""
+	}
+	return redact.String(err.Error())
+}
+
+// makeErr converts a string to an error. Empty string returns nil (i.e., no
+// error).
+func makeErr(s string) error {
+	if s == "" {
+		return nil
+	}
+	return errors.New(s)
+}
+
+// describeKey returns a human-readable description of the key with the
+// specified base64-encoded public key material.  nickname is the name by
+// which the key is listed, or empty if it is unknown.
+func describeKey(blob, nickname string) string {
+	b, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return "an unknown key"
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		return "an unknown key"
+	}
+	if nickname != "" {
+		return fmt.Sprintf("the %s key %q (%s)", pub.Type(), nickname, ssh.FingerprintSHA256(pub))
+	}
+	return fmt.Sprintf("the %s key %s", pub.Type(), ssh.FingerprintSHA256(pub))
+}
+
+// confirmMessage returns the message displayed when asking the user to allow
+// a request from origin to sign data with the key whose public key material is
+// blob and whose nickname is nickname.  Both blob and data are base64-encoded.
+// If data is an SSH authentication request, the message describes the login
+// it authorizes.
+func confirmMessage(origin, blob, nickname, data string) string {
+	msg := fmt.Sprintf("%s is requesting an SSH signature using %s", origin, describeKey(blob, nickname))
+
+	d, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return msg + "."
+	}
+	req, err := signreq.Parse(d)
+	if err != nil {
+		return msg + ". The data to be signed is not an SSH login, so what it authorizes is unknown."
+	}
+	msg = fmt.Sprintf("%s to %s.", msg, req.Describe())
+	if b, err := base64.StdEncoding.DecodeString(blob); err != nil || !req.MatchesKey(b) {
+		msg += " Warning: the login names a different key than the one being asked to sign."
+	}
+	return msg
+}
+
+// Server handles requests relayed by the content script from approved web
+// applications.
+type Server struct {
+	agent        agent.Agent
+	acl          *ACL
+	audit        *audit.Log
+	confirm      *keys.ConfirmGuard
+	replays      *replay.Detector
+	interceptors []transport.Interceptor
+}
+
+// NewServer returns a Server that serves keys from the supplied agent to the
+// web applications approved in acl.  Each signature request waits until the
+// user allows it using confirm.  All requests are recorded in auditLog.
+func NewServer(agt agent.Agent, acl *ACL, auditLog *audit.Log, confirm *keys.ConfirmGuard, msg keys.MessageReceiver) *Server {
+	result := &Server{
+		agent:   agt,
+		acl:     acl,
+		audit:   auditLog,
+		confirm: confirm,
+	}
+	msg.OnMessage(result.onMessage)
+	return result
+}
+
+// DetectReplays checks each signature request against those recently made by
+// other web applications and clients using d, as transport.Server does.
+func (s *Server) DetectReplays(d *replay.Detector) {
+	s.replays = d
+}
+
+// Intercept wraps the agent used for each signature request subsequently
+// made by a web application using i, as transport.Server.Intercept does for
+// connected clients (e.g., to enforce the confirm constraint).
+func (s *Server) Intercept(i transport.Interceptor) {
+	s.interceptors = append(s.interceptors, i)
+}
+
+// requestAgent returns the agent with which a signature request made by the
+// web application at origin is performed.  The request waits until the user
+// allows it; reason is displayed when they are asked.  Since every request
+// is allowed by the user, this also satisfies the confirm constraint.
+func (s *Server) requestAgent(origin, reason string) agent.Agent {
+	conn := fmt.Sprintf("web application %s", origin)
+	a := keys.NewConfirmAllInterceptor(s.confirm, reason).Agent(s.agent, conn)
+	for _, i := range s.interceptors {
+		a = i.Agent(a, conn)
+	}
+	if s.replays != nil {
+		a = s.replays.Agent(a, conn)
+	}
+	return a
+}
+
+// senderURL returns the URL of the page that sent a message, or the empty
+// string if it is unknown.
+func senderURL(sender *js.Object) string {
+	if sender == nil || sender == js.Undefined {
+		return ""
+	}
+	u := sender.Get("url")
+	if u == js.Undefined {
+		return ""
+	}
+	return u.String()
+}
+
+// pageForAudit returns the URL of a page as recorded in the audit log.  The
+// query and fragment are removed, since they may contain tokens or other
+// sensitive material.
+func pageForAudit(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// newEntry returns an audit entry for a request made by the page at pageURL.
+func newEntry(action, origin, pageURL, key string, allowed bool, detail string) *audit.Entry {
+	e := audit.NewEntry(action, origin, key, allowed, detail)
+	e.Page = pageForAudit(pageURL)
+	return e
+}
+
+// onMessage is the callback invoked when a message is received.  Messages
+// that are not intended for the bridge are ignored.
+func (s *Server) onMessage(headerObj *js.Object, sender *js.Object, sendResponse func(interface{})) bool {
+	header := &msgHeader{Object: headerObj}
+	switch header.Type {
+	case msgTypeList:
+		s.list(senderURL(sender), func(keys []*PublicKey, err error) {
+			rsp := &rspList{msgHeader: header}
+			rsp.Type = msgTypeListRsp
+			rsp.Keys = keys
+			rsp.Err = errStr(err)
+			sendResponse(rsp)
+		})
+		return true
+	case msgTypeSign:
+		m := &msgSign{msgHeader: header}
+		s.sign(senderURL(sender), m.Blob, m.Data, func(sig *ssh.Signature, err error) {
+			rsp := &rspSign{msgHeader: header}
+			rsp.Type = msgTypeSignRsp
+			rsp.Err = errStr(err)
+			if sig != nil {
+				rsp.Format = sig.Format
+				rsp.Signature = base64.StdEncoding.EncodeToString(sig.Blob)
+			}
+			sendResponse(rsp)
+		})
+		return true
+	}
+	return false
+}
+
+// checkAllowed determines if the page at the specified URL may use the
+// bridge.  Denied requests are recorded in the audit log.
+func (s *Server) checkAllowed(action, pageURL, key string, callback func(origin string, err error)) {
+	origin, err := NormalizeOrigin(pageURL)
+	if err != nil {
+		origin = pageURL
+	}
+
+	s.acl.Allowed(pageURL, func(allowed bool, err error) {
+		if err != nil {
+			err = fmt.Errorf("failed to check approved origins: %v", err)
+		} else if !allowed {
+			err = fmt.Errorf("origin %s is not approved", origin)
+		}
+		if err != nil {
+			s.audit.Record(newEntry(action, origin, pageURL, key, false, err.Error()), nil)
+			callback(origin, err)
+			return
+		}
+		callback(origin, nil)
+	})
+}
+
+// list returns the keys loaded in the agent.
+func (s *Server) list(pageURL string, callback func(keys []*PublicKey, err error)) {
+	s.checkAllowed("list", pageURL, "", func(origin string, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+
+		loaded, err := s.agent.List()
+		if err != nil {
+			err = fmt.Errorf("failed to list keys: %v", err)
+			s.audit.Record(newEntry("list", origin, pageURL, "", false, err.Error()), nil)
+			callback(nil, err)
+			return
+		}
+
+		var result []*PublicKey
+		for _, l := range loaded {
+			k := &PublicKey{Object: js.Global.Get("Object").New()}
+			k.Type = l.Type()
+			k.Blob = base64.StdEncoding.EncodeToString(l.Marshal())
+			k.Comment = l.Comment
+			result = append(result, k)
+		}
+		s.audit.Record(newEntry("list", origin, pageURL, "", true, fmt.Sprintf("listed %d keys", len(result))), nil)
+		callback(result, nil)
+	})
+}
+
+// findKey returns the loaded key with the specified public key material.
+func (s *Server) findKey(blob []byte) (*agent.Key, error) {
+	loaded, err := s.agent.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %v", err)
+	}
+	for _, l := range loaded {
+		if bytes.Equal(l.Marshal(), blob) {
+			return l, nil
+		}
+	}
+	return nil, errors.New("key not loaded")
+}
+
+// sign signs data using the loaded key with the specified public key
+// material.  Both blob and data are base64-encoded.
+func (s *Server) sign(pageURL, blob, data string, callback func(sig *ssh.Signature, err error)) {
+	fp := ""
+	b, blobErr := base64.StdEncoding.DecodeString(blob)
+	if blobErr == nil {
+		if pub, err := ssh.ParsePublicKey(b); err == nil {
+			fp = ssh.FingerprintSHA256(pub)
+		}
+	}
+
+	s.checkAllowed("sign", pageURL, fp, func(origin string, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+
+		fail := func(err error) {
+			s.audit.Record(newEntry("sign", origin, pageURL, fp, false, err.Error()), nil)
+			callback(nil, err)
+		}
+
+		if blobErr != nil {
+			fail(fmt.Errorf("failed to decode key: %v", blobErr))
+			return
+		}
+		d, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			fail(fmt.Errorf("failed to decode data: %v", err))
+			return
+		}
+		key, err := s.findKey(b)
+		if err != nil {
+			fail(err)
+			return
+		}
+		// The key's nickname is included in the confirmation so that
+		// keys with the same name can be told apart.
+		reason := confirmMessage(origin, blob, (&keys.LoadedKey{Comment: key.Comment}).Nickname(), data)
+
+		// Signing waits for the user to allow it, which must not block
+		// the message callback.
+		go func() {
+			sig, err := s.requestAgent(origin, reason).Sign(key, d)
+			if err != nil {
+				fail(fmt.Errorf("failed to sign: %v", err))
+				return
+			}
+
+			s.audit.Record(newEntry("sign", origin, pageURL, fp, true, "signed"), nil)
+			callback(sig, nil)
+		}()
+	})
+}
+
+// Client sends requests to a Server.  It is used by the content script.
+type Client struct {
+	msg keys.MessageSender
+}
+
+// NewClient returns a Client that sends requests using the supplied
+// messaging API.
+func NewClient(msg keys.MessageSender) *Client {
+	return &Client{msg: msg}
+}
+
+// List returns the keys loaded in the agent.  callback is invoked with the
+// result.
+func (c *Client) List(callback func(keys []*PublicKey, err error)) {
+	msg := &msgList{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeList
+	c.msg.SendMessage(msg, func(rspObj *js.Object) {
+		if err := c.msg.Error(); err != nil {
+			callback(nil, fmt.Errorf("failed to send message: %v", err))
+			return
+		}
+		rsp := &rspList{msgHeader: &msgHeader{Object: rspObj}}
+		callback(rsp.Keys, makeErr(rsp.Err))
+	})
+}
+
+// Sign signs data using the key with the specified public key material. Both
+// blob and data are base64-encoded.  callback is invoked with the signature
+// format and base64-encoded signature.
+func (c *Client) Sign(blob, data string, callback func(format, signature string, err error)) {
+	msg := &msgSign{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeSign
+	msg.Blob = blob
+	msg.Data = data
+	c.msg.SendMessage(msg, func(rspObj *js.Object) {
+		if err := c.msg.Error(); err != nil {
+			callback("", "", fmt.Errorf("failed to send message: %v", err))
+			return
+		}
+		rsp := &rspSign{msgHeader: &msgHeader{Object: rspObj}}
+		callback(rsp.Format, rsp.Signature, makeErr(rsp.Err))
+	})
+}
+
+// ContentScript is the path (relative to the extension's root) of the
+// content script that relays requests from approved web applications.
+const ContentScript = "go/contentscript/contentscript.js"
+
+// Tabs provides access to browser tabs.  See chrome.C.OnTabUpdated() for
+// details on the methods; using this interface allows for alternate
+// implementations during testing.
+type Tabs interface {
+	// OnTabUpdated installs a callback invoked when a tab loads a page.
+	OnTabUpdated(callback func(tabID int, url string))
+
+	// ExecuteScript injects a script into the page displayed in a tab.
+	ExecuteScript(tabID int, file string, callback func(err error))
+}
+
+// InjectApproved injects the content script into pages from approved origins
+// as they are loaded.
+func InjectApproved(tabs Tabs, acl *ACL) {
+	tabs.OnTabUpdated(func(tabID int, url string) {
+		acl.Allowed(url, func(allowed bool, err error) {
+			if err != nil {
+				log.Printf("Failed to check approved origins: %v", err)
+				return
+			}
+			if !allowed {
+				return
+			}
+			tabs.ExecuteScript(tabID, ContentScript, func(err error) {
+				if err != nil {
+					log.Printf("Failed to inject content script: %v", err)
+				}
+			})
+		})
+	})
+}
@@ -0,0 +1,369 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bridge
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	approvedURL = "https://approved.example.com/repo"
+	otherURL    = "https://other.example.com/repo"
+)
+
+type testHarness struct {
+	hub    *fakes.MessageHub
+	agent  agent.Agent
+	log    *audit.Log
+	server *Server
+	client *Client
+	// refuse is set to refuse signature requests when the user is asked.
+	refuse bool
+	// requested contains the reasons displayed when the user was asked
+	// to allow signature requests.
+	requested []string
+}
+
+func newHarness(loadKey bool) *testHarness {
+	hub := fakes.NewMessageHub()
+	agt := agent.NewKeyring()
+	if loadKey {
+		priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+		if err != nil {
+			panic(fmt.Sprintf("failed to parse private key: %v", err))
+		}
+		if err := agt.Add(agent.AddedKey{PrivateKey: priv, Comment: "some-key"}); err != nil {
+			panic(fmt.Sprintf("failed to load private key: %v", err))
+		}
+	}
+
+	acl := NewACL(fakes.NewMemStorage(), fakes.NewPermissions())
+	acl.Allow(approvedURL, func(err error) {
+		if err != nil {
+			panic(fmt.Sprintf("failed to approve origin: %v", err))
+		}
+	})
+
+	h := &testHarness{
+		hub:   hub,
+		agent: agt,
+		log:   audit.NewLog(fakes.NewMemStorage(), 100),
+	}
+	var guard *keys.ConfirmGuard
+	guard = keys.NewConfirmGuard(keys.DefaultConfirmTimeout, func(r *keys.ConfirmRequest) {
+		h.requested = append(h.requested, r.Reason)
+		if err := guard.Respond(r.Request, !h.refuse); err != nil {
+			panic(fmt.Sprintf("failed to respond to signature request: %v", err))
+		}
+	})
+	h.server = NewServer(agt, acl, h.log, guard, hub)
+	h.client = NewClient(hub)
+	return h
+}
+
+// auditSummary summarizes an audit entry for comparison.
+type auditSummary struct {
+	Action    string
+	Requester string
+	Page      string
+	Allowed   bool
+}
+
+func (h *testHarness) auditEntries() []auditSummary {
+	var result []auditSummary
+	h.log.Entries(func(entries []*audit.Entry, err error) {
+		if err != nil {
+			panic(fmt.Sprintf("failed to read audit log: %v", err))
+		}
+		for _, e := range entries {
+			result = append(result, auditSummary{Action: e.Action, Requester: e.Requester, Page: e.Page, Allowed: e.Allowed})
+		}
+	})
+	return result
+}
+
+func TestList(t *testing.T) {
+	testcases := []struct {
+		description string
+		senderURL   string
+		loadKey     bool
+		wantKeys    []string
+		wantErr     error
+		wantAudit   []auditSummary
+	}{
+		{
+			description: "list keys",
+			senderURL:   approvedURL,
+			loadKey:     true,
+			wantKeys:    []string{testdata.ValidPrivateKeyWithoutPassphraseBlob},
+			wantAudit: []auditSummary{
+				{Action: "list", Requester: "https://approved.example.com", Page: approvedURL, Allowed: true},
+			},
+		},
+		{
+			description: "list no keys",
+			senderURL:   approvedURL,
+			wantAudit: []auditSummary{
+				{Action: "list", Requester: "https://approved.example.com", Page: approvedURL, Allowed: true},
+			},
+		},
+		{
+			description: "origin not approved",
+			senderURL:   otherURL,
+			loadKey:     true,
+			wantErr:     errors.New("origin https://other.example.com is not approved"),
+			wantAudit: []auditSummary{
+				{Action: "list", Requester: "https://other.example.com", Page: otherURL, Allowed: false},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		h := newHarness(tc.loadKey)
+		h.hub.SetSenderURL(tc.senderURL)
+
+		h.client.List(func(keys []*PublicKey, err error) {
+			if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+				t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+			}
+			var blobs []string
+			for _, k := range keys {
+				blobs = append(blobs, k.Blob)
+			}
+			if diff := pretty.Diff(blobs, tc.wantKeys); diff != nil {
+				t.Errorf("%s: incorrect keys; -got +want: %s", tc.description, diff)
+			}
+		})
+		if diff := pretty.Diff(h.auditEntries(), tc.wantAudit); diff != nil {
+			t.Errorf("%s: incorrect audit entries; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	data := base64.StdEncoding.EncodeToString([]byte("some-data"))
+
+	testcases := []struct {
+		description string
+		senderURL   string
+		loadKey     bool
+		refuse      bool
+		blob        string
+		wantErr     error
+		wantAsked   bool
+		wantAudit   []auditSummary
+	}{
+		{
+			description: "sign data",
+			senderURL:   approvedURL,
+			loadKey:     true,
+			blob:        testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			wantAsked:   true,
+			wantAudit: []auditSummary{
+				{Action: "sign", Requester: "https://approved.example.com", Page: approvedURL, Allowed: true},
+			},
+		},
+		{
+			description: "query and fragment omitted from audit log",
+			senderURL:   approvedURL + "?token=secret#section",
+			loadKey:     true,
+			blob:        testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			wantAsked:   true,
+			wantAudit: []auditSummary{
+				{Action: "sign", Requester: "https://approved.example.com", Page: approvedURL, Allowed: true},
+			},
+		},
+		{
+			description: "refused by user",
+			senderURL:   approvedURL,
+			loadKey:     true,
+			refuse:      true,
+			blob:        testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			wantErr:     fmt.Errorf("failed to sign: %v", keys.ErrConfirmDenied),
+			wantAsked:   true,
+			wantAudit: []auditSummary{
+				{Action: "sign", Requester: "https://approved.example.com", Page: approvedURL, Allowed: false},
+			},
+		},
+		{
+			description: "key not loaded",
+			senderURL:   approvedURL,
+			blob:        testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			wantErr:     errors.New("key not loaded"),
+			wantAudit: []auditSummary{
+				{Action: "sign", Requester: "https://approved.example.com", Page: approvedURL, Allowed: false},
+			},
+		},
+		{
+			description: "origin not approved",
+			senderURL:   otherURL,
+			loadKey:     true,
+			blob:        testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			wantErr:     errors.New("origin https://other.example.com is not approved"),
+			wantAudit: []auditSummary{
+				{Action: "sign", Requester: "https://other.example.com", Page: otherURL, Allowed: false},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		h := newHarness(tc.loadKey)
+		h.refuse = tc.refuse
+		h.hub.SetSenderURL(tc.senderURL)
+
+		// Signing completes asynchronously.
+		done := make(chan struct{})
+		h.client.Sign(tc.blob, data, func(format, signature string, err error) {
+			defer close(done)
+			if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+				t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+			}
+			if err != nil {
+				return
+			}
+
+			b, _ := base64.StdEncoding.DecodeString(tc.blob)
+			pub, err := ssh.ParsePublicKey(b)
+			if err != nil {
+				t.Fatalf("%s: failed to parse public key: %v", tc.description, err)
+			}
+			sig, _ := base64.StdEncoding.DecodeString(signature)
+			if err := pub.Verify([]byte("some-data"), &ssh.Signature{Format: format, Blob: sig}); err != nil {
+				t.Errorf("%s: failed to verify signature: %v", tc.description, err)
+			}
+		})
+		<-done
+		if asked := len(h.requested) > 0; asked != tc.wantAsked {
+			t.Errorf("%s: incorrect confirmation; got asked %t, want %t", tc.description, asked, tc.wantAsked)
+		}
+		if diff := pretty.Diff(h.auditEntries(), tc.wantAudit); diff != nil {
+			t.Errorf("%s: incorrect audit entries; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+// authRequest returns the base64-encoded data signed by a client logging in
+// as user with the key whose public key material is blob.
+func authRequest(user, blob string) string {
+	pub, _ := base64.StdEncoding.DecodeString(blob)
+	data := ssh.Marshal(struct{ ID []byte }{[]byte{0xab, 0xcd}})
+	data = append(data, ssh.Marshal(struct {
+		User      string `sshtype:"50"`
+		Service   string
+		Method    string
+		HasSig    bool
+		Algorithm string
+		PublicKey []byte
+	}{user, "ssh-connection", "publickey", true, "ssh-rsa", pub})...)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func TestConfirmMessage(t *testing.T) {
+	blob := testdata.ValidPrivateKeyWithoutPassphraseBlob
+	prefix := fmt.Sprintf("https://example.com is requesting an SSH signature using %s", describeKey(blob, ""))
+
+	testcases := []struct {
+		description string
+		data        string
+		want        string
+	}{
+		{
+			description: "authentication request",
+			data:        authRequest("git", blob),
+			want:        prefix + ` to log in as "git" (service ssh-connection, session abcd).`,
+		},
+		{
+			description: "authentication request for another key",
+			data:        authRequest("git", testdata.ValidPrivateKeyBlob),
+			want:        prefix + ` to log in as "git" (service ssh-connection, session abcd). Warning: the login names a different key than the one being asked to sign.`,
+		},
+		{
+			description: "arbitrary data",
+			data:        base64.StdEncoding.EncodeToString([]byte("some-data")),
+			want:        prefix + ". The data to be signed is not an SSH login, so what it authorizes is unknown.",
+		},
+		{
+			description: "invalid data",
+			data:        "!!!",
+			want:        prefix + ".",
+		},
+	}
+
+	for _, tc := range testcases {
+		got := confirmMessage("https://example.com", blob, "", tc.data)
+		if got != tc.want {
+			t.Errorf("%s: incorrect message; got %q, want %q", tc.description, got, tc.want)
+		}
+	}
+
+	// Keys that share a name are told apart by their nickname.
+	got := confirmMessage("https://example.com", blob, "work (SHA256:abcd1234)", "!!!")
+	if !strings.Contains(got, `using the ssh-rsa key "work (SHA256:abcd1234)" (SHA256:`) {
+		t.Errorf("nickname not included in message; got %q", got)
+	}
+}
+
+// refusingInterceptor refuses every signature, recording the connection over
+// which it was requested.
+type refusingInterceptor struct {
+	conns []string
+}
+
+func (r *refusingInterceptor) Agent(a agent.Agent, conn string) agent.Agent {
+	return &refusingAgent{Agent: a, interceptor: r, conn: conn}
+}
+
+type refusingAgent struct {
+	agent.Agent
+	interceptor *refusingInterceptor
+	conn        string
+}
+
+func (r *refusingAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	r.interceptor.conns = append(r.interceptor.conns, r.conn)
+	return nil, errors.New("refused")
+}
+
+func TestIntercept(t *testing.T) {
+	h := newHarness(true)
+	h.hub.SetSenderURL(approvedURL)
+	i := &refusingInterceptor{}
+	h.server.Intercept(i)
+
+	done := make(chan struct{})
+	data := base64.StdEncoding.EncodeToString([]byte("some-data"))
+	h.client.Sign(testdata.ValidPrivateKeyWithoutPassphraseBlob, data, func(format, signature string, err error) {
+		defer close(done)
+		if diff := pretty.Diff(err, errors.New("failed to sign: refused")); diff != nil {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+	<-done
+	if diff := pretty.Diff(i.conns, []string{"web application https://approved.example.com"}); diff != nil {
+		t.Errorf("incorrect intercepted requests; -got +want: %s", diff)
+	}
+}
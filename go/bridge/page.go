@@ -0,0 +1,126 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+const (
+	// PageRequestType is the type of message posted by a web page to
+	// request an operation.
+	PageRequestType = "chrome-ssh-agent-request"
+	// PageResponseType is the type of message posted to the web page in
+	// response to a request.
+	PageResponseType = "chrome-ssh-agent-response"
+)
+
+// pageMessage is a message exchanged with the web page using
+// window.postMessage.  The page sets ID to an arbitrary value, which is
+// included in the response so it can match responses to requests.
+//
+// Requests set Method to one of the following:
+//   - 'list': Lists the keys loaded in the agent.  The response sets Keys.
+//   - 'sign': Signs Data (base64-encoded) with the key whose public key
+//     material is Blob (base64-encoded).  The response sets Format and
+//     Signature (base64-encoded).
+//
+// If the request fails, the response sets Err.
+type pageMessage struct {
+	*js.Object
+	Type      string       `js:"type"`
+	ID        *js.Object   `js:"id"`
+	Method    string       `js:"method"`
+	Blob      string       `js:"blob"`
+	Data      string       `js:"data"`
+	Keys      []*PublicKey `js:"keys"`
+	Format    string       `js:"format"`
+	Signature string       `js:"signature"`
+	Err       string       `js:"err"`
+}
+
+// Page relays requests from the web page displayed in a window to a Server.
+// It never asks the user to approve requests itself: a dialog shown in the
+// page's tab could be interfered with by the page, so the Server asks from
+// the background page instead.
+type Page struct {
+	window *js.Object
+	client *Client
+}
+
+// ServePage relays requests posted by the web page displayed in window to the
+// background page using client.
+func ServePage(window *js.Object, client *Client) *Page {
+	p := &Page{
+		window: window,
+		client: client,
+	}
+	window.Call("addEventListener", "message", p.onMessage)
+	return p
+}
+
+// origin returns the origin of the page.
+func (p *Page) origin() string {
+	return p.window.Get("location").Get("origin").String()
+}
+
+// respond posts a response to the page.
+func (p *Page) respond(req *pageMessage, populate func(rsp *pageMessage)) {
+	rsp := &pageMessage{Object: js.Global.Get("Object").New()}
+	rsp.Type = PageResponseType
+	rsp.ID = req.ID
+	populate(rsp)
+	p.window.Call("postMessage", rsp, p.origin())
+}
+
+// onMessage handles a message posted to the window.  Only requests posted by
+// the page itself are handled.
+func (p *Page) onMessage(event *js.Object) {
+	if event.Get("source") != p.window {
+		return
+	}
+	data := event.Get("data")
+	if data == nil || data == js.Undefined || data.Get("type") == js.Undefined {
+		return
+	}
+	req := &pageMessage{Object: data}
+	if req.Type != PageRequestType {
+		return
+	}
+
+	switch req.Method {
+	case "list":
+		p.client.List(func(keys []*PublicKey, err error) {
+			p.respond(req, func(rsp *pageMessage) {
+				rsp.Keys = keys
+				rsp.Err = errStr(err)
+			})
+		})
+	case "sign":
+		p.client.Sign(req.Blob, req.Data, func(format, signature string, err error) {
+			p.respond(req, func(rsp *pageMessage) {
+				rsp.Format = format
+				rsp.Signature = signature
+				rsp.Err = errStr(err)
+			})
+		})
+	default:
+		p.respond(req, func(rsp *pageMessage) {
+			rsp.Err = fmt.Sprintf("unsupported method %q", req.Method)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chrome
+
+import (
+	"time"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// alarms returns the chrome.alarms API, or nil if it is unavailable.
+func (c *C) alarms() *js.Object {
+	alarms := c.chrome.Get("alarms")
+	if alarms == nil || alarms == js.Undefined {
+		return nil
+	}
+	return alarms
+}
+
+// CreateAlarm creates (or replaces) the named alarm, which fires repeatedly
+// at the specified period.  Chrome fires alarms at most once a minute.
+//
+// See https://developer.chrome.com/extensions/alarms#method-create.
+func (c *C) CreateAlarm(name string, period time.Duration) {
+	if alarms := c.alarms(); alarms != nil {
+		alarms.Call("create", name, js.M{"periodInMinutes": period.Minutes()})
+	}
+}
+
+// OnAlarm installs a callback that will be invoked when an alarm fires.
+// name is the name of the alarm.
+//
+// See https://developer.chrome.com/extensions/alarms#event-onAlarm.
+func (c *C) OnAlarm(callback func(name string)) {
+	if alarms := c.alarms(); alarms != nil {
+		alarms.Get("onAlarm").Call("addListener", func(alarm *js.Object) {
+			callback(alarm.Get("name").String())
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chrome
+
+import (
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// SetBadgeText sets the text displayed on the badge of the extension's
+// toolbar icon.  An empty string removes the badge.
+//
+// See https://developer.chrome.com/extensions/browserAction#method-setBadgeText.
+func (c *C) SetBadgeText(text string) {
+	c.browserAction.Call("setBadgeText", js.M{"text": text})
+}
+
+// SetBadgeBackgroundColor sets the background color of the badge on the
+// extension's toolbar icon.  color is a CSS color (e.g., '#ff0000').
+//
+// See https://developer.chrome.com/extensions/browserAction#method-setBadgeBackgroundColor.
+func (c *C) SetBadgeBackgroundColor(color string) {
+	c.browserAction.Call("setBadgeBackgroundColor", js.M{"color": color})
+}
+
+// SetTitle sets the tooltip displayed when hovering over the extension's
+// toolbar icon.
+//
+// See https://developer.chrome.com/extensions/browserAction#method-setTitle.
+func (c *C) SetTitle(title string) {
+	c.browserAction.Call("setTitle", js.M{"title": title})
+}
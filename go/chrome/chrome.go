@@ -27,8 +27,20 @@ type C struct {
 	chrome *js.Object
 	// runtime is a reference to 'chrome.runtime'.
 	runtime *js.Object
+	// storage is a reference to 'chrome.storage'.
+	storage *js.Object
 	// syncStorage is a reference to 'chrome.storage.sync'.
 	syncStorage *js.Object
+	// localStorage is a reference to 'chrome.storage.local'.
+	localStorage *js.Object
+	// managedStorage is a reference to 'chrome.storage.managed'.
+	managedStorage *js.Object
+	// tabs is a reference to 'chrome.tabs'.
+	tabs *js.Object
+	// permissions is a reference to 'chrome.permissions'.
+	permissions *js.Object
+	// browserAction is a reference to 'chrome.browserAction'.
+	browserAction *js.Object
 	// extensionID is the unique ID allocated to our extension.
 	extensionID string
 }
@@ -42,10 +54,16 @@ func New(chrome *js.Object) *C {
 	}
 
 	return &C{
-		chrome:      chrome,
-		runtime:     chrome.Get("runtime"),
-		syncStorage: chrome.Get("storage").Get("sync"),
-		extensionID: chrome.Get("runtime").Get("id").String(),
+		chrome:         chrome,
+		runtime:        chrome.Get("runtime"),
+		storage:        chrome.Get("storage"),
+		syncStorage:    chrome.Get("storage").Get("sync"),
+		localStorage:   chrome.Get("storage").Get("local"),
+		managedStorage: chrome.Get("storage").Get("managed"),
+		tabs:           chrome.Get("tabs"),
+		permissions:    chrome.Get("permissions"),
+		browserAction:  chrome.Get("browserAction"),
+		extensionID:    chrome.Get("runtime").Get("id").String(),
 	}
 }
 
@@ -57,6 +75,32 @@ func (c *C) SyncStorage() *Storage {
 	return &Storage{
 		chrome: c,
 		o:      c.syncStorage,
+		area:   "sync",
+	}
+}
+
+// LocalStorage returns a Storage object that can be used to store persistent
+// data that is local to this device; it is never synchronized with Chrome
+// Sync.
+//
+// See https://developer.chrome.com/apps/storage#property-local.
+func (c *C) LocalStorage() *Storage {
+	return &Storage{
+		chrome: c,
+		o:      c.localStorage,
+		area:   "local",
+	}
+}
+
+// ManagedStorage returns a Storage object that can be used to read policy
+// configured by an administrator.  It is read-only.
+//
+// See https://developer.chrome.com/apps/storage#property-managed.
+func (c *C) ManagedStorage() *Storage {
+	return &Storage{
+		chrome: c,
+		o:      c.managedStorage,
+		area:   "managed",
 	}
 }
 
@@ -68,6 +112,19 @@ func (c *C) OnMessage(callback func(header *js.Object, sender *js.Object, sendRe
 	c.runtime.Get("onMessage").Call("addListener", callback)
 }
 
+// ExtensionID returns the unique ID allocated to our extension.
+func (c *C) ExtensionID() string {
+	return c.extensionID
+}
+
+// ExtensionURL returns the URL of our extension's root, under which each of
+// its pages is served.
+//
+// See https://developer.chrome.com/apps/runtime#method-getURL.
+func (c *C) ExtensionURL() string {
+	return c.runtime.Call("getURL", "").String()
+}
+
 // SendMessage sends a message within our extension. While the underlying
 // Chrome API supports sending a message to another extension, we only
 // expose functionality to send within the same extension.
@@ -85,6 +142,14 @@ func (c *C) OnConnectExternal(callback func(port *js.Object)) {
 	c.runtime.Get("onConnectExternal").Call("addListener", callback)
 }
 
+// ConnectNative connects to the named native messaging host, and returns
+// the Port used to communicate with it.
+//
+// See https://developer.chrome.com/apps/runtime#method-connectNative.
+func (c *C) ConnectNative(application string) *js.Object {
+	return c.runtime.Call("connectNative", application)
+}
+
 // Error returns the error (if any) from the last call. Returns nil if there
 // was no error.
 //
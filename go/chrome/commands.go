@@ -0,0 +1,35 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chrome
+
+import (
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// OnCommand installs a callback that will be invoked when the user presses
+// the keyboard shortcut for one of the commands declared in the extension's
+// manifest.  command is the name of the command.
+//
+// See https://developer.chrome.com/extensions/commands#event-onCommand.
+func (c *C) OnCommand(callback func(command string)) {
+	commands := c.chrome.Get("commands")
+	if commands == nil || commands == js.Undefined {
+		// chrome.commands is only available in extension pages.
+		return
+	}
+	commands.Get("onCommand").Call("addListener", func(command string) {
+		callback(command)
+	})
+}
@@ -0,0 +1,45 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chrome
+
+import (
+	"fmt"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// DeriveKey derives a key of keyLen bytes from passphrase with
+// PBKDF2-SHA256.  The key is derived by WebCrypto, outside of the page's
+// JavaScript, so that the page is not blocked meanwhile.  callback is
+// invoked with the key.
+//
+// See https://developer.mozilla.org/en-US/docs/Web/API/SubtleCrypto/deriveBits.
+func (c *C) DeriveKey(passphrase, salt []byte, iterations, keyLen int, callback func(key []byte, err error)) {
+	fail := func(reason *js.Object) {
+		callback(nil, fmt.Errorf("failed to derive key: %s", reason.Call("toString").String()))
+	}
+	subtle := js.Global.Get("crypto").Get("subtle")
+	subtle.Call("importKey", "raw", passphrase, "PBKDF2", false, []string{"deriveBits"}).Call("then", func(base *js.Object) {
+		params := js.M{
+			"name":       "PBKDF2",
+			"hash":       "SHA-256",
+			"salt":       salt,
+			"iterations": iterations,
+		}
+		subtle.Call("deriveBits", params, base, keyLen*8).Call("then", func(bits *js.Object) {
+			callback(js.Global.Get("Uint8Array").New(bits).Interface().([]byte), nil)
+		}, fail)
+	}, fail)
+}
@@ -18,14 +18,23 @@ import (
 	"github.com/gopherjs/gopherjs/js"
 )
 
+// ExtensionURL is the URL of the extension's root reported by MessageHub.
+const ExtensionURL = "chrome-extension://fake-extension-id/"
+
 // MessageHub is a fake implementation of Chrome's messaging APIs.
 type MessageHub struct {
-	handlers []func(*js.Object, *js.Object, func(interface{})) bool
+	handlers  []func(*js.Object, *js.Object, func(interface{})) bool
+	senderURL string
+	senderTab int
 }
 
 // NewMessageHub returns a fake implementation of Chrome's messaging APIs.
+// Messages are reported as sent by the extension's options page, unless
+// another sender is set.
 func NewMessageHub() *MessageHub {
-	return &MessageHub{}
+	return &MessageHub{
+		senderURL: ExtensionURL + "html/options.html",
+	}
 }
 
 // OnMessage is a fake implementation of chrome.C.OnMessage.
@@ -33,10 +42,38 @@ func (m *MessageHub) OnMessage(callback func(header *js.Object, sender *js.Objec
 	m.handlers = append(m.handlers, callback)
 }
 
+// SetSenderURL sets the URL of the page reported as the sender of
+// subsequent messages. If empty, no sender is reported.
+func (m *MessageHub) SetSenderURL(url string) {
+	m.senderURL = url
+}
+
+// SetSenderTab sets the ID of the tab reported as the sender of subsequent
+// messages, as for messages sent by a content script. If zero, no tab is
+// reported.
+func (m *MessageHub) SetSenderTab(id int) {
+	m.senderTab = id
+}
+
+// ExtensionURL is a fake implementation of chrome.C.ExtensionURL.
+func (m *MessageHub) ExtensionURL() string {
+	return ExtensionURL
+}
+
 // SendMessage is a fake implementation of chrome.C.SendMessage.
 func (m *MessageHub) SendMessage(msg interface{}, callback func(rsp *js.Object)) {
+	var sender *js.Object
+	if m.senderURL != "" || m.senderTab != 0 {
+		sender = js.Global.Get("Object").New()
+	}
+	if m.senderURL != "" {
+		sender.Set("url", m.senderURL)
+	}
+	if m.senderTab != 0 {
+		sender.Set("tab", js.M{"id": m.senderTab, "url": m.senderURL})
+	}
 	for _, h := range m.handlers {
-		h(toJSObject(msg), nil, func(rsp interface{}) {
+		h(toJSObject(msg), sender, func(rsp interface{}) {
 			callback(toJSObject(rsp))
 		})
 	}
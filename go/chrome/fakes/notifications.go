@@ -0,0 +1,48 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+// Notification is a system notification displayed by the fake
+// implementation.
+type Notification struct {
+	Title   string
+	Message string
+}
+
+// Notifications is a fake implementation of Chrome's notifications API.
+type Notifications struct {
+	// Err is the error that should be returned when creating a
+	// notification.
+	Err error
+	// Displayed contains the notifications that have been displayed.
+	Displayed []*Notification
+}
+
+// NewNotifications returns a fake implementation of Chrome's notifications
+// API.
+func NewNotifications() *Notifications {
+	return &Notifications{}
+}
+
+// CreateNotification is a fake implementation of
+// chrome.C.CreateNotification().
+func (n *Notifications) CreateNotification(title, message string, callback func(err error)) {
+	if n.Err != nil {
+		callback(n.Err)
+		return
+	}
+	n.Displayed = append(n.Displayed, &Notification{Title: title, Message: message})
+	callback(nil)
+}
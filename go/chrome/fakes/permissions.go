@@ -0,0 +1,103 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+// Permissions is a fake implementation of Chrome's permissions API.
+type Permissions struct {
+	// Deny indicates that requests for permissions should be denied, as
+	// if the user declined them.
+	Deny bool
+	// Origins contains the origins to which access has been granted.
+	Origins map[string]bool
+	// Permissions contains the API permissions that have been granted.
+	Permissions map[string]bool
+
+	listeners []func(perms []string, added bool)
+}
+
+// NewPermissions returns a fake implementation of Chrome's permissions API.
+func NewPermissions() *Permissions {
+	return &Permissions{
+		Origins:     make(map[string]bool),
+		Permissions: make(map[string]bool),
+	}
+}
+
+// RequestOrigins is a fake implementation of chrome.C.RequestOrigins().
+func (p *Permissions) RequestOrigins(origins []string, callback func(granted bool, err error)) {
+	if p.Deny {
+		callback(false, nil)
+		return
+	}
+	for _, o := range origins {
+		p.Origins[o] = true
+	}
+	callback(true, nil)
+}
+
+// RemoveOrigins is a fake implementation of chrome.C.RemoveOrigins().
+func (p *Permissions) RemoveOrigins(origins []string, callback func(err error)) {
+	for _, o := range origins {
+		delete(p.Origins, o)
+	}
+	callback(nil)
+}
+
+// RequestPermissions is a fake implementation of chrome.C.RequestPermissions().
+func (p *Permissions) RequestPermissions(perms []string, callback func(granted bool, err error)) {
+	if p.Deny {
+		callback(false, nil)
+		return
+	}
+	for _, perm := range perms {
+		p.Permissions[perm] = true
+	}
+	p.notify(perms, true)
+	callback(true, nil)
+}
+
+// ContainsPermissions is a fake implementation of
+// chrome.C.ContainsPermissions().
+func (p *Permissions) ContainsPermissions(perms []string, callback func(granted bool, err error)) {
+	for _, perm := range perms {
+		if !p.Permissions[perm] {
+			callback(false, nil)
+			return
+		}
+	}
+	callback(true, nil)
+}
+
+// RemovePermissions is a fake implementation of chrome.C.RemovePermissions().
+func (p *Permissions) RemovePermissions(perms []string, callback func(err error)) {
+	for _, perm := range perms {
+		delete(p.Permissions, perm)
+	}
+	p.notify(perms, false)
+	callback(nil)
+}
+
+// OnPermissionsChanged is a fake implementation of
+// chrome.C.OnPermissionsChanged().
+func (p *Permissions) OnPermissionsChanged(callback func(perms []string, added bool)) {
+	p.listeners = append(p.listeners, callback)
+}
+
+// notify invokes the callbacks registered by OnPermissionsChanged().
+func (p *Permissions) notify(perms []string, added bool) {
+	for _, l := range p.listeners {
+		l(perms, added)
+	}
+}
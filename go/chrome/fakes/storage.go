@@ -16,6 +16,12 @@
 // ease unit testing.
 package fakes
 
+import (
+	"errors"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
 // Errs contains errors that should be returned by the fake implementation.
 type Errs struct {
 	// Get is the error that should be returned by Storage.Get().
@@ -28,8 +34,10 @@ type Errs struct {
 
 // MemStorage is a fake implementation of Chrome's storage API.
 type MemStorage struct {
-	data map[string]interface{}
-	err  Errs
+	data         map[string]interface{}
+	err          Errs
+	quotaTotal   int
+	quotaPerItem int
 }
 
 // NewMemStorage returns a fake implementation of Chrome's storage API.
@@ -46,6 +54,20 @@ func (m *MemStorage) SetError(err Errs) {
 	m.err = err
 }
 
+// SetQuota specifies the limits returned by Quota(). Set operations that
+// exceed the limits fail, as they would with Chrome's storage API. A limit of
+// zero indicates that there is no limit.
+func (m *MemStorage) SetQuota(total, perItem int) {
+	m.quotaTotal = total
+	m.quotaPerItem = perItem
+}
+
+// itemSize returns the space used by an item, computed in the same way as
+// Chrome's storage API.
+func itemSize(key string, value interface{}) int {
+	return len(key) + js.Global.Get("JSON").Call("stringify", value).Length()
+}
+
 // Set is a fake implmentation of chrome.Storage.Set().
 func (m *MemStorage) Set(data map[string]interface{}, callback func(err error)) {
 	if m.err.Set != nil {
@@ -53,6 +75,25 @@ func (m *MemStorage) Set(data map[string]interface{}, callback func(err error))
 		return
 	}
 
+	total := 0
+	for k, v := range m.data {
+		if _, ok := data[k]; !ok {
+			total += itemSize(k, v)
+		}
+	}
+	for k, v := range data {
+		size := itemSize(k, v)
+		if m.quotaPerItem > 0 && size > m.quotaPerItem {
+			callback(errors.New("QUOTA_BYTES_PER_ITEM quota exceeded"))
+			return
+		}
+		total += size
+	}
+	if m.quotaTotal > 0 && total > m.quotaTotal {
+		callback(errors.New("QUOTA_BYTES quota exceeded"))
+		return
+	}
+
 	for k, v := range data {
 		m.data[k] = toJSObject(v).Interface()
 	}
@@ -70,6 +111,22 @@ func (m *MemStorage) Get(callback func(data map[string]interface{}, err error))
 	callback(m.data, nil)
 }
 
+// GetItems is a fake implementation of chrome.Storage.GetItems().
+func (m *MemStorage) GetItems(keys []string, callback func(data map[string]interface{}, err error)) {
+	if m.err.Get != nil {
+		callback(nil, m.err.Get)
+		return
+	}
+
+	result := make(map[string]interface{})
+	for _, k := range keys {
+		if v, ok := m.data[k]; ok {
+			result[k] = v
+		}
+	}
+	callback(result, nil)
+}
+
 // Delete is a fake implmentation of chrome.Storage.Delete().
 func (m *MemStorage) Delete(keys []string, callback func(err error)) {
 	if m.err.Delete != nil {
@@ -82,3 +139,24 @@ func (m *MemStorage) Delete(keys []string, callback func(err error)) {
 	}
 	callback(nil)
 }
+
+// BytesInUse is a fake implementation of chrome.Storage.BytesInUse().
+func (m *MemStorage) BytesInUse(keys []string, callback func(bytes int, err error)) {
+	total := 0
+	if keys == nil {
+		for k, v := range m.data {
+			total += itemSize(k, v)
+		}
+	}
+	for _, k := range keys {
+		if v, ok := m.data[k]; ok {
+			total += itemSize(k, v)
+		}
+	}
+	callback(total, nil)
+}
+
+// Quota is a fake implementation of chrome.Storage.Quota().
+func (m *MemStorage) Quota() (total, perItem int) {
+	return m.quotaTotal, m.quotaPerItem
+}
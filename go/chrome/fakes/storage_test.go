@@ -150,3 +150,25 @@ func TestFunctions(t *testing.T) {
 		}
 	}
 }
+
+func TestGetItems(t *testing.T) {
+	m := NewMemStorage()
+	m.Set(map[string]interface{}{"key1": 42, "key2": "bar", "key3": true}, func(err error) {
+		if err != nil {
+			t.Fatalf("failed to set data: %v", err)
+		}
+	})
+
+	m.GetItems([]string{"key1", "key3", "missing"}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			t.Errorf("failed to get items: %v", err)
+		}
+		want := map[string]interface{}{
+			"key1": 42.0,
+			"key3": true,
+		}
+		if diff := pretty.Diff(data, want); diff != nil {
+			t.Errorf("incorrect items; -got +want: %s", diff)
+		}
+	})
+}
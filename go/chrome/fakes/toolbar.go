@@ -0,0 +1,47 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+// Toolbar is a fake implementation of the extension's toolbar icon, as
+// controlled by Chrome's browser action API.
+type Toolbar struct {
+	// Badge is the text displayed on the badge.
+	Badge string
+	// Color is the badge's background color.
+	Color string
+	// Title is the icon's tooltip.
+	Title string
+}
+
+// NewToolbar returns a fake implementation of the extension's toolbar icon.
+func NewToolbar() *Toolbar {
+	return &Toolbar{}
+}
+
+// SetBadgeText is a fake implementation of chrome.C.SetBadgeText().
+func (t *Toolbar) SetBadgeText(text string) {
+	t.Badge = text
+}
+
+// SetBadgeBackgroundColor is a fake implementation of
+// chrome.C.SetBadgeBackgroundColor().
+func (t *Toolbar) SetBadgeBackgroundColor(color string) {
+	t.Color = color
+}
+
+// SetTitle is a fake implementation of chrome.C.SetTitle().
+func (t *Toolbar) SetTitle(title string) {
+	t.Title = title
+}
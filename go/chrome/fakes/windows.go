@@ -0,0 +1,90 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"fmt"
+)
+
+// Window is a popup window opened by the fake implementation.
+type Window struct {
+	ID     int
+	Page   string
+	Width  int
+	Height int
+	// Focused is the number of times the window was brought to the
+	// front after it was opened.
+	Focused int
+}
+
+// Windows is a fake implementation of Chrome's windows API.
+type Windows struct {
+	// Err is the error that should be returned when creating a window.
+	Err error
+	// Open contains the windows that are open, keyed by ID.
+	Open map[int]*Window
+	// Created is the number of windows that have been opened.
+	Created int
+	next    int
+	removed []func(windowID int)
+}
+
+// NewWindows returns a fake implementation of Chrome's windows API.
+func NewWindows() *Windows {
+	return &Windows{
+		Open: make(map[int]*Window),
+		next: 1,
+	}
+}
+
+// CreatePopup is a fake implementation of chrome.C.CreatePopup().
+func (w *Windows) CreatePopup(page string, width, height int, callback func(windowID int, err error)) {
+	if w.Err != nil {
+		callback(0, w.Err)
+		return
+	}
+	win := &Window{ID: w.next, Page: page, Width: width, Height: height}
+	w.next++
+	w.Created++
+	w.Open[win.ID] = win
+	callback(win.ID, nil)
+}
+
+// FocusWindow is a fake implementation of chrome.C.FocusWindow().
+func (w *Windows) FocusWindow(windowID int, callback func(err error)) {
+	win, ok := w.Open[windowID]
+	if !ok {
+		callback(fmt.Errorf("no window with id: %d", windowID))
+		return
+	}
+	win.Focused++
+	callback(nil)
+}
+
+// OnWindowRemoved is a fake implementation of chrome.C.OnWindowRemoved().
+func (w *Windows) OnWindowRemoved(callback func(windowID int)) {
+	w.removed = append(w.removed, callback)
+}
+
+// Close simulates the user closing the window with the specified ID.
+func (w *Windows) Close(windowID int) {
+	if _, ok := w.Open[windowID]; !ok {
+		return
+	}
+	delete(w.Open, windowID)
+	for _, r := range w.removed {
+		r(windowID)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chrome
+
+import (
+	"fmt"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// HTTPError is returned when a server responds to a request with an
+// unsuccessful status.
+type HTTPError struct {
+	// Status is the HTTP status of the response.
+	Status int
+}
+
+// Error implements error.Error.
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("request failed with status %d", e.Status)
+}
+
+// HTTPStatus returns the HTTP status of the response.
+func (e *HTTPError) HTTPStatus() int {
+	return e.Status
+}
+
+// Fetch retrieves the document at the specified URL.  Cookies are never
+// sent, and cached copies are revalidated.  callback is invoked with the
+// body of the response; a response with an unsuccessful status is treated as
+// an error.
+//
+// See https://developer.mozilla.org/en-US/docs/Web/API/WindowOrWorkerGlobalScope/fetch.
+func (c *C) Fetch(url string, callback func(body []byte, err error)) {
+	fail := func(reason *js.Object) {
+		callback(nil, fmt.Errorf("request failed: %s", reason.Call("toString").String()))
+	}
+	opts := js.M{"credentials": "omit", "cache": "no-cache"}
+	js.Global.Call("fetch", url, opts).Call("then", func(rsp *js.Object) {
+		if !rsp.Get("ok").Bool() {
+			callback(nil, &HTTPError{Status: rsp.Get("status").Int()})
+			return
+		}
+		rsp.Call("text").Call("then", func(text string) {
+			callback([]byte(text), nil)
+		}, fail)
+	}, fail)
+}
+
+// Post sends body, a JSON document, to the specified URL.  Cookies are never
+// sent.  callback is invoked with the body of the response; a response with
+// an unsuccessful status is treated as an error.
+func (c *C) Post(url string, body []byte, callback func(body []byte, err error)) {
+	fail := func(reason *js.Object) {
+		callback(nil, fmt.Errorf("request failed: %s", reason.Call("toString").String()))
+	}
+	opts := js.M{
+		"method":      "POST",
+		"credentials": "omit",
+		"cache":       "no-store",
+		"headers":     js.M{"Content-Type": "application/json"},
+		"body":        string(body),
+	}
+	js.Global.Call("fetch", url, opts).Call("then", func(rsp *js.Object) {
+		if !rsp.Get("ok").Bool() {
+			callback(nil, &HTTPError{Status: rsp.Get("status").Int()})
+			return
+		}
+		rsp.Call("text").Call("then", func(text string) {
+			callback([]byte(text), nil)
+		}, fail)
+	}, fail)
+}
+
+// Online determines if the browser is connected to a network.
+//
+// See https://developer.mozilla.org/en-US/docs/Web/API/NavigatorOnLine/onLine.
+func (c *C) Online() bool {
+	return js.Global.Get("navigator").Get("onLine").Bool()
+}
+
+// OnOnlineChanged installs a callback that will be invoked when the browser
+// connects to or disconnects from a network.
+//
+// See https://developer.mozilla.org/en-US/docs/Web/API/Window/online_event.
+func (c *C) OnOnlineChanged(callback func(online bool)) {
+	js.Global.Call("addEventListener", "online", func() {
+		callback(true)
+	})
+	js.Global.Call("addEventListener", "offline", func() {
+		callback(false)
+	})
+}
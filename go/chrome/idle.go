@@ -0,0 +1,53 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chrome
+
+import (
+	"time"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// idle returns the chrome.idle API, or nil if it is unavailable.
+func (c *C) idle() *js.Object {
+	idle := c.chrome.Get("idle")
+	if idle == nil || idle == js.Undefined {
+		return nil
+	}
+	return idle
+}
+
+// SetIdleDetectionInterval sets how long the machine must go unused before
+// it is reported as idle.  Chrome requires at least 15 seconds.
+//
+// See https://developer.chrome.com/extensions/idle#method-setDetectionInterval.
+func (c *C) SetIdleDetectionInterval(interval time.Duration) {
+	if idle := c.idle(); idle != nil {
+		idle.Call("setDetectionInterval", int(interval/time.Second))
+	}
+}
+
+// OnIdleStateChanged installs a callback that will be invoked when the
+// machine becomes active, idle or locked.  state is one of 'active', 'idle'
+// or 'locked'.
+//
+// See https://developer.chrome.com/extensions/idle#event-onStateChanged.
+func (c *C) OnIdleStateChanged(callback func(state string)) {
+	if idle := c.idle(); idle != nil {
+		idle.Get("onStateChanged").Call("addListener", func(state string) {
+			callback(state)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chrome
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// ExtensionName returns the name of the installed extension with the
+// specified ID.  It requires the 'management' permission.  callback is
+// invoked with the result.
+//
+// See https://developer.chrome.com/extensions/management#method-get.
+func (c *C) ExtensionName(id string, callback func(name string, err error)) {
+	// chrome.management is only available once the permission has been
+	// granted.
+	management := c.chrome.Get("management")
+	if management == nil || management == js.Undefined {
+		callback("", errors.New("permission to read extension names has not been granted"))
+		return
+	}
+	management.Call("get", id, func(info *js.Object) {
+		if err := c.Error(); err != nil {
+			callback("", fmt.Errorf("failed to get extension: %v", err))
+			return
+		}
+		callback(info.Get("name").String(), nil)
+	})
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chrome
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+const (
+	// notificationIcon is the icon displayed alongside system
+	// notifications, relative to the extension's root.
+	notificationIcon = "img/icon128.png"
+)
+
+// CreateNotification displays a system notification with the specified
+// title and message.  callback is invoked when complete.
+//
+// See https://developer.chrome.com/apps/notifications#method-create.
+func (c *C) CreateNotification(title, message string, callback func(err error)) {
+	// chrome.notifications is only available in extension pages, and
+	// only once the optional 'notifications' permission is granted.  Look
+	// it up on each call since the permission may be granted at runtime.
+	notifications := c.chrome.Get("notifications")
+	if notifications == nil || notifications == js.Undefined {
+		callback(errors.New("notifications are not available"))
+		return
+	}
+	opts := js.M{
+		"type":    "basic",
+		"iconUrl": notificationIcon,
+		"title":   title,
+		"message": message,
+	}
+	notifications.Call("create", opts, func(id string) {
+		if err := c.Error(); err != nil {
+			callback(fmt.Errorf("failed to create notification: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chrome
+
+import (
+	"fmt"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// RequestOrigins requests access to the specified origins (e.g.,
+// 'https://example.com/*').  It must be invoked in response to a user
+// gesture.  callback is invoked with an indication of whether access was
+// granted.
+//
+// See https://developer.chrome.com/extensions/permissions#method-request.
+func (c *C) RequestOrigins(origins []string, callback func(granted bool, err error)) {
+	c.permissions.Call("request", js.M{"origins": origins}, func(granted bool) {
+		if err := c.Error(); err != nil {
+			callback(false, fmt.Errorf("failed to request permissions: %v", err))
+			return
+		}
+		callback(granted, nil)
+	})
+}
+
+// RemoveOrigins relinquishes access to the specified origins.  callback is
+// invoked when complete.
+//
+// See https://developer.chrome.com/extensions/permissions#method-remove.
+func (c *C) RemoveOrigins(origins []string, callback func(err error)) {
+	c.permissions.Call("remove", js.M{"origins": origins}, func(removed bool) {
+		if err := c.Error(); err != nil {
+			callback(fmt.Errorf("failed to remove permissions: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// RequestPermissions requests the specified API permissions (e.g.,
+// 'notifications').  It must be invoked in response to a user gesture.
+// callback is invoked with an indication of whether the permissions were
+// granted.
+//
+// See https://developer.chrome.com/extensions/permissions#method-request.
+func (c *C) RequestPermissions(perms []string, callback func(granted bool, err error)) {
+	c.permissions.Call("request", js.M{"permissions": perms}, func(granted bool) {
+		if err := c.Error(); err != nil {
+			callback(false, fmt.Errorf("failed to request permissions: %v", err))
+			return
+		}
+		callback(granted, nil)
+	})
+}
+
+// ContainsPermissions checks whether the extension holds the specified API
+// permissions.  callback is invoked with the result.
+//
+// See https://developer.chrome.com/extensions/permissions#method-contains.
+func (c *C) ContainsPermissions(perms []string, callback func(granted bool, err error)) {
+	c.permissions.Call("contains", js.M{"permissions": perms}, func(granted bool) {
+		if err := c.Error(); err != nil {
+			callback(false, fmt.Errorf("failed to check permissions: %v", err))
+			return
+		}
+		callback(granted, nil)
+	})
+}
+
+// RemovePermissions relinquishes the specified API permissions.  callback
+// is invoked when complete.
+//
+// See https://developer.chrome.com/extensions/permissions#method-remove.
+func (c *C) RemovePermissions(perms []string, callback func(err error)) {
+	c.permissions.Call("remove", js.M{"permissions": perms}, func(removed bool) {
+		if err := c.Error(); err != nil {
+			callback(fmt.Errorf("failed to remove permissions: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// OnPermissionsChanged installs a callback that will be invoked when API
+// permissions are granted or relinquished.  added indicates whether the
+// permissions were granted.
+//
+// See https://developer.chrome.com/extensions/permissions#event-onAdded.
+func (c *C) OnPermissionsChanged(callback func(perms []string, added bool)) {
+	listen := func(event string, added bool) {
+		c.permissions.Get(event).Call("addListener", func(p *js.Object) {
+			var perms []string
+			if l := p.Get("permissions"); l != js.Undefined && l != nil {
+				for i := 0; i < l.Length(); i++ {
+					perms = append(perms, l.Index(i).String())
+				}
+			}
+			callback(perms, added)
+		})
+	}
+	listen("onAdded", true)
+	listen("onRemoved", false)
+}
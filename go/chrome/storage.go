@@ -24,6 +24,8 @@ import (
 type Storage struct {
 	chrome *C
 	o      *js.Object
+	// area is the name of the storage area (e.g., 'sync').
+	area string
 }
 
 // Set stores new data in storage. data is a map of key-value pairs to be
@@ -58,6 +60,22 @@ func (s *Storage) Get(callback func(data map[string]interface{}, err error)) {
 	})
 }
 
+// GetItems reads the data items with the specified keys.  Keys that are not
+// found in storage are omitted from the result.  The callback will be invoked
+// when complete, supplying the items read and indicating any errors.
+//
+// See get() in https://developer.chrome.com/apps/storage#type-StorageArea.
+func (s *Storage) GetItems(keys []string, callback func(data map[string]interface{}, err error)) {
+	s.o.Call("get", keys, func(vals interface{}) {
+		if err := s.chrome.Error(); err != nil {
+			callback(nil, fmt.Errorf("failed to get data: %v", err))
+			return
+		}
+
+		callback(vals.(map[string]interface{}), nil)
+	})
+}
+
 // Delete removes the items from storage with the specified keys. If a key is
 // not found in storage, it will be silently ignored (i.e., no error will be
 // returned). Callback is invoked when complete.
@@ -73,3 +91,53 @@ func (s *Storage) Delete(keys []string, callback func(err error)) {
 		callback(nil)
 	})
 }
+
+// BytesInUse gets the amount of space (in bytes) used by the items with the
+// specified keys.  If keys is nil, the space used by all items is returned.
+// Callback is invoked when complete.
+//
+// See getBytesInUse() in https://developer.chrome.com/apps/storage#type-StorageArea.
+func (s *Storage) BytesInUse(keys []string, callback func(bytes int, err error)) {
+	var k interface{}
+	if keys != nil {
+		k = keys
+	}
+	s.o.Call("getBytesInUse", k, func(bytes int) {
+		if err := s.chrome.Error(); err != nil {
+			callback(0, fmt.Errorf("failed to get bytes in use: %v", err))
+			return
+		}
+		callback(bytes, nil)
+	})
+}
+
+// Quota returns the maximum amount of space (in bytes) that may be used by
+// all items in total, and by each individual item. A limit of zero indicates
+// that there is no limit.
+//
+// See QUOTA_BYTES and QUOTA_BYTES_PER_ITEM in
+// https://developer.chrome.com/apps/storage#properties.
+func (s *Storage) Quota() (total, perItem int) {
+	if q := s.o.Get("QUOTA_BYTES"); q != js.Undefined {
+		total = q.Int()
+	}
+	if q := s.o.Get("QUOTA_BYTES_PER_ITEM"); q != js.Undefined {
+		perItem = q.Int()
+	}
+	return total, perItem
+}
+
+// OnChanged installs a callback that will be invoked when items in storage
+// change, including changes delivered by Chrome Sync from another device.
+// changes is a map from each changed key to an object with 'oldValue' and
+// 'newValue' fields; either field is absent if the item was added or removed.
+//
+// See https://developer.chrome.com/apps/storage#event-onChanged.
+func (s *Storage) OnChanged(callback func(changes map[string]interface{})) {
+	s.chrome.storage.Get("onChanged").Call("addListener", func(changes map[string]interface{}, areaName string) {
+		if areaName != s.area {
+			return
+		}
+		callback(changes)
+	})
+}
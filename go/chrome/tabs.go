@@ -0,0 +1,57 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chrome
+
+import (
+	"fmt"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// OnTabUpdated installs a callback that will be invoked when a tab finishes
+// loading a page.  url is the URL of the page; it is only available if the
+// extension has permission to access the page.
+//
+// See https://developer.chrome.com/extensions/tabs#event-onUpdated.
+func (c *C) OnTabUpdated(callback func(tabID int, url string)) {
+	if c.tabs == nil || c.tabs == js.Undefined {
+		// chrome.tabs is not available in content scripts.
+		return
+	}
+	c.tabs.Get("onUpdated").Call("addListener", func(tabID int, changeInfo *js.Object, tab *js.Object) {
+		if changeInfo.Get("status").String() != "complete" {
+			return
+		}
+		url := tab.Get("url")
+		if url == js.Undefined {
+			return
+		}
+		callback(tabID, url.String())
+	})
+}
+
+// ExecuteScript injects the script contained in file into the page displayed
+// in the specified tab.  callback is invoked when complete.
+//
+// See https://developer.chrome.com/extensions/tabs#method-executeScript.
+func (c *C) ExecuteScript(tabID int, file string, callback func(err error)) {
+	c.tabs.Call("executeScript", tabID, js.M{"file": file}, func() {
+		if err := c.Error(); err != nil {
+			callback(fmt.Errorf("failed to execute script: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
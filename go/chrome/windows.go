@@ -0,0 +1,91 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chrome
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// windows returns a reference to 'chrome.windows', or nil if it is not
+// available (e.g., in content scripts).
+func (c *C) windows() *js.Object {
+	w := c.chrome.Get("windows")
+	if w == nil || w == js.Undefined {
+		return nil
+	}
+	return w
+}
+
+// CreatePopup opens the specified page of the extension, relative to the
+// extension's root, in a new popup window of the specified size.  callback
+// is invoked with the ID of the window.
+//
+// See https://developer.chrome.com/extensions/windows#method-create.
+func (c *C) CreatePopup(page string, width, height int, callback func(windowID int, err error)) {
+	w := c.windows()
+	if w == nil {
+		callback(0, errors.New("windows are not available"))
+		return
+	}
+	opts := js.M{
+		"url":     c.runtime.Call("getURL", page).String(),
+		"type":    "popup",
+		"width":   width,
+		"height":  height,
+		"focused": true,
+	}
+	w.Call("create", opts, func(win *js.Object) {
+		if err := c.Error(); err != nil {
+			callback(0, fmt.Errorf("failed to create window: %v", err))
+			return
+		}
+		callback(win.Get("id").Int(), nil)
+	})
+}
+
+// FocusWindow brings the window with the specified ID to the front.
+// callback is invoked when complete; it fails if the window no longer
+// exists.
+//
+// See https://developer.chrome.com/extensions/windows#method-update.
+func (c *C) FocusWindow(windowID int, callback func(err error)) {
+	w := c.windows()
+	if w == nil {
+		callback(errors.New("windows are not available"))
+		return
+	}
+	w.Call("update", windowID, js.M{"focused": true}, func(win *js.Object) {
+		if err := c.Error(); err != nil {
+			callback(fmt.Errorf("failed to focus window: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// OnWindowRemoved installs a callback that will be invoked when a window is
+// closed.
+//
+// See https://developer.chrome.com/extensions/windows#event-onRemoved.
+func (c *C) OnWindowRemoved(callback func(windowID int)) {
+	w := c.windows()
+	if w == nil {
+		return
+	}
+	w.Get("onRemoved").Call("addListener", callback)
+}
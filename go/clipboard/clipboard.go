@@ -0,0 +1,108 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package clipboard copies text to the clipboard, and clears it again after
+// a delay so that copied secrets do not linger.
+package clipboard
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+const (
+	// DefaultClearDelay is how long copied text remains on the clipboard
+	// before it is cleared.
+	DefaultClearDelay = 30 * time.Second
+)
+
+// API provides access to the clipboard.  See dom.DOM for details on the
+// methods; using this interface allows for alternate implementations during
+// testing.
+type API interface {
+	// CopyToClipboard copies the value of an input to the clipboard. See
+	// dom.DOM.CopyToClipboard() for details.
+	CopyToClipboard(o *js.Object) bool
+
+	// ClearClipboard clears the clipboard. See dom.DOM.ClearClipboard()
+	// for details.
+	ClearClipboard() bool
+}
+
+// Clipboard copies text to the clipboard, and clears the clipboard once the
+// delay has elapsed since the most recent copy.
+//
+// The clipboard cannot be read without an additional permission, so it is
+// cleared even if other text was copied in the meantime.  It is not cleared
+// if the page is closed before the delay elapses.
+type Clipboard struct {
+	api     API
+	delay   time.Duration
+	cleared func(ok bool)
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// New returns a Clipboard that clears text copied through api once delay has
+// elapsed.  cleared is invoked each time the clipboard is cleared; ok is
+// false if the browser did not permit it.
+func New(api API, delay time.Duration, cleared func(ok bool)) *Clipboard {
+	return &Clipboard{
+		api:     api,
+		delay:   delay,
+		cleared: cleared,
+	}
+}
+
+// Copy copies the value of a text input or textarea to the clipboard, and
+// schedules the clipboard to be cleared.  A pending clear is postponed, so
+// that the most recently copied text remains for the full delay.  It must be
+// invoked in response to a user gesture.  Returns false if the browser did
+// not permit the copy, in which case nothing is scheduled.
+func (c *Clipboard) Copy(o *js.Object) bool {
+	if !c.api.CopyToClipboard(o) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(c.delay, func() {
+		c.mu.Lock()
+		current := c.timer == timer
+		if current {
+			c.timer = nil
+		}
+		c.mu.Unlock()
+		// A timer that fired just as it was replaced is ignored.
+		if current {
+			c.cleared(c.api.ClearClipboard())
+		}
+	})
+	c.timer = timer
+	return true
+}
+
+// Pending indicates if the clipboard is scheduled to be cleared.
+func (c *Clipboard) Pending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.timer != nil
+}
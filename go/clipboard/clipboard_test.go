@@ -0,0 +1,149 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clipboard
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopherjs/gopherjs/js"
+	"github.com/kr/pretty"
+)
+
+type fakeAPI struct {
+	mu      sync.Mutex
+	copyErr bool
+	clearOk bool
+	copies  int
+	clears  int
+}
+
+func (f *fakeAPI) CopyToClipboard(o *js.Object) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.copyErr {
+		return false
+	}
+	f.copies++
+	return true
+}
+
+func (f *fakeAPI) ClearClipboard() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clears++
+	return f.clearOk
+}
+
+func TestCopy(t *testing.T) {
+	testcases := []struct {
+		description string
+		copyErr     bool
+		clearOk     bool
+		copies      int
+		wantCopied  bool
+		wantCleared []bool
+		wantClears  int
+	}{
+		{
+			description: "cleared after copy",
+			clearOk:     true,
+			copies:      1,
+			wantCopied:  true,
+			wantCleared: []bool{true},
+			wantClears:  1,
+		},
+		{
+			description: "cleared once after repeated copies",
+			clearOk:     true,
+			copies:      3,
+			wantCopied:  true,
+			wantCleared: []bool{true},
+			wantClears:  1,
+		},
+		{
+			description: "clear refused",
+			clearOk:     false,
+			copies:      1,
+			wantCopied:  true,
+			wantCleared: []bool{false},
+			wantClears:  1,
+		},
+		{
+			description: "copy refused",
+			copyErr:     true,
+			copies:      1,
+			wantCopied:  false,
+		},
+	}
+
+	for _, tc := range testcases {
+		api := &fakeAPI{copyErr: tc.copyErr, clearOk: tc.clearOk}
+		clearedc := make(chan bool, 10)
+		c := New(api, 10*time.Millisecond, func(ok bool) {
+			clearedc <- ok
+		})
+
+		var copied bool
+		for i := 0; i < tc.copies; i++ {
+			copied = c.Copy(nil)
+		}
+		if diff := pretty.Diff(copied, tc.wantCopied); diff != nil {
+			t.Errorf("%s: incorrect copy result; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(c.Pending(), tc.wantCopied); diff != nil {
+			t.Errorf("%s: incorrect pending state; -got +want: %s", tc.description, diff)
+		}
+
+		var cleared []bool
+		timeout := time.After(100 * time.Millisecond)
+	wait:
+		for {
+			select {
+			case ok := <-clearedc:
+				cleared = append(cleared, ok)
+			case <-timeout:
+				break wait
+			}
+		}
+		if diff := pretty.Diff(cleared, tc.wantCleared); diff != nil {
+			t.Errorf("%s: incorrect clears reported; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(api.clears, tc.wantClears); diff != nil {
+			t.Errorf("%s: incorrect number of clears; -got +want: %s", tc.description, diff)
+		}
+		if c.Pending() {
+			t.Errorf("%s: clear still pending", tc.description)
+		}
+	}
+}
+
+func TestCopyPostponesClear(t *testing.T) {
+	api := &fakeAPI{clearOk: true}
+	clearedc := make(chan bool, 10)
+	c := New(api, 50*time.Millisecond, func(ok bool) {
+		clearedc <- ok
+	})
+
+	start := time.Now()
+	c.Copy(nil)
+	time.Sleep(30 * time.Millisecond)
+	c.Copy(nil)
+	<-clearedc
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("clipboard cleared too soon after the second copy: %v", elapsed)
+	}
+}
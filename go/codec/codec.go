@@ -0,0 +1,391 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package codec converts Go values to and from the generic values that may be
+// exchanged with Chrome's messaging and storage APIs: objects
+// (map[string]interface{}), lists ([]interface{}), strings, numbers
+// (float64), booleans and nil.
+//
+// Structs are converted to objects.  Only exported fields are converted; the
+// name of each property is taken from the field's 'codec' tag, or is the
+// field name with its first letter in lower case if there is no tag.  A tag
+// of "-" skips the field, and the "omitempty" option omits the property if
+// the field has its zero value:
+//
+//	type Key struct {
+//		ID      string `codec:"id"`
+//		Comment string `codec:"comment,omitempty"`
+//		Scratch string `codec:"-"`
+//	}
+//
+// Some types are converted specially, since Chrome's APIs do not preserve
+// them:
+//   - []byte is converted to a base64-encoded string.
+//   - time.Time is converted to the number of milliseconds since the Unix
+//     epoch.  The zero time is converted to zero.
+//
+// The conversion is deterministic: encoding a value, passing it through
+// Chrome, and decoding it yields an equal value.
+package codec
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	bytesType = reflect.TypeOf([]byte(nil))
+	timeType  = reflect.TypeOf(time.Time{})
+)
+
+// field describes a struct field that is converted to an object property.
+type field struct {
+	// index is the index of the field within the struct.
+	index int
+	// name is the name of the property.
+	name string
+	// omitEmpty indicates that the property is omitted if the field has
+	// its zero value.
+	omitEmpty bool
+}
+
+// fields returns the fields of the specified struct type that are converted
+// to object properties.
+func fields(t reflect.Type) []field {
+	var result []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		tag := f.Tag.Get("codec")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		fd := field{index: i, name: parts[0]}
+		if fd.name == "" {
+			r, n := utf8.DecodeRuneInString(f.Name)
+			fd.name = string(unicode.ToLower(r)) + f.Name[n:]
+		}
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				fd.omitEmpty = true
+			}
+		}
+		result = append(result, fd)
+	}
+	return result
+}
+
+// isEmpty determines if v has its zero value.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
+
+// Encode converts v to a value that may be exchanged with Chrome's APIs.
+func Encode(v interface{}) (interface{}, error) {
+	return encode(reflect.ValueOf(v), "value")
+}
+
+// encode converts v to a value that may be exchanged with Chrome's APIs.
+// path describes the location of v within the value being encoded, and is
+// used in error messages.
+func encode(v reflect.Value, path string) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return float64(0), nil
+		}
+		return float64(t.UnixNano() / int64(time.Millisecond)), nil
+	case bytesType:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return base64.StdEncoding.EncodeToString(v.Bytes()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return encode(v.Elem(), path)
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		fallthrough
+	case reflect.Array:
+		result := make([]interface{}, v.Len())
+		for i := range result {
+			e, err := encode(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			result[i] = e
+		}
+		return result, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("%s: map key must be a string, not %s", path, v.Type().Key())
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		result := make(map[string]interface{})
+		for _, k := range v.MapKeys() {
+			e, err := encode(v.MapIndex(k), fmt.Sprintf("%s[%q]", path, k.String()))
+			if err != nil {
+				return nil, err
+			}
+			result[k.String()] = e
+		}
+		return result, nil
+	case reflect.Struct:
+		result := make(map[string]interface{})
+		for _, f := range fields(v.Type()) {
+			fv := v.Field(f.index)
+			if f.omitEmpty && isEmpty(fv) {
+				continue
+			}
+			e, err := encode(fv, path+"."+f.name)
+			if err != nil {
+				return nil, err
+			}
+			result[f.name] = e
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported type %s", path, v.Type())
+}
+
+// Decode converts v, a value received from Chrome's APIs, and stores the
+// result in the value pointed to by out.  Properties of objects that do not
+// correspond to a struct field are ignored, and fields for which there is
+// no property are left unchanged.  An error is returned if v does not match
+// the type of out.
+func Decode(v interface{}, out interface{}) error {
+	o := reflect.ValueOf(out)
+	if o.Kind() != reflect.Ptr || o.IsNil() {
+		return fmt.Errorf("cannot decode into %T; must be a non-nil pointer", out)
+	}
+	return decode(v, o.Elem(), "value")
+}
+
+// number returns v as a float64, if it is a number.
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// typeError returns an error indicating that v cannot be decoded into a value
+// of type t.
+func typeError(path string, v interface{}, t reflect.Type) error {
+	return fmt.Errorf("%s: cannot decode %T into %s", path, v, t)
+}
+
+// decode converts v and stores the result in out.  path describes the
+// location of out within the value being decoded, and is used in error
+// messages.
+func decode(v interface{}, out reflect.Value, path string) error {
+	t := out.Type()
+	if v == nil {
+		// Absent and null values leave the destination unchanged,
+		// except that pointers, slices and maps are cleared.
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			out.Set(reflect.Zero(t))
+		}
+		return nil
+	}
+
+	switch t {
+	case timeType:
+		n, ok := number(v)
+		if !ok {
+			return typeError(path, v, t)
+		}
+		if n == 0 {
+			out.Set(reflect.ValueOf(time.Time{}))
+			return nil
+		}
+		out.Set(reflect.ValueOf(time.Unix(0, int64(n)*int64(time.Millisecond))))
+		return nil
+	case bytesType:
+		s, ok := v.(string)
+		if !ok {
+			return typeError(path, v, t)
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		out.SetBytes(b)
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return typeError(path, v, t)
+		}
+		out.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := number(v)
+		if !ok || out.OverflowInt(int64(n)) {
+			return typeError(path, v, t)
+		}
+		out.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := number(v)
+		if !ok || n < 0 || out.OverflowUint(uint64(n)) {
+			return typeError(path, v, t)
+		}
+		out.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		n, ok := number(v)
+		if !ok {
+			return typeError(path, v, t)
+		}
+		out.SetFloat(n)
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			return typeError(path, v, t)
+		}
+		out.SetString(s)
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return typeError(path, v, t)
+		}
+		out.Set(reflect.ValueOf(v))
+	case reflect.Ptr:
+		p := reflect.New(t.Elem())
+		if err := decode(v, p.Elem(), path); err != nil {
+			return err
+		}
+		out.Set(p)
+	case reflect.Slice:
+		l, ok := v.([]interface{})
+		if !ok {
+			return typeError(path, v, t)
+		}
+		s := reflect.MakeSlice(t, len(l), len(l))
+		for i, e := range l {
+			if err := decode(e, s.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		out.Set(s)
+	case reflect.Array:
+		l, ok := v.([]interface{})
+		if !ok || len(l) != t.Len() {
+			return typeError(path, v, t)
+		}
+		for i, e := range l {
+			if err := decode(e, out.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := v.(map[string]interface{})
+		if !ok || t.Key().Kind() != reflect.String {
+			return typeError(path, v, t)
+		}
+		result := reflect.MakeMap(t)
+		for k, e := range m {
+			ev := reflect.New(t.Elem()).Elem()
+			if err := decode(e, ev, fmt.Sprintf("%s[%q]", path, k)); err != nil {
+				return err
+			}
+			result.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), ev)
+		}
+		out.Set(result)
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return typeError(path, v, t)
+		}
+		for _, f := range fields(t) {
+			e, present := m[f.name]
+			if !present {
+				continue
+			}
+			if err := decode(e, out.Field(f.index), path+"."+f.name); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: unsupported type %s", path, t)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+)
+
+type kind string
+
+type inner struct {
+	Name string `codec:"name"`
+}
+
+type outer struct {
+	ID         kind              `codec:"id"`
+	Count      int64             `codec:"count"`
+	Small      uint8             `codec:"small"`
+	Ratio      float64           `codec:"ratio"`
+	Enabled    bool              `codec:"enabled"`
+	Blob       []byte            `codec:"blob"`
+	Created    time.Time         `codec:"created"`
+	Inner      *inner            `codec:"inner"`
+	Items      []*inner          `codec:"items"`
+	Labels     map[string]string `codec:"labels"`
+	Optional   string            `codec:"optional,omitempty"`
+	Skipped    string            `codec:"-"`
+	Untagged   string
+	unexported string
+}
+
+func TestEncode(t *testing.T) {
+	testcases := []struct {
+		description string
+		value       interface{}
+		want        interface{}
+		wantErr     error
+	}{
+		{
+			description: "nil",
+			value:       nil,
+			want:        nil,
+		},
+		{
+			description: "struct",
+			value: &outer{
+				ID:         kind("id"),
+				Count:      1234567890123,
+				Small:      7,
+				Ratio:      0.5,
+				Enabled:    true,
+				Blob:       []byte{0, 1, 255},
+				Created:    time.Unix(1500000000, 0),
+				Inner:      &inner{Name: "inner"},
+				Items:      []*inner{{Name: "a"}, nil},
+				Labels:     map[string]string{"k": "v"},
+				Skipped:    "skipped",
+				Untagged:   "untagged",
+				unexported: "unexported",
+			},
+			want: map[string]interface{}{
+				"id":       "id",
+				"count":    float64(1234567890123),
+				"small":    float64(7),
+				"ratio":    0.5,
+				"enabled":  true,
+				"blob":     "AAH/",
+				"created":  float64(1500000000000),
+				"inner":    map[string]interface{}{"name": "inner"},
+				"items":    []interface{}{map[string]interface{}{"name": "a"}, nil},
+				"labels":   map[string]interface{}{"k": "v"},
+				"untagged": "untagged",
+			},
+		},
+		{
+			description: "zero struct",
+			value:       outer{},
+			want: map[string]interface{}{
+				"id":       "",
+				"count":    float64(0),
+				"small":    float64(0),
+				"ratio":    float64(0),
+				"enabled":  false,
+				"blob":     nil,
+				"created":  float64(0),
+				"inner":    nil,
+				"items":    nil,
+				"labels":   nil,
+				"untagged": "",
+			},
+		},
+		{
+			description: "unsupported map key",
+			value:       map[int]string{1: "a"},
+			wantErr:     errors.New("value: map key must be a string, not int"),
+		},
+		{
+			description: "unsupported field",
+			value: struct {
+				C chan int `codec:"c"`
+			}{},
+			wantErr: errors.New("value.c: unsupported type chan int"),
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := Encode(tc.value)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect result; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	testcases := []struct {
+		description string
+		value       interface{}
+		want        *outer
+		wantErr     error
+	}{
+		{
+			description: "struct",
+			value: map[string]interface{}{
+				"id":       "id",
+				"count":    float64(1234567890123),
+				"small":    float64(7),
+				"ratio":    0.5,
+				"enabled":  true,
+				"blob":     "AAH/",
+				"created":  float64(1500000000000),
+				"inner":    map[string]interface{}{"name": "inner"},
+				"items":    []interface{}{map[string]interface{}{"name": "a"}, nil},
+				"labels":   map[string]interface{}{"k": "v"},
+				"optional": "optional",
+				"untagged": "untagged",
+				"unknown":  "ignored",
+			},
+			want: &outer{
+				ID:       kind("id"),
+				Count:    1234567890123,
+				Small:    7,
+				Ratio:    0.5,
+				Enabled:  true,
+				Blob:     []byte{0, 1, 255},
+				Created:  time.Unix(1500000000, 0),
+				Inner:    &inner{Name: "inner"},
+				Items:    []*inner{{Name: "a"}, nil},
+				Labels:   map[string]string{"k": "v"},
+				Optional: "optional",
+				Untagged: "untagged",
+			},
+		},
+		{
+			description: "missing fields",
+			value:       map[string]interface{}{},
+			want:        &outer{},
+		},
+		{
+			description: "integers constructed in Go",
+			value:       map[string]interface{}{"count": int64(42), "small": 3},
+			want:        &outer{Count: 42, Small: 3},
+		},
+		{
+			description: "not an object",
+			value:       "garbage",
+			wantErr:     errors.New("value: cannot decode string into codec.outer"),
+		},
+		{
+			description: "incorrect field type",
+			value:       map[string]interface{}{"items": []interface{}{map[string]interface{}{"name": 42.0}}},
+			wantErr:     errors.New("value.items[0].name: cannot decode float64 into string"),
+		},
+		{
+			description: "overflow",
+			value:       map[string]interface{}{"small": 256.0},
+			wantErr:     errors.New("value.small: cannot decode float64 into uint8"),
+		},
+		{
+			description: "invalid base64",
+			value:       map[string]interface{}{"blob": "!!!"},
+			wantErr:     errors.New("value.blob: illegal base64 data at input byte 0"),
+		},
+	}
+
+	for _, tc := range testcases {
+		got := &outer{}
+		err := Decode(tc.value, got)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if err != nil {
+			continue
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect result; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestDecodeInvalidDestination(t *testing.T) {
+	var o outer
+	err := Decode(map[string]interface{}{}, o)
+	wantErr := errors.New("cannot decode into codec.outer; must be a non-nil pointer")
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := &outer{
+		ID:      kind("id"),
+		Count:   -5,
+		Blob:    []byte("blob"),
+		Created: time.Unix(1500000000, 123000000),
+		Items:   []*inner{{Name: "a"}, {Name: "b"}},
+	}
+
+	v, err := Encode(want)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	got := &outer{}
+	if err := Decode(v, got); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect result; -got +want: %s", diff)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/google/chrome-ssh-agent/go/bridge"
+	"github.com/google/chrome-ssh-agent/go/chrome"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// injectedFlag is set on the window once the content script has been
+// injected, so that repeated injections into the same page are ignored.
+const injectedFlag = "chromeSSHAgentBridge"
+
+func main() {
+	window := js.Global.Get("window")
+	if window.Get(injectedFlag) != js.Undefined {
+		return
+	}
+	window.Set(injectedFlag, true)
+
+	c := chrome.New(nil)
+	bridge.ServePage(window, bridge.NewClient(c))
+}
@@ -0,0 +1,374 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package diagnostics collects a bundle describing the state of the extension
+// that users may attach to bug reports.
+//
+// Bundles must be safe to post publicly.  They never include private key
+// material, passphrases or secrets.  Keys are identified only by a label
+// (e.g., 'key-1') that is consistent within a bundle, which also replaces
+// their IDs, fingerprints and quoted names in messages.
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/agentport"
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/notify"
+	"github.com/google/chrome-ssh-agent/go/redact"
+	"github.com/google/chrome-ssh-agent/go/selfcheck"
+	"github.com/google/chrome-ssh-agent/go/storage"
+)
+
+// Inventory describes the configured and loaded keys.  See
+// keys.Manager.Inventory() for details; using this interface allows for
+// alternate implementations during testing.
+type Inventory interface {
+	// Inventory returns metadata for all keys.
+	Inventory(callback func(items []*keys.InventoryItem, err error))
+}
+
+// DefaultSettingKeys are the keys of the settings included in a bundle.  Only
+// settings that never contain secrets or identifying information may be
+// listed.
+var DefaultSettingKeys = []string{
+	notify.SettingKey,
+	keys.LoadLifetimeKey,
+	keys.OnDemandKey,
+	agentport.ParallelismKey,
+	selfcheck.EnabledKey,
+	selfcheck.SampleKey,
+}
+
+// Sources are the sources from which a bundle is collected.
+type Sources struct {
+	// Keys provides metadata for the configured and loaded keys.
+	Keys Inventory
+	// Settings is the storage in which settings are kept.
+	Settings storage.Settings
+	// SettingKeys are the keys of the settings to include.
+	SettingKeys []string
+	// Audit is the audit log.
+	Audit *audit.Log
+	// Errors contains errors recently displayed to the user.  It may be
+	// nil.
+	Errors *ErrorLog
+	// SelfCheck is the most recent self-check report.  It may be nil.
+	SelfCheck *selfcheck.Report
+	// Upgrade is the most recent upgrade of keys from formats used by
+	// older versions (see keys.Manager.Upgrade).  It may be nil.
+	Upgrade *keys.UpgradeResult
+	// Platform describes the environment in which the extension is
+	// running.
+	Platform *Platform
+}
+
+// Key describes a key in a bundle.
+type Key struct {
+	// Label identifies the key within the bundle.
+	Label string `json:"label"`
+	// Configured indicates that the key is configured, rather than only
+	// loaded.
+	Configured bool `json:"configured"`
+	// Type is the type of the key (e.g., 'ssh-rsa'), or empty if unknown.
+	Type string `json:"type,omitempty"`
+	// Bits is the size of the key in bits, or zero if unknown.
+	Bits int `json:"bits,omitempty"`
+	// Created is the time the key was configured, or empty if unknown.
+	Created string `json:"created,omitempty"`
+	// Loaded indicates that the key is loaded into the agent.
+	Loaded bool `json:"loaded"`
+	// DeviceOnly indicates that the key is stored only on this device.
+	DeviceOnly bool `json:"deviceOnly"`
+	// Protection describes how the key is protected at rest.
+	Protection string `json:"protection,omitempty"`
+}
+
+// Event is an entry from the audit log.
+type Event struct {
+	Time      string `json:"time"`
+	Action    string `json:"action"`
+	Requester string `json:"requester,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Allowed   bool   `json:"allowed"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// Upgrade describes the keys most recently upgraded from formats used by
+// older versions of the extension.
+type Upgrade struct {
+	Time string `json:"time"`
+	// Keys are the labels of configured keys in which metadata was
+	// recorded.
+	Keys []string `json:"keys"`
+	// Loaded are the labels of loaded keys that were relabelled.
+	Loaded []string `json:"loaded"`
+}
+
+// Capabilities describes the capabilities the agent advertises to clients
+// (see agentport.CapabilitiesExtension).
+type Capabilities struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Features        []string `json:"features"`
+	Extensions      []string `json:"extensions"`
+}
+
+// Client describes the capabilities most recently negotiated by a client
+// connected to the agent.
+type Client struct {
+	Requester  string `json:"requester"`
+	Time       string `json:"time"`
+	Negotiated string `json:"negotiated"`
+}
+
+// Bundle describes the state of the extension.
+type Bundle struct {
+	// Created is the time at which the bundle was collected.
+	Created string `json:"created"`
+	// Platform describes the environment in which the extension is
+	// running.
+	Platform *Platform `json:"platform"`
+	// SchemaVersion is the version of the format in which keys are
+	// stored.
+	SchemaVersion int `json:"schemaVersion"`
+	// Settings contains the values of the included settings that are
+	// set, indexed by key.
+	Settings map[string]interface{} `json:"settings"`
+	// Capabilities describes the capabilities the agent advertises to
+	// clients.
+	Capabilities *Capabilities `json:"capabilities"`
+	// Clients describes the capabilities most recently negotiated by
+	// each client, in the order in which clients first negotiated.
+	Clients []*Client `json:"clients"`
+	// Keys describes the configured and loaded keys.
+	Keys []*Key `json:"keys"`
+	// Events are the entries in the audit log, oldest first.
+	Events []*Event `json:"events"`
+	// Errors are the errors recently displayed to the user, oldest first.
+	Errors []*Error `json:"errors"`
+	// SelfCheck is the most recent self-check report, if any.
+	SelfCheck *selfcheck.Report `json:"selfCheck,omitempty"`
+	// Upgrade describes the most recent upgrade that changed anything,
+	// if any.
+	Upgrade *Upgrade `json:"upgrade,omitempty"`
+	// Problems describes the parts of the bundle that could not be
+	// collected.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// formatMillis formats a time in milliseconds since the Unix epoch.  Zero is
+// formatted as the empty string.
+func formatMillis(ms int64) string {
+	if ms == 0 {
+		return ""
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+}
+
+// labeler replaces the names, IDs and fingerprints of keys with labels.
+type labeler struct {
+	// labels contains the label for each ID and fingerprint.
+	labels map[string]string
+	// replacer replaces IDs, fingerprints and quoted names in text.
+	replacer *strings.Replacer
+}
+
+// keyLabel returns the label for the i-th key in a bundle.
+func keyLabel(i int) string {
+	return fmt.Sprintf("key-%d", i+1)
+}
+
+// newLabeler returns a labeler for the specified keys, which are assigned
+// labels in order.
+func newLabeler(items []*keys.InventoryItem) *labeler {
+	l := &labeler{labels: make(map[string]string)}
+	var pairs []string
+	for i, item := range items {
+		label := keyLabel(i)
+		for _, s := range []string{string(item.ID), item.Fingerprint, item.FingerprintMD5} {
+			if s == "" || s == string(keys.InvalidID) {
+				continue
+			}
+			l.labels[s] = label
+			pairs = append(pairs, s, label)
+		}
+		// Names are short and may be ordinary words, so only quoted
+		// names are replaced.
+		if item.Name != "" {
+			pairs = append(pairs, strconv.Quote(item.Name), strconv.Quote(label))
+		}
+	}
+	l.replacer = strings.NewReplacer(pairs...)
+	return l
+}
+
+// label returns the label for a key identified by ID or fingerprint.  Keys
+// that are not known are replaced by a placeholder.
+func (l *labeler) label(key string) string {
+	if key == "" {
+		return ""
+	}
+	if label, ok := l.labels[key]; ok {
+		return label
+	}
+	return "unknown-key"
+}
+
+// text returns s with sensitive material redacted and keys replaced by their
+// labels.
+func (l *labeler) text(s string) string {
+	return l.replacer.Replace(redact.String(s))
+}
+
+// Collect collects a bundle from the specified sources.  now is the time at
+// which it is collected.  Sources that cannot be read are described in
+// Bundle.Problems, so that a bundle can be collected even if the extension
+// is not working correctly.  callback is invoked with the result.
+func Collect(src *Sources, now time.Time, callback func(b *Bundle)) {
+	b := &Bundle{
+		Created:       now.UTC().Format(time.RFC3339),
+		Platform:      src.Platform,
+		SchemaVersion: keys.SchemaVersion,
+		Settings:      make(map[string]interface{}),
+		Clients:       []*Client{},
+		Keys:          []*Key{},
+		Events:        []*Event{},
+		Errors:        []*Error{},
+		Capabilities: &Capabilities{
+			ProtocolVersion: agentport.ProtocolVersion,
+			Features:        agentport.Features,
+			Extensions:      agentport.Extensions,
+		},
+	}
+	problem := func(format string, args ...interface{}) {
+		b.Problems = append(b.Problems, redact.String(fmt.Sprintf(format, args...)))
+	}
+
+	src.Keys.Inventory(func(items []*keys.InventoryItem, err error) {
+		if err != nil {
+			problem("failed to read keys: %v", err)
+		}
+		l := newLabeler(items)
+		for i, item := range items {
+			b.Keys = append(b.Keys, &Key{
+				Label:      keyLabel(i),
+				Configured: item.ID != keys.InvalidID,
+				Type:       item.Type,
+				Bits:       item.Bits,
+				Created:    formatMillis(item.Created),
+				Loaded:     item.Loaded,
+				DeviceOnly: item.DeviceOnly,
+				Protection: string(item.Protection),
+			})
+		}
+
+		src.Settings.GetItems(src.SettingKeys, func(data map[string]interface{}, err error) {
+			if err != nil {
+				problem("failed to read settings: %v", err)
+			}
+			for _, k := range src.SettingKeys {
+				if v, ok := data[k]; ok && v != nil {
+					b.Settings[k] = v
+				}
+			}
+
+			src.Audit.Entries(func(entries []*audit.Entry, err error) {
+				if err != nil {
+					problem("%v", err)
+				}
+				clients := make(map[string]*Client)
+				for _, e := range entries {
+					if e.Action == audit.NegotiateAction {
+						c := &Client{
+							Requester:  e.Requester,
+							Time:       formatMillis(e.Time),
+							Negotiated: e.Detail,
+						}
+						if prev, ok := clients[e.Requester]; ok {
+							*prev = *c
+						} else {
+							clients[e.Requester] = c
+							b.Clients = append(b.Clients, c)
+						}
+					}
+					b.Events = append(b.Events, &Event{
+						Time:      formatMillis(e.Time),
+						Action:    e.Action,
+						Requester: e.Requester,
+						Key:       l.label(e.Key),
+						Allowed:   e.Allowed,
+						Detail:    l.text(e.Detail),
+					})
+				}
+
+				if src.Errors != nil {
+					for _, e := range src.Errors.Errors() {
+						b.Errors = append(b.Errors, &Error{
+							Time:    e.Time,
+							Message: l.text(e.Message),
+						})
+					}
+				}
+
+				if r := src.SelfCheck; r != nil {
+					b.SelfCheck = &selfcheck.Report{Time: r.Time, Health: r.Health}
+					for _, c := range r.Checks {
+						b.SelfCheck.Checks = append(b.SelfCheck.Checks, &selfcheck.Check{
+							Name:   c.Name,
+							Health: c.Health,
+							Detail: l.text(c.Detail),
+						})
+					}
+				}
+
+				if u := src.Upgrade; u != nil {
+					b.Upgrade = &Upgrade{
+						Time:   formatMillis(u.Time),
+						Keys:   []string{},
+						Loaded: []string{},
+					}
+					for _, id := range u.Keys {
+						b.Upgrade.Keys = append(b.Upgrade.Keys, l.label(string(id)))
+					}
+					for _, id := range u.Loaded {
+						b.Upgrade.Loaded = append(b.Upgrade.Loaded, l.label(string(id)))
+					}
+				}
+
+				callback(b)
+			})
+		})
+	})
+}
+
+// Encode encodes a bundle as indented JSON.
+func Encode(b *Bundle) (string, error) {
+	enc, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode bundle: %v", err)
+	}
+	return string(enc), nil
+}
+
+// Filename returns the name of the file to which a bundle collected at the
+// specified time is saved.
+func Filename(now time.Time) string {
+	return fmt.Sprintf("chrome-ssh-agent-diagnostics-%s.json", now.UTC().Format("20060102-150405"))
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diagnostics
+
+import (
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/redact"
+)
+
+// Error is an error that was displayed to the user.
+type Error struct {
+	// Time is the time at which the error was displayed.
+	Time string `json:"time"`
+	// Message is the error message.
+	Message string `json:"message"`
+}
+
+// ErrorLog retains the errors most recently displayed to the user, so that
+// they can be included in a bundle.  Errors are only kept in memory.
+type ErrorLog struct {
+	max    int
+	errors []*Error
+	// now returns the current time.  It may be overridden for testing.
+	now func() time.Time
+}
+
+// NewErrorLog returns an ErrorLog that retains up to max errors.
+func NewErrorLog(max int) *ErrorLog {
+	return &ErrorLog{
+		max: max,
+		now: time.Now,
+	}
+}
+
+// Record adds an error to the log.  Sensitive material is redacted from the
+// message.  A nil error is ignored.
+func (l *ErrorLog) Record(err error) {
+	if err == nil {
+		return
+	}
+	l.errors = append(l.errors, &Error{
+		Time:    l.now().UTC().Format(time.RFC3339),
+		Message: redact.String(err.Error()),
+	})
+	if len(l.errors) > l.max {
+		l.errors = l.errors[len(l.errors)-l.max:]
+	}
+}
+
+// Errors returns the errors in the log, oldest first.
+func (l *ErrorLog) Errors() []*Error {
+	return append([]*Error(nil), l.errors...)
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diagnostics
+
+import (
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// Platform describes the environment in which the extension is running.
+type Platform struct {
+	// Version is the version of the extension.
+	Version string `json:"version"`
+	// UserAgent is the browser's user agent string.
+	UserAgent string `json:"userAgent"`
+	// OS is the platform reported by the browser (e.g., 'Linux x86_64').
+	OS string `json:"os"`
+}
+
+// get returns the named property of o, or nil if either is undefined.
+func get(o *js.Object, name string) *js.Object {
+	if o == nil || o == js.Undefined {
+		return nil
+	}
+	v := o.Get(name)
+	if v == nil || v == js.Undefined {
+		return nil
+	}
+	return v
+}
+
+// CurrentPlatform returns a description of the environment in which the
+// extension is running.  Details that are unavailable (e.g., when running
+// outside the browser in tests) are left empty.
+func CurrentPlatform() *Platform {
+	p := &Platform{}
+	if nav := get(js.Global, "navigator"); nav != nil {
+		if ua := get(nav, "userAgent"); ua != nil {
+			p.UserAgent = ua.String()
+		}
+		if os := get(nav, "platform"); os != nil {
+			p.OS = os.String()
+		}
+	}
+	if runtime := get(get(js.Global, "chrome"), "runtime"); get(runtime, "getManifest") != nil {
+		if v := get(runtime.Call("getManifest"), "version"); v != nil {
+			p.Version = v.String()
+		}
+	}
+	return p
+}
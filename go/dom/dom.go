@@ -16,6 +16,8 @@
 package dom
 
 import (
+	"errors"
+	"fmt"
 	"log"
 
 	"github.com/gopherjs/gopherjs/js"
@@ -68,6 +70,75 @@ func (d *DOM) OnClick(o *js.Object, callback func()) {
 	o.Call("addEventListener", "click", callback)
 }
 
+// DoChange simulates a change event. Any callback registered by OnChange()
+// will be invoked.
+func (d *DOM) DoChange(o *js.Object) {
+	event := d.doc.Call("createEvent", "Event")
+	event.Call("initEvent", "change", true, true)
+	o.Call("dispatchEvent", event)
+}
+
+// OnChange registers a callback to be invoked when the value of the
+// specified object is changed by the user.
+func (d *DOM) OnChange(o *js.Object, callback func()) {
+	o.Call("addEventListener", "change", callback)
+}
+
+// DoInput simulates the user editing a value. Any callback registered by
+// OnInput() will be invoked.
+func (d *DOM) DoInput(o *js.Object) {
+	event := d.doc.Call("createEvent", "Event")
+	event.Call("initEvent", "input", true, true)
+	o.Call("dispatchEvent", event)
+}
+
+// OnInput registers a callback to be invoked whenever the user edits the
+// value of the specified object.
+func (d *DOM) OnInput(o *js.Object, callback func()) {
+	o.Call("addEventListener", "input", callback)
+}
+
+// DoSubmit simulates submitting a form. Any callback registered by
+// OnSubmit() will be invoked.
+func (d *DOM) DoSubmit(o *js.Object) {
+	event := d.doc.Call("createEvent", "Event")
+	event.Call("initEvent", "submit", true, true)
+	o.Call("dispatchEvent", event)
+}
+
+// OnSubmit registers a callback to be invoked when the specified form is
+// submitted, such as by pressing Enter in one of its fields.  The form is
+// not actually submitted, so the page is not reloaded.
+func (d *DOM) OnSubmit(o *js.Object, callback func()) {
+	o.Call("addEventListener", "submit", func(event *js.Object) {
+		event.Call("preventDefault")
+		callback()
+	})
+}
+
+// DoKeyDown simulates pressing the specified key. Any callback registered by
+// OnKeyDown() will be invoked.
+func (d *DOM) DoKeyDown(o *js.Object, key string) {
+	event := d.doc.Call("createEvent", "Event")
+	event.Call("initEvent", "keydown", true, true)
+	event.Set("key", key)
+	o.Call("dispatchEvent", event)
+}
+
+// OnKeyDown registers a callback to be invoked when a key is pressed while
+// the specified object, or one of its children, has focus.  key identifies
+// the key (e.g., 'Escape'); see KeyboardEvent.key.
+func (d *DOM) OnKeyDown(o *js.Object, callback func(key string)) {
+	o.Call("addEventListener", "keydown", func(event *js.Object) {
+		callback(event.Get("key").String())
+	})
+}
+
+// Focus moves the keyboard focus to the specified object.
+func (d *DOM) Focus(o *js.Object) {
+	o.Call("focus")
+}
+
 // DoDOMContentLoaded simulates the DOMContentLoaded event. Any callback
 // registered by OnDOMContentLoaded() will be invoked.
 func (d *DOM) DoDOMContentLoaded() {
@@ -92,6 +163,107 @@ func (d *DOM) SetValue(o *js.Object, value string) {
 	o.Set("value", value)
 }
 
+// Checked returns whether a checkbox is checked.
+func (d *DOM) Checked(o *js.Object) bool {
+	return o.Get("checked").Bool()
+}
+
+// SetChecked sets whether a checkbox is checked.
+func (d *DOM) SetChecked(o *js.Object, checked bool) {
+	o.Set("checked", checked)
+}
+
+// CopyToClipboard copies the value of a text input or textarea to the
+// clipboard.  It must be invoked in response to a user gesture.  Returns
+// false if the browser did not permit the copy.
+func (d *DOM) CopyToClipboard(o *js.Object) bool {
+	o.Call("select")
+	return d.doc.Call("execCommand", "copy").Bool()
+}
+
+// ClearClipboard replaces the contents of the clipboard with empty text.
+// Unless it is invoked in response to a user gesture, it requires the
+// 'clipboardWrite' permission.  Returns false if the browser did not permit
+// the copy.
+func (d *DOM) ClearClipboard() bool {
+	clear := js.MakeFunc(func(this *js.Object, args []*js.Object) interface{} {
+		e := args[0]
+		e.Get("clipboardData").Call("setData", "text/plain", "")
+		e.Call("preventDefault")
+		return nil
+	})
+	d.doc.Call("addEventListener", "copy", clear)
+	defer d.doc.Call("removeEventListener", "copy", clear)
+	return d.doc.Call("execCommand", "copy").Bool()
+}
+
+// Download saves text to a file with the specified name and content type,
+// as if it were downloaded.  It must be invoked in response to a user
+// gesture.
+func (d *DOM) Download(name, contentType, contents string) {
+	win := d.doc.Get("defaultView")
+	url := win.Get("URL")
+	if url.Get("createObjectURL") == js.Undefined {
+		// jsdom (which is used in tests) does not support object URLs.
+		log.Printf("createObjectURL() not found")
+		return
+	}
+	blob := win.Get("Blob").New([]interface{}{contents}, map[string]interface{}{"type": contentType})
+	href := url.Call("createObjectURL", blob)
+	a := d.NewElement("a")
+	a.Set("href", href)
+	a.Set("download", name)
+	a.Call("click")
+	// Release the contents once the download has started.
+	win.Call("setTimeout", func() {
+		url.Call("revokeObjectURL", href)
+	}, 0)
+}
+
+// ReadFile reads the file selected in a file input as text.  callback is
+// invoked with the name and contents of the file.  The contents are passed
+// directly to the callback; they are never placed in the DOM.
+func (d *DOM) ReadFile(o *js.Object, callback func(name, contents string, err error)) {
+	files := o.Get("files")
+	if files == nil || files == js.Undefined || files.Length() == 0 {
+		callback("", "", errors.New("no file selected"))
+		return
+	}
+	file := files.Index(0)
+	name := file.Get("name").String()
+
+	reader := d.doc.Get("defaultView").Get("FileReader").New()
+	reader.Set("onload", func() {
+		callback(name, reader.Get("result").String(), nil)
+	})
+	reader.Set("onerror", func() {
+		callback(name, "", fmt.Errorf("failed to read %s: %s", name, reader.Get("error").Get("message").String()))
+	})
+	reader.Call("readAsText", file)
+}
+
+// ReadFileBytes reads the file selected in a file input as binary data.  It
+// is otherwise the same as ReadFile.
+func (d *DOM) ReadFileBytes(o *js.Object, callback func(name string, contents []byte, err error)) {
+	files := o.Get("files")
+	if files == nil || files == js.Undefined || files.Length() == 0 {
+		callback("", nil, errors.New("no file selected"))
+		return
+	}
+	file := files.Index(0)
+	name := file.Get("name").String()
+
+	reader := d.doc.Get("defaultView").Get("FileReader").New()
+	reader.Set("onload", func() {
+		contents := js.Global.Get("Uint8Array").New(reader.Get("result")).Interface().([]byte)
+		callback(name, contents, nil)
+	})
+	reader.Set("onerror", func() {
+		callback(name, nil, fmt.Errorf("failed to read %s: %s", name, reader.Get("error").Get("message").String()))
+	})
+	reader.Call("readAsArrayBuffer", file)
+}
+
 // TextContent returns the text content of the specified object (and its
 // children).
 func (d *DOM) TextContent(o *js.Object) string {
@@ -86,6 +86,30 @@ func TestClick(t *testing.T) {
 	}
 }
 
+func TestSubmit(t *testing.T) {
+	d := New(dt.NewDocForTesting(`
+		<form id="form"><input id="input" type="text"/></form>
+	`))
+	var submitted bool
+	d.OnSubmit(d.GetElement("form"), func() { submitted = true })
+	d.DoSubmit(d.GetElement("form"))
+	if !submitted {
+		t.Errorf("submitted callback not invoked")
+	}
+}
+
+func TestKeyDown(t *testing.T) {
+	d := New(dt.NewDocForTesting(`
+		<form id="form"><input id="input" type="text"/></form>
+	`))
+	var keys []string
+	d.OnKeyDown(d.GetElement("form"), func(key string) { keys = append(keys, key) })
+	d.DoKeyDown(d.GetElement("input"), "Escape")
+	if diff := pretty.Diff(keys, []string{"Escape"}); diff != nil {
+		t.Errorf("incorrect keys; -got +want: %s", diff)
+	}
+}
+
 func TestValue(t *testing.T) {
 	d := New(dt.NewDocForTesting(`
 		<input id="ipt" type="text" value="Hello">
@@ -101,6 +125,21 @@ func TestValue(t *testing.T) {
 	}
 }
 
+func TestChecked(t *testing.T) {
+	d := New(dt.NewDocForTesting(`
+		<input id="ipt" type="checkbox">
+	`))
+
+	if diff := pretty.Diff(d.Checked(d.GetElement("ipt")), false); diff != nil {
+		t.Errorf("incorrect checked state; -got +want: %s", diff)
+	}
+
+	d.SetChecked(d.GetElement("ipt"), true)
+	if diff := pretty.Diff(d.Checked(d.GetElement("ipt")), true); diff != nil {
+		t.Errorf("incorrect checked state; -got +want: %s", diff)
+	}
+}
+
 func TestRemoveEventListeners(t *testing.T) {
 	d := New(dt.NewDocForTesting(`
 		<button id="btn"/>
@@ -0,0 +1,217 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package entropy provides a source of randomness that fails cleanly when
+// the platform's random number generator is slow, unavailable or unhealthy.
+//
+// Randomness is read from the underlying source in blocks and buffered, so
+// that small reads (e.g., when generating IDs) rarely wait on the source.
+// Each block is checked before it is used; a source that returns repeated
+// output is considered broken, and is never used again.  Reads that cannot
+// be satisfied within a timeout fail instead of hanging.
+package entropy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+const (
+	// DefaultTimeout is the longest Reader waits for the underlying
+	// source.
+	DefaultTimeout = 5 * time.Second
+
+	// poolSize is the number of bytes read from the underlying source at
+	// a time.
+	poolSize = 256
+	// blockSize is the size of the blocks compared by the continuous
+	// health check.  Consecutive blocks read from a healthy source are
+	// vanishingly unlikely to be equal.
+	blockSize = 16
+)
+
+var (
+	// Reader is a Pool that reads from crypto/rand.  It should be used in
+	// place of crypto/rand.Reader wherever a failure should be reported
+	// to the user.
+	Reader = New(rand.Reader, DefaultTimeout)
+)
+
+// Error indicates that randomness could not be obtained.
+type Error struct {
+	// Reason describes the problem with the random number generator.
+	Reason string
+	// Err is the error returned by the underlying source, if any.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("random number generator %s: %v", e.Reason, e.Err)
+	}
+	return fmt.Sprintf("random number generator %s", e.Reason)
+}
+
+// HelpCode implements help.Coder.
+func (e *Error) HelpCode() help.Code {
+	return help.EntropyUnavailable
+}
+
+// Pool is an io.Reader that buffers randomness read from an underlying
+// source.  It is safe for concurrent use.
+type Pool struct {
+	src     io.Reader
+	timeout time.Duration
+
+	mu sync.Mutex
+	// buf contains randomness that has been checked, but not yet read.
+	buf []byte
+	// last is the last block read from the source.
+	last []byte
+	// broken is set once the source has failed the health check.
+	broken error
+	// fillErr is the error from the most recent read from the source.
+	fillErr error
+	// filling is closed when an outstanding read from the source
+	// completes, or nil if there is none.
+	filling chan struct{}
+}
+
+// New returns a Pool that reads from src.  Reads fail if src does not
+// supply randomness within timeout.
+func New(src io.Reader, timeout time.Duration) *Pool {
+	return &Pool{
+		src:     src,
+		timeout: timeout,
+	}
+}
+
+// Read implements io.Reader.  It either fills p, or returns an *Error.
+func (p *Pool) Read(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		if err := p.wait(); err != nil {
+			return n, err
+		}
+		p.mu.Lock()
+		c := copy(b[n:], p.buf)
+		// Never return the same randomness twice.
+		for i := range p.buf[:c] {
+			p.buf[i] = 0
+		}
+		p.buf = p.buf[c:]
+		p.mu.Unlock()
+		n += c
+	}
+	return n, nil
+}
+
+// Check verifies that randomness is available, waiting for the underlying
+// source if necessary.  It returns an *Error if it is not.
+func (p *Pool) Check() error {
+	return p.wait()
+}
+
+// wait waits until randomness is buffered, reading from the underlying
+// source if necessary.
+func (p *Pool) wait() error {
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	for {
+		p.mu.Lock()
+		if p.broken != nil {
+			p.mu.Unlock()
+			return p.broken
+		}
+		if len(p.buf) > 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		if err := p.fillErr; err != nil {
+			p.fillErr = nil
+			p.mu.Unlock()
+			return err
+		}
+		done := p.fillLocked()
+		p.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-timer.C:
+			return &Error{Reason: fmt.Sprintf("did not respond within %v", p.timeout)}
+		}
+	}
+}
+
+// fillLocked starts reading from the underlying source, unless a read is
+// already outstanding.  It returns a channel that is closed when the read
+// completes.
+func (p *Pool) fillLocked() chan struct{} {
+	if p.filling != nil {
+		return p.filling
+	}
+
+	done := make(chan struct{})
+	p.filling = done
+	go func() {
+		data := make([]byte, poolSize)
+		_, err := io.ReadFull(p.src, data)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.filling = nil
+		close(done)
+		if err != nil {
+			p.fillErr = &Error{Reason: "is unavailable", Err: err}
+			return
+		}
+		if err := p.checkLocked(data); err != nil {
+			p.broken = err
+			p.buf = nil
+			return
+		}
+		p.buf = append(p.buf, data...)
+	}()
+	return done
+}
+
+// checkLocked performs a continuous health check on data read from the
+// underlying source, comparing each block with the one before it.
+func (p *Pool) checkLocked(data []byte) error {
+	for i := 0; i+blockSize <= len(data); i += blockSize {
+		block := data[i : i+blockSize]
+		if p.last != nil && bytes.Equal(block, p.last) {
+			return &Error{Reason: "returned repeated output; it cannot be used"}
+		}
+		p.last = append(p.last[:0], block...)
+	}
+	return nil
+}
+
+// Check verifies that randomness is available from r, if r is a Pool.  It
+// returns nil for other readers.
+func Check(r io.Reader) error {
+	if p, ok := r.(*Pool); ok {
+		return p.Check()
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entropy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/kr/pretty"
+)
+
+// counterReader is a deterministic source whose output does not repeat.
+type counterReader struct {
+	n byte
+}
+
+func (c *counterReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = c.n
+		c.n++
+	}
+	// Hash each block so that consecutive blocks differ.
+	for i := 0; i+blockSize <= len(p); i += blockSize {
+		sum := sha256.Sum256(p[i : i+blockSize])
+		copy(p[i:i+blockSize], sum[:])
+	}
+	return len(p), nil
+}
+
+// failingReader is a source that always fails.
+type failingReader struct{}
+
+func (f failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("no randomness")
+}
+
+// zeroReader is a broken source that only returns zeros.
+type zeroReader struct{}
+
+func (z zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// blockingReader is a source that waits to be released before reading
+// from another source.
+type blockingReader struct {
+	release chan bool
+	r       io.Reader
+}
+
+func (b *blockingReader) Read(p []byte) (int, error) {
+	<-b.release
+	return b.r.Read(p)
+}
+
+func TestRead(t *testing.T) {
+	want := make([]byte, 3*poolSize)
+	io.ReadFull(&counterReader{}, want)
+
+	// Reads of varying sizes return the source's output in order.
+	p := New(&counterReader{}, DefaultTimeout)
+	var got []byte
+	for _, n := range []int{1, 7, poolSize, 2*poolSize - 8} {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(p, b); err != nil {
+			t.Fatalf("failed to read %d bytes: %v", n, err)
+		}
+		got = append(got, b...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("incorrect output; got %x, want %x", got, want)
+	}
+}
+
+func TestReadFailure(t *testing.T) {
+	testcases := []struct {
+		description string
+		src         io.Reader
+		wantErr     string
+		wantBroken  bool
+	}{
+		{
+			description: "unavailable",
+			src:         failingReader{},
+			wantErr:     "random number generator is unavailable: no randomness",
+		},
+		{
+			description: "repeated output",
+			src:         zeroReader{},
+			wantErr:     "random number generator returned repeated output; it cannot be used",
+			wantBroken:  true,
+		},
+	}
+
+	for _, tc := range testcases {
+		p := New(tc.src, DefaultTimeout)
+		_, err := p.Read(make([]byte, 8))
+		if err == nil {
+			t.Errorf("%s: read unexpectedly succeeded", tc.description)
+			continue
+		}
+		if diff := pretty.Diff(err.Error(), tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(help.CodeOf(err), help.EntropyUnavailable); diff != nil {
+			t.Errorf("%s: incorrect help code; -got +want: %s", tc.description, diff)
+		}
+
+		// A broken source is never used again, even if it recovers.
+		p.src = &counterReader{}
+		err = p.Check()
+		if diff := pretty.Diff(err != nil, tc.wantBroken); diff != nil {
+			t.Errorf("%s: incorrect failure after recovery; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	src := &blockingReader{release: make(chan bool), r: &counterReader{}}
+	p := New(src, 10*time.Millisecond)
+	_, err := p.Read(make([]byte, 8))
+	if err == nil {
+		t.Fatalf("read unexpectedly succeeded")
+	}
+	if diff := pretty.Diff(err.Error(), "random number generator did not respond within 10ms"); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+
+	// Once the source responds, the randomness it supplied is used.
+	close(src.release)
+	p.timeout = DefaultTimeout
+	if err := Check(p); err != nil {
+		t.Errorf("check failed after source responded: %v", err)
+	}
+}
@@ -0,0 +1,434 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package external controls which other extensions (e.g., Secure Shell) may
+// connect to the agent, and what they may do once connected.  Connections
+// from extensions the user has not yet approved are queued until the user
+// approves or denies them.  Each approved extension is granted a set of
+// scopes (e.g., to request signatures) that limit the requests it may make.
+package external
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/storage"
+)
+
+// Names looks up the names of installed extensions.  See
+// chrome.C.ExtensionName() for details; using this interface allows for
+// alternate implementations during testing.
+type Names interface {
+	// ExtensionName returns the name of the extension with the specified
+	// ID.
+	ExtensionName(id string, callback func(name string, err error))
+}
+
+const (
+	// extensionsKey is the key under which decisions are stored.
+	extensionsKey = "external.extensions"
+)
+
+// Trusted are the extensions that may always connect, indexed by ID,
+// with their names.
+var Trusted = map[string]string{
+	"pnhechapfaindjhompbnflcldabbghjo": "Secure Shell",
+}
+
+// Decision is the user's decision on whether an extension may connect.
+type Decision string
+
+const (
+	// Pending indicates that the extension has asked to connect, and the
+	// user has not yet decided.
+	Pending Decision = "pending"
+	// Approved indicates that the extension may connect.
+	Approved Decision = "approved"
+	// Denied indicates that the extension may not connect.
+	Denied Decision = "denied"
+)
+
+// Scope permits an extension to make some of the requests defined by the
+// SSH agent protocol.
+type Scope string
+
+const (
+	// ScopeListKeys permits an extension to list the public keys of the
+	// loaded keys.
+	ScopeListKeys Scope = "list-public-keys"
+	// ScopeSign permits an extension to request signatures.
+	ScopeSign Scope = "request-signature"
+	// ScopeAddKey permits an extension to add keys to the agent.  It
+	// also permits removing keys and locking the agent, which likewise
+	// change the keys available to other clients.
+	ScopeAddKey Scope = "add-key"
+)
+
+// AllScopes are the scopes that may be granted, in the order in which they
+// are displayed.
+var AllScopes = []Scope{ScopeListKeys, ScopeSign, ScopeAddKey}
+
+// DefaultScopes are the scopes granted to an extension when it is approved;
+// these suffice for an SSH client.
+var DefaultScopes = []Scope{ScopeListKeys, ScopeSign}
+
+// validScope returns true if s is one of AllScopes.
+func validScope(s Scope) bool {
+	for _, v := range AllScopes {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Extension is an extension that has asked to connect to the agent.
+type Extension struct {
+	// ID is the ID of the extension.
+	ID string
+	// Name is the name of the extension, or empty if it is unknown
+	// (e.g., because permission to read the names of other extensions
+	// has not been granted).
+	Name string
+	// Decision is the user's decision on whether it may connect.
+	Decision Decision
+	// Requested is the time at which it first asked to connect.  It is
+	// zero for trusted extensions.
+	Requested time.Time
+	// Trusted indicates that the extension may always connect; the
+	// decision cannot be changed.
+	Trusted bool
+	// Scopes are the scopes granted to the extension, ordered as in
+	// AllScopes.  It is empty unless the extension is approved.
+	Scopes []Scope
+}
+
+// entry is the stored decision for an extension.
+type entry struct {
+	decision  Decision
+	requested int64
+	// scopes are the scopes granted to the extension.  It is nil for
+	// extensions approved before scopes were introduced, which retain
+	// every scope.
+	scopes []Scope
+}
+
+// granted returns the scopes granted to the extension, ordered as in
+// AllScopes.
+func (e *entry) granted() []Scope {
+	if e.decision != Approved {
+		return nil
+	}
+	if e.scopes == nil {
+		return append([]Scope(nil), AllScopes...)
+	}
+	var result []Scope
+	for _, s := range AllScopes {
+		for _, g := range e.scopes {
+			if s == g {
+				result = append(result, s)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// ACL is the set of extensions the user has approved or denied.  Decisions
+// are stored per-device; they are never synced.
+type ACL struct {
+	store storage.Store
+	names Names
+}
+
+// NewACL returns an ACL that keeps decisions in the supplied storage.  The
+// names of extensions are looked up using names, which may be nil.
+func NewACL(store storage.Store, names Names) *ACL {
+	return &ACL{
+		store: store,
+		names: names,
+	}
+}
+
+// Changed returns true if the supplied storage changes (as passed to a
+// chrome.Storage OnChanged callback) affect the stored decisions.
+func Changed(changes map[string]interface{}) bool {
+	_, ok := changes[extensionsKey]
+	return ok
+}
+
+// read returns the stored decisions, indexed by extension ID.
+func (a *ACL) read(callback func(entries map[string]*entry, err error)) {
+	a.store.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read extension decisions: %v", err))
+			return
+		}
+
+		result := make(map[string]*entry)
+		raw, _ := data[extensionsKey].(map[string]interface{})
+		for id, r := range raw {
+			m, ok := r.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			e := &entry{}
+			if d, ok := m["decision"].(string); ok {
+				e.decision = Decision(d)
+			}
+			if t, ok := m["requested"].(float64); ok {
+				e.requested = int64(t)
+			}
+			if l, ok := m["scopes"].([]interface{}); ok {
+				e.scopes = []Scope{}
+				for _, v := range l {
+					if sc, ok := v.(string); ok && validScope(Scope(sc)) {
+						e.scopes = append(e.scopes, Scope(sc))
+					}
+				}
+			}
+			switch e.decision {
+			case Pending, Approved, Denied:
+				result[id] = e
+			}
+		}
+		callback(result, nil)
+	})
+}
+
+// write stores the supplied decisions.
+func (a *ACL) write(entries map[string]*entry, callback func(err error)) {
+	raw := make(map[string]interface{})
+	for id, e := range entries {
+		r := map[string]interface{}{
+			"decision":  string(e.decision),
+			"requested": float64(e.requested),
+		}
+		if e.scopes != nil {
+			scopes := []interface{}{}
+			for _, sc := range e.scopes {
+				scopes = append(scopes, string(sc))
+			}
+			r["scopes"] = scopes
+		}
+		raw[id] = r
+	}
+	a.store.Set(map[string]interface{}{extensionsKey: raw}, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to write extension decisions: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// update applies change to the stored decisions, and writes them.
+func (a *ACL) update(change func(entries map[string]*entry), callback func(err error)) {
+	a.read(func(entries map[string]*entry, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		change(entries)
+		a.write(entries, callback)
+	})
+}
+
+// Check returns the decision on whether the extension with the specified
+// ID may connect.  An empty decision indicates that it has never asked.
+func (a *ACL) Check(id string, callback func(decision Decision, err error)) {
+	if _, ok := Trusted[id]; ok {
+		callback(Approved, nil)
+		return
+	}
+	a.read(func(entries map[string]*entry, err error) {
+		if err != nil {
+			callback("", err)
+			return
+		}
+		if e := entries[id]; e != nil {
+			callback(e.decision, nil)
+			return
+		}
+		callback("", nil)
+	})
+}
+
+// Request records that the extension with the specified ID has asked to
+// connect, so that the user can decide whether it may.  It has no effect if
+// the user has already decided.
+func (a *ACL) Request(id string, callback func(err error)) {
+	a.update(func(entries map[string]*entry) {
+		if entries[id] == nil {
+			entries[id] = &entry{
+				decision:  Pending,
+				requested: time.Now().UnixNano() / int64(time.Millisecond),
+			}
+		}
+	}, callback)
+}
+
+// decide records the user's decision for the extension with the specified
+// ID.
+func (a *ACL) decide(id string, decision Decision, callback func(err error)) {
+	if _, ok := Trusted[id]; ok {
+		callback(fmt.Errorf("extension %s is always allowed to connect", id))
+		return
+	}
+	a.update(func(entries map[string]*entry) {
+		e := entries[id]
+		if e == nil {
+			e = &entry{}
+			entries[id] = e
+		}
+		if decision == Approved && e.decision != Approved {
+			e.scopes = append([]Scope{}, DefaultScopes...)
+		}
+		e.decision = decision
+	}, callback)
+}
+
+// Approve allows the extension with the specified ID to connect, granting
+// it DefaultScopes.  callback is invoked when complete.
+func (a *ACL) Approve(id string, callback func(err error)) {
+	a.decide(id, Approved, callback)
+}
+
+// Deny refuses connections from the extension with the specified ID.
+// callback is invoked when complete.
+func (a *ACL) Deny(id string, callback func(err error)) {
+	a.decide(id, Denied, callback)
+}
+
+// Scopes returns the scopes granted to the extension with the specified ID.
+// Trusted extensions are granted every scope, and extensions that are not
+// approved none.  callback is invoked with the result.
+func (a *ACL) Scopes(id string, callback func(scopes []Scope, err error)) {
+	if _, ok := Trusted[id]; ok {
+		callback(append([]Scope(nil), AllScopes...), nil)
+		return
+	}
+	a.read(func(entries map[string]*entry, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		if e := entries[id]; e != nil {
+			callback(e.granted(), nil)
+			return
+		}
+		callback(nil, nil)
+	})
+}
+
+// SetScope grants or revokes a scope for the approved extension with the
+// specified ID.  callback is invoked when complete.
+func (a *ACL) SetScope(id string, scope Scope, grant bool, callback func(err error)) {
+	if _, ok := Trusted[id]; ok {
+		callback(fmt.Errorf("extension %s is always granted every scope", id))
+		return
+	}
+	if !validScope(scope) {
+		callback(fmt.Errorf("unknown scope %q", scope))
+		return
+	}
+	a.read(func(entries map[string]*entry, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		e := entries[id]
+		if e == nil || e.decision != Approved {
+			callback(fmt.Errorf("extension %s is not approved", id))
+			return
+		}
+		scopes := []Scope{}
+		for _, s := range e.granted() {
+			if s != scope {
+				scopes = append(scopes, s)
+			}
+		}
+		if grant {
+			scopes = append(scopes, scope)
+		}
+		e.scopes = scopes
+		a.write(entries, callback)
+	})
+}
+
+// Forget removes the decision for the extension with the specified ID; the
+// user will be asked again the next time it connects.  callback is invoked
+// when complete.
+func (a *ACL) Forget(id string, callback func(err error)) {
+	a.update(func(entries map[string]*entry) {
+		delete(entries, id)
+	}, callback)
+}
+
+// Extensions returns the trusted extensions, followed by those that have
+// asked to connect, ordered by when they first asked.  callback is invoked
+// with the result.
+func (a *ACL) Extensions(callback func(extensions []*Extension, err error)) {
+	a.read(func(entries map[string]*entry, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+
+		var trusted, result []*Extension
+		for id, name := range Trusted {
+			trusted = append(trusted, &Extension{ID: id, Name: name, Decision: Approved, Trusted: true, Scopes: append([]Scope(nil), AllScopes...)})
+		}
+		for id, e := range entries {
+			if _, ok := Trusted[id]; ok {
+				continue
+			}
+			result = append(result, &Extension{
+				ID:        id,
+				Decision:  e.decision,
+				Requested: time.Unix(0, e.requested*int64(time.Millisecond)),
+				Scopes:    e.granted(),
+			})
+		}
+		sort.Slice(trusted, func(i, j int) bool {
+			return trusted[i].ID < trusted[j].ID
+		})
+		sort.Slice(result, func(i, j int) bool {
+			if !result[i].Requested.Equal(result[j].Requested) {
+				return result[i].Requested.Before(result[j].Requested)
+			}
+			return result[i].ID < result[j].ID
+		})
+		a.lookupNames(result, 0, func() {
+			callback(append(trusted, result...), nil)
+		})
+	})
+}
+
+// lookupNames fills in the names of extensions, starting at index i.
+// Failures (e.g., because permission to read the names has not been
+// granted) are ignored.
+func (a *ACL) lookupNames(extensions []*Extension, i int, callback func()) {
+	if a.names == nil || i >= len(extensions) {
+		callback()
+		return
+	}
+	a.names.ExtensionName(extensions[i].ID, func(name string, err error) {
+		if err == nil {
+			extensions[i].Name = name
+		}
+		a.lookupNames(extensions, i+1, callback)
+	})
+}
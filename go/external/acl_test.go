@@ -0,0 +1,366 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package external
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/kr/pretty"
+)
+
+const (
+	secureShellID = "pnhechapfaindjhompbnflcldabbghjo"
+	otherID       = "abcdefghijklmnopabcdefghijklmnop"
+	anotherID     = "ponmlkjihgfedcbaponmlkjihgfedcba"
+)
+
+// fakeNames is a fake implementation of Names.
+type fakeNames map[string]string
+
+func (f fakeNames) ExtensionName(id string, callback func(name string, err error)) {
+	name, ok := f[id]
+	if !ok {
+		callback("", errors.New("not installed"))
+		return
+	}
+	callback(name, nil)
+}
+
+// extensionSummary summarizes an Extension for comparison; the time at
+// which it asked to connect varies.
+type extensionSummary struct {
+	ID       string
+	Name     string
+	Decision Decision
+	Trusted  bool
+}
+
+func syncExtensions(a *ACL) ([]extensionSummary, error) {
+	var result []extensionSummary
+	var err error
+	a.Extensions(func(extensions []*Extension, e error) {
+		err = e
+		for _, x := range extensions {
+			result = append(result, extensionSummary{ID: x.ID, Name: x.Name, Decision: x.Decision, Trusted: x.Trusted})
+		}
+	})
+	return result, err
+}
+
+func syncCheck(a *ACL, id string) (Decision, error) {
+	var result Decision
+	var err error
+	a.Check(id, func(d Decision, e error) {
+		result, err = d, e
+	})
+	return result, err
+}
+
+func TestACL(t *testing.T) {
+	secureShell := extensionSummary{ID: secureShellID, Name: "Secure Shell", Decision: Approved, Trusted: true}
+
+	testcases := []struct {
+		description    string
+		actions        func(a *ACL, done func(err error))
+		wantExtensions []extensionSummary
+		wantChecks     map[string]Decision
+		wantErr        error
+	}{
+		{
+			description:    "only trusted extensions",
+			actions:        func(a *ACL, done func(err error)) { done(nil) },
+			wantExtensions: []extensionSummary{secureShell},
+			wantChecks: map[string]Decision{
+				secureShellID: Approved,
+				otherID:       "",
+			},
+		},
+		{
+			description: "record request",
+			actions: func(a *ACL, done func(err error)) {
+				a.Request(otherID, done)
+			},
+			wantExtensions: []extensionSummary{
+				secureShell,
+				{ID: otherID, Name: "Other", Decision: Pending},
+			},
+			wantChecks: map[string]Decision{
+				otherID: Pending,
+			},
+		},
+		{
+			description: "approve request",
+			actions: func(a *ACL, done func(err error)) {
+				a.Request(otherID, func(err error) {
+					a.Approve(otherID, func(err error) {
+						// A later request does not undo the
+						// decision.
+						a.Request(otherID, done)
+					})
+				})
+			},
+			wantExtensions: []extensionSummary{
+				secureShell,
+				{ID: otherID, Name: "Other", Decision: Approved},
+			},
+			wantChecks: map[string]Decision{
+				otherID: Approved,
+			},
+		},
+		{
+			description: "deny request",
+			actions: func(a *ACL, done func(err error)) {
+				a.Request(anotherID, func(err error) {
+					a.Deny(anotherID, done)
+				})
+			},
+			wantExtensions: []extensionSummary{
+				secureShell,
+				{ID: anotherID, Decision: Denied},
+			},
+			wantChecks: map[string]Decision{
+				anotherID: Denied,
+			},
+		},
+		{
+			description: "forget decision",
+			actions: func(a *ACL, done func(err error)) {
+				a.Approve(otherID, func(err error) {
+					a.Forget(otherID, done)
+				})
+			},
+			wantExtensions: []extensionSummary{secureShell},
+			wantChecks: map[string]Decision{
+				otherID: "",
+			},
+		},
+		{
+			description: "trusted extension cannot be denied",
+			actions: func(a *ACL, done func(err error)) {
+				a.Deny(secureShellID, done)
+			},
+			wantExtensions: []extensionSummary{secureShell},
+			wantChecks: map[string]Decision{
+				secureShellID: Approved,
+			},
+			wantErr: errors.New("extension pnhechapfaindjhompbnflcldabbghjo is always allowed to connect"),
+		},
+	}
+
+	for _, tc := range testcases {
+		a := NewACL(fakes.NewMemStorage(), fakeNames{otherID: "Other"})
+
+		var err error
+		tc.actions(a, func(e error) { err = e })
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+
+		got, err := syncExtensions(a)
+		if err != nil {
+			t.Errorf("%s: failed to get extensions: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(got, tc.wantExtensions); diff != nil {
+			t.Errorf("%s: incorrect extensions; -got +want: %s", tc.description, diff)
+		}
+		for id, want := range tc.wantChecks {
+			d, err := syncCheck(a, id)
+			if err != nil {
+				t.Errorf("%s: failed to check %s: %v", tc.description, id, err)
+			}
+			if d != want {
+				t.Errorf("%s: incorrect decision for %s; got %q, want %q", tc.description, id, d, want)
+			}
+		}
+	}
+}
+
+func syncScopes(a *ACL, id string) ([]Scope, error) {
+	var result []Scope
+	var err error
+	a.Scopes(id, func(s []Scope, e error) {
+		result, err = s, e
+	})
+	return result, err
+}
+
+func TestScopes(t *testing.T) {
+	testcases := []struct {
+		description string
+		id          string
+		actions     func(a *ACL, store *fakes.MemStorage, done func(err error))
+		wantScopes  []Scope
+		wantErr     error
+	}{
+		{
+			description: "trusted extension",
+			id:          secureShellID,
+			actions:     func(a *ACL, store *fakes.MemStorage, done func(err error)) { done(nil) },
+			wantScopes:  []Scope{ScopeListKeys, ScopeSign, ScopeAddKey},
+		},
+		{
+			description: "trusted extension scopes cannot be changed",
+			id:          secureShellID,
+			actions: func(a *ACL, store *fakes.MemStorage, done func(err error)) {
+				a.SetScope(secureShellID, ScopeAddKey, false, done)
+			},
+			wantScopes: []Scope{ScopeListKeys, ScopeSign, ScopeAddKey},
+			wantErr:    errors.New("extension pnhechapfaindjhompbnflcldabbghjo is always granted every scope"),
+		},
+		{
+			description: "pending extension",
+			id:          otherID,
+			actions: func(a *ACL, store *fakes.MemStorage, done func(err error)) {
+				a.Request(otherID, done)
+			},
+		},
+		{
+			description: "approved extension granted default scopes",
+			id:          otherID,
+			actions: func(a *ACL, store *fakes.MemStorage, done func(err error)) {
+				a.Request(otherID, func(err error) {
+					a.Approve(otherID, done)
+				})
+			},
+			wantScopes: []Scope{ScopeListKeys, ScopeSign},
+		},
+		{
+			description: "grant scope",
+			id:          otherID,
+			actions: func(a *ACL, store *fakes.MemStorage, done func(err error)) {
+				a.Approve(otherID, func(err error) {
+					a.SetScope(otherID, ScopeAddKey, true, done)
+				})
+			},
+			wantScopes: []Scope{ScopeListKeys, ScopeSign, ScopeAddKey},
+		},
+		{
+			description: "revoke scope",
+			id:          otherID,
+			actions: func(a *ACL, store *fakes.MemStorage, done func(err error)) {
+				a.Approve(otherID, func(err error) {
+					a.SetScope(otherID, ScopeSign, false, done)
+				})
+			},
+			wantScopes: []Scope{ScopeListKeys},
+		},
+		{
+			description: "revoke every scope",
+			id:          otherID,
+			actions: func(a *ACL, store *fakes.MemStorage, done func(err error)) {
+				a.Approve(otherID, func(err error) {
+					a.SetScope(otherID, ScopeSign, false, func(err error) {
+						a.SetScope(otherID, ScopeListKeys, false, done)
+					})
+				})
+			},
+		},
+		{
+			description: "approving again restores default scopes",
+			id:          otherID,
+			actions: func(a *ACL, store *fakes.MemStorage, done func(err error)) {
+				a.Approve(otherID, func(err error) {
+					a.SetScope(otherID, ScopeAddKey, true, func(err error) {
+						a.Deny(otherID, func(err error) {
+							a.Approve(otherID, done)
+						})
+					})
+				})
+			},
+			wantScopes: []Scope{ScopeListKeys, ScopeSign},
+		},
+		{
+			description: "approved before scopes were introduced",
+			id:          otherID,
+			actions: func(a *ACL, store *fakes.MemStorage, done func(err error)) {
+				store.Set(map[string]interface{}{
+					extensionsKey: map[string]interface{}{
+						otherID: map[string]interface{}{
+							"decision":  "approved",
+							"requested": float64(1),
+						},
+					},
+				}, done)
+			},
+			wantScopes: []Scope{ScopeListKeys, ScopeSign, ScopeAddKey},
+		},
+		{
+			description: "scope cannot be granted to pending extension",
+			id:          otherID,
+			actions: func(a *ACL, store *fakes.MemStorage, done func(err error)) {
+				a.Request(otherID, func(err error) {
+					a.SetScope(otherID, ScopeSign, true, done)
+				})
+			},
+			wantErr: errors.New("extension abcdefghijklmnopabcdefghijklmnop is not approved"),
+		},
+		{
+			description: "unknown scope",
+			id:          otherID,
+			actions: func(a *ACL, store *fakes.MemStorage, done func(err error)) {
+				a.Approve(otherID, func(err error) {
+					a.SetScope(otherID, Scope("remove-everything"), true, done)
+				})
+			},
+			wantScopes: []Scope{ScopeListKeys, ScopeSign},
+			wantErr:    errors.New(`unknown scope "remove-everything"`),
+		},
+	}
+
+	for _, tc := range testcases {
+		store := fakes.NewMemStorage()
+		a := NewACL(store, nil)
+
+		var err error
+		tc.actions(a, store, func(e error) { err = e })
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+
+		got, err := syncScopes(a, tc.id)
+		if err != nil {
+			t.Errorf("%s: failed to get scopes: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(got, tc.wantScopes); diff != nil {
+			t.Errorf("%s: incorrect scopes; -got +want: %s", tc.description, diff)
+		}
+
+		// The scopes are also reported with the extension.
+		a.Extensions(func(extensions []*Extension, err error) {
+			if err != nil {
+				t.Errorf("%s: failed to get extensions: %v", tc.description, err)
+			}
+			for _, x := range extensions {
+				if x.ID != tc.id {
+					continue
+				}
+				if diff := pretty.Diff(x.Scopes, tc.wantScopes); diff != nil {
+					t.Errorf("%s: incorrect extension scopes; -got +want: %s", tc.description, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestChanged(t *testing.T) {
+	if !Changed(map[string]interface{}{extensionsKey: nil}) {
+		t.Errorf("change to decisions not detected")
+	}
+	if Changed(map[string]interface{}{"other": nil}) {
+		t.Errorf("unrelated change detected")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package external
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ScopedAgent is an agent.Agent that serves another extension, refusing
+// requests outside the scopes granted to it.
+//
+// Every request consults the ACL for the granted scopes, so that revoking a
+// scope takes effect on existing connections.  ScopedAgent must therefore
+// not be called from a callback (e.g., one invoked by storage).
+type ScopedAgent struct {
+	agent agent.Agent
+	acl   *ACL
+	id    string
+}
+
+// NewScopedAgent returns a ScopedAgent that performs requests from the
+// extension with the specified ID using a, provided acl grants the
+// extension the necessary scope.
+func NewScopedAgent(a agent.Agent, acl *ACL, id string) *ScopedAgent {
+	return &ScopedAgent{
+		agent: a,
+		acl:   acl,
+		id:    id,
+	}
+}
+
+// check returns an error if the extension has not been granted scope.
+func (s *ScopedAgent) check(scope Scope) error {
+	done := make(chan struct{})
+	var scopes []Scope
+	var err error
+	s.acl.Scopes(s.id, func(sc []Scope, e error) {
+		scopes, err = sc, e
+		close(done)
+	})
+	<-done
+	if err != nil {
+		return fmt.Errorf("failed to read scopes: %v", err)
+	}
+	for _, sc := range scopes {
+		if sc == scope {
+			return nil
+		}
+	}
+	return fmt.Errorf("extension %s has not been granted the %s scope", s.id, scope)
+}
+
+// List implements agent.Agent.List.
+func (s *ScopedAgent) List() ([]*agent.Key, error) {
+	if err := s.check(ScopeListKeys); err != nil {
+		return nil, err
+	}
+	return s.agent.List()
+}
+
+// Signers implements agent.Agent.Signers.
+func (s *ScopedAgent) Signers() ([]ssh.Signer, error) {
+	if err := s.check(ScopeSign); err != nil {
+		return nil, err
+	}
+	return s.agent.Signers()
+}
+
+// Sign implements agent.Agent.Sign.
+func (s *ScopedAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	if err := s.check(ScopeSign); err != nil {
+		return nil, err
+	}
+	return s.agent.Sign(key, data)
+}
+
+// Add implements agent.Agent.Add.
+func (s *ScopedAgent) Add(key agent.AddedKey) error {
+	if err := s.check(ScopeAddKey); err != nil {
+		return err
+	}
+	return s.agent.Add(key)
+}
+
+// Remove implements agent.Agent.Remove.
+func (s *ScopedAgent) Remove(key ssh.PublicKey) error {
+	if err := s.check(ScopeAddKey); err != nil {
+		return err
+	}
+	return s.agent.Remove(key)
+}
+
+// RemoveAll implements agent.Agent.RemoveAll.
+func (s *ScopedAgent) RemoveAll() error {
+	if err := s.check(ScopeAddKey); err != nil {
+		return err
+	}
+	return s.agent.RemoveAll()
+}
+
+// Lock implements agent.Agent.Lock.
+func (s *ScopedAgent) Lock(passphrase []byte) error {
+	if err := s.check(ScopeAddKey); err != nil {
+		return err
+	}
+	return s.agent.Lock(passphrase)
+}
+
+// Unlock implements agent.Agent.Unlock.
+func (s *ScopedAgent) Unlock(passphrase []byte) error {
+	if err := s.check(ScopeAddKey); err != nil {
+		return err
+	}
+	return s.agent.Unlock(passphrase)
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package external
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestScopedAgent(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+
+	// requests makes one request of each kind, returning its error.
+	requests := func(a agent.Agent) []error {
+		_, listErr := a.List()
+		_, signErr := a.Sign(signer.PublicKey(), []byte("some-data"))
+		addErr := a.Add(agent.AddedKey{PrivateKey: priv})
+		return []error{listErr, signErr, addErr}
+	}
+
+	testcases := []struct {
+		description string
+		id          string
+		grant       []Scope
+		want        []error
+	}{
+		{
+			description: "trusted extension",
+			id:          secureShellID,
+			want:        []error{nil, nil, nil},
+		},
+		{
+			description: "default scopes",
+			id:          otherID,
+			want: []error{
+				nil,
+				nil,
+				errors.New("extension abcdefghijklmnopabcdefghijklmnop has not been granted the add-key scope"),
+			},
+		},
+		{
+			description: "add key granted",
+			id:          otherID,
+			grant:       []Scope{ScopeAddKey},
+			want:        []error{nil, nil, nil},
+		},
+		{
+			description: "extension not approved",
+			id:          anotherID,
+			want: []error{
+				errors.New("extension ponmlkjihgfedcbaponmlkjihgfedcba has not been granted the list-public-keys scope"),
+				errors.New("extension ponmlkjihgfedcbaponmlkjihgfedcba has not been granted the request-signature scope"),
+				errors.New("extension ponmlkjihgfedcbaponmlkjihgfedcba has not been granted the add-key scope"),
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		acl := NewACL(fakes.NewMemStorage(), nil)
+		acl.Approve(otherID, func(err error) {
+			if err != nil {
+				t.Fatalf("%s: failed to approve extension: %v", tc.description, err)
+			}
+		})
+		for _, s := range tc.grant {
+			acl.SetScope(tc.id, s, true, func(err error) {
+				if err != nil {
+					t.Fatalf("%s: failed to grant %s: %v", tc.description, s, err)
+				}
+			})
+		}
+
+		keyring := agent.NewKeyring()
+		if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		got := requests(NewScopedAgent(keyring, acl, tc.id))
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect errors; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestScopedAgentRevoked(t *testing.T) {
+	acl := NewACL(fakes.NewMemStorage(), nil)
+	acl.Approve(otherID, func(err error) {
+		if err != nil {
+			t.Fatalf("failed to approve extension: %v", err)
+		}
+	})
+	a := NewScopedAgent(agent.NewKeyring(), acl, otherID)
+	if _, err := a.List(); err != nil {
+		t.Errorf("failed to list keys: %v", err)
+	}
+
+	// Revoking a scope affects an existing agent.
+	acl.SetScope(otherID, ScopeListKeys, false, func(err error) {
+		if err != nil {
+			t.Fatalf("failed to revoke scope: %v", err)
+		}
+	})
+	if _, err := a.List(); err == nil {
+		t.Errorf("listed keys after scope was revoked")
+	}
+}
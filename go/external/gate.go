@@ -0,0 +1,128 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package external
+
+import (
+	"log"
+)
+
+// Gate decides whether to serve each connection from another extension.
+// Connections from approved extensions are served immediately, and those
+// from denied extensions are refused.  Connections from other extensions are
+// queued (and recorded in the ACL as pending) until the user decides.
+//
+// Ports are opaque to the Gate; they are only passed to the callbacks
+// supplied to NewGate, and compared to find queued connections.
+type Gate struct {
+	acl    *ACL
+	serve  func(id string, port interface{})
+	refuse func(id string, port interface{})
+	notify func(id string)
+	// queued contains the connections awaiting a decision, indexed by
+	// extension ID.
+	queued map[string][]interface{}
+}
+
+// NewGate returns a Gate that checks connections against acl.  serve is
+// invoked to serve a connection, and refuse to disconnect it.  notify is
+// invoked when an extension without a decision connects, so that the user
+// can be told; it may be nil.
+func NewGate(acl *ACL, serve, refuse func(id string, port interface{}), notify func(id string)) *Gate {
+	if notify == nil {
+		notify = func(id string) {}
+	}
+	return &Gate{
+		acl:    acl,
+		serve:  serve,
+		refuse: refuse,
+		notify: notify,
+		queued: make(map[string][]interface{}),
+	}
+}
+
+// Connect handles a new connection from the extension with the specified
+// ID.
+func (g *Gate) Connect(id string, port interface{}) {
+	g.acl.Check(id, func(decision Decision, err error) {
+		if err != nil {
+			log.Printf("Refusing connection from extension %s: %v", id, err)
+			g.refuse(id, port)
+			return
+		}
+		switch decision {
+		case Approved:
+			g.serve(id, port)
+		case Denied:
+			g.refuse(id, port)
+		default:
+			// The user is only asked once, however many times
+			// the extension connects.
+			asked := decision == Pending || len(g.queued[id]) > 0
+			g.queued[id] = append(g.queued[id], port)
+			if asked {
+				return
+			}
+			g.acl.Request(id, func(err error) {
+				if err != nil {
+					log.Printf("Failed to record connection from extension %s: %v", id, err)
+					return
+				}
+				g.notify(id)
+			})
+		}
+	})
+}
+
+// Disconnected removes a queued connection that was closed by the
+// extension before the user decided whether to serve it.
+func (g *Gate) Disconnected(id string, port interface{}) {
+	var remaining []interface{}
+	for _, p := range g.queued[id] {
+		if p != port {
+			remaining = append(remaining, p)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(g.queued, id)
+		return
+	}
+	g.queued[id] = remaining
+}
+
+// Update serves or refuses queued connections according to the user's
+// decisions.  It should be invoked whenever the decisions change.
+func (g *Gate) Update() {
+	for id := range g.queued {
+		id := id
+		g.acl.Check(id, func(decision Decision, err error) {
+			if err != nil {
+				log.Printf("Failed to check decision for extension %s: %v", id, err)
+				return
+			}
+			if decision != Approved && decision != Denied {
+				return
+			}
+			ports := g.queued[id]
+			delete(g.queued, id)
+			for _, p := range ports {
+				if decision == Approved {
+					g.serve(id, p)
+				} else {
+					g.refuse(id, p)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package external
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/kr/pretty"
+)
+
+// fakePort is a connection passed to a Gate.
+type fakePort struct {
+	name string
+}
+
+// gateEvents records the callbacks invoked by a Gate.
+type gateEvents struct {
+	served   []string
+	refused  []string
+	notified []string
+}
+
+func newTestGate(a *ACL) (*Gate, *gateEvents) {
+	ev := &gateEvents{}
+	g := NewGate(a, func(id string, port interface{}) {
+		ev.served = append(ev.served, port.(*fakePort).name)
+	}, func(id string, port interface{}) {
+		ev.refused = append(ev.refused, port.(*fakePort).name)
+	}, func(id string) {
+		ev.notified = append(ev.notified, id)
+	})
+	return g, ev
+}
+
+func TestGate(t *testing.T) {
+	testcases := []struct {
+		description string
+		actions     func(a *ACL, g *Gate)
+		want        *gateEvents
+		wantQueued  int
+	}{
+		{
+			description: "serve trusted extension",
+			actions: func(a *ACL, g *Gate) {
+				g.Connect(secureShellID, &fakePort{"a"})
+			},
+			want: &gateEvents{served: []string{"a"}},
+		},
+		{
+			description: "queue unknown extension",
+			actions: func(a *ACL, g *Gate) {
+				g.Connect(otherID, &fakePort{"a"})
+				g.Connect(otherID, &fakePort{"b"})
+			},
+			want:       &gateEvents{notified: []string{otherID}},
+			wantQueued: 2,
+		},
+		{
+			description: "serve queued connections once approved",
+			actions: func(a *ACL, g *Gate) {
+				g.Connect(otherID, &fakePort{"a"})
+				g.Connect(anotherID, &fakePort{"b"})
+				a.Approve(otherID, func(err error) {
+					g.Update()
+				})
+			},
+			want:       &gateEvents{served: []string{"a"}, notified: []string{otherID, anotherID}},
+			wantQueued: 1,
+		},
+		{
+			description: "refuse queued connections once denied",
+			actions: func(a *ACL, g *Gate) {
+				g.Connect(otherID, &fakePort{"a"})
+				a.Deny(otherID, func(err error) {
+					g.Update()
+				})
+			},
+			want: &gateEvents{refused: []string{"a"}, notified: []string{otherID}},
+		},
+		{
+			description: "drop disconnected connections",
+			actions: func(a *ACL, g *Gate) {
+				p := &fakePort{"a"}
+				g.Connect(otherID, p)
+				g.Disconnected(otherID, p)
+				a.Approve(otherID, func(err error) {
+					g.Update()
+				})
+			},
+			want: &gateEvents{notified: []string{otherID}},
+		},
+		{
+			description: "serve approved and refuse denied extensions",
+			actions: func(a *ACL, g *Gate) {
+				a.Approve(otherID, func(err error) {
+					a.Deny(anotherID, func(err error) {
+						g.Connect(otherID, &fakePort{"a"})
+						g.Connect(anotherID, &fakePort{"b"})
+					})
+				})
+			},
+			want: &gateEvents{served: []string{"a"}, refused: []string{"b"}},
+		},
+	}
+
+	for _, tc := range testcases {
+		a := NewACL(fakes.NewMemStorage(), nil)
+		g, ev := newTestGate(a)
+		tc.actions(a, g)
+		if diff := pretty.Diff(ev, tc.want); diff != nil {
+			t.Errorf("%s: incorrect events; -got +want: %s", tc.description, diff)
+		}
+		queued := 0
+		for _, ports := range g.queued {
+			queued += len(ports)
+		}
+		if queued != tc.wantQueued {
+			t.Errorf("%s: incorrect number of queued connections; got %d, want %d", tc.description, queued, tc.wantQueued)
+		}
+	}
+}
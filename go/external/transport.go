@@ -0,0 +1,86 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package external
+
+import (
+	"github.com/google/chrome-ssh-agent/go/transport"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// admission is a connection awaiting a decision from a TransportACL.
+type admission struct {
+	serve  func()
+	refuse func(reason string)
+}
+
+// TransportACL is a transport.ACL that admits connections from other
+// extensions using a Gate.  Connections from extensions without a decision
+// are queued until the user decides; Update must be invoked whenever the
+// decisions change.
+type TransportACL struct {
+	*Gate
+	// pending contains the callbacks for each connection passed to the
+	// Gate, until it is served or refused.
+	pending map[transport.Conn]*admission
+}
+
+// NewTransportACL returns a TransportACL that checks connections against
+// acl.  notify is invoked when an extension without a decision connects;
+// it may be nil.
+func NewTransportACL(acl *ACL, notify func(id string)) *TransportACL {
+	t := &TransportACL{
+		pending: make(map[transport.Conn]*admission),
+	}
+	t.Gate = NewGate(acl, func(id string, port interface{}) {
+		if a := t.take(port); a != nil {
+			a.serve()
+		}
+	}, func(id string, port interface{}) {
+		if a := t.take(port); a != nil {
+			a.refuse("extension is not approved")
+		}
+	}, notify)
+	return t
+}
+
+// take removes and returns the callbacks for a connection passed to the
+// Gate, or nil if there are none.
+func (t *TransportACL) take(port interface{}) *admission {
+	c, ok := port.(transport.Conn)
+	if !ok {
+		return nil
+	}
+	a := t.pending[c]
+	delete(t.pending, c)
+	return a
+}
+
+// Admit implements transport.ACL.Admit.
+func (t *TransportACL) Admit(c transport.Conn, serve func(), refuse func(reason string)) {
+	t.pending[c] = &admission{serve: serve, refuse: refuse}
+	t.Connect(c.ID(), c)
+}
+
+// Disconnected implements transport.ACL.Disconnected.
+func (t *TransportACL) Disconnected(c transport.Conn) {
+	delete(t.pending, c)
+	t.Gate.Disconnected(c.ID(), c)
+}
+
+// Limit implements transport.Limiter.Limit.  Admitted extensions may only
+// make the requests permitted by the scopes granted to them.
+func (t *TransportACL) Limit(c transport.Conn, a agent.Agent) agent.Agent {
+	return NewScopedAgent(a, t.acl, c.ID())
+}
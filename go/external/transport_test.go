@@ -0,0 +1,88 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package external
+
+import (
+	"io"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/kr/pretty"
+)
+
+// fakeConn is a connection from another extension.
+type fakeConn struct {
+	io.ReadWriter
+	id   string
+	name string
+}
+
+func (f *fakeConn) ID() string        { return f.id }
+func (f *fakeConn) Requester() string { return f.id }
+func (f *fakeConn) Channel() string   { return "" }
+func (f *fakeConn) Close()            {}
+
+func TestTransportACL(t *testing.T) {
+	a := NewACL(fakes.NewMemStorage(), nil)
+	var notified []string
+	acl := NewTransportACL(a, func(id string) {
+		notified = append(notified, id)
+	})
+
+	ev := &gateEvents{}
+	admit := func(c *fakeConn) {
+		acl.Admit(c, func() {
+			ev.served = append(ev.served, c.name)
+		}, func(reason string) {
+			ev.refused = append(ev.refused, c.name+": "+reason)
+		})
+	}
+
+	// Trusted extensions are served immediately.
+	admit(&fakeConn{id: secureShellID, name: "a"})
+
+	// Other extensions are queued until the user decides; connections
+	// closed before then are forgotten.
+	admit(&fakeConn{id: otherID, name: "b"})
+	closed := &fakeConn{id: otherID, name: "c"}
+	admit(closed)
+	admit(&fakeConn{id: anotherID, name: "d"})
+	acl.Disconnected(closed)
+	a.Approve(otherID, func(err error) {
+		if err != nil {
+			t.Fatalf("failed to approve extension: %v", err)
+		}
+	})
+	a.Deny(anotherID, func(err error) {
+		if err != nil {
+			t.Fatalf("failed to deny extension: %v", err)
+		}
+	})
+	acl.Update()
+
+	want := &gateEvents{
+		served:  []string{"a", "b"},
+		refused: []string{"d: extension is not approved"},
+	}
+	if diff := pretty.Diff(ev, want); diff != nil {
+		t.Errorf("incorrect events; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(notified, []string{otherID, anotherID}); diff != nil {
+		t.Errorf("incorrect notifications; -got +want: %s", diff)
+	}
+	if len(acl.pending) != 0 {
+		t.Errorf("connections still pending: %d", len(acl.pending))
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package faults injects artificial latency and failures into storage, and
+// restarts the background page, so that loading states, retries and
+// reconciliation can be exercised during development.
+//
+// Fault injection is disabled unless configured in local storage under
+// ConfigKey, for example from the background page's console:
+//
+//	chrome.storage.local.set({'dev.faults': {
+//	  latencyMs: 500, failureRate: 0.1, restartSeconds: 300}})
+//
+// Remove the item to disable it again.  It is never configured from the
+// options page.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/storage"
+)
+
+// ConfigKey is the key under which fault injection is configured in local
+// storage.
+const ConfigKey = "dev.faults"
+
+// ErrInjected is returned by storage operations that fail because of fault
+// injection.
+var ErrInjected = errors.New("injected fault")
+
+// Config configures fault injection.
+type Config struct {
+	// LatencyMillis is the largest delay added to each storage operation,
+	// in milliseconds.  Each operation is delayed by a random amount up
+	// to this.
+	LatencyMillis int
+	// FailureRate is the probability (between 0 and 1) that a storage
+	// operation fails with ErrInjected, without being performed.
+	FailureRate float64
+	// RestartSeconds is the average interval between restarts of the
+	// background page, in seconds.  Zero disables restarts.
+	RestartSeconds int
+}
+
+// Enabled determines if the configuration injects any faults.
+func (c *Config) Enabled() bool {
+	return c.LatencyMillis > 0 || c.FailureRate > 0 || c.RestartSeconds > 0
+}
+
+// String describes the configuration for logging.
+func (c *Config) String() string {
+	return fmt.Sprintf("latency up to %dms, failure rate %g, restart every %ds", c.LatencyMillis, c.FailureRate, c.RestartSeconds)
+}
+
+// number returns the number stored under key in a configuration read from
+// storage.  Numbers are decoded from storage as float64.
+func number(data map[string]interface{}, key string) float64 {
+	n, _ := data[key].(float64)
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// ReadConfig reads the fault injection configuration from store.  The
+// configuration is empty (injecting no faults) if none is stored.
+func ReadConfig(store storage.Area, callback func(config *Config, err error)) {
+	store.GetItems([]string{ConfigKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(&Config{}, fmt.Errorf("failed to read fault injection configuration: %v", err))
+			return
+		}
+		raw, _ := data[ConfigKey].(map[string]interface{})
+		c := &Config{
+			LatencyMillis:  int(number(raw, "latencyMs")),
+			FailureRate:    number(raw, "failureRate"),
+			RestartSeconds: int(number(raw, "restartSeconds")),
+		}
+		if c.FailureRate > 1 {
+			c.FailureRate = 1
+		}
+		callback(c, nil)
+	})
+}
+
+// Changed determines if the fault injection configuration is among the
+// changes reported by chrome.Storage.OnChanged.
+func Changed(changes map[string]interface{}) bool {
+	_, ok := changes[ConfigKey]
+	return ok
+}
+
+// Injector injects faults according to its current configuration.
+type Injector struct {
+	mu     sync.Mutex
+	config Config
+	rand   *rand.Rand
+	// after invokes f once d has elapsed; it is replaced in tests.
+	after func(d time.Duration, f func())
+	// restart is invoked to restart the background page, or nil if
+	// restarts are not injected.
+	restart func()
+	// generation is incremented when the configuration changes, so that
+	// restarts scheduled under an earlier configuration are abandoned.
+	generation int
+}
+
+// New returns an Injector that injects no faults until it is configured.
+func New() *Injector {
+	return &Injector{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		after: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+	}
+}
+
+// OnRestart installs the function used to restart the background page.
+// Restarts are only injected once it is installed.
+func (i *Injector) OnRestart(restart func()) {
+	i.mu.Lock()
+	i.restart = restart
+	i.mu.Unlock()
+
+	i.scheduleRestart()
+}
+
+// Configure replaces the configuration.
+func (i *Injector) Configure(c *Config) {
+	i.mu.Lock()
+	i.config = *c
+	i.generation++
+	i.mu.Unlock()
+
+	if c.Enabled() {
+		log.Printf("FAULTS: fault injection enabled: %s", c)
+	}
+	i.scheduleRestart()
+}
+
+// Load reads the configuration from store, and applies it.  callback is
+// invoked when complete; it may be nil.
+func (i *Injector) Load(store storage.Area, callback func(err error)) {
+	ReadConfig(store, func(c *Config, err error) {
+		i.Configure(c)
+		if callback != nil {
+			callback(err)
+		}
+	})
+}
+
+// scheduleRestart schedules a restart, if restarts are configured.  The
+// delay is chosen at random between half and one and a half times the
+// configured interval.
+func (i *Injector) scheduleRestart() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.restart == nil || i.config.RestartSeconds <= 0 {
+		return
+	}
+	interval := time.Duration(i.config.RestartSeconds) * time.Second
+	delay := interval/2 + time.Duration(i.rand.Int63n(int64(interval)+1))
+	generation, restart := i.generation, i.restart
+	i.after(delay, func() {
+		i.mu.Lock()
+		current := i.generation == generation
+		i.mu.Unlock()
+		if !current {
+			return
+		}
+		log.Printf("FAULTS: restarting background page")
+		restart()
+	})
+}
+
+// inject delays an operation by a random latency, and then invokes either
+// proceed or fail.  Without latency, the operation is invoked immediately.
+func (i *Injector) inject(op string, proceed func(), fail func(err error)) {
+	i.mu.Lock()
+	var delay time.Duration
+	if i.config.LatencyMillis > 0 {
+		delay = time.Duration(i.rand.Int63n(int64(i.config.LatencyMillis)+1)) * time.Millisecond
+	}
+	failed := i.config.FailureRate > 0 && i.rand.Float64() < i.config.FailureRate
+	i.mu.Unlock()
+
+	run := func() {
+		if failed {
+			log.Printf("FAULTS: failing %s", op)
+			fail(fmt.Errorf("%s failed: %v", op, ErrInjected))
+			return
+		}
+		proceed()
+	}
+	if delay == 0 {
+		run()
+		return
+	}
+	i.after(delay, run)
+}
+
+// Wrap returns a storage area that performs operations using s, injecting
+// faults according to the Injector's configuration at the time of each
+// operation.  area names the storage area in logs (e.g., 'sync').
+func (i *Injector) Wrap(s storage.Area, area string) storage.Area {
+	return &store{s: s, i: i, area: area}
+}
+
+// store is a storage area that injects faults.
+type store struct {
+	s    storage.Area
+	i    *Injector
+	area string
+}
+
+func (s *store) Set(data map[string]interface{}, callback func(err error)) {
+	s.i.inject(s.area+" storage set", func() {
+		s.s.Set(data, callback)
+	}, callback)
+}
+
+func (s *store) Get(callback func(data map[string]interface{}, err error)) {
+	s.i.inject(s.area+" storage get", func() {
+		s.s.Get(callback)
+	}, func(err error) {
+		callback(nil, err)
+	})
+}
+
+func (s *store) GetItems(keys []string, callback func(data map[string]interface{}, err error)) {
+	s.i.inject(s.area+" storage get", func() {
+		s.s.GetItems(keys, callback)
+	}, func(err error) {
+		callback(nil, err)
+	})
+}
+
+func (s *store) Delete(keys []string, callback func(err error)) {
+	s.i.inject(s.area+" storage delete", func() {
+		s.s.Delete(keys, callback)
+	}, callback)
+}
+
+func (s *store) BytesInUse(keys []string, callback func(bytes int, err error)) {
+	s.i.inject(s.area+" storage usage", func() {
+		s.s.BytesInUse(keys, callback)
+	}, func(err error) {
+		callback(0, err)
+	})
+}
+
+func (s *store) Quota() (total, perItem int) {
+	return s.s.Quota()
+}
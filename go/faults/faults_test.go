@@ -0,0 +1,208 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package faults
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/kr/pretty"
+)
+
+// newTestInjector returns an Injector with deterministic randomness, whose
+// delayed operations are recorded and run immediately.
+func newTestInjector(c *Config) (*Injector, *[]time.Duration) {
+	var delays []time.Duration
+	i := New()
+	i.rand = rand.New(rand.NewSource(1))
+	i.after = func(d time.Duration, f func()) {
+		delays = append(delays, d)
+		f()
+	}
+	i.Configure(c)
+	return i, &delays
+}
+
+func TestReadConfig(t *testing.T) {
+	testcases := []struct {
+		description string
+		stored      interface{}
+		want        *Config
+	}{
+		{
+			description: "not configured",
+			want:        &Config{},
+		},
+		{
+			description: "configured",
+			stored: map[string]interface{}{
+				"latencyMs":      500,
+				"failureRate":    0.25,
+				"restartSeconds": 60,
+			},
+			want: &Config{LatencyMillis: 500, FailureRate: 0.25, RestartSeconds: 60},
+		},
+		{
+			description: "out of range",
+			stored: map[string]interface{}{
+				"latencyMs":   -5,
+				"failureRate": 3,
+			},
+			want: &Config{FailureRate: 1},
+		},
+		{
+			description: "malformed",
+			stored:      "fail everything",
+			want:        &Config{},
+		},
+	}
+
+	for _, tc := range testcases {
+		store := fakes.NewMemStorage()
+		if tc.stored != nil {
+			store.Set(map[string]interface{}{ConfigKey: tc.stored}, func(err error) {
+				if err != nil {
+					t.Fatalf("%s: failed to store configuration: %v", tc.description, err)
+				}
+			})
+		}
+		ReadConfig(store, func(c *Config, err error) {
+			if err != nil {
+				t.Errorf("%s: failed to read configuration: %v", tc.description, err)
+			}
+			if diff := pretty.Diff(c, tc.want); diff != nil {
+				t.Errorf("%s: incorrect configuration; -got +want: %s", tc.description, diff)
+			}
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	testcases := []struct {
+		description string
+		config      *Config
+		wantErr     bool
+		wantDelay   bool
+	}{
+		{
+			description: "disabled",
+			config:      &Config{},
+		},
+		{
+			description: "latency",
+			config:      &Config{LatencyMillis: 1000},
+			wantDelay:   true,
+		},
+		{
+			description: "failure",
+			config:      &Config{FailureRate: 1},
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		i, delays := newTestInjector(tc.config)
+		underlying := fakes.NewMemStorage()
+		s := i.Wrap(underlying, "test")
+
+		var setErr error
+		s.Set(map[string]interface{}{"some-key": "some-value"}, func(err error) {
+			setErr = err
+		})
+		if got := setErr != nil; got != tc.wantErr {
+			t.Errorf("%s: incorrect error from Set; got %v, want error %t", tc.description, setErr, tc.wantErr)
+		}
+
+		var got map[string]interface{}
+		var getErr error
+		s.GetItems([]string{"some-key"}, func(data map[string]interface{}, err error) {
+			got, getErr = data, err
+		})
+		if tc.wantErr {
+			if getErr == nil {
+				t.Errorf("%s: GetItems succeeded", tc.description)
+			}
+		} else if diff := pretty.Diff(got, map[string]interface{}{"some-key": "some-value"}); diff != nil {
+			t.Errorf("%s: incorrect data; -got +want: %s", tc.description, diff)
+		}
+
+		// A failed write is not performed.
+		underlying.GetItems([]string{"some-key"}, func(data map[string]interface{}, err error) {
+			if _, ok := data["some-key"]; ok == tc.wantErr {
+				t.Errorf("%s: incorrect stored data: %v", tc.description, data)
+			}
+		})
+
+		if got := len(*delays) > 0; got != tc.wantDelay {
+			t.Errorf("%s: incorrect delays: %v", tc.description, *delays)
+		}
+		for _, d := range *delays {
+			if d > time.Duration(tc.config.LatencyMillis)*time.Millisecond {
+				t.Errorf("%s: delay %v exceeds configured latency", tc.description, d)
+			}
+		}
+	}
+}
+
+func TestWrapFailureMessage(t *testing.T) {
+	i, _ := newTestInjector(&Config{FailureRate: 1})
+	s := i.Wrap(fakes.NewMemStorage(), "sync")
+	s.Delete([]string{"some-key"}, func(err error) {
+		if diff := pretty.Diff(err, errors.New("sync storage delete failed: injected fault")); diff != nil {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestRestart(t *testing.T) {
+	var scheduled []func()
+	i := New()
+	i.rand = rand.New(rand.NewSource(1))
+	var delays []time.Duration
+	i.after = func(d time.Duration, f func()) {
+		delays = append(delays, d)
+		scheduled = append(scheduled, f)
+	}
+
+	restarts := 0
+	i.OnRestart(func() { restarts++ })
+	if len(scheduled) != 0 {
+		t.Errorf("restart scheduled while disabled")
+	}
+
+	i.Configure(&Config{RestartSeconds: 60})
+	if len(scheduled) != 1 {
+		t.Fatalf("incorrect number of scheduled restarts; got %d, want 1", len(scheduled))
+	}
+	if delays[0] < 30*time.Second || delays[0] > 90*time.Second {
+		t.Errorf("restart delay %v out of range", delays[0])
+	}
+
+	// Changing the configuration abandons the scheduled restart.
+	i.Configure(&Config{})
+	scheduled[0]()
+	if restarts != 0 {
+		t.Errorf("abandoned restart performed")
+	}
+
+	i.Configure(&Config{RestartSeconds: 60})
+	scheduled[len(scheduled)-1]()
+	if restarts != 1 {
+		t.Errorf("incorrect number of restarts; got %d, want 1", restarts)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package help
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kr/pretty"
+)
+
+// codedError is an error that implements Coder.
+type codedError struct {
+	code Code
+}
+
+func (e *codedError) Error() string {
+	return "coded error"
+}
+
+func (e *codedError) HelpCode() Code {
+	return e.code
+}
+
+func TestWrap(t *testing.T) {
+	testcases := []struct {
+		description string
+		err         error
+		wantCode    Code
+		wantMsg     string
+	}{
+		{
+			description: "error without help topic",
+			err:         errors.New("some error"),
+			wantCode:    None,
+			wantMsg:     "failed: some error",
+		},
+		{
+			description: "error with help topic",
+			err:         Errorf(IncorrectPassphrase, "bad %s", "passphrase"),
+			wantCode:    IncorrectPassphrase,
+			wantMsg:     "failed: bad passphrase",
+		},
+		{
+			description: "error that carries its own help topic",
+			err:         &codedError{code: NameTaken},
+			wantCode:    NameTaken,
+			wantMsg:     "failed: coded error",
+		},
+		{
+			description: "wrapped error with help topic",
+			err:         Wrap(Errorf(KeyNotFound, "no key"), "inner"),
+			wantCode:    KeyNotFound,
+			wantMsg:     "failed: inner: no key",
+		},
+	}
+
+	for _, tc := range testcases {
+		err := Wrap(tc.err, "failed")
+		if diff := pretty.Diff(CodeOf(err), tc.wantCode); diff != nil {
+			t.Errorf("%s: incorrect code; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(err.Error(), tc.wantMsg); diff != nil {
+			t.Errorf("%s: incorrect message; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestTopics(t *testing.T) {
+	codes := []Code{
+		InvalidPrivateKey,
+		IncorrectPassphrase,
+		KeyNotFound,
+		StorageFailure,
+		StorageQuotaExceeded,
+		InvalidName,
+		NameTaken,
+		KeyNotAllowed,
+		ConnectSecureShell,
+		PermissionDenied,
+		Timeout,
+		EntropyUnavailable,
+		TooManyAttempts,
+		ApprovalRequired,
+		DegradedStorage,
+		InvalidCertificate,
+		KeyRevoked,
+		VaultLocked,
+		UnsupportedKeyType,
+	}
+	for _, c := range codes {
+		topic := Lookup(c)
+		if topic == nil {
+			t.Errorf("%s: missing topic", c)
+			continue
+		}
+		if topic.Title == "" || len(topic.Paragraphs) == 0 {
+			t.Errorf("%s: empty topic", c)
+		}
+	}
+
+	if Lookup(None) != nil {
+		t.Errorf("unexpected topic for None")
+	}
+}
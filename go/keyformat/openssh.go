@@ -0,0 +1,238 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyformat
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/google/chrome-ssh-agent/go/keytype"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// opensshMagic begins every key in OpenSSH's format.
+	opensshMagic = "openssh-key-v1\x00"
+	// opensshRounds is the number of bcrypt_pbkdf rounds used to derive
+	// the encryption key; it matches the default used by ssh-keygen.
+	opensshRounds = 16
+	// opensshSaltSize is the size of the bcrypt_pbkdf salt.
+	opensshSaltSize = 16
+)
+
+// MarshalOpenSSH returns the private key as a PEM-encoded key in OpenSSH's
+// format ('openssh-key-v1').  If passphrase is empty the key is not
+// encrypted.  r is the source of randomness for the salt and check values.
+//
+// See PROTOCOL.key in the OpenSSH sources for details on the format.
+func MarshalOpenSSH(priv interface{}, comment string, passphrase []byte, r io.Reader) (string, error) {
+	priv, err := normalizePrivateKey(priv)
+	if err != nil {
+		return "", err
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine public key: %v", err)
+	}
+
+	check, err := randomBytes(r, 4)
+	if err != nil {
+		return "", err
+	}
+	checkVal := binary.BigEndian.Uint32(check)
+	privBlock := ssh.Marshal(struct {
+		Check1 uint32
+		Check2 uint32
+	}{checkVal, checkVal})
+	keyBlock, err := opensshKeyBlock(priv)
+	if err != nil {
+		return "", err
+	}
+	privBlock = append(privBlock, keyBlock...)
+	privBlock = append(privBlock, ssh.Marshal(struct{ Comment string }{comment})...)
+
+	cipherName, kdfName, kdfOpts := "none", "none", ""
+	blockSize := 8
+	var stream cipher.Stream
+	if len(passphrase) > 0 {
+		salt, err := randomBytes(r, opensshSaltSize)
+		if err != nil {
+			return "", err
+		}
+		k, err := bcryptPBKDF(passphrase, salt, opensshRounds, 32+aes.BlockSize)
+		if err != nil {
+			return "", fmt.Errorf("failed to derive key: %v", err)
+		}
+		block, err := aes.NewCipher(k[:32])
+		if err != nil {
+			return "", fmt.Errorf("failed to create cipher: %v", err)
+		}
+		stream = cipher.NewCTR(block, k[32:])
+		cipherName, kdfName = "aes256-ctr", "bcrypt"
+		kdfOpts = string(ssh.Marshal(struct {
+			Salt   []byte
+			Rounds uint32
+		}{salt, opensshRounds}))
+		blockSize = aes.BlockSize
+	}
+
+	// The private section is padded to the cipher block size with the
+	// bytes 1, 2, 3, ...
+	for i := byte(1); len(privBlock)%blockSize != 0; i++ {
+		privBlock = append(privBlock, i)
+	}
+	if stream != nil {
+		stream.XORKeyStream(privBlock, privBlock)
+	}
+
+	body := ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{cipherName, kdfName, kdfOpts, 1, signer.PublicKey().Marshal(), privBlock})
+
+	b := pem.EncodeToMemory(&pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte(opensshMagic), body...),
+	})
+	return string(b), nil
+}
+
+// opensshKeyBlock returns the type-specific portion of a private key in
+// OpenSSH's format.
+func opensshKeyBlock(priv interface{}) ([]byte, error) {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return ssh.Marshal(struct {
+			Keytype string
+			N       *big.Int
+			E       *big.Int
+			D       *big.Int
+			Iqmp    *big.Int
+			P       *big.Int
+			Q       *big.Int
+		}{ssh.KeyAlgoRSA, k.N, big.NewInt(int64(k.E)), k.D, rsaQInv(k), k.Primes[0], k.Primes[1]}), nil
+	case *ecdsa.PrivateKey:
+		curve, err := curveName(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.Marshal(struct {
+			Keytype string
+			Curve   string
+			Pub     []byte
+			D       *big.Int
+		}{"ecdsa-sha2-" + curve, curve, elliptic.Marshal(k.Curve, k.X, k.Y), k.D}), nil
+	case ed25519.PrivateKey:
+		return ssh.Marshal(struct {
+			Keytype string
+			Pub     []byte
+			Priv    []byte
+		}{ssh.KeyAlgoED25519, []byte(k.Public().(ed25519.PublicKey)), []byte(k)}), nil
+	}
+	return nil, errors.New("unsupported private key type")
+}
+
+// InspectOpenSSH returns the public key and comment recorded in a PEM-encoded
+// private key in OpenSSH's format, without decrypting it.  The public key is
+// stored in the clear, but the comment is stored with the private key; it is
+// empty if the private key is encrypted.  The public key of an algorithm
+// that cannot be used for signing is returned unparsed; see
+// keytype.ParsePublicKey.
+func InspectOpenSSH(pemBytes []byte) (pub ssh.PublicKey, comment string, err error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+		return nil, "", errors.New("not a private key in OpenSSH format")
+	}
+	if len(block.Bytes) < len(opensshMagic) || string(block.Bytes[:len(opensshMagic)]) != opensshMagic {
+		return nil, "", errors.New("invalid OpenSSH private key")
+	}
+
+	var body struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}
+	if err := ssh.Unmarshal(block.Bytes[len(opensshMagic):], &body); err != nil {
+		return nil, "", fmt.Errorf("invalid OpenSSH private key: %v", err)
+	}
+	if body.NumKeys != 1 {
+		return nil, "", fmt.Errorf("OpenSSH private key must contain one key; got %d", body.NumKeys)
+	}
+	pub, err = keytype.ParsePublicKey(body.PubKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid OpenSSH public key: %v", err)
+	}
+	if body.CipherName != "none" {
+		return pub, "", nil
+	}
+
+	// The private section holds two check values, the key type, the
+	// type-specific fields, and the comment.
+	rest := body.PrivKeyBlock
+	if len(rest) < 8 {
+		return pub, "", nil
+	}
+	rest = rest[8:]
+	keyType, rest, ok := opensshString(rest)
+	if !ok {
+		return pub, "", nil
+	}
+	t := keytype.Lookup(string(keyType))
+	if t == nil || t.PrivateFields == 0 {
+		return pub, "", nil
+	}
+	fields := t.PrivateFields
+	for i := 0; i < fields; i++ {
+		if _, rest, ok = opensshString(rest); !ok {
+			return pub, "", nil
+		}
+	}
+	c, _, ok := opensshString(rest)
+	if !ok {
+		return pub, "", nil
+	}
+	return pub, string(c), nil
+}
+
+// opensshString reads a length-prefixed string from b, returning the string
+// and the remainder of b.  ok is false if b is too short.
+func opensshString(b []byte) (s, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	if uint32(len(b)) < n {
+		return nil, nil, false
+	}
+	return b[:n], b[n:], true
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyformat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// pkcs8Iterations is the number of PBKDF2 iterations used to derive
+	// the encryption key.
+	pkcs8Iterations = 100000
+	// pkcs8SaltSize is the size of the PBKDF2 salt.
+	pkcs8SaltSize = 16
+)
+
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidEd25519    = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// pkcs8 is an unencrypted PKCS#8 PrivateKeyInfo.  See RFC 5208.
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// encryptedPKCS8 is a PKCS#8 EncryptedPrivateKeyInfo.  See RFC 5208.
+type encryptedPKCS8 struct {
+	Algo          pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params are the parameters for PBES2.  See RFC 8018.
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the parameters for PBKDF2.  See RFC 8018.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	PRF            pkix.AlgorithmIdentifier
+}
+
+// marshalPKCS8PrivateKey returns the unencrypted PKCS#8 encoding of the
+// private key.
+func marshalPKCS8PrivateKey(priv interface{}) ([]byte, error) {
+	k, ok := priv.(ed25519.PrivateKey)
+	if !ok {
+		return x509.MarshalPKCS8PrivateKey(priv)
+	}
+
+	// The standard library does not support ED25519 keys; encode them as
+	// described in RFC 8410.
+	seed, err := asn1.Marshal(k[:32])
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs8{
+		Algo:       pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+		PrivateKey: seed,
+	})
+}
+
+// ParsePKCS8PrivateKey parses an unencrypted PKCS#8 PrivateKeyInfo.  Unlike
+// x509.ParsePKCS8PrivateKey, it supports ED25519 keys (RFC 8410).
+func ParsePKCS8PrivateKey(der []byte) (interface{}, error) {
+	var info pkcs8
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS#8 private key: %v", err)
+	}
+	if !info.Algo.Algorithm.Equal(oidEd25519) {
+		return x509.ParsePKCS8PrivateKey(der)
+	}
+
+	var seed []byte
+	if _, err := asn1.Unmarshal(info.PrivateKey, &seed); err != nil {
+		return nil, fmt.Errorf("failed to parse ED25519 private key: %v", err)
+	}
+	if len(seed) != 32 {
+		return nil, fmt.Errorf("ED25519 private key must be 32 bytes; got %d", len(seed))
+	}
+	_, priv, err := ed25519.GenerateKey(bytes.NewReader(seed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive ED25519 private key: %v", err)
+	}
+	return priv, nil
+}
+
+// marshalPKCS8 returns the private key as a PEM-encoded PKCS#8
+// EncryptedPrivateKeyInfo, encrypted using the passphrase.
+func marshalPKCS8(priv interface{}, passphrase []byte, r io.Reader) (string, error) {
+	der, err := marshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode private key: %v", err)
+	}
+
+	salt, err := randomBytes(r, pkcs8SaltSize)
+	if err != nil {
+		return "", err
+	}
+	iv, err := randomBytes(r, aes.BlockSize)
+	if err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key(passphrase, salt, pkcs8Iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cipher: %v", err)
+	}
+
+	// Pad to the block size as described in RFC 5652, section 6.3.
+	pad := aes.BlockSize - len(der)%aes.BlockSize
+	data := append(der, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pkcs8Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return "", err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return "", err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return "", err
+	}
+	encoded, err := asn1.Marshal(encryptedPKCS8{
+		Algo:          pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: data,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode encrypted private key: %v", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encoded})), nil
+}
@@ -0,0 +1,492 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyformat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Argon2id parameters used to derive the encryption key.  The memory
+	// and parallelism match PuTTYgen's defaults; PuTTYgen calibrates the
+	// number of passes, so a fixed value is used instead.
+	ppkArgonMemory      = 8192
+	ppkArgonPasses      = 13
+	ppkArgonParallelism = 1
+	ppkSaltSize         = 16
+	// ppkLineLength is the length of each line of base64-encoded data.
+	ppkLineLength = 64
+	// ppkHeader prefixes the first line of a key in PuTTY's format; it is
+	// followed by the version.
+	ppkHeader = "PuTTY-User-Key-File-"
+	// ppkMaxLines is the maximum number of lines of base64-encoded data
+	// accepted for the public or private portion of a key.
+	ppkMaxLines = 1024
+	// ppkMaxArgonMemory and ppkMaxArgonPasses bound the work needed to
+	// derive the key for an encrypted key file, so that a malicious
+	// file cannot exhaust the browser's memory.  They are well above
+	// the values PuTTYgen chooses.
+	ppkMaxArgonMemory = 256 * 1024
+	ppkMaxArgonPasses = 1000
+	// ppkV2MACKey is hashed with the passphrase to derive the MAC key in
+	// version 2 key files.
+	ppkV2MACKey = "putty-private-key-file-mac-key"
+	// ppkEd25519SeedSize is the size of the seed from which an Ed25519
+	// private key is derived, which is how PuTTY stores the key.
+	ppkEd25519SeedSize = 32
+)
+
+// ppkPrivateBlob returns the private portion of a key in PuTTY's format.
+func ppkPrivateBlob(priv interface{}) ([]byte, error) {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return ssh.Marshal(struct {
+			D    *big.Int
+			P    *big.Int
+			Q    *big.Int
+			Iqmp *big.Int
+		}{k.D, k.Primes[0], k.Primes[1], rsaQInv(k)}), nil
+	case *ecdsa.PrivateKey:
+		return ssh.Marshal(struct{ D *big.Int }{k.D}), nil
+	case ed25519.PrivateKey:
+		// The private key is stored as the 32-byte seed.
+		return ssh.Marshal(struct{ Seed []byte }{[]byte(k[:32])}), nil
+	}
+	return nil, errors.New("unsupported private key type")
+}
+
+// ppkLines returns data base64-encoded and split into lines.
+func ppkLines(data []byte) []string {
+	s := base64.StdEncoding.EncodeToString(data)
+	var lines []string
+	for len(s) > ppkLineLength {
+		lines = append(lines, s[:ppkLineLength])
+		s = s[ppkLineLength:]
+	}
+	return append(lines, s)
+}
+
+// marshalPPK returns the private key in PuTTY's format (version 3), encrypted
+// using the passphrase.
+//
+// See https://the.earth.li/~sgtatham/putty/latest/htmldoc/AppendixC.html.
+func marshalPPK(priv interface{}, comment string, passphrase []byte, r io.Reader) (string, error) {
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine public key: %v", err)
+	}
+	pub := signer.PublicKey()
+	privBlob, err := ppkPrivateBlob(priv)
+	if err != nil {
+		return "", err
+	}
+	comment = strings.Replace(comment, "\n", " ", -1)
+
+	salt, err := randomBytes(r, ppkSaltSize)
+	if err != nil {
+		return "", err
+	}
+	// The derived key material is the cipher key, followed by the IV,
+	// followed by the MAC key.
+	k := argon2.IDKey(passphrase, salt, ppkArgonPasses, ppkArgonMemory, ppkArgonParallelism, 32+aes.BlockSize+32)
+	cipherKey, iv, macKey := k[:32], k[32:32+aes.BlockSize], k[32+aes.BlockSize:]
+
+	// Pad the private blob to the cipher block size; the padding is
+	// arbitrary.
+	if n := len(privBlob) % aes.BlockSize; n != 0 {
+		pad, err := randomBytes(r, aes.BlockSize-n)
+		if err != nil {
+			return "", err
+		}
+		privBlob = append(privBlob, pad...)
+	}
+
+	const encryption = "aes256-cbc"
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(ssh.Marshal(struct {
+		Algorithm  string
+		Encryption string
+		Comment    string
+		Public     []byte
+		Private    []byte
+	}{pub.Type(), encryption, comment, pub.Marshal(), privBlob}))
+
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cipher: %v", err)
+	}
+	encrypted := make([]byte, len(privBlob))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, privBlob)
+
+	pubLines := ppkLines(pub.Marshal())
+	privLines := ppkLines(encrypted)
+	lines := []string{
+		"PuTTY-User-Key-File-3: " + pub.Type(),
+		"Encryption: " + encryption,
+		"Comment: " + comment,
+		fmt.Sprintf("Public-Lines: %d", len(pubLines)),
+	}
+	lines = append(lines, pubLines...)
+	lines = append(lines,
+		"Key-Derivation: Argon2id",
+		fmt.Sprintf("Argon2-Memory: %d", ppkArgonMemory),
+		fmt.Sprintf("Argon2-Passes: %d", ppkArgonPasses),
+		fmt.Sprintf("Argon2-Parallelism: %d", ppkArgonParallelism),
+		"Argon2-Salt: "+hex.EncodeToString(salt),
+		fmt.Sprintf("Private-Lines: %d", len(privLines)),
+	)
+	lines = append(lines, privLines...)
+	lines = append(lines, "Private-MAC: "+hex.EncodeToString(mac.Sum(nil)))
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// IsPPK indicates if data looks like a private key in PuTTY's format.
+func IsPPK(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(ppkHeader))
+}
+
+// PPKEncrypted indicates if data is a private key in PuTTY's format that is
+// encrypted, and so requires a passphrase to parse.
+func PPKEncrypted(data []byte) bool {
+	f, err := readPPK(data)
+	return err == nil && f.encryption != "none"
+}
+
+// ParsePPK parses a private key in PuTTY's format (version 2 or 3),
+// decrypting it using the passphrase if it is encrypted, and verifying its
+// MAC.  It returns the private key (as returned by ssh.ParseRawPrivateKey) and
+// the key's comment.  x509.IncorrectPasswordError is returned if the
+// passphrase is missing or incorrect.
+//
+// See https://the.earth.li/~sgtatham/putty/latest/htmldoc/AppendixC.html.
+func ParsePPK(data []byte, passphrase []byte) (priv interface{}, comment string, err error) {
+	f, err := readPPK(data)
+	if err != nil {
+		return nil, "", err
+	}
+	encrypted := f.encryption != "none"
+
+	var cipherKey, iv, macKey []byte
+	var newHash func() hash.Hash
+	switch f.version {
+	case 2:
+		newHash = sha1.New
+		k := sha1.Sum(append([]byte(ppkV2MACKey), passphrase...))
+		macKey = k[:]
+		if encrypted {
+			k0 := sha1.Sum(append([]byte{0, 0, 0, 0}, passphrase...))
+			k1 := sha1.Sum(append([]byte{0, 0, 0, 1}, passphrase...))
+			cipherKey = append(k0[:], k1[:]...)[:32]
+			iv = make([]byte, aes.BlockSize)
+		}
+	case 3:
+		newHash = sha256.New
+		if encrypted {
+			k, err := f.deriveKey(passphrase, 32+aes.BlockSize+32)
+			if err != nil {
+				return nil, "", err
+			}
+			cipherKey, iv, macKey = k[:32], k[32:32+aes.BlockSize], k[32+aes.BlockSize:]
+		}
+	}
+
+	privBlob := f.private
+	if encrypted {
+		if len(privBlob)%aes.BlockSize != 0 {
+			return nil, "", errors.New("invalid PuTTY key: private data is not a multiple of the cipher block size")
+		}
+		block, err := aes.NewCipher(cipherKey)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create cipher: %v", err)
+		}
+		privBlob = make([]byte, len(f.private))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(privBlob, f.private)
+	}
+
+	mac := hmac.New(newHash, macKey)
+	mac.Write(ssh.Marshal(struct {
+		Algorithm  string
+		Encryption string
+		Comment    string
+		Public     []byte
+		Private    []byte
+	}{f.algorithm, f.encryption, f.comment, f.public, privBlob}))
+	if !hmac.Equal(mac.Sum(nil), f.mac) {
+		if encrypted {
+			return nil, "", x509.IncorrectPasswordError
+		}
+		return nil, "", errors.New("invalid PuTTY key: MAC does not match; the file may be corrupt")
+	}
+
+	priv, err = ppkPrivateKey(f.algorithm, f.public, privBlob)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid PuTTY key: %v", err)
+	}
+	return priv, f.comment, nil
+}
+
+// ppkFile holds the fields of a private key in PuTTY's format.
+type ppkFile struct {
+	version    int
+	algorithm  string
+	encryption string
+	comment    string
+	public     []byte
+	// kdf holds the key derivation parameters of an encrypted version 3
+	// key, indexed by header name.
+	kdf     map[string]string
+	private []byte
+	mac     []byte
+}
+
+// ppkKDFHeaders are the key derivation headers of an encrypted version 3
+// key, in the order in which they appear.
+var ppkKDFHeaders = []string{"Key-Derivation", "Argon2-Memory", "Argon2-Passes", "Argon2-Parallelism", "Argon2-Salt"}
+
+// readPPK splits a private key in PuTTY's format into its fields.  The
+// private data is not decrypted.
+func readPPK(data []byte) (*ppkFile, error) {
+	r := &ppkReader{lines: strings.Split(strings.Replace(strings.TrimSpace(string(data)), "\r\n", "\n", -1), "\n")}
+	f := &ppkFile{}
+
+	first := r.next()
+	if !strings.HasPrefix(first, ppkHeader) {
+		return nil, errors.New("not a private key in PuTTY's format")
+	}
+	colon := strings.Index(first, ": ")
+	if colon < 0 {
+		return nil, errors.New("invalid PuTTY key: malformed header")
+	}
+	switch first[len(ppkHeader):colon] {
+	case "2":
+		f.version = 2
+	case "3":
+		f.version = 3
+	default:
+		return nil, fmt.Errorf("unsupported PuTTY key version %q", first[len(ppkHeader):colon])
+	}
+	f.algorithm = first[colon+len(": "):]
+
+	var err error
+	if f.encryption, err = r.field("Encryption"); err != nil {
+		return nil, err
+	}
+	if f.encryption != "none" && f.encryption != "aes256-cbc" {
+		return nil, fmt.Errorf("unsupported PuTTY key encryption %q", f.encryption)
+	}
+	if f.comment, err = r.field("Comment"); err != nil {
+		return nil, err
+	}
+	if f.public, err = r.blob("Public"); err != nil {
+		return nil, err
+	}
+	if f.version == 3 && f.encryption != "none" {
+		f.kdf = make(map[string]string)
+		for _, h := range ppkKDFHeaders {
+			if f.kdf[h], err = r.field(h); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if f.private, err = r.blob("Private"); err != nil {
+		return nil, err
+	}
+	mac, err := r.field("Private-MAC")
+	if err != nil {
+		return nil, err
+	}
+	if f.mac, err = hex.DecodeString(mac); err != nil {
+		return nil, fmt.Errorf("invalid PuTTY key: malformed MAC: %v", err)
+	}
+	return f, nil
+}
+
+// deriveKey derives n bytes of key material from the passphrase, using the
+// key derivation parameters of an encrypted version 3 key.
+func (f *ppkFile) deriveKey(passphrase []byte, n int) ([]byte, error) {
+	var memory, passes, parallelism int
+	for _, p := range []struct {
+		header string
+		value  *int
+		max    int
+	}{
+		{"Argon2-Memory", &memory, ppkMaxArgonMemory},
+		{"Argon2-Passes", &passes, ppkMaxArgonPasses},
+		{"Argon2-Parallelism", &parallelism, 255},
+	} {
+		v, err := strconv.Atoi(f.kdf[p.header])
+		if err != nil || v < 1 || v > p.max {
+			return nil, fmt.Errorf("invalid PuTTY key: unsupported %s %q", p.header, f.kdf[p.header])
+		}
+		*p.value = v
+	}
+	salt, err := hex.DecodeString(f.kdf["Argon2-Salt"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid PuTTY key: malformed salt: %v", err)
+	}
+
+	switch f.kdf["Key-Derivation"] {
+	case "Argon2id":
+		return argon2.IDKey(passphrase, salt, uint32(passes), uint32(memory), uint8(parallelism), uint32(n)), nil
+	case "Argon2i":
+		return argon2.Key(passphrase, salt, uint32(passes), uint32(memory), uint8(parallelism), uint32(n)), nil
+	}
+	return nil, fmt.Errorf("unsupported PuTTY key derivation %q", f.kdf["Key-Derivation"])
+}
+
+// ppkReader reads the lines of a key in PuTTY's format.
+type ppkReader struct {
+	lines []string
+	pos   int
+}
+
+// next returns the next line, or an empty string if there are none.
+func (r *ppkReader) next() string {
+	if r.pos >= len(r.lines) {
+		return ""
+	}
+	r.pos++
+	return strings.TrimRight(r.lines[r.pos-1], " \t")
+}
+
+// field reads a header line with the specified name, and returns its value.
+func (r *ppkReader) field(name string) (string, error) {
+	line := r.next()
+	if line == name+":" {
+		// Trailing whitespace was trimmed from an empty value.
+		return "", nil
+	}
+	if !strings.HasPrefix(line, name+": ") {
+		return "", fmt.Errorf("invalid PuTTY key: expected %s header", name)
+	}
+	return line[len(name)+len(": "):], nil
+}
+
+// blob reads the header giving the number of lines of base64-encoded data
+// in the named section (e.g., 'Public-Lines'), followed by the data.
+func (r *ppkReader) blob(name string) ([]byte, error) {
+	v, err := r.field(name + "-Lines")
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > ppkMaxLines {
+		return nil, fmt.Errorf("invalid PuTTY key: invalid number of %s lines %q", strings.ToLower(name), v)
+	}
+	var encoded []string
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.lines) {
+			return nil, fmt.Errorf("invalid PuTTY key: %s data is truncated", strings.ToLower(name))
+		}
+		encoded = append(encoded, r.next())
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.Join(encoded, ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PuTTY key: malformed %s data: %v", strings.ToLower(name), err)
+	}
+	return b, nil
+}
+
+// ppkPrivateKey returns the private key described by the public and
+// (decrypted) private portions of a key in PuTTY's format.  It is checked
+// against the public key.
+func ppkPrivateKey(algorithm string, pubBlob, privBlob []byte) (interface{}, error) {
+	pub, err := ssh.ParsePublicKey(pubBlob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	if pub.Type() != algorithm {
+		return nil, fmt.Errorf("public key type %s does not match %s", pub.Type(), algorithm)
+	}
+	cpub, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %s", algorithm)
+	}
+
+	// The private data is padded to the cipher block size.
+	switch k := cpub.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		var p struct {
+			D    *big.Int
+			P    *big.Int
+			Q    *big.Int
+			Iqmp *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(privBlob, &p); err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %v", err)
+		}
+		priv := &rsa.PrivateKey{PublicKey: *k, D: p.D, Primes: []*big.Int{p.P, p.Q}}
+		if err := priv.Validate(); err != nil {
+			return nil, fmt.Errorf("private key does not match public key: %v", err)
+		}
+		priv.Precompute()
+		return priv, nil
+	case *ecdsa.PublicKey:
+		var p struct {
+			D    *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(privBlob, &p); err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %v", err)
+		}
+		x, y := k.Curve.ScalarBaseMult(p.D.Bytes())
+		if x.Cmp(k.X) != 0 || y.Cmp(k.Y) != 0 {
+			return nil, errors.New("private key does not match public key")
+		}
+		return &ecdsa.PrivateKey{PublicKey: *k, D: p.D}, nil
+	case ed25519.PublicKey:
+		var p struct {
+			Seed []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(privBlob, &p); err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %v", err)
+		}
+		if len(p.Seed) != ppkEd25519SeedSize {
+			return nil, fmt.Errorf("invalid Ed25519 private key length %d", len(p.Seed))
+		}
+		_, priv, err := ed25519.GenerateKey(bytes.NewReader(p.Seed))
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive Ed25519 key: %v", err)
+		}
+		if !bytes.Equal(priv.Public().(ed25519.PublicKey), k) {
+			return nil, errors.New("private key does not match public key")
+		}
+		return &priv, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", algorithm)
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyformat
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// PrivateFormat is a format in which a private key may be exported.
+type PrivateFormat string
+
+const (
+	// PrivateOpenSSH is OpenSSH's own format ('openssh-key-v1'),
+	// encrypted using AES-256-CTR with a key derived using bcrypt_pbkdf.
+	PrivateOpenSSH PrivateFormat = "openssh"
+	// PrivatePKCS8 is a PEM-encoded PKCS#8 EncryptedPrivateKeyInfo,
+	// encrypted using AES-256-CBC with a key derived using PBKDF2.
+	PrivatePKCS8 PrivateFormat = "pkcs8"
+	// PrivatePPK is PuTTY's private key format (version 3), encrypted
+	// using AES-256-CBC with a key derived using Argon2id.
+	PrivatePPK PrivateFormat = "ppk"
+)
+
+// PrivateFormats lists all private key formats, in the order in which they
+// should be displayed.
+var PrivateFormats = []PrivateFormat{PrivateOpenSSH, PrivatePKCS8, PrivatePPK}
+
+// Description returns a human-readable description of the format.
+func (f PrivateFormat) Description() string {
+	switch f {
+	case PrivateOpenSSH:
+		return "OpenSSH"
+	case PrivatePKCS8:
+		return "PEM (PKCS#8)"
+	case PrivatePPK:
+		return "PuTTY (PPK version 3)"
+	}
+	return string(f)
+}
+
+var (
+	// ErrPassphraseRequired indicates that no passphrase was supplied
+	// when exporting a private key.  Exported keys are always encrypted.
+	ErrPassphraseRequired = errors.New("a passphrase is required to export a private key")
+)
+
+// EncodePrivate returns the private key encoded in the specified format and
+// encrypted using the passphrase.  The comment (typically the name of the
+// key) is included in formats that support one.  r is the source of
+// randomness for salts and initialization vectors.
+//
+// priv must be an RSA, ECDSA or ED25519 private key, as returned by
+// ssh.ParseRawPrivateKey.
+func EncodePrivate(priv interface{}, comment string, f PrivateFormat, passphrase []byte, r io.Reader) (string, error) {
+	if len(passphrase) == 0 {
+		return "", ErrPassphraseRequired
+	}
+	priv, err := normalizePrivateKey(priv)
+	if err != nil {
+		return "", err
+	}
+
+	switch f {
+	case PrivateOpenSSH:
+		return MarshalOpenSSH(priv, comment, passphrase, r)
+	case PrivatePKCS8:
+		return marshalPKCS8(priv, passphrase, r)
+	case PrivatePPK:
+		return marshalPPK(priv, comment, passphrase, r)
+	}
+	return "", fmt.Errorf("unknown private key format %q", f)
+}
+
+// normalizePrivateKey returns the private key in the form expected by the
+// encoders, or an error if the type of key is not supported.
+func normalizePrivateKey(priv interface{}) (interface{}, error) {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, errors.New("RSA keys with more than two primes are not supported")
+		}
+		k.Precompute()
+		return k, nil
+	case *ecdsa.PrivateKey:
+		if _, err := curveName(k.Curve); err != nil {
+			return nil, err
+		}
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	case *ed25519.PrivateKey:
+		return *k, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", priv)
+}
+
+// curveName returns the name used by SSH for the curve of an ECDSA key.
+func curveName(c elliptic.Curve) (string, error) {
+	switch c {
+	case elliptic.P256():
+		return "nistp256", nil
+	case elliptic.P384():
+		return "nistp384", nil
+	case elliptic.P521():
+		return "nistp521", nil
+	}
+	return "", fmt.Errorf("unsupported curve %s", c.Params().Name)
+}
+
+// rsaQInv returns the CRT coefficient (q^-1 mod p) of an RSA key.
+func rsaQInv(k *rsa.PrivateKey) *big.Int {
+	return k.Precomputed.Qinv
+}
+
+// randomBytes returns n bytes read from r.
+func randomBytes(r io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("failed to generate random data: %v", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,760 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyformat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/ssh"
+)
+
+var testPassphrase = []byte("secret")
+
+func rsaPrivateKey() interface{} {
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		panic(err)
+	}
+	return priv
+}
+
+func ecdsaPrivateKey() interface{} {
+	pemKey, _, err := provider.GenerateKey(provider.NewSoftware(provider.NewDeterministicRand("ecdsa")), "")
+	if err != nil {
+		panic(err)
+	}
+	priv, err := ssh.ParseRawPrivateKey([]byte(pemKey))
+	if err != nil {
+		panic(err)
+	}
+	return priv
+}
+
+func ed25519PrivateKey() interface{} {
+	_, priv, err := ed25519.GenerateKey(provider.NewDeterministicRand("ed25519"))
+	if err != nil {
+		panic(err)
+	}
+	return priv
+}
+
+func publicKeyOf(priv interface{}) ssh.PublicKey {
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(err)
+	}
+	return signer.PublicKey()
+}
+
+func TestBcryptPBKDF(t *testing.T) {
+	// Test vector generated by the reference implementation from OpenBSD.
+	want := []byte{
+		0x1a, 0xe4, 0x2c, 0x05, 0xd4, 0x87, 0xbc, 0x02, 0xf6,
+		0x49, 0x21, 0xa4, 0xeb, 0xe4, 0xea, 0x93, 0xbc, 0xac,
+		0xfe, 0x13, 0x5f, 0xda, 0x99, 0x97, 0x4c, 0x06, 0xb7,
+		0xb0, 0x1f, 0xae, 0x14, 0x9a,
+	}
+	got, err := bcryptPBKDF([]byte("password"), []byte("salt"), 12, len(want))
+	if err != nil {
+		t.Fatalf("bcryptPBKDF failed: %v", err)
+	}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect key; -got +want: %s", diff)
+	}
+}
+
+func TestEncodePrivateErrors(t *testing.T) {
+	testcases := []struct {
+		description string
+		priv        interface{}
+		format      PrivateFormat
+		passphrase  []byte
+		wantErr     error
+	}{
+		{
+			description: "missing passphrase",
+			priv:        rsaPrivateKey(),
+			format:      PrivateOpenSSH,
+			wantErr:     ErrPassphraseRequired,
+		},
+		{
+			description: "unknown format",
+			priv:        rsaPrivateKey(),
+			format:      PrivateFormat("bogus"),
+			passphrase:  testPassphrase,
+			wantErr:     errors.New(`unknown private key format "bogus"`),
+		},
+		{
+			description: "unsupported key",
+			priv:        "not a key",
+			format:      PrivateOpenSSH,
+			passphrase:  testPassphrase,
+			wantErr:     errors.New("unsupported private key type string"),
+		},
+	}
+
+	for _, tc := range testcases {
+		_, err := EncodePrivate(tc.priv, "my-key", tc.format, tc.passphrase, provider.NewDeterministicRand("rand"))
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+// decryptOpenSSH decrypts a key in OpenSSH's format, and returns the
+// equivalent unencrypted key.
+func decryptOpenSSH(pemKey string, passphrase []byte) ([]byte, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil || !bytes.HasPrefix(block.Bytes, []byte(opensshMagic)) {
+		return nil, errors.New("not an OpenSSH key")
+	}
+	var body struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}
+	if err := ssh.Unmarshal(block.Bytes[len(opensshMagic):], &body); err != nil {
+		return nil, err
+	}
+	if body.CipherName != "aes256-ctr" || body.KdfName != "bcrypt" {
+		return nil, fmt.Errorf("unexpected cipher %s and kdf %s", body.CipherName, body.KdfName)
+	}
+	var opts struct {
+		Salt   []byte
+		Rounds uint32
+	}
+	if err := ssh.Unmarshal([]byte(body.KdfOpts), &opts); err != nil {
+		return nil, err
+	}
+	k, err := bcryptPBKDF(passphrase, opts.Salt, int(opts.Rounds), 48)
+	if err != nil {
+		return nil, err
+	}
+	c, err := aes.NewCipher(k[:32])
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCTR(c, k[32:]).XORKeyStream(body.PrivKeyBlock, body.PrivKeyBlock)
+	if !bytes.Equal(body.PrivKeyBlock[:4], body.PrivKeyBlock[4:8]) {
+		return nil, errors.New("check values do not match; incorrect passphrase?")
+	}
+
+	body.CipherName, body.KdfName, body.KdfOpts = "none", "none", ""
+	block.Bytes = append([]byte(opensshMagic), ssh.Marshal(&body)...)
+	return pem.EncodeToMemory(block), nil
+}
+
+func TestEncodePrivateOpenSSH(t *testing.T) {
+	testcases := []struct {
+		description string
+		priv        interface{}
+		// parseable indicates that the vendored ssh package can
+		// parse keys of this type in OpenSSH's format.
+		parseable bool
+	}{
+		{description: "RSA", priv: rsaPrivateKey(), parseable: true},
+		{description: "ECDSA", priv: ecdsaPrivateKey()},
+		{description: "ED25519", priv: ed25519PrivateKey(), parseable: true},
+	}
+
+	for _, tc := range testcases {
+		encoded, err := EncodePrivate(tc.priv, "my-key", PrivateOpenSSH, testPassphrase, provider.NewDeterministicRand("rand"))
+		if err != nil {
+			t.Errorf("%s: EncodePrivate failed: %v", tc.description, err)
+			continue
+		}
+		if _, err := decryptOpenSSH(encoded, []byte("wrong")); err == nil {
+			t.Errorf("%s: decrypted with incorrect passphrase", tc.description)
+		}
+		decrypted, err := decryptOpenSSH(encoded, testPassphrase)
+		if err != nil {
+			t.Errorf("%s: failed to decrypt: %v", tc.description, err)
+			continue
+		}
+		if !tc.parseable {
+			continue
+		}
+		parsed, err := ssh.ParseRawPrivateKey(decrypted)
+		if err != nil {
+			t.Errorf("%s: failed to parse decrypted key: %v", tc.description, err)
+			continue
+		}
+		if diff := pretty.Diff(publicKeyOf(parsed).Marshal(), publicKeyOf(tc.priv).Marshal()); diff != nil {
+			t.Errorf("%s: incorrect key; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestInspectOpenSSH(t *testing.T) {
+	testcases := []struct {
+		description string
+		priv        interface{}
+		passphrase  []byte
+		wantComment string
+	}{
+		{description: "RSA", priv: rsaPrivateKey(), wantComment: "my-key"},
+		{description: "ECDSA", priv: ecdsaPrivateKey(), wantComment: "my-key"},
+		{description: "ED25519", priv: ed25519PrivateKey(), wantComment: "my-key"},
+		{description: "encrypted", priv: ed25519PrivateKey(), passphrase: testPassphrase},
+	}
+
+	for _, tc := range testcases {
+		encoded, err := MarshalOpenSSH(tc.priv, "my-key", tc.passphrase, provider.NewDeterministicRand("rand"))
+		if err != nil {
+			t.Errorf("%s: MarshalOpenSSH failed: %v", tc.description, err)
+			continue
+		}
+		pub, comment, err := InspectOpenSSH([]byte(encoded))
+		if err != nil {
+			t.Errorf("%s: InspectOpenSSH failed: %v", tc.description, err)
+			continue
+		}
+		if diff := pretty.Diff(pub.Marshal(), publicKeyOf(tc.priv).Marshal()); diff != nil {
+			t.Errorf("%s: incorrect public key; -got +want: %s", tc.description, diff)
+		}
+		if comment != tc.wantComment {
+			t.Errorf("%s: incorrect comment; got %q, want %q", tc.description, comment, tc.wantComment)
+		}
+	}
+
+	if _, _, err := InspectOpenSSH([]byte(testdata.ValidPrivateKey)); err == nil {
+		t.Errorf("inspected key not in OpenSSH format")
+	}
+}
+
+// decryptPKCS8 decrypts a PKCS#8 EncryptedPrivateKeyInfo, and returns the
+// unencrypted PrivateKeyInfo.
+func decryptPKCS8(pemKey string, passphrase []byte) ([]byte, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		return nil, errors.New("not an encrypted PKCS#8 key")
+	}
+	var info encryptedPKCS8
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return nil, err
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if !info.Algo.Algorithm.Equal(oidPBES2) || !params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) || !kdf.PRF.Algorithm.Equal(oidHMACSHA256) {
+		return nil, errors.New("unexpected algorithms")
+	}
+
+	c, err := aes.NewCipher(pbkdf2.Key(passphrase, kdf.Salt, kdf.IterationCount, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	cipher.NewCBCDecrypter(c, iv).CryptBlocks(data, data)
+	pad := int(data[len(data)-1])
+	if pad < 1 || pad > aes.BlockSize || !bytes.Equal(data[len(data)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errors.New("invalid padding; incorrect passphrase?")
+	}
+	return data[:len(data)-pad], nil
+}
+
+func TestEncodePrivatePKCS8(t *testing.T) {
+	testcases := []struct {
+		description string
+		priv        interface{}
+	}{
+		{description: "RSA", priv: rsaPrivateKey()},
+		{description: "ECDSA", priv: ecdsaPrivateKey()},
+		{description: "ED25519", priv: ed25519PrivateKey()},
+	}
+
+	for _, tc := range testcases {
+		encoded, err := EncodePrivate(tc.priv, "my-key", PrivatePKCS8, testPassphrase, provider.NewDeterministicRand("rand"))
+		if err != nil {
+			t.Errorf("%s: EncodePrivate failed: %v", tc.description, err)
+			continue
+		}
+		der, err := decryptPKCS8(encoded, testPassphrase)
+		if err != nil {
+			t.Errorf("%s: failed to decrypt: %v", tc.description, err)
+			continue
+		}
+
+		var parsed interface{}
+		if k, ok := tc.priv.(ed25519.PrivateKey); ok {
+			var info pkcs8
+			var seed []byte
+			if _, err := asn1.Unmarshal(der, &info); err != nil {
+				t.Errorf("%s: failed to parse decrypted key: %v", tc.description, err)
+				continue
+			}
+			if _, err := asn1.Unmarshal(info.PrivateKey, &seed); err != nil || !info.Algo.Algorithm.Equal(oidEd25519) {
+				t.Errorf("%s: failed to parse decrypted key: %v", tc.description, err)
+				continue
+			}
+			_, parsed, _ = ed25519.GenerateKey(bytes.NewReader(seed))
+			if diff := pretty.Diff(parsed, k); diff != nil {
+				t.Errorf("%s: incorrect key; -got +want: %s", tc.description, diff)
+			}
+			continue
+		}
+		parsed, err = x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			t.Errorf("%s: failed to parse decrypted key: %v", tc.description, err)
+			continue
+		}
+		if diff := pretty.Diff(publicKeyOf(parsed).Marshal(), publicKeyOf(tc.priv).Marshal()); diff != nil {
+			t.Errorf("%s: incorrect key; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+// decryptPPK decrypts a key in PuTTY's format, verifies its MAC, and returns
+// the public and (padded) private blobs.
+func decryptPPK(ppk string, passphrase []byte) (pub, priv []byte, err error) {
+	lines := strings.Split(strings.TrimSuffix(ppk, "\n"), "\n")
+	fields := make(map[string]string)
+	var order []string
+	for i := 0; i < len(lines); i++ {
+		parts := strings.SplitN(lines[i], ": ", 2)
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("malformed line %q", lines[i])
+		}
+		order = append(order, parts[0])
+		fields[parts[0]] = parts[1]
+		if parts[0] == "Public-Lines" || parts[0] == "Private-Lines" {
+			n, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, nil, err
+			}
+			fields[parts[0]] = strings.Join(lines[i+1:i+1+n], "")
+			i += n
+		}
+	}
+	wantOrder := []string{"PuTTY-User-Key-File-3", "Encryption", "Comment", "Public-Lines", "Key-Derivation", "Argon2-Memory", "Argon2-Passes", "Argon2-Parallelism", "Argon2-Salt", "Private-Lines", "Private-MAC"}
+	if diff := pretty.Diff(order, wantOrder); diff != nil {
+		return nil, nil, fmt.Errorf("incorrect fields; -got +want: %s", diff)
+	}
+
+	pub, err = base64.StdEncoding.DecodeString(fields["Public-Lines"])
+	if err != nil {
+		return nil, nil, err
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(fields["Private-Lines"])
+	if err != nil {
+		return nil, nil, err
+	}
+	salt, err := hex.DecodeString(fields["Argon2-Salt"])
+	if err != nil {
+		return nil, nil, err
+	}
+	memory, _ := strconv.Atoi(fields["Argon2-Memory"])
+	passes, _ := strconv.Atoi(fields["Argon2-Passes"])
+	threads, _ := strconv.Atoi(fields["Argon2-Parallelism"])
+	k := argon2.IDKey(passphrase, salt, uint32(passes), uint32(memory), uint8(threads), 80)
+
+	c, err := aes.NewCipher(k[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	priv = make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(c, k[32:48]).CryptBlocks(priv, encrypted)
+
+	mac := hmac.New(sha256.New, k[48:])
+	for _, s := range [][]byte{[]byte(fields["PuTTY-User-Key-File-3"]), []byte(fields["Encryption"]), []byte(fields["Comment"]), pub, priv} {
+		mac.Write(ssh.Marshal(struct{ S []byte }{s}))
+	}
+	if got := hex.EncodeToString(mac.Sum(nil)); got != fields["Private-MAC"] {
+		return nil, nil, errors.New("incorrect MAC; incorrect passphrase?")
+	}
+	return pub, priv, nil
+}
+
+func TestEncodePrivatePPK(t *testing.T) {
+	testcases := []struct {
+		description string
+		priv        interface{}
+		wantPrivate func(priv interface{}) []byte
+	}{
+		{
+			description: "RSA",
+			priv:        rsaPrivateKey(),
+		},
+		{
+			description: "ECDSA",
+			priv:        ecdsaPrivateKey(),
+		},
+		{
+			description: "ED25519",
+			priv:        ed25519PrivateKey(),
+			wantPrivate: func(priv interface{}) []byte {
+				return ssh.Marshal(struct{ S []byte }{[]byte(priv.(ed25519.PrivateKey)[:32])})
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		encoded, err := EncodePrivate(tc.priv, "my-key", PrivatePPK, testPassphrase, provider.NewDeterministicRand("rand"))
+		if err != nil {
+			t.Errorf("%s: EncodePrivate failed: %v", tc.description, err)
+			continue
+		}
+		if _, _, err := decryptPPK(encoded, []byte("wrong")); err == nil {
+			t.Errorf("%s: decrypted with incorrect passphrase", tc.description)
+		}
+		pub, priv, err := decryptPPK(encoded, testPassphrase)
+		if err != nil {
+			t.Errorf("%s: failed to decrypt: %v", tc.description, err)
+			continue
+		}
+		if diff := pretty.Diff(pub, publicKeyOf(tc.priv).Marshal()); diff != nil {
+			t.Errorf("%s: incorrect public key; -got +want: %s", tc.description, diff)
+		}
+		if len(priv)%aes.BlockSize != 0 {
+			t.Errorf("%s: private blob of length %d is not padded", tc.description, len(priv))
+		}
+		if tc.wantPrivate != nil {
+			want := tc.wantPrivate(tc.priv)
+			if diff := pretty.Diff(priv[:len(want)], want); diff != nil {
+				t.Errorf("%s: incorrect private blob; -got +want: %s", tc.description, diff)
+			}
+		}
+	}
+}
+
+// benchmarkEncodePrivate measures exporting a key in format f.  The time is
+// dominated by the format's key derivation function, which is also what an
+// OpenSSH or PuTTY client spends when loading the exported key.
+func benchmarkEncodePrivate(b *testing.B, f PrivateFormat) {
+	priv := ecdsaPrivateKey()
+	r := provider.NewDeterministicRand("benchmark")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodePrivate(priv, "some-key", f, testPassphrase, r); err != nil {
+			b.Fatalf("failed to encode key: %v", err)
+		}
+	}
+}
+
+func BenchmarkEncodePrivateOpenSSH(b *testing.B) { benchmarkEncodePrivate(b, PrivateOpenSSH) }
+func BenchmarkEncodePrivatePKCS8(b *testing.B)   { benchmarkEncodePrivate(b, PrivatePKCS8) }
+func BenchmarkEncodePrivatePPK(b *testing.B)     { benchmarkEncodePrivate(b, PrivatePPK) }
+
+func TestParsePKCS8PrivateKey(t *testing.T) {
+	testcases := []struct {
+		description string
+		priv        interface{}
+	}{
+		{description: "RSA", priv: rsaPrivateKey()},
+		{description: "ECDSA", priv: ecdsaPrivateKey()},
+		{description: "ED25519", priv: ed25519PrivateKey()},
+	}
+
+	for _, tc := range testcases {
+		der, err := marshalPKCS8PrivateKey(tc.priv)
+		if err != nil {
+			t.Errorf("%s: failed to encode key: %v", tc.description, err)
+			continue
+		}
+		parsed, err := ParsePKCS8PrivateKey(der)
+		if err != nil {
+			t.Errorf("%s: ParsePKCS8PrivateKey failed: %v", tc.description, err)
+			continue
+		}
+		if diff := pretty.Diff(publicKeyOf(parsed).Marshal(), publicKeyOf(tc.priv).Marshal()); diff != nil {
+			t.Errorf("%s: incorrect key; -got +want: %s", tc.description, diff)
+		}
+	}
+
+	if _, err := ParsePKCS8PrivateKey([]byte("not a key")); err == nil {
+		t.Errorf("ParsePKCS8PrivateKey succeeded for invalid data; want error")
+	}
+}
+
+// encodePPK returns priv in PuTTY's format, independently of marshalPPK so
+// that version 2 and unencrypted keys can be tested.  Version 3 keys are
+// encrypted using Argon2i with minimal parameters.
+func encodePPK(version int, priv interface{}, comment string, passphrase []byte) string {
+	pub := publicKeyOf(priv).Marshal()
+	privBlob, err := ppkPrivateBlob(priv)
+	if err != nil {
+		panic(err)
+	}
+	encryption := "none"
+	if len(passphrase) > 0 {
+		encryption = "aes256-cbc"
+		for len(privBlob)%aes.BlockSize != 0 {
+			privBlob = append(privBlob, 0)
+		}
+	}
+
+	var cipherKey, iv, macKey []byte
+	newHash := sha256.New
+	var kdf []string
+	switch version {
+	case 2:
+		newHash = sha1.New
+		k := sha1.Sum(append([]byte("putty-private-key-file-mac-key"), passphrase...))
+		macKey = k[:]
+		k0 := sha1.Sum(append([]byte{0, 0, 0, 0}, passphrase...))
+		k1 := sha1.Sum(append([]byte{0, 0, 0, 1}, passphrase...))
+		cipherKey, iv = append(k0[:], k1[:]...)[:32], make([]byte, aes.BlockSize)
+	case 3:
+		if len(passphrase) > 0 {
+			salt := []byte("0123456789abcdef")
+			k := argon2.Key(passphrase, salt, 1, 64, 1, 32+aes.BlockSize+32)
+			cipherKey, iv, macKey = k[:32], k[32:32+aes.BlockSize], k[32+aes.BlockSize:]
+			kdf = []string{"Key-Derivation: Argon2i", "Argon2-Memory: 64", "Argon2-Passes: 1", "Argon2-Parallelism: 1", "Argon2-Salt: " + hex.EncodeToString(salt)}
+		}
+	}
+
+	mac := hmac.New(newHash, macKey)
+	mac.Write(ssh.Marshal(struct {
+		Algorithm  string
+		Encryption string
+		Comment    string
+		Public     []byte
+		Private    []byte
+	}{publicKeyOf(priv).Type(), encryption, comment, pub, privBlob}))
+	if len(passphrase) > 0 {
+		block, err := aes.NewCipher(cipherKey)
+		if err != nil {
+			panic(err)
+		}
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(privBlob, privBlob)
+	}
+
+	pubLines, privLines := ppkLines(pub), ppkLines(privBlob)
+	lines := []string{
+		fmt.Sprintf("PuTTY-User-Key-File-%d: %s", version, publicKeyOf(priv).Type()),
+		"Encryption: " + encryption,
+		"Comment: " + comment,
+		fmt.Sprintf("Public-Lines: %d", len(pubLines)),
+	}
+	lines = append(lines, pubLines...)
+	lines = append(lines, kdf...)
+	lines = append(lines, fmt.Sprintf("Private-Lines: %d", len(privLines)))
+	lines = append(lines, privLines...)
+	lines = append(lines, "Private-MAC: "+hex.EncodeToString(mac.Sum(nil)))
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestParsePPK(t *testing.T) {
+	rsaKey, ecKey, edKey := rsaPrivateKey(), ecdsaPrivateKey(), ed25519PrivateKey()
+	exported := func(priv interface{}) string {
+		encoded, err := EncodePrivate(priv, "my-key", PrivatePPK, testPassphrase, provider.NewDeterministicRand("rand"))
+		if err != nil {
+			panic(err)
+		}
+		return encoded
+	}
+	corrupt := func(ppk string) string {
+		i := strings.Index(ppk, "Private-MAC: ") + len("Private-MAC: ")
+		return ppk[:i] + "00" + ppk[i+2:]
+	}
+
+	testcases := []struct {
+		description   string
+		ppk           string
+		passphrase    []byte
+		wantPriv      interface{}
+		wantComment   string
+		wantEncrypted bool
+		wantErr       error
+		wantAnyErr    bool
+	}{
+		{
+			description:   "version 3 RSA",
+			ppk:           exported(rsaKey),
+			passphrase:    testPassphrase,
+			wantPriv:      rsaKey,
+			wantComment:   "my-key",
+			wantEncrypted: true,
+		},
+		{
+			description:   "version 3 ECDSA",
+			ppk:           exported(ecKey),
+			passphrase:    testPassphrase,
+			wantPriv:      ecKey,
+			wantComment:   "my-key",
+			wantEncrypted: true,
+		},
+		{
+			description:   "version 3 ED25519",
+			ppk:           exported(edKey),
+			passphrase:    testPassphrase,
+			wantPriv:      edKey,
+			wantComment:   "my-key",
+			wantEncrypted: true,
+		},
+		{
+			description:   "version 3 Argon2i",
+			ppk:           encodePPK(3, ecKey, "my-key", testPassphrase),
+			passphrase:    testPassphrase,
+			wantPriv:      ecKey,
+			wantComment:   "my-key",
+			wantEncrypted: true,
+		},
+		{
+			description: "version 3 unencrypted",
+			ppk:         encodePPK(3, edKey, "my-key", nil),
+			wantPriv:    edKey,
+			wantComment: "my-key",
+		},
+		{
+			description:   "version 2 encrypted",
+			ppk:           encodePPK(2, rsaKey, "my-key", testPassphrase),
+			passphrase:    testPassphrase,
+			wantPriv:      rsaKey,
+			wantComment:   "my-key",
+			wantEncrypted: true,
+		},
+		{
+			description: "version 2 unencrypted",
+			ppk:         encodePPK(2, ecKey, "my-key", nil),
+			wantPriv:    ecKey,
+			wantComment: "my-key",
+		},
+		{
+			description: "empty comment",
+			ppk:         encodePPK(3, edKey, "", nil),
+			wantPriv:    edKey,
+		},
+		{
+			description: "Windows line endings",
+			ppk:         strings.Replace(encodePPK(2, ecKey, "my-key", nil), "\n", "\r\n", -1),
+			wantPriv:    ecKey,
+			wantComment: "my-key",
+		},
+		{
+			description:   "incorrect passphrase",
+			ppk:           exported(ecKey),
+			passphrase:    []byte("wrong"),
+			wantEncrypted: true,
+			wantErr:       x509.IncorrectPasswordError,
+		},
+		{
+			description:   "missing passphrase",
+			ppk:           encodePPK(2, rsaKey, "my-key", testPassphrase),
+			wantEncrypted: true,
+			wantErr:       x509.IncorrectPasswordError,
+		},
+		{
+			description: "corrupt unencrypted key",
+			ppk:         corrupt(encodePPK(3, edKey, "my-key", nil)),
+			wantAnyErr:  true,
+		},
+		{
+			description:   "unsupported key derivation",
+			ppk:           strings.Replace(exported(ecKey), "Argon2id", "Argon2d", 1),
+			passphrase:    testPassphrase,
+			wantEncrypted: true,
+			wantAnyErr:    true,
+		},
+		{
+			description:   "excessive memory",
+			ppk:           strings.Replace(exported(ecKey), "Argon2-Memory: 8192", "Argon2-Memory: 4194304", 1),
+			passphrase:    testPassphrase,
+			wantEncrypted: true,
+			wantAnyErr:    true,
+		},
+		{
+			description: "unsupported version",
+			ppk:         strings.Replace(encodePPK(2, ecKey, "my-key", nil), "File-2", "File-1", 1),
+			wantAnyErr:  true,
+		},
+		{
+			description: "truncated",
+			ppk:         encodePPK(2, rsaKey, "my-key", nil)[:200],
+			wantAnyErr:  true,
+		},
+	}
+
+	for _, tc := range testcases {
+		if !IsPPK([]byte(tc.ppk)) {
+			t.Errorf("%s: not recognized as a PuTTY key", tc.description)
+		}
+		if diff := pretty.Diff(PPKEncrypted([]byte(tc.ppk)), tc.wantEncrypted); diff != nil {
+			t.Errorf("%s: incorrect encrypted state; -got +want: %s", tc.description, diff)
+		}
+
+		priv, comment, err := ParsePPK([]byte(tc.ppk), tc.passphrase)
+		if tc.wantErr != nil || tc.wantAnyErr {
+			if err == nil {
+				t.Errorf("%s: parsed successfully; want error", tc.description)
+			} else if tc.wantErr != nil && err != tc.wantErr {
+				t.Errorf("%s: incorrect error: got %v, want %v", tc.description, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", tc.description, err)
+			continue
+		}
+		if diff := pretty.Diff(comment, tc.wantComment); diff != nil {
+			t.Errorf("%s: incorrect comment; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(publicKeyOf(priv).Marshal(), publicKeyOf(tc.wantPriv).Marshal()); diff != nil {
+			t.Errorf("%s: incorrect public key; -got +want: %s", tc.description, diff)
+		}
+		// Signing with the parsed key checks the private half too.
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Errorf("%s: failed to create signer: %v", tc.description, err)
+			continue
+		}
+		sig, err := signer.Sign(provider.NewDeterministicRand("sign"), []byte("data"))
+		if err != nil {
+			t.Errorf("%s: failed to sign: %v", tc.description, err)
+			continue
+		}
+		if err := publicKeyOf(tc.wantPriv).Verify([]byte("data"), sig); err != nil {
+			t.Errorf("%s: signature does not verify: %v", tc.description, err)
+		}
+	}
+
+	if IsPPK([]byte(testdata.ValidPrivateKey)) {
+		t.Errorf("PEM key recognized as a PuTTY key")
+	}
+}
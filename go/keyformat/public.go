@@ -0,0 +1,146 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keyformat encodes keys in the formats expected by other tools.
+package keyformat
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// PublicFormat is a format in which a public key may be exported.
+type PublicFormat string
+
+const (
+	// PublicOpenSSH is OpenSSH's single-line format, as used in
+	// authorized_keys files.
+	PublicOpenSSH PublicFormat = "openssh"
+	// PublicRFC4716 is the SSH2 public key file format described in
+	// RFC 4716, used by some commercial SSH implementations.
+	PublicRFC4716 PublicFormat = "rfc4716"
+	// PublicPKCS1 is a PEM-encoded PKCS#1 RSAPublicKey.  Only RSA keys
+	// may be exported in this format.
+	PublicPKCS1 PublicFormat = "pkcs1"
+	// PublicPKIX is a PEM-encoded X.509 SubjectPublicKeyInfo.
+	PublicPKIX PublicFormat = "pkix"
+)
+
+// PublicFormats lists all public key formats, in the order in which they
+// should be displayed.
+var PublicFormats = []PublicFormat{PublicOpenSSH, PublicRFC4716, PublicPKCS1, PublicPKIX}
+
+// Description returns a human-readable description of the format.
+func (f PublicFormat) Description() string {
+	switch f {
+	case PublicOpenSSH:
+		return "OpenSSH"
+	case PublicRFC4716:
+		return "RFC 4716 (SSH2)"
+	case PublicPKCS1:
+		return "PEM (PKCS#1, RSA only)"
+	case PublicPKIX:
+		return "PEM (SubjectPublicKeyInfo)"
+	}
+	return string(f)
+}
+
+const (
+	// rfc4716LineLength is the maximum length of a line in the RFC 4716
+	// format, excluding the line terminator.
+	rfc4716LineLength = 72
+	// rfc4716Base64Length is the length of each line of base64-encoded
+	// key data.
+	rfc4716Base64Length = 70
+)
+
+// EncodePublic returns the public key encoded in the specified format.  The
+// comment (typically the name of the key) is included in formats that
+// support one.
+func EncodePublic(pub ssh.PublicKey, comment string, f PublicFormat) (string, error) {
+	comment = strings.TrimSpace(strings.Replace(comment, "\n", " ", -1))
+	switch f {
+	case PublicOpenSSH:
+		line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+		if comment != "" {
+			line += " " + comment
+		}
+		return line, nil
+	case PublicRFC4716:
+		return encodeRFC4716(pub, comment), nil
+	case PublicPKCS1:
+		k, err := cryptoPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+		rk, ok := k.(*rsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("%s keys cannot be exported in PKCS#1 format", pub.Type())
+		}
+		b := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(rk)})
+		return string(b), nil
+	case PublicPKIX:
+		k, err := cryptoPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			return "", fmt.Errorf("%s keys cannot be exported in SubjectPublicKeyInfo format: %v", pub.Type(), err)
+		}
+		b := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		return string(b), nil
+	}
+	return "", fmt.Errorf("unknown public key format %q", f)
+}
+
+// cryptoPublicKey returns the standard library representation of the public
+// key.
+func cryptoPublicKey(pub ssh.PublicKey) (interface{}, error) {
+	cpk, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s keys cannot be converted", pub.Type())
+	}
+	return cpk.CryptoPublicKey(), nil
+}
+
+// encodeRFC4716 returns the public key in the format described in RFC 4716.
+func encodeRFC4716(pub ssh.PublicKey, comment string) string {
+	var lines []string
+	lines = append(lines, "---- BEGIN SSH2 PUBLIC KEY ----")
+	if comment != "" {
+		// Header lines longer than the limit are continued on the
+		// following line by ending them with a backslash.
+		header := fmt.Sprintf("Comment: \"%s\"", strings.Replace(comment, `"`, "", -1))
+		for len(header) > rfc4716LineLength {
+			lines = append(lines, header[:rfc4716LineLength-1]+`\`)
+			header = header[rfc4716LineLength-1:]
+		}
+		lines = append(lines, header)
+	}
+	data := base64.StdEncoding.EncodeToString(pub.Marshal())
+	for len(data) > rfc4716Base64Length {
+		lines = append(lines, data[:rfc4716Base64Length])
+		data = data[rfc4716Base64Length:]
+	}
+	lines = append(lines, data)
+	lines = append(lines, "---- END SSH2 PUBLIC KEY ----")
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,207 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyformat
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+func rsaPublicKey() ssh.PublicKey {
+	blob, err := base64.StdEncoding.DecodeString(testdata.ValidPrivateKeyBlob)
+	if err != nil {
+		panic(err)
+	}
+	pub, err := ssh.ParsePublicKey(blob)
+	if err != nil {
+		panic(err)
+	}
+	return pub
+}
+
+func ecdsaPublicKey() ssh.PublicKey {
+	_, signer, err := provider.GenerateKey(provider.NewSoftware(provider.NewDeterministicRand("ecdsa")), "")
+	if err != nil {
+		panic(err)
+	}
+	return signer.PublicKey()
+}
+
+func ed25519PublicKey() ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(provider.NewDeterministicRand("ed25519"))
+	if err != nil {
+		panic(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		panic(err)
+	}
+	return sshPub
+}
+
+func TestEncodePublicOpenSSH(t *testing.T) {
+	got, err := EncodePublic(rsaPublicKey(), " my-key\n", PublicOpenSSH)
+	if err != nil {
+		t.Fatalf("EncodePublic failed: %v", err)
+	}
+	want := "ssh-rsa " + testdata.ValidPrivateKeyBlob + " my-key"
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect encoding; -got +want: %s", diff)
+	}
+}
+
+func TestEncodePublicRFC4716(t *testing.T) {
+	testcases := []struct {
+		description string
+		comment     string
+		wantHeader  []string
+	}{
+		{
+			description: "no comment",
+		},
+		{
+			description: "short comment",
+			comment:     `my "key"`,
+			wantHeader:  []string{`Comment: "my key"`},
+		},
+		{
+			description: "long comment",
+			comment:     strings.Repeat("x", 80),
+			wantHeader: []string{
+				`Comment: "` + strings.Repeat("x", 61) + `\`,
+				strings.Repeat("x", 19) + `"`,
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := EncodePublic(rsaPublicKey(), tc.comment, PublicRFC4716)
+		if err != nil {
+			t.Errorf("%s: EncodePublic failed: %v", tc.description, err)
+			continue
+		}
+
+		lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+		if lines[0] != "---- BEGIN SSH2 PUBLIC KEY ----" || lines[len(lines)-1] != "---- END SSH2 PUBLIC KEY ----" {
+			t.Errorf("%s: incorrect begin/end lines: %q", tc.description, got)
+			continue
+		}
+		lines = lines[1 : len(lines)-1]
+		for _, l := range lines {
+			if len(l) > rfc4716LineLength {
+				t.Errorf("%s: line exceeds %d characters: %q", tc.description, rfc4716LineLength, l)
+			}
+		}
+
+		header := lines[:len(tc.wantHeader)]
+		if diff := pretty.Diff(header, tc.wantHeader); len(tc.wantHeader) > 0 && diff != nil {
+			t.Errorf("%s: incorrect header; -got +want: %s", tc.description, diff)
+		}
+		data := strings.Join(lines[len(tc.wantHeader):], "")
+		if diff := pretty.Diff(data, testdata.ValidPrivateKeyBlob); diff != nil {
+			t.Errorf("%s: incorrect key data; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestEncodePublicPEM(t *testing.T) {
+	testcases := []struct {
+		description string
+		pub         ssh.PublicKey
+		format      PublicFormat
+		wantType    string
+		wantErr     bool
+	}{
+		{
+			description: "RSA as PKCS#1",
+			pub:         rsaPublicKey(),
+			format:      PublicPKCS1,
+			wantType:    "RSA PUBLIC KEY",
+		},
+		{
+			description: "ECDSA as PKCS#1",
+			pub:         ecdsaPublicKey(),
+			format:      PublicPKCS1,
+			wantErr:     true,
+		},
+		{
+			description: "RSA as SubjectPublicKeyInfo",
+			pub:         rsaPublicKey(),
+			format:      PublicPKIX,
+			wantType:    "PUBLIC KEY",
+		},
+		{
+			description: "ECDSA as SubjectPublicKeyInfo",
+			pub:         ecdsaPublicKey(),
+			format:      PublicPKIX,
+			wantType:    "PUBLIC KEY",
+		},
+		{
+			description: "ED25519 as SubjectPublicKeyInfo",
+			pub:         ed25519PublicKey(),
+			format:      PublicPKIX,
+			wantErr:     true,
+		},
+		{
+			description: "unknown format",
+			pub:         rsaPublicKey(),
+			format:      PublicFormat("bogus"),
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := EncodePublic(tc.pub, "my-key", tc.format)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %v", tc.description, err, tc.wantErr)
+		}
+		if tc.wantErr {
+			continue
+		}
+
+		block, _ := pem.Decode([]byte(got))
+		if block == nil {
+			t.Errorf("%s: failed to decode PEM: %q", tc.description, got)
+			continue
+		}
+		if diff := pretty.Diff(block.Type, tc.wantType); diff != nil {
+			t.Errorf("%s: incorrect PEM type; -got +want: %s", tc.description, diff)
+		}
+
+		var parsed interface{}
+		if tc.format == PublicPKCS1 {
+			parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		} else {
+			parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+		}
+		if err != nil {
+			t.Errorf("%s: failed to parse encoded key: %v", tc.description, err)
+			continue
+		}
+		want := tc.pub.(ssh.CryptoPublicKey).CryptoPublicKey()
+		if diff := pretty.Diff(parsed, want); diff != nil {
+			t.Errorf("%s: incorrect key; -got +want: %s", tc.description, diff)
+		}
+	}
+}
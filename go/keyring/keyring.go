@@ -0,0 +1,422 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keyring provides an SSH agent keyring whose list of identities is
+// versioned.  Clients listing identities always observe a consistent
+// snapshot, even while keys are being added or removed.  Subscribers are
+// told about each new version.
+package keyring
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/rsaaccel"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Snapshot is an immutable view of the keys in a Keyring.
+type Snapshot struct {
+	// Version identifies the state of the keyring from which the snapshot
+	// was taken.  It increases each time the set of keys changes.
+	Version uint64
+	// Keys are the keys in the keyring.
+	Keys []*agent.Key
+}
+
+// State summarizes a version of a Keyring for subscribers.
+type State struct {
+	// Version identifies the state of the keyring.
+	Version uint64
+	// Keys is the number of keys in the keyring.  It is zero while the
+	// keyring is locked.
+	Keys int
+	// Locked indicates that the keyring is locked.
+	Locked bool
+}
+
+// Keyring is an agent.Agent that holds keys in memory.  Every change to the
+// set of keys produces a new version; List returns the keys for a single
+// version, never a partially-updated list.
+type Keyring struct {
+	mu      sync.Mutex
+	keyring agent.Agent
+	version uint64
+	// snapshot is the snapshot for the current version, or nil if it has
+	// not yet been computed.
+	snapshot *Snapshot
+	// expiry contains the time at which keys added with a limited lifetime
+	// expire, keyed by public key blob.
+	expiry map[string]time.Time
+	// confirm contains the keys added with the confirm constraint (e.g.,
+	// by 'ssh-add -c'), keyed by public key blob.
+	confirm map[string]bool
+	// comments contains the comments of keys that were relabelled after
+	// they were added, keyed by public key blob.
+	comments map[string]string
+	// now returns the current time; it may be overridden in tests.
+	now func() time.Time
+	// locked indicates that the keyring is locked.
+	locked bool
+	// subscribers are invoked each time the set of keys changes.
+	subscribers []func(s *State)
+	// rsaExp computes modular exponentiation for RSA signatures, or nil
+	// if crypto/rsa should be used.
+	rsaExp rsaaccel.ModExp
+}
+
+// New returns a new, empty Keyring.
+func New() *Keyring {
+	return &Keyring{
+		keyring:  agent.NewKeyring(),
+		expiry:   make(map[string]time.Time),
+		confirm:  make(map[string]bool),
+		comments: make(map[string]string),
+		now:      time.Now,
+	}
+}
+
+// changedLocked records that the set of keys has changed.
+func (k *Keyring) changedLocked() {
+	k.version++
+	k.snapshot = nil
+}
+
+// expireLocked discards the snapshot if any keys have expired since it was
+// taken.  It returns true if any keys expired.
+func (k *Keyring) expireLocked() bool {
+	expired := false
+	now := k.now()
+	for blob, t := range k.expiry {
+		if !now.Before(t) {
+			delete(k.expiry, blob)
+			k.changedLocked()
+			expired = true
+		}
+	}
+	return expired
+}
+
+// Subscribe registers a callback that is invoked with the state of the
+// keyring each time the set of keys changes, including when the keyring is
+// locked or unlocked.  Expired keys are noticed when they expire, or when
+// the keyring is next used.  The callback is invoked without holding the
+// keyring's lock, so it may use the keyring.
+func (k *Keyring) Subscribe(callback func(s *State)) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.subscribers = append(k.subscribers, callback)
+}
+
+// stateLocked returns the current state of the keyring, along with the
+// subscribers that should be told about it.
+func (k *Keyring) stateLocked() (*State, []func(s *State)) {
+	keys, _ := k.keyring.List()
+	s := &State{
+		Version: k.version,
+		Keys:    len(keys),
+		Locked:  k.locked,
+	}
+	subs := make([]func(s *State), len(k.subscribers))
+	copy(subs, k.subscribers)
+	return s, subs
+}
+
+// publish tells subscribers about a new state.  The keyring's lock must not
+// be held.
+func publish(s *State, subscribers []func(s *State)) {
+	for _, sub := range subscribers {
+		sub(s)
+	}
+}
+
+// expire discards any keys that have expired, and tells subscribers about
+// the new state if they did.
+func (k *Keyring) expire() {
+	k.mu.Lock()
+	if !k.expireLocked() {
+		k.mu.Unlock()
+		return
+	}
+	s, subs := k.stateLocked()
+	k.mu.Unlock()
+	publish(s, subs)
+}
+
+// Snapshot returns an immutable view of the keys currently in the keyring.
+func (k *Keyring) Snapshot() (*Snapshot, error) {
+	k.expire()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.snapshot != nil {
+		return k.snapshot, nil
+	}
+
+	keys, err := k.keyring.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if comment, ok := k.comments[string(key.Blob)]; ok {
+			key.Comment = comment
+		}
+	}
+	k.snapshot = &Snapshot{
+		Version: k.version,
+		Keys:    keys,
+	}
+	return k.snapshot, nil
+}
+
+// Version returns the current version of the keyring.
+func (k *Keyring) Version() uint64 {
+	k.expire()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.version
+}
+
+// Update applies several changes to the keyring as a single new version.
+// Clients listing keys observe either none or all of the changes.  If update
+// returns an error, changes it already made are still retained.
+func (k *Keyring) Update(update func(a agent.Agent) error) error {
+	k.mu.Lock()
+	err := update(&unversioned{k})
+	k.changedLocked()
+	s, subs := k.stateLocked()
+	k.mu.Unlock()
+
+	publish(s, subs)
+	return err
+}
+
+// List implements agent.Agent.List.  The keys returned are a copy of the
+// current snapshot.
+func (k *Keyring) List() ([]*agent.Key, error) {
+	s, err := k.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return append([]*agent.Key(nil), s.Keys...), nil
+}
+
+// Expiry returns the time at which a key added with a limited lifetime
+// expires.  ok is false if the key has no lifetime, or is not in the
+// keyring.
+func (k *Keyring) Expiry(key ssh.PublicKey) (t time.Time, ok bool) {
+	k.expire()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	t, ok = k.expiry[string(key.Marshal())]
+	return t, ok
+}
+
+// ConfirmBeforeUse returns true if the key was added with the confirm
+// constraint, so that each signature using it must be allowed by the user.
+// The keyring itself does not enforce the constraint; see
+// keys.ConfirmInterceptor.
+func (k *Keyring) ConfirmBeforeUse(key ssh.PublicKey) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.confirm[string(key.Marshal())]
+}
+
+// Relabel replaces the comment of a key in the keyring (e.g., to upgrade a key
+// loaded by an older version of the extension whose comment is in an older
+// format).  The private key is not needed, so encrypted keys need not be
+// loaded again.  An error is returned if the key is not in the keyring.
+func (k *Keyring) Relabel(key ssh.PublicKey, comment string) error {
+	return k.Update(func(a agent.Agent) error {
+		keys, err := a.List()
+		if err != nil {
+			return err
+		}
+		wanted := key.Marshal()
+		for _, l := range keys {
+			if bytes.Equal(l.Blob, wanted) {
+				k.comments[string(wanted)] = comment
+				return nil
+			}
+		}
+		return errors.New("not found")
+	})
+}
+
+// SetRSAExp specifies the implementation of modular exponentiation used to
+// sign with RSA keys added from now on (e.g., rsaaccel.BigIntExp).  If nil,
+// crypto/rsa is used.
+func (k *Keyring) SetRSAExp(exp rsaaccel.ModExp) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.rsaExp = exp
+}
+
+// Sign implements agent.Agent.Sign.
+func (k *Keyring) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	// Look up the signer while holding the lock, but sign without it so
+	// that concurrent requests using different keys are not serialized.
+	k.mu.Lock()
+	signers, err := k.keyring.Signers()
+	k.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := key.Marshal()
+	for _, s := range signers {
+		if bytes.Equal(s.PublicKey().Marshal(), wanted) {
+			return s.Sign(rand.Reader, data)
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+// Signers implements agent.Agent.Signers.
+func (k *Keyring) Signers() ([]ssh.Signer, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.keyring.Signers()
+}
+
+// Add implements agent.Agent.Add.
+func (k *Keyring) Add(key agent.AddedKey) error {
+	return k.Update(func(a agent.Agent) error {
+		return a.Add(key)
+	})
+}
+
+// Remove implements agent.Agent.Remove.
+func (k *Keyring) Remove(key ssh.PublicKey) error {
+	return k.Update(func(a agent.Agent) error {
+		return a.Remove(key)
+	})
+}
+
+// RemoveAll implements agent.Agent.RemoveAll.
+func (k *Keyring) RemoveAll() error {
+	return k.Update(func(a agent.Agent) error {
+		return a.RemoveAll()
+	})
+}
+
+// Lock implements agent.Agent.Lock.  A locked keyring lists no keys.
+func (k *Keyring) Lock(passphrase []byte) error {
+	return k.Update(func(a agent.Agent) error {
+		return a.Lock(passphrase)
+	})
+}
+
+// Unlock implements agent.Agent.Unlock.
+func (k *Keyring) Unlock(passphrase []byte) error {
+	return k.Update(func(a agent.Agent) error {
+		return a.Unlock(passphrase)
+	})
+}
+
+// unversioned provides direct access to the underlying keyring while the
+// Keyring's lock is held.  It is only valid during a call to Update.
+type unversioned struct {
+	k *Keyring
+}
+
+func (u *unversioned) List() ([]*agent.Key, error) {
+	return u.k.keyring.List()
+}
+
+func (u *unversioned) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return u.k.keyring.Sign(key, data)
+}
+
+func (u *unversioned) Signers() ([]ssh.Signer, error) {
+	return u.k.keyring.Signers()
+}
+
+func (u *unversioned) Add(key agent.AddedKey) error {
+	if priv, ok := key.PrivateKey.(*rsa.PrivateKey); ok && u.k.rsaExp != nil {
+		key.PrivateKey = rsaaccel.NewSigner(priv, u.k.rsaExp)
+	}
+	if err := u.k.keyring.Add(key); err != nil {
+		return err
+	}
+	signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+	if err != nil {
+		// Not reached: the underlying keyring has already created a
+		// signer for the key.
+		return nil
+	}
+	blob := string(signer.PublicKey().Marshal())
+	if key.Certificate != nil {
+		// Keys are listed by their certificate.
+		blob = string(key.Certificate.Marshal())
+	}
+	delete(u.k.comments, blob)
+	if key.ConfirmBeforeUse {
+		u.k.confirm[blob] = true
+	} else {
+		delete(u.k.confirm, blob)
+	}
+	if key.LifetimeSecs > 0 {
+		lifetime := time.Duration(key.LifetimeSecs) * time.Second
+		u.k.expiry[blob] = u.k.now().Add(lifetime)
+		// Tell subscribers when the key expires, even if the keyring
+		// is not used in the meantime.
+		time.AfterFunc(lifetime, u.k.expire)
+	}
+	return nil
+}
+
+func (u *unversioned) Remove(key ssh.PublicKey) error {
+	delete(u.k.expiry, string(key.Marshal()))
+	delete(u.k.confirm, string(key.Marshal()))
+	delete(u.k.comments, string(key.Marshal()))
+	return u.k.keyring.Remove(key)
+}
+
+func (u *unversioned) RemoveAll() error {
+	u.k.expiry = make(map[string]time.Time)
+	u.k.confirm = make(map[string]bool)
+	u.k.comments = make(map[string]string)
+	return u.k.keyring.RemoveAll()
+}
+
+func (u *unversioned) Lock(passphrase []byte) error {
+	if err := u.k.keyring.Lock(passphrase); err != nil {
+		return err
+	}
+	u.k.locked = true
+	return nil
+}
+
+func (u *unversioned) Unlock(passphrase []byte) error {
+	if err := u.k.keyring.Unlock(passphrase); err != nil {
+		return err
+	}
+	u.k.locked = false
+	return nil
+}
@@ -0,0 +1,474 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/rsaaccel"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newKey(comment string) agent.AddedKey {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(fmt.Sprintf("failed to generate key: %v", err))
+	}
+	return agent.AddedKey{PrivateKey: priv, Comment: comment}
+}
+
+func publicKey(key agent.AddedKey) ssh.PublicKey {
+	signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create signer: %v", err))
+	}
+	return signer.PublicKey()
+}
+
+func comments(keys []*agent.Key) []string {
+	var result []string
+	for _, k := range keys {
+		result = append(result, k.Comment)
+	}
+	return result
+}
+
+func TestSnapshot(t *testing.T) {
+	k1, k2, k3 := newKey("key-1"), newKey("key-2"), newKey("key-3")
+
+	testcases := []struct {
+		description  string
+		sequence     func(k *Keyring) error
+		wantComments []string
+		wantVersion  uint64
+		wantErr      error
+	}{
+		{
+			description: "empty keyring",
+		},
+		{
+			description: "add keys",
+			sequence: func(k *Keyring) error {
+				if err := k.Add(k1); err != nil {
+					return err
+				}
+				return k.Add(k2)
+			},
+			wantComments: []string{"key-1", "key-2"},
+			wantVersion:  2,
+		},
+		{
+			description: "remove key",
+			sequence: func(k *Keyring) error {
+				if err := k.Add(k1); err != nil {
+					return err
+				}
+				if err := k.Add(k2); err != nil {
+					return err
+				}
+				return k.Remove(publicKey(k1))
+			},
+			wantComments: []string{"key-2"},
+			wantVersion:  3,
+		},
+		{
+			description: "update as single version",
+			sequence: func(k *Keyring) error {
+				return k.Update(func(a agent.Agent) error {
+					for _, key := range []agent.AddedKey{k1, k2, k3} {
+						if err := a.Add(key); err != nil {
+							return err
+						}
+					}
+					return a.Remove(publicKey(k2))
+				})
+			},
+			wantComments: []string{"key-1", "key-3"},
+			wantVersion:  1,
+		},
+		{
+			description: "failed update retains changes",
+			sequence: func(k *Keyring) error {
+				return k.Update(func(a agent.Agent) error {
+					if err := a.Add(k1); err != nil {
+						return err
+					}
+					return errors.New("update failed")
+				})
+			},
+			wantComments: []string{"key-1"},
+			wantVersion:  1,
+			wantErr:      errors.New("update failed"),
+		},
+		{
+			description: "lock hides keys",
+			sequence: func(k *Keyring) error {
+				if err := k.Add(k1); err != nil {
+					return err
+				}
+				return k.Lock([]byte("secret"))
+			},
+			wantVersion: 2,
+		},
+	}
+
+	for _, tc := range testcases {
+		k := New()
+		var err error
+		if tc.sequence != nil {
+			err = tc.sequence(k)
+		}
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+
+		s, err := k.Snapshot()
+		if err != nil {
+			t.Errorf("%s: failed to get snapshot: %v", tc.description, err)
+			continue
+		}
+		if diff := pretty.Diff(comments(s.Keys), tc.wantComments); diff != nil {
+			t.Errorf("%s: incorrect keys; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(s.Version, tc.wantVersion); diff != nil {
+			t.Errorf("%s: incorrect version; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	k := New()
+	if err := k.Add(newKey("key-1")); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+
+	before, err := k.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to get snapshot: %v", err)
+	}
+	if err := k.Add(newKey("key-2")); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	after, err := k.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to get snapshot: %v", err)
+	}
+
+	if diff := pretty.Diff(comments(before.Keys), []string{"key-1"}); diff != nil {
+		t.Errorf("earlier snapshot changed; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(comments(after.Keys), []string{"key-1", "key-2"}); diff != nil {
+		t.Errorf("incorrect keys in later snapshot; -got +want: %s", diff)
+	}
+
+	// Unchanged keyring returns the same snapshot.
+	again, err := k.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to get snapshot: %v", err)
+	}
+	if again != after {
+		t.Errorf("snapshot recomputed for unchanged keyring")
+	}
+}
+
+func TestExpiryChangesVersion(t *testing.T) {
+	now := time.Unix(1000, 0)
+	k := New()
+	k.now = func() time.Time { return now }
+
+	key := newKey("key-1")
+	key.LifetimeSecs = 60
+	if err := k.Add(key); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	if diff := pretty.Diff(k.Version(), uint64(1)); diff != nil {
+		t.Errorf("incorrect version before expiry; -got +want: %s", diff)
+	}
+
+	now = now.Add(61 * time.Second)
+	if diff := pretty.Diff(k.Version(), uint64(2)); diff != nil {
+		t.Errorf("incorrect version after expiry; -got +want: %s", diff)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	k := New()
+	k.now = func() time.Time { return now }
+
+	permanent := newKey("key-1")
+	if err := k.Add(permanent); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	expiring := newKey("key-2")
+	expiring.LifetimeSecs = 60
+	if err := k.Add(expiring); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+
+	if _, ok := k.Expiry(publicKey(permanent)); ok {
+		t.Errorf("expiry reported for key without lifetime")
+	}
+	got, ok := k.Expiry(publicKey(expiring))
+	if !ok {
+		t.Errorf("no expiry reported for key with lifetime")
+	}
+	if diff := pretty.Diff(got, now.Add(60*time.Second)); diff != nil {
+		t.Errorf("incorrect expiry; -got +want: %s", diff)
+	}
+
+	now = now.Add(61 * time.Second)
+	if _, ok := k.Expiry(publicKey(expiring)); ok {
+		t.Errorf("expiry reported for expired key")
+	}
+}
+
+func TestConfirmBeforeUse(t *testing.T) {
+	k := New()
+
+	plain := newKey("key-1")
+	if err := k.Add(plain); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	confirmed := newKey("key-2")
+	confirmed.ConfirmBeforeUse = true
+	if err := k.Add(confirmed); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+
+	if k.ConfirmBeforeUse(publicKey(plain)) {
+		t.Errorf("confirmation required for key added without constraint")
+	}
+	if !k.ConfirmBeforeUse(publicKey(confirmed)) {
+		t.Errorf("confirmation not required for key added with constraint")
+	}
+
+	// The constraint is forgotten once the key is removed.
+	if err := k.Remove(publicKey(confirmed)); err != nil {
+		t.Fatalf("failed to remove key: %v", err)
+	}
+	if k.ConfirmBeforeUse(publicKey(confirmed)) {
+		t.Errorf("confirmation required for removed key")
+	}
+}
+
+func TestRelabel(t *testing.T) {
+	k := New()
+
+	key := newKey("chrome-ssh-agent:1")
+	if err := k.Add(key); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	before := k.Version()
+	if err := k.Relabel(publicKey(key), "chrome-ssh-agent:1 nickname"); err != nil {
+		t.Fatalf("failed to relabel key: %v", err)
+	}
+	if k.Version() == before {
+		t.Errorf("version unchanged after relabel")
+	}
+	listed, err := k.List()
+	if err != nil {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+	if diff := pretty.Diff(comments(listed), []string{"chrome-ssh-agent:1 nickname"}); diff != nil {
+		t.Errorf("incorrect comments after relabel; -got +want: %s", diff)
+	}
+
+	// The new comment is forgotten once the key is removed.
+	if err := k.Remove(publicKey(key)); err != nil {
+		t.Fatalf("failed to remove key: %v", err)
+	}
+	if err := k.Add(key); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	listed, err = k.List()
+	if err != nil {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+	if diff := pretty.Diff(comments(listed), []string{"chrome-ssh-agent:1"}); diff != nil {
+		t.Errorf("incorrect comments after removing; -got +want: %s", diff)
+	}
+
+	if err := k.Relabel(publicKey(newKey("other")), "comment"); err == nil {
+		t.Errorf("relabelled key not in keyring")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	now := time.Unix(1000, 0)
+	k := New()
+	k.now = func() time.Time { return now }
+
+	var got []State
+	k.Subscribe(func(s *State) {
+		got = append(got, *s)
+		// Subscribers may use the keyring.
+		if _, err := k.List(); err != nil {
+			t.Errorf("failed to list keys from subscriber: %v", err)
+		}
+	})
+
+	if err := k.Add(newKey("key-1")); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	expiring := newKey("key-2")
+	expiring.LifetimeSecs = 60
+	if err := k.Add(expiring); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	if err := k.Lock([]byte("secret")); err != nil {
+		t.Fatalf("failed to lock: %v", err)
+	}
+	if err := k.Unlock([]byte("wrong")); err == nil {
+		t.Errorf("unlocked with incorrect passphrase")
+	}
+	if err := k.Unlock([]byte("secret")); err != nil {
+		t.Fatalf("failed to unlock: %v", err)
+	}
+
+	want := []State{
+		{Version: 1, Keys: 1},
+		{Version: 2, Keys: 2},
+		{Version: 3, Locked: true},
+		{Version: 4, Locked: true},
+		{Version: 5, Keys: 2},
+	}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect states; -got +want: %s", diff)
+	}
+
+	// Subscribers are told when keys expire.  The underlying keyring
+	// expires keys according to the real clock, so only the version is
+	// checked.
+	got = nil
+	now = now.Add(61 * time.Second)
+	k.Version()
+	if len(got) != 1 || got[0].Version != 6 {
+		t.Errorf("incorrect states after expiry; got %+v, want version 6", got)
+	}
+}
+
+func TestRSAExp(t *testing.T) {
+	key := newKey("rsa")
+
+	k := New()
+	calls := 0
+	k.SetRSAExp(func(base, exp, m *big.Int) *big.Int {
+		calls++
+		return rsaaccel.GoExp(base, exp, m)
+	})
+	if err := k.Add(key); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+
+	data := []byte("data")
+	sig, err := k.Sign(publicKey(key), data)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if err := publicKey(key).Verify(data, sig); err != nil {
+		t.Errorf("failed to verify signature: %v", err)
+	}
+	if calls == 0 {
+		t.Errorf("signature not computed using the configured exponentiation")
+	}
+}
+
+func benchmarkList(b *testing.B, n int) {
+	k := New()
+	for i := 0; i < n; i++ {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			b.Fatalf("failed to generate key: %v", err)
+		}
+		if err := k.Add(agent.AddedKey{PrivateKey: &priv, Comment: fmt.Sprintf("key-%d", i)}); err != nil {
+			b.Fatalf("failed to add key: %v", err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		keys, err := k.List()
+		if err != nil {
+			b.Fatalf("failed to list keys: %v", err)
+		}
+		if len(keys) != n {
+			b.Fatalf("listed %d keys, want %d", len(keys), n)
+		}
+	}
+}
+
+func BenchmarkList10(b *testing.B)  { benchmarkList(b, 10) }
+func BenchmarkList100(b *testing.B) { benchmarkList(b, 100) }
+
+func benchmarkSign(b *testing.B, priv interface{}, exp rsaaccel.ModExp) {
+	k := New()
+	k.SetRSAExp(exp)
+	key := agent.AddedKey{PrivateKey: priv, Comment: "some-key"}
+	if err := k.Add(key); err != nil {
+		b.Fatalf("failed to add key: %v", err)
+	}
+	pub := publicKey(key)
+	data := []byte("some-data")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := k.Sign(pub, data); err != nil {
+			b.Fatalf("failed to sign: %v", err)
+		}
+	}
+}
+
+func benchmarkSignECDSA(b *testing.B, curve elliptic.Curve) {
+	priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		b.Fatalf("failed to generate key: %v", err)
+	}
+	benchmarkSign(b, priv, nil)
+}
+
+func benchmarkSignRSA(b *testing.B, exp rsaaccel.ModExp) {
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		b.Fatalf("failed to parse private key: %v", err)
+	}
+	benchmarkSign(b, priv, exp)
+}
+
+func BenchmarkSignRSA(b *testing.B)            { benchmarkSignRSA(b, nil) }
+func BenchmarkSignRSAAccelerated(b *testing.B) { benchmarkSignRSA(b, rsaaccel.GoExp) }
+func BenchmarkSignECDSAP256(b *testing.B)      { benchmarkSignECDSA(b, elliptic.P256()) }
+func BenchmarkSignECDSAP384(b *testing.B)      { benchmarkSignECDSA(b, elliptic.P384()) }
+func BenchmarkSignECDSAP521(b *testing.B)      { benchmarkSignECDSA(b, elliptic.P521()) }
+
+func BenchmarkSignED25519(b *testing.B) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		b.Fatalf("failed to generate key: %v", err)
+	}
+	benchmarkSign(b, &priv, nil)
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/codec"
+)
+
+// The types in this package are plain Go structs, so that the logic operating
+// on them can be built and tested without a Javascript runtime.  They are
+// converted to and from the values exchanged with Chrome's storage and
+// messaging APIs using the codec package.
+
+// mustEncode converts v using the codec.  It is only used for types known to
+// be supported by the codec, so a failure indicates a programming error.
+func mustEncode(v interface{}) interface{} {
+	result, err := codec.Encode(v)
+	if err != nil {
+		panic(fmt.Sprintf("failed to encode %T: %v", v, err))
+	}
+	return result
+}
+
+// value returns the value written to persistent storage for the key.  Fields
+// that were read from storage but are not known to this version are
+// preserved.
+func (s *storedKey) value() map[string]interface{} {
+	m := mustEncode(s).(map[string]interface{})
+	for k, v := range s.unknown {
+		if _, ok := m[k]; !ok {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// storedKeyFromValue converts a value read from persistent storage into a
+// storedKey.
+func storedKeyFromValue(m map[string]interface{}) (*storedKey, error) {
+	s := &storedKey{}
+	if err := codec.Decode(m, s); err != nil {
+		return nil, err
+	}
+	for k, v := range m {
+		if knownField(k) {
+			continue
+		}
+		if s.unknown == nil {
+			s.unknown = make(map[string]interface{})
+		}
+		s.unknown[k] = v
+	}
+	return s, nil
+}
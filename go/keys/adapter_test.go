@@ -0,0 +1,249 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/codec"
+	"github.com/kr/pretty"
+)
+
+func TestStoredKeyValue(t *testing.T) {
+	testcases := []struct {
+		description string
+		key         *storedKey
+	}{
+		{
+			description: "all fields",
+			key: &storedKey{
+				ID:            ID("1"),
+				Name:          "name",
+				PEMPrivateKey: "pem",
+				Updated:       100,
+				DeviceOnly:    true,
+				Provider:      "provider",
+				Source:        SourceFile,
+				SourceDetail:  "id_rsa",
+				Created:       50,
+				DeviceID:      "device-id",
+				DeviceName:    "device-name",
+				Attestation:   "attestation",
+			},
+		},
+		{
+			description: "required fields only",
+			key: &storedKey{
+				ID:            ID("1"),
+				PEMPrivateKey: "pem",
+			},
+		},
+		{
+			description: "unknown fields are preserved",
+			key: &storedKey{
+				ID:            ID("1"),
+				PEMPrivateKey: "pem",
+				unknown:       map[string]interface{}{"futureField": 42.0},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := storedKeyFromValue(tc.key.value())
+		if err != nil {
+			t.Errorf("%s: failed to convert value: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(got, tc.key); diff != nil {
+			t.Errorf("%s: incorrect key; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestStoredKeyFromValue(t *testing.T) {
+	// Numbers read from Javascript are float64.
+	got, err := storedKeyFromValue(map[string]interface{}{
+		"id":            "1",
+		"pemPrivateKey": "pem",
+		"updated":       100.0,
+		"created":       50.0,
+	})
+	if err != nil {
+		t.Errorf("failed to convert value: %v", err)
+	}
+	want := &storedKey{
+		ID:            ID("1"),
+		PEMPrivateKey: "pem",
+		Updated:       100,
+		Created:       50,
+	}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect key; -got +want: %s", diff)
+	}
+}
+
+func TestConfiguredKeysValue(t *testing.T) {
+	testcases := []struct {
+		description string
+		keys        []*ConfiguredKey
+	}{
+		{
+			description: "no keys",
+		},
+		{
+			description: "multiple keys",
+			keys: []*ConfiguredKey{
+				{
+					ID:                  ID("1"),
+					Name:                "key-1",
+					Encrypted:           true,
+					Source:              SourceGenerated,
+					Created:             100,
+					DeviceName:          "device-name",
+					Synced:              true,
+					Attestation:         "attestation",
+					SourceDetail:        "detail",
+					CertificateWarnings: []string{"expired"},
+				},
+				{
+					ID:         ID("2"),
+					Name:       "key-2",
+					DeviceOnly: true,
+				},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		var got []*ConfiguredKey
+		if err := codec.Decode(mustEncode(tc.keys), &got); err != nil {
+			t.Errorf("%s: failed to decode keys: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(got, tc.keys); diff != nil {
+			t.Errorf("%s: incorrect keys; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestLoadedKeysValue(t *testing.T) {
+	want := []*LoadedKey{
+		{Type: "ssh-rsa", Blob: []byte{0, 1, 2, 255}, Comment: "comment", Expires: 1500000000000},
+	}
+
+	var got []*LoadedKey
+	if err := codec.Decode(mustEncode(want), &got); err != nil {
+		t.Errorf("failed to decode keys: %v", err)
+	}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect keys; -got +want: %s", diff)
+	}
+}
+
+func TestLoadedKeyFromValue(t *testing.T) {
+	testcases := []struct {
+		description string
+		value       interface{}
+		want        *LoadedKey
+		wantErr     bool
+	}{
+		{
+			description: "valid key",
+			value: map[string]interface{}{
+				"type":    "ssh-rsa",
+				"blob":    "AAEC/w==",
+				"comment": "comment",
+			},
+			want: &LoadedKey{Type: "ssh-rsa", Blob: []byte{0, 1, 2, 255}, Comment: "comment"},
+		},
+		{
+			description: "not an object",
+			value:       "garbage",
+			want:        &LoadedKey{},
+			wantErr:     true,
+		},
+		{
+			description: "invalid blob",
+			value: map[string]interface{}{
+				"type":    "ssh-rsa",
+				"blob":    "!!!",
+				"comment": "comment",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		got := &LoadedKey{}
+		err := codec.Decode(tc.value, got)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %v", tc.description, err, tc.wantErr)
+		}
+		if tc.want == nil {
+			continue
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect key; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestStorageUsageValue(t *testing.T) {
+	want := &StorageUsage{
+		Keys: []*KeyUsage{
+			{ID: ID("1"), Bytes: 42},
+			{ID: ID("2"), Bytes: 84, DeviceOnly: true},
+		},
+		SyncBytesInUse:  42,
+		SyncQuota:       100,
+		SyncQuotaPerKey: 50,
+		LocalBytesInUse: 84,
+		LocalQuota:      1000,
+	}
+
+	got := &StorageUsage{}
+	if err := codec.Decode(mustEncode(want), got); err != nil {
+		t.Errorf("failed to decode usage: %v", err)
+	}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect usage; -got +want: %s", diff)
+	}
+}
+
+func TestItemSize(t *testing.T) {
+	testcases := []struct {
+		description string
+		key         string
+		value       interface{}
+		want        int
+	}{
+		{
+			description: "string",
+			key:         "key.1",
+			value:       "value",
+			want:        len("key.1") + len(`"value"`),
+		},
+		{
+			description: "object",
+			key:         "key.1",
+			value:       map[string]interface{}{"name": "<name>", "updated": int64(100)},
+			want:        len("key.1") + len(`{"name":"<name>","updated":100}`),
+		},
+	}
+
+	for _, tc := range testcases {
+		if got := itemSize(tc.key, tc.value); got != tc.want {
+			t.Errorf("%s: incorrect size; got %d, want %d", tc.description, got, tc.want)
+		}
+	}
+}
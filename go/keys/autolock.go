@@ -0,0 +1,221 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// AutoLockAction is what the agent does when it locks automatically.
+type AutoLockAction string
+
+const (
+	// AutoLockUnload unloads all keys.
+	AutoLockUnload AutoLockAction = "unload"
+	// AutoLockVault unloads all keys and locks the vault, so that keys
+	// sealed by it cannot be loaded until the master passphrase is
+	// entered again.
+	AutoLockVault AutoLockAction = "lock-vault"
+)
+
+// AutoLockActions lists the available actions, in the order in which they
+// should be presented.
+var AutoLockActions = []AutoLockAction{AutoLockUnload, AutoLockVault}
+
+// Description returns a human-readable description of the action.
+func (a AutoLockAction) Description() string {
+	switch a {
+	case AutoLockUnload:
+		return "Unload all keys"
+	case AutoLockVault:
+		return "Unload all keys and lock the vault"
+	}
+	return "Unknown"
+}
+
+// IdleState is the state of the machine, as reported by chrome.idle.
+type IdleState string
+
+const (
+	// IdleActive indicates that the user is using the machine.
+	IdleActive IdleState = "active"
+	// IdleIdle indicates that the user has not used the machine for the
+	// detection interval.
+	IdleIdle IdleState = "idle"
+	// IdleLocked indicates that the screen is locked.
+	IdleLocked IdleState = "locked"
+)
+
+const (
+	// autoLockPolicyKey is the key under which the auto-lock policy is
+	// stored.  It is stored on each device, since whether a device is
+	// left unattended varies from one to the next.
+	autoLockPolicyKey = "autolock.policy"
+	// MinAutoLockIdle is the shortest idle period that may be selected.
+	MinAutoLockIdle = time.Minute
+	// MaxAutoLockIdle is the longest idle period that may be selected.
+	MaxAutoLockIdle = 4 * time.Hour
+)
+
+// AutoLockIdlePeriods lists the idle periods offered to the user, in the
+// order in which they should be presented.  Zero indicates that the agent
+// is not locked when the machine is idle.
+var AutoLockIdlePeriods = []time.Duration{0, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute, time.Hour, MaxAutoLockIdle}
+
+// AutoLockPolicy determines when the agent locks automatically.  The zero
+// value never locks it.
+type AutoLockPolicy struct {
+	// OnScreenLock locks the agent when the screen is locked.
+	OnScreenLock bool
+	// Idle locks the agent when the machine has not been used for this
+	// long, or is zero if it is not locked when idle.
+	Idle time.Duration
+	// Action is what is done when the agent locks.  Empty is treated as
+	// AutoLockUnload.
+	Action AutoLockAction
+}
+
+// Enabled returns true if the policy ever locks the agent.
+func (p *AutoLockPolicy) Enabled() bool {
+	return p.OnScreenLock || p.Idle > 0
+}
+
+// Triggered returns true if the policy locks the agent when the machine
+// enters the specified state.  A screen lock is not treated as being idle;
+// the agent locks on one only if OnScreenLock is set.
+func (p *AutoLockPolicy) Triggered(state IdleState) bool {
+	switch state {
+	case IdleLocked:
+		return p.OnScreenLock
+	case IdleIdle:
+		return p.Idle > 0
+	}
+	return false
+}
+
+// check returns an error if the policy is invalid.
+func (p *AutoLockPolicy) check() error {
+	if p.Idle != 0 && (p.Idle < MinAutoLockIdle || p.Idle > MaxAutoLockIdle) {
+		return fmt.Errorf("idle period must be between %v and %v", MinAutoLockIdle, MaxAutoLockIdle)
+	}
+	if p.Idle%time.Second != 0 {
+		return fmt.Errorf("idle period must be a whole number of seconds")
+	}
+	switch p.Action {
+	case "", AutoLockUnload, AutoLockVault:
+		return nil
+	}
+	return fmt.Errorf("unknown auto-lock action %q", p.Action)
+}
+
+// value returns the policy as stored.
+func (p *AutoLockPolicy) value() map[string]interface{} {
+	action := p.Action
+	if action == "" {
+		action = AutoLockUnload
+	}
+	return map[string]interface{}{
+		"onScreenLock": p.OnScreenLock,
+		"idle":         p.Idle.Seconds(),
+		"action":       string(action),
+	}
+}
+
+// parseAutoLockPolicy parses a stored policy.  A missing or invalid policy
+// never locks the agent.
+func parseAutoLockPolicy(v interface{}) *AutoLockPolicy {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return &AutoLockPolicy{Action: AutoLockUnload}
+	}
+	p := &AutoLockPolicy{}
+	p.OnScreenLock, _ = m["onScreenLock"].(bool)
+	// Numbers are decoded from storage as float64.
+	secs, _ := m["idle"].(float64)
+	p.Idle = time.Duration(secs) * time.Second
+	action, _ := m["action"].(string)
+	p.Action = AutoLockAction(action)
+	if p.Action == "" {
+		p.Action = AutoLockUnload
+	}
+	if p.check() != nil {
+		return &AutoLockPolicy{Action: AutoLockUnload}
+	}
+	return p
+}
+
+// AutoLockPolicy implements Manager.AutoLockPolicy.
+func (m *manager) AutoLockPolicy(callback func(policy *AutoLockPolicy, err error)) {
+	m.localStorage.GetItems([]string{autoLockPolicyKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, help.Errorf(help.StorageFailure, "failed to read auto-lock policy: %v", err))
+			return
+		}
+		callback(parseAutoLockPolicy(data[autoLockPolicyKey]), nil)
+	})
+}
+
+// SetAutoLockPolicy implements Manager.SetAutoLockPolicy.
+func (m *manager) SetAutoLockPolicy(policy *AutoLockPolicy, callback func(err error)) {
+	if err := policy.check(); err != nil {
+		callback(err)
+		return
+	}
+	m.localStorage.Set(map[string]interface{}{autoLockPolicyKey: policy.value()}, func(err error) {
+		if err != nil {
+			callback(help.Errorf(help.StorageFailure, "failed to write auto-lock policy: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// AutoLockPolicyChanged returns true if the supplied storage changes (as
+// passed to a chrome.Storage OnChanged callback) affect the auto-lock
+// policy.
+func AutoLockPolicyChanged(changes map[string]interface{}) bool {
+	_, ok := changes[autoLockPolicyKey]
+	return ok
+}
+
+// AutoLock locks the agent as required by the auto-lock policy when the
+// machine enters the specified state (e.g., as reported by
+// chrome.idle.onStateChanged).  callback is invoked with the outcome of
+// unloading each key, or a nil result if the policy does not lock the agent
+// in that state.
+func AutoLock(mgr Manager, state IdleState, callback func(result *Result, err error)) {
+	mgr.AutoLockPolicy(func(policy *AutoLockPolicy, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		if !policy.Triggered(state) {
+			callback(nil, nil)
+			return
+		}
+		UnloadAll(mgr, func(result *Result, err error) {
+			if err != nil || policy.Action != AutoLockVault {
+				callback(result, err)
+				return
+			}
+			mgr.LockVault(func(err error) {
+				callback(result, err)
+			})
+		})
+	})
+}
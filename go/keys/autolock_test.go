@@ -0,0 +1,191 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAutoLockPolicy(t *testing.T) {
+	testcases := []struct {
+		description string
+		policy      *AutoLockPolicy
+		want        *AutoLockPolicy
+		wantErr     error
+	}{
+		{
+			description: "never lock",
+			policy:      &AutoLockPolicy{},
+			want:        &AutoLockPolicy{Action: AutoLockUnload},
+		},
+		{
+			description: "lock on screen lock",
+			policy:      &AutoLockPolicy{OnScreenLock: true, Action: AutoLockVault},
+			want:        &AutoLockPolicy{OnScreenLock: true, Action: AutoLockVault},
+		},
+		{
+			description: "lock when idle",
+			policy:      &AutoLockPolicy{Idle: 15 * time.Minute, Action: AutoLockUnload},
+			want:        &AutoLockPolicy{Idle: 15 * time.Minute, Action: AutoLockUnload},
+		},
+		{
+			description: "idle period too short",
+			policy:      &AutoLockPolicy{Idle: 30 * time.Second},
+			want:        &AutoLockPolicy{Action: AutoLockUnload},
+			wantErr:     errors.New("idle period must be between 1m0s and 4h0m0s"),
+		},
+		{
+			description: "idle period too long",
+			policy:      &AutoLockPolicy{Idle: MaxAutoLockIdle + time.Minute},
+			want:        &AutoLockPolicy{Action: AutoLockUnload},
+			wantErr:     errors.New("idle period must be between 1m0s and 4h0m0s"),
+		},
+		{
+			description: "unknown action",
+			policy:      &AutoLockPolicy{OnScreenLock: true, Action: "shutdown"},
+			want:        &AutoLockPolicy{Action: AutoLockUnload},
+			wantErr:     errors.New(`unknown auto-lock action "shutdown"`),
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		err := syncSetAutoLockPolicy(mgr, tc.policy)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		got, err := syncAutoLockPolicy(mgr)
+		if err != nil {
+			t.Errorf("%s: failed to read policy: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect policy; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestAutoLockPolicyChanged(t *testing.T) {
+	if !AutoLockPolicyChanged(map[string]interface{}{autoLockPolicyKey: nil}) {
+		t.Errorf("change to policy not detected")
+	}
+	if AutoLockPolicyChanged(map[string]interface{}{"other": nil}) {
+		t.Errorf("unrelated change detected")
+	}
+}
+
+func syncAutoLock(mgr Manager, state IdleState) (*Result, error) {
+	errc := make(chan error, 1)
+	var result *Result
+	AutoLock(mgr, state, func(r *Result, err error) {
+		result = r
+		errc <- err
+		close(errc)
+	})
+	return result, readErr(errc)
+}
+
+func TestAutoLock(t *testing.T) {
+	testcases := []struct {
+		description     string
+		policy          *AutoLockPolicy
+		state           IdleState
+		wantLocked      bool
+		wantVaultLocked bool
+	}{
+		{
+			description: "policy disabled",
+			policy:      &AutoLockPolicy{},
+			state:       IdleLocked,
+		},
+		{
+			description: "machine active",
+			policy:      &AutoLockPolicy{OnScreenLock: true, Idle: 5 * time.Minute},
+			state:       IdleActive,
+		},
+		{
+			description: "screen locked",
+			policy:      &AutoLockPolicy{OnScreenLock: true},
+			state:       IdleLocked,
+			wantLocked:  true,
+		},
+		{
+			description: "screen lock ignored",
+			policy:      &AutoLockPolicy{Idle: 5 * time.Minute},
+			state:       IdleLocked,
+		},
+		{
+			description: "machine idle",
+			policy:      &AutoLockPolicy{Idle: 5 * time.Minute},
+			state:       IdleIdle,
+			wantLocked:  true,
+		},
+		{
+			description:     "vault locked",
+			policy:          &AutoLockPolicy{OnScreenLock: true, Action: AutoLockVault},
+			state:           IdleLocked,
+			wantLocked:      true,
+			wantVaultLocked: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		if err := syncSetMasterPassphrase(mgr, "", "master"); err != nil {
+			t.Fatalf("%s: failed to set master passphrase: %v", tc.description, err)
+		}
+		if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncLoad(mgr, id, ""); err != nil {
+			t.Fatalf("%s: failed to load key: %v", tc.description, err)
+		}
+		if err := syncSetAutoLockPolicy(mgr, tc.policy); err != nil {
+			t.Fatalf("%s: failed to set policy: %v", tc.description, err)
+		}
+
+		result, err := syncAutoLock(mgr, tc.state)
+		if err != nil {
+			t.Errorf("%s: failed to lock: %v", tc.description, err)
+		}
+		if got := result != nil; got != tc.wantLocked {
+			t.Errorf("%s: incorrect lock state; got %t, want %t", tc.description, got, tc.wantLocked)
+		}
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Errorf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		if got := len(loaded) == 0; got != tc.wantLocked {
+			t.Errorf("%s: incorrect unload state; got %t, want %t", tc.description, got, tc.wantLocked)
+		}
+		_, unlocked, err := syncVaultStatus(mgr)
+		if err != nil {
+			t.Errorf("%s: failed to get vault status: %v", tc.description, err)
+		}
+		if unlocked == tc.wantVaultLocked {
+			t.Errorf("%s: incorrect vault state; got unlocked %t, want %t", tc.description, unlocked, !tc.wantVaultLocked)
+		}
+	}
+}
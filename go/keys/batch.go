@@ -0,0 +1,118 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+)
+
+// ErrBatchCancelled is returned for keys that were not loaded because the
+// batch was cancelled.
+var ErrBatchCancelled = errors.New("batch load cancelled")
+
+// LoadProgress reports the outcome of loading a single key in a batch.
+type LoadProgress struct {
+	// ID is the ID of the key.
+	ID ID
+	// Err is the error encountered while loading the key, or nil if the key
+	// was loaded.
+	Err error
+	// Done is the number of keys in the batch that have been processed,
+	// including this one.
+	Done int
+	// Total is the number of keys in the batch.
+	Total int
+}
+
+// Batch is a batch of keys being loaded by a BatchLoader.
+type Batch struct {
+	cancelled bool
+}
+
+// Cancel stops loading keys in the batch.  Keys that are currently being
+// decrypted are allowed to finish; the remaining keys are not loaded.
+func (b *Batch) Cancel() {
+	b.cancelled = true
+}
+
+// BatchLoader loads many keys using a bounded number of concurrent
+// requests, so that decrypting a large number of keys does not monopolize
+// the Manager, and so that a batch can be cancelled part way through.
+type BatchLoader struct {
+	mgr     Manager
+	workers int
+}
+
+// NewBatchLoader returns a BatchLoader that loads keys using mgr, with at
+// most workers keys being decrypted at once.
+func NewBatchLoader(mgr Manager, workers int) *BatchLoader {
+	if workers < 1 {
+		workers = 1
+	}
+	return &BatchLoader{
+		mgr:     mgr,
+		workers: workers,
+	}
+}
+
+// LoadAll loads the keys with the specified IDs, using passphrase to decrypt
+// any that are encrypted.  Keys are only decrypted once a worker is
+// available to process them.  progress is invoked as each key is processed,
+// and callback is invoked with the outcome for every key once the batch is
+// complete.  The returned Batch may be used to cancel loading.
+func (l *BatchLoader) LoadAll(ids []ID, passphrase string, progress func(p *LoadProgress), callback func(result *Result)) *Batch {
+	batch := &Batch{}
+	total := len(ids)
+	pending := append([]ID(nil), ids...)
+	inflight := 0
+	finished := false
+	result := NewResult("load")
+
+	report := func(id ID, err error) {
+		result.Record(id, err)
+		progress(&LoadProgress{ID: id, Err: err, Done: result.Total(), Total: total})
+	}
+
+	var next func()
+	next = func() {
+		for !batch.cancelled && inflight < l.workers && len(pending) > 0 {
+			id := pending[0]
+			pending = pending[1:]
+			inflight++
+			l.mgr.Load(id, passphrase, func(err error) {
+				inflight--
+				report(id, err)
+				next()
+			})
+		}
+
+		if finished || inflight > 0 || (len(pending) > 0 && !batch.cancelled) {
+			return
+		}
+		finished = true
+
+		if batch.cancelled {
+			remaining := pending
+			pending = nil
+			for _, id := range remaining {
+				report(id, ErrBatchCancelled)
+			}
+		}
+		callback(result)
+	}
+
+	next()
+	return batch
+}
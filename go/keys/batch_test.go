@@ -0,0 +1,150 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kr/pretty"
+)
+
+// queuedManager is a Manager whose Load operations complete only when
+// explicitly requested, allowing tests to observe concurrent loads.
+type queuedManager struct {
+	dummyManager
+	// fail contains the IDs of keys that fail to load.
+	fail map[ID]bool
+	// queue contains the callbacks for loads that are in progress.
+	queue []func()
+	// maxInflight is the maximum number of concurrent loads observed.
+	maxInflight int
+	// started contains the IDs of keys for which loading was started.
+	started []ID
+}
+
+func (m *queuedManager) Load(id ID, passphrase string, callback func(err error)) {
+	m.started = append(m.started, id)
+	m.queue = append(m.queue, func() {
+		if m.fail[id] {
+			callback(errors.New("failed"))
+			return
+		}
+		callback(nil)
+	})
+	if len(m.queue) > m.maxInflight {
+		m.maxInflight = len(m.queue)
+	}
+}
+
+// completeOne completes the oldest load in progress.
+func (m *queuedManager) completeOne() bool {
+	if len(m.queue) == 0 {
+		return false
+	}
+	cb := m.queue[0]
+	m.queue = m.queue[1:]
+	cb()
+	return true
+}
+
+func TestBatchLoadAll(t *testing.T) {
+	ids := []ID{"id-0", "id-1", "id-2", "id-3", "id-4"}
+
+	testcases := []struct {
+		description     string
+		workers         int
+		fail            map[ID]bool
+		cancelAfter     int
+		wantStarted     []ID
+		wantFailed      []ID
+		wantMaxInflight int
+		wantErr         error
+	}{
+		{
+			description:     "load all keys",
+			workers:         2,
+			wantStarted:     ids,
+			wantMaxInflight: 2,
+		},
+		{
+			description:     "load with single worker",
+			workers:         1,
+			wantStarted:     ids,
+			wantMaxInflight: 1,
+		},
+		{
+			description:     "report failures",
+			workers:         3,
+			fail:            map[ID]bool{"id-1": true, "id-3": true},
+			wantStarted:     ids,
+			wantFailed:      []ID{"id-1", "id-3"},
+			wantMaxInflight: 3,
+			wantErr:         errors.New("failed to load 2 of 5 keys"),
+		},
+		{
+			description:     "cancel part way",
+			workers:         2,
+			cancelAfter:     1,
+			wantStarted:     []ID{"id-0", "id-1"},
+			wantFailed:      []ID{"id-2", "id-3", "id-4"},
+			wantMaxInflight: 2,
+			wantErr:         ErrBatchCancelled,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := &queuedManager{fail: tc.fail}
+		loader := NewBatchLoader(mgr, tc.workers)
+
+		var batch *Batch
+		var failed []ID
+		var result *Result
+		completed := false
+		batch = loader.LoadAll(ids, "passphrase", func(p *LoadProgress) {
+			if p.Err != nil {
+				failed = append(failed, p.ID)
+			}
+			if tc.cancelAfter > 0 && p.Done == tc.cancelAfter {
+				batch.Cancel()
+			}
+		}, func(r *Result) {
+			result = r
+			completed = true
+		})
+		for mgr.completeOne() {
+		}
+
+		if !completed {
+			t.Errorf("%s: batch did not complete", tc.description)
+			continue
+		}
+		if diff := pretty.Diff(result.Err(), tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(mgr.started, tc.wantStarted); diff != nil {
+			t.Errorf("%s: incorrect keys started; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(failed, tc.wantFailed); diff != nil {
+			t.Errorf("%s: incorrect keys failed; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(mgr.maxInflight, tc.wantMaxInflight); diff != nil {
+			t.Errorf("%s: incorrect concurrency; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(result.Succeeded+result.Failed+result.Cancelled, len(ids)); diff != nil {
+			t.Errorf("%s: incorrect summary counts; -got +want: %s", tc.description, diff)
+		}
+	}
+}
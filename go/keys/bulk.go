@@ -0,0 +1,162 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+)
+
+// BulkAction identifies a destructive operation on many keys.
+type BulkAction string
+
+const (
+	// BulkRemove removes configured keys.
+	BulkRemove BulkAction = "remove"
+	// BulkUnload unloads keys from the agent.
+	BulkUnload BulkAction = "unload"
+)
+
+// Change describes the change a bulk operation makes to a single key.
+type Change struct {
+	// ID is the ID of the key, or InvalidID for a loaded key that is not
+	// configured.
+	ID ID
+	// Name is a human-readable name for the key.
+	Name string
+	// loaded is the key to unload, for BulkUnload.
+	loaded *LoadedKey
+}
+
+// Plan lists exactly the keys a bulk operation will change.  It is computed
+// without making any changes, so that it can be shown to the user for
+// confirmation before it is applied.
+type Plan struct {
+	// Action is the operation to perform.
+	Action BulkAction
+	// Changes lists the affected keys.
+	Changes []*Change
+}
+
+// PlanRemoveAll computes a Plan to remove all configured keys.  callback is
+// invoked with the plan; no keys are removed.
+func PlanRemoveAll(mgr Manager, callback func(plan *Plan, err error)) {
+	mgr.Configured(func(configured []*ConfiguredKey, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read configured keys: %v", err))
+			return
+		}
+		plan := &Plan{Action: BulkRemove}
+		for _, k := range configured {
+			plan.Changes = append(plan.Changes, &Change{ID: k.ID, Name: k.DisplayName()})
+		}
+		callback(plan, nil)
+	})
+}
+
+// PlanUnloadAll computes a Plan to unload all keys loaded in the agent.
+// callback is invoked with the plan; no keys are unloaded.
+func PlanUnloadAll(mgr Manager, callback func(plan *Plan, err error)) {
+	mgr.Configured(func(configured []*ConfiguredKey, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read configured keys: %v", err))
+			return
+		}
+		names := make(map[ID]string)
+		for _, k := range configured {
+			names[k.ID] = k.DisplayName()
+		}
+
+		mgr.Loaded(func(loaded []*LoadedKey, err error) {
+			if err != nil {
+				callback(nil, fmt.Errorf("failed to read loaded keys: %v", err))
+				return
+			}
+			plan := &Plan{Action: BulkUnload}
+			for _, l := range loaded {
+				c := &Change{ID: l.ID(), Name: names[l.ID()], loaded: l}
+				if c.Name == "" {
+					c.Name = l.Nickname()
+				}
+				if c.Name == "" {
+					c.Name = l.Comment
+				}
+				plan.Changes = append(plan.Changes, c)
+			}
+			callback(plan, nil)
+		})
+	})
+}
+
+// Apply performs the changes listed in the plan, one key at a time.  Only
+// the listed keys are changed; keys added since the plan was computed are
+// left alone.  callback is invoked with the outcome for each key.
+func (p *Plan) Apply(mgr Manager, callback func(result *Result)) {
+	result := NewResult(string(p.Action))
+	var next func(i int)
+	next = func(i int) {
+		if i == len(p.Changes) {
+			callback(result)
+			return
+		}
+		c := p.Changes[i]
+		done := func(err error) {
+			result.Record(c.ID, err)
+			next(i + 1)
+		}
+		switch p.Action {
+		case BulkRemove:
+			mgr.Remove(c.ID, done)
+		case BulkUnload:
+			mgr.Unload(c.loaded, done)
+		default:
+			done(fmt.Errorf("unknown action %q", p.Action))
+		}
+	}
+	next(0)
+}
+
+// UnloadAll unloads all keys loaded in the agent at once, without asking for
+// confirmation (e.g., when the user presses the quick-lock shortcut).
+// callback is invoked with the outcome for each key, or an error if the
+// loaded keys could not be determined.
+func UnloadAll(mgr Manager, callback func(result *Result, err error)) {
+	PlanUnloadAll(mgr, func(plan *Plan, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		plan.Apply(mgr, func(result *Result) {
+			callback(result, nil)
+		})
+	})
+}
+
+// QuickLock unloads all keys loaded in the agent and locks the vault, as
+// when the user presses the quick-lock shortcut.  The vault is locked even if
+// some keys could not be unloaded.  callback is invoked with the outcome for
+// each key, and an error if either step failed.
+func QuickLock(mgr Manager, callback func(result *Result, err error)) {
+	UnloadAll(mgr, func(result *Result, err error) {
+		if err == nil {
+			err = result.Err()
+		}
+		mgr.LockVault(func(lockErr error) {
+			if err == nil && lockErr != nil {
+				err = fmt.Errorf("failed to lock vault: %v", lockErr)
+			}
+			callback(result, err)
+		})
+	})
+}
@@ -0,0 +1,238 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func changeNames(plan *Plan) []string {
+	var result []string
+	for _, c := range plan.Changes {
+		result = append(result, c.Name)
+	}
+	return result
+}
+
+func sortedStrings(s []string) []string {
+	sort.Strings(s)
+	return s
+}
+
+func TestBulk(t *testing.T) {
+	testcases := []struct {
+		description    string
+		plan           func(mgr Manager, callback func(plan *Plan, err error))
+		wantChanges    []string
+		wantConfigured []string
+		wantLoaded     int
+	}{
+		{
+			description:    "remove all",
+			plan:           PlanRemoveAll,
+			wantChanges:    []string{"key-1", "key-2"},
+			wantConfigured: []string{"added-later"},
+			wantLoaded:     1,
+		},
+		{
+			description:    "unload all",
+			plan:           PlanUnloadAll,
+			wantChanges:    []string{"key-1"},
+			wantConfigured: []string{"added-later", "key-1", "key-2"},
+			wantLoaded:     0,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		for _, name := range []string{"key-1", "key-2"} {
+			if err := syncAdd(mgr, name, testdata.ValidPrivateKey, nil); err != nil {
+				t.Fatalf("%s: failed to add key: %v", tc.description, err)
+			}
+		}
+		id, err := findKey(mgr, InvalidID, "key-1")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase); err != nil {
+			t.Fatalf("%s: failed to load key: %v", tc.description, err)
+		}
+
+		var plan *Plan
+		tc.plan(mgr, func(p *Plan, err error) {
+			if err != nil {
+				t.Fatalf("%s: failed to plan: %v", tc.description, err)
+			}
+			plan = p
+		})
+		if diff := pretty.Diff(sortedStrings(changeNames(plan)), tc.wantChanges); diff != nil {
+			t.Errorf("%s: incorrect changes; -got +want: %s", tc.description, diff)
+		}
+
+		// Planning makes no changes.
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to read configured keys: %v", tc.description, err)
+		}
+		if len(configured) != 2 {
+			t.Errorf("%s: keys changed while planning: %v", tc.description, configuredKeyNames(configured))
+		}
+
+		// Keys added after planning are not affected by applying the
+		// plan.
+		if err := syncAdd(mgr, "added-later", testdata.ValidPrivateKey, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		var result *Result
+		plan.Apply(mgr, func(r *Result) { result = r })
+		if result.Succeeded != len(tc.wantChanges) || result.Err() != nil {
+			t.Errorf("%s: incorrect result: %s (%v)", tc.description, result.Summary(), result.Err())
+		}
+
+		configured, err = syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to read configured keys: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(sortedStrings(configuredKeyNames(configured)), tc.wantConfigured); diff != nil {
+			t.Errorf("%s: incorrect configured keys; -got +want: %s", tc.description, diff)
+		}
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to read loaded keys: %v", tc.description, err)
+		}
+		if len(loaded) != tc.wantLoaded {
+			t.Errorf("%s: incorrect number of loaded keys; got %d, want %d", tc.description, len(loaded), tc.wantLoaded)
+		}
+	}
+}
+
+func TestUnloadAll(t *testing.T) {
+	mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+	for _, name := range []string{"key-1", "key-2"} {
+		if err := syncAdd(mgr, name, testdata.ValidPrivateKey, nil); err != nil {
+			t.Fatalf("failed to add key: %v", err)
+		}
+	}
+	id, err := findKey(mgr, InvalidID, "key-1")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	if err := syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase); err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	if err := syncLoadEphemeral(mgr, "ephemeral", testdata.ValidPrivateKeyWithoutPassphrase); err != nil {
+		t.Fatalf("failed to load ephemeral key: %v", err)
+	}
+
+	var result *Result
+	UnloadAll(mgr, func(r *Result, err error) {
+		if err != nil {
+			t.Fatalf("failed to unload keys: %v", err)
+		}
+		result = r
+	})
+	if result.Succeeded != 2 || result.Err() != nil {
+		t.Errorf("incorrect result: %s (%v)", result.Summary(), result.Err())
+	}
+
+	loaded, err := syncLoaded(mgr)
+	if err != nil {
+		t.Fatalf("failed to read loaded keys: %v", err)
+	}
+	if len(loaded) != 0 {
+		t.Errorf("keys still loaded: %d", len(loaded))
+	}
+	configured, err := syncConfigured(mgr)
+	if err != nil {
+		t.Fatalf("failed to read configured keys: %v", err)
+	}
+	if diff := pretty.Diff(sortedStrings(configuredKeyNames(configured)), []string{"key-1", "key-2"}); diff != nil {
+		t.Errorf("incorrect configured keys; -got +want: %s", diff)
+	}
+}
+
+// lockFailingManager is a Manager that fails to lock the vault.
+type lockFailingManager struct {
+	Manager
+}
+
+func (m *lockFailingManager) LockVault(callback func(err error)) {
+	callback(errors.New("lock failed"))
+}
+
+func TestQuickLock(t *testing.T) {
+	testcases := []struct {
+		description  string
+		failLock     bool
+		wantErr      bool
+		wantUnlocked bool
+	}{
+		{
+			description: "unloads keys and locks vault",
+		},
+		{
+			description:  "vault fails to lock",
+			failLock:     true,
+			wantErr:      true,
+			wantUnlocked: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		var mgr Manager = NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		if err := syncSetMasterPassphrase(mgr, "", "master"); err != nil {
+			t.Fatalf("%s: failed to set master passphrase: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncLoad(mgr, id, ""); err != nil {
+			t.Fatalf("%s: failed to load key: %v", tc.description, err)
+		}
+		if tc.failLock {
+			mgr = &lockFailingManager{mgr}
+		}
+
+		var result *Result
+		QuickLock(mgr, func(r *Result, err error) {
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("%s: incorrect error; got %v, want error %v", tc.description, err, tc.wantErr)
+			}
+			result = r
+		})
+		if result == nil || result.Succeeded != 1 {
+			t.Errorf("%s: incorrect result: %v", tc.description, result)
+		}
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to read loaded keys: %v", tc.description, err)
+		}
+		if len(loaded) != 0 {
+			t.Errorf("%s: keys still loaded: %d", tc.description, len(loaded))
+		}
+		checkVaultStatus(t, tc.description, mgr, true, tc.wantUnlocked)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/google/chrome-ssh-agent/go/agenthooks"
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrCanaryKey is returned when a signature is requested using a canary key.
+var ErrCanaryKey = errors.New("signatures using this key are refused")
+
+// CanaryGuard refuses signatures requested using canary keys.  A canary key
+// is listed to clients like any other loaded key, but is never used to sign;
+// a client asking it to sign indicates that the client (or a host to which
+// the agent was forwarded) may be compromised.
+type CanaryGuard struct {
+	mu sync.Mutex
+	// canaries contains the name of each canary key, keyed by ID.
+	canaries map[ID]string
+	// tripped is invoked each time a signature is refused.
+	tripped func(id ID, name string)
+}
+
+// NewCanaryGuard returns a CanaryGuard with no canary keys.  tripped is
+// invoked with the ID and name of the key each time a signature is refused.
+func NewCanaryGuard(tripped func(id ID, name string)) *CanaryGuard {
+	return &CanaryGuard{
+		canaries: make(map[ID]string),
+		tripped:  tripped,
+	}
+}
+
+// set records whether the key with the specified ID and name is a canary.
+func (g *CanaryGuard) set(id ID, name string, canary bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if canary {
+		g.canaries[id] = name
+	} else {
+		delete(g.canaries, id)
+	}
+}
+
+// CheckSign determines if the specified loaded key may be used to sign.
+func (g *CanaryGuard) CheckSign(key *agent.Key) error {
+	id := (&LoadedKey{Comment: key.Comment}).ID()
+	if id == InvalidID {
+		return nil
+	}
+
+	g.mu.Lock()
+	name, ok := g.canaries[id]
+	g.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	log.Printf("CANARY: refused signature using canary key %q (%s)", name, id)
+	if g.tripped != nil {
+		g.tripped(id, name)
+	}
+	return ErrCanaryKey
+}
+
+// Hooks returns hooks that refuse signatures using canary keys when
+// installed in an agenthooks.Agent.
+func (g *CanaryGuard) Hooks() *agenthooks.Hooks {
+	return &agenthooks.Hooks{
+		BeforeSign: func(key *agent.Key, data []byte) error {
+			return g.CheckSign(key)
+		},
+	}
+}
+
+// WithCanaryGuard specifies a guard that is told which keys are canaries as
+// they are loaded and marked.  By default, canary keys are not tracked.
+func WithCanaryGuard(guard *CanaryGuard) ManagerOption {
+	return func(m *manager) {
+		m.canaries = guard
+	}
+}
+
+// SetCanary implements Manager.SetCanary.
+func (m *manager) SetCanary(id ID, canary bool, callback func(err error)) {
+	m.writes.runErr(func(callback func(err error)) {
+		m.setCanary(id, canary, callback)
+	}, callback)
+}
+
+// setCanary rewrites the stored key with the specified ID to mark or unmark
+// it as a canary.  callback is invoked when complete.
+func (m *manager) setCanary(id ID, canary bool, callback func(err error)) {
+	m.readKey(id, func(key *storedKey, err error) {
+		if err != nil {
+			callback(help.Errorf(help.StorageFailure, "failed to read key: %v", err))
+			return
+		}
+		if key == nil {
+			callback(help.Errorf(help.KeyNotFound, "failed to find key with ID %s", id))
+			return
+		}
+
+		key.Canary = canary
+		key.Updated = nowMillis()
+		data := map[string]interface{}{
+			storageKey(id): key.value(),
+		}
+		m.storeFor(key.DeviceOnly).Set(data, func(err error) {
+			if err != nil {
+				callback(help.Errorf(help.StorageFailure, "failed to write key: %v", err))
+				return
+			}
+			if m.canaries != nil {
+				m.canaries.set(id, key.Name, canary)
+			}
+			if m.audit != nil {
+				action := "marked key %q as a canary"
+				if !canary {
+					action = "unmarked key %q as a canary"
+				}
+				m.audit.Record(audit.NewEntry("canary", "options", string(id), true, fmt.Sprintf(action, key.Name)), nil)
+			}
+			callback(nil)
+		})
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/agenthooks"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCanary(t *testing.T) {
+	testcases := []struct {
+		description string
+		canary      bool
+		unmark      bool
+		wantErr     error
+		wantTripped []string
+	}{
+		{
+			description: "ordinary key signs",
+		},
+		{
+			description: "canary key refuses to sign",
+			canary:      true,
+			wantErr:     ErrCanaryKey,
+			wantTripped: []string{"some-key"},
+		},
+		{
+			description: "unmarked canary key signs",
+			canary:      true,
+			unmark:      true,
+		},
+	}
+
+	for _, tc := range testcases {
+		var tripped []string
+		guard := NewCanaryGuard(func(id ID, name string) {
+			tripped = append(tripped, name)
+		})
+		agt := agenthooks.New(keyring.New())
+		agt.Install(guard.Hooks())
+		mgr := NewManager(agt, fakes.NewMemStorage(), fakes.NewMemStorage(), WithCanaryGuard(guard))
+		if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if tc.canary {
+			if err := syncSetCanary(mgr, id, true); err != nil {
+				t.Fatalf("%s: failed to mark canary: %v", tc.description, err)
+			}
+		}
+		if err := syncLoad(mgr, id, ""); err != nil {
+			t.Fatalf("%s: failed to load key: %v", tc.description, err)
+		}
+		if tc.unmark {
+			if err := syncSetCanary(mgr, id, false); err != nil {
+				t.Fatalf("%s: failed to unmark canary: %v", tc.description, err)
+			}
+		}
+
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to get configured keys: %v", tc.description, err)
+		}
+		if got, want := configured[0].Canary, tc.canary && !tc.unmark; got != want {
+			t.Errorf("%s: incorrect canary; got %t, want %t", tc.description, got, want)
+		}
+
+		// The key is listed to clients regardless.
+		loaded, err := agt.List()
+		if err != nil || len(loaded) != 1 {
+			t.Fatalf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		pub, err := ssh.ParsePublicKey(loaded[0].Blob)
+		if err != nil {
+			t.Fatalf("%s: failed to parse public key: %v", tc.description, err)
+		}
+		_, err = agt.Sign(pub, []byte("some-data"))
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(tripped, tc.wantTripped); diff != nil {
+			t.Errorf("%s: incorrect tripped keys; -got +want: %s", tc.description, diff)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// certificateExpiryWarning is how long before a certificate expires
+	// that a warning is given.
+	certificateExpiryWarning = 7 * 24 * time.Hour
+)
+
+// knownCriticalOptions describes the critical options understood by OpenSSH
+// servers.  A server refuses a certificate with a critical option it does
+// not recognize.
+var knownCriticalOptions = map[string]func(value string) string{
+	"force-command": func(value string) string {
+		return fmt.Sprintf("certificate only permits running the command %q", value)
+	},
+	"source-address": func(value string) string {
+		return fmt.Sprintf("certificate valid only when connecting from %s", value)
+	},
+}
+
+// ParseCertificate parses an OpenSSH certificate in authorized_keys format
+// (e.g., the contents of id_ed25519-cert.pub).
+func ParseCertificate(s string) (*ssh.Certificate, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		return nil, help.Errorf(help.InvalidCertificate, "failed to parse certificate: %v", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, help.Errorf(help.InvalidCertificate, "%s is a public key, not a certificate", pub.Type())
+	}
+	return cert, nil
+}
+
+// certTime converts a time in a certificate to a time.Time.
+func certTime(t uint64) time.Time {
+	return time.Unix(int64(t), 0)
+}
+
+// quoteAll returns each of s in single quotes, joined by commas.
+func quoteAll(s []string) string {
+	var quoted []string
+	for _, v := range s {
+		quoted = append(quoted, fmt.Sprintf("'%s'", v))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// CheckCertificate determines if cert may be loaded along with the private
+// key whose public key is pub.  An error is returned if it is not a user
+// certificate, was issued for a different key, or is not valid at now;
+// servers would reject such a certificate without explanation.
+func CheckCertificate(cert *ssh.Certificate, pub ssh.PublicKey, now time.Time) error {
+	if err := checkCertificateKey(cert, pub); err != nil {
+		return err
+	}
+	if after := certTime(cert.ValidAfter); now.Before(after) {
+		return help.Errorf(help.InvalidCertificate, "certificate is not valid until %s", after.UTC().Format(time.RFC3339))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		if before := certTime(cert.ValidBefore); !now.Before(before) {
+			return help.Errorf(help.InvalidCertificate, "certificate expired at %s", before.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// checkCertificateKey determines if cert is a user certificate issued for
+// the key whose public key is pub.  If pub is nil, the key is not checked.
+func checkCertificateKey(cert *ssh.Certificate, pub ssh.PublicKey) error {
+	if cert.CertType != ssh.UserCert {
+		return help.Errorf(help.InvalidCertificate, "certificate is a host certificate; a user certificate is required")
+	}
+	if pub != nil && !bytes.Equal(cert.Key.Marshal(), pub.Marshal()) {
+		return help.Errorf(help.InvalidCertificate, "certificate was issued for key %s, not this key (%s)", ssh.FingerprintSHA256(cert.Key), ssh.FingerprintSHA256(pub))
+	}
+	return nil
+}
+
+// CertificateWarnings describes the restrictions cert places on its use
+// that may cause a server to reject it, as of now (e.g., "certificate valid
+// only for principal 'deploy'").  Problems that prevent the certificate
+// from being loaded at all (see CheckCertificate) are included too.
+func CertificateWarnings(cert *ssh.Certificate, now time.Time) []string {
+	var warnings []string
+	if err := CheckCertificate(cert, nil, now); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+
+	switch len(cert.ValidPrincipals) {
+	case 0:
+		warnings = append(warnings, "certificate lists no principals; most servers will reject it")
+	case 1:
+		warnings = append(warnings, fmt.Sprintf("certificate valid only for principal %s", quoteAll(cert.ValidPrincipals)))
+	default:
+		warnings = append(warnings, fmt.Sprintf("certificate valid only for principals %s", quoteAll(cert.ValidPrincipals)))
+	}
+
+	var options []string
+	for name := range cert.CriticalOptions {
+		options = append(options, name)
+	}
+	sort.Strings(options)
+	for _, name := range options {
+		if describe, ok := knownCriticalOptions[name]; ok {
+			warnings = append(warnings, describe(cert.CriticalOptions[name]))
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("certificate has critical option %q, which servers that do not understand it will reject", name))
+	}
+
+	if _, ok := cert.Extensions["permit-pty"]; !ok {
+		warnings = append(warnings, "certificate does not permit interactive sessions (no permit-pty)")
+	}
+
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		before := certTime(cert.ValidBefore)
+		if left := before.Sub(now); left > 0 && left < certificateExpiryWarning {
+			warnings = append(warnings, fmt.Sprintf("certificate expires at %s", before.UTC().Format(time.RFC3339)))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,312 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// certNow is the time at which certificates are checked in tests.
+var certNow = time.Unix(1500000000, 0)
+
+// testKey returns the public key of testdata.ValidPrivateKeyWithoutPassphrase.
+func testKey(t *testing.T) ssh.PublicKey {
+	blob, err := base64.StdEncoding.DecodeString(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+	if err != nil {
+		t.Fatalf("failed to decode public key: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(blob)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+	return pub
+}
+
+// newTestCertificate returns a user certificate for key, valid for principal
+// 'deploy' for 30 days around certNow, after applying modify.
+func newTestCertificate(t *testing.T, key ssh.PublicKey, modify func(cert *ssh.Certificate)) *ssh.Certificate {
+	_, caKey, err := ed25519.GenerateKey(provider.NewDeterministicRand("certificate-authority"))
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA signer: %v", err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.UserCert,
+		KeyId:           "test",
+		ValidPrincipals: []string{"deploy"},
+		ValidAfter:      uint64(certNow.Add(-24 * time.Hour).Unix()),
+		ValidBefore:     uint64(certNow.Add(30 * 24 * time.Hour).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{"permit-pty": ""},
+		},
+	}
+	if modify != nil {
+		modify(cert)
+	}
+	if err := cert.SignCert(provider.NewDeterministicRand("certificate"), ca); err != nil {
+		t.Fatalf("failed to sign certificate: %v", err)
+	}
+	return cert
+}
+
+func TestCheckCertificate(t *testing.T) {
+	key := testKey(t)
+	other := newTestCertificate(t, key, nil).SignatureKey
+
+	testcases := []struct {
+		description string
+		modify      func(cert *ssh.Certificate)
+		pub         ssh.PublicKey
+		wantErr     bool
+	}{
+		{
+			description: "valid certificate",
+			pub:         key,
+		},
+		{
+			description: "valid certificate without expiry",
+			modify: func(cert *ssh.Certificate) {
+				cert.ValidBefore = ssh.CertTimeInfinity
+			},
+			pub: key,
+		},
+		{
+			description: "host certificate",
+			modify: func(cert *ssh.Certificate) {
+				cert.CertType = ssh.HostCert
+			},
+			pub:     key,
+			wantErr: true,
+		},
+		{
+			description: "certificate for another key",
+			pub:         other,
+			wantErr:     true,
+		},
+		{
+			description: "certificate not yet valid",
+			modify: func(cert *ssh.Certificate) {
+				cert.ValidAfter = uint64(certNow.Add(time.Hour).Unix())
+			},
+			pub:     key,
+			wantErr: true,
+		},
+		{
+			description: "certificate expired",
+			modify: func(cert *ssh.Certificate) {
+				cert.ValidBefore = uint64(certNow.Unix())
+			},
+			pub:     key,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		cert := newTestCertificate(t, key, tc.modify)
+		err := CheckCertificate(cert, tc.pub, certNow)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %t", tc.description, err, tc.wantErr)
+		}
+		if err != nil && help.CodeOf(err) != help.InvalidCertificate {
+			t.Errorf("%s: incorrect help code; got %s, want %s", tc.description, help.CodeOf(err), help.InvalidCertificate)
+		}
+	}
+}
+
+func TestCertificateWarnings(t *testing.T) {
+	key := testKey(t)
+
+	testcases := []struct {
+		description  string
+		modify       func(cert *ssh.Certificate)
+		wantWarnings []string
+	}{
+		{
+			description:  "single principal",
+			wantWarnings: []string{"certificate valid only for principal 'deploy'"},
+		},
+		{
+			description: "multiple principals",
+			modify: func(cert *ssh.Certificate) {
+				cert.ValidPrincipals = []string{"deploy", "admin"}
+			},
+			wantWarnings: []string{"certificate valid only for principals 'deploy', 'admin'"},
+		},
+		{
+			description: "no principals",
+			modify: func(cert *ssh.Certificate) {
+				cert.ValidPrincipals = nil
+			},
+			wantWarnings: []string{"certificate lists no principals; most servers will reject it"},
+		},
+		{
+			description: "critical options",
+			modify: func(cert *ssh.Certificate) {
+				cert.CriticalOptions = map[string]string{
+					"source-address":  "10.0.0.0/8",
+					"force-command":   "/usr/bin/deploy",
+					"verify-required": "",
+				}
+			},
+			wantWarnings: []string{
+				"certificate valid only for principal 'deploy'",
+				`certificate only permits running the command "/usr/bin/deploy"`,
+				"certificate valid only when connecting from 10.0.0.0/8",
+				`certificate has critical option "verify-required", which servers that do not understand it will reject`,
+			},
+		},
+		{
+			description: "no terminal",
+			modify: func(cert *ssh.Certificate) {
+				cert.Extensions = nil
+			},
+			wantWarnings: []string{
+				"certificate valid only for principal 'deploy'",
+				"certificate does not permit interactive sessions (no permit-pty)",
+			},
+		},
+		{
+			description: "expires soon",
+			modify: func(cert *ssh.Certificate) {
+				cert.ValidBefore = uint64(certNow.Add(time.Hour).Unix())
+			},
+			wantWarnings: []string{
+				"certificate valid only for principal 'deploy'",
+				"certificate expires at 2017-07-14T03:40:00Z",
+			},
+		},
+		{
+			description: "expired",
+			modify: func(cert *ssh.Certificate) {
+				cert.ValidBefore = uint64(certNow.Add(-time.Hour).Unix())
+			},
+			wantWarnings: []string{
+				"certificate expired at 2017-07-14T01:40:00Z",
+				"certificate valid only for principal 'deploy'",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		cert := newTestCertificate(t, key, tc.modify)
+		warnings := CertificateWarnings(cert, certNow)
+		if diff := pretty.Diff(warnings, tc.wantWarnings); diff != nil {
+			t.Errorf("%s: incorrect warnings; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestAddCertificate(t *testing.T) {
+	key := testKey(t)
+	valid := func(cert *ssh.Certificate) {
+		// Configured and Load check the certificate against the
+		// current time.
+		cert.ValidAfter = 0
+		cert.ValidBefore = ssh.CertTimeInfinity
+	}
+	cert := string(ssh.MarshalAuthorizedKey(newTestCertificate(t, key, valid)))
+
+	testcases := []struct {
+		description   string
+		pemPrivateKey string
+		certificate   string
+		wantErr       help.Code
+		wantLoadErr   help.Code
+		wantLoaded    string
+		wantWarnings  []string
+	}{
+		{
+			description:   "add and load certificate",
+			pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			certificate:   cert,
+			wantLoaded:    ssh.CertAlgoRSAv01,
+			wantWarnings:  []string{"certificate valid only for principal 'deploy'"},
+		},
+		{
+			description:   "reject public key",
+			pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			certificate:   "ssh-rsa " + testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			wantErr:       help.InvalidCertificate,
+		},
+		{
+			description:   "reject certificate for another key",
+			pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			certificate:   string(ssh.MarshalAuthorizedKey(newTestCertificate(t, newTestCertificate(t, key, nil).SignatureKey, valid))),
+			wantErr:       help.InvalidCertificate,
+		},
+		{
+			description:   "refuse to load certificate for another encrypted key",
+			pemPrivateKey: testdata.ValidPrivateKey,
+			certificate:   cert,
+			wantLoadErr:   help.InvalidCertificate,
+			wantWarnings:  []string{"certificate valid only for principal 'deploy'"},
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), nil)
+		if err != nil {
+			t.Fatalf("%s: failed to initialize manager: %v", tc.description, err)
+		}
+
+		err = syncAdd(mgr, "cert-key", tc.pemPrivateKey, &AddOptions{Certificate: tc.certificate})
+		if help.CodeOf(err) != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want code %s", tc.description, err, tc.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+
+		configured, err := syncConfigured(mgr)
+		if err != nil || len(configured) != 1 {
+			t.Fatalf("%s: failed to get configured keys: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(configured[0].CertificateWarnings, tc.wantWarnings); diff != nil {
+			t.Errorf("%s: incorrect warnings; -got +want: %s", tc.description, diff)
+		}
+
+		err = syncLoad(mgr, configured[0].ID, testdata.ValidPrivateKeyPassphrase)
+		if help.CodeOf(err) != tc.wantLoadErr {
+			t.Errorf("%s: incorrect load error; got %v, want code %s", tc.description, err, tc.wantLoadErr)
+		}
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to get loaded keys: %v", tc.description, err)
+		}
+		var gotLoaded string
+		if len(loaded) == 1 {
+			gotLoaded = loaded[0].Type
+		}
+		if gotLoaded != tc.wantLoaded {
+			t.Errorf("%s: incorrect loaded key type; got %q, want %q", tc.description, gotLoaded, tc.wantLoaded)
+		}
+	}
+}
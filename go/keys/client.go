@@ -17,14 +17,21 @@ package keys
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/google/chrome-ssh-agent/go/codec"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/redact"
 	"github.com/gopherjs/gopherjs/js"
 )
 
 // MessageReceiver defines methods sufficient to receive messages and send
-// responses.
+// responses, and to tell which were sent by the extension's own pages.
 type MessageReceiver interface {
 	OnMessage(callback func(header *js.Object, sender *js.Object, sendResponse func(interface{})) bool)
+	ExtensionURL() string
 }
 
 // Server exposes a Manager instance via a messaging API so that a shared
@@ -60,6 +67,56 @@ const (
 	msgTypeLoadRsp
 	msgTypeUnload
 	msgTypeUnloadRsp
+	msgTypeUsage
+	msgTypeUsageRsp
+	msgTypeLoadEphemeral
+	msgTypeLoadEphemeralRsp
+	msgTypeExport
+	msgTypeExportRsp
+	msgTypeSetCanary
+	msgTypeSetCanaryRsp
+	msgTypeIDScheme
+	msgTypeIDSchemeRsp
+	msgTypeSetIDScheme
+	msgTypeSetIDSchemeRsp
+	msgTypeSetNotes
+	msgTypeSetNotesRsp
+	msgTypeUnloadByFingerprint
+	msgTypeUnloadByFingerprintRsp
+	msgTypeUnloadByName
+	msgTypeUnloadByNameRsp
+	msgTypeInventory
+	msgTypeInventoryRsp
+	msgTypeSetRevoked
+	msgTypeSetRevokedRsp
+	msgTypeGenerate
+	msgTypeGenerateRsp
+	msgTypeVaultStatus
+	msgTypeVaultStatusRsp
+	msgTypeUnlockVault
+	msgTypeUnlockVaultRsp
+	msgTypeSetMasterPassphrase
+	msgTypeSetMasterPassphraseRsp
+	msgTypeSetProfile
+	msgTypeSetProfileRsp
+	msgTypeSelfCheck
+	msgTypeSelfCheckRsp
+	msgTypePublicKey
+	msgTypePublicKeyRsp
+	msgTypeSetLoadOnStartup
+	msgTypeSetLoadOnStartupRsp
+	msgTypeLoadOnStartup
+	msgTypeLoadOnStartupRsp
+	msgTypeLockVault
+	msgTypeLockVaultRsp
+	msgTypeAutoLockPolicy
+	msgTypeAutoLockPolicyRsp
+	msgTypeSetAutoLockPolicy
+	msgTypeSetAutoLockPolicyRsp
+	msgTypeSetSchedule
+	msgTypeSetScheduleRsp
+	msgTypeUpgrade
+	msgTypeUpgradeRsp
 )
 
 // msgHeader are the common fields included in every message (as an embedded
@@ -75,8 +132,9 @@ type msgConfigured struct {
 
 type rspConfigured struct {
 	*msgHeader
-	Keys []*ConfiguredKey `js:"keys"`
-	Err  string           `js:"err"`
+	Keys    interface{} `js:"keys"`
+	Err     string      `js:"err"`
+	ErrCode help.Code   `js:"errCode"`
 }
 
 type msgLoaded struct {
@@ -85,19 +143,33 @@ type msgLoaded struct {
 
 type rspLoaded struct {
 	*msgHeader
-	Keys []*LoadedKey `js:"keys"`
-	Err  string       `js:"err"`
+	Keys    interface{} `js:"keys"`
+	Err     string      `js:"err"`
+	ErrCode help.Code   `js:"errCode"`
 }
 
 type msgAdd struct {
 	*msgHeader
-	Name          string `js:"name"`
-	PEMPrivateKey string `js:"pemPrivateKey"`
+	Name          string     `js:"name"`
+	PEMPrivateKey string     `js:"pemPrivateKey"`
+	DeviceOnly    bool       `js:"deviceOnly"`
+	Provider      string     `js:"provider"`
+	Source        Source     `js:"source"`
+	SourceDetail  string     `js:"sourceDetail"`
+	UniqueName    bool       `js:"uniqueName"`
+	Attestation   string     `js:"attestation"`
+	Protection    Protection `js:"protection"`
+	Certificate   string     `js:"certificate"`
+	TOTPSecret    string     `js:"totpSecret"`
+	Passphrase    string     `js:"passphrase"`
+	Notes         string     `js:"notes"`
+	Profile       string     `js:"profile"`
 }
 
 type rspAdd struct {
 	*msgHeader
-	Err string `js:"err"`
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
 }
 
 type msgRemove struct {
@@ -107,76 +179,432 @@ type msgRemove struct {
 
 type rspRemove struct {
 	*msgHeader
-	Err string `js:"err"`
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
 }
 
 type msgLoad struct {
 	*msgHeader
 	ID         ID     `js:"id"`
 	Passphrase string `js:"passphrase"`
+	// Lifetime is in seconds; zero selects the lifetime from local
+	// storage.
+	Lifetime int `js:"lifetime"`
 }
 
 type rspLoad struct {
 	*msgHeader
-	Err string `js:"err"`
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgLoadEphemeral struct {
+	*msgHeader
+	Name          string `js:"name"`
+	PEMPrivateKey string `js:"pemPrivateKey"`
+}
+
+type rspLoadEphemeral struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgExport struct {
+	*msgHeader
+	ID               ID                      `js:"id"`
+	Passphrase       string                  `js:"passphrase"`
+	Format           keyformat.PrivateFormat `js:"format"`
+	ExportPassphrase string                  `js:"exportPassphrase"`
+}
+
+type rspExport struct {
+	*msgHeader
+	Encoded string    `js:"encoded"`
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
 }
 
 type msgUnload struct {
 	*msgHeader
-	Key *LoadedKey `js:"key"`
+	Key interface{} `js:"key"`
 }
 
 type rspUnload struct {
 	*msgHeader
-	Err string `js:"err"`
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
 }
 
-// makeErr converts a string to an error. Empty string returns nil (i.e., no
-// error).
-func makeErr(s string) error {
+type msgUsage struct {
+	*msgHeader
+}
+
+type rspUsage struct {
+	*msgHeader
+	Usage   interface{} `js:"usage"`
+	Err     string      `js:"err"`
+	ErrCode help.Code   `js:"errCode"`
+}
+
+type msgSetCanary struct {
+	*msgHeader
+	ID     ID   `js:"id"`
+	Canary bool `js:"canary"`
+}
+
+type rspSetCanary struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgSetRevoked struct {
+	*msgHeader
+	ID      ID   `js:"id"`
+	Revoked bool `js:"revoked"`
+}
+
+type rspSetRevoked struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgGenerate struct {
+	*msgHeader
+	Name       string `js:"name"`
+	KeyType    string `js:"keyType"`
+	Bits       int    `js:"bits"`
+	Passphrase string `js:"passphrase"`
+}
+
+type rspGenerate struct {
+	*msgHeader
+	PublicKey string    `js:"publicKey"`
+	Err       string    `js:"err"`
+	ErrCode   help.Code `js:"errCode"`
+}
+
+type msgVaultStatus struct {
+	*msgHeader
+}
+
+type rspVaultStatus struct {
+	*msgHeader
+	Enabled  bool      `js:"enabled"`
+	Unlocked bool      `js:"unlocked"`
+	Err      string    `js:"err"`
+	ErrCode  help.Code `js:"errCode"`
+}
+
+type msgUnlockVault struct {
+	*msgHeader
+	Passphrase string `js:"passphrase"`
+}
+
+type rspUnlockVault struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgSetMasterPassphrase struct {
+	*msgHeader
+	Current    string `js:"current"`
+	Passphrase string `js:"passphrase"`
+}
+
+type rspSetMasterPassphrase struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgIDScheme struct {
+	*msgHeader
+}
+
+type rspIDScheme struct {
+	*msgHeader
+	Scheme  IDScheme  `js:"scheme"`
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgSetIDScheme struct {
+	*msgHeader
+	Scheme IDScheme `js:"scheme"`
+}
+
+type rspSetIDScheme struct {
+	*msgHeader
+	Migrated int       `js:"migrated"`
+	Err      string    `js:"err"`
+	ErrCode  help.Code `js:"errCode"`
+}
+
+type msgSetNotes struct {
+	*msgHeader
+	ID    ID     `js:"id"`
+	Notes string `js:"notes"`
+}
+
+type rspSetNotes struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgSetProfile struct {
+	*msgHeader
+	ID      ID     `js:"id"`
+	Profile string `js:"profile"`
+}
+
+type rspSetProfile struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgUnloadByFingerprint struct {
+	*msgHeader
+	Fingerprint string `js:"fingerprint"`
+}
+
+type rspUnloadByFingerprint struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgUnloadByName struct {
+	*msgHeader
+	Name string `js:"name"`
+}
+
+type rspUnloadByName struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgInventory struct {
+	*msgHeader
+}
+
+type rspInventory struct {
+	*msgHeader
+	Items   interface{} `js:"items"`
+	Err     string      `js:"err"`
+	ErrCode help.Code   `js:"errCode"`
+}
+
+type msgSelfCheck struct {
+	*msgHeader
+	Sample int `js:"sample"`
+}
+
+type rspSelfCheck struct {
+	*msgHeader
+	Results interface{} `js:"results"`
+	Err     string      `js:"err"`
+	ErrCode help.Code   `js:"errCode"`
+}
+
+type msgUpgrade struct {
+	*msgHeader
+}
+
+type rspUpgrade struct {
+	*msgHeader
+	Result  interface{} `js:"result"`
+	Err     string      `js:"err"`
+	ErrCode help.Code   `js:"errCode"`
+}
+
+type msgPublicKey struct {
+	*msgHeader
+	ID     ID                     `js:"id"`
+	Format keyformat.PublicFormat `js:"format"`
+}
+
+type rspPublicKey struct {
+	*msgHeader
+	Encoded string    `js:"encoded"`
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgSetLoadOnStartup struct {
+	*msgHeader
+	ID      ID   `js:"id"`
+	Enabled bool `js:"enabled"`
+}
+
+type rspSetLoadOnStartup struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgLoadOnStartup struct {
+	*msgHeader
+}
+
+type rspLoadOnStartup struct {
+	*msgHeader
+	Loaded  int       `js:"loaded"`
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgLockVault struct {
+	*msgHeader
+}
+
+type rspLockVault struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgAutoLockPolicy struct {
+	*msgHeader
+}
+
+type rspAutoLockPolicy struct {
+	*msgHeader
+	// Idle is in seconds.
+	Idle         int            `js:"idle"`
+	OnScreenLock bool           `js:"onScreenLock"`
+	Action       AutoLockAction `js:"action"`
+	Err          string         `js:"err"`
+	ErrCode      help.Code      `js:"errCode"`
+}
+
+type msgSetAutoLockPolicy struct {
+	*msgHeader
+	// Idle is in seconds.
+	Idle         int            `js:"idle"`
+	OnScreenLock bool           `js:"onScreenLock"`
+	Action       AutoLockAction `js:"action"`
+}
+
+type rspSetAutoLockPolicy struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+type msgSetSchedule struct {
+	*msgHeader
+	ID       ID     `js:"id"`
+	Schedule string `js:"schedule"`
+}
+
+type rspSetSchedule struct {
+	*msgHeader
+	Err     string    `js:"err"`
+	ErrCode help.Code `js:"errCode"`
+}
+
+// makeErr converts a string and associated help topic to an error. Empty
+// string returns nil (i.e., no error).
+func makeErr(s string, code help.Code) error {
 	if s == "" {
 		return nil
 	}
+	if code == help.Timeout && s == ErrTimeout.Error() {
+		return ErrTimeout
+	}
+	if code != help.None {
+		return &help.Error{Code: code, Err: errors.New(s)}
+	}
 	return errors.New(s)
 }
 
-// makeErrStr converts an error to a string. A nil error is converted to the
-// empty string.
+// makeErrStr converts an error to a string, redacting any sensitive material
+// it contains. A nil error is converted to the empty string.
 func makeErrStr(err error) string {
 	if err == nil {
 		return ""
 	}
-	return err.Error()
+	return redact.String(err.Error())
+}
+
+// fromExtension determines if a message was sent by one of the pages of the
+// extension served under extensionURL, rather than by a content script.
+// Content scripts run in a tab and report the URL of the page in it.
+// Extension pages may run in a tab too (e.g., the prompt's popup window), so
+// a tab is only accepted if its page is the extension's.
+func fromExtension(sender *js.Object, extensionURL string) bool {
+	if sender == nil || sender == js.Undefined || extensionURL == "" {
+		return false
+	}
+	if tab := sender.Get("tab"); tab != nil && tab != js.Undefined {
+		if u := tab.Get("url"); u != nil && u != js.Undefined && !strings.HasPrefix(u.String(), extensionURL) {
+			return false
+		}
+	}
+	u := sender.Get("url")
+	if u == nil || u == js.Undefined {
+		return false
+	}
+	return strings.HasPrefix(u.String(), extensionURL)
 }
 
 // onMessage is the callback invoked when a message is received. It determines
 // the type of request received, invokes the appropriate method on the
 // underlying manager instance, and then sends a response with the result.
 func (s *Server) onMessage(headerObj *js.Object, sender *js.Object, sendResponse func(interface{})) bool {
+	if !fromExtension(sender, s.msg.ExtensionURL()) {
+		// Keys may only be managed by the extension's own pages, not by
+		// content scripts running in a tab.  Leave the message to
+		// other listeners (e.g., the bridge).
+		return false
+	}
 	header := &msgHeader{Object: headerObj}
 	switch header.Type {
 	case msgTypeConfigured:
 		s.mgr.Configured(func(keys []*ConfiguredKey, err error) {
 			rsp := &rspConfigured{msgHeader: header}
 			rsp.Type = msgTypeConfiguredRsp
-			rsp.Keys = keys
+			rsp.Keys = mustEncode(keys)
 			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
 			sendResponse(rsp)
 		})
 	case msgTypeLoaded:
 		s.mgr.Loaded(func(keys []*LoadedKey, err error) {
 			rsp := &rspLoaded{msgHeader: header}
 			rsp.Type = msgTypeLoadedRsp
-			rsp.Keys = keys
+			rsp.Keys = mustEncode(keys)
 			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
 			sendResponse(rsp)
 		})
 	case msgTypeAdd:
 		m := &msgAdd{msgHeader: header}
-		s.mgr.Add(m.Name, m.PEMPrivateKey, func(err error) {
+		s.mgr.Add(m.Name, m.PEMPrivateKey, &AddOptions{
+			DeviceOnly:   m.DeviceOnly,
+			Provider:     m.Provider,
+			Source:       m.Source,
+			SourceDetail: m.SourceDetail,
+			UniqueName:   m.UniqueName,
+			Attestation:  m.Attestation,
+			Protection:   m.Protection,
+			Certificate:  m.Certificate,
+			TOTPSecret:   m.TOTPSecret,
+			Notes:        m.Notes,
+			Passphrase:   m.Passphrase,
+			Profile:      m.Profile,
+		}, func(err error) {
 			rsp := &rspAdd{msgHeader: header}
 			rsp.Type = msgTypeAddRsp
 			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
 			sendResponse(rsp)
 		})
 	case msgTypeRemove:
@@ -185,24 +613,277 @@ func (s *Server) onMessage(headerObj *js.Object, sender *js.Object, sendResponse
 			rsp := &rspRemove{msgHeader: header}
 			rsp.Type = msgTypeRemoveRsp
 			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
 			sendResponse(rsp)
 		})
 	case msgTypeLoad:
 		m := &msgLoad{msgHeader: header}
-		s.mgr.Load(m.ID, m.Passphrase, func(err error) {
+		s.mgr.LoadWithLifetime(m.ID, m.Passphrase, time.Duration(m.Lifetime)*time.Second, func(err error) {
 			rsp := &rspLoad{msgHeader: header}
 			rsp.Type = msgTypeLoadRsp
 			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeLoadEphemeral:
+		m := &msgLoadEphemeral{msgHeader: header}
+		s.mgr.LoadEphemeral(m.Name, m.PEMPrivateKey, func(err error) {
+			rsp := &rspLoadEphemeral{msgHeader: header}
+			rsp.Type = msgTypeLoadEphemeralRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeExport:
+		m := &msgExport{msgHeader: header}
+		s.mgr.Export(m.ID, m.Passphrase, m.Format, m.ExportPassphrase, func(encoded string, err error) {
+			rsp := &rspExport{msgHeader: header}
+			rsp.Type = msgTypeExportRsp
+			rsp.Encoded = encoded
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
 			sendResponse(rsp)
 		})
 	case msgTypeUnload:
 		m := &msgUnload{msgHeader: header}
-		s.mgr.Unload(m.Key, func(err error) {
+		key := &LoadedKey{}
+		if err := codec.Decode(m.Key, key); err != nil {
 			rsp := &rspUnload{msgHeader: header}
 			rsp.Type = msgTypeUnloadRsp
+			rsp.Err = makeErrStr(fmt.Errorf("failed to decode key: %v", err))
+			sendResponse(rsp)
+			break
+		}
+		s.mgr.Unload(key, func(err error) {
+			rsp := &rspUnload{msgHeader: header}
+			rsp.Type = msgTypeUnloadRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeUsage:
+		s.mgr.Usage(func(usage *StorageUsage, err error) {
+			rsp := &rspUsage{msgHeader: header}
+			rsp.Type = msgTypeUsageRsp
+			rsp.Usage = mustEncode(usage)
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeSetCanary:
+		m := &msgSetCanary{msgHeader: header}
+		s.mgr.SetCanary(m.ID, m.Canary, func(err error) {
+			rsp := &rspSetCanary{msgHeader: header}
+			rsp.Type = msgTypeSetCanaryRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeSetRevoked:
+		m := &msgSetRevoked{msgHeader: header}
+		s.mgr.SetRevoked(m.ID, m.Revoked, func(err error) {
+			rsp := &rspSetRevoked{msgHeader: header}
+			rsp.Type = msgTypeSetRevokedRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeGenerate:
+		m := &msgGenerate{msgHeader: header}
+		s.mgr.Generate(m.Name, m.KeyType, m.Bits, m.Passphrase, func(publicKey string, err error) {
+			rsp := &rspGenerate{msgHeader: header}
+			rsp.Type = msgTypeGenerateRsp
+			rsp.PublicKey = publicKey
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeVaultStatus:
+		s.mgr.VaultStatus(func(enabled, unlocked bool, err error) {
+			rsp := &rspVaultStatus{msgHeader: header}
+			rsp.Type = msgTypeVaultStatusRsp
+			rsp.Enabled = enabled
+			rsp.Unlocked = unlocked
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeUnlockVault:
+		m := &msgUnlockVault{msgHeader: header}
+		s.mgr.UnlockVault(m.Passphrase, func(err error) {
+			rsp := &rspUnlockVault{msgHeader: header}
+			rsp.Type = msgTypeUnlockVaultRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeSetMasterPassphrase:
+		m := &msgSetMasterPassphrase{msgHeader: header}
+		s.mgr.SetMasterPassphrase(m.Current, m.Passphrase, func(err error) {
+			rsp := &rspSetMasterPassphrase{msgHeader: header}
+			rsp.Type = msgTypeSetMasterPassphraseRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeIDScheme:
+		s.mgr.IDScheme(func(scheme IDScheme, err error) {
+			rsp := &rspIDScheme{msgHeader: header}
+			rsp.Type = msgTypeIDSchemeRsp
+			rsp.Scheme = scheme
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeSetIDScheme:
+		m := &msgSetIDScheme{msgHeader: header}
+		s.mgr.SetIDScheme(m.Scheme, func(migrated int, err error) {
+			rsp := &rspSetIDScheme{msgHeader: header}
+			rsp.Type = msgTypeSetIDSchemeRsp
+			rsp.Migrated = migrated
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeSetNotes:
+		m := &msgSetNotes{msgHeader: header}
+		s.mgr.SetNotes(m.ID, m.Notes, func(err error) {
+			rsp := &rspSetNotes{msgHeader: header}
+			rsp.Type = msgTypeSetNotesRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeSetProfile:
+		m := &msgSetProfile{msgHeader: header}
+		s.mgr.SetProfile(m.ID, m.Profile, func(err error) {
+			rsp := &rspSetProfile{msgHeader: header}
+			rsp.Type = msgTypeSetProfileRsp
 			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
 			sendResponse(rsp)
 		})
+	case msgTypeUnloadByFingerprint:
+		m := &msgUnloadByFingerprint{msgHeader: header}
+		s.mgr.UnloadByFingerprint(m.Fingerprint, func(err error) {
+			rsp := &rspUnloadByFingerprint{msgHeader: header}
+			rsp.Type = msgTypeUnloadByFingerprintRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeUnloadByName:
+		m := &msgUnloadByName{msgHeader: header}
+		s.mgr.UnloadByName(m.Name, func(err error) {
+			rsp := &rspUnloadByName{msgHeader: header}
+			rsp.Type = msgTypeUnloadByNameRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeInventory:
+		s.mgr.Inventory(func(items []*InventoryItem, err error) {
+			rsp := &rspInventory{msgHeader: header}
+			rsp.Type = msgTypeInventoryRsp
+			rsp.Items = mustEncode(items)
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeSelfCheck:
+		m := &msgSelfCheck{msgHeader: header}
+		s.mgr.SelfCheck(m.Sample, func(results []*CheckResult, err error) {
+			rsp := &rspSelfCheck{msgHeader: header}
+			rsp.Type = msgTypeSelfCheckRsp
+			rsp.Results = mustEncode(results)
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeUpgrade:
+		s.mgr.Upgrade(func(result *UpgradeResult, err error) {
+			rsp := &rspUpgrade{msgHeader: header}
+			rsp.Type = msgTypeUpgradeRsp
+			rsp.Result = mustEncode(result)
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypePublicKey:
+		m := &msgPublicKey{msgHeader: header}
+		s.mgr.PublicKey(m.ID, m.Format, func(encoded string, err error) {
+			rsp := &rspPublicKey{msgHeader: header}
+			rsp.Type = msgTypePublicKeyRsp
+			rsp.Encoded = encoded
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeSetLoadOnStartup:
+		m := &msgSetLoadOnStartup{msgHeader: header}
+		s.mgr.SetLoadOnStartup(m.ID, m.Enabled, func(err error) {
+			rsp := &rspSetLoadOnStartup{msgHeader: header}
+			rsp.Type = msgTypeSetLoadOnStartupRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeLoadOnStartup:
+		s.mgr.LoadOnStartup(func(loaded int, err error) {
+			rsp := &rspLoadOnStartup{msgHeader: header}
+			rsp.Type = msgTypeLoadOnStartupRsp
+			rsp.Loaded = loaded
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeLockVault:
+		s.mgr.LockVault(func(err error) {
+			rsp := &rspLockVault{msgHeader: header}
+			rsp.Type = msgTypeLockVaultRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeAutoLockPolicy:
+		s.mgr.AutoLockPolicy(func(policy *AutoLockPolicy, err error) {
+			rsp := &rspAutoLockPolicy{msgHeader: header}
+			rsp.Type = msgTypeAutoLockPolicyRsp
+			if policy != nil {
+				rsp.OnScreenLock = policy.OnScreenLock
+				rsp.Idle = int(policy.Idle / time.Second)
+				rsp.Action = policy.Action
+			}
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeSetAutoLockPolicy:
+		m := &msgSetAutoLockPolicy{msgHeader: header}
+		policy := &AutoLockPolicy{
+			OnScreenLock: m.OnScreenLock,
+			Idle:         time.Duration(m.Idle) * time.Second,
+			Action:       m.Action,
+		}
+		s.mgr.SetAutoLockPolicy(policy, func(err error) {
+			rsp := &rspSetAutoLockPolicy{msgHeader: header}
+			rsp.Type = msgTypeSetAutoLockPolicyRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	case msgTypeSetSchedule:
+		m := &msgSetSchedule{msgHeader: header}
+		s.mgr.SetSchedule(m.ID, m.Schedule, func(err error) {
+			rsp := &rspSetSchedule{msgHeader: header}
+			rsp.Type = msgTypeSetScheduleRsp
+			rsp.Err = makeErrStr(err)
+			rsp.ErrCode = help.CodeOf(err)
+			sendResponse(rsp)
+		})
+	default:
+		// Not intended for us; allow other listeners to respond.
+		return false
 	}
 	return true
 }
@@ -213,27 +894,78 @@ type MessageSender interface {
 	Error() error
 }
 
+const (
+	// defaultClientTimeout is how long a Client waits for a response
+	// from the Server by default.  It allows for the Server's own retries
+	// of operations on storage under DefaultRetryPolicy.
+	defaultClientTimeout = 30 * time.Second
+)
+
 // client implements the Manager interface and forwards calls to a Server.
 type client struct {
-	msg MessageSender
+	msg     MessageSender
+	timeout time.Duration
+}
+
+// ClientOption customizes the behavior of a Manager returned by NewClient.
+type ClientOption func(c *client)
+
+// WithTimeout specifies how long to wait for a response from the Server
+// before failing the call with ErrTimeout.  Zero waits indefinitely.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *client) {
+		c.timeout = timeout
+	}
 }
 
 // NewClient returns a Manager implementation that forwards calls to a Server.
-func NewClient(msg MessageSender) Manager {
-	return &client{msg: msg}
+// A call fails with ErrTimeout if the Server does not respond within 30
+// seconds, unless overridden using WithTimeout.
+func NewClient(msg MessageSender, opts ...ClientOption) Manager {
+	c := &client{
+		msg:     msg,
+		timeout: defaultClientTimeout,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// send sends msg to the Server, and invokes callback with the response.  If
+// no response is received within the timeout, callback is invoked with
+// ErrTimeout; a response received later is discarded.
+func (c *client) send(msg interface{}, callback func(rsp *js.Object, err error)) {
+	withTimeout(c.timeout, func(done func(v interface{}, err error)) {
+		c.msg.SendMessage(msg, func(rsp *js.Object) {
+			if err := c.msg.Error(); err != nil {
+				done(nil, fmt.Errorf("failed to send message: %v", err))
+				return
+			}
+			done(rsp, nil)
+		})
+	}, func(v interface{}, err error) {
+		rsp, _ := v.(*js.Object)
+		callback(rsp, err)
+	})
 }
 
 // Configured implements Manager.Configured.
 func (c *client) Configured(callback func(keys []*ConfiguredKey, err error)) {
 	msg := &msgConfigured{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
 	msg.Type = msgTypeConfigured
-	c.msg.SendMessage(msg, func(rspObj *js.Object) {
+	c.send(msg, func(rspObj *js.Object, err error) {
 		rsp := &rspConfigured{msgHeader: &msgHeader{Object: rspObj}}
-		if err := c.msg.Error(); err != nil {
-			callback(nil, fmt.Errorf("failed to send message: %v", err))
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		var keys []*ConfiguredKey
+		if err := codec.Decode(rsp.Keys, &keys); err != nil {
+			callback(nil, fmt.Errorf("failed to decode response: %v", err))
 			return
 		}
-		callback(rsp.Keys, makeErr(rsp.Err))
+		callback(keys, makeErr(rsp.Err, rsp.ErrCode))
 	})
 }
 
@@ -241,29 +973,48 @@ func (c *client) Configured(callback func(keys []*ConfiguredKey, err error)) {
 func (c *client) Loaded(callback func(keys []*LoadedKey, err error)) {
 	msg := &msgLoaded{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
 	msg.Type = msgTypeLoaded
-	c.msg.SendMessage(msg, func(rspObj *js.Object) {
+	c.send(msg, func(rspObj *js.Object, err error) {
 		rsp := &rspLoaded{msgHeader: &msgHeader{Object: rspObj}}
-		if err := c.msg.Error(); err != nil {
-			callback(nil, fmt.Errorf("failed to send message: %v", err))
+		if err != nil {
+			callback(nil, err)
 			return
 		}
-		callback(rsp.Keys, makeErr(rsp.Err))
+		var keys []*LoadedKey
+		if err := codec.Decode(rsp.Keys, &keys); err != nil {
+			callback(nil, fmt.Errorf("failed to decode response: %v", err))
+			return
+		}
+		callback(keys, makeErr(rsp.Err, rsp.ErrCode))
 	})
 }
 
 // Add implements Manager.Add.
-func (c *client) Add(name string, pemPrivateKey string, callback func(err error)) {
+func (c *client) Add(name string, pemPrivateKey string, opts *AddOptions, callback func(err error)) {
 	msg := &msgAdd{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
 	msg.Type = msgTypeAdd
 	msg.Name = name
 	msg.PEMPrivateKey = pemPrivateKey
-	c.msg.SendMessage(msg, func(rspObj *js.Object) {
+	if opts != nil {
+		msg.DeviceOnly = opts.DeviceOnly
+		msg.Provider = opts.Provider
+		msg.Source = opts.Source
+		msg.SourceDetail = opts.SourceDetail
+		msg.UniqueName = opts.UniqueName
+		msg.Attestation = opts.Attestation
+		msg.Protection = opts.Protection
+		msg.Certificate = opts.Certificate
+		msg.TOTPSecret = opts.TOTPSecret
+		msg.Notes = opts.Notes
+		msg.Passphrase = opts.Passphrase
+		msg.Profile = opts.Profile
+	}
+	c.send(msg, func(rspObj *js.Object, err error) {
 		rsp := &rspAdd{msgHeader: &msgHeader{Object: rspObj}}
-		if err := c.msg.Error(); err != nil {
-			callback(fmt.Errorf("failed to send message: %v", err))
+		if err != nil {
+			callback(err)
 			return
 		}
-		callback(makeErr(rsp.Err))
+		callback(makeErr(rsp.Err, rsp.ErrCode))
 	})
 }
 
@@ -272,29 +1023,69 @@ func (c *client) Remove(id ID, callback func(err error)) {
 	msg := &msgRemove{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
 	msg.Type = msgTypeRemove
 	msg.ID = id
-	c.msg.SendMessage(msg, func(rspObj *js.Object) {
+	c.send(msg, func(rspObj *js.Object, err error) {
 		rsp := &rspRemove{msgHeader: &msgHeader{Object: rspObj}}
-		if err := c.msg.Error(); err != nil {
-			callback(fmt.Errorf("failed to send message: %v", err))
+		if err != nil {
+			callback(err)
 			return
 		}
-		callback(makeErr(rsp.Err))
+		callback(makeErr(rsp.Err, rsp.ErrCode))
 	})
 }
 
 // Load implements Manager.Load.
 func (c *client) Load(id ID, passphrase string, callback func(err error)) {
+	c.LoadWithLifetime(id, passphrase, 0, callback)
+}
+
+// LoadWithLifetime implements Manager.LoadWithLifetime.
+func (c *client) LoadWithLifetime(id ID, passphrase string, lifetime time.Duration, callback func(err error)) {
 	msg := &msgLoad{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
 	msg.Type = msgTypeLoad
 	msg.ID = id
 	msg.Passphrase = passphrase
-	c.msg.SendMessage(msg, func(rspObj *js.Object) {
+	msg.Lifetime = int(lifetime / time.Second)
+	c.send(msg, func(rspObj *js.Object, err error) {
 		rsp := &rspLoad{msgHeader: &msgHeader{Object: rspObj}}
-		if err := c.msg.Error(); err != nil {
-			callback(fmt.Errorf("failed to send message: %v", err))
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// LoadEphemeral implements Manager.LoadEphemeral.
+func (c *client) LoadEphemeral(name string, pemPrivateKey string, callback func(err error)) {
+	msg := &msgLoadEphemeral{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeLoadEphemeral
+	msg.Name = name
+	msg.PEMPrivateKey = pemPrivateKey
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspLoadEphemeral{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// Export implements Manager.Export.
+func (c *client) Export(id ID, passphrase string, format keyformat.PrivateFormat, exportPassphrase string, callback func(encoded string, err error)) {
+	msg := &msgExport{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeExport
+	msg.ID = id
+	msg.Passphrase = passphrase
+	msg.Format = format
+	msg.ExportPassphrase = exportPassphrase
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspExport{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback("", err)
 			return
 		}
-		callback(makeErr(rsp.Err))
+		callback(rsp.Encoded, makeErr(rsp.Err, rsp.ErrCode))
 	})
 }
 
@@ -302,13 +1093,391 @@ func (c *client) Load(id ID, passphrase string, callback func(err error)) {
 func (c *client) Unload(key *LoadedKey, callback func(err error)) {
 	msg := &msgUnload{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
 	msg.Type = msgTypeUnload
-	msg.Key = key
-	c.msg.SendMessage(msg, func(rspObj *js.Object) {
+	msg.Key = mustEncode(key)
+	c.send(msg, func(rspObj *js.Object, err error) {
 		rsp := &rspUnload{msgHeader: &msgHeader{Object: rspObj}}
-		if err := c.msg.Error(); err != nil {
-			callback(fmt.Errorf("failed to send message: %v", err))
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// UnloadByFingerprint implements Manager.UnloadByFingerprint.
+func (c *client) UnloadByFingerprint(fp string, callback func(err error)) {
+	msg := &msgUnloadByFingerprint{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeUnloadByFingerprint
+	msg.Fingerprint = fp
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspUnloadByFingerprint{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// UnloadByName implements Manager.UnloadByName.
+func (c *client) UnloadByName(name string, callback func(err error)) {
+	msg := &msgUnloadByName{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeUnloadByName
+	msg.Name = name
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspUnloadByName{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// Usage implements Manager.Usage.
+func (c *client) Usage(callback func(usage *StorageUsage, err error)) {
+	msg := &msgUsage{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeUsage
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspUsage{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		var usage *StorageUsage
+		if err := codec.Decode(rsp.Usage, &usage); err != nil {
+			callback(nil, fmt.Errorf("failed to decode response: %v", err))
+			return
+		}
+		callback(usage, makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// SetCanary implements Manager.SetCanary.
+func (c *client) SetCanary(id ID, canary bool, callback func(err error)) {
+	msg := &msgSetCanary{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeSetCanary
+	msg.ID = id
+	msg.Canary = canary
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspSetCanary{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// SetRevoked implements Manager.SetRevoked.
+func (c *client) SetRevoked(id ID, revoked bool, callback func(err error)) {
+	msg := &msgSetRevoked{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeSetRevoked
+	msg.ID = id
+	msg.Revoked = revoked
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspSetRevoked{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// Generate implements Manager.Generate.
+func (c *client) Generate(name string, keyType string, bits int, passphrase string, callback func(publicKey string, err error)) {
+	msg := &msgGenerate{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeGenerate
+	msg.Name = name
+	msg.KeyType = keyType
+	msg.Bits = bits
+	msg.Passphrase = passphrase
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspGenerate{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback("", err)
+			return
+		}
+		callback(rsp.PublicKey, makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// VaultStatus implements Manager.VaultStatus.
+func (c *client) VaultStatus(callback func(enabled, unlocked bool, err error)) {
+	msg := &msgVaultStatus{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeVaultStatus
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspVaultStatus{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(false, false, err)
+			return
+		}
+		callback(rsp.Enabled, rsp.Unlocked, makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// UnlockVault implements Manager.UnlockVault.
+func (c *client) UnlockVault(passphrase string, callback func(err error)) {
+	msg := &msgUnlockVault{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeUnlockVault
+	msg.Passphrase = passphrase
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspUnlockVault{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// SetMasterPassphrase implements Manager.SetMasterPassphrase.
+func (c *client) SetMasterPassphrase(current, passphrase string, callback func(err error)) {
+	msg := &msgSetMasterPassphrase{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeSetMasterPassphrase
+	msg.Current = current
+	msg.Passphrase = passphrase
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspSetMasterPassphrase{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// IDScheme implements Manager.IDScheme.
+func (c *client) IDScheme(callback func(scheme IDScheme, err error)) {
+	msg := &msgIDScheme{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeIDScheme
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspIDScheme{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(DefaultIDScheme, err)
+			return
+		}
+		callback(rsp.Scheme, makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// SetIDScheme implements Manager.SetIDScheme.
+func (c *client) SetIDScheme(scheme IDScheme, callback func(migrated int, err error)) {
+	msg := &msgSetIDScheme{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeSetIDScheme
+	msg.Scheme = scheme
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspSetIDScheme{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(0, err)
+			return
+		}
+		callback(rsp.Migrated, makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// SetNotes implements Manager.SetNotes.
+func (c *client) SetNotes(id ID, notes string, callback func(err error)) {
+	msg := &msgSetNotes{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeSetNotes
+	msg.ID = id
+	msg.Notes = notes
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspSetNotes{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// SetProfile implements Manager.SetProfile.
+func (c *client) SetProfile(id ID, profile string, callback func(err error)) {
+	msg := &msgSetProfile{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeSetProfile
+	msg.ID = id
+	msg.Profile = profile
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspSetProfile{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// Inventory implements Manager.Inventory.
+func (c *client) Inventory(callback func(items []*InventoryItem, err error)) {
+	msg := &msgInventory{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeInventory
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspInventory{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		var items []*InventoryItem
+		if err := codec.Decode(rsp.Items, &items); err != nil {
+			callback(nil, fmt.Errorf("failed to decode response: %v", err))
+			return
+		}
+		callback(items, makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// SelfCheck implements Manager.SelfCheck.
+func (c *client) SelfCheck(sample int, callback func(results []*CheckResult, err error)) {
+	msg := &msgSelfCheck{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeSelfCheck
+	msg.Sample = sample
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspSelfCheck{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		var results []*CheckResult
+		if err := codec.Decode(rsp.Results, &results); err != nil {
+			callback(nil, fmt.Errorf("failed to decode response: %v", err))
+			return
+		}
+		callback(results, makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// Upgrade implements Manager.Upgrade.
+func (c *client) Upgrade(callback func(result *UpgradeResult, err error)) {
+	msg := &msgUpgrade{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeUpgrade
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspUpgrade{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		var result *UpgradeResult
+		if err := codec.Decode(rsp.Result, &result); err != nil {
+			callback(nil, fmt.Errorf("failed to decode response: %v", err))
+			return
+		}
+		callback(result, makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// PublicKey implements Manager.PublicKey.
+func (c *client) PublicKey(id ID, format keyformat.PublicFormat, callback func(encoded string, err error)) {
+	msg := &msgPublicKey{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypePublicKey
+	msg.ID = id
+	msg.Format = format
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspPublicKey{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback("", err)
+			return
+		}
+		callback(rsp.Encoded, makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// SetLoadOnStartup implements Manager.SetLoadOnStartup.
+func (c *client) SetLoadOnStartup(id ID, enabled bool, callback func(err error)) {
+	msg := &msgSetLoadOnStartup{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeSetLoadOnStartup
+	msg.ID = id
+	msg.Enabled = enabled
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspSetLoadOnStartup{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// LoadOnStartup implements Manager.LoadOnStartup.
+func (c *client) LoadOnStartup(callback func(loaded int, err error)) {
+	msg := &msgLoadOnStartup{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeLoadOnStartup
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspLoadOnStartup{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(0, err)
+			return
+		}
+		callback(rsp.Loaded, makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// LockVault implements Manager.LockVault.
+func (c *client) LockVault(callback func(err error)) {
+	msg := &msgLockVault{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeLockVault
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspLockVault{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// AutoLockPolicy implements Manager.AutoLockPolicy.
+func (c *client) AutoLockPolicy(callback func(policy *AutoLockPolicy, err error)) {
+	msg := &msgAutoLockPolicy{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeAutoLockPolicy
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspAutoLockPolicy{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		if err := makeErr(rsp.Err, rsp.ErrCode); err != nil {
+			callback(nil, err)
+			return
+		}
+		callback(&AutoLockPolicy{
+			OnScreenLock: rsp.OnScreenLock,
+			Idle:         time.Duration(rsp.Idle) * time.Second,
+			Action:       rsp.Action,
+		}, nil)
+	})
+}
+
+// SetAutoLockPolicy implements Manager.SetAutoLockPolicy.
+func (c *client) SetAutoLockPolicy(policy *AutoLockPolicy, callback func(err error)) {
+	msg := &msgSetAutoLockPolicy{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeSetAutoLockPolicy
+	msg.OnScreenLock = policy.OnScreenLock
+	msg.Idle = int(policy.Idle / time.Second)
+	msg.Action = policy.Action
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspSetAutoLockPolicy{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
+			return
+		}
+		callback(makeErr(rsp.Err, rsp.ErrCode))
+	})
+}
+
+// SetSchedule implements Manager.SetSchedule.
+func (c *client) SetSchedule(id ID, schedule string, callback func(err error)) {
+	msg := &msgSetSchedule{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeSetSchedule
+	msg.ID = id
+	msg.Schedule = schedule
+	c.send(msg, func(rspObj *js.Object, err error) {
+		rsp := &rspSetSchedule{msgHeader: &msgHeader{Object: rspObj}}
+		if err != nil {
+			callback(err)
 			return
 		}
-		callback(makeErr(rsp.Err))
+		callback(makeErr(rsp.Err, rsp.ErrCode))
 	})
 }
@@ -16,32 +16,64 @@ package keys
 
 import (
 	"errors"
-	"reflect"
 	"testing"
+	"time"
 
 	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
-	"github.com/gopherjs/gopherjs/js"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
 	"github.com/kr/pretty"
 )
 
 type dummyManager struct {
-	ID             ID
-	Name           string
-	PEMPrivateKey  string
-	Passphrase     string
-	ConfiguredKeys []*ConfiguredKey
-	LoadedKeys     []*LoadedKey
-	Key            *LoadedKey
-	Err            error
+	ID               ID
+	Name             string
+	PEMPrivateKey    string
+	AddOptions       *AddOptions
+	Passphrase       string
+	Format           keyformat.PrivateFormat
+	PublicFormat     keyformat.PublicFormat
+	ExportPassphrase string
+	Encoded          string
+	ConfiguredKeys   []*ConfiguredKey
+	LoadedKeys       []*LoadedKey
+	Key              *LoadedKey
+	StorageUsage     *StorageUsage
+	Canary           bool
+	Revoked          bool
+	Scheme           IDScheme
+	Migrated         int
+	Notes            string
+	Profile          string
+	Fingerprint      string
+	InventoryItems   []*InventoryItem
+	KeyType          string
+	Bits             int
+	GeneratedKey     string
+	VaultEnabled     bool
+	VaultUnlocked    bool
+	MasterPassphrase string
+	Sample           int
+	CheckResults     []*CheckResult
+	UpgradeResult    *UpgradeResult
+	StartupEnabled   bool
+	LoadedCount      int
+	Lifetime         time.Duration
+	VaultLocked      bool
+	Policy           *AutoLockPolicy
+	Schedule         string
+	Err              error
 }
 
 func (m *dummyManager) Configured(callback func(keys []*ConfiguredKey, err error)) {
 	callback(m.ConfiguredKeys, m.Err)
 }
 
-func (m *dummyManager) Add(name string, pemPrivateKey string, callback func(err error)) {
+func (m *dummyManager) Add(name string, pemPrivateKey string, opts *AddOptions, callback func(err error)) {
 	m.Name = name
 	m.PEMPrivateKey = pemPrivateKey
+	m.AddOptions = opts
 	callback(m.Err)
 }
 
@@ -60,21 +92,161 @@ func (m *dummyManager) Load(id ID, passphrase string, callback func(err error))
 	callback(m.Err)
 }
 
+func (m *dummyManager) LoadWithLifetime(id ID, passphrase string, lifetime time.Duration, callback func(err error)) {
+	m.ID = id
+	m.Passphrase = passphrase
+	m.Lifetime = lifetime
+	callback(m.Err)
+}
+
+func (m *dummyManager) LoadEphemeral(name string, pemPrivateKey string, callback func(err error)) {
+	m.Name = name
+	m.PEMPrivateKey = pemPrivateKey
+	callback(m.Err)
+}
+
+func (m *dummyManager) Export(id ID, passphrase string, format keyformat.PrivateFormat, exportPassphrase string, callback func(encoded string, err error)) {
+	m.ID = id
+	m.Passphrase = passphrase
+	m.Format = format
+	m.ExportPassphrase = exportPassphrase
+	callback(m.Encoded, m.Err)
+}
+
 func (m *dummyManager) Unload(key *LoadedKey, callback func(err error)) {
 	m.Key = key
 	callback(m.Err)
 }
 
+func (m *dummyManager) Inventory(callback func(items []*InventoryItem, err error)) {
+	callback(m.InventoryItems, m.Err)
+}
+
+func (m *dummyManager) UnloadByFingerprint(fp string, callback func(err error)) {
+	m.Fingerprint = fp
+	callback(m.Err)
+}
+
+func (m *dummyManager) UnloadByName(name string, callback func(err error)) {
+	m.Name = name
+	callback(m.Err)
+}
+
+func (m *dummyManager) Usage(callback func(usage *StorageUsage, err error)) {
+	callback(m.StorageUsage, m.Err)
+}
+
+func (m *dummyManager) SetCanary(id ID, canary bool, callback func(err error)) {
+	m.ID = id
+	m.Canary = canary
+	callback(m.Err)
+}
+
+func (m *dummyManager) SetLoadOnStartup(id ID, enabled bool, callback func(err error)) {
+	m.ID = id
+	m.StartupEnabled = enabled
+	callback(m.Err)
+}
+
+func (m *dummyManager) LoadOnStartup(callback func(loaded int, err error)) {
+	callback(m.LoadedCount, m.Err)
+}
+
+func (m *dummyManager) SetRevoked(id ID, revoked bool, callback func(err error)) {
+	m.ID = id
+	m.Revoked = revoked
+	callback(m.Err)
+}
+
+func (m *dummyManager) Generate(name string, keyType string, bits int, passphrase string, callback func(publicKey string, err error)) {
+	m.Name = name
+	m.KeyType = keyType
+	m.Bits = bits
+	m.Passphrase = passphrase
+	callback(m.GeneratedKey, m.Err)
+}
+
+func (m *dummyManager) VaultStatus(callback func(enabled, unlocked bool, err error)) {
+	callback(m.VaultEnabled, m.VaultUnlocked, m.Err)
+}
+
+func (m *dummyManager) UnlockVault(passphrase string, callback func(err error)) {
+	m.MasterPassphrase = passphrase
+	callback(m.Err)
+}
+
+func (m *dummyManager) SetMasterPassphrase(current, passphrase string, callback func(err error)) {
+	m.Passphrase = current
+	m.MasterPassphrase = passphrase
+	callback(m.Err)
+}
+
+func (m *dummyManager) IDScheme(callback func(scheme IDScheme, err error)) {
+	callback(m.Scheme, m.Err)
+}
+
+func (m *dummyManager) SetIDScheme(scheme IDScheme, callback func(migrated int, err error)) {
+	m.Scheme = scheme
+	callback(m.Migrated, m.Err)
+}
+
+func (m *dummyManager) SetNotes(id ID, notes string, callback func(err error)) {
+	m.ID = id
+	m.Notes = notes
+	callback(m.Err)
+}
+
+func (m *dummyManager) SetProfile(id ID, profile string, callback func(err error)) {
+	m.ID = id
+	m.Profile = profile
+	callback(m.Err)
+}
+
+func (m *dummyManager) SelfCheck(sample int, callback func(results []*CheckResult, err error)) {
+	m.Sample = sample
+	callback(m.CheckResults, m.Err)
+}
+
+func (m *dummyManager) Upgrade(callback func(result *UpgradeResult, err error)) {
+	callback(m.UpgradeResult, m.Err)
+}
+
+func (m *dummyManager) PublicKey(id ID, format keyformat.PublicFormat, callback func(encoded string, err error)) {
+	m.ID = id
+	m.PublicFormat = format
+	callback(m.Encoded, m.Err)
+}
+
+func (m *dummyManager) LockVault(callback func(err error)) {
+	m.VaultLocked = true
+	callback(m.Err)
+}
+
+func (m *dummyManager) AutoLockPolicy(callback func(policy *AutoLockPolicy, err error)) {
+	callback(m.Policy, m.Err)
+}
+
+func (m *dummyManager) SetAutoLockPolicy(policy *AutoLockPolicy, callback func(err error)) {
+	m.Policy = policy
+	callback(m.Err)
+}
+
+func (m *dummyManager) SetSchedule(id ID, schedule string, callback func(err error)) {
+	m.ID = id
+	m.Schedule = schedule
+	callback(m.Err)
+}
+
 func TestClientServerConfigured(t *testing.T) {
 	hub := fakes.NewMessageHub()
 	mgr := &dummyManager{}
 	cli := NewClient(hub)
 	NewServer(mgr, hub)
 
-	k0 := &ConfiguredKey{Object: js.Global.Get("Object").New()}
+	k0 := &ConfiguredKey{}
 	k0.ID = ID("id-0")
 	k0.Name = "key-0"
-	k1 := &ConfiguredKey{Object: js.Global.Get("Object").New()}
+	k1 := &ConfiguredKey{}
 	k1.ID = ID("id-1")
 	k1.Name = "key-1"
 
@@ -85,10 +257,8 @@ func TestClientServerConfigured(t *testing.T) {
 	mgr.Err = wantErr
 
 	configured, err := syncConfigured(cli)
-	// Compare using reflect.DeepEqual since pretty.Diff fails to
-	// terminate on this input.
-	if !reflect.DeepEqual(configured, wantConfiguredKeys) {
-		t.Errorf("incorrect configured keys; got %v, want %v", configured, wantConfiguredKeys)
+	if diff := pretty.Diff(configured, wantConfiguredKeys); diff != nil {
+		t.Errorf("incorrect configured keys; -got +want: %s", diff)
 	}
 	if diff := pretty.Diff(err, wantErr); diff != nil {
 		t.Errorf("incorrect error; -got +want: %s", diff)
@@ -103,17 +273,32 @@ func TestClientServerAdd(t *testing.T) {
 
 	wantName := "some-name"
 	wantPrivateKey := "private-key"
+	wantOptions := &AddOptions{
+		DeviceOnly:   true,
+		Provider:     "some-provider",
+		Source:       SourceFile,
+		SourceDetail: "some-file",
+		UniqueName:   true,
+		Protection:   ProtectionPlaintext,
+		Certificate:  "some-certificate",
+		Notes:        "some-notes",
+		Passphrase:   "some-passphrase",
+		Profile:      "work",
+	}
 	wantErr := errors.New("failed")
 
 	mgr.Err = wantErr
 
-	err := syncAdd(cli, wantName, wantPrivateKey)
+	err := syncAdd(cli, wantName, wantPrivateKey, wantOptions)
 	if diff := pretty.Diff(mgr.Name, wantName); diff != nil {
 		t.Errorf("incorrect name; -got +want: %s", diff)
 	}
 	if diff := pretty.Diff(mgr.PEMPrivateKey, wantPrivateKey); diff != nil {
 		t.Errorf("incorrect private key; -got +want: %s", diff)
 	}
+	if diff := pretty.Diff(mgr.AddOptions, wantOptions); diff != nil {
+		t.Errorf("incorrect options; -got +want: %s", diff)
+	}
 	if diff := pretty.Diff(err, wantErr); diff != nil {
 		t.Errorf("incorrect error; -got +want: %s", diff)
 	}
@@ -139,57 +324,742 @@ func TestClientServerRemove(t *testing.T) {
 	}
 }
 
-func TestClientServerLoaded(t *testing.T) {
+func TestClientServerSetCanary(t *testing.T) {
 	hub := fakes.NewMessageHub()
 	mgr := &dummyManager{}
 	cli := NewClient(hub)
 	NewServer(mgr, hub)
 
-	k0 := &LoadedKey{Object: js.Global.Get("Object").New()}
-	k0.Type = "type-0"
-	k0.SetBlob([]byte("blob-0"))
-	k0.Comment = "comment-0"
-	k1 := &LoadedKey{Object: js.Global.Get("Object").New()}
-	k1.Type = "type-1"
-	k1.SetBlob([]byte("blob-1"))
-	k1.Comment = "comment-1"
-
-	wantLoadedKeys := []*LoadedKey{k0, k1}
+	wantID := ID("id-0")
 	wantErr := errors.New("failed")
 
-	mgr.LoadedKeys = append(mgr.LoadedKeys, wantLoadedKeys...)
 	mgr.Err = wantErr
 
-	loaded, err := syncLoaded(cli)
-	// Compare using reflect.DeepEqual since pretty.Diff fails to
-	// terminate on this input.
-	if !reflect.DeepEqual(loaded, wantLoadedKeys) {
-		t.Errorf("incorrect loaded keys; got %s, want %s", loaded, wantLoadedKeys)
+	err := syncSetCanary(cli, wantID, true)
+	if diff := pretty.Diff(mgr.ID, wantID); diff != nil {
+		t.Errorf("incorrect ID; -got +want: %s", diff)
+	}
+	if !mgr.Canary {
+		t.Errorf("incorrect canary; got false, want true")
 	}
 	if diff := pretty.Diff(err, wantErr); diff != nil {
 		t.Errorf("incorrect error; -got +want: %s", diff)
 	}
 }
 
-func TestClientServerLoad(t *testing.T) {
+func TestClientServerSetRevoked(t *testing.T) {
 	hub := fakes.NewMessageHub()
 	mgr := &dummyManager{}
 	cli := NewClient(hub)
 	NewServer(mgr, hub)
 
 	wantID := ID("id-0")
-	wantPassphrase := "secret"
 	wantErr := errors.New("failed")
 
 	mgr.Err = wantErr
 
-	err := syncLoad(cli, wantID, wantPassphrase)
+	err := syncSetRevoked(cli, wantID, true)
 	if diff := pretty.Diff(mgr.ID, wantID); diff != nil {
 		t.Errorf("incorrect ID; -got +want: %s", diff)
 	}
-	if diff := pretty.Diff(mgr.Passphrase, wantPassphrase); diff != nil {
+	if !mgr.Revoked {
+		t.Errorf("incorrect revoked; got false, want true")
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerGenerate(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantPublicKey := "ssh-ed25519 AAAA new-key"
+	wantErr := errors.New("failed")
+
+	mgr.GeneratedKey = wantPublicKey
+	mgr.Err = wantErr
+
+	publicKey, err := syncGenerate(cli, "new-key", "rsa", 4096, "secret")
+	if diff := pretty.Diff(mgr.Name, "new-key"); diff != nil {
+		t.Errorf("incorrect name; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.KeyType, "rsa"); diff != nil {
+		t.Errorf("incorrect key type; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.Bits, 4096); diff != nil {
+		t.Errorf("incorrect bits; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.Passphrase, "secret"); diff != nil {
 		t.Errorf("incorrect passphrase; -got +want: %s", diff)
 	}
+	if diff := pretty.Diff(publicKey, wantPublicKey); diff != nil {
+		t.Errorf("incorrect public key; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerVault(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantErr := help.Errorf(help.VaultLocked, "locked")
+
+	mgr.VaultEnabled = true
+	mgr.VaultUnlocked = true
+	enabled, unlocked, err := syncVaultStatus(cli)
+	if err != nil {
+		t.Errorf("failed to read vault status: %v", err)
+	}
+	if diff := pretty.Diff([]bool{enabled, unlocked}, []bool{true, true}); diff != nil {
+		t.Errorf("incorrect vault status; -got +want: %s", diff)
+	}
+
+	mgr.Err = wantErr
+	err = syncUnlockVault(cli, "master")
+	if diff := pretty.Diff(mgr.MasterPassphrase, "master"); diff != nil {
+		t.Errorf("incorrect master passphrase; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(help.CodeOf(err), help.VaultLocked); diff != nil {
+		t.Errorf("incorrect error code; -got +want: %s", diff)
+	}
+
+	mgr.Err = nil
+	if err := syncSetMasterPassphrase(cli, "old", "new"); err != nil {
+		t.Errorf("failed to set master passphrase: %v", err)
+	}
+	if diff := pretty.Diff([]string{mgr.Passphrase, mgr.MasterPassphrase}, []string{"old", "new"}); diff != nil {
+		t.Errorf("incorrect passphrases; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerSetNotes(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantID := ID("id-0")
+	wantNotes := "Deploys to **prod**"
+	wantErr := errors.New("failed")
+
+	mgr.Err = wantErr
+
+	err := syncSetNotes(cli, wantID, wantNotes)
+	if diff := pretty.Diff(mgr.ID, wantID); diff != nil {
+		t.Errorf("incorrect ID; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.Notes, wantNotes); diff != nil {
+		t.Errorf("incorrect notes; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerSetProfile(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantID := ID("id-0")
+	wantProfile := "work"
+	wantErr := errors.New("failed")
+
+	mgr.Err = wantErr
+
+	err := syncSetProfile(cli, wantID, wantProfile)
+	if diff := pretty.Diff(mgr.ID, wantID); diff != nil {
+		t.Errorf("incorrect ID; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.Profile, wantProfile); diff != nil {
+		t.Errorf("incorrect profile; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerUnloadByFingerprint(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantFingerprint := "SHA256:KKE7aAXbGqQMhd2F+5zxUTL3ITL6hHZ8JTrm2J97Arc"
+	wantErr := errors.New("failed")
+
+	mgr.Err = wantErr
+
+	err := syncUnloadByFingerprint(cli, wantFingerprint)
+	if diff := pretty.Diff(mgr.Fingerprint, wantFingerprint); diff != nil {
+		t.Errorf("incorrect fingerprint; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerUnloadByName(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantName := "some-name"
+	wantErr := errors.New("failed")
+
+	mgr.Err = wantErr
+
+	err := syncUnloadByName(cli, wantName)
+	if diff := pretty.Diff(mgr.Name, wantName); diff != nil {
+		t.Errorf("incorrect name; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerInventory(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantItems := []*InventoryItem{
+		{
+			ID:          ID("id-0"),
+			Name:        "key-0",
+			Type:        "ssh-ed25519",
+			Bits:        256,
+			Fingerprint: "SHA256:abc",
+			Created:     1000,
+			Loaded:      true,
+			Protection:  ProtectionPassphrase,
+		},
+	}
+	wantErr := errors.New("failed")
+
+	mgr.InventoryItems = wantItems
+	mgr.Err = wantErr
+
+	items, err := syncInventory(cli)
+	if diff := pretty.Diff(items, wantItems); diff != nil {
+		t.Errorf("incorrect items; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerSelfCheck(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantResults := []*CheckResult{
+		{Name: SchemaCheck, Health: Healthy, Detail: "schema version 1"},
+		{Name: FingerprintCheck, Health: Failing, Detail: "mismatch"},
+	}
+	wantErr := errors.New("failed")
+
+	mgr.CheckResults = wantResults
+	mgr.Err = wantErr
+
+	results, err := syncSelfCheck(cli, 3)
+	if mgr.Sample != 3 {
+		t.Errorf("incorrect sample; got %d, want 3", mgr.Sample)
+	}
+	if diff := pretty.Diff(results, wantResults); diff != nil {
+		t.Errorf("incorrect results; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerUpgrade(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantResult := &UpgradeResult{
+		Time:   1500000000000,
+		Keys:   []ID{ID("1"), ID("2")},
+		Loaded: []ID{ID("2")},
+	}
+	wantErr := errors.New("failed")
+
+	mgr.UpgradeResult = wantResult
+	mgr.Err = wantErr
+
+	result, err := syncUpgrade(cli)
+	if diff := pretty.Diff(result, wantResult); diff != nil {
+		t.Errorf("incorrect result; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerIDScheme(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantErr := errors.New("failed")
+
+	mgr.Scheme = IDFingerprint
+	mgr.Err = wantErr
+	scheme, err := syncIDScheme(cli)
+	if diff := pretty.Diff(scheme, IDFingerprint); diff != nil {
+		t.Errorf("incorrect scheme; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerSetIDScheme(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantErr := errors.New("failed")
+
+	mgr.Migrated = 3
+	mgr.Err = wantErr
+	migrated, err := syncSetIDScheme(cli, IDFingerprint)
+	if diff := pretty.Diff(mgr.Scheme, IDFingerprint); diff != nil {
+		t.Errorf("incorrect scheme; -got +want: %s", diff)
+	}
+	if migrated != 3 {
+		t.Errorf("incorrect number of keys migrated; got %d, want 3", migrated)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerSetLoadOnStartup(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantID := ID("id-0")
+	wantErr := errors.New("failed")
+
+	mgr.Err = wantErr
+
+	err := syncSetLoadOnStartup(cli, wantID, true)
+	if diff := pretty.Diff(mgr.ID, wantID); diff != nil {
+		t.Errorf("incorrect ID; -got +want: %s", diff)
+	}
+	if !mgr.StartupEnabled {
+		t.Errorf("incorrect setting; got false, want true")
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerLoadOnStartup(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantErr := errors.New("failed")
+
+	mgr.LoadedCount = 2
+	mgr.Err = wantErr
+	loaded, err := syncLoadOnStartup(cli)
+	if loaded != 2 {
+		t.Errorf("incorrect number of keys loaded; got %d, want 2", loaded)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerLockVault(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantErr := errors.New("failed")
+
+	mgr.Err = wantErr
+	err := syncLockVault(cli)
+	if !mgr.VaultLocked {
+		t.Errorf("vault not locked")
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerAutoLockPolicy(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantPolicy := &AutoLockPolicy{OnScreenLock: true, Idle: 15 * time.Minute, Action: AutoLockVault}
+
+	mgr.Policy = wantPolicy
+	policy, err := syncAutoLockPolicy(cli)
+	if diff := pretty.Diff(policy, wantPolicy); diff != nil {
+		t.Errorf("incorrect policy; -got +want: %s", diff)
+	}
+	if err != nil {
+		t.Errorf("failed to get policy: %v", err)
+	}
+
+	wantErr := errors.New("failed")
+	mgr.Err = wantErr
+	_, err = syncAutoLockPolicy(cli)
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerSetAutoLockPolicy(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantPolicy := &AutoLockPolicy{Idle: 5 * time.Minute, Action: AutoLockUnload}
+	wantErr := errors.New("failed")
+
+	mgr.Err = wantErr
+	err := syncSetAutoLockPolicy(cli, wantPolicy)
+	if diff := pretty.Diff(mgr.Policy, wantPolicy); diff != nil {
+		t.Errorf("incorrect policy; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerSetSchedule(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantID := ID("id-0")
+	wantSchedule := "Mon-Fri 08:45-18:00"
+	wantErr := errors.New("failed")
+
+	mgr.Err = wantErr
+	err := syncSetSchedule(cli, wantID, wantSchedule)
+	if diff := pretty.Diff(mgr.ID, wantID); diff != nil {
+		t.Errorf("incorrect ID; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.Schedule, wantSchedule); diff != nil {
+		t.Errorf("incorrect schedule; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerLoaded(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	k0 := &LoadedKey{}
+	k0.Type = "type-0"
+	k0.Blob = []byte("blob-0")
+	k0.Comment = "comment-0"
+	k1 := &LoadedKey{}
+	k1.Type = "type-1"
+	k1.Blob = []byte("blob-1")
+	k1.Comment = "comment-1"
+
+	wantLoadedKeys := []*LoadedKey{k0, k1}
+	wantErr := errors.New("failed")
+
+	mgr.LoadedKeys = append(mgr.LoadedKeys, wantLoadedKeys...)
+	mgr.Err = wantErr
+
+	loaded, err := syncLoaded(cli)
+	if diff := pretty.Diff(loaded, wantLoadedKeys); diff != nil {
+		t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerLoad(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantID := ID("id-0")
+	wantPassphrase := "secret"
+	wantErr := errors.New("failed")
+
+	mgr.Err = wantErr
+
+	err := syncLoad(cli, wantID, wantPassphrase)
+	if diff := pretty.Diff(mgr.ID, wantID); diff != nil {
+		t.Errorf("incorrect ID; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.Passphrase, wantPassphrase); diff != nil {
+		t.Errorf("incorrect passphrase; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerLoadWithLifetime(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantID := ID("id-0")
+	wantPassphrase := "secret"
+	wantLifetime := 15 * time.Minute
+	wantErr := errors.New("failed")
+
+	mgr.Err = wantErr
+
+	err := syncLoadWithLifetime(cli, wantID, wantPassphrase, wantLifetime)
+	if diff := pretty.Diff(mgr.ID, wantID); diff != nil {
+		t.Errorf("incorrect ID; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.Passphrase, wantPassphrase); diff != nil {
+		t.Errorf("incorrect passphrase; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.Lifetime, wantLifetime); diff != nil {
+		t.Errorf("incorrect lifetime; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerLoadEphemeral(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantName := "some-name"
+	wantPrivateKey := "private-key"
+	wantErr := errors.New("failed")
+
+	mgr.Err = wantErr
+
+	err := syncLoadEphemeral(cli, wantName, wantPrivateKey)
+	if diff := pretty.Diff(mgr.Name, wantName); diff != nil {
+		t.Errorf("incorrect name; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.PEMPrivateKey, wantPrivateKey); diff != nil {
+		t.Errorf("incorrect private key; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerErrorCode(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	mgr.Err = help.Errorf(help.IncorrectPassphrase, "bad passphrase")
+
+	err := syncLoad(cli, ID("id-0"), "secret")
+	if diff := pretty.Diff(help.CodeOf(err), help.IncorrectPassphrase); diff != nil {
+		t.Errorf("incorrect help code; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err.Error(), "bad passphrase"); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerRedactsErrors(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	mgr.Err = help.Errorf(help.InvalidPrivateKey, "failed to parse %q", testdata.ValidPrivateKey)
+
+	err := syncLoad(cli, ID("id-0"), "secret")
+	if diff := pretty.Diff(help.CodeOf(err), help.InvalidPrivateKey); diff != nil {
+		t.Errorf("incorrect help code; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err.Error(), `failed to parse "[redacted]"`); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerRejectsOtherSenders(t *testing.T) {
+	testcases := []struct {
+		description string
+		senderURL   string
+		senderTab   int
+		wantErr     error
+	}{
+		{
+			description: "extension page",
+			senderURL:   fakes.ExtensionURL + "html/options.html",
+		},
+		{
+			description: "extension page in a tab",
+			senderURL:   fakes.ExtensionURL + "html/prompt.html",
+			senderTab:   1,
+		},
+		{
+			description: "content script in a tab",
+			senderURL:   "https://example.com/",
+			senderTab:   1,
+			wantErr:     ErrTimeout,
+		},
+		{
+			description: "other extension",
+			senderURL:   "chrome-extension://other-extension-id/html/options.html",
+			wantErr:     ErrTimeout,
+		},
+		{
+			description: "unknown sender",
+			wantErr:     ErrTimeout,
+		},
+	}
+
+	for _, tc := range testcases {
+		hub := fakes.NewMessageHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub, WithTimeout(10*time.Millisecond))
+		NewServer(mgr, hub)
+		hub.SetSenderURL(tc.senderURL)
+		hub.SetSenderTab(tc.senderTab)
+
+		err := syncRemove(cli, ID("id-0"))
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		wantID := ID("id-0")
+		if tc.wantErr != nil {
+			wantID = InvalidID
+		}
+		if diff := pretty.Diff(mgr.ID, wantID); diff != nil {
+			t.Errorf("%s: incorrect removed key; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestClientServerUsage(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	k := &KeyUsage{}
+	k.ID = ID("id-0")
+	k.Bytes = 42
+	u := &StorageUsage{}
+	u.Keys = []*KeyUsage{k}
+	u.SyncBytesInUse = 42
+	u.SyncQuota = 100
+
+	mgr.StorageUsage = u
+
+	usage, err := syncUsage(cli)
+	if err != nil {
+		t.Errorf("failed to get usage: %v", err)
+	}
+	if diff := pretty.Diff(usage, u); diff != nil {
+		t.Errorf("incorrect usage; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerExport(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantID := ID("some-id")
+	wantPassphrase := "secret"
+	wantFormat := keyformat.PrivatePPK
+	wantExportPassphrase := "export-secret"
+	wantEncoded := "encoded-key"
+	wantErr := errors.New("failed")
+
+	mgr.Encoded = wantEncoded
+	mgr.Err = wantErr
+
+	encoded, err := syncExport(cli, wantID, wantPassphrase, wantFormat, wantExportPassphrase)
+	if diff := pretty.Diff(mgr.ID, wantID); diff != nil {
+		t.Errorf("incorrect ID; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.Passphrase, wantPassphrase); diff != nil {
+		t.Errorf("incorrect passphrase; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.Format, wantFormat); diff != nil {
+		t.Errorf("incorrect format; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.ExportPassphrase, wantExportPassphrase); diff != nil {
+		t.Errorf("incorrect export passphrase; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(encoded, wantEncoded); diff != nil {
+		t.Errorf("incorrect encoded key; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(err, wantErr); diff != nil {
+		t.Errorf("incorrect error; -got +want: %s", diff)
+	}
+}
+
+func TestClientServerPublicKey(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	mgr := &dummyManager{}
+	cli := NewClient(hub)
+	NewServer(mgr, hub)
+
+	wantID := ID("some-id")
+	wantFormat := keyformat.PublicRFC4716
+	wantEncoded := "encoded-key"
+	wantErr := errors.New("failed")
+
+	mgr.Encoded = wantEncoded
+	mgr.Err = wantErr
+
+	encoded, err := syncPublicKey(cli, wantID, wantFormat)
+	if diff := pretty.Diff(mgr.ID, wantID); diff != nil {
+		t.Errorf("incorrect ID; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(mgr.PublicFormat, wantFormat); diff != nil {
+		t.Errorf("incorrect format; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(encoded, wantEncoded); diff != nil {
+		t.Errorf("incorrect encoded key; -got +want: %s", diff)
+	}
 	if diff := pretty.Diff(err, wantErr); diff != nil {
 		t.Errorf("incorrect error; -got +want: %s", diff)
 	}
@@ -201,19 +1071,17 @@ func TestClientServerUnload(t *testing.T) {
 	cli := NewClient(hub)
 	NewServer(mgr, hub)
 
-	wantKey := &LoadedKey{Object: js.Global.Get("Object").New()}
+	wantKey := &LoadedKey{}
 	wantKey.Type = "type-0"
-	wantKey.SetBlob([]byte("blob-0"))
+	wantKey.Blob = []byte("blob-0")
 	wantKey.Comment = "comment1"
 	wantErr := errors.New("failed")
 
 	mgr.Err = wantErr
 
 	err := syncUnload(cli, wantKey)
-	// Compare using reflect.DeepEqual since pretty.Diff causes test to fail
-	// without any output.
-	if !reflect.DeepEqual(mgr.Key, wantKey) {
-		t.Errorf("incorrect key; got %s, want %s", mgr.Key, wantKey)
+	if diff := pretty.Diff(mgr.Key, wantKey); diff != nil {
+		t.Errorf("incorrect key; -got +want: %s", diff)
 	}
 	if diff := pretty.Diff(err, wantErr); diff != nil {
 		t.Errorf("incorrect error; -got +want: %s", diff)
@@ -17,11 +17,14 @@ package keys
 import (
 	"encoding/base64"
 	"fmt"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/keyformat"
 )
 
-func syncAdd(mgr Manager, name string, pemPrivateKey string) error {
+func syncAdd(mgr Manager, name string, pemPrivateKey string, opts *AddOptions) error {
 	errc := make(chan error, 1)
-	mgr.Add(name, pemPrivateKey, func(err error) {
+	mgr.Add(name, pemPrivateKey, opts, func(err error) {
 		errc <- err
 		close(errc)
 	})
@@ -58,6 +61,104 @@ func syncLoad(mgr Manager, id ID, passphrase string) error {
 	return readErr(errc)
 }
 
+func syncLoadWithLifetime(mgr Manager, id ID, passphrase string, lifetime time.Duration) error {
+	errc := make(chan error, 1)
+	mgr.LoadWithLifetime(id, passphrase, lifetime, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncLoadEphemeral(mgr Manager, name string, pemPrivateKey string) error {
+	errc := make(chan error, 1)
+	mgr.LoadEphemeral(name, pemPrivateKey, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncExport(mgr Manager, id ID, passphrase string, format keyformat.PrivateFormat, exportPassphrase string) (string, error) {
+	errc := make(chan error, 1)
+	var result string
+	mgr.Export(id, passphrase, format, exportPassphrase, func(encoded string, err error) {
+		result = encoded
+		errc <- err
+		close(errc)
+	})
+	return result, readErr(errc)
+}
+
+func syncPublicKey(mgr Manager, id ID, format keyformat.PublicFormat) (string, error) {
+	errc := make(chan error, 1)
+	var result string
+	mgr.PublicKey(id, format, func(encoded string, err error) {
+		result = encoded
+		errc <- err
+		close(errc)
+	})
+	return result, readErr(errc)
+}
+
+func syncSetLoadOnStartup(mgr Manager, id ID, enabled bool) error {
+	errc := make(chan error, 1)
+	mgr.SetLoadOnStartup(id, enabled, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncLoadOnStartup(mgr Manager) (int, error) {
+	errc := make(chan error, 1)
+	var result int
+	mgr.LoadOnStartup(func(loaded int, err error) {
+		result = loaded
+		errc <- err
+		close(errc)
+	})
+	return result, readErr(errc)
+}
+
+func syncLockVault(mgr Manager) error {
+	errc := make(chan error, 1)
+	mgr.LockVault(func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncAutoLockPolicy(mgr Manager) (*AutoLockPolicy, error) {
+	errc := make(chan error, 1)
+	var result *AutoLockPolicy
+	mgr.AutoLockPolicy(func(policy *AutoLockPolicy, err error) {
+		result = policy
+		errc <- err
+		close(errc)
+	})
+	return result, readErr(errc)
+}
+
+func syncSetAutoLockPolicy(mgr Manager, policy *AutoLockPolicy) error {
+	errc := make(chan error, 1)
+	mgr.SetAutoLockPolicy(policy, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncSetSchedule(mgr Manager, id ID, schedule string) error {
+	errc := make(chan error, 1)
+	mgr.SetSchedule(id, schedule, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
 func syncLoaded(mgr Manager) ([]*LoadedKey, error) {
 	errc := make(chan error, 1)
 	var result []*LoadedKey
@@ -79,6 +180,173 @@ func syncUnload(mgr Manager, key *LoadedKey) error {
 	return readErr(errc)
 }
 
+func syncUnloadByFingerprint(mgr Manager, fp string) error {
+	errc := make(chan error, 1)
+	mgr.UnloadByFingerprint(fp, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncUnloadByName(mgr Manager, name string) error {
+	errc := make(chan error, 1)
+	mgr.UnloadByName(name, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncUsage(mgr Manager) (*StorageUsage, error) {
+	errc := make(chan error, 1)
+	var result *StorageUsage
+	mgr.Usage(func(usage *StorageUsage, err error) {
+		result = usage
+		errc <- err
+		close(errc)
+	})
+	err := readErr(errc)
+	return result, err
+}
+
+func syncSetCanary(mgr Manager, id ID, canary bool) error {
+	errc := make(chan error, 1)
+	mgr.SetCanary(id, canary, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncSetRevoked(mgr Manager, id ID, revoked bool) error {
+	errc := make(chan error, 1)
+	mgr.SetRevoked(id, revoked, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncGenerate(mgr Manager, name, keyType string, bits int, passphrase string) (string, error) {
+	errc := make(chan error, 1)
+	var result string
+	mgr.Generate(name, keyType, bits, passphrase, func(publicKey string, err error) {
+		result = publicKey
+		errc <- err
+		close(errc)
+	})
+	err := readErr(errc)
+	return result, err
+}
+
+func syncVaultStatus(mgr Manager) (enabled, unlocked bool, err error) {
+	errc := make(chan error, 1)
+	mgr.VaultStatus(func(e, u bool, err error) {
+		enabled, unlocked = e, u
+		errc <- err
+		close(errc)
+	})
+	err = readErr(errc)
+	return enabled, unlocked, err
+}
+
+func syncUnlockVault(mgr Manager, passphrase string) error {
+	errc := make(chan error, 1)
+	mgr.UnlockVault(passphrase, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncSetMasterPassphrase(mgr Manager, current, passphrase string) error {
+	errc := make(chan error, 1)
+	mgr.SetMasterPassphrase(current, passphrase, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncIDScheme(mgr Manager) (IDScheme, error) {
+	errc := make(chan error, 1)
+	var result IDScheme
+	mgr.IDScheme(func(scheme IDScheme, err error) {
+		result = scheme
+		errc <- err
+		close(errc)
+	})
+	err := readErr(errc)
+	return result, err
+}
+
+func syncSetIDScheme(mgr Manager, scheme IDScheme) (int, error) {
+	errc := make(chan error, 1)
+	var result int
+	mgr.SetIDScheme(scheme, func(migrated int, err error) {
+		result = migrated
+		errc <- err
+		close(errc)
+	})
+	err := readErr(errc)
+	return result, err
+}
+
+func syncSetNotes(mgr Manager, id ID, notes string) error {
+	errc := make(chan error, 1)
+	mgr.SetNotes(id, notes, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncSetProfile(mgr Manager, id ID, profile string) error {
+	errc := make(chan error, 1)
+	mgr.SetProfile(id, profile, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func syncInventory(mgr Manager) ([]*InventoryItem, error) {
+	errc := make(chan error, 1)
+	var result []*InventoryItem
+	mgr.Inventory(func(items []*InventoryItem, err error) {
+		result = items
+		errc <- err
+		close(errc)
+	})
+	err := readErr(errc)
+	return result, err
+}
+
+func syncSelfCheck(mgr Manager, sample int) ([]*CheckResult, error) {
+	errc := make(chan error, 1)
+	var result []*CheckResult
+	mgr.SelfCheck(sample, func(results []*CheckResult, err error) {
+		result = results
+		errc <- err
+		close(errc)
+	})
+	err := readErr(errc)
+	return result, err
+}
+
+func syncUpgrade(mgr Manager) (*UpgradeResult, error) {
+	errc := make(chan error, 1)
+	var result *UpgradeResult
+	mgr.Upgrade(func(r *UpgradeResult, err error) {
+		result = r
+		errc <- err
+		close(errc)
+	})
+	err := readErr(errc)
+	return result, err
+}
+
 func readErr(errc chan error) error {
 	for err := range errc {
 		return err
@@ -124,7 +392,7 @@ func loadedKeyIds(keys []*LoadedKey) []ID {
 func loadedKeyBlobs(keys []*LoadedKey) []string {
 	var result []string
 	for _, k := range keys {
-		result = append(result, base64.StdEncoding.EncodeToString(k.Blob()))
+		result = append(result, base64.StdEncoding.EncodeToString(k.Blob))
 	}
 	return result
 }
@@ -0,0 +1,258 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/agenthooks"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// DefaultConfirmTimeout is how long a signature waits for the user to
+	// allow it before it is refused.
+	DefaultConfirmTimeout = time.Minute
+)
+
+var (
+	// ErrConfirmDenied is returned when the user refused a signature.
+	ErrConfirmDenied = errors.New("signature refused by the user")
+	// ErrConfirmTimeout is returned when the user did not allow a
+	// signature before the request timed out.
+	ErrConfirmTimeout = errors.New("signature refused: the user did not respond")
+	// ErrConfirmNotPending is returned when the user responds to a
+	// signature request that is no longer waiting for them.
+	ErrConfirmNotPending = errors.New("signature request is no longer waiting for confirmation")
+)
+
+// ConfirmRequest is a signature request waiting for the user to allow or
+// refuse it.
+type ConfirmRequest struct {
+	// Request identifies the request; responses are made using it.
+	Request int `codec:"request"`
+	// ID is the ID of the key with which the signature was requested.
+	ID ID `codec:"id"`
+	// Name is the name of the key.
+	Name string `codec:"name"`
+	// Reason explains to the user why they are being asked.
+	Reason string `codec:"reason"`
+	// Requester identifies the connection over which the signature was
+	// requested, or is empty if it is unknown.
+	Requester string `codec:"requester"`
+	// Expires is the time at which the request is refused if the user
+	// has not responded, in milliseconds since the Unix epoch.
+	Expires int64 `codec:"expires"`
+}
+
+// confirmKey is a loaded key for which signatures must be allowed.
+type confirmKey struct {
+	name   string
+	reason string
+}
+
+// pendingConfirm is a signature request waiting for the user.
+type pendingConfirm struct {
+	request *ConfirmRequest
+	// done receives the outcome of the request.  It is buffered so that
+	// the outcome can be delivered without waiting for the request.
+	done chan error
+}
+
+// ConfirmGuard requires that the user allow each signature using selected
+// keys.  A signature request is held until the user responds, and refused
+// if they do not do so in time.
+type ConfirmGuard struct {
+	mu sync.Mutex
+	// keys contains the keys requiring confirmation, keyed by ID.
+	keys map[ID]*confirmKey
+	// pending contains the requests waiting for the user, keyed by
+	// request.
+	pending map[int]*pendingConfirm
+	// next is the number assigned to the next request.
+	next int
+	// timeout is how long a request waits for the user.
+	timeout time.Duration
+	// requested is invoked each time a request starts waiting.
+	requested func(r *ConfirmRequest)
+	// now returns the current time.  It may be replaced in tests.
+	now func() time.Time
+}
+
+// NewConfirmGuard returns a ConfirmGuard for which no keys require
+// confirmation.  A signature request waits up to timeout for the user;
+// requested is invoked each time one starts waiting, so the user can be
+// asked.
+func NewConfirmGuard(timeout time.Duration, requested func(r *ConfirmRequest)) *ConfirmGuard {
+	return &ConfirmGuard{
+		keys:      make(map[ID]*confirmKey),
+		pending:   make(map[int]*pendingConfirm),
+		next:      1,
+		timeout:   timeout,
+		requested: requested,
+		now:       time.Now,
+	}
+}
+
+// set records whether signatures using the key with the specified ID and
+// name must be allowed.  reason is displayed to the user when they are
+// asked; an empty reason indicates that signatures need not be allowed.
+func (g *ConfirmGuard) set(id ID, name, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if reason != "" {
+		g.keys[id] = &confirmKey{name: name, reason: reason}
+	} else {
+		delete(g.keys, id)
+	}
+}
+
+// selected returns true if signatures using the key with the specified ID
+// must be allowed.
+func (g *ConfirmGuard) selected(id ID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, ok := g.keys[id]
+	return ok
+}
+
+// CheckSign determines if the specified loaded key may be used to sign.  If
+// the key requires confirmation, it waits until the user responds (see
+// Respond), or the request times out.
+func (g *ConfirmGuard) CheckSign(key *agent.Key) error {
+	id := (&LoadedKey{Comment: key.Comment}).ID()
+	if id == InvalidID {
+		return nil
+	}
+
+	g.mu.Lock()
+	k, ok := g.keys[id]
+	g.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return g.Confirm(id, k.name, k.reason, "")
+}
+
+// Confirm waits until the user allows a signature using the key with the
+// specified ID and name (see Respond), or the request times out.  reason
+// is displayed to the user, along with requester if it is not empty.  The
+// key need not have been selected to require confirmation (e.g., it may
+// have been added with the confirm constraint by a client), and id may be
+// InvalidID for keys that are not configured.
+func (g *ConfirmGuard) Confirm(id ID, name, reason, requester string) error {
+	g.mu.Lock()
+	p := &pendingConfirm{
+		request: &ConfirmRequest{
+			Request:   g.next,
+			ID:        id,
+			Name:      name,
+			Reason:    reason,
+			Requester: requester,
+			Expires:   g.now().Add(g.timeout).UnixNano() / int64(time.Millisecond),
+		},
+		done: make(chan error, 1),
+	}
+	g.next++
+	g.pending[p.request.Request] = p
+	g.mu.Unlock()
+
+	if g.requested != nil {
+		r := *p.request
+		g.requested(&r)
+	}
+
+	var err error
+	select {
+	case err = <-p.done:
+	case <-time.After(g.timeout):
+		g.mu.Lock()
+		delete(g.pending, p.request.Request)
+		g.mu.Unlock()
+		// The user may have responded just before the request was
+		// removed.
+		select {
+		case err = <-p.done:
+		default:
+			err = ErrConfirmTimeout
+		}
+	}
+	if err != nil {
+		log.Printf("Confirm: refused signature using key %q (%s): %v", name, id, err)
+	}
+	return err
+}
+
+// Pending returns the signature requests waiting for the user, oldest
+// first.
+func (g *ConfirmGuard) Pending() []*ConfirmRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var result []*ConfirmRequest
+	for _, p := range g.pending {
+		r := *p.request
+		result = append(result, &r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Request < result[j].Request
+	})
+	return result
+}
+
+// Respond allows the signature request if allow is true, or refuses it
+// otherwise.
+func (g *ConfirmGuard) Respond(request int, allow bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.pending[request]
+	if !ok {
+		return ErrConfirmNotPending
+	}
+	delete(g.pending, request)
+	if allow {
+		p.done <- nil
+	} else {
+		p.done <- ErrConfirmDenied
+	}
+	return nil
+}
+
+// Hooks returns hooks that hold signatures until they are allowed when
+// installed in an agenthooks.Agent.
+func (g *ConfirmGuard) Hooks() *agenthooks.Hooks {
+	return &agenthooks.Hooks{
+		BeforeSign: func(key *agent.Key, data []byte) error {
+			return g.CheckSign(key)
+		},
+	}
+}
+
+// WithConfirmGuard specifies a guard that is told which keys require each
+// signature to be allowed as they are loaded.  Signatures using remote keys
+// (see the remote package) must be allowed, since they are sent to another
+// service.  By default, signatures are not confirmed.
+func WithConfirmGuard(guard *ConfirmGuard) ManagerOption {
+	return func(m *manager) {
+		m.confirm = guard
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/agenthooks"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConfirm(t *testing.T) {
+	testcases := []struct {
+		description    string
+		remote         bool
+		allow          []bool
+		timeout        time.Duration
+		wantRequested  []string
+		wantRespondErr []error
+		wantErr        error
+	}{
+		{
+			description: "software key signs without confirmation",
+		},
+		{
+			description:    "remote key allowed",
+			remote:         true,
+			allow:          []bool{true},
+			wantRequested:  []string{"some-key: The key is held by signer.example.com, which will compute the signature."},
+			wantRespondErr: []error{nil},
+		},
+		{
+			description:    "remote key refused",
+			remote:         true,
+			allow:          []bool{false},
+			wantRequested:  []string{"some-key: The key is held by signer.example.com, which will compute the signature."},
+			wantRespondErr: []error{nil},
+			wantErr:        ErrConfirmDenied,
+		},
+		{
+			description:    "respond twice",
+			remote:         true,
+			allow:          []bool{true, false},
+			wantRequested:  []string{"some-key: The key is held by signer.example.com, which will compute the signature."},
+			wantRespondErr: []error{nil, ErrConfirmNotPending},
+		},
+		{
+			description:   "no response",
+			remote:        true,
+			timeout:       10 * time.Millisecond,
+			wantRequested: []string{"some-key: The key is held by signer.example.com, which will compute the signature."},
+			wantErr:       ErrConfirmTimeout,
+		},
+	}
+
+	for _, tc := range testcases {
+		timeout := tc.timeout
+		if timeout == 0 {
+			timeout = DefaultConfirmTimeout
+		}
+		var guard *ConfirmGuard
+		var requested []string
+		var respondErr []error
+		guard = NewConfirmGuard(timeout, func(r *ConfirmRequest) {
+			requested = append(requested, r.Name+": "+r.Reason)
+			if diff := pretty.Diff(guard.Pending(), []*ConfirmRequest{r}); diff != nil {
+				t.Errorf("%s: incorrect pending requests; -got +want: %s", tc.description, diff)
+			}
+			// Respond as the user would while the signature
+			// waits.
+			for _, allow := range tc.allow {
+				respondErr = append(respondErr, guard.Respond(r.Request, allow))
+			}
+		})
+		encoded, _, providers := newRemoteKey(t)
+		if !tc.remote {
+			encoded = testdata.ValidPrivateKeyWithoutPassphrase
+		}
+		agt := agenthooks.New(keyring.New())
+		agt.Install(guard.Hooks())
+		mgr := NewManager(agt, fakes.NewMemStorage(), fakes.NewMemStorage(), WithProviders(providers), WithConfirmGuard(guard))
+		if err := syncAdd(mgr, "some-key", encoded, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncLoad(mgr, id, ""); err != nil {
+			t.Fatalf("%s: failed to load key: %v", tc.description, err)
+		}
+
+		loaded, err := agt.List()
+		if err != nil || len(loaded) != 1 {
+			t.Fatalf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		pub, err := ssh.ParsePublicKey(loaded[0].Blob)
+		if err != nil {
+			t.Fatalf("%s: failed to parse public key: %v", tc.description, err)
+		}
+		_, err = agt.Sign(pub, []byte("some-data"))
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(requested, tc.wantRequested); diff != nil {
+			t.Errorf("%s: incorrect requests; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(respondErr, tc.wantRespondErr); diff != nil {
+			t.Errorf("%s: incorrect respond errors; -got +want: %s", tc.description, diff)
+		}
+		if got := guard.Pending(); len(got) != 0 {
+			t.Errorf("%s: requests still pending after signature: %v", tc.description, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// constraintReason is displayed to the user when asked to allow a signature
+// using a key added with the confirm constraint.
+const constraintReason = "The key was added with the confirm constraint, so each signature must be allowed."
+
+// ConfirmConstraints reports which loaded keys were added with the confirm
+// constraint (e.g., by 'ssh-add -c').  It is implemented by
+// keyring.Keyring.
+type ConfirmConstraints interface {
+	// ConfirmBeforeUse returns true if the key was added with the
+	// confirm constraint.
+	ConfirmBeforeUse(key ssh.PublicKey) bool
+}
+
+// ConfirmInterceptor enforces the confirm constraint: each signature request
+// using a key added with it is held until the user allows it, naming the
+// connection over which it was made.  It wraps the agent served to each
+// connection (see transport.Server.Intercept).
+type ConfirmInterceptor struct {
+	guard *ConfirmGuard
+	// constraints determines which keys require confirmation, or is nil
+	// if every key does.
+	constraints ConfirmConstraints
+	// reason is displayed to the user when they are asked.
+	reason string
+}
+
+// NewConfirmInterceptor returns a ConfirmInterceptor that asks the user to
+// allow signatures using guard, for the keys constrained according to
+// constraints.
+func NewConfirmInterceptor(guard *ConfirmGuard, constraints ConfirmConstraints) *ConfirmInterceptor {
+	return &ConfirmInterceptor{
+		guard:       guard,
+		constraints: constraints,
+		reason:      constraintReason,
+	}
+}
+
+// NewConfirmAllInterceptor returns a ConfirmInterceptor that asks the user to
+// allow every signature using guard, displaying reason.  It is used for
+// requests that must each be allowed regardless of how the key was added
+// (e.g., those made by web applications through the bridge).
+func NewConfirmAllInterceptor(guard *ConfirmGuard, reason string) *ConfirmInterceptor {
+	return &ConfirmInterceptor{
+		guard:  guard,
+		reason: reason,
+	}
+}
+
+// Agent returns an agent.Agent that performs requests made over the
+// connection identified by conn using a, holding signature requests using
+// constrained keys until the user allows them.
+func (i *ConfirmInterceptor) Agent(a agent.Agent, conn string) agent.Agent {
+	return &confirmAgent{
+		Agent:       a,
+		interceptor: i,
+		conn:        conn,
+	}
+}
+
+// confirmAgent is an agent.Agent enforcing the confirm constraint for the
+// requests made over a single connection.
+type confirmAgent struct {
+	agent.Agent
+	interceptor *ConfirmInterceptor
+	conn        string
+}
+
+// Sign implements agent.Agent.Sign.
+func (c *confirmAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	if c.interceptor.constraints == nil || c.interceptor.constraints.ConfirmBeforeUse(key) {
+		id, name := c.describe(key)
+		// Configured keys already requiring confirmation (e.g.,
+		// remote keys) are only confirmed once.
+		if id == InvalidID || !c.interceptor.guard.selected(id) {
+			if err := c.interceptor.guard.Confirm(id, name, c.interceptor.reason, c.conn); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c.Agent.Sign(key, data)
+}
+
+// describe returns the ID of the configured key for the loaded key, if any,
+// and a name for it to display to the user.
+func (c *confirmAgent) describe(key ssh.PublicKey) (ID, string) {
+	blob := key.Marshal()
+	if loaded, err := c.Agent.List(); err == nil {
+		for _, l := range loaded {
+			if !bytes.Equal(l.Blob, blob) {
+				continue
+			}
+			k := &LoadedKey{Comment: l.Comment}
+			if name := k.Nickname(); name != "" {
+				return k.ID(), name
+			}
+			return k.ID(), ssh.FingerprintSHA256(key)
+		}
+	}
+	return InvalidID, ssh.FingerprintSHA256(key)
+}
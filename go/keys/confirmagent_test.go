@@ -0,0 +1,134 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestConfirmInterceptor(t *testing.T) {
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		t.Fatalf("failed to parse private key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+
+	testcases := []struct {
+		description   string
+		comment       string
+		confirm       bool
+		all           bool
+		allow         bool
+		wantRequested []ConfirmRequest
+		wantErr       error
+	}{
+		{
+			description: "unconstrained key signs without confirmation",
+		},
+		{
+			description: "constrained key allowed",
+			confirm:     true,
+			allow:       true,
+			wantRequested: []ConfirmRequest{
+				{
+					ID:        InvalidID,
+					Name:      fingerprint,
+					Reason:    constraintReason,
+					Requester: "some-connection",
+				},
+			},
+		},
+		{
+			description: "constrained key refused",
+			confirm:     true,
+			wantRequested: []ConfirmRequest{
+				{
+					ID:        InvalidID,
+					Name:      fingerprint,
+					Reason:    constraintReason,
+					Requester: "some-connection",
+				},
+			},
+			wantErr: ErrConfirmDenied,
+		},
+		{
+			description: "constrained configured key named by nickname",
+			comment:     commentPrefix + "some-id some-key",
+			confirm:     true,
+			allow:       true,
+			wantRequested: []ConfirmRequest{
+				{
+					ID:        ID("some-id"),
+					Name:      "some-key",
+					Reason:    constraintReason,
+					Requester: "some-connection",
+				},
+			},
+		},
+		{
+			description: "unconstrained key confirmed when all are",
+			all:         true,
+			allow:       true,
+			wantRequested: []ConfirmRequest{
+				{
+					ID:        InvalidID,
+					Name:      fingerprint,
+					Reason:    "some-reason",
+					Requester: "some-connection",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		var guard *ConfirmGuard
+		var requested []ConfirmRequest
+		guard = NewConfirmGuard(DefaultConfirmTimeout, func(r *ConfirmRequest) {
+			got := *r
+			got.Request, got.Expires = 0, 0
+			requested = append(requested, got)
+			if err := guard.Respond(r.Request, tc.allow); err != nil {
+				t.Errorf("%s: failed to respond: %v", tc.description, err)
+			}
+		})
+		kr := keyring.New()
+		if err := kr.Add(agent.AddedKey{PrivateKey: priv, Comment: tc.comment, ConfirmBeforeUse: tc.confirm}); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		interceptor := NewConfirmInterceptor(guard, kr)
+		if tc.all {
+			interceptor = NewConfirmAllInterceptor(guard, "some-reason")
+		}
+		agt := interceptor.Agent(kr, "some-connection")
+
+		_, err := agt.Sign(signer.PublicKey(), []byte("some-data"))
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(requested, tc.wantRequested); diff != nil {
+			t.Errorf("%s: incorrect requests; -got +want: %s", tc.description, diff)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/codec"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// Define a distinct type for each message used to allow or refuse
+// signatures.  These are distinct from those used by the Server.
+const (
+	msgTypeConfirmPending int = 8000 + iota
+	msgTypeConfirmPendingRsp
+	msgTypeConfirmRespond
+	msgTypeConfirmRespondRsp
+)
+
+type msgConfirmPending struct {
+	*msgHeader
+}
+
+type rspConfirmPending struct {
+	*msgHeader
+	Requests interface{} `js:"requests"`
+}
+
+type msgConfirmRespond struct {
+	*msgHeader
+	Request int  `js:"request"`
+	Allow   bool `js:"allow"`
+}
+
+type rspConfirmRespond struct {
+	*msgHeader
+	Err string `js:"err"`
+}
+
+// makeConfirmErr converts an error string returned by ServeConfirm to an
+// error.
+func makeConfirmErr(s string) error {
+	switch s {
+	case "":
+		return nil
+	case ErrConfirmNotPending.Error():
+		return ErrConfirmNotPending
+	}
+	return errors.New(s)
+}
+
+// ServeConfirm allows other extension pages to list the signature requests
+// waiting for the user, and allow or refuse them, using ConfirmClient.
+func ServeConfirm(guard *ConfirmGuard, msg MessageReceiver) {
+	msg.OnMessage(func(headerObj *js.Object, sender *js.Object, sendResponse func(interface{})) bool {
+		if !fromExtension(sender, msg.ExtensionURL()) {
+			return false
+		}
+		header := &msgHeader{Object: headerObj}
+		switch header.Type {
+		case msgTypeConfirmPending:
+			rsp := &rspConfirmPending{msgHeader: header}
+			rsp.Type = msgTypeConfirmPendingRsp
+			rsp.Requests = mustEncode(guard.Pending())
+			sendResponse(rsp)
+		case msgTypeConfirmRespond:
+			m := &msgConfirmRespond{msgHeader: header}
+			rsp := &rspConfirmRespond{msgHeader: header}
+			rsp.Type = msgTypeConfirmRespondRsp
+			rsp.Err = makeErrStr(guard.Respond(m.Request, m.Allow))
+			sendResponse(rsp)
+		}
+		return false
+	})
+}
+
+// ConfirmClient lists signature requests waiting for the user, and allows or
+// refuses them, using the ConfirmGuard served by ServeConfirm (typically in
+// the background page).
+type ConfirmClient struct {
+	msg MessageSender
+}
+
+// NewConfirmClient returns a ConfirmClient that sends requests using the
+// supplied messaging API.
+func NewConfirmClient(msg MessageSender) *ConfirmClient {
+	return &ConfirmClient{msg: msg}
+}
+
+// Pending returns the signature requests waiting for the user; see
+// ConfirmGuard.Pending.
+func (c *ConfirmClient) Pending(callback func(requests []*ConfirmRequest, err error)) {
+	msg := &msgConfirmPending{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeConfirmPending
+	sendMessage(c.msg, msg, func(rspObj *js.Object, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		rsp := &rspConfirmPending{msgHeader: &msgHeader{Object: rspObj}}
+		var requests []*ConfirmRequest
+		if err := codec.Decode(rsp.Requests, &requests); err != nil {
+			callback(nil, fmt.Errorf("failed to decode requests: %v", err))
+			return
+		}
+		callback(requests, nil)
+	})
+}
+
+// Respond allows or refuses a signature request; see ConfirmGuard.Respond.
+func (c *ConfirmClient) Respond(request int, allow bool, callback func(err error)) {
+	msg := &msgConfirmRespond{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeConfirmRespond
+	msg.Request = request
+	msg.Allow = allow
+	sendMessage(c.msg, msg, func(rspObj *js.Object, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		rsp := &rspConfirmRespond{msgHeader: &msgHeader{Object: rspObj}}
+		callback(makeConfirmErr(rsp.Err))
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+const (
+	// readAttempts is the number of times a storage area is read before
+	// it is considered unreadable.
+	readAttempts = 2
+)
+
+// ErrDegraded is returned, along with the keys that could be read, when a
+// storage area cannot be read.  The keys last read from that area are
+// included in place of its current keys, so the keys returned may be out
+// of date or incomplete.  They are suitable for display, but not for
+// deciding what to change.
+type ErrDegraded struct {
+	// Err is the error encountered when reading storage.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *ErrDegraded) Error() string {
+	return e.Err.Error()
+}
+
+// HelpCode implements help.Coder.
+func (e *ErrDegraded) HelpCode() help.Code {
+	return help.DegradedStorage
+}
+
+// IsDegraded determines if err indicates that the keys returned with it
+// were read only in part.  It recognizes errors forwarded by a Client.
+func IsDegraded(err error) bool {
+	return help.CodeOf(err) == help.DegradedStorage
+}
+
+// copyKeys returns shallow copies of the supplied keys, so that keys
+// remembered by mergeRead are not changed by callers of readKeys.
+func copyKeys(keys []*storedKey) []*storedKey {
+	var result []*storedKey
+	for _, k := range keys {
+		c := *k
+		result = append(result, &c)
+	}
+	return result
+}
+
+// mergeRead combines the keys read from both storage areas and invokes
+// callback with them.  The keys read from each area are remembered; if an
+// area could not be read, the keys last read from it are used instead, and
+// an *ErrDegraded is returned.  An error is returned without any keys only
+// if neither area has ever been read.
+func (m *manager) mergeRead(synced []*storedKey, syncErr error, local []*storedKey, localErr error, callback func(keys []*storedKey, err error)) {
+	err := syncErr
+	if err == nil {
+		err = localErr
+	}
+	if syncErr == nil {
+		m.lastRead[false] = copyKeys(synced)
+	}
+	if localErr == nil {
+		m.lastRead[true] = copyKeys(local)
+	}
+	if syncErr != nil {
+		synced = copyKeys(m.lastRead[false])
+	}
+	if localErr != nil {
+		local = copyKeys(m.lastRead[true])
+	}
+
+	if err != nil && len(m.lastRead) == 0 {
+		callback(nil, err)
+		return
+	}
+
+	keys := append(synced, local...)
+	if err == nil {
+		m.deviceOnly = make(map[ID]bool)
+	}
+	for _, k := range keys {
+		m.deviceOnly[k.ID] = k.DeviceOnly
+	}
+	if err != nil {
+		callback(keys, &ErrDegraded{Err: err})
+		return
+	}
+	callback(keys, nil)
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// flakyStore is a PersistentStore whose next failures reads fail.
+type flakyStore struct {
+	PersistentStore
+	failures int
+}
+
+func (s *flakyStore) Get(callback func(data map[string]interface{}, err error)) {
+	if s.failures > 0 {
+		s.failures--
+		callback(nil, errors.New("transient failure"))
+		return
+	}
+	s.PersistentStore.Get(callback)
+}
+
+func TestConfiguredDegraded(t *testing.T) {
+	testcases := []struct {
+		description    string
+		readFirst      bool
+		syncFailures   int
+		localFailures  int
+		wantConfigured []string
+		wantErr        error
+	}{
+		{
+			description:    "retry transient failure",
+			syncFailures:   1,
+			wantConfigured: []string{"synced-key", "local-key"},
+		},
+		{
+			description:    "return last keys read",
+			readFirst:      true,
+			syncFailures:   readAttempts,
+			wantConfigured: []string{"synced-key", "local-key"},
+			wantErr:        &ErrDegraded{Err: errors.New("failed to read keys: failed to read from storage: transient failure")},
+		},
+		{
+			description:    "return keys that could be read",
+			syncFailures:   readAttempts,
+			wantConfigured: []string{"local-key"},
+			wantErr:        &ErrDegraded{Err: errors.New("failed to read keys: failed to read from storage: transient failure")},
+		},
+		{
+			description:    "return last keys read from both areas",
+			readFirst:      true,
+			syncFailures:   readAttempts,
+			localFailures:  readAttempts,
+			wantConfigured: []string{"synced-key", "local-key"},
+			wantErr:        &ErrDegraded{Err: errors.New("failed to read keys: failed to read from storage: transient failure")},
+		},
+		{
+			description:   "fail if no keys were ever read",
+			syncFailures:  readAttempts,
+			localFailures: readAttempts,
+			wantErr:       errors.New("failed to read keys: failed to read from storage: transient failure"),
+		},
+	}
+
+	for _, tc := range testcases {
+		syncStorage := &flakyStore{PersistentStore: fakes.NewMemStorage()}
+		localStorage := &flakyStore{PersistentStore: fakes.NewMemStorage()}
+		initial := NewManager(agent.NewKeyring(), syncStorage, localStorage)
+		if err := syncAdd(initial, "synced-key", testdata.ValidPrivateKey, nil); err != nil {
+			t.Fatalf("%s: failed to add synced key: %v", tc.description, err)
+		}
+		if err := syncAdd(initial, "local-key", testdata.ValidPrivateKeyWithoutPassphrase, &AddOptions{DeviceOnly: true}); err != nil {
+			t.Fatalf("%s: failed to add device-only key: %v", tc.description, err)
+		}
+
+		// Use a new manager, so that nothing has been read before the
+		// test case starts.
+		mgr := NewManager(agent.NewKeyring(), syncStorage, localStorage)
+		if tc.readFirst {
+			if _, err := syncConfigured(mgr); err != nil {
+				t.Fatalf("%s: failed to read keys: %v", tc.description, err)
+			}
+		}
+
+		syncStorage.failures = tc.syncFailures
+		localStorage.failures = tc.localFailures
+		configured, err := syncConfigured(mgr)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(configuredKeyNames(configured), tc.wantConfigured); diff != nil {
+			t.Errorf("%s: incorrect configured keys; -got +want: %s", tc.description, diff)
+		}
+		if got, want := IsDegraded(err), tc.wantErr != nil && tc.wantConfigured != nil; got != want {
+			t.Errorf("%s: incorrect degraded; got %v, want %v", tc.description, got, want)
+		}
+		if got, want := help.CodeOf(err) == help.DegradedStorage, IsDegraded(err); got != want {
+			t.Errorf("%s: incorrect help code; got %v", tc.description, help.CodeOf(err))
+		}
+	}
+}
+
+func TestReadKeysCopies(t *testing.T) {
+	mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+	if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKey, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+
+	// Keys remembered from a previous read must not be changed by the
+	// callers that read them.
+	m := mgr.(*manager)
+	m.readKeys(func(keys []*storedKey, err error) {
+		if err != nil {
+			t.Fatalf("failed to read keys: %v", err)
+		}
+		keys[0].Name = "changed"
+	})
+	if got := m.lastRead[false][0].Name; got != "some-key" {
+		t.Errorf("remembered key changed; got name %q, want %q", got, "some-key")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+)
+
+// DependencyKind identifies the kind of data that depends on a configured
+// key.
+type DependencyKind string
+
+const (
+	// DependsLoaded indicates that the key is loaded in the agent.
+	// Removing the configured key leaves it loaded (and usable) unless it
+	// is also unloaded.
+	DependsLoaded DependencyKind = "loaded"
+	// DependsProvisioned indicates that the key was provisioned by an
+	// administrator, and will be added again when provisioning next runs.
+	DependsProvisioned DependencyKind = "provisioned"
+	// DependsSynced indicates that the key is synced with Chrome Sync, and
+	// removing it removes it from other devices.
+	DependsSynced DependencyKind = "synced"
+	// DependsCanary indicates that the key is a canary; removing it
+	// disables the alarm raised when a client asks to use it.
+	DependsCanary DependencyKind = "canary"
+)
+
+// Dependency describes data that depends on a configured key, and is
+// affected when the key is removed.
+type Dependency struct {
+	// Kind is the kind of dependent data.
+	Kind DependencyKind
+	// Description is a human-readable description of the dependency,
+	// and what happens to it when the key is removed.
+	Description string
+}
+
+// Dependencies returns the data that depends on the configured key with the
+// specified ID, so that the user can decide what to do with it before the
+// key is removed.  callback is invoked with the result.
+func Dependencies(mgr Manager, id ID, callback func(deps []*Dependency, err error)) {
+	mgr.Configured(func(configured []*ConfiguredKey, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read configured keys: %v", err))
+			return
+		}
+		var key *ConfiguredKey
+		for _, k := range configured {
+			if k.ID == id {
+				key = k
+			}
+		}
+		if key == nil {
+			callback(nil, nil)
+			return
+		}
+
+		loadedKey(mgr, id, func(loaded *LoadedKey, err error) {
+			if err != nil {
+				callback(nil, err)
+				return
+			}
+
+			var deps []*Dependency
+			if loaded != nil {
+				deps = append(deps, &Dependency{
+					Kind:        DependsLoaded,
+					Description: "The key is loaded in the agent, and remains usable until it is unloaded.",
+				})
+			}
+			if key.Source == SourceProvisioned {
+				deps = append(deps, &Dependency{
+					Kind:        DependsProvisioned,
+					Description: fmt.Sprintf("The key was provisioned by your administrator from %s, and will be added again the next time provisioning runs.", key.SourceDetail),
+				})
+			}
+			if !key.DeviceOnly {
+				deps = append(deps, &Dependency{
+					Kind:        DependsSynced,
+					Description: "The key is synced with Chrome Sync, and will also be removed from your other devices.",
+				})
+			}
+			if key.Canary {
+				deps = append(deps, &Dependency{
+					Kind:        DependsCanary,
+					Description: "The key is a canary; no alarm will be raised if a client asks to use it.",
+				})
+			}
+			callback(deps, nil)
+		})
+	})
+}
+
+// loadedKey returns the key with the specified ID that is loaded in the
+// agent, or nil if it is not loaded.  callback is invoked with the result.
+func loadedKey(mgr Manager, id ID, callback func(key *LoadedKey, err error)) {
+	mgr.Loaded(func(loaded []*LoadedKey, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read loaded keys: %v", err))
+			return
+		}
+		for _, l := range loaded {
+			if l.ID() == id {
+				callback(l, nil)
+				return
+			}
+		}
+		callback(nil, nil)
+	})
+}
+
+// RemoveAndUnload removes the configured key with the specified ID, first
+// unloading it from the agent if it is loaded, so that it does not remain
+// usable after it is removed.  callback is invoked when complete.
+func RemoveAndUnload(mgr Manager, id ID, callback func(err error)) {
+	loadedKey(mgr, id, func(loaded *LoadedKey, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		if loaded == nil {
+			mgr.Remove(id, callback)
+			return
+		}
+		mgr.Unload(loaded, func(err error) {
+			if err != nil {
+				callback(fmt.Errorf("failed to unload key: %v", err))
+				return
+			}
+			mgr.Remove(id, callback)
+		})
+	})
+}
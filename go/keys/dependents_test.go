@@ -0,0 +1,173 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func syncDependencies(mgr Manager, id ID) ([]DependencyKind, error) {
+	errc := make(chan error, 1)
+	var kinds []DependencyKind
+	Dependencies(mgr, id, func(deps []*Dependency, err error) {
+		for _, d := range deps {
+			kinds = append(kinds, d.Kind)
+		}
+		errc <- err
+		close(errc)
+	})
+	return kinds, readErr(errc)
+}
+
+func syncRemoveAndUnload(mgr Manager, id ID) error {
+	errc := make(chan error, 1)
+	RemoveAndUnload(mgr, id, func(err error) {
+		errc <- err
+		close(errc)
+	})
+	return readErr(errc)
+}
+
+func TestDependencies(t *testing.T) {
+	testcases := []struct {
+		description string
+		opts        *AddOptions
+		load        bool
+		canary      bool
+		want        []DependencyKind
+	}{
+		{
+			description: "device-only key",
+			opts:        &AddOptions{DeviceOnly: true},
+		},
+		{
+			description: "synced key",
+			opts:        &AddOptions{},
+			want:        []DependencyKind{DependsSynced},
+		},
+		{
+			description: "loaded key",
+			opts:        &AddOptions{DeviceOnly: true},
+			load:        true,
+			want:        []DependencyKind{DependsLoaded},
+		},
+		{
+			description: "provisioned key",
+			opts:        &AddOptions{DeviceOnly: true, Source: SourceProvisioned, SourceDetail: "https://example.com/keys"},
+			want:        []DependencyKind{DependsProvisioned},
+		},
+		{
+			description: "canary key",
+			opts:        &AddOptions{DeviceOnly: true},
+			canary:      true,
+			want:        []DependencyKind{DependsCanary},
+		},
+		{
+			description: "all dependencies",
+			opts:        &AddOptions{Source: SourceProvisioned},
+			load:        true,
+			canary:      true,
+			want:        []DependencyKind{DependsLoaded, DependsProvisioned, DependsSynced, DependsCanary},
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		if err := syncAdd(mgr, "key", testdata.ValidPrivateKey, tc.opts); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if tc.load {
+			if err := syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase); err != nil {
+				t.Fatalf("%s: failed to load key: %v", tc.description, err)
+			}
+		}
+		if tc.canary {
+			if err := syncSetCanary(mgr, id, true); err != nil {
+				t.Fatalf("%s: failed to mark canary: %v", tc.description, err)
+			}
+		}
+
+		got, err := syncDependencies(mgr, id)
+		if err != nil {
+			t.Errorf("%s: failed to get dependencies: %v", tc.description, err)
+			continue
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect dependencies; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestRemoveAndUnload(t *testing.T) {
+	testcases := []struct {
+		description string
+		load        bool
+	}{
+		{
+			description: "loaded key",
+			load:        true,
+		},
+		{
+			description: "unloaded key",
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		for _, name := range []string{"key-1", "key-2"} {
+			if err := syncAdd(mgr, name, testdata.ValidPrivateKey, nil); err != nil {
+				t.Fatalf("%s: failed to add key: %v", tc.description, err)
+			}
+		}
+		id, err := findKey(mgr, InvalidID, "key-1")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if tc.load {
+			if err := syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase); err != nil {
+				t.Fatalf("%s: failed to load key: %v", tc.description, err)
+			}
+		}
+
+		if err := syncRemoveAndUnload(mgr, id); err != nil {
+			t.Errorf("%s: failed to remove key: %v", tc.description, err)
+			continue
+		}
+
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to read configured keys: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(configuredKeyNames(configured), []string{"key-2"}); diff != nil {
+			t.Errorf("%s: incorrect configured keys; -got +want: %s", tc.description, diff)
+		}
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to read loaded keys: %v", tc.description, err)
+		}
+		if len(loaded) != 0 {
+			t.Errorf("%s: incorrect number of loaded keys; got %d, want 0", tc.description, len(loaded))
+		}
+	}
+}
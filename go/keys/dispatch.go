@@ -0,0 +1,118 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"log"
+	"sync"
+)
+
+// dispatcher invokes a callback exactly once when a set of asynchronous
+// operations have all completed.  Operations on storage complete via
+// callbacks that may be invoked in any order, from any goroutine, and - if
+// the underlying store misbehaves - more than once or not at all.  Flows that
+// fan out to several operations (e.g., reading both storage areas) otherwise
+// each track completion by hand, and a duplicate completion could invoke
+// their callback twice, or before the other operations have completed.
+//
+// Operations are registered using add, after which wait is called.  The
+// callback is invoked on the goroutine (or, under GopherJS, in the event
+// loop turn) in which the last operation completes, or in which wait is
+// called if all operations have already completed.  It is never invoked
+// before wait is called.
+type dispatcher struct {
+	mu       sync.Mutex
+	pending  int
+	waiting  bool
+	finished bool
+	finish   func()
+}
+
+// newDispatcher returns a dispatcher that invokes finish once all the
+// operations subsequently registered with it have completed.
+func newDispatcher(finish func()) *dispatcher {
+	return &dispatcher{finish: finish}
+}
+
+// pendingOp is an operation registered with a dispatcher.
+type pendingOp struct {
+	d         *dispatcher
+	what      string
+	started   bool
+	completed bool
+}
+
+// add registers an operation described by what.  The operation must call
+// start when its callback is invoked, and done when it has completed.
+func (d *dispatcher) add(what string) *pendingOp {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending++
+	return &pendingOp{d: d, what: what}
+}
+
+// wait indicates that all operations have been added.  finish is invoked
+// when they have completed; if they already have, it is invoked before wait
+// returns.
+func (d *dispatcher) wait() {
+	d.mu.Lock()
+	d.waiting = true
+	d.mu.Unlock()
+	d.maybeFinish()
+}
+
+// maybeFinish invokes finish if all operations have completed, and it has
+// not yet been invoked.
+func (d *dispatcher) maybeFinish() {
+	d.mu.Lock()
+	if !d.waiting || d.pending > 0 || d.finished {
+		d.mu.Unlock()
+		return
+	}
+	d.finished = true
+	d.mu.Unlock()
+	d.finish()
+}
+
+// start reports whether this is the first time the operation's callback has
+// been invoked.  If it is not, the invocation is logged, and the caller must
+// ignore it; in particular, it must not modify any result that may already
+// have been passed to finish.
+func (o *pendingOp) start() bool {
+	o.d.mu.Lock()
+	defer o.d.mu.Unlock()
+	if o.started {
+		log.Printf("Callback for %s invoked more than once; ignoring", o.what)
+		return false
+	}
+	o.started = true
+	return true
+}
+
+// done marks the operation as complete.  Calls after the first are logged
+// and ignored.
+func (o *pendingOp) done() {
+	o.d.mu.Lock()
+	if o.completed {
+		o.d.mu.Unlock()
+		log.Printf("Operation %s completed more than once; ignoring", o.what)
+		return
+	}
+	o.started = true
+	o.completed = true
+	o.d.pending--
+	o.d.mu.Unlock()
+	o.d.maybeFinish()
+}
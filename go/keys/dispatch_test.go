@@ -0,0 +1,276 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestDispatcher(t *testing.T) {
+	testcases := []struct {
+		description string
+		ops         int
+		// run drives the operations, and must call wait.
+		run       func(ops []*pendingOp, wait func())
+		wantCalls int
+	}{
+		{
+			description: "no operations",
+			run: func(ops []*pendingOp, wait func()) {
+				wait()
+			},
+			wantCalls: 1,
+		},
+		{
+			description: "operations complete before wait",
+			ops:         2,
+			run: func(ops []*pendingOp, wait func()) {
+				ops[0].done()
+				ops[1].done()
+				wait()
+			},
+			wantCalls: 1,
+		},
+		{
+			description: "operations complete after wait",
+			ops:         2,
+			run: func(ops []*pendingOp, wait func()) {
+				wait()
+				ops[1].done()
+				ops[0].done()
+			},
+			wantCalls: 1,
+		},
+		{
+			description: "operation completes more than once",
+			ops:         2,
+			run: func(ops []*pendingOp, wait func()) {
+				wait()
+				ops[0].done()
+				ops[0].done()
+				ops[1].done()
+				ops[1].done()
+			},
+			wantCalls: 1,
+		},
+		{
+			description: "operation completes more than once before others",
+			ops:         2,
+			run: func(ops []*pendingOp, wait func()) {
+				wait()
+				ops[0].done()
+				ops[0].done()
+			},
+			wantCalls: 0,
+		},
+		{
+			description: "operation never completes",
+			ops:         2,
+			run: func(ops []*pendingOp, wait func()) {
+				wait()
+				ops[0].done()
+			},
+			wantCalls: 0,
+		},
+		{
+			description: "wait called more than once",
+			ops:         1,
+			run: func(ops []*pendingOp, wait func()) {
+				ops[0].done()
+				wait()
+				wait()
+			},
+			wantCalls: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		calls := 0
+		waited := false
+		d := newDispatcher(func() {
+			if !waited {
+				t.Errorf("%s: finish invoked before wait", tc.description)
+			}
+			calls++
+		})
+		var ops []*pendingOp
+		for i := 0; i < tc.ops; i++ {
+			ops = append(ops, d.add("op"))
+		}
+		tc.run(ops, func() {
+			waited = true
+			d.wait()
+		})
+		if diff := pretty.Diff(calls, tc.wantCalls); diff != nil {
+			t.Errorf("%s: incorrect number of calls to finish; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestPendingOpStart(t *testing.T) {
+	d := newDispatcher(func() {})
+	op := d.add("op")
+	if !op.start() {
+		t.Errorf("first call to start returned false")
+	}
+	if op.start() {
+		t.Errorf("second call to start returned true")
+	}
+	op.done()
+	if op.start() {
+		t.Errorf("call to start after done returned true")
+	}
+}
+
+func TestDispatcherConcurrent(t *testing.T) {
+	const n = 100
+	var mu sync.Mutex
+	calls := 0
+	finished := make(chan struct{})
+	d := newDispatcher(func() {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		close(finished)
+	})
+	var ops []*pendingOp
+	for i := 0; i < n; i++ {
+		ops = append(ops, d.add("op"))
+	}
+	for _, op := range ops {
+		go func(op *pendingOp) {
+			if op.start() {
+				op.done()
+			}
+			op.done()
+		}(op)
+	}
+	d.wait()
+	<-finished
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Errorf("incorrect number of calls to finish; got %d, want 1", calls)
+	}
+}
+
+// misbehavingStore is a PersistentStore that invokes the callbacks of Get and
+// GetItems twice, the second time with an error, and from a different
+// goroutine if async is true.
+type misbehavingStore struct {
+	PersistentStore
+	async bool
+}
+
+func (s *misbehavingStore) invoke(f func()) {
+	if !s.async {
+		f()
+		return
+	}
+	go f()
+}
+
+func (s *misbehavingStore) Get(callback func(data map[string]interface{}, err error)) {
+	s.PersistentStore.Get(func(data map[string]interface{}, err error) {
+		s.invoke(func() {
+			callback(data, err)
+			callback(nil, errors.New("duplicate callback"))
+		})
+	})
+}
+
+func (s *misbehavingStore) GetItems(keys []string, callback func(data map[string]interface{}, err error)) {
+	s.PersistentStore.GetItems(keys, func(data map[string]interface{}, err error) {
+		s.invoke(func() {
+			callback(data, err)
+			callback(nil, errors.New("duplicate callback"))
+		})
+	})
+}
+
+func TestMisbehavingStore(t *testing.T) {
+	testcases := []struct {
+		description string
+		async       bool
+		storageErr  fakes.Errs
+		wantNames   []string
+		wantErr     bool
+	}{
+		{
+			description: "duplicate callbacks",
+			wantNames:   []string{"good-key"},
+		},
+		{
+			description: "duplicate callbacks from other goroutines",
+			async:       true,
+			wantNames:   []string{"good-key"},
+		},
+		{
+			description: "duplicate callbacks after failure",
+			storageErr: fakes.Errs{
+				Get: errors.New("Storage error"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		syncStorage := fakes.NewMemStorage()
+		localStorage := fakes.NewMemStorage()
+		mgr, err := newTestManager(agent.NewKeyring(), syncStorage, localStorage, []*initialKey{
+			{
+				Name:          "good-key",
+				PEMPrivateKey: testdata.ValidPrivateKey,
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to initialize manager: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "good-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+
+		// The sync helpers fail if a callback is invoked more than
+		// once.
+		syncStorage.SetError(tc.storageErr)
+		mgr = NewManager(agent.NewKeyring(), &misbehavingStore{syncStorage, tc.async}, &misbehavingStore{localStorage, tc.async})
+
+		configured, err := syncConfigured(mgr)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error from Configured; got %v, want error %v", tc.description, err, tc.wantErr)
+		}
+		var names []string
+		for _, k := range configured {
+			names = append(names, k.Name)
+		}
+		if diff := pretty.Diff(names, tc.wantNames); diff != nil {
+			t.Errorf("%s: incorrect configured keys; -got +want: %s", tc.description, diff)
+		}
+
+		err = syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error from Load; got %v, want error %v", tc.description, err, tc.wantErr)
+		}
+	}
+}
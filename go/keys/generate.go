@@ -0,0 +1,81 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/attestation"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"golang.org/x/crypto/ssh"
+)
+
+// WithExtensionID specifies the ID of the extension, which is recorded in
+// the attestations of keys generated by the manager.
+func WithExtensionID(id string) ManagerOption {
+	return func(m *manager) {
+		m.extensionID = id
+	}
+}
+
+// Generate implements Manager.Generate.
+func (m *manager) Generate(name string, keyType string, bits int, passphrase string, callback func(publicKey string, err error)) {
+	p := m.providers.Default()
+	pemKey, signer, err := provider.Generate(p, keyType, bits, passphrase)
+	if err != nil {
+		callback("", err)
+		return
+	}
+	stmt := &attestation.Statement{
+		ExtensionID: m.extensionID,
+		Provider:    p.Name(),
+		Created:     nowMillis(),
+	}
+	a, err := attestation.New(stmt, signer, p.Rand())
+	if err != nil {
+		callback("", fmt.Errorf("failed to attest key: %v", err))
+		return
+	}
+	encoded, err := a.Marshal()
+	if err != nil {
+		callback("", fmt.Errorf("failed to attest key: %v", err))
+		return
+	}
+
+	opts := &AddOptions{
+		Source:      SourceGenerated,
+		UniqueName:  true,
+		Attestation: encoded,
+	}
+	m.Add(name, pemKey, opts, func(err error) {
+		if err != nil {
+			callback("", help.Wrap(err, "failed to add generated key"))
+			return
+		}
+		callback(authorizedKey(signer.PublicKey(), name), nil)
+	})
+}
+
+// authorizedKey returns pub in authorized_keys format, with name as its
+// comment.
+func authorizedKey(pub ssh.PublicKey, name string) string {
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	if name = strings.TrimSpace(name); name != "" {
+		line = fmt.Sprintf("%s %s", line, name)
+	}
+	return line
+}
@@ -0,0 +1,163 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/attestation"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestGenerate(t *testing.T) {
+	testcases := []struct {
+		description string
+		name        string
+		keyType     string
+		bits        int
+		passphrase  string
+		wantType    string
+		wantErr     bool
+	}{
+		{
+			description: "Ed25519 key",
+			name:        "new-key",
+			keyType:     provider.KeyTypeEd25519,
+			wantType:    ssh.KeyAlgoED25519,
+		},
+		{
+			description: "encrypted ECDSA key",
+			name:        "new-key",
+			keyType:     provider.KeyTypeECDSA,
+			bits:        384,
+			passphrase:  "secret",
+			wantType:    ssh.KeyAlgoECDSA384,
+		},
+		{
+			description: "encrypted RSA key",
+			name:        "new-key",
+			keyType:     provider.KeyTypeRSA,
+			bits:        2048,
+			passphrase:  "secret",
+			wantType:    ssh.KeyAlgoRSA,
+		},
+		{
+			description: "name taken",
+			name:        "existing-key",
+			keyType:     provider.KeyTypeECDSA,
+			wantErr:     true,
+		},
+		{
+			description: "encrypted Ed25519 key",
+			name:        "new-key",
+			keyType:     provider.KeyTypeEd25519,
+			passphrase:  "secret",
+			wantType:    ssh.KeyAlgoED25519,
+		},
+		{
+			description: "unsupported key type",
+			name:        "new-key",
+			keyType:     "dsa",
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), WithExtensionID("extension-id"))
+		if err := syncAdd(mgr, "existing-key", testdata.ValidPrivateKey, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+
+		publicKey, err := syncGenerate(mgr, tc.name, tc.keyType, tc.bits, tc.passphrase)
+		if diff := pretty.Diff(err != nil, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error state; -got +want: %s", tc.description, diff)
+		}
+		if err != nil {
+			configured, err := syncConfigured(mgr)
+			if err != nil {
+				t.Fatalf("%s: failed to read configured keys: %v", tc.description, err)
+			}
+			if diff := pretty.Diff(len(configured), 1); diff != nil {
+				t.Errorf("%s: incorrect number of configured keys; -got +want: %s", tc.description, diff)
+			}
+			continue
+		}
+
+		pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+		if err != nil {
+			t.Fatalf("%s: failed to parse public key: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(pub.Type(), tc.wantType); diff != nil {
+			t.Errorf("%s: incorrect key type; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(comment, tc.name); diff != nil {
+			t.Errorf("%s: incorrect comment; -got +want: %s", tc.description, diff)
+		}
+
+		id, err := findKey(mgr, InvalidID, tc.name)
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to read configured keys: %v", tc.description, err)
+		}
+		for _, k := range configured {
+			if k.ID != id {
+				continue
+			}
+			if diff := pretty.Diff(k.Source, SourceGenerated); diff != nil {
+				t.Errorf("%s: incorrect source; -got +want: %s", tc.description, diff)
+			}
+			if diff := pretty.Diff(k.Encrypted, tc.passphrase != ""); diff != nil {
+				t.Errorf("%s: incorrect encrypted state; -got +want: %s", tc.description, diff)
+			}
+			a, err := attestation.Parse(k.Attestation)
+			if err != nil {
+				t.Fatalf("%s: failed to parse attestation: %v", tc.description, err)
+			}
+			stmt, err := a.Verify()
+			if err != nil {
+				t.Fatalf("%s: failed to verify attestation: %v", tc.description, err)
+			}
+			if diff := pretty.Diff(stmt.ExtensionID, "extension-id"); diff != nil {
+				t.Errorf("%s: incorrect extension ID in attestation; -got +want: %s", tc.description, diff)
+			}
+		}
+
+		if err := syncLoad(mgr, id, tc.passphrase); err != nil {
+			t.Fatalf("%s: failed to load key: %v", tc.description, err)
+		}
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		var found bool
+		for _, l := range loaded {
+			if bytes.Equal(l.Blob, pub.Marshal()) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: generated key not loaded", tc.description)
+		}
+	}
+}
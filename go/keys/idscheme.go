@@ -0,0 +1,274 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"golang.org/x/crypto/ssh"
+)
+
+// IDScheme determines how IDs are assigned to newly-configured keys.
+type IDScheme string
+
+const (
+	// IDRandom assigns each key a randomly-generated ID.  The same key
+	// configured on two devices is assigned different IDs.
+	IDRandom IDScheme = "random"
+	// IDFingerprint uses the SHA256 fingerprint of the key's public key
+	// as its ID, so that the same key configured on two devices is
+	// stored under the same ID and reconciles naturally when synced.
+	// The public key of an encrypted private key cannot be determined
+	// without its passphrase; such keys are assigned a random ID.
+	IDFingerprint IDScheme = "fingerprint"
+
+	// DefaultIDScheme is the scheme used if none has been selected.
+	DefaultIDScheme = IDRandom
+)
+
+// IDSchemes lists the available ID schemes, in the order in which they
+// should be presented.
+var IDSchemes = []IDScheme{IDRandom, IDFingerprint}
+
+// Description returns a human-readable description of the scheme.
+func (s IDScheme) Description() string {
+	switch s {
+	case IDRandom:
+		return "Random"
+	case IDFingerprint:
+		return "Public key fingerprint"
+	}
+	return "Unknown"
+}
+
+const (
+	// idSchemeKey is the key under which the selected ID scheme is
+	// stored.  It is synced, so that all devices assign the same ID to
+	// the same key.
+	idSchemeKey = "ids.scheme"
+	// fingerprintPrefix is the prefix of a SHA256 fingerprint; see
+	// ssh.FingerprintSHA256.
+	fingerprintPrefix = "SHA256:"
+)
+
+// fingerprintID returns the ID assigned to a private key under the
+// IDFingerprint scheme, or InvalidID if the public key cannot be determined
+// without a passphrase.
+func fingerprintID(p provider.Provider, pemPrivateKey string) ID {
+	pub := privatePublicKey(p, pemPrivateKey)
+	if pub == nil {
+		return InvalidID
+	}
+	return ID(ssh.FingerprintSHA256(pub))
+}
+
+// isMigrationOf determines if the stored key is a copy of local that was
+// assigned its fingerprint as its ID when migrating to IDFingerprint.  The
+// caller must have determined that both contain the same private key.
+func (s *storedKey) isMigrationOf(local *storedKey) bool {
+	return strings.HasPrefix(string(s.ID), fingerprintPrefix) &&
+		!strings.HasPrefix(string(local.ID), fingerprintPrefix) &&
+		s.Name == local.Name
+}
+
+// IDScheme implements Manager.IDScheme.
+func (m *manager) IDScheme(callback func(scheme IDScheme, err error)) {
+	m.storage.GetItems([]string{idSchemeKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(DefaultIDScheme, help.Errorf(help.StorageFailure, "failed to read ID scheme: %v", err))
+			return
+		}
+		if s, ok := data[idSchemeKey].(string); ok && IDScheme(s) == IDFingerprint {
+			callback(IDFingerprint, nil)
+			return
+		}
+		callback(IDRandom, nil)
+	})
+}
+
+// newKeyID returns the ID for a new key, according to the selected ID
+// scheme.  p is the provider used to load the key. callback is invoked with
+// the ID.
+func (m *manager) newKeyID(p provider.Provider, pemPrivateKey string, callback func(id ID, err error)) {
+	m.IDScheme(func(scheme IDScheme, err error) {
+		if err != nil {
+			callback(InvalidID, err)
+			return
+		}
+
+		if scheme == IDFingerprint {
+			if id := fingerprintID(p, pemPrivateKey); id != InvalidID {
+				m.readKey(id, func(key *storedKey, err error) {
+					if err != nil {
+						callback(InvalidID, help.Errorf(help.StorageFailure, "failed to read key: %v", err))
+						return
+					}
+					if key != nil {
+						callback(InvalidID, fmt.Errorf("key is already configured as %q", key.Name))
+						return
+					}
+					callback(id, nil)
+				})
+				return
+			}
+		}
+
+		id, err := newID(m.providers.Default().Rand())
+		if err != nil {
+			callback(InvalidID, help.Wrap(err, "failed to generate new ID"))
+			return
+		}
+		callback(id, nil)
+	})
+}
+
+// SetIDScheme implements Manager.SetIDScheme.
+func (m *manager) SetIDScheme(scheme IDScheme, callback func(migrated int, err error)) {
+	if scheme != IDRandom && scheme != IDFingerprint {
+		callback(0, fmt.Errorf("unknown ID scheme %q", scheme))
+		return
+	}
+
+	m.writes.run(func(done func()) {
+		callback := func(migrated int, err error) {
+			callback(migrated, err)
+			done()
+		}
+
+		m.storage.Set(map[string]interface{}{idSchemeKey: string(scheme)}, func(err error) {
+			if err != nil {
+				callback(0, help.Errorf(help.StorageFailure, "failed to write ID scheme: %v", err))
+				return
+			}
+			if scheme != IDFingerprint {
+				// Existing random IDs remain unique, so keys
+				// previously assigned a fingerprint keep it.
+				callback(0, nil)
+				return
+			}
+			m.migrateToFingerprints(callback)
+		})
+	})
+}
+
+// migrateToFingerprints assigns configured keys their fingerprint as their
+// ID.  A key is skipped if its public key cannot be determined without a
+// passphrase, if it is loaded (since the loaded key is labelled with its
+// ID), or if another key already has the same fingerprint.  callback is
+// invoked with the number of keys that were migrated.
+func (m *manager) migrateToFingerprints(callback func(migrated int, err error)) {
+	m.readKeys(func(keys []*storedKey, err error) {
+		if err != nil {
+			callback(0, help.Errorf(help.StorageFailure, "failed to read keys: %v", err))
+			return
+		}
+
+		m.Loaded(func(loadedKeys []*LoadedKey, err error) {
+			if err != nil {
+				callback(0, err)
+				return
+			}
+
+			taken := make(map[ID]bool)
+			for _, k := range keys {
+				taken[k.ID] = true
+			}
+			loaded := make(map[ID]bool)
+			for _, l := range loadedKeys {
+				loaded[l.ID()] = true
+			}
+
+			migrated := 0
+			var next func(i int)
+			next = func(i int) {
+				if i == len(keys) {
+					callback(migrated, nil)
+					return
+				}
+
+				k := keys[i]
+				if strings.HasPrefix(string(k.ID), fingerprintPrefix) || loaded[k.ID] {
+					next(i + 1)
+					return
+				}
+				p, err := m.providers.Lookup(k.Provider)
+				if err != nil {
+					next(i + 1)
+					return
+				}
+				id := fingerprintID(p, k.PEMPrivateKey)
+				if id == InvalidID || taken[id] {
+					next(i + 1)
+					return
+				}
+
+				m.changeID(k, id, func(err error) {
+					if err != nil {
+						callback(migrated, err)
+						return
+					}
+					taken[id] = true
+					migrated++
+					next(i + 1)
+				})
+			}
+			next(0)
+		})
+	})
+}
+
+// changeID stores the key under a new ID, and then removes it from its old
+// ID.  callback is invoked when complete.
+func (m *manager) changeID(key *storedKey, id ID, callback func(err error)) {
+	oldID := key.ID
+	moved := *key
+	moved.ID = id
+	moved.Updated = nowMillis()
+	store := m.storeFor(key.DeviceOnly)
+	data := map[string]interface{}{
+		storageKey(id): moved.value(),
+	}
+	checkQuota(store, storageKey(id), data[storageKey(id)], func(err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		store.Set(data, func(err error) {
+			if err != nil {
+				callback(help.Errorf(help.StorageFailure, "failed to write key: %v", err))
+				return
+			}
+			m.deviceOnly[id] = key.DeviceOnly
+			m.removeKey(oldID, func(err error) {
+				if err != nil {
+					callback(help.Errorf(help.StorageFailure, "failed to remove key with previous ID: %v", err))
+					return
+				}
+				if m.canaries != nil && key.Canary {
+					m.canaries.set(oldID, "", false)
+					m.canaries.set(id, key.Name, true)
+				}
+				if m.audit != nil {
+					m.audit.Record(audit.NewEntry("migrate", "options", string(id), true, fmt.Sprintf("changed ID of key %q from %s", key.Name, oldID)), nil)
+				}
+				callback(nil)
+			})
+		})
+	})
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func isFingerprintID(id ID) bool {
+	return strings.HasPrefix(string(id), fingerprintPrefix)
+}
+
+func TestAddWithIDScheme(t *testing.T) {
+	plain, err := ssh.ParsePrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	plainID := ID(ssh.FingerprintSHA256(plain.PublicKey()))
+
+	testcases := []struct {
+		description     string
+		scheme          IDScheme
+		pemPrivateKey   string
+		wantID          ID
+		wantFingerprint bool
+	}{
+		{
+			description:   "random ID",
+			scheme:        IDRandom,
+			pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+		},
+		{
+			description:     "fingerprint ID",
+			scheme:          IDFingerprint,
+			pemPrivateKey:   testdata.ValidPrivateKeyWithoutPassphrase,
+			wantID:          plainID,
+			wantFingerprint: true,
+		},
+		{
+			description:   "encrypted key falls back to random ID",
+			scheme:        IDFingerprint,
+			pemPrivateKey: testdata.ValidPrivateKey,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		if _, err := syncSetIDScheme(mgr, tc.scheme); err != nil {
+			t.Fatalf("%s: failed to set ID scheme: %v", tc.description, err)
+		}
+		if err := syncAdd(mgr, "key", tc.pemPrivateKey, nil); err != nil {
+			t.Errorf("%s: failed to add key: %v", tc.description, err)
+			continue
+		}
+		id, err := findKey(mgr, InvalidID, "key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if got := isFingerprintID(id); got != tc.wantFingerprint {
+			t.Errorf("%s: incorrect ID %s; got fingerprint %t, want %t", tc.description, id, got, tc.wantFingerprint)
+		}
+		if tc.wantID != InvalidID && id != tc.wantID {
+			t.Errorf("%s: incorrect ID; got %s, want %s", tc.description, id, tc.wantID)
+		}
+
+		// Adding the same key again under the fingerprint scheme
+		// would overwrite the first.
+		err = syncAdd(mgr, "again", tc.pemPrivateKey, nil)
+		if gotErr := err != nil; gotErr != tc.wantFingerprint {
+			t.Errorf("%s: incorrect error adding key again; got %v, want error %t", tc.description, err, tc.wantFingerprint)
+		}
+	}
+}
+
+func TestSetIDScheme(t *testing.T) {
+	mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+	scheme, err := syncIDScheme(mgr)
+	if err != nil {
+		t.Fatalf("failed to read ID scheme: %v", err)
+	}
+	if scheme != DefaultIDScheme {
+		t.Errorf("incorrect default ID scheme; got %s, want %s", scheme, DefaultIDScheme)
+	}
+
+	generated := func() string {
+		pemPrivateKey, _, err := provider.GenerateKey(provider.NewSoftware(nil), "")
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		return pemPrivateKey
+	}
+	initial := []struct {
+		name          string
+		pemPrivateKey string
+		opts          *AddOptions
+		load          bool
+	}{
+		{name: "plain", pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase},
+		{name: "device-only", pemPrivateKey: generated(), opts: &AddOptions{DeviceOnly: true}},
+		{name: "encrypted", pemPrivateKey: testdata.ValidPrivateKey},
+		{name: "loaded", pemPrivateKey: generated(), load: true},
+	}
+	for _, k := range initial {
+		if err := syncAdd(mgr, k.name, k.pemPrivateKey, k.opts); err != nil {
+			t.Fatalf("failed to add key %s: %v", k.name, err)
+		}
+		if k.load {
+			id, err := findKey(mgr, InvalidID, k.name)
+			if err != nil {
+				t.Fatalf("failed to find key %s: %v", k.name, err)
+			}
+			if err := syncLoad(mgr, id, ""); err != nil {
+				t.Fatalf("failed to load key %s: %v", k.name, err)
+			}
+		}
+	}
+
+	migrated, err := syncSetIDScheme(mgr, IDFingerprint)
+	if err != nil {
+		t.Fatalf("failed to set ID scheme: %v", err)
+	}
+	if migrated != 2 {
+		t.Errorf("incorrect number of keys migrated; got %d, want 2", migrated)
+	}
+	scheme, err = syncIDScheme(mgr)
+	if err != nil {
+		t.Fatalf("failed to read ID scheme: %v", err)
+	}
+	if scheme != IDFingerprint {
+		t.Errorf("incorrect ID scheme; got %s, want %s", scheme, IDFingerprint)
+	}
+
+	configured, err := syncConfigured(mgr)
+	if err != nil {
+		t.Fatalf("failed to read configured keys: %v", err)
+	}
+	got := make(map[string]bool)
+	for _, k := range configured {
+		got[k.Name] = isFingerprintID(k.ID)
+		if k.Name == "device-only" && !k.DeviceOnly {
+			t.Errorf("migrated key no longer stored on this device only")
+		}
+	}
+	want := map[string]bool{
+		"plain":       true,
+		"device-only": true,
+		"encrypted":   false,
+		"loaded":      false,
+	}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect migrated keys; -got +want: %s", diff)
+	}
+
+	// Migrated keys may still be loaded using their new ID.
+	id, err := findKey(mgr, InvalidID, "plain")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	if err := syncLoad(mgr, id, ""); err != nil {
+		t.Errorf("failed to load migrated key: %v", err)
+	}
+}
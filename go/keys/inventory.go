@@ -0,0 +1,369 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// InventoryItem describes a key for the purposes of an inventory.  It
+// contains only metadata; never the private key.
+type InventoryItem struct {
+	// ID is the ID of the configured key, or InvalidID if the key is
+	// loaded but not configured.
+	ID ID `codec:"id"`
+	// Name is the name of the key, or the comment of a key that is loaded
+	// but not configured.
+	Name string `codec:"name"`
+	// Type is the type of the key (e.g., 'ssh-rsa'), or empty if it
+	// cannot be determined without a passphrase.
+	Type string `codec:"type"`
+	// Bits is the size of the key in bits, or zero if unknown.
+	Bits int `codec:"bits"`
+	// Fingerprint is the SHA256 fingerprint of the key, or empty if
+	// unknown.
+	Fingerprint string `codec:"fingerprint"`
+	// FingerprintMD5 is the legacy MD5 fingerprint of the key, or empty
+	// if unknown.
+	FingerprintMD5 string `codec:"fingerprintMD5"`
+	// Created is the time the key was configured, in milliseconds since
+	// the Unix epoch.  It is zero if unknown.
+	Created int64 `codec:"created"`
+	// LastUsed is the time of the most recent signature using the key
+	// recorded in the audit log, in milliseconds since the Unix epoch.  It
+	// is zero if there is none.
+	LastUsed int64 `codec:"lastUsed"`
+	// UsedBy summarizes the signatures using the key recorded in the
+	// audit log, by requester, most recently used first.
+	UsedBy []*KeyUse `codec:"usedBy"`
+	// Loaded indicates that the key is loaded into the agent.
+	Loaded bool `codec:"loaded"`
+	// DeviceOnly indicates that the key is stored only on this device.
+	DeviceOnly bool `codec:"deviceOnly"`
+	// Protection describes how the key is protected at rest.  It is
+	// empty for keys that are not configured.
+	Protection Protection `codec:"protection"`
+	// Profile is the profile to which the key belongs, or empty for the
+	// default profile.
+	Profile string `codec:"profile"`
+}
+
+// KeyUse summarizes the signatures made using a key on behalf of a single
+// requester.
+type KeyUse struct {
+	// Requester identifies who requested the signatures (e.g., a web
+	// origin, or the extension connected to the agent).
+	Requester string `codec:"requester"`
+	// Page is the page from which the most recent signature was
+	// requested, if known.
+	Page string `codec:"page"`
+	// Count is the number of signatures.
+	Count int `codec:"count"`
+	// Last is the time of the most recent signature, in milliseconds since
+	// the Unix epoch.
+	Last int64 `codec:"last"`
+}
+
+// Inventory implements Manager.Inventory.
+func (m *manager) Inventory(callback func(items []*InventoryItem, err error)) {
+	m.readKeys(func(keys []*storedKey, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read keys: %v", err))
+			return
+		}
+		loaded, err := m.agent.List()
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to list loaded keys: %v", err))
+			return
+		}
+		m.usedBy(func(usedBy map[string][]*KeyUse) {
+			callback(m.inventory(keys, loaded, usedBy), nil)
+		})
+	})
+}
+
+// inventory returns the inventory of the configured and loaded keys.
+// usedBy summarizes the use of each key, indexed by SHA256 fingerprint.
+func (m *manager) inventory(keys []*storedKey, loaded []*agent.Key, usedBy map[string][]*KeyUse) []*InventoryItem {
+	loadedByID := make(map[ID]*agent.Key)
+	var unconfigured []*agent.Key
+	for _, l := range loaded {
+		lk := &LoadedKey{Comment: l.Comment}
+		if id := lk.ID(); id != InvalidID {
+			loadedByID[id] = l
+			continue
+		}
+		unconfigured = append(unconfigured, l)
+	}
+
+	var result []*InventoryItem
+	for _, k := range keys {
+		item := &InventoryItem{
+			ID:         k.ID,
+			Name:       k.Name,
+			Created:    k.Created,
+			DeviceOnly: k.DeviceOnly,
+			Protection: k.protection(),
+			Profile:    k.Profile,
+		}
+		var pub ssh.PublicKey
+		if l := loadedByID[k.ID]; l != nil {
+			item.Loaded = true
+			pub = l
+		} else if k.hasMetadata() {
+			item.Type = k.KeyType
+			item.Bits = k.Bits
+			item.Fingerprint = k.FingerprintSHA256
+			item.FingerprintMD5 = k.FingerprintMD5
+		} else if !k.Encrypted() {
+			pub = m.publicKey(k)
+		}
+		if pub != nil {
+			describePublicKey(item, pub)
+		} else if item.Fingerprint == "" && strings.HasPrefix(string(k.ID), fingerprintPrefix) {
+			item.Fingerprint = string(k.ID)
+		}
+		setUsedBy(item, usedBy[item.Fingerprint])
+		result = append(result, item)
+	}
+	for _, l := range unconfigured {
+		item := &InventoryItem{
+			Name:   l.Comment,
+			Loaded: true,
+		}
+		describePublicKey(item, l)
+		setUsedBy(item, usedBy[item.Fingerprint])
+		result = append(result, item)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// publicKey returns the public key of an unencrypted stored key, or nil if
+// it cannot be parsed.  It is only needed for keys configured by older
+// versions, which do not record metadata.
+func (m *manager) publicKey(k *storedKey) ssh.PublicKey {
+	p, err := m.providers.Lookup(k.Provider)
+	if err != nil {
+		return nil
+	}
+	return privatePublicKey(p, k.PEMPrivateKey)
+}
+
+// describePublicKey fills in the details of item that are derived from its
+// public key.
+func describePublicKey(item *InventoryItem, pub ssh.PublicKey) {
+	item.Type = pub.Type()
+	item.Fingerprint = ssh.FingerprintSHA256(pub)
+	item.FingerprintMD5 = md5FingerprintPrefix + ssh.FingerprintLegacyMD5(pub)
+
+	// Keys listed by the agent must be parsed to access the underlying
+	// key.
+	if _, ok := pub.(ssh.CryptoPublicKey); !ok {
+		parsed, err := ssh.ParsePublicKey(pub.Marshal())
+		if err != nil {
+			return
+		}
+		pub = parsed
+	}
+	cpk, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return
+	}
+	switch k := cpk.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		item.Bits = k.N.BitLen()
+	case *ecdsa.PublicKey:
+		item.Bits = k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		item.Bits = 256
+	}
+}
+
+// setUsedBy records the use of a key in its inventory item.
+func setUsedBy(item *InventoryItem, usedBy []*KeyUse) {
+	item.UsedBy = usedBy
+	if len(usedBy) > 0 {
+		item.LastUsed = usedBy[0].Last
+	}
+}
+
+// usedBy invokes callback with a summary of the signatures recorded in the
+// audit log for each key, indexed by SHA256 fingerprint.  Failures to read
+// the audit log are ignored.
+func (m *manager) usedBy(callback func(usedBy map[string][]*KeyUse)) {
+	if m.audit == nil {
+		callback(make(map[string][]*KeyUse))
+		return
+	}
+	m.audit.Entries(func(entries []*audit.Entry, err error) {
+		callback(summarizeUse(entries))
+	})
+}
+
+// summarizeUse summarizes the signatures in entries for each key, indexed by
+// SHA256 fingerprint.  Refused signatures are ignored.  The requesters of
+// each key are ordered by most recent use.
+func summarizeUse(entries []*audit.Entry) map[string][]*KeyUse {
+	result := make(map[string][]*KeyUse)
+	for _, e := range entries {
+		if e.Action != "sign" || !e.Allowed || e.Key == "" {
+			continue
+		}
+		var use *KeyUse
+		for _, u := range result[e.Key] {
+			if u.Requester == e.Requester {
+				use = u
+				break
+			}
+		}
+		if use == nil {
+			use = &KeyUse{Requester: e.Requester}
+			result[e.Key] = append(result[e.Key], use)
+		}
+		use.Count++
+		if e.Time >= use.Last {
+			use.Last = e.Time
+			use.Page = e.Page
+		}
+	}
+	for _, uses := range result {
+		uses := uses
+		sort.SliceStable(uses, func(i, j int) bool {
+			return uses[i].Last > uses[j].Last
+		})
+	}
+	return result
+}
+
+// InventoryFormat is a format in which an inventory may be exported.
+type InventoryFormat string
+
+const (
+	// InventoryCSV is comma-separated values, with a header row.
+	InventoryCSV InventoryFormat = "csv"
+	// InventoryJSON is a JSON array of objects.
+	InventoryJSON InventoryFormat = "json"
+)
+
+// InventoryFormats lists all inventory formats, in the order in which they
+// should be offered.
+var InventoryFormats = []InventoryFormat{InventoryCSV, InventoryJSON}
+
+// Description returns a human-readable description of the format.
+func (f InventoryFormat) Description() string {
+	switch f {
+	case InventoryCSV:
+		return "CSV"
+	case InventoryJSON:
+		return "JSON"
+	default:
+		return string(f)
+	}
+}
+
+// inventoryColumns are the columns of an exported inventory.
+var inventoryColumns = []string{"id", "name", "type", "bits", "fingerprint", "fingerprintMD5", "created", "lastUsed", "loaded", "deviceOnly", "protection"}
+
+// inventoryRecord is an InventoryItem as exported in JSON.
+type inventoryRecord struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	Type           string `json:"type"`
+	Bits           int    `json:"bits"`
+	Fingerprint    string `json:"fingerprint"`
+	FingerprintMD5 string `json:"fingerprintMD5"`
+	Created        string `json:"created"`
+	LastUsed       string `json:"lastUsed"`
+	Loaded         bool   `json:"loaded"`
+	DeviceOnly     bool   `json:"deviceOnly"`
+	Protection     string `json:"protection"`
+}
+
+// formatMillis formats a time in milliseconds since the Unix epoch as an
+// RFC 3339 timestamp in UTC, or an empty string if it is zero.
+func formatMillis(ms int64) string {
+	if ms == 0 {
+		return ""
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+}
+
+// EncodeInventory encodes items in the specified format.
+func EncodeInventory(items []*InventoryItem, format InventoryFormat) (string, error) {
+	var records []*inventoryRecord
+	for _, i := range items {
+		records = append(records, &inventoryRecord{
+			ID:             string(i.ID),
+			Name:           i.Name,
+			Type:           i.Type,
+			Bits:           i.Bits,
+			Fingerprint:    i.Fingerprint,
+			FingerprintMD5: i.FingerprintMD5,
+			Created:        formatMillis(i.Created),
+			LastUsed:       formatMillis(i.LastUsed),
+			Loaded:         i.Loaded,
+			DeviceOnly:     i.DeviceOnly,
+			Protection:     string(i.Protection),
+		})
+	}
+
+	switch format {
+	case InventoryCSV:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write(inventoryColumns)
+		for _, r := range records {
+			bits := ""
+			if r.Bits > 0 {
+				bits = strconv.Itoa(r.Bits)
+			}
+			w.Write([]string{r.ID, r.Name, r.Type, bits, r.Fingerprint, r.FingerprintMD5, r.Created, r.LastUsed, strconv.FormatBool(r.Loaded), strconv.FormatBool(r.DeviceOnly), r.Protection})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return "", fmt.Errorf("failed to encode inventory: %v", err)
+		}
+		return buf.String(), nil
+	case InventoryJSON:
+		if records == nil {
+			records = []*inventoryRecord{}
+		}
+		b, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to encode inventory: %v", err)
+		}
+		return string(b) + "\n", nil
+	default:
+		return "", fmt.Errorf("unknown inventory format %q", string(format))
+	}
+}
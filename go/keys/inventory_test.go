@@ -0,0 +1,212 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestInventory(t *testing.T) {
+	auditLog := audit.NewLog(fakes.NewMemStorage(), 10)
+	agt := agent.NewKeyring()
+	mgr, err := newTestManager(agt, fakes.NewMemStorage(), fakes.NewMemStorage(), []*initialKey{
+		{
+			Name:          "encrypted-key",
+			PEMPrivateKey: testdata.ValidPrivateKey,
+			Load:          true,
+			Passphrase:    testdata.ValidPrivateKeyPassphrase,
+		},
+		{
+			Name:          "plaintext-key",
+			PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+	mgr.(*manager).audit = auditLog
+
+	// A key loaded directly into the agent, rather than configured.
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	if err := agt.Add(agent.AddedKey{PrivateKey: &priv, Comment: "ephemeral-key"}); err != nil {
+		t.Fatalf("failed to add key to agent: %v", err)
+	}
+	edPub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatalf("failed to get public key: %v", err)
+	}
+
+	// Signatures recorded in the audit log determine when and by whom a
+	// key was used; refused signatures are ignored.
+	plainPub := mustParseBlob(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+	for _, e := range []struct {
+		requester string
+		page      string
+		key       string
+		allowed   bool
+		time      int64
+	}{
+		{"https://example.com", "https://example.com/a", ssh.FingerprintSHA256(plainPub), true, 2000},
+		{audit.NativeHostRequester, "", ssh.FingerprintSHA256(plainPub), true, 2500},
+		{"https://example.com", "https://example.com/b", ssh.FingerprintSHA256(plainPub), true, 3000},
+		{audit.NativeHostRequester, "", ssh.FingerprintSHA256(plainPub), false, 4000},
+	} {
+		entry := audit.NewEntry("sign", e.requester, e.key, e.allowed, "signed")
+		entry.Page = e.page
+		entry.Time = e.time
+		auditLog.Record(entry, nil)
+	}
+
+	items, err := syncInventory(mgr)
+	if err != nil {
+		t.Fatalf("failed to get inventory: %v", err)
+	}
+	for _, i := range items {
+		// IDs and creation times vary between runs.
+		if i.Name != "ephemeral-key" && (i.ID == InvalidID || i.Created == 0) {
+			t.Errorf("%s: missing ID or creation time", i.Name)
+		}
+		i.ID = InvalidID
+		i.Created = 0
+	}
+
+	encPub := mustParseBlob(testdata.ValidPrivateKeyBlob)
+	want := []*InventoryItem{
+		{
+			Name:           "encrypted-key",
+			Type:           "ssh-rsa",
+			Bits:           2048,
+			Fingerprint:    ssh.FingerprintSHA256(encPub),
+			FingerprintMD5: "MD5:" + ssh.FingerprintLegacyMD5(encPub),
+			Loaded:         true,
+			Protection:     ProtectionPassphrase,
+		},
+		{
+			Name:           "ephemeral-key",
+			Type:           "ssh-ed25519",
+			Bits:           256,
+			Fingerprint:    ssh.FingerprintSHA256(edPub),
+			FingerprintMD5: "MD5:" + ssh.FingerprintLegacyMD5(edPub),
+			Loaded:         true,
+		},
+		{
+			Name:           "plaintext-key",
+			Type:           "ssh-rsa",
+			Bits:           2048,
+			Fingerprint:    ssh.FingerprintSHA256(plainPub),
+			FingerprintMD5: "MD5:" + ssh.FingerprintLegacyMD5(plainPub),
+			LastUsed:       3000,
+			UsedBy: []*KeyUse{
+				{Requester: "https://example.com", Page: "https://example.com/b", Count: 2, Last: 3000},
+				{Requester: audit.NativeHostRequester, Count: 1, Last: 2500},
+			},
+			Protection: ProtectionPlaintext,
+		},
+	}
+	if diff := pretty.Diff(items, want); diff != nil {
+		t.Errorf("incorrect inventory; -got +want: %s", diff)
+	}
+}
+
+func TestEncodeInventory(t *testing.T) {
+	items := []*InventoryItem{
+		{
+			ID:             ID("1"),
+			Name:           "work, laptop",
+			Type:           "ssh-rsa",
+			Bits:           2048,
+			Fingerprint:    "SHA256:abc",
+			FingerprintMD5: "MD5:00:11",
+			Created:        1500000000000,
+			LastUsed:       1600000000000,
+			Loaded:         true,
+			Protection:     ProtectionPassphrase,
+		},
+		{
+			Name: "unknown",
+		},
+	}
+
+	testcases := []struct {
+		description string
+		items       []*InventoryItem
+		format      InventoryFormat
+		want        string
+		wantErr     bool
+	}{
+		{
+			description: "CSV",
+			items:       items,
+			format:      InventoryCSV,
+			want: "id,name,type,bits,fingerprint,fingerprintMD5,created,lastUsed,loaded,deviceOnly,protection\n" +
+				"1,\"work, laptop\",ssh-rsa,2048,SHA256:abc,MD5:00:11,2017-07-14T02:40:00Z,2020-09-13T12:26:40Z,true,false,passphrase\n" +
+				",unknown,,,,,,,false,false,\n",
+		},
+		{
+			description: "JSON",
+			items:       items[:1],
+			format:      InventoryJSON,
+			want: `[
+  {
+    "id": "1",
+    "name": "work, laptop",
+    "type": "ssh-rsa",
+    "bits": 2048,
+    "fingerprint": "SHA256:abc",
+    "fingerprintMD5": "MD5:00:11",
+    "created": "2017-07-14T02:40:00Z",
+    "lastUsed": "2020-09-13T12:26:40Z",
+    "loaded": true,
+    "deviceOnly": false,
+    "protection": "passphrase"
+  }
+]
+`,
+		},
+		{
+			description: "empty JSON",
+			format:      InventoryJSON,
+			want:        "[]\n",
+		},
+		{
+			description: "unknown format",
+			items:       items,
+			format:      InventoryFormat("xml"),
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := EncodeInventory(tc.items, tc.format)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %v", tc.description, err, tc.wantErr)
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect result; -got +want: %s", tc.description, diff)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/keytype"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"golang.org/x/crypto/ssh"
+)
+
+// privatePublicKey returns the public key corresponding to a PEM-encoded
+// private key, or nil if it cannot be determined without a passphrase.
+// Keys of an algorithm that cannot be used for signing (see keytype) are
+// not parsed by providers; they are accepted in OpenSSH's format, which
+// records the public key in the clear.
+func privatePublicKey(p provider.Provider, pemPrivateKey string) ssh.PublicKey {
+	if priv, err := p.ParsePrivateKey([]byte(pemPrivateKey), nil); err == nil {
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			return nil
+		}
+		return signer.PublicKey()
+	}
+	pub, _, err := keyformat.InspectOpenSSH([]byte(pemPrivateKey))
+	if err != nil || keytype.Signing(pub.Type()) {
+		return nil
+	}
+	return pub
+}
+
+// checkKeyType returns an error if a private key is of an algorithm that
+// cannot be used for signing.  Such keys are stored and listed, but cannot
+// be loaded or exported.
+func checkKeyType(pemPrivateKey string) error {
+	pub, _, err := keyformat.InspectOpenSSH([]byte(pemPrivateKey))
+	if err != nil || keytype.Signing(pub.Type()) {
+		return nil
+	}
+	return help.Errorf(help.UnsupportedKeyType, "keys of type %q are not supported", pub.Type())
+}
+
+// keyType returns the algorithm of a stored key, or an empty string if it
+// cannot be determined without a passphrase.  As with fingerprints, the
+// recorded metadata is used if present.
+func (m *manager) keyType(k *storedKey) string {
+	if k.hasMetadata() {
+		return k.KeyType
+	}
+	if !k.Encrypted() {
+		if pub := m.publicKey(k); pub != nil {
+			return pub.Type()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestUnsupportedKeyType(t *testing.T) {
+	mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+
+	// Keys of an algorithm that cannot be used for signing are stored,
+	// and listed with the details recorded in the clear.
+	if err := syncAdd(mgr, "", testdata.SecurityKeyPrivateKey, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	configured, err := syncConfigured(mgr)
+	if err != nil {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+	if len(configured) != 1 {
+		t.Fatalf("incorrect number of keys: got %d, want 1", len(configured))
+	}
+	k := configured[0]
+	got := []string{k.Name, k.Type, k.FingerprintSHA256}
+	want := []string{"ed25519-sk SHA256:83ny0sBD", testdata.SecurityKeyPrivateKeyType, testdata.SecurityKeyPrivateKeyFingerprint}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect key details; -got +want: %s", diff)
+	}
+
+	// Its public key can be exported, but it cannot be loaded or its
+	// private key exported.
+	pub, err := syncPublicKey(mgr, k.ID, keyformat.PublicOpenSSH)
+	if err != nil {
+		t.Errorf("failed to export public key: %v", err)
+	} else if !strings.HasPrefix(pub, testdata.SecurityKeyPrivateKeyType+" ") {
+		t.Errorf("incorrect public key: got %q", pub)
+	}
+	if err := syncLoad(mgr, k.ID, ""); help.CodeOf(err) != help.UnsupportedKeyType {
+		t.Errorf("incorrect error loading key: got %v, want code %s", err, help.UnsupportedKeyType)
+	}
+	if _, err := syncExport(mgr, k.ID, "", keyformat.PrivateOpenSSH, "secret"); help.CodeOf(err) != help.UnsupportedKeyType {
+		t.Errorf("incorrect error exporting key: got %v, want code %s", err, help.UnsupportedKeyType)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// State is a stage in the lifecycle of a configured key.
+type State string
+
+const (
+	// StateConfigured indicates that the key is configured, and has not
+	// been loaded since the extension started.  This is the state of any
+	// key not otherwise tracked.
+	StateConfigured State = "configured"
+	// StateLoading indicates that the key is being loaded into the agent.
+	StateLoading State = "loading"
+	// StateLoaded indicates that the key is loaded into the agent.
+	StateLoaded State = "loaded"
+	// StateExpiring indicates that the key is loaded, but will be
+	// unloaded shortly (e.g., because its lifetime is about to end).
+	StateExpiring State = "expiring"
+	// StateUnloaded indicates that the key was loaded, and has since been
+	// unloaded.  It remains configured.
+	StateUnloaded State = "unloaded"
+	// StateRemoved indicates that the key is no longer configured.  It is
+	// the final state; if a key with the same ID is configured again, its
+	// lifecycle starts afresh.
+	StateRemoved State = "removed"
+)
+
+// transitions lists the states to which a key may move from each state.  In
+// addition, a key that fails to load may return from StateLoading to the
+// state it was in before.
+var transitions = map[State][]State{
+	StateConfigured: {StateLoading, StateRemoved},
+	StateLoading:    {StateLoaded},
+	StateLoaded:     {StateLoading, StateExpiring, StateUnloaded, StateRemoved},
+	StateExpiring:   {StateLoading, StateLoaded, StateUnloaded, StateRemoved},
+	StateUnloaded:   {StateLoading, StateRemoved},
+}
+
+// ErrInvalidTransition is returned when a key is asked to move to a state
+// that cannot follow its current state.
+type ErrInvalidTransition struct {
+	// ID is the ID of the key.
+	ID ID
+	// From is the key's current state.
+	From State
+	// To is the requested state.
+	To State
+}
+
+// Error implements the error interface.
+func (e *ErrInvalidTransition) Error() string {
+	return fmt.Sprintf("key %s cannot move from %s to %s", e.ID, e.From, e.To)
+}
+
+// Event describes a key moving from one state to another.
+type Event struct {
+	// ID is the ID of the key.
+	ID ID
+	// From is the state the key moved from.
+	From State
+	// To is the state the key moved to.
+	To State
+}
+
+// Lifecycle tracks the state of each configured key.  Keys move between
+// states only along valid transitions, and subscribers are told about each
+// move, so that everything that depends on a key's state (e.g., the UI,
+// timers and policies) observes the same sequence of states.
+type Lifecycle struct {
+	mu     sync.Mutex
+	states map[ID]State
+	// before contains the state of each key in StateLoading before it
+	// started loading.
+	before map[ID]State
+	// subscribers are invoked each time a key changes state.
+	subscribers []func(e *Event)
+}
+
+// NewLifecycle returns a Lifecycle in which every key is in
+// StateConfigured.
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{
+		states: make(map[ID]State),
+		before: make(map[ID]State),
+	}
+}
+
+// Subscribe registers a callback that is invoked each time a key changes
+// state.  Callbacks are invoked in the order in which the changes were
+// made, without holding the Lifecycle's lock.
+func (l *Lifecycle) Subscribe(callback func(e *Event)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.subscribers = append(l.subscribers, callback)
+}
+
+// State returns the current state of the key with the specified ID.
+func (l *Lifecycle) State(id ID) State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stateLocked(id)
+}
+
+func (l *Lifecycle) stateLocked(id ID) State {
+	if s, ok := l.states[id]; ok {
+		return s
+	}
+	return StateConfigured
+}
+
+// Transition moves the key with the specified ID to state to.  An
+// *ErrInvalidTransition is returned, and the state is unchanged, if to
+// cannot follow the key's current state.
+func (l *Lifecycle) Transition(id ID, to State) error {
+	l.mu.Lock()
+	from := l.stateLocked(id)
+	valid := from == StateLoading && to == l.before[id]
+	for _, s := range transitions[from] {
+		if s == to {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		l.mu.Unlock()
+		return &ErrInvalidTransition{ID: id, From: from, To: to}
+	}
+	if to == StateLoading {
+		l.before[id] = from
+	} else {
+		delete(l.before, id)
+	}
+	if to == StateRemoved || to == StateConfigured {
+		delete(l.states, id)
+	} else {
+		l.states[id] = to
+	}
+	subscribers := l.subscribers
+	l.mu.Unlock()
+
+	e := &Event{ID: id, From: from, To: to}
+	for _, s := range subscribers {
+		s(e)
+	}
+	return nil
+}
+
+// WithLifecycle specifies the Lifecycle in which the manager records the
+// state of keys as they are loaded, unloaded and removed.  By default, key
+// states are not tracked.
+func WithLifecycle(lifecycle *Lifecycle) ManagerOption {
+	return func(m *manager) {
+		m.lifecycle = lifecycle
+	}
+}
+
+// state returns the current state of the key, or StateConfigured if no
+// Lifecycle is in use.
+func (m *manager) state(id ID) State {
+	if m.lifecycle == nil {
+		return StateConfigured
+	}
+	return m.lifecycle.State(id)
+}
+
+// transition moves the key to state to, if a Lifecycle is in use.  Invalid
+// transitions are logged but otherwise ignored; they indicate that the
+// key's state changed outside the manager (e.g., it was unloaded by a
+// client).
+func (m *manager) transition(id ID, to State) {
+	if m.lifecycle == nil {
+		return
+	}
+	if err := m.lifecycle.Transition(id, to); err != nil {
+		log.Printf("Ignoring key state change: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestLifecycle(t *testing.T) {
+	testcases := []struct {
+		description string
+		sequence    []State
+		wantState   State
+		wantErr     error
+		wantEvents  []string
+	}{
+		{
+			description: "load and unload",
+			sequence:    []State{StateLoading, StateLoaded, StateUnloaded, StateLoading, StateLoaded},
+			wantState:   StateLoaded,
+			wantEvents: []string{
+				"configured -> loading",
+				"loading -> loaded",
+				"loaded -> unloaded",
+				"unloaded -> loading",
+				"loading -> loaded",
+			},
+		},
+		{
+			description: "failed load returns to previous state",
+			sequence:    []State{StateLoading, StateLoaded, StateUnloaded, StateLoading, StateUnloaded},
+			wantState:   StateUnloaded,
+			wantEvents: []string{
+				"configured -> loading",
+				"loading -> loaded",
+				"loaded -> unloaded",
+				"unloaded -> loading",
+				"loading -> unloaded",
+			},
+		},
+		{
+			description: "failed load cannot move to another state",
+			sequence:    []State{StateLoading, StateUnloaded},
+			wantState:   StateLoading,
+			wantErr:     &ErrInvalidTransition{ID: "1", From: StateLoading, To: StateUnloaded},
+			wantEvents:  []string{"configured -> loading"},
+		},
+		{
+			description: "expire",
+			sequence:    []State{StateLoading, StateLoaded, StateExpiring, StateUnloaded},
+			wantState:   StateUnloaded,
+			wantEvents: []string{
+				"configured -> loading",
+				"loading -> loaded",
+				"loaded -> expiring",
+				"expiring -> unloaded",
+			},
+		},
+		{
+			description: "remove starts afresh",
+			sequence:    []State{StateLoading, StateLoaded, StateRemoved},
+			wantState:   StateConfigured,
+			wantEvents: []string{
+				"configured -> loading",
+				"loading -> loaded",
+				"loaded -> removed",
+			},
+		},
+		{
+			description: "unload key that is not loaded",
+			sequence:    []State{StateUnloaded},
+			wantState:   StateConfigured,
+			wantErr:     &ErrInvalidTransition{ID: "1", From: StateConfigured, To: StateUnloaded},
+		},
+		{
+			description: "load key that is already loading",
+			sequence:    []State{StateLoading, StateLoading},
+			wantState:   StateLoading,
+			wantErr:     &ErrInvalidTransition{ID: "1", From: StateLoading, To: StateLoading},
+			wantEvents:  []string{"configured -> loading"},
+		},
+	}
+
+	for _, tc := range testcases {
+		l := NewLifecycle()
+		var events []string
+		l.Subscribe(func(e *Event) {
+			events = append(events, fmt.Sprintf("%s -> %s", e.From, e.To))
+		})
+
+		var err error
+		for _, s := range tc.sequence {
+			if err = l.Transition("1", s); err != nil {
+				break
+			}
+		}
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if got := l.State("1"); got != tc.wantState {
+			t.Errorf("%s: incorrect state; got %s, want %s", tc.description, got, tc.wantState)
+		}
+		if diff := pretty.Diff(events, tc.wantEvents); diff != nil {
+			t.Errorf("%s: incorrect events; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestManagerLifecycle(t *testing.T) {
+	l := NewLifecycle()
+	var events []string
+	l.Subscribe(func(e *Event) {
+		events = append(events, fmt.Sprintf("%s -> %s", e.From, e.To))
+	})
+	mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), WithLifecycle(l))
+	if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKey, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	id, err := findKey(mgr, InvalidID, "some-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+
+	if err := syncLoad(mgr, id, "incorrect"); err == nil {
+		t.Errorf("load with incorrect passphrase unexpectedly succeeded")
+	}
+	if err := syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase); err != nil {
+		t.Errorf("failed to load key: %v", err)
+	}
+	if got := l.State(id); got != StateLoaded {
+		t.Errorf("incorrect state after load; got %s, want %s", got, StateLoaded)
+	}
+	loaded, err := syncLoaded(mgr)
+	if err != nil {
+		t.Fatalf("failed to get loaded keys: %v", err)
+	}
+	for _, k := range loaded {
+		if err := syncUnload(mgr, k); err != nil {
+			t.Errorf("failed to unload key: %v", err)
+		}
+	}
+	if err := syncRemove(mgr, id); err != nil {
+		t.Errorf("failed to remove key: %v", err)
+	}
+
+	want := []string{
+		"configured -> loading",
+		"loading -> configured",
+		"configured -> loading",
+		"loading -> loaded",
+		"loaded -> unloaded",
+		"unloaded -> removed",
+	}
+	if diff := pretty.Diff(events, want); diff != nil {
+		t.Errorf("incorrect events; -got +want: %s", diff)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// LoadLifetimeKey is the key under which the lifetime of keys loaded
+	// by the extension is stored.  It is stored on each device.
+	LoadLifetimeKey = "load.lifetime"
+	// MaxLoadLifetime is the longest lifetime that may be selected.
+	MaxLoadLifetime = 24 * time.Hour
+)
+
+// SettingsStore is the subset of PersistentStore used to read and write
+// settings (e.g., the lifetime of loaded keys).
+type SettingsStore = storage.Settings
+
+// LoadLifetimes lists the lifetimes offered to the user, in the order in
+// which they should be presented.  Zero indicates that keys remain loaded
+// until they are unloaded.
+var LoadLifetimes = []time.Duration{0, 15 * time.Minute, time.Hour, 4 * time.Hour, 8 * time.Hour, MaxLoadLifetime}
+
+// ReadLoadLifetime reads the lifetime of keys loaded by the extension from
+// store.  Zero indicates that keys remain loaded until they are unloaded.
+func ReadLoadLifetime(store SettingsStore, callback func(lifetime time.Duration, err error)) {
+	store.GetItems([]string{LoadLifetimeKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(0, fmt.Errorf("failed to read key lifetime: %v", err))
+			return
+		}
+		// Numbers are decoded from storage as float64.
+		secs, _ := data[LoadLifetimeKey].(float64)
+		lifetime := time.Duration(secs) * time.Second
+		if lifetime < 0 || lifetime > MaxLoadLifetime {
+			lifetime = 0
+		}
+		callback(lifetime, nil)
+	})
+}
+
+// WriteLoadLifetime stores the lifetime of keys loaded by the extension in
+// store.  It applies to keys loaded from then on.
+func WriteLoadLifetime(store SettingsStore, lifetime time.Duration, callback func(err error)) {
+	if err := checkLifetime(lifetime); err != nil {
+		callback(err)
+		return
+	}
+	store.Set(map[string]interface{}{LoadLifetimeKey: lifetime.Seconds()}, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to write key lifetime: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// checkLifetime checks that a lifetime requested when loading a key is
+// valid.
+func checkLifetime(lifetime time.Duration) error {
+	if lifetime < 0 || lifetime > MaxLoadLifetime {
+		return fmt.Errorf("key lifetime must be between 0 and %v", MaxLoadLifetime)
+	}
+	return nil
+}
+
+// lifetimeFor invokes callback with the lifetime with which a key is
+// loaded: requested if it is not zero, or otherwise the lifetime selected in
+// local storage.
+func (m *manager) lifetimeFor(requested time.Duration, callback func(lifetime time.Duration, err error)) {
+	if requested > 0 {
+		callback(requested, nil)
+		return
+	}
+	m.loadLifetime(callback)
+}
+
+// loadLifetime reads the lifetime with which keys are loaded from local
+// storage.  callback is invoked exactly once, even if the store misbehaves.
+func (m *manager) loadLifetime(callback func(lifetime time.Duration, err error)) {
+	var lifetime time.Duration
+	var readErr error
+	d := newDispatcher(func() { callback(lifetime, readErr) })
+	op := d.add("read key lifetime")
+	ReadLoadLifetime(m.localStorage, func(l time.Duration, err error) {
+		if !op.start() {
+			return
+		}
+		lifetime, readErr = l, err
+		op.done()
+	})
+	d.wait()
+}
+
+// Expiries reports when keys loaded with a limited lifetime expire.  It is
+// implemented by keyring.Keyring.
+type Expiries interface {
+	// Expiry returns the time at which the loaded key expires.  ok is
+	// false if the key has no lifetime.
+	Expiry(key ssh.PublicKey) (t time.Time, ok bool)
+}
+
+// WithExpiries specifies how the manager learns when loaded keys expire, so
+// that Loaded can report it.  By default, no expiry is reported.
+func WithExpiries(expiries Expiries) ManagerOption {
+	return func(m *manager) {
+		m.expiries = expiries
+	}
+}
+
+// unloadTimers contains the timers that unload keys when their lifetime
+// ends, keyed by ID.  Timers fire on their own goroutine, so access is
+// serialized.
+type unloadTimers struct {
+	mu     sync.Mutex
+	timers map[ID]*time.Timer
+}
+
+// scheduleUnload arranges for the key with the specified ID to be unloaded
+// once lifetime has elapsed, replacing any earlier arrangement (e.g., if the
+// key was loaded again).  A lifetime of zero cancels it.
+func (m *manager) scheduleUnload(id ID, lifetime time.Duration) {
+	u := &m.unloads
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if t, ok := u.timers[id]; ok {
+		t.Stop()
+		delete(u.timers, id)
+	}
+	if lifetime <= 0 {
+		return
+	}
+	if u.timers == nil {
+		u.timers = make(map[ID]*time.Timer)
+	}
+	var t *time.Timer
+	t = time.AfterFunc(lifetime, func() {
+		u.mu.Lock()
+		current := u.timers[id] == t
+		if current {
+			delete(u.timers, id)
+		}
+		u.mu.Unlock()
+		if current {
+			m.unloadExpired(id)
+		}
+	})
+	u.timers[id] = t
+}
+
+// unloadExpired unloads the key with the specified ID once its lifetime has
+// ended.  The agent may already have removed it.
+func (m *manager) unloadExpired(id ID) {
+	loaded, err := m.agent.List()
+	if err != nil {
+		log.Printf("Failed to unload expired key %s: %v", id, err)
+		return
+	}
+	for _, k := range loaded {
+		lk := &LoadedKey{Type: k.Format, Blob: k.Blob, Comment: k.Comment}
+		if lk.ID() != id {
+			continue
+		}
+		m.Unload(lk, func(err error) {
+			if err != nil {
+				log.Printf("Failed to unload expired key %s: %v", id, err)
+				return
+			}
+			log.Printf("Unloaded key %s: its lifetime ended", id)
+		})
+		return
+	}
+	m.transition(id, StateUnloaded)
+}
@@ -0,0 +1,240 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+)
+
+func syncReadLoadLifetime(store PersistentStore) (time.Duration, error) {
+	var lifetime time.Duration
+	errc := make(chan error, 1)
+	ReadLoadLifetime(store, func(l time.Duration, err error) {
+		lifetime = l
+		errc <- err
+	})
+	return lifetime, readErr(errc)
+}
+
+func syncWriteLoadLifetime(store PersistentStore, lifetime time.Duration) error {
+	errc := make(chan error, 1)
+	WriteLoadLifetime(store, lifetime, func(err error) {
+		errc <- err
+	})
+	return readErr(errc)
+}
+
+func TestLoadLifetimeSetting(t *testing.T) {
+	testcases := []struct {
+		description string
+		lifetime    time.Duration
+		want        time.Duration
+		wantErr     bool
+	}{
+		{description: "no lifetime", lifetime: 0, want: 0},
+		{description: "one hour", lifetime: time.Hour, want: time.Hour},
+		{description: "maximum", lifetime: MaxLoadLifetime, want: MaxLoadLifetime},
+		{description: "too long", lifetime: MaxLoadLifetime + time.Second, want: time.Hour, wantErr: true},
+		{description: "negative", lifetime: -time.Second, want: time.Hour, wantErr: true},
+	}
+
+	for _, tc := range testcases {
+		store := fakes.NewMemStorage()
+		if err := syncWriteLoadLifetime(store, time.Hour); err != nil {
+			t.Fatalf("%s: failed to write initial lifetime: %v", tc.description, err)
+		}
+		err := syncWriteLoadLifetime(store, tc.lifetime)
+		if diff := pretty.Diff(err != nil, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error state; -got +want: %s", tc.description, diff)
+		}
+		got, err := syncReadLoadLifetime(store)
+		if err != nil {
+			t.Errorf("%s: failed to read lifetime: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect lifetime; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestLoadWithLifetime(t *testing.T) {
+	testcases := []struct {
+		description string
+		lifetime    time.Duration
+	}{
+		{description: "no lifetime", lifetime: 0},
+		{description: "limited lifetime", lifetime: time.Hour},
+	}
+
+	for _, tc := range testcases {
+		local := fakes.NewMemStorage()
+		if err := syncWriteLoadLifetime(local, tc.lifetime); err != nil {
+			t.Fatalf("%s: failed to write lifetime: %v", tc.description, err)
+		}
+		kr := keyring.New()
+		mgr := NewManager(kr, fakes.NewMemStorage(), local, WithExpiries(kr))
+		if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+
+		// Loading the key again (e.g., to extend its lifetime)
+		// replaces it, rather than loading a second copy.
+		for i := 0; i < 2; i++ {
+			if err := syncLoad(mgr, id, ""); err != nil {
+				t.Fatalf("%s: failed to load key: %v", tc.description, err)
+			}
+		}
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		if len(loaded) != 1 {
+			t.Errorf("%s: incorrect number of loaded keys; got %d, want 1", tc.description, len(loaded))
+			continue
+		}
+
+		if tc.lifetime == 0 {
+			if loaded[0].Expires != 0 {
+				t.Errorf("%s: expiry reported for key without lifetime", tc.description)
+			}
+			continue
+		}
+		expires := time.Unix(0, loaded[0].Expires*int64(time.Millisecond))
+		if d := time.Until(expires); d <= tc.lifetime-time.Minute || d > tc.lifetime {
+			t.Errorf("%s: incorrect expiry; got %v remaining, want about %v", tc.description, d, tc.lifetime)
+		}
+	}
+}
+
+func TestRequestedLifetime(t *testing.T) {
+	testcases := []struct {
+		description string
+		lifetime    time.Duration
+		want        time.Duration
+		wantErr     bool
+	}{
+		{description: "lifetime from settings", lifetime: 0, want: time.Hour},
+		{description: "requested lifetime", lifetime: 15 * time.Minute, want: 15 * time.Minute},
+		{description: "lifetime too long", lifetime: MaxLoadLifetime + time.Hour, wantErr: true},
+		{description: "negative lifetime", lifetime: -time.Minute, wantErr: true},
+	}
+
+	for _, tc := range testcases {
+		local := fakes.NewMemStorage()
+		if err := syncWriteLoadLifetime(local, time.Hour); err != nil {
+			t.Fatalf("%s: failed to write lifetime: %v", tc.description, err)
+		}
+		kr := keyring.New()
+		mgr := NewManager(kr, fakes.NewMemStorage(), local, WithExpiries(kr))
+		if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+
+		err = syncLoadWithLifetime(mgr, id, "", tc.lifetime)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, wantErr %t", tc.description, err, tc.wantErr)
+		}
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		if tc.wantErr {
+			if len(loaded) != 0 {
+				t.Errorf("%s: key loaded despite error", tc.description)
+			}
+			continue
+		}
+		if len(loaded) != 1 {
+			t.Errorf("%s: incorrect number of loaded keys; got %d, want 1", tc.description, len(loaded))
+			continue
+		}
+		expires := time.Unix(0, loaded[0].Expires*int64(time.Millisecond))
+		if d := time.Until(expires); d <= tc.want-time.Minute || d > tc.want {
+			t.Errorf("%s: incorrect expiry; got %v remaining, want about %v", tc.description, d, tc.want)
+		}
+	}
+}
+
+func TestUnloadWhenLifetimeEnds(t *testing.T) {
+	testcases := []struct {
+		description string
+		lifetimes   []time.Duration
+		wantLoaded  bool
+	}{
+		{
+			description: "unloaded when lifetime ends",
+			lifetimes:   []time.Duration{50 * time.Millisecond},
+		},
+		{
+			description: "lifetime restarted when loaded again",
+			lifetimes:   []time.Duration{50 * time.Millisecond, time.Hour},
+			wantLoaded:  true,
+		},
+		{
+			description: "lifetime cancelled when loaded again without one",
+			lifetimes:   []time.Duration{50 * time.Millisecond, 0},
+			wantLoaded:  true,
+		},
+	}
+
+	for _, tc := range testcases {
+		// The agent is not told of lifetimes shorter than a second, so
+		// the manager must unload the key itself.
+		lifecycle := NewLifecycle()
+		mgr := NewManager(keyring.New(), fakes.NewMemStorage(), fakes.NewMemStorage(), WithLifecycle(lifecycle))
+		if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		for _, lifetime := range tc.lifetimes {
+			if err := syncLoadWithLifetime(mgr, id, "", lifetime); err != nil {
+				t.Fatalf("%s: failed to load key: %v", tc.description, err)
+			}
+		}
+
+		time.Sleep(200 * time.Millisecond)
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		if got := len(loaded) == 1; got != tc.wantLoaded {
+			t.Errorf("%s: incorrect loaded; got %t, want %t", tc.description, got, tc.wantLoaded)
+		}
+		wantState := StateUnloaded
+		if tc.wantLoaded {
+			wantState = StateLoaded
+		}
+		if diff := pretty.Diff(lifecycle.State(id), wantState); diff != nil {
+			t.Errorf("%s: incorrect state; -got +want: %s", tc.description, diff)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// LintCode identifies the kind of problem reported by Lint.
+type LintCode string
+
+const (
+	// LintWeakAlgorithm indicates that the key uses an algorithm or size
+	// that is no longer considered secure (DSA, or RSA with fewer than
+	// 2048 bits).
+	LintWeakAlgorithm LintCode = "weak-algorithm"
+	// LintLegacyFormat indicates that the key is in OpenSSL's traditional
+	// PEM format rather than OpenSSH's.  When encrypted, the passphrase
+	// is hashed only once using MD5, which makes guessing it cheap.
+	LintLegacyFormat LintCode = "legacy-format"
+	// LintMissingNewline indicates that the key does not end with a
+	// newline, without which OpenSSH refuses to read it.
+	LintMissingNewline LintCode = "missing-newline"
+	// LintUnencrypted indicates that the key is not protected by a
+	// passphrase.
+	LintUnencrypted LintCode = "unencrypted"
+	// LintOversized indicates that the key is larger than any key in
+	// practical use, or large enough that signing with it is slow.
+	LintOversized LintCode = "oversized"
+)
+
+const (
+	// lintMaxSize is the size of the largest private key accepted without
+	// a finding.  A PEM-encoded 16384-bit RSA key is about 12.5KB.
+	lintMaxSize = 16 * 1024
+	// lintMinRSABits is the size of the smallest RSA key accepted without
+	// a finding.
+	lintMinRSABits = 2048
+	// lintMaxRSABits is the size of the largest RSA key accepted without
+	// a finding.
+	lintMaxRSABits = 8192
+)
+
+// Finding describes a single problem found by Lint.
+type Finding struct {
+	// Code identifies the kind of problem.
+	Code LintCode `codec:"code"`
+	// Message describes the problem in terms suitable for display to the
+	// user.
+	Message string `codec:"message"`
+}
+
+// Lint reports problems with a private key, without requiring its
+// passphrase.  Problems that can only be detected once the key is
+// decrypted (e.g., the size of an encrypted RSA key in OpenSSL's format)
+// are not reported.  Lint never fails; text that is not a private key
+// yields only the findings that apply to any text.
+func Lint(pemPrivateKey string) []*Finding {
+	var result []*Finding
+	add := func(code LintCode, format string, args ...interface{}) {
+		result = append(result, &Finding{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(pemPrivateKey) > lintMaxSize {
+		// Don't attempt to parse it.
+		add(LintOversized, "private key is %d bytes; no supported key is larger than %d bytes", len(pemPrivateKey), lintMaxSize)
+		return result
+	}
+	if strings.TrimSpace(pemPrivateKey) != "" && !strings.HasSuffix(pemPrivateKey, "\n") {
+		add(LintMissingNewline, "private key does not end with a newline; OpenSSH will not read it if copied to a file")
+	}
+
+	if keyformat.IsPPK([]byte(pemPrivateKey)) {
+		if !keyformat.PPKEncrypted([]byte(pemPrivateKey)) {
+			add(LintUnencrypted, "private key is not protected by a passphrase")
+		}
+		return result
+	}
+
+	block, _ := pem.Decode([]byte(pemPrivateKey))
+	if block == nil {
+		return result
+	}
+
+	var pub interface{}
+	encrypted := strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED")
+	switch block.Type {
+	case "OPENSSH PRIVATE KEY":
+		sshPub, _, err := keyformat.InspectOpenSSH([]byte(pemPrivateKey))
+		if err != nil {
+			return result
+		}
+		if cpk, ok := sshPub.(ssh.CryptoPublicKey); ok {
+			pub = cpk.CryptoPublicKey()
+		}
+		// Only keys that are not encrypted can be parsed without a
+		// passphrase.
+		_, err = ssh.ParseRawPrivateKey([]byte(pemPrivateKey))
+		encrypted = err != nil
+	case "RSA PRIVATE KEY", "EC PRIVATE KEY", "DSA PRIVATE KEY":
+		if encrypted {
+			add(LintLegacyFormat, "private key is encrypted in OpenSSL's traditional format, which hashes the passphrase only once; convert it to OpenSSH's format with 'ssh-keygen -p -f <file>'")
+		} else {
+			add(LintLegacyFormat, "private key is in OpenSSL's traditional format; convert it to OpenSSH's format with 'ssh-keygen -p -f <file>'")
+		}
+		if !encrypted {
+			if priv, err := ssh.ParseRawPrivateKey([]byte(pemPrivateKey)); err == nil {
+				pub = publicKeyOf(priv)
+			}
+		}
+	default:
+		return result
+	}
+
+	if _, ok := pub.(*dsa.PublicKey); ok || block.Type == "DSA PRIVATE KEY" {
+		add(LintWeakAlgorithm, "DSA keys are insecure, and are not accepted by current versions of OpenSSH")
+	}
+	if k, ok := pub.(*rsa.PublicKey); ok {
+		if bits := k.N.BitLen(); bits < lintMinRSABits {
+			add(LintWeakAlgorithm, "RSA key has only %d bits; use at least %d", bits, lintMinRSABits)
+		} else if bits > lintMaxRSABits {
+			add(LintOversized, "RSA key has %d bits; signing with keys larger than %d bits is slow", bits, lintMaxRSABits)
+		}
+	}
+
+	if !encrypted {
+		add(LintUnencrypted, "private key is not protected by a passphrase")
+	}
+	return result
+}
+
+// publicKeyOf returns the public key corresponding to a private key, as
+// returned by ssh.ParseRawPrivateKey.
+func publicKeyOf(priv interface{}) interface{} {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case *dsa.PrivateKey:
+		return &k.PublicKey
+	case *ed25519.PrivateKey:
+		return k.Public()
+	}
+	return nil
+}
@@ -17,17 +17,25 @@
 package keys
 
 import (
+	"bytes"
 	"crypto/rand"
-	"encoding/base64"
+	"crypto/x509"
 	"encoding/pem"
-	"errors"
 	"fmt"
-	"log"
+	"io"
 	"math"
 	"math/big"
 	"strings"
+	"time"
 
-	"github.com/gopherjs/gopherjs/js"
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/google/chrome-ssh-agent/go/redact"
+	"github.com/google/chrome-ssh-agent/go/remote"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	"github.com/google/chrome-ssh-agent/go/totp"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 )
@@ -42,73 +50,296 @@ const (
 
 // ConfiguredKey is a key configured for use.
 type ConfiguredKey struct {
-	*js.Object
 	// Id is the unique ID for this key.
-	ID ID `js:"id"`
+	ID ID `codec:"id"`
 	// Name is a name allocated to key.
-	Name string `js:"name"`
+	Name string `codec:"name"`
 	// Encrypted indicates if the key is encrypted and requires a passphrase
 	// to load.
-	Encrypted bool `js:"encrypted"`
+	Encrypted bool `codec:"encrypted"`
+	// DeviceOnly indicates that the key is stored only on this device, and
+	// is not synced to other devices.
+	DeviceOnly bool `codec:"deviceOnly"`
+	// Source describes how the key entered the system.
+	Source Source `codec:"source"`
+	// SourceDetail provides additional detail about the source (e.g.,
+	// the file name or client).
+	SourceDetail string `codec:"sourceDetail"`
+	// Created is the time the key was configured, in milliseconds since
+	// the Unix epoch. It is zero if unknown.
+	Created int64 `codec:"created"`
+	// DeviceName is the name of the device on which the key was
+	// configured.
+	DeviceName string `codec:"deviceName"`
+	// Synced indicates that the key was configured on another device and
+	// delivered by Chrome Sync.
+	Synced bool `codec:"synced"`
+	// Attestation is the attestation produced when the key was generated
+	// (see the attestation package), or empty if there is none.
+	Attestation string `codec:"attestation"`
+	// Canary indicates that the key is a canary; signatures using it are
+	// always refused (see CanaryGuard).
+	Canary bool `codec:"canary"`
+	// Notes are free-form notes about the key (e.g., what it is for),
+	// written in the subset of Markdown supported by the markdown
+	// package.
+	Notes string `codec:"notes"`
+	// Nickname is the name by which the key is listed.  It is the same
+	// as Name unless another key has the same name, in which case it
+	// includes a fragment of the key's fingerprint to tell them apart.
+	Nickname string `codec:"nickname"`
+	// Protection describes how the private key is protected at rest.
+	Protection Protection `codec:"protection"`
+	// Certificate is the OpenSSH certificate loaded along with the key,
+	// in authorized_keys format, or empty if there is none.
+	Certificate string `codec:"certificate"`
+	// CertificateWarnings describes restrictions the certificate places
+	// on its use, and problems that will prevent it from being loaded
+	// (see CertificateWarnings).
+	CertificateWarnings []string `codec:"certificateWarnings"`
+	// TOTP indicates that each signature using the key must be confirmed
+	// with a code from an authenticator app (see TOTPGuard).
+	TOTP bool `codec:"totp"`
+	// Revoked indicates that the key has been revoked; it is unloaded on
+	// every device, and may not be loaded (see SetRevoked).
+	Revoked bool `codec:"revoked"`
+	// LoadOnStartup indicates that the key is loaded when the agent
+	// starts (see SetLoadOnStartup).
+	LoadOnStartup bool `codec:"loadOnStartup"`
+	// Schedule is the schedule on which the key is loaded and unloaded
+	// (see ParseSchedule), or empty if it has none (see SetSchedule).
+	Schedule string `codec:"schedule"`
+	// Profile is the profile to which the key belongs; only clients of
+	// that profile may use it (see ProfileAgent).  It is empty for the
+	// default profile.
+	Profile string `codec:"profile"`
+	// FingerprintSHA256 is the SHA256 fingerprint of the public key, as
+	// printed by 'ssh-add -l', or empty if it cannot be determined
+	// without a passphrase.
+	FingerprintSHA256 string `codec:"fingerprintSHA256"`
+	// FingerprintMD5 is the legacy MD5 fingerprint of the public key
+	// (e.g., 'MD5:12:34:...'), or empty if it cannot be determined
+	// without a passphrase.
+	FingerprintMD5 string `codec:"fingerprintMD5"`
+	// Type is the key's algorithm (e.g., 'ssh-ed25519'), or empty if it
+	// cannot be determined without a passphrase.  Keys of some types can
+	// be configured, but not loaded; see keytype.Signing.
+	Type string `codec:"type"`
+	// Remote is the URL of the signing service holding the key, or empty
+	// if the private key is stored by the extension.  See the remote
+	// package.
+	Remote string `codec:"remote"`
+}
+
+// DisplayName returns the name by which the key should be listed; see
+// Nickname.
+func (k *ConfiguredKey) DisplayName() string {
+	if k.Nickname != "" {
+		return k.Nickname
+	}
+	return k.Name
 }
 
 // LoadedKey is a key loaded into the agent.
 type LoadedKey struct {
-	*js.Object
 	// Type is the type of key loaded in the agent (e.g., 'ssh-rsa').
-	Type string `js:"type"`
-	// blob is the public key material for the loaded key.
-	blob string `js:"blob"`
+	Type string `codec:"type"`
+	// Blob is the public key material for the loaded key.
+	Blob []byte `codec:"blob"`
 	// Comment is a comment for the loaded key.
-	Comment string `js:"comment"`
-}
-
-// SetBlob sets the given public key material for the loaded key.
-func (k *LoadedKey) SetBlob(b []byte) {
-	// Store as base64-encoded string. Two simpler solutions did not appear
-	// to work:
-	// - Storing as a []byte resulted in data not being passed via Chrome's
-	//   messaging.
-	// - Casting to a string resulted in different data being read from the
-	//   field.
-	k.blob = base64.StdEncoding.EncodeToString(b)
-}
-
-// Blob returns the public key material for the loaded key.
-func (k *LoadedKey) Blob() []byte {
-	b, err := base64.StdEncoding.DecodeString(k.blob)
-	if err != nil {
-		log.Printf("failed to decode key blob: %v", err)
-		return nil
-	}
-
-	return b
+	Comment string `codec:"comment"`
+	// Expires is the time at which the key expires and is removed from
+	// the agent, in milliseconds since the epoch.  It is zero if the key
+	// was loaded without a lifetime.
+	Expires int64 `codec:"expires,omitempty"`
+	// FingerprintSHA256 is the SHA256 fingerprint of the public key, as
+	// printed by 'ssh-add -l'.
+	FingerprintSHA256 string `codec:"fingerprintSHA256,omitempty"`
+	// FingerprintMD5 is the legacy MD5 fingerprint of the public key
+	// (e.g., 'MD5:12:34:...').
+	FingerprintMD5 string `codec:"fingerprintMD5,omitempty"`
 }
 
 // ID returns the unique ID corresponding to the key.  If the ID cannot be
 // determined, then InvalidID is returned.
 //
 // The ID for a key loaded into the agent is stored in the Comment field as
-// a string in a particular format.
+// a string in a particular format.  Keys loaded by older versions of the
+// extension may remain loaded after an upgrade, so if the format changes,
+// this must continue to recognize the existing format.
 func (k *LoadedKey) ID() ID {
 	if !strings.HasPrefix(k.Comment, commentPrefix) {
 		return InvalidID
 	}
 
-	return ID(strings.TrimPrefix(k.Comment, commentPrefix))
+	id := strings.TrimPrefix(k.Comment, commentPrefix)
+	if i := strings.Index(id, " "); i >= 0 {
+		id = id[:i]
+	}
+	return ID(id)
+}
+
+// Nickname returns the nickname of the configured key at the time it was
+// loaded (see ConfiguredKey.Nickname), or the comment itself if the key
+// was not loaded from a configured key.  It is empty if the key was loaded
+// by an older version of the extension that did not record the nickname.
+func (k *LoadedKey) Nickname() string {
+	if !strings.HasPrefix(k.Comment, commentPrefix) {
+		return k.Comment
+	}
+
+	rest := strings.TrimPrefix(k.Comment, commentPrefix)
+	if i := strings.Index(rest, " "); i >= 0 {
+		return rest[i+1:]
+	}
+	return ""
+}
+
+// AddOptions are optional settings applied to a key when it is configured.
+type AddOptions struct {
+	// DeviceOnly indicates that the key should be stored only on this
+	// device. It is never synced to other devices.
+	DeviceOnly bool
+	// Provider is the name of the provider used to load the key. If
+	// empty, the default provider is used.
+	Provider string
+	// Source describes how the key entered the system.
+	Source Source
+	// SourceDetail provides additional detail about the source (e.g.,
+	// the file name or client).
+	SourceDetail string
+	// UniqueName indicates that the key must not have the same name as
+	// another configured key.  If it does, an *ErrNameTaken is returned.
+	UniqueName bool
+	// Attestation is the attestation produced when the key was generated,
+	// if any.
+	Attestation string
+	// Protection is the protection with which the key must be stored.
+	// The key is refused if it is not stored as requested.  If
+	// ProtectionDefault, it is implied by the private key.
+	Protection Protection
+	// Certificate is an OpenSSH certificate for the key, in
+	// authorized_keys format.  It is loaded into the agent along with
+	// the key.  It may be empty.
+	Certificate string
+	// TOTPSecret is the base32-encoded secret shared with an
+	// authenticator app.  If set, each signature using the key must be
+	// confirmed with a code generated from it (see TOTPGuard).
+	TOTPSecret string
+	// Notes are free-form notes about the key (see Manager.SetNotes).
+	Notes string
+	// Passphrase decrypts a private key in PuTTY's format (.ppk).  Such
+	// keys are converted to PEM when added, and encrypted again with the
+	// same passphrase.  It is ignored for other keys.
+	Passphrase string
+	// Profile is the profile to which the key belongs (see
+	// Manager.SetProfile).  If empty, it belongs to the default profile.
+	Profile string
+}
+
+// VaultManager manages the vault, which seals the secrets of configured keys
+// in storage with a key derived from a master passphrase.
+type VaultManager interface {
+	// VaultStatus reports whether a master passphrase is set, and if
+	// so, whether the vault has been unlocked during this session.
+	VaultStatus(callback func(enabled, unlocked bool, err error))
+
+	// UnlockVault unlocks the vault for the rest of the session using
+	// the master passphrase, so that keys sealed by it can be loaded,
+	// exported and added.  callback is invoked when complete.
+	UnlockVault(passphrase string, callback func(err error))
+
+	// LockVault locks the vault, so that keys sealed by it cannot be
+	// loaded, exported or added until it is unlocked again with the
+	// master passphrase.  Keys already loaded remain loaded.  callback
+	// is invoked when complete.
+	LockVault(callback func(err error))
+
+	// SetMasterPassphrase sets or changes the master passphrase with
+	// which the private keys (and TOTP secrets) of all configured keys
+	// are sealed in storage, or removes it if passphrase is empty.  current is the
+	// existing master passphrase, if any.  The vault is left unlocked.
+	// callback is invoked when complete.
+	SetMasterPassphrase(current, passphrase string, callback func(err error))
+}
+
+// ScheduleManager manages when configured keys are loaded and unloaded
+// without the user: when the agent starts, and on a weekly schedule.
+type ScheduleManager interface {
+	// SetLoadOnStartup sets whether the key with the specified ID is
+	// loaded when the agent starts.  Keys encrypted with a passphrase
+	// cannot be loaded without the user, so may not be marked.
+	// callback is invoked when complete.
+	SetLoadOnStartup(id ID, enabled bool, callback func(err error))
+
+	// LoadOnStartup loads the keys marked to be loaded when the agent
+	// starts that are not already loaded.  Keys sealed by the vault are
+	// skipped while it is locked; they are loaded if LoadOnStartup is
+	// invoked again once it is unlocked.  A key that fails to load does
+	// not prevent the others from being loaded.  callback is invoked
+	// with the number of keys loaded, and an error describing any
+	// failures.
+	LoadOnStartup(callback func(loaded int, err error))
+
+	// SetSchedule sets the schedule on which the key with the specified
+	// ID is loaded and unloaded (see ParseSchedule); an empty schedule
+	// clears it.  Keys encrypted with a passphrase cannot be loaded
+	// without the user, so may only be scheduled to be unloaded.
+	// callback is invoked when complete.
+	SetSchedule(id ID, schedule string, callback func(err error))
+}
+
+// RevocationManager manages keys that may be listed, but must not be used to
+// sign: canaries, and keys that have been revoked.
+type RevocationManager interface {
+	// SetCanary marks or unmarks the key with the specified ID as a
+	// canary.  A canary key may be loaded and is listed to clients, but
+	// signatures using it are refused.  callback is invoked when
+	// complete.
+	SetCanary(id ID, canary bool, callback func(err error))
+
+	// SetRevoked revokes the key with the specified ID, or clears its
+	// revocation.  A revoked key is unloaded, and may not be loaded
+	// again until the revocation is cleared.  The revocation is synced
+	// along with the key; other devices unload the key once it arrives
+	// (see EnforceRevocations).  callback is invoked when complete.
+	SetRevoked(id ID, revoked bool, callback func(err error))
+}
+
+// PresenceManager manages how the agent responds to the user leaving the
+// device (e.g., locking the screen or going idle).
+type PresenceManager interface {
+	// AutoLockPolicy returns the policy determining when the agent locks
+	// automatically on this device (e.g., when the screen is locked).
+	// callback is invoked with the result.
+	AutoLockPolicy(callback func(policy *AutoLockPolicy, err error))
+
+	// SetAutoLockPolicy sets the policy determining when the agent locks
+	// automatically on this device.  callback is invoked when complete.
+	SetAutoLockPolicy(policy *AutoLockPolicy, callback func(err error))
 }
 
 // Manager provides an API for managing configured keys and loading them into
 // an SSH agent.
 type Manager interface {
+	VaultManager
+	ScheduleManager
+	RevocationManager
+	PresenceManager
+
 	// Configured returns the full set of keys that are configured. The
-	// callback is invoked with the result.
+	// callback is invoked with the result.  If some keys could not be
+	// read, the keys that could be are returned along with an error for
+	// which IsDegraded is true.
 	Configured(callback func(keys []*ConfiguredKey, err error))
 
 	// Add configures a new key.  name is a human-readable name describing
-	// the key, and pemPrivateKey is the PEM-encoded private key.  callback
-	// is invoked when complete.
-	Add(name string, pemPrivateKey string, callback func(err error))
+	// the key, and pemPrivateKey is the PEM-encoded private key.  If name
+	// is blank, one is derived from the key's type, fingerprint and
+	// comment; if a unique name was requested and the derived name is
+	// taken, a similar one is used instead.  opts may be nil to use the
+	// default options.  callback is invoked when complete.
+	Add(name string, pemPrivateKey string, opts *AddOptions, callback func(err error))
 
 	// Remove removes the key with the specified ID.  callback is invoked
 	// when complete.
@@ -125,58 +356,312 @@ type Manager interface {
 	Loaded(callback func(keys []*LoadedKey, err error))
 
 	// Load loads a new key into to the agent, using the passphrase to
-	// decrypt the private key.  callback is invoked when complete.
-	//
-	// NOTE: Unencrypted private keys are not currently supported.
+	// decrypt the private key.  The key is loaded with the lifetime
+	// selected in local storage (see ReadLoadLifetime).  Loading a key
+	// that is already loaded replaces it, restarting its lifetime.
+	// passphrase is ignored for unencrypted keys.  Keys sealed by the
+	// vault may only be loaded once it is unlocked.  callback is invoked
+	// when complete.
 	Load(id ID, passphrase string, callback func(err error))
 
+	// LoadWithLifetime is as Load, but the key is loaded with the
+	// specified lifetime (at most MaxLoadLifetime) instead of the one
+	// selected in local storage, as with 'ssh-add -t'.  Zero selects the
+	// lifetime from local storage.  The manager unloads the key itself
+	// when its lifetime ends, even if the agent does not enforce
+	// lifetimes.
+	LoadWithLifetime(id ID, passphrase string, lifetime time.Duration, callback func(err error))
+
+	// LoadEphemeral loads an unencrypted private key into the agent
+	// without configuring it; the key is not stored, and is lost when
+	// unloaded or when the agent restarts.  name is recorded as the key's
+	// comment.  callback is invoked when complete.
+	LoadEphemeral(name string, pemPrivateKey string, callback func(err error))
+
+	// Export returns the configured key with the specified ID, re-encoded
+	// in the requested format.  passphrase is used to decrypt the stored
+	// private key, and exportPassphrase is used to encrypt the exported
+	// key; it must not be empty.  callback is invoked with the encoded
+	// key.
+	Export(id ID, passphrase string, format keyformat.PrivateFormat, exportPassphrase string, callback func(encoded string, err error))
+
 	// Unload unloads a key from the agent. callback is invoked when
 	// complete.
 	Unload(key *LoadedKey, callback func(err error))
-}
 
-// PersistentStore provides access to underlying storage.  See chrome.Storage
-// for details on the methods; using this interface allows for alternate
-// implementations during testing.
-type PersistentStore interface {
-	// Set stores new data. See chrome.Storage.Set() for details.
-	Set(data map[string]interface{}, callback func(err error))
+	// UnloadByFingerprint unloads the loaded key with the specified
+	// fingerprint, as printed by 'ssh-add -l' (e.g., 'SHA256:...' or
+	// 'MD5:...').  callback is invoked when complete.
+	UnloadByFingerprint(fp string, callback func(err error))
+
+	// UnloadByName unloads the loaded key with the specified name; either
+	// the name of the configured key, or its nickname may be used.  It
+	// fails if more than one loaded key has the name.  callback is
+	// invoked when complete.
+	UnloadByName(name string, callback func(err error))
+
+	// Usage returns the storage space used by configured keys, and the
+	// space remaining.  callback is invoked with the result.
+	Usage(callback func(usage *StorageUsage, err error))
+
+	// IDScheme returns the scheme used to assign IDs to new keys.
+	// callback is invoked with the result.
+	IDScheme(callback func(scheme IDScheme, err error))
+
+	// SetIDScheme selects the scheme used to assign IDs to new keys.
+	// When IDFingerprint is selected, existing keys are also migrated to
+	// it where possible; keys that are encrypted, or currently loaded,
+	// keep their existing ID.  callback is invoked with the number of
+	// keys migrated.
+	SetIDScheme(scheme IDScheme, callback func(migrated int, err error))
+
+	// SetNotes replaces the notes for the key with the specified ID.
+	// Notes are stored with the key, and included when it is exported.
+	// callback is invoked when complete.
+	SetNotes(id ID, notes string, callback func(err error))
+
+	// SetProfile moves the key with the specified ID to the named
+	// profile, or to the default profile if profile is empty.  Only
+	// clients of a key's profile may list it or sign with it (see
+	// ProfileAgent).  callback is invoked when complete.
+	SetProfile(id ID, profile string, callback func(err error))
+
+	// Inventory returns metadata describing each configured key and each
+	// key loaded into the agent, for the purposes of an inventory.  It
+	// never includes private keys.  callback is invoked with the result.
+	Inventory(callback func(items []*InventoryItem, err error))
 
-	// Get gets data from storage. See chrome.Storage.Get() for details.
-	Get(callback func(data map[string]interface{}, err error))
+	// Generate generates a new key pair and configures it, as for Add.
+	// keyType is one of the provider.KeyType constants, and bits
+	// selects the key size; if zero, a default is used (see
+	// provider.Generate).  The private key is encrypted with passphrase
+	// unless it is empty, and never leaves the extension.  callback is
+	// invoked with the public key in authorized_keys format.
+	Generate(name string, keyType string, bits int, passphrase string, callback func(publicKey string, err error))
 
-	// Delete deletes data from storage. See chrome.Storage.Delete() for
-	// details.
-	Delete(keys []string, callback func(err error))
+	// PublicKey returns the public key of the configured key with the
+	// specified ID, encoded in the specified format (e.g., an
+	// authorized_keys line or an RFC 4716 public key file).  No
+	// passphrase is needed; the public key of an encrypted key is
+	// available if it is loaded, or if it is stored in OpenSSH's format.
+	// callback is invoked with the result.
+	PublicKey(id ID, format keyformat.PublicFormat, callback func(encoded string, err error))
+
+	// SelfCheck verifies the integrity of stored keys: that their schema
+	// version is supported, that the recorded fingerprints of up to
+	// sample keys match their private keys, and that the master key
+	// cached for the session (if any) still decrypts the vault.  It
+	// records the current schema version if none is recorded.  callback
+	// is invoked with the result of each check.
+	SelfCheck(sample int, callback func(results []*CheckResult, err error))
+
+	// Upgrade upgrades keys from formats used by older versions of the
+	// extension: it records metadata in configured keys that lack it,
+	// and relabels loaded keys whose comment lacks the key's nickname.
+	// callback is invoked with what was upgraded.
+	Upgrade(callback func(result *UpgradeResult, err error))
 }
 
+// PersistentStore provides access to underlying storage; using this interface
+// allows for alternate implementations during testing.
+type PersistentStore = storage.Area
+
 // NewManager returns a Manager implementation that can manage keys in the
-// supplied agent, and store configured keys in the supplied storage.
-func NewManager(agt agent.Agent, storage PersistentStore) Manager {
-	return &manager{
-		agent:   agt,
-		storage: storage,
+// supplied agent.  Configured keys are stored in syncStorage, unless they
+// are configured to be stored only on this device, in which case they are
+// stored in localStorage.
+func NewManager(agt agent.Agent, syncStorage, localStorage PersistentStore, opts ...ManagerOption) Manager {
+	m := &manager{
+		agent:        agt,
+		storage:      syncStorage,
+		localStorage: localStorage,
+		providers:    provider.NewRegistry(provider.NewSoftware(nil)),
+		lastRead:     make(map[bool][]*storedKey),
+		deviceOnly:   make(map[ID]bool),
+		kdf:          goVaultKDF,
+	}
+	for _, o := range opts {
+		o(m)
+	}
+	return m
+}
+
+// ManagerOption customizes the behavior of a Manager returned by NewManager.
+type ManagerOption func(m *manager)
+
+// WithDeviceName specifies a human-readable name for this device, which is
+// recorded with the keys configured on it.
+func WithDeviceName(name string) ManagerOption {
+	return func(m *manager) {
+		m.deviceName = name
+	}
+}
+
+// WithAuditLog specifies a log in which changes to configured keys are
+// recorded.
+func WithAuditLog(log *audit.Log) ManagerOption {
+	return func(m *manager) {
+		m.audit = log
+	}
+}
+
+// WithProviders specifies the providers used to generate IDs and load keys.
+// By default, only a software provider is available.
+func WithProviders(providers *provider.Registry) ManagerOption {
+	return func(m *manager) {
+		m.providers = providers
+	}
+}
+
+// LoadPolicy determines if a key may be loaded.  It is invoked with the
+// public key corresponding to the key being loaded; callback is invoked with
+// a non-nil error if the key must not be loaded.
+type LoadPolicy func(pub ssh.PublicKey, callback func(err error))
+
+// WithLoadPolicy specifies a policy that is consulted before each key is
+// loaded.  By default, all keys may be loaded.
+func WithLoadPolicy(policy LoadPolicy) ManagerOption {
+	return func(m *manager) {
+		m.loadPolicy = policy
 	}
 }
 
 // manager is an implementation of Manager.
 type manager struct {
-	agent   agent.Agent
-	storage PersistentStore
+	agent        agent.Agent
+	storage      PersistentStore
+	localStorage PersistentStore
+	providers    *provider.Registry
+	deviceName   string
+	extensionID  string
+	audit        *audit.Log
+	loadPolicy   LoadPolicy
+	canaries     *CanaryGuard
+	totp         *TOTPGuard
+	confirm      *ConfirmGuard
+	lifecycle    *Lifecycle
+	throttle     *throttle
+	expiries     Expiries
+	relabeler    Relabeler
+	// writes serializes operations that modify configured keys.
+	writes writeQueue
+	// deviceID is the unique ID for this device, or empty if it has not
+	// yet been read from storage.
+	deviceID string
+	// lastRead contains the keys most recently read from each storage
+	// area, indexed by whether the area is local to this device.  An area
+	// is absent if it has never been read.  See mergeRead.
+	lastRead map[bool][]*storedKey
+	// deviceOnly indexes whether each key known to the manager is stored
+	// only on this device. It allows a single key to be read without
+	// consulting both storage areas; it is only a hint, since keys may be
+	// changed by other devices.
+	deviceOnly map[ID]bool
+	// vault is the master key with which private keys are sealed, or
+	// nil if the vault has not been unlocked during this session.
+	vault *vaultSession
+	// kdf derives the master key from the master passphrase.
+	kdf VaultKDF
+	// unloads unloads keys loaded with a lifetime when it ends.
+	unloads unloadTimers
+}
+
+// storeFor returns the storage in which a key is stored.
+func (m *manager) storeFor(deviceOnly bool) PersistentStore {
+	if deviceOnly {
+		return m.localStorage
+	}
+	return m.storage
 }
 
 // storedKey is the raw object stored in persistent storage for a configured
 // key.
 type storedKey struct {
-	*js.Object
-	ID            ID     `js:"id"`
-	Name          string `js:"name"`
-	PEMPrivateKey string `js:"pemPrivateKey"`
+	ID            ID     `codec:"id"`
+	Name          string `codec:"name"`
+	PEMPrivateKey string `codec:"pemPrivateKey"`
+	// Updated is the time the key was last written, in milliseconds
+	// since the Unix epoch.
+	Updated int64 `codec:"updated"`
+	// DeviceOnly indicates the key is stored only on this device.
+	DeviceOnly bool `codec:"deviceOnly"`
+	// Provider is the name of the provider used to load the key. It is
+	// empty if the default provider is used.
+	Provider string `codec:"provider,omitempty"`
+	// Source describes how the key entered the system.
+	Source Source `codec:"source"`
+	// SourceDetail provides additional detail about the source.
+	SourceDetail string `codec:"sourceDetail"`
+	// Created is the time the key was configured, in milliseconds since
+	// the Unix epoch.
+	Created int64 `codec:"created"`
+	// DeviceID is the ID of the device on which the key was configured.
+	DeviceID string `codec:"deviceId"`
+	// DeviceName is the name of the device on which the key was
+	// configured.
+	DeviceName string `codec:"deviceName"`
+	// Attestation is the attestation produced when the key was generated.
+	Attestation string `codec:"attestation"`
+	// Canary indicates that the key is a canary.
+	Canary bool `codec:"canary,omitempty"`
+	// Notes are free-form notes about the key.
+	Notes string `codec:"notes,omitempty"`
+	// Protection is the protection with which the key was configured.  It
+	// is empty for keys configured by older versions.
+	Protection Protection `codec:"protection,omitempty"`
+	// Certificate is the OpenSSH certificate for the key, in
+	// authorized_keys format.
+	Certificate string `codec:"certificate,omitempty"`
+	// TOTPSecret is the base32-encoded secret from which codes
+	// confirming signatures are generated, or empty if none are needed.
+	TOTPSecret string `codec:"totpSecret,omitempty"`
+	// Revoked indicates that the key has been revoked.
+	Revoked bool `codec:"revoked,omitempty"`
+	// LoadOnStartup indicates that the key is loaded when the agent
+	// starts; see Manager.LoadOnStartup.
+	LoadOnStartup bool `codec:"loadOnStartup,omitempty"`
+	// Schedule is the schedule on which the key is loaded and unloaded;
+	// see Scheduler.
+	Schedule string `codec:"schedule,omitempty"`
+	// Profile is the profile to which the key belongs, or empty for the
+	// default profile.
+	Profile string `codec:"profile,omitempty"`
+	// Metadata is the version of the metadata below, or zero if the key
+	// was configured by an older version that did not record it.  See
+	// describe().
+	Metadata int `codec:"metadata,omitempty"`
+	// KeyEncrypted indicates that the private key is encrypted.
+	KeyEncrypted bool `codec:"encrypted,omitempty"`
+	// KeyType is the type of the key (e.g., 'ssh-rsa'), or empty if it
+	// cannot be determined without a passphrase.
+	KeyType string `codec:"keyType,omitempty"`
+	// Bits is the size of the key in bits, or zero if unknown.
+	Bits int `codec:"bits,omitempty"`
+	// FingerprintSHA256 is the SHA256 fingerprint of the public key, or
+	// empty if it cannot be determined without a passphrase.
+	FingerprintSHA256 string `codec:"fingerprintSHA256,omitempty"`
+	// FingerprintMD5 is the legacy MD5 fingerprint of the public key, or
+	// empty if it cannot be determined without a passphrase.
+	FingerprintMD5 string `codec:"fingerprintMD5,omitempty"`
+	// unknown contains the fields read from storage that are not known
+	// to this version (i.e., written by a newer version).
+	unknown map[string]interface{}
 }
 
-// Encrypted determines if the private key is encrypted. The Proc-Type header
-// contains 'ENCRYPTED' if the key is encrypted. See RFC 1421 Section 4.6.1.1.
+// Encrypted determines if the private key is encrypted.  The metadata
+// recorded when the key was configured is used if present; see
+// pemEncrypted().
 func (s *storedKey) Encrypted() bool {
+	if s.hasMetadata() {
+		return s.KeyEncrypted
+	}
+	return s.pemEncrypted()
+}
+
+// pemEncrypted determines if the private key is encrypted by decoding it. The
+// Proc-Type header contains 'ENCRYPTED' if the key is encrypted. See RFC 1421
+// Section 4.6.1.1.
+func (s *storedKey) pemEncrypted() bool {
 	block, _ := pem.Decode([]byte(s.PEMPrivateKey))
 	if block == nil {
 		// Attempt to handle this gracefully and guess that it isn't
@@ -194,104 +679,275 @@ const (
 	keyPrefix = "key."
 	// commentPrefix is the prefix for the comment included when a
 	// configured key is loaded into the agent. The full comment is of the
-	// form 'chrome-ssh-agent:<id>'.
+	// form 'chrome-ssh-agent:<id> <nickname>'; older versions used
+	// 'chrome-ssh-agent:<id>'.  IDs never contain spaces.  Keys loaded
+	// in the older format are relabelled by Upgrade.  See LoadedKey.ID()
+	// before changing it.
 	commentPrefix = "chrome-ssh-agent:"
 )
 
-// newStoredKey converts a key-value map (e.g., which is supplied when reading
-// from persistent storage) into a storedKey.
-func newStoredKey(m map[string]interface{}) *storedKey {
-	o := js.Global.Get("Object").New()
-	for k, v := range m {
-		o.Set(k, v)
-	}
-	return &storedKey{Object: o}
-}
-
-// readKeys returns all the stored keys from persistent storage. callback is
-// invoked with the returned keys.
+// readKeys returns all the stored keys from persistent storage, including
+// both synced keys and those stored only on this device. callback is invoked
+// with the returned keys.
+//
+// Both storage areas are read concurrently.  callback is invoked exactly
+// once, even if a store invokes its callback more than once.  If a storage
+// area cannot be read, the keys last read from it are returned in its place,
+// along with an *ErrDegraded (see mergeRead).
 func (m *manager) readKeys(callback func(keys []*storedKey, err error)) {
-	m.storage.Get(func(data map[string]interface{}, err error) {
-		if err != nil {
-			callback(nil, fmt.Errorf("failed to read from storage: %v", err))
+	var synced, local []*storedKey
+	var syncErr, localErr error
+	d := newDispatcher(func() {
+		m.mergeRead(synced, syncErr, local, localErr, callback)
+	})
+
+	syncOp := d.add("read synced keys")
+	localOp := d.add("read device-only keys")
+	m.readArea(false, func(keys []*storedKey, err error) {
+		if !syncOp.start() {
 			return
 		}
+		synced, syncErr = keys, err
+		syncOp.done()
+	})
+	m.readArea(true, func(keys []*storedKey, err error) {
+		if !localOp.start() {
+			return
+		}
+		local, localErr = keys, err
+		localOp.done()
+	})
+	d.wait()
+}
 
+// readArea reads the stored keys from a single storage area.  A failed read
+// is retried, up to readAttempts attempts in total.  Malformed keys are
+// quarantined.  callback is invoked exactly once.
+func (m *manager) readArea(deviceOnly bool, callback func(keys []*storedKey, err error)) {
+	store := m.storeFor(deviceOnly)
+	what := "read from storage"
+	if deviceOnly {
+		what = "read from local storage"
+	}
+
+	var attempt func(n int)
+	attempt = func(n int) {
 		var keys []*storedKey
-		for k, v := range data {
-			if !strings.HasPrefix(k, keyPrefix) {
-				continue
+		var readErr error
+		d := newDispatcher(func() {
+			if readErr != nil && n < readAttempts {
+				attempt(n + 1)
+				return
+			}
+			callback(keys, readErr)
+		})
+		op := d.add(what)
+		store.Get(func(data map[string]interface{}, err error) {
+			if !op.start() {
+				return
 			}
+			if err != nil {
+				readErr = fmt.Errorf("failed to %s: %v", what, err)
+				op.done()
+				return
+			}
+			var corrupt []string
+			keys, corrupt = parseStoredKeys(data)
+			if deviceOnly {
+				for _, k := range keys {
+					k.DeviceOnly = true
+				}
+			}
+			m.quarantine(store, data, corrupt, op.done)
+		})
+		d.wait()
+	}
+	attempt(1)
+}
 
-			keys = append(keys, newStoredKey(v.(map[string]interface{})))
+// readKeyFrom reads the key of the specified ID from a single storage area.
+// callback is invoked with the returned key, or nil if it is not present.
+// A malformed key is quarantined, and an error is returned.
+func (m *manager) readKeyFrom(id ID, deviceOnly bool, callback func(key *storedKey, err error)) {
+	store := m.storeFor(deviceOnly)
+	sk := storageKey(id)
+	var key *storedKey
+	var readErr error
+	d := newDispatcher(func() { callback(key, readErr) })
+	op := d.add("read " + sk)
+	store.GetItems([]string{sk}, func(data map[string]interface{}, err error) {
+		if !op.start() {
+			return
+		}
+		if err != nil {
+			readErr = fmt.Errorf("failed to read from storage: %v", err)
+			op.done()
+			return
 		}
-		callback(keys, nil)
+		v, ok := data[sk]
+		if !ok {
+			op.done()
+			return
+		}
+		k, err := newStoredKey(sk, v)
+		if err != nil {
+			m.quarantine(store, data, []string{sk}, func() {
+				readErr = fmt.Errorf("key has been quarantined: %v", err)
+				op.done()
+			})
+			return
+		}
+		k.DeviceOnly = deviceOnly
+		key = k
+		op.done()
 	})
+	d.wait()
 }
 
 // readKey returns the key of the specified ID from persistent storage. callback
-// is invoked with the returned key.
+// is invoked with the returned key, or nil if there is no such key.
+//
+// Only the item for the key is read. The storage area in which the key was
+// last seen is consulted first; the other is consulted only if the key is not
+// found there.
 func (m *manager) readKey(id ID, callback func(key *storedKey, err error)) {
-	m.readKeys(func(keys []*storedKey, err error) {
-		if err != nil {
-			callback(nil, fmt.Errorf("failed to read keys: %v", err))
+	first := m.deviceOnly[id]
+	m.readKeyFrom(id, first, func(key *storedKey, err error) {
+		if err != nil || key != nil {
+			callback(key, err)
 			return
 		}
-
-		for _, k := range keys {
-			if k.ID == id {
-				callback(k, nil)
-				return
+		m.readKeyFrom(id, !first, func(key *storedKey, err error) {
+			if key != nil {
+				m.deviceOnly[id] = key.DeviceOnly
 			}
+			callback(key, err)
+		})
+	})
+}
+
+// newID returns a new randomly-generated ID, using randomness from r.
+func newID(r io.Reader) (ID, error) {
+	i, err := rand.Int(r, big.NewInt(math.MaxInt64))
+	if err != nil {
+		return InvalidID, err
+	}
+	return ID(i.String()), nil
+}
+
+// storageKey returns the key under which the configured key with the
+// specified ID is stored in persistent storage.
+func storageKey(id ID) string {
+	return fmt.Sprintf("%s%s", keyPrefix, id)
+}
+
+// KeysChanged returns true if changes (in the form supplied by
+// chrome.Storage.OnChanged()) includes changes to configured keys.
+func KeysChanged(changes map[string]interface{}) bool {
+	for k := range changes {
+		if strings.HasPrefix(k, keyPrefix) {
+			return true
 		}
+	}
+	return false
+}
 
-		callback(nil, nil)
-	})
+// nowMillis returns the current time in milliseconds since the Unix epoch.
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
 }
 
-// writeKey writes a new key to persistent storage.  callback is invoked when
-// complete.
-func (m *manager) writeKey(name string, pemPrivateKey string, callback func(err error)) {
-	i, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+// writeKey writes a new key to persistent storage.  callback is invoked with
+// the ID of the new key when complete.
+func (m *manager) writeKey(name string, pemPrivateKey string, opts *AddOptions, callback func(id ID, err error)) {
+	p, err := m.providers.Lookup(opts.Provider)
 	if err != nil {
-		callback(fmt.Errorf("failed to generate new ID: %v", err))
+		callback(InvalidID, err)
 		return
 	}
-	id := ID(i.String())
-	storageKey := fmt.Sprintf("%s%s", keyPrefix, id)
-	sk := &storedKey{Object: js.Global.Get("Object").New()}
-	sk.ID = id
-	sk.Name = name
-	sk.PEMPrivateKey = pemPrivateKey
-	data := map[string]interface{}{
-		storageKey: sk,
-	}
-	m.storage.Set(data, func(err error) {
-		callback(err)
+	m.newKeyID(p, pemPrivateKey, func(id ID, err error) {
+		if err != nil {
+			callback(InvalidID, err)
+			return
+		}
+		m.writeKeyWithID(id, name, pemPrivateKey, p, opts, callback)
 	})
 }
 
-// removeKey removes the key with the specified ID from persistent storage.
-// callback is invoked on completion.
-func (m *manager) removeKey(id ID, callback func(err error)) {
-	m.readKeys(func(keys []*storedKey, err error) {
+// writeKeyWithID writes a new key with the specified ID to persistent
+// storage.  callback is invoked with the ID when complete.
+func (m *manager) writeKeyWithID(id ID, name string, pemPrivateKey string, p provider.Provider, opts *AddOptions, callback func(id ID, err error)) {
+	m.device(func(deviceID string, err error) {
 		if err != nil {
-			callback(fmt.Errorf("failed to enumerate keys: %v", err))
+			callback(InvalidID, err)
 			return
 		}
 
-		var storageKeys []string
-		for _, k := range keys {
-			if k.ID == id {
-				storageKeys = append(storageKeys, fmt.Sprintf("%s%s", keyPrefix, k.ID))
+		sk := &storedKey{}
+		sk.ID = id
+		sk.Name = name
+		sk.PEMPrivateKey = pemPrivateKey
+		sk.Updated = nowMillis()
+		sk.DeviceOnly = opts.DeviceOnly
+		if p != m.providers.Default() {
+			sk.Provider = p.Name()
+		}
+		sk.Source = opts.Source
+		sk.SourceDetail = opts.SourceDetail
+		sk.Created = sk.Updated
+		sk.DeviceID = deviceID
+		sk.DeviceName = m.deviceName
+		sk.Attestation = opts.Attestation
+		sk.Protection = opts.Protection
+		sk.Certificate = strings.TrimSpace(opts.Certificate)
+		sk.TOTPSecret = totp.NormalizeSecret(opts.TOTPSecret)
+		sk.Notes = strings.TrimSpace(opts.Notes)
+		sk.Profile = opts.Profile
+		sk.describe(p)
+		m.sealNew(sk, func(err error) {
+			if err != nil {
+				callback(InvalidID, err)
+				return
+			}
+			data := map[string]interface{}{
+				storageKey(id): sk.value(),
 			}
+			store := m.storeFor(opts.DeviceOnly)
+			checkQuota(store, storageKey(id), data[storageKey(id)], func(err error) {
+				if err != nil {
+					callback(InvalidID, err)
+					return
+				}
+				store.Set(data, func(err error) {
+					if err == nil {
+						m.deviceOnly[id] = opts.DeviceOnly
+					}
+					callback(id, err)
+				})
+			})
+		})
+	})
+}
+
+// removeKey removes the key with the specified ID from persistent storage.
+// callback is invoked on completion.
+//
+// The key is deleted from both storage areas directly; deleting a key that is
+// not present is not an error.
+func (m *manager) removeKey(id ID, callback func(err error)) {
+	keys := []string{storageKey(id)}
+	m.storage.Delete(keys, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to delete keys: %v", err))
+			return
 		}
 
-		m.storage.Delete(storageKeys, func(err error) {
+		m.localStorage.Delete(keys, func(err error) {
 			if err != nil {
-				callback(fmt.Errorf("failed to delete keys: %v", err))
+				callback(fmt.Errorf("failed to delete local keys: %v", err))
 				return
 			}
+			delete(m.deviceOnly, id)
 			callback(nil)
 		})
 	})
@@ -300,40 +956,228 @@ func (m *manager) removeKey(id ID, callback func(err error)) {
 // Configured implements Manager.Configured.
 func (m *manager) Configured(callback func(keys []*ConfiguredKey, err error)) {
 	m.readKeys(func(keys []*storedKey, err error) {
-		if err != nil {
+		// If only some keys could be read, return them anyway so
+		// that they can be displayed, rather than an empty list.
+		var readErr error
+		if d, ok := err.(*ErrDegraded); ok {
+			readErr = &ErrDegraded{Err: fmt.Errorf("failed to read keys: %v", d.Err)}
+		} else if err != nil {
 			callback(nil, fmt.Errorf("failed to read keys: %v", err))
 			return
 		}
 
-		var result []*ConfiguredKey
-		for _, k := range keys {
-			c := &ConfiguredKey{Object: js.Global.Get("Object").New()}
-			c.ID = k.ID
-			c.Name = k.Name
-			c.Encrypted = k.Encrypted()
-			result = append(result, c)
-		}
-		callback(result, nil)
+		m.device(func(deviceID string, err error) {
+			if err != nil {
+				callback(nil, err)
+				return
+			}
+
+			var result []*ConfiguredKey
+			for _, k := range keys {
+				c := &ConfiguredKey{}
+				c.ID = k.ID
+				c.Name = k.Name
+				c.Encrypted = k.Encrypted()
+				c.DeviceOnly = k.DeviceOnly
+				c.Source = k.Source
+				c.SourceDetail = k.SourceDetail
+				c.Created = k.Created
+				c.DeviceName = k.DeviceName
+				c.Synced = !k.DeviceOnly && k.DeviceID != "" && k.DeviceID != deviceID
+				c.Attestation = k.Attestation
+				c.Canary = k.Canary
+				c.Revoked = k.Revoked
+				c.LoadOnStartup = k.LoadOnStartup
+				c.Schedule = k.Schedule
+				c.Notes = k.Notes
+				c.Profile = k.Profile
+				c.FingerprintSHA256, c.FingerprintMD5 = m.fingerprints(k)
+				c.Type = m.keyType(k)
+				c.Remote = remoteEndpoint(k)
+				c.Protection = k.protection()
+				c.TOTP = k.TOTPSecret != ""
+				if k.Certificate != "" {
+					c.Certificate = k.Certificate
+					if cert, err := ParseCertificate(k.Certificate); err != nil {
+						c.CertificateWarnings = []string{err.Error()}
+					} else {
+						c.CertificateWarnings = CertificateWarnings(cert, time.Now())
+					}
+				}
+				result = append(result, c)
+			}
+
+			names := nicknames(keys, m.fingerprint)
+			for _, c := range result {
+				c.Nickname = names[c.ID]
+			}
+			callback(result, readErr)
+		})
 	})
 }
 
 // Add implements Manager.Add.
-func (m *manager) Add(name string, pemPrivateKey string, callback func(err error)) {
-	if name == "" {
-		callback(errors.New("name must not be empty"))
+func (m *manager) Add(name string, pemPrivateKey string, opts *AddOptions, callback func(err error)) {
+	if opts == nil {
+		opts = &AddOptions{}
+	}
+	// Errors parsing the private key may quote it.
+	added := callback
+	callback = func(err error) {
+		added(redact.Error(err, opts.Passphrase))
+	}
+
+	// Remote keys can only be loaded by the remote provider.
+	if remote.IsKey([]byte(pemPrivateKey)) {
+		if _, err := remote.Parse([]byte(pemPrivateKey)); err != nil {
+			callback(help.Errorf(help.InvalidPrivateKey, "invalid remote key: %v", err))
+			return
+		}
+		if opts.Provider == "" {
+			o := *opts
+			o.Provider = remote.ProviderName
+			opts = &o
+		}
+	}
+
+	// Keys in PuTTY's format are converted to PEM, in which keys are
+	// stored.
+	var comment string
+	if keyformat.IsPPK([]byte(pemPrivateKey)) {
+		var err error
+		pemPrivateKey, comment, err = convertPPK(pemPrivateKey, opts.Passphrase)
+		if err != nil {
+			callback(err)
+			return
+		}
+	}
+
+	// Repair inconsistent RSA parameters now, rather than producing
+	// baffling signature failures later.  Encrypted keys are checked
+	// when they are loaded.
+	pemPrivateKey, repaired, err := provider.RepairRSA(pemPrivateKey)
+	if err != nil {
+		callback(err)
+		return
+	}
+	// Refuse keys that could never be decrypted, rather than failing
+	// when they are first loaded.
+	if err := provider.CheckCipher(pemPrivateKey); err != nil {
+		callback(err)
 		return
 	}
+	derived := strings.TrimSpace(name) == ""
+	if derived {
+		p, err := m.providers.Lookup(opts.Provider)
+		if err != nil {
+			p = m.providers.Default()
+		}
+		name = defaultName(p, pemPrivateKey, comment)
+	}
+	if repaired && m.audit != nil {
+		m.audit.Record(audit.NewEntry("repair", string(opts.Source), "", true, fmt.Sprintf("recomputed inconsistent RSA parameters of key %q", name)), nil)
+	}
 
-	m.writeKey(name, pemPrivateKey, func(err error) {
+	// Record the protection explicitly, so that a key later found stored
+	// with weaker protection can be refused.
+	protection, err := protectionFor(opts.Protection, (&storedKey{PEMPrivateKey: pemPrivateKey}).pemEncrypted())
+	if err != nil {
 		callback(err)
-	})
+		return
+	}
+	if protection != opts.Protection {
+		o := *opts
+		o.Protection = protection
+		opts = &o
+	}
+
+	// Refuse a certificate that could never be used with the key.  Its
+	// validity period is checked when the key is loaded, since a
+	// certificate may be configured before it becomes valid.
+	if strings.TrimSpace(opts.Certificate) != "" {
+		cert, err := ParseCertificate(opts.Certificate)
+		if err != nil {
+			callback(err)
+			return
+		}
+		var pub ssh.PublicKey
+		if p, err := m.providers.Lookup(opts.Provider); err == nil {
+			if priv, err := p.ParsePrivateKey([]byte(pemPrivateKey), nil); err == nil {
+				if signer, err := ssh.NewSignerFromKey(priv); err == nil {
+					pub = signer.PublicKey()
+				}
+			}
+		}
+		if err := checkCertificateKey(cert, pub); err != nil {
+			callback(err)
+			return
+		}
+	}
+
+	if opts.TOTPSecret != "" {
+		if _, err := totp.ParseSecret(opts.TOTPSecret); err != nil {
+			callback(fmt.Errorf("invalid confirmation code secret: %v", err))
+			return
+		}
+	}
+
+	if err := checkNotes(opts.Notes); err != nil {
+		callback(err)
+		return
+	}
+	if err := CheckProfile(opts.Profile); err != nil {
+		callback(err)
+		return
+	}
+
+	// The name is checked and the key written as a single operation so
+	// that a concurrent Add cannot claim the same name in between.
+	m.writes.runErr(func(callback func(err error)) {
+		m.checkName(name, opts.UniqueName, func(err error) {
+			if taken, ok := err.(*ErrNameTaken); ok && derived {
+				name, err = taken.Suggestion, nil
+			}
+			if err != nil {
+				callback(err)
+				return
+			}
+
+			m.writeKey(name, pemPrivateKey, opts, func(id ID, err error) {
+				if err == nil && m.audit != nil {
+					requester := string(opts.Source)
+					if opts.SourceDetail != "" {
+						requester = fmt.Sprintf("%s:%s", requester, opts.SourceDetail)
+					}
+					m.audit.Record(audit.NewEntry("add", requester, string(id), true, fmt.Sprintf("added key %q (%s)", name, opts.Source.Description())), nil)
+				}
+				callback(err)
+			})
+		})
+	}, callback)
 }
 
 // Remove implements Manager.Remove.
 func (m *manager) Remove(id ID, callback func(err error)) {
-	m.removeKey(id, func(err error) {
-		callback(err)
-	})
+	m.writes.runErr(func(callback func(err error)) {
+		m.removeKey(id, func(err error) {
+			if err == nil && m.canaries != nil {
+				m.canaries.set(id, "", false)
+			}
+			if err == nil && m.totp != nil {
+				m.totp.set(id, "", "")
+			}
+			if err == nil && m.confirm != nil {
+				m.confirm.set(id, "", "")
+			}
+			if err == nil {
+				m.scheduleUnload(id, 0)
+			}
+			if err == nil {
+				m.transition(id, StateRemoved)
+			}
+			callback(err)
+		})
+	}, callback)
 }
 
 // Loaded implements Manager.Loaded.
@@ -346,10 +1190,17 @@ func (m *manager) Loaded(callback func(keys []*LoadedKey, err error)) {
 
 	var result []*LoadedKey
 	for _, l := range loaded {
-		k := &LoadedKey{Object: js.Global.Get("Object").New()}
+		k := &LoadedKey{}
 		k.Type = l.Type()
-		k.SetBlob(l.Marshal())
+		k.Blob = l.Marshal()
 		k.Comment = l.Comment
+		k.FingerprintSHA256 = ssh.FingerprintSHA256(l)
+		k.FingerprintMD5 = md5FingerprintPrefix + ssh.FingerprintLegacyMD5(l)
+		if m.expiries != nil {
+			if t, ok := m.expiries.Expiry(l); ok {
+				k.Expires = t.UnixNano() / int64(time.Millisecond)
+			}
+		}
 		result = append(result, k)
 	}
 
@@ -358,31 +1209,175 @@ func (m *manager) Loaded(callback func(keys []*LoadedKey, err error)) {
 
 // Load implements Manager.Load.
 func (m *manager) Load(id ID, passphrase string, callback func(err error)) {
+	m.LoadWithLifetime(id, passphrase, 0, callback)
+}
+
+// LoadWithLifetime implements Manager.LoadWithLifetime.
+func (m *manager) LoadWithLifetime(id ID, passphrase string, lifetime time.Duration, callback func(err error)) {
+	if err := checkLifetime(lifetime); err != nil {
+		callback(err)
+		return
+	}
+
+	before := m.state(id)
+	m.transition(id, StateLoading)
+	done := callback
+	callback = func(err error) {
+		if err != nil {
+			m.transition(id, before)
+		} else {
+			m.transition(id, StateLoaded)
+		}
+		done(redact.Error(err, passphrase))
+	}
+
 	m.readKey(id, func(key *storedKey, err error) {
 		if err != nil {
-			callback(fmt.Errorf("failed to read key: %v", err))
+			callback(help.Errorf(help.StorageFailure, "failed to read key: %v", err))
 			return
 		}
 
 		if key == nil {
-			callback(fmt.Errorf("failed to find key with ID %s", id))
+			callback(help.Errorf(help.KeyNotFound, "failed to find key with ID %s", id))
+			return
+		}
+		if key.Revoked {
+			callback(help.Errorf(help.KeyRevoked, "key %q has been revoked", key.Name))
+			return
+		}
+		if err := m.unseal(key); err != nil {
+			callback(err)
+			return
+		}
+		if err := key.checkProtection(); err != nil {
+			callback(err)
+			return
+		}
+		if err := m.throttle.check(id); err != nil {
+			callback(err)
 			return
 		}
 
-		var priv interface{}
-		if key.Encrypted() {
-			priv, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(key.PEMPrivateKey), []byte(passphrase))
-		} else {
-			priv, err = ssh.ParseRawPrivateKey([]byte(key.PEMPrivateKey))
+		p, err := m.providers.Lookup(key.Provider)
+		if err != nil {
+			callback(fmt.Errorf("failed to find provider for key: %v", err))
+			return
+		}
+		if err := checkKeyType(key.PEMPrivateKey); err != nil {
+			callback(err)
+			return
+		}
+		priv, err := p.ParsePrivateKey([]byte(key.PEMPrivateKey), []byte(passphrase))
+		m.passphraseResult(id, "load", err == x509.IncorrectPasswordError)
+		if err == x509.IncorrectPasswordError {
+			callback(help.Errorf(help.IncorrectPassphrase, "failed to parse private key: %v", err))
+			return
+		} else if err != nil {
+			callback(help.Errorf(help.InvalidPrivateKey, "failed to parse private key: %v", err))
+			return
+		}
+
+		var cert *ssh.Certificate
+		if key.Certificate != "" {
+			signer, err := ssh.NewSignerFromKey(priv)
+			if err != nil {
+				callback(fmt.Errorf("failed to determine public key: %v", err))
+				return
+			}
+			if cert, err = ParseCertificate(key.Certificate); err != nil {
+				callback(err)
+				return
+			}
+			if err := CheckCertificate(cert, signer.PublicKey(), time.Now()); err != nil {
+				callback(err)
+				return
+			}
 		}
+
+		m.checkLoadPolicy(priv, func(err error) {
+			if err != nil {
+				callback(err)
+				return
+			}
+
+			m.nickname(key, func(nickname string) {
+				m.lifetimeFor(lifetime, func(lifetime time.Duration, err error) {
+					if err != nil {
+						callback(help.Errorf(help.StorageFailure, "%v", err))
+						return
+					}
+
+					m.removeLoaded(priv, cert)
+					err = m.agent.Add(agent.AddedKey{
+						PrivateKey:   priv,
+						Certificate:  cert,
+						Comment:      loadedComment(id, nickname),
+						LifetimeSecs: uint32(lifetime / time.Second),
+					})
+					if err != nil {
+						callback(fmt.Errorf("failed to add key to agent: %v", err))
+						return
+					}
+					if m.canaries != nil {
+						m.canaries.set(id, key.Name, key.Canary)
+					}
+					if m.totp != nil {
+						m.totp.set(id, key.Name, key.TOTPSecret)
+					}
+					if m.confirm != nil {
+						m.confirm.set(id, key.Name, confirmReason(key))
+					}
+					m.scheduleUnload(id, lifetime)
+					callback(nil)
+				})
+			})
+		})
+	})
+}
+
+// removeLoaded removes the key from the agent if it is already loaded, so
+// that loading it again replaces it (restarting its lifetime) rather than
+// adding a second copy.  Keys loaded with a certificate are listed by their
+// certificate.
+func (m *manager) removeLoaded(priv interface{}, cert *ssh.Certificate) {
+	var pub ssh.PublicKey = cert
+	if cert == nil {
+		signer, err := ssh.NewSignerFromKey(priv)
 		if err != nil {
-			callback(fmt.Errorf("failed to parse private key: %v", err))
+			return
+		}
+		pub = signer.PublicKey()
+	}
+
+	loaded, err := m.agent.List()
+	if err != nil {
+		return
+	}
+	for _, l := range loaded {
+		if bytes.Equal(l.Marshal(), pub.Marshal()) {
+			m.agent.Remove(l)
+			return
+		}
+	}
+}
+
+// LoadEphemeral implements Manager.LoadEphemeral.
+func (m *manager) LoadEphemeral(name string, pemPrivateKey string, callback func(err error)) {
+	priv, err := ssh.ParseRawPrivateKey([]byte(pemPrivateKey))
+	if err != nil {
+		callback(help.Errorf(help.InvalidPrivateKey, "failed to parse private key: %v", err))
+		return
+	}
+
+	m.checkLoadPolicy(priv, func(err error) {
+		if err != nil {
+			callback(err)
 			return
 		}
 
 		err = m.agent.Add(agent.AddedKey{
 			PrivateKey: priv,
-			Comment:    fmt.Sprintf("%s%s", commentPrefix, id),
+			Comment:    name,
 		})
 		if err != nil {
 			callback(fmt.Errorf("failed to add key to agent: %v", err))
@@ -392,15 +1387,109 @@ func (m *manager) Load(id ID, passphrase string, callback func(err error)) {
 	})
 }
 
+// Export implements Manager.Export.
+func (m *manager) Export(id ID, passphrase string, format keyformat.PrivateFormat, exportPassphrase string, callback func(encoded string, err error)) {
+	exported := callback
+	callback = func(encoded string, err error) {
+		exported(encoded, redact.Error(err, passphrase, exportPassphrase))
+	}
+	m.readKey(id, func(key *storedKey, err error) {
+		if err != nil {
+			callback("", help.Errorf(help.StorageFailure, "failed to read key: %v", err))
+			return
+		}
+
+		if key == nil {
+			callback("", help.Errorf(help.KeyNotFound, "failed to find key with ID %s", id))
+			return
+		}
+		if err := m.unseal(key); err != nil {
+			callback("", err)
+			return
+		}
+		if err := key.checkProtection(); err != nil {
+			callback("", err)
+			return
+		}
+		if err := m.throttle.check(id); err != nil {
+			callback("", err)
+			return
+		}
+
+		p, err := m.providers.Lookup(key.Provider)
+		if err != nil {
+			callback("", fmt.Errorf("failed to find provider for key: %v", err))
+			return
+		}
+		if key.Provider == remote.ProviderName {
+			callback("", fmt.Errorf("key %q is held by a remote signing service, and cannot be exported", key.Name))
+			return
+		}
+		if err := checkKeyType(key.PEMPrivateKey); err != nil {
+			callback("", err)
+			return
+		}
+		priv, err := p.ParsePrivateKey([]byte(key.PEMPrivateKey), []byte(passphrase))
+		m.passphraseResult(id, "export", err == x509.IncorrectPasswordError)
+		if err == x509.IncorrectPasswordError {
+			callback("", help.Errorf(help.IncorrectPassphrase, "failed to parse private key: %v", err))
+			return
+		} else if err != nil {
+			callback("", help.Errorf(help.InvalidPrivateKey, "failed to parse private key: %v", err))
+			return
+		}
+
+		encoded, err := keyformat.EncodePrivate(priv, key.Name, format, []byte(exportPassphrase), rand.Reader)
+		if err != nil {
+			callback("", fmt.Errorf("failed to export private key: %v", err))
+			return
+		}
+		encoded = appendNotes(encoded, key.Notes)
+		if m.audit != nil {
+			m.audit.Record(audit.NewEntry("export", "options", string(id), true, fmt.Sprintf("exported key %q (%s)", key.Name, format.Description())), nil)
+		}
+		callback(encoded, nil)
+	})
+}
+
+// checkLoadPolicy determines if the supplied private key may be loaded
+// according to the configured load policy.
+func (m *manager) checkLoadPolicy(priv interface{}, callback func(err error)) {
+	if m.loadPolicy == nil {
+		callback(nil)
+		return
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		callback(help.Errorf(help.InvalidPrivateKey, "failed to determine public key: %v", err))
+		return
+	}
+	m.loadPolicy(signer.PublicKey(), func(err error) {
+		if err != nil {
+			if m.audit != nil {
+				m.audit.Record(audit.NewEntry("load", "policy", ssh.FingerprintSHA256(signer.PublicKey()), false, err.Error()), nil)
+			}
+			callback(help.Wrap(err, "key may not be loaded"))
+			return
+		}
+		callback(nil)
+	})
+}
+
 // Unload implements Manager.Unload.
 func (m *manager) Unload(key *LoadedKey, callback func(err error)) {
 	pub := &agent.Key{
 		Format: key.Type,
-		Blob:   key.Blob(),
+		Blob:   key.Blob,
 	}
 	if err := m.agent.Remove(pub); err != nil {
 		callback(fmt.Errorf("failed to unload key: %v", err))
 		return
 	}
+	if id := key.ID(); id != InvalidID {
+		m.scheduleUnload(id, 0)
+		m.transition(id, StateUnloaded)
+	}
 	callback(nil)
 }
@@ -0,0 +1,335 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/entropy"
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// MergePolicy determines how conflicts are resolved when Chrome Sync delivers
+// configured keys that collide with keys configured on this device.
+type MergePolicy int
+
+const (
+	// MergeKeepBoth retains both conflicting keys. The incoming key is
+	// renamed if necessary so the two can be distinguished.
+	MergeKeepBoth MergePolicy = iota
+	// MergePreferNewer retains only the most recently updated key.
+	MergePreferNewer
+	// MergeAsk retains both conflicting keys without modification, leaving
+	// the user to decide which to keep.
+	MergeAsk
+)
+
+// ConflictKind describes why two configured keys conflict.
+type ConflictKind int
+
+const (
+	// IDConflict indicates that an incoming key replaced a different key
+	// with the same ID.
+	IDConflict ConflictKind = iota
+	// FingerprintConflict indicates that an incoming key contains the same
+	// private key as a key that is already configured.
+	FingerprintConflict
+)
+
+// Conflict describes a conflict detected while merging keys delivered by
+// Chrome Sync.
+type Conflict struct {
+	// Kind is the reason the keys conflict.
+	Kind ConflictKind
+	// Local is the ID of the key configured on this device. If the key
+	// was preserved under a new ID, this is the new ID.  If the key was
+	// discarded, this is InvalidID.
+	Local ID
+	// Remote is the ID of the key delivered by Chrome Sync. If the key was
+	// discarded, this is InvalidID.
+	Remote ID
+}
+
+// SyncMerger is a PersistentStore that reconciles configured keys delivered by
+// Chrome Sync with those configured on this device.  Writes made through the
+// SyncMerger are considered local; all other changes reported to OnChanged
+// are assumed to have arrived from another device.
+type SyncMerger struct {
+	store  PersistentStore
+	policy MergePolicy
+	// local contains the storage keys written by this device for which
+	// a change notification has not yet been received.
+	local map[string]bool
+}
+
+// NewSyncMerger returns a SyncMerger that stores data in the supplied store,
+// and resolves conflicts according to the specified policy.
+func NewSyncMerger(store PersistentStore, policy MergePolicy) *SyncMerger {
+	return &SyncMerger{
+		store:  store,
+		policy: policy,
+		local:  make(map[string]bool),
+	}
+}
+
+// Set implements PersistentStore.Set.
+func (s *SyncMerger) Set(data map[string]interface{}, callback func(err error)) {
+	for k := range data {
+		s.local[k] = true
+	}
+	s.store.Set(data, callback)
+}
+
+// Get implements PersistentStore.Get.
+func (s *SyncMerger) Get(callback func(data map[string]interface{}, err error)) {
+	s.store.Get(callback)
+}
+
+// GetItems implements PersistentStore.GetItems.
+func (s *SyncMerger) GetItems(keys []string, callback func(data map[string]interface{}, err error)) {
+	s.store.GetItems(keys, callback)
+}
+
+// Delete implements PersistentStore.Delete.
+func (s *SyncMerger) Delete(keys []string, callback func(err error)) {
+	for _, k := range keys {
+		s.local[k] = true
+	}
+	s.store.Delete(keys, callback)
+}
+
+// BytesInUse implements PersistentStore.BytesInUse.
+func (s *SyncMerger) BytesInUse(keys []string, callback func(bytes int, err error)) {
+	s.store.BytesInUse(keys, callback)
+}
+
+// Quota implements PersistentStore.Quota.
+func (s *SyncMerger) Quota() (total, perItem int) {
+	return s.store.Quota()
+}
+
+// sameKey determines if the stored key contains the same private key as
+// other.  The recorded fingerprints of the public keys are compared if
+// present, since private keys may be sealed by the vault (and are sealed
+// afresh each time the master passphrase changes).
+func (s *storedKey) sameKey(other *storedKey) bool {
+	if strings.TrimSpace(s.PEMPrivateKey) == strings.TrimSpace(other.PEMPrivateKey) {
+		return true
+	}
+	return s.hasMetadata() && other.hasMetadata() &&
+		s.FingerprintSHA256 != "" && s.FingerprintSHA256 == other.FingerprintSHA256
+}
+
+// resealOf determines if the stored key is a copy of local whose private key
+// was only sealed, unsealed or resealed by the vault on another device.
+// Such a change is an update rather than a conflict.  Keys whose
+// fingerprints cannot be determined (i.e., encrypted keys) are assumed to
+// be the same key.
+func (s *storedKey) resealOf(local *storedKey) bool {
+	if s.ID != local.ID || (!sealed(s.PEMPrivateKey) && !sealed(local.PEMPrivateKey)) {
+		return false
+	}
+	if s.hasMetadata() && local.hasMetadata() && s.FingerprintSHA256 != "" && local.FingerprintSHA256 != "" {
+		return s.FingerprintSHA256 == local.FingerprintSHA256
+	}
+	return true
+}
+
+// copyAs returns a copy of the stored key with the specified ID and name.
+func (s *storedKey) copyAs(id ID, name string) *storedKey {
+	c := *s
+	c.ID = id
+	c.Name = name
+	c.DeviceOnly = false
+	return &c
+}
+
+// uniqueName returns a name based on the supplied name that is not contained
+// in taken.  The returned name is added to taken.
+func uniqueName(name string, taken map[string]bool) string {
+	result := name
+	for i := 2; taken[result]; i++ {
+		result = fmt.Sprintf("%s (%d)", name, i)
+	}
+	taken[result] = true
+	return result
+}
+
+// changedValue returns the stored key contained in a change notification
+// for the specified storage key under the specified field (i.e., 'oldValue'
+// or 'newValue'), or nil if the field is absent or malformed.
+func changedValue(key string, change interface{}, field string) *storedKey {
+	c, ok := change.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	v, ok := c[field]
+	if !ok {
+		return nil
+	}
+	sk, err := newStoredKey(key, v)
+	if err != nil {
+		return nil
+	}
+	return sk
+}
+
+// OnChanged reconciles the changes made to persistent storage.  changes is
+// in the form supplied by chrome.Storage.OnChanged().  callback is invoked
+// with the conflicts that were detected once they have been resolved
+// according to the merge policy.
+func (s *SyncMerger) OnChanged(changes map[string]interface{}, callback func(conflicts []*Conflict, err error)) {
+	var replaced [][2]*storedKey // Pairs of (local, remote) keys.
+	added := make(map[ID]*storedKey)
+	for k, change := range changes {
+		if !strings.HasPrefix(k, keyPrefix) {
+			continue
+		}
+		if s.local[k] {
+			delete(s.local, k)
+			continue
+		}
+
+		remote := changedValue(k, change, "newValue")
+		if remote == nil {
+			// Keys removed on another device are not conflicts.
+			continue
+		}
+		if local := changedValue(k, change, "oldValue"); local != nil {
+			if local.PEMPrivateKey != remote.PEMPrivateKey && !remote.resealOf(local) {
+				replaced = append(replaced, [2]*storedKey{local, remote})
+			}
+			continue
+		}
+		added[remote.ID] = remote
+	}
+
+	if len(replaced) == 0 && len(added) == 0 {
+		callback(nil, nil)
+		return
+	}
+
+	s.store.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read from storage: %v", err))
+			return
+		}
+
+		current, _ := parseStoredKeys(data)
+		names := make(map[string]bool)
+		for _, k := range current {
+			names[k.Name] = true
+		}
+
+		var conflicts []*Conflict
+		writes := make(map[string]interface{})
+		var deletes []string
+
+		for _, pair := range replaced {
+			local, remote := pair[0], pair[1]
+			c := &Conflict{Kind: IDConflict, Local: local.ID, Remote: remote.ID}
+			switch s.policy {
+			case MergePreferNewer:
+				if local.Updated > remote.Updated {
+					writes[storageKey(local.ID)] = local.value()
+					c.Remote = InvalidID
+				} else {
+					c.Local = InvalidID
+				}
+			case MergeKeepBoth, MergeAsk:
+				id, err := newID(entropy.Reader)
+				if err != nil {
+					callback(nil, help.Wrap(err, "failed to generate new ID"))
+					return
+				}
+				name := local.Name
+				if s.policy == MergeKeepBoth {
+					name = uniqueName(name, names)
+				}
+				writes[storageKey(id)] = local.copyAs(id, name).value()
+				c.Local = id
+			}
+			conflicts = append(conflicts, c)
+		}
+
+		for _, local := range current {
+			if added[local.ID] != nil {
+				continue
+			}
+			for _, remote := range added {
+				if !local.sameKey(remote) {
+					continue
+				}
+				if remote.isMigrationOf(local) {
+					// Another device assigned the key its
+					// fingerprint as its ID, and removes it
+					// from its previous ID.
+					deletes = append(deletes, storageKey(local.ID))
+					continue
+				}
+
+				c := &Conflict{Kind: FingerprintConflict, Local: local.ID, Remote: remote.ID}
+				switch s.policy {
+				case MergePreferNewer:
+					if local.Updated >= remote.Updated {
+						deletes = append(deletes, storageKey(remote.ID))
+						c.Remote = InvalidID
+					} else {
+						deletes = append(deletes, storageKey(local.ID))
+						c.Local = InvalidID
+					}
+				case MergeKeepBoth:
+					if local.Name == remote.Name {
+						writes[storageKey(remote.ID)] = remote.copyAs(remote.ID, uniqueName(remote.Name, names)).value()
+					}
+				}
+				conflicts = append(conflicts, c)
+			}
+		}
+
+		s.apply(writes, deletes, func(err error) {
+			if err != nil {
+				callback(nil, fmt.Errorf("failed to resolve conflicts: %v", err))
+				return
+			}
+			callback(conflicts, nil)
+		})
+	})
+}
+
+// apply writes and deletes the specified items from storage.  callback is
+// invoked when complete.
+func (s *SyncMerger) apply(writes map[string]interface{}, deletes []string, callback func(err error)) {
+	del := func() {
+		if len(deletes) == 0 {
+			callback(nil)
+			return
+		}
+		s.Delete(deletes, callback)
+	}
+
+	if len(writes) == 0 {
+		del()
+		return
+	}
+	s.Set(writes, func(err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		del()
+	})
+}
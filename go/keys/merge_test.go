@@ -0,0 +1,265 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func storedKeyData(id ID, name, pemPrivateKey string, updated int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id":            string(id),
+		"name":          name,
+		"pemPrivateKey": pemPrivateKey,
+		"updated":       updated,
+	}
+}
+
+func TestSyncMerge(t *testing.T) {
+	local := storedKeyData(ID("1"), "shared", testdata.ValidPrivateKey, 100)
+
+	testcases := []struct {
+		description    string
+		policy         MergePolicy
+		remote         map[string]interface{}
+		changes        map[string]interface{}
+		wantConfigured []string
+		wantConflicts  []ConflictKind
+	}{
+		{
+			description:    "ignore local writes",
+			policy:         MergeKeepBoth,
+			wantConfigured: []string{"shared"},
+		},
+		{
+			description:    "accept non-conflicting key",
+			policy:         MergeKeepBoth,
+			remote:         storedKeyData(ID("2"), "other", testdata.ValidPrivateKeyWithoutPassphrase, 200),
+			wantConfigured: []string{"other", "shared"},
+		},
+		{
+			description:    "keep both on fingerprint conflict",
+			policy:         MergeKeepBoth,
+			remote:         storedKeyData(ID("2"), "shared", testdata.ValidPrivateKey, 200),
+			wantConfigured: []string{"shared", "shared (2)"},
+			wantConflicts:  []ConflictKind{FingerprintConflict},
+		},
+		{
+			description:    "prefer newer on fingerprint conflict",
+			policy:         MergePreferNewer,
+			remote:         storedKeyData(ID("2"), "renamed", testdata.ValidPrivateKey, 200),
+			wantConfigured: []string{"renamed"},
+			wantConflicts:  []ConflictKind{FingerprintConflict},
+		},
+		{
+			description:    "ask on fingerprint conflict",
+			policy:         MergeAsk,
+			remote:         storedKeyData(ID("2"), "shared", testdata.ValidPrivateKey, 200),
+			wantConfigured: []string{"shared", "shared"},
+			wantConflicts:  []ConflictKind{FingerprintConflict},
+		},
+		{
+			description:    "accept key migrated to fingerprint ID",
+			policy:         MergeKeepBoth,
+			remote:         storedKeyData(ID("SHA256:migrated"), "shared", testdata.ValidPrivateKey, 200),
+			wantConfigured: []string{"shared"},
+		},
+		{
+			description: "keep both on ID conflict",
+			policy:      MergeKeepBoth,
+			remote:      storedKeyData(ID("1"), "shared", testdata.ValidPrivateKeyWithoutPassphrase, 200),
+			changes: map[string]interface{}{
+				"key.1": map[string]interface{}{
+					"oldValue": local,
+					"newValue": storedKeyData(ID("1"), "shared", testdata.ValidPrivateKeyWithoutPassphrase, 200),
+				},
+			},
+			wantConfigured: []string{"shared", "shared (2)"},
+			wantConflicts:  []ConflictKind{IDConflict},
+		},
+		{
+			description: "prefer newer on ID conflict",
+			policy:      MergePreferNewer,
+			remote:      storedKeyData(ID("1"), "older", testdata.ValidPrivateKeyWithoutPassphrase, 50),
+			changes: map[string]interface{}{
+				"key.1": map[string]interface{}{
+					"oldValue": local,
+					"newValue": storedKeyData(ID("1"), "older", testdata.ValidPrivateKeyWithoutPassphrase, 50),
+				},
+			},
+			wantConfigured: []string{"shared"},
+			wantConflicts:  []ConflictKind{IDConflict},
+		},
+	}
+
+	for _, tc := range testcases {
+		storage := fakes.NewMemStorage()
+		merger := NewSyncMerger(storage, tc.policy)
+		mgr := NewManager(agent.NewKeyring(), merger, fakes.NewMemStorage())
+
+		// Configure the local key, and deliver the resulting change
+		// notification.  It must not be treated as a conflict.
+		errc := make(chan error, 3)
+		merger.Set(map[string]interface{}{"key.1": local}, func(err error) {
+			errc <- err
+		})
+		localChanges := map[string]interface{}{
+			"key.1": map[string]interface{}{"newValue": local},
+		}
+		merger.OnChanged(localChanges, func(c []*Conflict, err error) {
+			if len(c) > 0 {
+				t.Errorf("%s: local write reported as conflict", tc.description)
+			}
+			errc <- err
+		})
+
+		// Simulate delivery of the remote key by Chrome Sync.
+		changes := tc.changes
+		if tc.remote != nil {
+			k := storageKey(ID(tc.remote["id"].(string)))
+			storage.Set(map[string]interface{}{k: tc.remote}, func(err error) {
+				errc <- err
+			})
+			if changes == nil {
+				changes = map[string]interface{}{
+					k: map[string]interface{}{"newValue": tc.remote},
+				}
+			}
+		}
+		close(errc)
+		for err := range errc {
+			if err != nil {
+				t.Fatalf("%s: failed to initialize storage: %v", tc.description, err)
+			}
+		}
+
+		var conflicts []ConflictKind
+		merger.OnChanged(changes, func(c []*Conflict, err error) {
+			if err != nil {
+				t.Errorf("%s: failed to merge: %v", tc.description, err)
+			}
+			for _, conflict := range c {
+				conflicts = append(conflicts, conflict.Kind)
+			}
+		})
+		if diff := pretty.Diff(conflicts, tc.wantConflicts); diff != nil {
+			t.Errorf("%s: incorrect conflicts; -got +want: %s", tc.description, diff)
+		}
+
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Errorf("%s: failed to get configured keys: %v", tc.description, err)
+		}
+		names := configuredKeyNames(configured)
+		sort.Strings(names)
+		if diff := pretty.Diff(names, tc.wantConfigured); diff != nil {
+			t.Errorf("%s: incorrect configured keys; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+// syncChanges copies the contents of from to to, as Chrome Sync would, and
+// returns the resulting change notification.
+func syncChanges(t *testing.T, from, to PersistentStore) map[string]interface{} {
+	changes := make(map[string]interface{})
+	from.Get(func(fromData map[string]interface{}, err error) {
+		if err != nil {
+			t.Fatalf("failed to read storage: %v", err)
+		}
+		to.Get(func(toData map[string]interface{}, err error) {
+			if err != nil {
+				t.Fatalf("failed to read storage: %v", err)
+			}
+			for k, v := range fromData {
+				change := map[string]interface{}{"newValue": v}
+				if old, ok := toData[k]; ok {
+					if pretty.Diff(old, v) == nil {
+						continue
+					}
+					change["oldValue"] = old
+				}
+				changes[k] = change
+			}
+		})
+		to.Set(fromData, func(err error) {
+			if err != nil {
+				t.Fatalf("failed to write storage: %v", err)
+			}
+		})
+	})
+	return changes
+}
+
+func TestSyncMergeVault(t *testing.T) {
+	storageA, storageB := fakes.NewMemStorage(), fakes.NewMemStorage()
+	mgrA := NewManager(agent.NewKeyring(), NewSyncMerger(storageA, MergeKeepBoth), fakes.NewMemStorage())
+	mergerB := NewSyncMerger(storageB, MergeKeepBoth)
+	mgrB := NewManager(agent.NewKeyring(), mergerB, fakes.NewMemStorage())
+
+	if err := syncAdd(mgrA, "unencrypted", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	if err := syncAdd(mgrA, "encrypted", testdata.ValidPrivateKey, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	syncChanges(t, storageA, storageB)
+
+	// Setting a master passphrase on one device reseals its keys.  The
+	// other device must accept them as updates, rather than writing its
+	// unsealed copies back under new IDs.
+	if err := syncSetMasterPassphrase(mgrA, "", "master"); err != nil {
+		t.Fatalf("failed to set master passphrase: %v", err)
+	}
+	changes := syncChanges(t, storageA, storageB)
+	var conflicts []*Conflict
+	mergerB.OnChanged(changes, func(c []*Conflict, err error) {
+		if err != nil {
+			t.Errorf("failed to merge: %v", err)
+		}
+		conflicts = c
+	})
+	if len(conflicts) > 0 {
+		t.Errorf("resealed keys reported as conflicts: %# v", pretty.Formatter(conflicts))
+	}
+
+	pems := storedPEMs(t, storageB)
+	if diff := pretty.Diff(len(pems), 2); diff != nil {
+		t.Errorf("incorrect number of stored keys; -got +want: %s", diff)
+	}
+	for name, pem := range pems {
+		if !sealed(pem) {
+			t.Errorf("key %s stored unsealed", name)
+		}
+	}
+
+	if err := syncUnlockVault(mgrB, "master"); err != nil {
+		t.Fatalf("failed to unlock vault: %v", err)
+	}
+	configured, err := syncConfigured(mgrB)
+	if err != nil {
+		t.Fatalf("failed to get configured keys: %v", err)
+	}
+	names := configuredKeyNames(configured)
+	sort.Strings(names)
+	if diff := pretty.Diff(names, []string{"encrypted", "unencrypted"}); diff != nil {
+		t.Errorf("incorrect configured keys; -got +want: %s", diff)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"golang.org/x/crypto/ssh"
+)
+
+// metadataVersion is the version of the metadata recorded by describe().  It
+// is incremented if the metadata changes, so that metadata recorded by older
+// versions is ignored.
+const metadataVersion = 1
+
+// describe records metadata describing the private key (whether it is
+// encrypted, and its type, size and fingerprints) in the stored key, so that
+// keys can be listed without decoding every private key.  p is the provider
+// used to parse the key.  The type, size and fingerprints are only recorded
+// if they can be determined without a passphrase.
+func (s *storedKey) describe(p provider.Provider) {
+	s.Metadata = metadataVersion
+	s.KeyEncrypted = s.pemEncrypted()
+	s.KeyType = ""
+	s.Bits = 0
+	s.FingerprintSHA256 = ""
+	s.FingerprintMD5 = ""
+	if s.KeyEncrypted {
+		return
+	}
+
+	pub := privatePublicKey(p, s.PEMPrivateKey)
+	if pub == nil {
+		return
+	}
+	item := &InventoryItem{}
+	describePublicKey(item, pub)
+	s.KeyType = item.Type
+	s.Bits = item.Bits
+	s.FingerprintSHA256 = item.Fingerprint
+	s.FingerprintMD5 = item.FingerprintMD5
+}
+
+// fingerprints returns the SHA256 and legacy MD5 fingerprints of the public
+// key of a stored key.  The recorded metadata is used if present; otherwise
+// they are determined from the private key, unless it is encrypted.  Either
+// is empty if it cannot be determined without a passphrase.
+func (m *manager) fingerprints(k *storedKey) (sha256, md5 string) {
+	if k.hasMetadata() {
+		return k.FingerprintSHA256, k.FingerprintMD5
+	}
+	if !k.Encrypted() {
+		if pub := m.publicKey(k); pub != nil {
+			return ssh.FingerprintSHA256(pub), md5FingerprintPrefix + ssh.FingerprintLegacyMD5(pub)
+		}
+	}
+	if strings.HasPrefix(string(k.ID), fingerprintPrefix) {
+		return string(k.ID), ""
+	}
+	return "", ""
+}
+
+// hasMetadata determines if the stored key contains metadata recorded by
+// describe().  Keys configured by older versions do not; their metadata must
+// be determined from the private key each time it is needed.
+func (s *storedKey) hasMetadata() bool {
+	return s.Metadata == metadataVersion
+}
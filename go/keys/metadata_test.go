@@ -0,0 +1,268 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// metadataSummary summarizes the metadata of a stored key for comparison.
+type metadataSummary struct {
+	Metadata          int
+	KeyEncrypted      bool
+	KeyType           string
+	Bits              int
+	FingerprintSHA256 string
+	FingerprintMD5    string
+}
+
+func TestDescribe(t *testing.T) {
+	pub := mustParseBlob(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+
+	testcases := []struct {
+		description   string
+		pemPrivateKey string
+		want          metadataSummary
+	}{
+		{
+			description:   "unencrypted key",
+			pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			want: metadataSummary{
+				Metadata:          metadataVersion,
+				KeyType:           "ssh-rsa",
+				Bits:              2048,
+				FingerprintSHA256: ssh.FingerprintSHA256(pub),
+				FingerprintMD5:    "MD5:" + ssh.FingerprintLegacyMD5(pub),
+			},
+		},
+		{
+			description:   "encrypted key",
+			pemPrivateKey: testdata.ValidPrivateKey,
+			want: metadataSummary{
+				Metadata:     metadataVersion,
+				KeyEncrypted: true,
+			},
+		},
+		{
+			description:   "invalid key",
+			pemPrivateKey: "bogus",
+			want: metadataSummary{
+				Metadata: metadataVersion,
+			},
+		},
+	}
+
+	p := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage()).(*manager).providers.Default()
+	for _, tc := range testcases {
+		s := &storedKey{PEMPrivateKey: tc.pemPrivateKey}
+		s.describe(p)
+		got := metadataSummary{
+			Metadata:          s.Metadata,
+			KeyEncrypted:      s.KeyEncrypted,
+			KeyType:           s.KeyType,
+			Bits:              s.Bits,
+			FingerprintSHA256: s.FingerprintSHA256,
+			FingerprintMD5:    s.FingerprintMD5,
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect metadata; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+// stripMetadata removes the metadata from all keys in storage, as if they
+// were configured by an older version.
+func stripMetadata(t *testing.T, storage *fakes.MemStorage) {
+	storage.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			t.Fatalf("failed to read storage: %v", err)
+		}
+		for k, v := range data {
+			m, ok := v.(map[string]interface{})
+			if !KeysChanged(map[string]interface{}{k: v}) || !ok {
+				continue
+			}
+			for _, f := range []string{"metadata", "encrypted", "keyType", "bits", "fingerprintSHA256", "fingerprintMD5"} {
+				delete(m, f)
+			}
+			storage.Set(map[string]interface{}{k: m}, func(err error) {
+				if err != nil {
+					t.Fatalf("failed to write %s: %v", k, err)
+				}
+			})
+		}
+	})
+}
+
+func TestFingerprints(t *testing.T) {
+	plaintext := mustParseBlob(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+	encrypted := mustParseBlob(testdata.ValidPrivateKeyBlob)
+
+	mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), []*initialKey{
+		{
+			Name:          "encrypted-key",
+			PEMPrivateKey: testdata.ValidPrivateKey,
+		},
+		{
+			Name:          "plaintext-key",
+			PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+
+	// The fingerprints of an encrypted key are unknown until it is
+	// loaded.
+	configured, err := syncConfigured(mgr)
+	if err != nil {
+		t.Fatalf("failed to get configured keys: %v", err)
+	}
+	got := make(map[string][]string)
+	for _, k := range configured {
+		got[k.Name] = []string{k.FingerprintSHA256, k.FingerprintMD5}
+	}
+	want := map[string][]string{
+		"encrypted-key": []string{"", ""},
+		"plaintext-key": []string{ssh.FingerprintSHA256(plaintext), "MD5:" + ssh.FingerprintLegacyMD5(plaintext)},
+	}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect configured fingerprints; -got +want: %s", diff)
+	}
+
+	id, err := findKey(mgr, InvalidID, "encrypted-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	if err := syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase); err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	loaded, err := syncLoaded(mgr)
+	if err != nil {
+		t.Fatalf("failed to get loaded keys: %v", err)
+	}
+	if len(loaded) != 1 {
+		t.Fatalf("incorrect number of loaded keys; got %d, want 1", len(loaded))
+	}
+	gotLoaded := []string{loaded[0].FingerprintSHA256, loaded[0].FingerprintMD5}
+	wantLoaded := []string{ssh.FingerprintSHA256(encrypted), "MD5:" + ssh.FingerprintLegacyMD5(encrypted)}
+	if diff := pretty.Diff(gotLoaded, wantLoaded); diff != nil {
+		t.Errorf("incorrect loaded fingerprints; -got +want: %s", diff)
+	}
+}
+
+func TestMetadataFromOlderVersions(t *testing.T) {
+	initial := []*initialKey{
+		{
+			Name:          "encrypted-key",
+			PEMPrivateKey: testdata.ValidPrivateKey,
+		},
+		{
+			Name:          "plaintext-key",
+			PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+		},
+	}
+
+	// Keys configured by an older version are described by decoding
+	// them, with the same result as reading the recorded metadata.
+	var configured [][]*ConfiguredKey
+	var inventories [][]*InventoryItem
+	for _, strip := range []bool{false, true} {
+		storage := fakes.NewMemStorage()
+		mgr, err := newTestManager(agent.NewKeyring(), storage, fakes.NewMemStorage(), initial)
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		if strip {
+			stripMetadata(t, storage)
+		}
+
+		c, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("failed to get configured keys: %v", err)
+		}
+		items, err := syncInventory(mgr)
+		if err != nil {
+			t.Fatalf("failed to get inventory: %v", err)
+		}
+		// IDs and creation times vary between managers, and keys are
+		// listed in the order in which they are stored.
+		sort.Slice(c, func(i, j int) bool { return c[i].Name < c[j].Name })
+		for _, k := range c {
+			k.ID, k.Created = InvalidID, 0
+		}
+		for _, i := range items {
+			i.ID, i.Created = InvalidID, 0
+		}
+		configured = append(configured, c)
+		inventories = append(inventories, items)
+	}
+
+	if diff := pretty.Diff(configured[1], configured[0]); diff != nil {
+		t.Errorf("incorrect configured keys without metadata; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(inventories[1], inventories[0]); diff != nil {
+		t.Errorf("incorrect inventory without metadata; -got +want: %s", diff)
+	}
+}
+
+func TestListingUsesMetadata(t *testing.T) {
+	storage := fakes.NewMemStorage()
+	mgr, err := newTestManager(agent.NewKeyring(), storage, fakes.NewMemStorage(), []*initialKey{
+		{
+			Name:          "plaintext-key",
+			PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+
+	// Replace the recorded metadata behind the manager's back; listings
+	// report it rather than decoding the private key.
+	storage.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			t.Fatalf("failed to read storage: %v", err)
+		}
+		for k, v := range data {
+			m, ok := v.(map[string]interface{})
+			if !KeysChanged(map[string]interface{}{k: v}) || !ok {
+				continue
+			}
+			m["keyType"] = "recorded-type"
+			m["bits"] = 1234
+			storage.Set(map[string]interface{}{k: m}, func(err error) {
+				if err != nil {
+					t.Fatalf("failed to write %s: %v", k, err)
+				}
+			})
+		}
+	})
+
+	items, err := syncInventory(mgr)
+	if err != nil {
+		t.Fatalf("failed to get inventory: %v", err)
+	}
+	if len(items) != 1 || items[0].Type != "recorded-type" || items[0].Bits != 1234 {
+		t.Errorf("inventory does not use recorded metadata; got %s", pretty.Sprint(items))
+	}
+}
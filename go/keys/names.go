@@ -0,0 +1,273 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/keytype"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// MaxNameLength is the maximum length of a key's name, in characters.
+	MaxNameLength = 100
+	// nicknameFingerprintLength is the number of characters of a key's
+	// fingerprint included in its nickname when its name is shared with
+	// another key.
+	nicknameFingerprintLength = 8
+)
+
+// ValidateName checks that name may be used as the name of a key.
+func ValidateName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return help.Errorf(help.InvalidName, "name must not be empty")
+	}
+	if !utf8.ValidString(name) {
+		return help.Errorf(help.InvalidName, "name must be valid UTF-8")
+	}
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		return help.Errorf(help.InvalidName, "name must be at most %d characters", MaxNameLength)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return help.Errorf(help.InvalidName, "name must not contain control characters")
+		}
+	}
+	return nil
+}
+
+// ErrNameTaken is returned when adding a key whose name is already used by
+// another key, and a unique name was requested.
+type ErrNameTaken struct {
+	// Name is the name that was requested.
+	Name string
+	// Suggestion is a similar name that is not yet taken.
+	Suggestion string
+}
+
+// Error implements the error interface.
+func (e *ErrNameTaken) Error() string {
+	return fmt.Sprintf("a key named %q already exists", e.Name)
+}
+
+// HelpCode implements help.Coder.
+func (e *ErrNameTaken) HelpCode() help.Code {
+	return help.NameTaken
+}
+
+// normalizeName returns the form of a name used to detect duplicates. Names
+// that differ only in case or surrounding whitespace are easily confused, and
+// are considered duplicates.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// nameTaken determines if name duplicates any of the names in taken.
+func nameTaken(name string, taken []string) bool {
+	n := normalizeName(name)
+	for _, t := range taken {
+		if normalizeName(t) == n {
+			return true
+		}
+	}
+	return false
+}
+
+// SuggestName returns a name similar to name (e.g., 'name (2)') that does
+// not duplicate any of the names in taken.  name is returned unchanged if it
+// is not taken.
+func SuggestName(name string, taken []string) string {
+	result := name
+	for i := 2; nameTaken(result, taken); i++ {
+		result = fmt.Sprintf("%s (%d)", strings.TrimSpace(name), i)
+	}
+	return result
+}
+
+// checkName validates name, and if unique is true ensures that it does not
+// duplicate the name of a configured key.  callback is invoked with the
+// result.
+func (m *manager) checkName(name string, unique bool, callback func(err error)) {
+	if err := ValidateName(name); err != nil {
+		callback(err)
+		return
+	}
+	if !unique {
+		callback(nil)
+		return
+	}
+
+	m.readKeys(func(keys []*storedKey, err error) {
+		if err != nil {
+			callback(help.Errorf(help.StorageFailure, "failed to read keys: %v", err))
+			return
+		}
+
+		var taken []string
+		for _, k := range keys {
+			taken = append(taken, k.Name)
+		}
+		if nameTaken(name, taken) {
+			callback(&ErrNameTaken{Name: name, Suggestion: SuggestName(name, taken)})
+			return
+		}
+		callback(nil)
+	})
+}
+
+// nicknames returns the nickname of each of the specified keys, indexed by
+// ID.  The nickname of a key is its name, unless another key has the same
+// name; in that case a fragment of the key's fingerprint is appended (e.g.,
+// 'name (SHA256:abcd1234)') so that the keys can be told apart wherever they
+// are listed.  fingerprint returns the SHA256 fingerprint of a key, or an
+// empty string if it cannot be determined (e.g., the key is encrypted); such
+// keys are distinguished by their ID instead.
+func nicknames(keys []*storedKey, fingerprint func(key *storedKey) string) map[ID]string {
+	byName := make(map[string][]*storedKey)
+	for _, k := range keys {
+		n := normalizeName(k.Name)
+		byName[n] = append(byName[n], k)
+	}
+
+	result := make(map[ID]string)
+	for _, same := range byName {
+		if len(same) == 1 {
+			result[same[0].ID] = same[0].Name
+			continue
+		}
+
+		// The same private key may be configured more than once, in
+		// which case the fingerprint does not tell the copies apart.
+		suffixes := make(map[ID]string)
+		used := make(map[string]int)
+		for _, k := range same {
+			s := fingerprintFragment(fingerprint(k))
+			suffixes[k.ID] = s
+			used[s]++
+		}
+		for _, k := range same {
+			s := suffixes[k.ID]
+			if s == "" || used[s] > 1 {
+				s = fmt.Sprintf("ID %s", k.ID)
+			}
+			result[k.ID] = fmt.Sprintf("%s (%s)", strings.TrimSpace(k.Name), s)
+		}
+	}
+	return result
+}
+
+// fingerprintFragment returns the leading part of a SHA256 fingerprint
+// (e.g., 'SHA256:abcd1234'), or an empty string if fingerprint is empty.
+func fingerprintFragment(fingerprint string) string {
+	if !strings.HasPrefix(fingerprint, fingerprintPrefix) {
+		return ""
+	}
+	hash := strings.TrimPrefix(fingerprint, fingerprintPrefix)
+	if len(hash) > nicknameFingerprintLength {
+		hash = hash[:nicknameFingerprintLength]
+	}
+	return fingerprintPrefix + hash
+}
+
+// fingerprint returns the SHA256 fingerprint of a stored key, or an empty
+// string if it cannot be determined without a passphrase.
+func (m *manager) fingerprint(key *storedKey) string {
+	if strings.HasPrefix(string(key.ID), fingerprintPrefix) {
+		return string(key.ID)
+	}
+	if key.hasMetadata() {
+		return key.FingerprintSHA256
+	}
+	p, err := m.providers.Lookup(key.Provider)
+	if err != nil {
+		return ""
+	}
+	return string(fingerprintID(p, key.PEMPrivateKey))
+}
+
+// nickname returns the nickname of key among all configured keys.  If the
+// configured keys cannot be read, the key's name is used.
+func (m *manager) nickname(key *storedKey, callback func(nickname string)) {
+	m.readKeys(func(keys []*storedKey, err error) {
+		if err != nil {
+			callback(key.Name)
+			return
+		}
+		if n, ok := nicknames(keys, m.fingerprint)[key.ID]; ok {
+			callback(n)
+			return
+		}
+		callback(key.Name)
+	})
+}
+
+// pemKeyTypes maps the PEM block types of legacy private keys to the short
+// name of their key type.  These are used to name encrypted keys, whose
+// public key cannot be determined without a passphrase.
+var pemKeyTypes = map[string]string{
+	"RSA PRIVATE KEY": "rsa",
+	"DSA PRIVATE KEY": "dsa",
+	"EC PRIVATE KEY":  "ecdsa",
+}
+
+// defaultName returns the name given to a key added without one.  It is
+// derived from the key's type, a fragment of its fingerprint and its
+// comment (e.g., 'ed25519 SHA256:abcd1234 (user@host)'), as far as they can
+// be determined without a passphrase.  fallbackComment is used if the key
+// does not record a comment (e.g., one converted from another format).
+func defaultName(p provider.Provider, pemPrivateKey string, fallbackComment string) string {
+	pub, comment, err := keyformat.InspectOpenSSH([]byte(pemPrivateKey))
+	if err != nil {
+		pub, comment = nil, ""
+		if priv, err := p.ParsePrivateKey([]byte(pemPrivateKey), nil); err == nil {
+			if signer, err := ssh.NewSignerFromKey(priv); err == nil {
+				pub = signer.PublicKey()
+			}
+		}
+	}
+
+	name := "key"
+	if pub != nil {
+		name = fmt.Sprintf("%s %s", keytype.Name(pub.Type()), fingerprintFragment(ssh.FingerprintSHA256(pub)))
+	} else if block, _ := pem.Decode([]byte(pemPrivateKey)); block != nil && pemKeyTypes[block.Type] != "" {
+		name = pemKeyTypes[block.Type]
+	}
+
+	if comment == "" {
+		comment = fallbackComment
+	}
+
+	// Comments are free-form; drop anything that is not permitted in a
+	// name, and shorten them so the result remains valid.
+	comment = strings.Join(strings.FieldsFunc(comment, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || r == utf8.RuneError
+	}), " ")
+	if comment == "" {
+		return name
+	}
+	budget := MaxNameLength - utf8.RuneCountInString(name) - len(" ()")
+	if runes := []rune(comment); len(runes) > budget {
+		comment = string(runes[:budget-1]) + "…"
+	}
+	return fmt.Sprintf("%s (%s)", name, comment)
+}
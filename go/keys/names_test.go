@@ -0,0 +1,459 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestValidateName(t *testing.T) {
+	testcases := []struct {
+		description string
+		name        string
+		wantErr     error
+	}{
+		{
+			description: "valid name",
+			name:        "my key (work)",
+		},
+		{
+			description: "unicode name",
+			name:        "clé de travail",
+		},
+		{
+			description: "empty name",
+			name:        "",
+			wantErr:     help.Errorf(help.InvalidName, "name must not be empty"),
+		},
+		{
+			description: "whitespace name",
+			name:        "   ",
+			wantErr:     help.Errorf(help.InvalidName, "name must not be empty"),
+		},
+		{
+			description: "name too long",
+			name:        strings.Repeat("a", MaxNameLength+1),
+			wantErr:     help.Errorf(help.InvalidName, "name must be at most 100 characters"),
+		},
+		{
+			description: "control characters",
+			name:        "my\tkey",
+			wantErr:     help.Errorf(help.InvalidName, "name must not contain control characters"),
+		},
+		{
+			description: "invalid UTF-8",
+			name:        "my\xffkey",
+			wantErr:     help.Errorf(help.InvalidName, "name must be valid UTF-8"),
+		},
+	}
+
+	for _, tc := range testcases {
+		err := ValidateName(tc.name)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestSuggestName(t *testing.T) {
+	testcases := []struct {
+		description string
+		name        string
+		taken       []string
+		want        string
+	}{
+		{
+			description: "name not taken",
+			name:        "key",
+			taken:       []string{"other"},
+			want:        "key",
+		},
+		{
+			description: "name taken",
+			name:        "key",
+			taken:       []string{"key"},
+			want:        "key (2)",
+		},
+		{
+			description: "name and first suggestion taken",
+			name:        "key",
+			taken:       []string{"key", "Key (2)"},
+			want:        "key (3)",
+		},
+		{
+			description: "name taken with different case and spacing",
+			name:        " KEY ",
+			taken:       []string{"key"},
+			want:        "KEY (2)",
+		},
+	}
+
+	for _, tc := range testcases {
+		if diff := pretty.Diff(SuggestName(tc.name, tc.taken), tc.want); diff != nil {
+			t.Errorf("%s: incorrect suggestion; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestAddUniqueName(t *testing.T) {
+	testcases := []struct {
+		description    string
+		name           string
+		unique         bool
+		wantConfigured []string
+		wantErr        error
+	}{
+		{
+			description:    "allow duplicate name",
+			name:           "existing",
+			wantConfigured: []string{"existing", "existing"},
+		},
+		{
+			description:    "reject duplicate name",
+			name:           "Existing",
+			unique:         true,
+			wantConfigured: []string{"existing"},
+			wantErr:        &ErrNameTaken{Name: "Existing", Suggestion: "Existing (2)"},
+		},
+		{
+			description:    "allow unique name",
+			name:           "new-key",
+			unique:         true,
+			wantConfigured: []string{"existing", "new-key"},
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), []*initialKey{
+			{
+				Name:          "existing",
+				PEMPrivateKey: testdata.ValidPrivateKey,
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to initialize manager: %v", tc.description, err)
+		}
+
+		err = syncAdd(mgr, tc.name, testdata.ValidPrivateKey, &AddOptions{UniqueName: tc.unique})
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if help.CodeOf(err) != help.CodeOf(tc.wantErr) {
+			t.Errorf("%s: incorrect help code; got %s, want %s", tc.description, help.CodeOf(err), help.CodeOf(tc.wantErr))
+		}
+
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Errorf("%s: failed to get configured keys: %v", tc.description, err)
+		}
+		names := configuredKeyNames(configured)
+		sort.Strings(names)
+		if diff := pretty.Diff(names, tc.wantConfigured); diff != nil {
+			t.Errorf("%s: incorrect configured keys; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestDefaultName(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(provider.NewDeterministicRand("default-name"))
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	ed25519Fragment := fingerprintFragment(ssh.FingerprintSHA256(signer.PublicKey()))
+	openssh := func(comment string, passphrase []byte) string {
+		pemPrivateKey, err := keyformat.MarshalOpenSSH(priv, comment, passphrase, provider.NewDeterministicRand("default-name"))
+		if err != nil {
+			t.Fatalf("failed to marshal key: %v", err)
+		}
+		return pemPrivateKey
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+	if err != nil {
+		t.Fatalf("failed to decode public key: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(blob)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+	rsaFragment := fingerprintFragment(ssh.FingerprintSHA256(pub))
+
+	testcases := []struct {
+		description   string
+		pemPrivateKey string
+		want          string
+	}{
+		{
+			description:   "unencrypted PKCS1 key",
+			pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			want:          "rsa " + rsaFragment,
+		},
+		{
+			description:   "encrypted PKCS1 key",
+			pemPrivateKey: testdata.ValidPrivateKey,
+			want:          "rsa",
+		},
+		{
+			description:   "OpenSSH key with comment",
+			pemPrivateKey: openssh("user@host", nil),
+			want:          fmt.Sprintf("ed25519 %s (user@host)", ed25519Fragment),
+		},
+		{
+			description:   "encrypted OpenSSH key omits comment",
+			pemPrivateKey: openssh("user@host", []byte("secret")),
+			want:          "ed25519 " + ed25519Fragment,
+		},
+		{
+			description:   "OpenSSH key with control characters in comment",
+			pemPrivateKey: openssh("user@host\n\tlaptop", nil),
+			want:          fmt.Sprintf("ed25519 %s (user@host laptop)", ed25519Fragment),
+		},
+		{
+			description:   "OpenSSH key with long comment",
+			pemPrivateKey: openssh(strings.Repeat("a", MaxNameLength), nil),
+			want:          fmt.Sprintf("ed25519 %s (%s…)", ed25519Fragment, strings.Repeat("a", MaxNameLength-len("ed25519 ")-len(ed25519Fragment)-len(" ()")-1)),
+		},
+		{
+			description:   "not a key",
+			pemPrivateKey: "not a key",
+			want:          "key",
+		},
+	}
+
+	for _, tc := range testcases {
+		got := defaultName(provider.NewSoftware(nil), tc.pemPrivateKey, "")
+		if got != tc.want {
+			t.Errorf("%s: incorrect name; got %q, want %q", tc.description, got, tc.want)
+		}
+		if err := ValidateName(got); err != nil {
+			t.Errorf("%s: derived name is invalid: %v", tc.description, err)
+		}
+	}
+}
+
+func TestAddDefaultName(t *testing.T) {
+	mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := syncAdd(mgr, " ", testdata.ValidPrivateKey, &AddOptions{UniqueName: true}); err != nil {
+			t.Fatalf("failed to add key: %v", err)
+		}
+	}
+
+	configured, err := syncConfigured(mgr)
+	if err != nil {
+		t.Fatalf("failed to get configured keys: %v", err)
+	}
+	names := configuredKeyNames(configured)
+	sort.Strings(names)
+	if diff := pretty.Diff(names, []string{"rsa", "rsa (2)"}); diff != nil {
+		t.Errorf("incorrect configured keys; -got +want: %s", diff)
+	}
+}
+
+func TestNicknames(t *testing.T) {
+	fingerprints := map[ID]string{
+		"1": "SHA256:abcdefghijklmnop",
+		"2": "SHA256:qrstuvwxyz012345",
+		"3": "SHA256:abcdefghijklmnop",
+	}
+	fingerprint := func(key *storedKey) string {
+		return fingerprints[key.ID]
+	}
+
+	testcases := []struct {
+		description string
+		keys        []*storedKey
+		want        map[ID]string
+	}{
+		{
+			description: "unique names",
+			keys: []*storedKey{
+				{ID: "1", Name: "work"},
+				{ID: "2", Name: "home"},
+			},
+			want: map[ID]string{"1": "work", "2": "home"},
+		},
+		{
+			description: "shared name",
+			keys: []*storedKey{
+				{ID: "1", Name: "work"},
+				{ID: "2", Name: "Work "},
+				{ID: "4", Name: "home"},
+			},
+			want: map[ID]string{
+				"1": "work (SHA256:abcdefgh)",
+				"2": "Work (SHA256:qrstuvwx)",
+				"4": "home",
+			},
+		},
+		{
+			description: "shared name with unknown fingerprint",
+			keys: []*storedKey{
+				{ID: "1", Name: "work"},
+				{ID: "4", Name: "work"},
+			},
+			want: map[ID]string{
+				"1": "work (SHA256:abcdefgh)",
+				"4": "work (ID 4)",
+			},
+		},
+		{
+			description: "shared name with same fingerprint",
+			keys: []*storedKey{
+				{ID: "1", Name: "work"},
+				{ID: "2", Name: "work"},
+				{ID: "3", Name: "work"},
+			},
+			want: map[ID]string{
+				"1": "work (ID 1)",
+				"2": "work (SHA256:qrstuvwx)",
+				"3": "work (ID 3)",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		got := nicknames(tc.keys, fingerprint)
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect nicknames; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestLoadedKeyNickname(t *testing.T) {
+	testcases := []struct {
+		description  string
+		comment      string
+		wantID       ID
+		wantNickname string
+	}{
+		{
+			description:  "current format",
+			comment:      "chrome-ssh-agent:1234 work (SHA256:abcdefgh)",
+			wantID:       "1234",
+			wantNickname: "work (SHA256:abcdefgh)",
+		},
+		{
+			description: "format without nickname",
+			comment:     "chrome-ssh-agent:1234",
+			wantID:      "1234",
+		},
+		{
+			description:  "fingerprint ID",
+			comment:      "chrome-ssh-agent:SHA256:abcd+/efgh work",
+			wantID:       "SHA256:abcd+/efgh",
+			wantNickname: "work",
+		},
+		{
+			description:  "key not loaded from a configured key",
+			comment:      "some-key",
+			wantID:       InvalidID,
+			wantNickname: "some-key",
+		},
+	}
+
+	for _, tc := range testcases {
+		k := &LoadedKey{Comment: tc.comment}
+		if diff := pretty.Diff(k.ID(), tc.wantID); diff != nil {
+			t.Errorf("%s: incorrect ID; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(k.Nickname(), tc.wantNickname); diff != nil {
+			t.Errorf("%s: incorrect nickname; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestLoadDuplicateName(t *testing.T) {
+	mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), []*initialKey{
+		{
+			Name:          "work",
+			PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+		},
+		{
+			Name:          "work",
+			PEMPrivateKey: testdata.ValidPrivateKey,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+	if err != nil {
+		t.Fatalf("failed to decode blob: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(blob)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+	wantNickname := fmt.Sprintf("work (%s)", ssh.FingerprintSHA256(pub)[:len("SHA256:")+nicknameFingerprintLength])
+
+	configured, err := syncConfigured(mgr)
+	if err != nil {
+		t.Fatalf("failed to get configured keys: %v", err)
+	}
+	var id ID
+	for _, k := range configured {
+		if k.Encrypted {
+			if diff := pretty.Diff(k.DisplayName(), fmt.Sprintf("work (ID %s)", k.ID)); diff != nil {
+				t.Errorf("incorrect nickname for encrypted key; -got +want: %s", diff)
+			}
+			continue
+		}
+		id = k.ID
+		if diff := pretty.Diff(k.DisplayName(), wantNickname); diff != nil {
+			t.Errorf("incorrect nickname for unencrypted key; -got +want: %s", diff)
+		}
+	}
+
+	if err := syncLoad(mgr, id, ""); err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	loaded, err := syncLoaded(mgr)
+	if err != nil {
+		t.Fatalf("failed to get loaded keys: %v", err)
+	}
+	if len(loaded) != 1 {
+		t.Fatalf("incorrect number of loaded keys; got %d, want 1", len(loaded))
+	}
+	if diff := pretty.Diff(loaded[0].ID(), id); diff != nil {
+		t.Errorf("incorrect loaded ID; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(loaded[0].Nickname(), wantNickname); diff != nil {
+		t.Errorf("incorrect loaded nickname; -got +want: %s", diff)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+const (
+	// MaxNotesLength is the maximum length of the notes for a key, in
+	// characters.  Notes are stored with the key, so they count against
+	// the storage quota for each key.
+	MaxNotesLength = 2000
+
+	// notesHeader introduces the notes appended to an exported key.
+	notesHeader = "Notes:"
+)
+
+// checkNotes returns an error if notes are too long to be stored with a key.
+func checkNotes(notes string) error {
+	if n := utf8.RuneCountInString(strings.TrimSpace(notes)); n > MaxNotesLength {
+		return fmt.Errorf("notes are %d characters long; they must be at most %d", n, MaxNotesLength)
+	}
+	return nil
+}
+
+// SetNotes implements Manager.SetNotes.
+func (m *manager) SetNotes(id ID, notes string, callback func(err error)) {
+	notes = strings.TrimSpace(notes)
+	if err := checkNotes(notes); err != nil {
+		callback(err)
+		return
+	}
+
+	m.readKey(id, func(key *storedKey, err error) {
+		if err != nil {
+			callback(help.Errorf(help.StorageFailure, "failed to read key: %v", err))
+			return
+		}
+		if key == nil {
+			callback(help.Errorf(help.KeyNotFound, "failed to find key with ID %s", id))
+			return
+		}
+
+		key.Notes = notes
+		key.Updated = nowMillis()
+		data := map[string]interface{}{
+			storageKey(id): key.value(),
+		}
+		m.storeFor(key.DeviceOnly).Set(data, func(err error) {
+			if err != nil {
+				callback(help.Errorf(help.StorageFailure, "failed to write key: %v", err))
+				return
+			}
+			if m.audit != nil {
+				m.audit.Record(audit.NewEntry("notes", "options", string(id), true, fmt.Sprintf("updated notes of key %q", key.Name)), nil)
+			}
+			callback(nil)
+		})
+	})
+}
+
+// appendNotes appends notes to an exported key.  The notes follow the end
+// of the encoded key, where tools reading the key ignore them.
+func appendNotes(encoded, notes string) string {
+	if notes == "" {
+		return encoded
+	}
+	return fmt.Sprintf("%s\n\n%s\n%s\n", strings.TrimRight(encoded, "\n"), notesHeader, notes)
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestSetNotes(t *testing.T) {
+	testcases := []struct {
+		description string
+		byID        ID
+		notes       string
+		wantNotes   string
+		wantErr     bool
+		wantCode    help.Code
+	}{
+		{
+			description: "set notes",
+			notes:       "  Deploys to **prod**\n- TICKET-1  \n",
+			wantNotes:   "Deploys to **prod**\n- TICKET-1",
+		},
+		{
+			description: "clear notes",
+			notes:       "",
+			wantNotes:   "",
+		},
+		{
+			description: "notes too long",
+			notes:       strings.Repeat("x", MaxNotesLength+1),
+			wantNotes:   "original notes",
+			wantErr:     true,
+		},
+		{
+			description: "unknown key",
+			byID:        ID("unknown"),
+			notes:       "some notes",
+			wantNotes:   "original notes",
+			wantErr:     true,
+			wantCode:    help.KeyNotFound,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKey, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncSetNotes(mgr, id, "original notes"); err != nil {
+			t.Fatalf("%s: failed to set original notes: %v", tc.description, err)
+		}
+
+		if tc.byID != InvalidID {
+			id = tc.byID
+		}
+		err = syncSetNotes(mgr, id, tc.notes)
+		if diff := pretty.Diff(err != nil, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error state; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(help.CodeOf(err), tc.wantCode); diff != nil {
+			t.Errorf("%s: incorrect help code; -got +want: %s", tc.description, diff)
+		}
+
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to get configured keys: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(configured[0].Notes, tc.wantNotes); diff != nil {
+			t.Errorf("%s: incorrect notes; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestAddNotes(t *testing.T) {
+	testcases := []struct {
+		description string
+		notes       string
+		wantNotes   string
+		wantErr     bool
+	}{
+		{
+			description: "add with notes",
+			notes:       "  Imported from the team vault\n",
+			wantNotes:   "Imported from the team vault",
+		},
+		{
+			description: "add without notes",
+			notes:       "",
+			wantNotes:   "",
+		},
+		{
+			description: "notes too long",
+			notes:       strings.Repeat("x", MaxNotesLength+1),
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		err := syncAdd(mgr, "some-key", testdata.ValidPrivateKey, &AddOptions{Notes: tc.notes})
+		if diff := pretty.Diff(err != nil, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error state; -got +want: %s", tc.description, diff)
+		}
+
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to get configured keys: %v", tc.description, err)
+		}
+		if tc.wantErr {
+			if len(configured) != 0 {
+				t.Errorf("%s: key added despite error", tc.description)
+			}
+			continue
+		}
+		if len(configured) != 1 {
+			t.Errorf("%s: incorrect number of keys; got %d, want 1", tc.description, len(configured))
+			continue
+		}
+		if diff := pretty.Diff(configured[0].Notes, tc.wantNotes); diff != nil {
+			t.Errorf("%s: incorrect notes; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestExportNotes(t *testing.T) {
+	mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+	if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKey, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	id, err := findKey(mgr, InvalidID, "some-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	if err := syncSetNotes(mgr, id, "Deploys to prod"); err != nil {
+		t.Fatalf("failed to set notes: %v", err)
+	}
+
+	encoded, err := syncExport(mgr, id, testdata.ValidPrivateKeyPassphrase, keyformat.PrivateOpenSSH, "export-passphrase")
+	if err != nil {
+		t.Fatalf("failed to export key: %v", err)
+	}
+	// The notes follow the encoded key, which is otherwise unchanged.
+	block, rest := pem.Decode([]byte(encoded))
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+		t.Errorf("failed to decode exported key: %s", encoded)
+	}
+	if diff := pretty.Diff(string(rest), "\nNotes:\nDeploys to prod\n"); diff != nil {
+		t.Errorf("incorrect text following exported key; -got +want: %s", diff)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// OnDemandKey is the key under which the setting that enables loading keys
+// on demand is stored.  It is stored on each device.
+const OnDemandKey = "load.onDemand"
+
+// ReadOnDemand reads from store whether keys are loaded on demand (see
+// OnDemandAgent).  It is disabled unless the user enables it.
+func ReadOnDemand(store SettingsStore, callback func(enabled bool, err error)) {
+	store.GetItems([]string{OnDemandKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(false, fmt.Errorf("failed to read on-demand loading setting: %v", err))
+			return
+		}
+		enabled, _ := data[OnDemandKey].(bool)
+		callback(enabled, nil)
+	})
+}
+
+// WriteOnDemand stores in store whether keys are loaded on demand.
+func WriteOnDemand(store SettingsStore, enabled bool, callback func(err error)) {
+	store.Set(map[string]interface{}{OnDemandKey: enabled}, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to write on-demand loading setting: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// OnDemandAgent is an agent.Agent that, if enabled in settings, loads a
+// configured key when a client asks it to sign using a key that is not
+// loaded.  Keys stored unencrypted are loaded directly; keys encrypted with
+// a passphrase are only loaded if the user can be asked for it (see
+// WithPassphrasePrompts), and must otherwise be loaded by the user.  This
+// allows users with many keys to configure them all, and leave the agent to
+// load the ones that are actually used.
+//
+// The public key of an encrypted key cannot be determined without its
+// passphrase, so it is only loaded on demand once it has been seen loaded
+// in the agent since the background page started.  Signatures requested
+// while a key is being loaded wait for that load, rather than asking for
+// the passphrase again.
+//
+// Keys are loaded by the manager exactly as if the user had loaded them, so
+// load policies, lifetimes, canary keys and confirmation codes all apply.
+// Clients must already know the public key to request a signature; keys
+// that are not loaded are not listed.
+type OnDemandAgent struct {
+	agent.Agent
+	mgr      Manager
+	settings SettingsStore
+	prompts  *PassphrasePrompts
+
+	mu sync.Mutex
+	// seen maps the SHA256 fingerprints of keys seen loaded in the agent
+	// to their IDs.
+	seen map[string]ID
+	// loading contains the callbacks waiting for each key being loaded,
+	// keyed by ID.
+	loading map[ID][]func(err error)
+}
+
+// OnDemandOption configures an OnDemandAgent.
+type OnDemandOption func(a *OnDemandAgent)
+
+// WithPassphrasePrompts specifies that keys encrypted with a passphrase are
+// also loaded on demand, asking the user for the passphrase using prompts.
+// By default, they are not.
+func WithPassphrasePrompts(prompts *PassphrasePrompts) OnDemandOption {
+	return func(a *OnDemandAgent) {
+		a.prompts = prompts
+	}
+}
+
+// NewOnDemandAgent returns an OnDemandAgent that signs using a, and loads
+// keys into it using mgr.  Whether keys are loaded on demand is read from
+// settings for each signature using a key that is not loaded, so changes
+// take effect immediately.
+func NewOnDemandAgent(a agent.Agent, mgr Manager, settings SettingsStore, opts ...OnDemandOption) *OnDemandAgent {
+	result := &OnDemandAgent{
+		Agent:    a,
+		mgr:      mgr,
+		settings: settings,
+		seen:     make(map[string]ID),
+		loading:  make(map[ID][]func(err error)),
+	}
+	for _, o := range opts {
+		o(result)
+	}
+	return result
+}
+
+// remember records the IDs of the loaded keys, so that they can be found by
+// fingerprint after they are unloaded.
+func (a *OnDemandAgent) remember(loaded []*agent.Key) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, l := range loaded {
+		if id := (&LoadedKey{Comment: l.Comment}).ID(); id != InvalidID {
+			a.seen[ssh.FingerprintSHA256(l)] = id
+		}
+	}
+}
+
+// seenID returns the ID of the specified key if it has been seen loaded, or
+// InvalidID otherwise.
+func (a *OnDemandAgent) seenID(key ssh.PublicKey) ID {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if id, ok := a.seen[ssh.FingerprintSHA256(key)]; ok {
+		return id
+	}
+	return InvalidID
+}
+
+// List implements agent.Agent.List.
+func (a *OnDemandAgent) List() ([]*agent.Key, error) {
+	loaded, err := a.Agent.List()
+	if err == nil {
+		a.remember(loaded)
+	}
+	return loaded, err
+}
+
+// loaded determines if the specified key is loaded in the agent.
+func (a *OnDemandAgent) loaded(key ssh.PublicKey) (bool, error) {
+	loaded, err := a.List()
+	if err != nil {
+		return false, fmt.Errorf("failed to list loaded keys: %v", err)
+	}
+	blob := string(key.Marshal())
+	for _, l := range loaded {
+		if string(l.Blob) == blob {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// candidate returns the configured key that may be loaded on demand to sign
+// using the specified key, or nil if there is none.  seen is the ID of the
+// key if it has been seen loaded, or InvalidID.  Keys encrypted with a
+// passphrase are only candidates if encrypted is true.
+func candidate(items []*InventoryItem, key ssh.PublicKey, seen ID, encrypted bool) *InventoryItem {
+	fp := ssh.FingerprintSHA256(key)
+	for _, i := range items {
+		if i.ID == InvalidID || i.Loaded {
+			continue
+		}
+		if i.Fingerprint != fp && (seen == InvalidID || i.ID != seen) {
+			continue
+		}
+		if i.Protection != ProtectionPlaintext && !(encrypted && i.Protection == ProtectionPassphrase) {
+			continue
+		}
+		return i
+	}
+	return nil
+}
+
+// load loads the configured key corresponding to the specified key, if
+// loading keys on demand is enabled and there is one that may be loaded.
+// callback is invoked with the ID of the key that was loaded, or InvalidID
+// if none was.
+func (a *OnDemandAgent) load(key ssh.PublicKey, callback func(id ID, err error)) {
+	ReadOnDemand(a.settings, func(enabled bool, err error) {
+		if err != nil {
+			callback(InvalidID, err)
+			return
+		}
+		if !enabled {
+			callback(InvalidID, nil)
+			return
+		}
+		a.mgr.Inventory(func(items []*InventoryItem, err error) {
+			if err != nil {
+				callback(InvalidID, fmt.Errorf("failed to find configured key: %v", err))
+				return
+			}
+			c := candidate(items, key, a.seenID(key), a.prompts != nil)
+			if c == nil {
+				callback(InvalidID, nil)
+				return
+			}
+			a.loadOnce(c, func(err error) {
+				if err != nil {
+					callback(InvalidID, fmt.Errorf("failed to load key on demand: %v", err))
+					return
+				}
+				callback(c.ID, nil)
+			})
+		})
+	})
+}
+
+// loadOnce loads the configured key c, asking the user for its passphrase if
+// it has one.  If the key is already being loaded for another signature,
+// callback is instead invoked once that load completes, so that concurrent
+// signatures using a key share a single prompt.
+func (a *OnDemandAgent) loadOnce(c *InventoryItem, callback func(err error)) {
+	a.mu.Lock()
+	waiting, ok := a.loading[c.ID]
+	a.loading[c.ID] = append(waiting, callback)
+	a.mu.Unlock()
+	if ok {
+		return
+	}
+
+	done := func(err error) {
+		a.mu.Lock()
+		waiting := a.loading[c.ID]
+		delete(a.loading, c.ID)
+		a.mu.Unlock()
+		for _, w := range waiting {
+			w(err)
+		}
+	}
+	if c.Protection == ProtectionPassphrase {
+		LoadWithPassphrase(a.mgr, c.ID, DefaultPassphraseAttempts, a.prompts.Func(c.ID, c.Name), done)
+		return
+	}
+	a.mgr.Load(c.ID, "", done)
+}
+
+// Sign implements agent.Agent.Sign.  If the key is not loaded, a configured
+// key is loaded on demand where possible.  Failing to do so is not an error
+// in itself; the signature is then refused by the underlying agent as usual.
+//
+// Sign blocks until the key is loaded, so it must not be called from a
+// callback (e.g., one invoked by the manager or by storage).
+func (a *OnDemandAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	if ok, err := a.loaded(key); err == nil && !ok {
+		done := make(chan struct{})
+		a.load(key, func(id ID, err error) {
+			if err != nil {
+				log.Printf("Failed to load key %s on demand: %v", ssh.FingerprintSHA256(key), err)
+			} else if id != InvalidID {
+				log.Printf("Loaded key %s on demand", id)
+			}
+			close(done)
+		})
+		<-done
+	}
+	return a.Agent.Sign(key, data)
+}
@@ -0,0 +1,405 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/agenthooks"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+func syncWriteOnDemand(store PersistentStore, enabled bool) error {
+	errc := make(chan error, 1)
+	WriteOnDemand(store, enabled, func(err error) {
+		errc <- err
+	})
+	return readErr(errc)
+}
+
+func TestOnDemandAgent(t *testing.T) {
+	testcases := []struct {
+		description   string
+		disabled      bool
+		pemPrivateKey string
+		canary        bool
+		settingsErr   error
+		wantLoaded    bool
+		wantSigned    bool
+		wantErr       error
+	}{
+		{
+			description:   "load unencrypted key",
+			pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			wantLoaded:    true,
+			wantSigned:    true,
+		},
+		{
+			description:   "disabled",
+			disabled:      true,
+			pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			wantErr:       errors.New("not found"),
+		},
+		{
+			description:   "encrypted key is not loaded",
+			pemPrivateKey: testdata.ValidPrivateKey,
+			wantErr:       errors.New("not found"),
+		},
+		{
+			description:   "canary key is loaded but refuses to sign",
+			pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			canary:        true,
+			wantLoaded:    true,
+			wantErr:       ErrCanaryKey,
+		},
+		{
+			description:   "fail to read setting",
+			pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			settingsErr:   errors.New("storage.Get failed"),
+			wantErr:       errors.New("not found"),
+		},
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+	if err != nil {
+		t.Fatalf("failed to decode public key: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(blob)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+
+	for _, tc := range testcases {
+		guard := NewCanaryGuard(nil)
+		kr := keyring.New()
+		hooked := agenthooks.New(kr)
+		hooked.Install(guard.Hooks())
+		settings := fakes.NewMemStorage()
+		mgr := NewManager(hooked, fakes.NewMemStorage(), settings, WithCanaryGuard(guard))
+		if err := syncAdd(mgr, "some-key", tc.pemPrivateKey, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		if tc.canary {
+			id, err := findKey(mgr, InvalidID, "some-key")
+			if err != nil {
+				t.Fatalf("%s: failed to find key: %v", tc.description, err)
+			}
+			if err := syncSetCanary(mgr, id, true); err != nil {
+				t.Fatalf("%s: failed to mark canary: %v", tc.description, err)
+			}
+		}
+		if err := syncWriteOnDemand(settings, !tc.disabled); err != nil {
+			t.Fatalf("%s: failed to enable loading on demand: %v", tc.description, err)
+		}
+		settings.SetError(fakes.Errs{Get: tc.settingsErr})
+
+		a := NewOnDemandAgent(hooked, mgr, settings)
+		sig, err := a.Sign(pub, []byte("some-data"))
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if got := sig != nil; got != tc.wantSigned {
+			t.Errorf("%s: incorrect signed; got %t, want %t", tc.description, got, tc.wantSigned)
+		}
+		if sig != nil {
+			if err := pub.Verify([]byte("some-data"), sig); err != nil {
+				t.Errorf("%s: failed to verify signature: %v", tc.description, err)
+			}
+		}
+
+		loaded, err := kr.List()
+		if err != nil {
+			t.Fatalf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		if got := len(loaded) == 1; got != tc.wantLoaded {
+			t.Errorf("%s: incorrect loaded; got %t, want %t", tc.description, got, tc.wantLoaded)
+		}
+	}
+}
+
+func TestOnDemandAgentLoadsOnce(t *testing.T) {
+	kr := keyring.New()
+	settings := fakes.NewMemStorage()
+	mgr := NewManager(kr, fakes.NewMemStorage(), settings)
+	if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	if err := syncWriteOnDemand(settings, true); err != nil {
+		t.Fatalf("failed to enable loading on demand: %v", err)
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+	if err != nil {
+		t.Fatalf("failed to decode public key: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(blob)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+
+	a := NewOnDemandAgent(kr, mgr, settings)
+	for i := 0; i < 3; i++ {
+		if _, err := a.Sign(pub, []byte("some-data")); err != nil {
+			t.Errorf("signature %d failed: %v", i, err)
+		}
+	}
+	loaded, err := kr.List()
+	if err != nil {
+		t.Fatalf("failed to list loaded keys: %v", err)
+	}
+	if len(loaded) != 1 {
+		t.Errorf("incorrect number of loaded keys; got %d, want 1", len(loaded))
+	}
+}
+
+func TestReadOnDemand(t *testing.T) {
+	settings := fakes.NewMemStorage()
+	ReadOnDemand(settings, func(enabled bool, err error) {
+		if err != nil {
+			t.Errorf("failed to read setting: %v", err)
+		}
+		if enabled {
+			t.Errorf("loading on demand enabled by default")
+		}
+	})
+
+	if err := syncWriteOnDemand(settings, true); err != nil {
+		t.Fatalf("failed to write setting: %v", err)
+	}
+	ReadOnDemand(settings, func(enabled bool, err error) {
+		if err != nil {
+			t.Errorf("failed to read setting: %v", err)
+		}
+		if !enabled {
+			t.Errorf("loading on demand not enabled")
+		}
+	})
+}
+
+func TestOnDemandAgentPrompts(t *testing.T) {
+	testcases := []struct {
+		description string
+		prompt      bool
+		unseen      bool
+		passphrases []string
+		wantPrompts int
+		wantSigned  bool
+	}{
+		{
+			description: "encrypted key is not loaded without prompts",
+		},
+		{
+			description: "load encrypted key",
+			prompt:      true,
+			passphrases: []string{testdata.ValidPrivateKeyPassphrase},
+			wantPrompts: 1,
+			wantSigned:  true,
+		},
+		{
+			description: "load encrypted key after incorrect passphrase",
+			prompt:      true,
+			passphrases: []string{"incorrect", testdata.ValidPrivateKeyPassphrase},
+			wantPrompts: 2,
+			wantSigned:  true,
+		},
+		{
+			description: "encrypted key not seen loaded",
+			prompt:      true,
+			unseen:      true,
+			passphrases: []string{testdata.ValidPrivateKeyPassphrase},
+		},
+		{
+			description: "user declines to enter passphrase",
+			prompt:      true,
+			wantPrompts: 1,
+		},
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(testdata.ValidPrivateKeyBlob)
+	if err != nil {
+		t.Fatalf("failed to decode public key: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(blob)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+
+	for _, tc := range testcases {
+		kr := keyring.New()
+		settings := fakes.NewMemStorage()
+		mgr := NewManager(kr, fakes.NewMemStorage(), settings)
+		if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKey, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		if err := syncWriteOnDemand(settings, true); err != nil {
+			t.Fatalf("%s: failed to enable loading on demand: %v", tc.description, err)
+		}
+
+		// Answer each prompt as the user would, from another page.
+		var opts []OnDemandOption
+		var prompted int
+		if tc.prompt {
+			passphrases := tc.passphrases
+			var prompts *PassphrasePrompts
+			prompts = NewPassphrasePrompts(DefaultPassphraseTimeout, func(r *PassphraseRequest) {
+				prompted++
+				if r.Name != "some-key" {
+					t.Errorf("%s: incorrect key name in request; got %q, want %q", tc.description, r.Name, "some-key")
+				}
+				go func() {
+					if len(passphrases) == 0 {
+						prompts.Respond(r.Request, "", false)
+						return
+					}
+					p := passphrases[0]
+					passphrases = passphrases[1:]
+					prompts.Respond(r.Request, p, true)
+				}()
+			})
+			opts = append(opts, WithPassphrasePrompts(prompts))
+		}
+
+		a := NewOnDemandAgent(kr, mgr, settings, opts...)
+
+		// The public key of an encrypted key is only known once it
+		// has been loaded.
+		if !tc.unseen {
+			id, err := findKey(mgr, InvalidID, "some-key")
+			if err != nil {
+				t.Fatalf("%s: failed to find key: %v", tc.description, err)
+			}
+			if err := syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase); err != nil {
+				t.Fatalf("%s: failed to load key: %v", tc.description, err)
+			}
+			if _, err := a.List(); err != nil {
+				t.Fatalf("%s: failed to list keys: %v", tc.description, err)
+			}
+			loaded, err := syncLoaded(mgr)
+			if err != nil {
+				t.Fatalf("%s: failed to get loaded keys: %v", tc.description, err)
+			}
+			if err := syncUnload(mgr, loaded[0]); err != nil {
+				t.Fatalf("%s: failed to unload key: %v", tc.description, err)
+			}
+		}
+
+		sig, _ := a.Sign(pub, []byte("some-data"))
+		if got := sig != nil; got != tc.wantSigned {
+			t.Errorf("%s: incorrect signed; got %t, want %t", tc.description, got, tc.wantSigned)
+		}
+		if prompted != tc.wantPrompts {
+			t.Errorf("%s: incorrect number of prompts; got %d, want %d", tc.description, prompted, tc.wantPrompts)
+		}
+	}
+}
+
+func TestOnDemandAgentCoalescesPrompts(t *testing.T) {
+	const signers = 3
+
+	blob, err := base64.StdEncoding.DecodeString(testdata.ValidPrivateKeyBlob)
+	if err != nil {
+		t.Fatalf("failed to decode public key: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(blob)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+
+	kr := keyring.New()
+	settings := fakes.NewMemStorage()
+	mgr := NewManager(kr, fakes.NewMemStorage(), settings)
+	if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKey, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	if err := syncWriteOnDemand(settings, true); err != nil {
+		t.Fatalf("failed to enable loading on demand: %v", err)
+	}
+
+	requests := make(chan *PassphraseRequest, signers)
+	prompts := NewPassphrasePrompts(DefaultPassphraseTimeout, func(r *PassphraseRequest) {
+		requests <- r
+	})
+	a := NewOnDemandAgent(kr, mgr, settings, WithPassphrasePrompts(prompts))
+
+	// Load the key once so that its public key is known, then unload it
+	// as if it had expired.
+	id, err := findKey(mgr, InvalidID, "some-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	if err := syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase); err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	if _, err := a.List(); err != nil {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+	loaded, err := syncLoaded(mgr)
+	if err != nil {
+		t.Fatalf("failed to get loaded keys: %v", err)
+	}
+	if err := syncUnload(mgr, loaded[0]); err != nil {
+		t.Fatalf("failed to unload key: %v", err)
+	}
+
+	// Several clients ask to sign at once.
+	errs := make(chan error, signers)
+	for i := 0; i < signers; i++ {
+		go func() {
+			_, err := a.Sign(pub, []byte("some-data"))
+			errs <- err
+		}()
+	}
+
+	// Wait until every signature is waiting for the key, then enter the
+	// passphrase once.
+	var r *PassphraseRequest
+	select {
+	case r = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("passphrase not requested")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.mu.Lock()
+		waiting := len(a.loading[id])
+		a.mu.Unlock()
+		if waiting == signers {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("incorrect number of signatures waiting for key; got %d, want %d", waiting, signers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := prompts.Respond(r.Request, testdata.ValidPrivateKeyPassphrase, true); err != nil {
+		t.Fatalf("failed to enter passphrase: %v", err)
+	}
+
+	for i := 0; i < signers; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("signature %d failed: %v", i, err)
+		}
+	}
+	if n := len(requests); n != 0 {
+		t.Errorf("incorrect number of passphrase prompts; got %d, want 1", n+1)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPassphraseTimeout is how long a key waits for the user to enter its
+// passphrase before loading it is abandoned.
+const DefaultPassphraseTimeout = 2 * time.Minute
+
+// ErrPassphraseNotPending is returned when a passphrase is entered for a key
+// that is no longer waiting for one.
+var ErrPassphraseNotPending = errors.New("key is no longer waiting for a passphrase")
+
+// PassphraseRequest is a key waiting for the user to enter its passphrase so
+// that it can be loaded.
+type PassphraseRequest struct {
+	// Request identifies the request; passphrases are entered using it.
+	Request int `codec:"request"`
+	// ID is the ID of the key to be loaded.
+	ID ID `codec:"id"`
+	// Name is the name of the key.
+	Name string `codec:"name"`
+	// Attempt is the number of the attempt, starting at 1, and Remaining
+	// the number of attempts left, including this one; see
+	// PassphraseFunc.
+	Attempt   int `codec:"attempt"`
+	Remaining int `codec:"remaining"`
+	// Incorrect is true if the passphrase entered for the previous
+	// attempt was incorrect.
+	Incorrect bool `codec:"incorrect"`
+	// Expires is the time at which loading the key is abandoned if no
+	// passphrase has been entered, in milliseconds since the Unix epoch.
+	Expires int64 `codec:"expires"`
+}
+
+// pendingPassphrase is a key waiting for a passphrase.
+type pendingPassphrase struct {
+	request  *PassphraseRequest
+	timer    *time.Timer
+	callback func(passphrase string, ok bool)
+}
+
+// PassphrasePrompts holds requests for the passphrases of keys that are
+// being loaded without the user having asked (e.g., on demand), until the
+// user enters the passphrase on another page (see Pending and Respond).
+// Requests are abandoned if the user does not respond in time.
+type PassphrasePrompts struct {
+	mu sync.Mutex
+	// pending contains the requests waiting for a passphrase, keyed by
+	// request.
+	pending map[int]*pendingPassphrase
+	// next is the number assigned to the next request.
+	next int
+	// timeout is how long a request waits for a passphrase.
+	timeout time.Duration
+	// requested is invoked each time a request starts waiting.
+	requested func(r *PassphraseRequest)
+	// now returns the current time.  It may be replaced in tests.
+	now func() time.Time
+}
+
+// NewPassphrasePrompts returns a PassphrasePrompts with no pending requests.
+// A request waits up to timeout for a passphrase; requested is invoked each
+// time one starts waiting, so the user can be asked for it.
+func NewPassphrasePrompts(timeout time.Duration, requested func(r *PassphraseRequest)) *PassphrasePrompts {
+	return &PassphrasePrompts{
+		pending:   make(map[int]*pendingPassphrase),
+		next:      1,
+		timeout:   timeout,
+		requested: requested,
+		now:       time.Now,
+	}
+}
+
+// Func returns a PassphraseFunc, for use with LoadWithPassphrase, that asks
+// the user for the passphrase of the key with the specified ID and name.
+// Each attempt waits until the user responds, or the request times out, in
+// which case loading is abandoned.
+func (p *PassphrasePrompts) Func(id ID, name string) PassphraseFunc {
+	return func(attempt, remaining int, lastErr error, callback func(passphrase string, ok bool)) {
+		r := &PassphraseRequest{
+			ID:        id,
+			Name:      name,
+			Attempt:   attempt,
+			Remaining: remaining,
+			Incorrect: lastErr != nil,
+			Expires:   p.now().Add(p.timeout).UnixNano() / int64(time.Millisecond),
+		}
+
+		p.mu.Lock()
+		r.Request = p.next
+		p.next++
+		pp := &pendingPassphrase{request: r, callback: callback}
+		pp.timer = time.AfterFunc(p.timeout, func() {
+			if p.remove(r.Request) != nil {
+				callback("", false)
+			}
+		})
+		p.pending[r.Request] = pp
+		p.mu.Unlock()
+
+		if p.requested != nil {
+			c := *r
+			p.requested(&c)
+		}
+	}
+}
+
+// remove removes the specified request, and returns it if it was pending.
+func (p *PassphrasePrompts) remove(request int) *pendingPassphrase {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pp, ok := p.pending[request]
+	if !ok {
+		return nil
+	}
+	delete(p.pending, request)
+	return pp
+}
+
+// Pending returns the keys waiting for a passphrase, oldest first.
+func (p *PassphrasePrompts) Pending() []*PassphraseRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var result []*PassphraseRequest
+	for _, pp := range p.pending {
+		r := *pp.request
+		result = append(result, &r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Request < result[j].Request
+	})
+	return result
+}
+
+// Respond supplies the passphrase entered by the user for a request; ok is
+// false if the user declined to enter one, in which case loading the key is
+// abandoned.  Whether the passphrase is correct is not known until the key
+// is loaded; if it is not, a new request is made for the next attempt.
+func (p *PassphrasePrompts) Respond(request int, passphrase string, ok bool) error {
+	pp := p.remove(request)
+	if pp == nil {
+		return ErrPassphraseNotPending
+	}
+	pp.timer.Stop()
+	pp.callback(passphrase, ok)
+	return nil
+}
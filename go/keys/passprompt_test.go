@@ -0,0 +1,99 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+)
+
+func TestPassphrasePrompts(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	var requested []*PassphraseRequest
+	prompts := NewPassphrasePrompts(time.Minute, func(r *PassphraseRequest) {
+		requested = append(requested, r)
+	})
+	prompts.now = func() time.Time { return now }
+
+	type answer struct {
+		passphrase string
+		ok         bool
+	}
+	var answers []answer
+	callback := func(passphrase string, ok bool) {
+		answers = append(answers, answer{passphrase, ok})
+	}
+	prompts.Func(ID("first"), "first-key")(1, 3, nil, callback)
+	prompts.Func(ID("second"), "second-key")(2, 2, errors.New("incorrect passphrase"), callback)
+
+	expires := now.Add(time.Minute).UnixNano() / int64(time.Millisecond)
+	want := []*PassphraseRequest{
+		{Request: 1, ID: ID("first"), Name: "first-key", Attempt: 1, Remaining: 3, Expires: expires},
+		{Request: 2, ID: ID("second"), Name: "second-key", Attempt: 2, Remaining: 2, Incorrect: true, Expires: expires},
+	}
+	if diff := pretty.Diff(requested, want); diff != nil {
+		t.Errorf("incorrect requests notified; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(prompts.Pending(), want); diff != nil {
+		t.Errorf("incorrect pending requests; -got +want: %s", diff)
+	}
+
+	// Respond to each request, entering a passphrase for one and
+	// declining the other.
+	if err := prompts.Respond(2, "", false); err != nil {
+		t.Errorf("failed to decline second request: %v", err)
+	}
+	if err := prompts.Respond(1, "some-passphrase", true); err != nil {
+		t.Errorf("failed to respond to first request: %v", err)
+	}
+	wantAnswers := []answer{{"", false}, {"some-passphrase", true}}
+	if diff := pretty.Diff(answers, wantAnswers); diff != nil {
+		t.Errorf("incorrect answers; -got +want: %s", diff)
+	}
+	if got := prompts.Pending(); len(got) != 0 {
+		t.Errorf("incorrect pending requests after responding; got %v, want none", got)
+	}
+
+	// Requests may only be answered once.
+	if err := prompts.Respond(1, "other-passphrase", true); err != ErrPassphraseNotPending {
+		t.Errorf("incorrect error responding twice; got %v, want %v", err, ErrPassphraseNotPending)
+	}
+}
+
+func TestPassphrasePromptsTimeout(t *testing.T) {
+	prompts := NewPassphrasePrompts(10*time.Millisecond, nil)
+	done := make(chan bool, 1)
+	prompts.Func(ID("some-id"), "some-key")(1, 1, nil, func(passphrase string, ok bool) {
+		done <- ok
+	})
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Errorf("passphrase supplied after timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("request did not time out")
+	}
+	if got := prompts.Pending(); len(got) != 0 {
+		t.Errorf("incorrect pending requests after timeout; got %v, want none", got)
+	}
+	if err := prompts.Respond(1, "some-passphrase", true); err != ErrPassphraseNotPending {
+		t.Errorf("incorrect error responding after timeout; got %v, want %v", err, ErrPassphraseNotPending)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/codec"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// Define a distinct type for each message used to enter passphrases.  These
+// are distinct from those used by the Server.
+const (
+	msgTypePassphrasePending int = 7000 + iota
+	msgTypePassphrasePendingRsp
+	msgTypePassphraseRespond
+	msgTypePassphraseRespondRsp
+)
+
+type msgPassphrasePending struct {
+	*msgHeader
+}
+
+type rspPassphrasePending struct {
+	*msgHeader
+	Requests interface{} `js:"requests"`
+}
+
+type msgPassphraseRespond struct {
+	*msgHeader
+	Request    int    `js:"request"`
+	Passphrase string `js:"passphrase"`
+	OK         bool   `js:"ok"`
+}
+
+type rspPassphraseRespond struct {
+	*msgHeader
+	Err string `js:"err"`
+}
+
+// makePassphraseErr converts an error string returned by
+// ServePassphrasePrompts to an error.
+func makePassphraseErr(s string) error {
+	switch s {
+	case "":
+		return nil
+	case ErrPassphraseNotPending.Error():
+		return ErrPassphraseNotPending
+	}
+	return errors.New(s)
+}
+
+// ServePassphrasePrompts allows other extension pages to list the keys
+// waiting for a passphrase, and enter passphrases for them, using
+// PassphrasePromptClient.
+func ServePassphrasePrompts(prompts *PassphrasePrompts, msg MessageReceiver) {
+	msg.OnMessage(func(headerObj *js.Object, sender *js.Object, sendResponse func(interface{})) bool {
+		if !fromExtension(sender, msg.ExtensionURL()) {
+			return false
+		}
+		header := &msgHeader{Object: headerObj}
+		switch header.Type {
+		case msgTypePassphrasePending:
+			rsp := &rspPassphrasePending{msgHeader: header}
+			rsp.Type = msgTypePassphrasePendingRsp
+			rsp.Requests = mustEncode(prompts.Pending())
+			sendResponse(rsp)
+		case msgTypePassphraseRespond:
+			m := &msgPassphraseRespond{msgHeader: header}
+			rsp := &rspPassphraseRespond{msgHeader: header}
+			rsp.Type = msgTypePassphraseRespondRsp
+			rsp.Err = makeErrStr(prompts.Respond(m.Request, m.Passphrase, m.OK))
+			sendResponse(rsp)
+		}
+		return false
+	})
+}
+
+// PassphrasePromptClient lists keys waiting for a passphrase, and enters
+// passphrases for them, using the PassphrasePrompts served by
+// ServePassphrasePrompts (typically in the background page).
+type PassphrasePromptClient struct {
+	msg MessageSender
+}
+
+// NewPassphrasePromptClient returns a PassphrasePromptClient that sends
+// requests using the supplied messaging API.
+func NewPassphrasePromptClient(msg MessageSender) *PassphrasePromptClient {
+	return &PassphrasePromptClient{msg: msg}
+}
+
+// Pending returns the keys waiting for a passphrase; see
+// PassphrasePrompts.Pending.
+func (c *PassphrasePromptClient) Pending(callback func(requests []*PassphraseRequest, err error)) {
+	msg := &msgPassphrasePending{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypePassphrasePending
+	sendMessage(c.msg, msg, func(rspObj *js.Object, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		rsp := &rspPassphrasePending{msgHeader: &msgHeader{Object: rspObj}}
+		var requests []*PassphraseRequest
+		if err := codec.Decode(rsp.Requests, &requests); err != nil {
+			callback(nil, fmt.Errorf("failed to decode passphrase requests: %v", err))
+			return
+		}
+		callback(requests, nil)
+	})
+}
+
+// Respond enters a passphrase for a key; see PassphrasePrompts.Respond.
+func (c *PassphrasePromptClient) Respond(request int, passphrase string, ok bool, callback func(err error)) {
+	msg := &msgPassphraseRespond{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypePassphraseRespond
+	msg.Request = request
+	msg.Passphrase = passphrase
+	msg.OK = ok
+	sendMessage(c.msg, msg, func(rspObj *js.Object, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		rsp := &rspPassphraseRespond{msgHeader: &msgHeader{Object: rspObj}}
+		callback(makePassphraseErr(rsp.Err))
+	})
+}
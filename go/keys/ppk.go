@@ -0,0 +1,50 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/provider"
+)
+
+// convertPPK converts a private key in PuTTY's format (version 2 or 3) to
+// PEM, in which keys are stored (see provider.EncodePEM).  An encrypted key
+// is decrypted using passphrase, and encrypted again with it, so that it
+// remains protected by the passphrase the user already knows.  The key's
+// comment is returned, since it is not recorded in all PEM encodings.
+func convertPPK(ppk, passphrase string) (pemPrivateKey, comment string, err error) {
+	priv, comment, err := keyformat.ParsePPK([]byte(ppk), []byte(passphrase))
+	if err == x509.IncorrectPasswordError {
+		if passphrase == "" {
+			return "", "", help.Errorf(help.IncorrectPassphrase, "failed to parse PuTTY key: it is encrypted, and no passphrase was entered")
+		}
+		return "", "", help.Errorf(help.IncorrectPassphrase, "failed to parse PuTTY key: %v", err)
+	} else if err != nil {
+		return "", "", help.Errorf(help.InvalidPrivateKey, "failed to parse PuTTY key: %v", err)
+	}
+
+	if !keyformat.PPKEncrypted([]byte(ppk)) {
+		passphrase = ""
+	}
+	pemPrivateKey, err = provider.EncodePEM(priv, comment, passphrase, rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return pemPrivateKey, comment, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAddPPK(t *testing.T) {
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		t.Fatalf("failed to parse private key: %v", err)
+	}
+	ppk, err := keyformat.EncodePrivate(priv, "user@host", keyformat.PrivatePPK, []byte("ppk-secret"), provider.NewDeterministicRand("ppk"))
+	if err != nil {
+		t.Fatalf("failed to encode PuTTY key: %v", err)
+	}
+
+	testcases := []struct {
+		description string
+		name        string
+		ppk         string
+		passphrase  string
+		wantName    string
+		wantErr     help.Code
+	}{
+		{
+			description: "correct passphrase",
+			name:        "putty-key",
+			ppk:         ppk,
+			passphrase:  "ppk-secret",
+			wantName:    "putty-key",
+		},
+		{
+			description: "name derived from comment",
+			ppk:         ppk,
+			passphrase:  "ppk-secret",
+			wantName:    "(user@host)",
+		},
+		{
+			description: "incorrect passphrase",
+			name:        "putty-key",
+			ppk:         ppk,
+			passphrase:  "wrong",
+			wantErr:     help.IncorrectPassphrase,
+		},
+		{
+			description: "missing passphrase",
+			name:        "putty-key",
+			ppk:         ppk,
+			wantErr:     help.IncorrectPassphrase,
+		},
+		{
+			description: "corrupt key",
+			name:        "putty-key",
+			ppk:         strings.Replace(ppk, "Private-Lines:", "Private-Lines: x", 1),
+			passphrase:  "ppk-secret",
+			wantErr:     help.InvalidPrivateKey,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+
+		err := syncAdd(mgr, tc.name, tc.ppk, &AddOptions{Passphrase: tc.passphrase})
+		if diff := pretty.Diff(help.CodeOf(err), tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error code; -got +want: %s", tc.description, diff)
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), tc.passphrase) && tc.passphrase != "" {
+				t.Errorf("%s: error reveals passphrase: %v", tc.description, err)
+			}
+			continue
+		}
+
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to read configured keys: %v", tc.description, err)
+		}
+		if len(configured) != 1 {
+			t.Fatalf("%s: incorrect number of configured keys; got %d, want 1", tc.description, len(configured))
+		}
+		k := configured[0]
+		if !strings.Contains(k.Name, tc.wantName) {
+			t.Errorf("%s: incorrect name; got %q, want it to contain %q", tc.description, k.Name, tc.wantName)
+		}
+		if !k.Encrypted {
+			t.Errorf("%s: key stored unencrypted", tc.description)
+		}
+
+		// The key remains protected by the passphrase it was
+		// imported with.
+		if err := syncLoad(mgr, k.ID, "wrong"); err == nil {
+			t.Errorf("%s: loaded key with incorrect passphrase", tc.description)
+		}
+		if err := syncLoad(mgr, k.ID, tc.passphrase); err != nil {
+			t.Errorf("%s: failed to load key: %v", tc.description, err)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// DefaultProfile is the profile to which keys belong unless assigned
+	// to another.  Clients that do not name a profile when connecting
+	// are served the default profile.
+	DefaultProfile = ""
+
+	// MaxProfileLength is the maximum length of the name of a profile.
+	MaxProfileLength = 32
+)
+
+// CheckProfile returns an error if name may not be used as the name of a
+// profile.  Clients select a profile by name when connecting (e.g., as the
+// name of the port they open), so names are restricted to lower-case
+// letters, digits and hyphens.
+func CheckProfile(name string) error {
+	if len(name) > MaxProfileLength {
+		return fmt.Errorf("profile name is %d characters long; it must be at most %d", len(name), MaxProfileLength)
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return fmt.Errorf("profile name %q may only contain lower-case letters, digits and hyphens", name)
+		}
+	}
+	return nil
+}
+
+// SetProfile implements Manager.SetProfile.
+func (m *manager) SetProfile(id ID, profile string, callback func(err error)) {
+	if err := CheckProfile(profile); err != nil {
+		callback(err)
+		return
+	}
+
+	m.writes.runErr(func(callback func(err error)) {
+		m.readKey(id, func(key *storedKey, err error) {
+			if err != nil {
+				callback(help.Errorf(help.StorageFailure, "failed to read key: %v", err))
+				return
+			}
+			if key == nil {
+				callback(help.Errorf(help.KeyNotFound, "failed to find key with ID %s", id))
+				return
+			}
+
+			key.Profile = profile
+			key.Updated = nowMillis()
+			data := map[string]interface{}{
+				storageKey(id): key.value(),
+			}
+			m.storeFor(key.DeviceOnly).Set(data, func(err error) {
+				if err != nil {
+					callback(help.Errorf(help.StorageFailure, "failed to write key: %v", err))
+					return
+				}
+				if m.audit != nil {
+					m.audit.Record(audit.NewEntry("profile", "options", string(id), true, fmt.Sprintf("moved key %q to %s", key.Name, profileText(profile))), nil)
+				}
+				callback(nil)
+			})
+		})
+	}, callback)
+}
+
+// profileText describes a profile in messages.
+func profileText(profile string) string {
+	if profile == DefaultProfile {
+		return "the default profile"
+	}
+	return fmt.Sprintf("profile %q", profile)
+}
+
+// Profiles returns the names of the profiles to which configured keys
+// belong, other than the default profile, in the order in which they are
+// first found.  callback is invoked with the result.
+func Profiles(mgr Manager, callback func(profiles []string, err error)) {
+	mgr.Configured(func(keys []*ConfiguredKey, err error) {
+		if err != nil && !IsDegraded(err) {
+			callback(nil, fmt.Errorf("failed to read configured keys: %v", err))
+			return
+		}
+		seen := make(map[string]bool)
+		var result []string
+		for _, k := range keys {
+			if k.Profile == DefaultProfile || seen[k.Profile] {
+				continue
+			}
+			seen[k.Profile] = true
+			result = append(result, k.Profile)
+		}
+		callback(result, nil)
+	})
+}
+
+// profileIndex records the profile to which each key belongs.  Keys that
+// are not configured belong to the default profile.
+type profileIndex struct {
+	// byID maps the IDs of configured keys to their profiles.
+	byID map[ID]string
+	// byFingerprint maps the SHA256 fingerprints of configured keys to
+	// their profiles, where known.
+	byFingerprint map[string]string
+}
+
+// newProfileIndex returns an index of the profiles of the keys in an
+// inventory.
+func newProfileIndex(items []*InventoryItem) *profileIndex {
+	x := &profileIndex{
+		byID:          make(map[ID]string),
+		byFingerprint: make(map[string]string),
+	}
+	for _, i := range items {
+		if i.ID == InvalidID {
+			continue
+		}
+		x.byID[i.ID] = i.Profile
+		if i.Fingerprint != "" {
+			x.byFingerprint[i.Fingerprint] = i.Profile
+		}
+	}
+	return x
+}
+
+// ofLoaded returns the profile to which a loaded key belongs.  The ID
+// recorded in its comment is preferred, so that a key is attributed to its
+// profile even if it was loaded after the inventory was taken.
+func (x *profileIndex) ofLoaded(k *agent.Key) string {
+	if id := (&LoadedKey{Comment: k.Comment}).ID(); id != InvalidID {
+		return x.byID[id]
+	}
+	return x.ofPublicKey(k)
+}
+
+// ofPublicKey returns the profile to which the key with the specified public
+// key belongs.
+func (x *profileIndex) ofPublicKey(pub ssh.PublicKey) string {
+	return x.byFingerprint[ssh.FingerprintSHA256(pub)]
+}
+
+// errNotInProfile is returned when a client asks to use a key that does not
+// belong to its profile.  It is indistinguishable from asking to use a key
+// that is not loaded, so that clients learn nothing about other profiles.
+var errNotInProfile = errors.New("key not found")
+
+// ProfileAgent is an agent.Agent that exposes only the keys belonging to a
+// single profile.  Keys belonging to other profiles are never listed, and
+// requests to sign with or remove them are refused as if they were not
+// loaded.  This allows several logical agents (e.g., one for work and one
+// for personal use) to share a single keyring, while a client of one never
+// sees the identities of another.
+//
+// Only the default profile may add keys, or lock and unlock the agent, since
+// these affect every profile.  Removing all keys removes only those in the
+// profile.
+//
+// Every request consults the manager for the profiles of the configured
+// keys, so ProfileAgent must not be called from a callback (e.g., one
+// invoked by the manager or by storage).
+type ProfileAgent struct {
+	agent   agent.Agent
+	mgr     Manager
+	profile string
+}
+
+// NewProfileAgent returns a ProfileAgent that exposes the keys in a
+// belonging to the named profile.  mgr is used to find the profile of each
+// key.
+func NewProfileAgent(a agent.Agent, mgr Manager, profile string) *ProfileAgent {
+	return &ProfileAgent{
+		agent:   a,
+		mgr:     mgr,
+		profile: profile,
+	}
+}
+
+// index returns the profile of each configured key.
+func (p *ProfileAgent) index() (*profileIndex, error) {
+	done := make(chan struct{})
+	var items []*InventoryItem
+	var err error
+	p.mgr.Inventory(func(i []*InventoryItem, e error) {
+		items, err = i, e
+		close(done)
+	})
+	<-done
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles: %v", err)
+	}
+	return newProfileIndex(items), nil
+}
+
+// restricted returns an error if the profile may not perform an operation
+// that affects every profile.
+func (p *ProfileAgent) restricted(op string) error {
+	if p.profile == DefaultProfile {
+		return nil
+	}
+	return fmt.Errorf("keys may not be %s by clients of %s", op, profileText(p.profile))
+}
+
+// List implements agent.Agent.List.
+func (p *ProfileAgent) List() ([]*agent.Key, error) {
+	loaded, err := p.agent.List()
+	if err != nil {
+		return nil, err
+	}
+	x, err := p.index()
+	if err != nil {
+		return nil, err
+	}
+	var result []*agent.Key
+	for _, l := range loaded {
+		if x.ofLoaded(l) == p.profile {
+			result = append(result, l)
+		}
+	}
+	return result, nil
+}
+
+// member determines if the key with the specified public key belongs to the
+// profile.  A key that is loaded is attributed by its comment; otherwise, by
+// the fingerprint recorded for the configured key.
+func (p *ProfileAgent) member(key ssh.PublicKey) (bool, error) {
+	x, err := p.index()
+	if err != nil {
+		return false, err
+	}
+	loaded, err := p.agent.List()
+	if err != nil {
+		return false, err
+	}
+	blob := string(key.Marshal())
+	for _, l := range loaded {
+		if string(l.Blob) == blob {
+			return x.ofLoaded(l) == p.profile, nil
+		}
+	}
+	return x.ofPublicKey(key) == p.profile, nil
+}
+
+// Sign implements agent.Agent.Sign.
+func (p *ProfileAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	ok, err := p.member(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errNotInProfile
+	}
+	return p.agent.Sign(key, data)
+}
+
+// Add implements agent.Agent.Add.
+func (p *ProfileAgent) Add(key agent.AddedKey) error {
+	if err := p.restricted("added"); err != nil {
+		return err
+	}
+	return p.agent.Add(key)
+}
+
+// Remove implements agent.Agent.Remove.
+func (p *ProfileAgent) Remove(key ssh.PublicKey) error {
+	ok, err := p.member(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotInProfile
+	}
+	return p.agent.Remove(key)
+}
+
+// RemoveAll implements agent.Agent.RemoveAll.  Only the keys belonging to
+// the profile are removed.
+func (p *ProfileAgent) RemoveAll() error {
+	loaded, err := p.List()
+	if err != nil {
+		return err
+	}
+	for _, l := range loaded {
+		if err := p.agent.Remove(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lock implements agent.Agent.Lock.
+func (p *ProfileAgent) Lock(passphrase []byte) error {
+	if err := p.restricted("locked"); err != nil {
+		return err
+	}
+	return p.agent.Lock(passphrase)
+}
+
+// Unlock implements agent.Agent.Unlock.
+func (p *ProfileAgent) Unlock(passphrase []byte) error {
+	if err := p.restricted("unlocked"); err != nil {
+		return err
+	}
+	return p.agent.Unlock(passphrase)
+}
+
+// Signers implements agent.Agent.Signers.
+func (p *ProfileAgent) Signers() ([]ssh.Signer, error) {
+	signers, err := p.agent.Signers()
+	if err != nil {
+		return nil, err
+	}
+	loaded, err := p.List()
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]bool)
+	for _, l := range loaded {
+		members[string(l.Blob)] = true
+	}
+	var result []ssh.Signer
+	for _, s := range signers {
+		if members[string(s.PublicKey().Marshal())] {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// ProfileRouter selects the profile served to each client by the channel on
+// which it connected (see transport.Router).  Clients connecting without
+// naming a channel are served the default profile; any other channel must
+// name a profile to which at least one configured key belongs.
+type ProfileRouter struct {
+	agent agent.Agent
+	mgr   Manager
+}
+
+// NewProfileRouter returns a ProfileRouter serving the keys in a, using mgr
+// to find the profile of each key.
+func NewProfileRouter(a agent.Agent, mgr Manager) *ProfileRouter {
+	return &ProfileRouter{
+		agent: a,
+		mgr:   mgr,
+	}
+}
+
+// Route implements transport.Router.Route.
+func (r *ProfileRouter) Route(channel string, callback func(a agent.Agent, err error)) {
+	if channel == DefaultProfile {
+		callback(NewProfileAgent(r.agent, r.mgr, DefaultProfile), nil)
+		return
+	}
+	Profiles(r.mgr, func(profiles []string, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		for _, p := range profiles {
+			if p == channel {
+				callback(NewProfileAgent(r.agent, r.mgr, p), nil)
+				return
+			}
+		}
+		callback(nil, fmt.Errorf("unknown profile %q", channel))
+	})
+}
@@ -0,0 +1,247 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestCheckProfile(t *testing.T) {
+	testcases := []struct {
+		description string
+		profile     string
+		wantErr     bool
+	}{
+		{description: "default profile", profile: DefaultProfile},
+		{description: "valid name", profile: "work-2"},
+		{description: "upper case", profile: "Work", wantErr: true},
+		{description: "space", profile: "my work", wantErr: true},
+		{description: "too long", profile: "abcdefghijklmnopqrstuvwxyz0123456", wantErr: true},
+	}
+
+	for _, tc := range testcases {
+		err := CheckProfile(tc.profile)
+		if got := err != nil; got != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %v", tc.description, err, tc.wantErr)
+		}
+	}
+}
+
+// newProfileManager returns a manager with a personal key in the default
+// profile and a work key in the 'work' profile, both loaded into a.
+func newProfileManager(t *testing.T, a agent.Agent) Manager {
+	mgr := NewManager(a, fakes.NewMemStorage(), fakes.NewMemStorage())
+	if err := syncAdd(mgr, "personal", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+		t.Fatalf("failed to add personal key: %v", err)
+	}
+	if err := syncAdd(mgr, "work", testdata.ValidPrivateKey, &AddOptions{Profile: "work"}); err != nil {
+		t.Fatalf("failed to add work key: %v", err)
+	}
+	for _, k := range []struct {
+		name       string
+		passphrase string
+	}{
+		{name: "personal"},
+		{name: "work", passphrase: testdata.ValidPrivateKeyPassphrase},
+	} {
+		id, err := findKey(mgr, InvalidID, k.name)
+		if err != nil {
+			t.Fatalf("failed to find %s key: %v", k.name, err)
+		}
+		if err := syncLoad(mgr, id, k.passphrase); err != nil {
+			t.Fatalf("failed to load %s key: %v", k.name, err)
+		}
+	}
+	return mgr
+}
+
+func agentKeyBlobs(keys []*agent.Key) []string {
+	var result []string
+	for _, k := range keys {
+		result = append(result, base64.StdEncoding.EncodeToString(k.Blob))
+	}
+	return result
+}
+
+func TestProfileAgent(t *testing.T) {
+	personal := testdata.ValidPrivateKeyWithoutPassphraseBlob
+	work := testdata.ValidPrivateKeyBlob
+
+	testcases := []struct {
+		description string
+		profile     string
+		wantListed  []string
+		wantSigned  []string
+		wantRefused []string
+	}{
+		{
+			description: "default profile",
+			profile:     DefaultProfile,
+			wantListed:  []string{personal},
+			wantSigned:  []string{personal},
+			wantRefused: []string{work},
+		},
+		{
+			description: "work profile",
+			profile:     "work",
+			wantListed:  []string{work},
+			wantSigned:  []string{work},
+			wantRefused: []string{personal},
+		},
+		{
+			description: "profile without keys",
+			profile:     "other",
+			wantRefused: []string{personal, work},
+		},
+	}
+
+	for _, tc := range testcases {
+		kr := keyring.New()
+		mgr := newProfileManager(t, kr)
+		a := NewProfileAgent(kr, mgr, tc.profile)
+
+		listed, err := a.List()
+		if err != nil {
+			t.Errorf("%s: failed to list keys: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(agentKeyBlobs(listed), tc.wantListed); diff != nil {
+			t.Errorf("%s: incorrect listed keys; -got +want: %s", tc.description, diff)
+		}
+
+		for _, b := range tc.wantSigned {
+			if _, err := a.Sign(mustParseBlob(b), []byte("some-data")); err != nil {
+				t.Errorf("%s: failed to sign: %v", tc.description, err)
+			}
+		}
+		for _, b := range tc.wantRefused {
+			_, err := a.Sign(mustParseBlob(b), []byte("some-data"))
+			if diff := pretty.Diff(err, errNotInProfile); diff != nil {
+				t.Errorf("%s: incorrect error signing with key in another profile; -got +want: %s", tc.description, diff)
+			}
+			if diff := pretty.Diff(a.Remove(mustParseBlob(b)), errNotInProfile); diff != nil {
+				t.Errorf("%s: incorrect error removing key in another profile; -got +want: %s", tc.description, diff)
+			}
+		}
+
+		signers, err := a.Signers()
+		if err != nil {
+			t.Errorf("%s: failed to get signers: %v", tc.description, err)
+		}
+		if len(signers) != len(tc.wantListed) {
+			t.Errorf("%s: incorrect number of signers; got %d, want %d", tc.description, len(signers), len(tc.wantListed))
+		}
+
+		// Keys are only locked, unlocked and added by the default
+		// profile.
+		if err := a.Lock([]byte("secret")); (err != nil) != (tc.profile != DefaultProfile) {
+			t.Errorf("%s: incorrect error locking agent: %v", tc.description, err)
+		}
+		if err := a.Unlock([]byte("secret")); (err != nil) != (tc.profile != DefaultProfile) {
+			t.Errorf("%s: incorrect error unlocking agent: %v", tc.description, err)
+		}
+
+		// Removing all keys leaves those of other profiles loaded.
+		if err := a.RemoveAll(); err != nil {
+			t.Errorf("%s: failed to remove all keys: %v", tc.description, err)
+		}
+		loaded, err := kr.List()
+		if err != nil {
+			t.Errorf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		if got, want := len(loaded), 2-len(tc.wantListed); got != want {
+			t.Errorf("%s: incorrect number of keys left loaded; got %d, want %d", tc.description, got, want)
+		}
+	}
+}
+
+func TestSetProfile(t *testing.T) {
+	kr := keyring.New()
+	mgr := newProfileManager(t, kr)
+	id, err := findKey(mgr, InvalidID, "work")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+
+	if err := syncSetProfile(mgr, id, "Not Valid"); err == nil {
+		t.Errorf("invalid profile name unexpectedly accepted")
+	}
+	if err := syncSetProfile(mgr, ID("missing"), "work"); err == nil {
+		t.Errorf("profile of missing key unexpectedly set")
+	}
+
+	// Moving the work key to the default profile lists it to clients of
+	// the default profile, and removes the work profile.
+	if err := syncSetProfile(mgr, id, DefaultProfile); err != nil {
+		t.Fatalf("failed to set profile: %v", err)
+	}
+	listed, err := NewProfileAgent(kr, mgr, DefaultProfile).List()
+	if err != nil {
+		t.Errorf("failed to list keys: %v", err)
+	}
+	if len(listed) != 2 {
+		t.Errorf("incorrect number of listed keys; got %d, want 2", len(listed))
+	}
+	NewProfileRouter(kr, mgr).Route("work", func(a agent.Agent, err error) {
+		if err == nil {
+			t.Errorf("removed profile unexpectedly routed")
+		}
+	})
+}
+
+func TestProfileRouter(t *testing.T) {
+	testcases := []struct {
+		description string
+		channel     string
+		wantErr     error
+	}{
+		{
+			description: "default profile",
+			channel:     DefaultProfile,
+		},
+		{
+			description: "profile with keys",
+			channel:     "work",
+		},
+		{
+			description: "unknown profile",
+			channel:     "personal",
+			wantErr:     errors.New(`unknown profile "personal"`),
+		},
+	}
+
+	for _, tc := range testcases {
+		kr := keyring.New()
+		r := NewProfileRouter(kr, newProfileManager(t, kr))
+		r.Route(tc.channel, func(a agent.Agent, err error) {
+			if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+				t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+			}
+			if err != nil {
+				return
+			}
+			if got := a.(*ProfileAgent).profile; got != tc.channel {
+				t.Errorf("%s: incorrect profile; got %q, want %q", tc.description, got, tc.channel)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// DefaultPassphraseAttempts is the number of passphrases a user may enter
+// when loading a key before giving up.
+const DefaultPassphraseAttempts = 3
+
+// ErrLoadCancelled is returned when loading a key is abandoned because no
+// passphrase was supplied.
+var ErrLoadCancelled = errors.New("load cancelled")
+
+// PassphraseFunc supplies the passphrase for an attempt to load a key.
+// attempt is the number of the attempt, starting at 1, and remaining is the
+// number of attempts left, including this one.  lastErr is the error from the
+// previous attempt, or nil for the first.  callback is invoked with the
+// passphrase; ok is false if loading should be abandoned.
+type PassphraseFunc func(attempt, remaining int, lastErr error, callback func(passphrase string, ok bool))
+
+// LoadWithPassphrase loads the key with the specified ID using mgr, obtaining
+// the passphrase from passphrase.  If the passphrase is incorrect, passphrase
+// is invoked again, up to attempts times in total, so that the user can be
+// asked again without restarting the load.  Other errors are not retried.
+// callback is invoked with the outcome of the last attempt, or
+// ErrLoadCancelled if no passphrase was supplied.
+func LoadWithPassphrase(mgr Manager, id ID, attempts int, passphrase PassphraseFunc, callback func(err error)) {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var try func(attempt int, lastErr error)
+	try = func(attempt int, lastErr error) {
+		passphrase(attempt, attempts-attempt+1, lastErr, func(p string, ok bool) {
+			if !ok {
+				callback(ErrLoadCancelled)
+				return
+			}
+			mgr.Load(id, p, func(err error) {
+				if help.CodeOf(err) == help.IncorrectPassphrase && attempt < attempts {
+					try(attempt+1, err)
+					return
+				}
+				callback(err)
+			})
+		})
+	}
+	try(1, nil)
+}
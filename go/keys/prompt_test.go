@@ -0,0 +1,126 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestLoadWithPassphrase(t *testing.T) {
+	testcases := []struct {
+		description string
+		id          ID
+		passphrases []string
+		wantPrompts []string
+		wantLoaded  bool
+		wantErr     help.Code
+		wantErrIs   error
+	}{
+		{
+			description: "correct passphrase",
+			passphrases: []string{testdata.ValidPrivateKeyPassphrase},
+			wantPrompts: []string{"1/3 <nil>"},
+			wantLoaded:  true,
+		},
+		{
+			description: "correct passphrase after incorrect passphrase",
+			passphrases: []string{"incorrect", testdata.ValidPrivateKeyPassphrase},
+			wantPrompts: []string{"1/3 <nil>", "2/2 incorrect-passphrase"},
+			wantLoaded:  true,
+		},
+		{
+			description: "give up after attempts exhausted",
+			passphrases: []string{"incorrect", "incorrect", "incorrect", testdata.ValidPrivateKeyPassphrase},
+			wantPrompts: []string{"1/3 <nil>", "2/2 incorrect-passphrase", "3/1 incorrect-passphrase"},
+			wantErr:     help.IncorrectPassphrase,
+		},
+		{
+			description: "cancel after incorrect passphrase",
+			passphrases: []string{"incorrect"},
+			wantPrompts: []string{"1/3 <nil>", "2/2 incorrect-passphrase"},
+			wantErrIs:   ErrLoadCancelled,
+		},
+		{
+			description: "do not retry other errors",
+			id:          ID("missing"),
+			passphrases: []string{testdata.ValidPrivateKeyPassphrase, testdata.ValidPrivateKeyPassphrase},
+			wantPrompts: []string{"1/3 <nil>"},
+			wantErr:     help.KeyNotFound,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), []*initialKey{
+			{
+				Name:          "good-key",
+				PEMPrivateKey: testdata.ValidPrivateKey,
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to initialize manager: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, tc.id, "good-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+
+		var prompts []string
+		passphrases := tc.passphrases
+		errc := make(chan error, 1)
+		LoadWithPassphrase(mgr, id, DefaultPassphraseAttempts, func(attempt, remaining int, lastErr error, callback func(passphrase string, ok bool)) {
+			code := "<nil>"
+			if lastErr != nil {
+				code = string(help.CodeOf(lastErr))
+			}
+			prompts = append(prompts, fmt.Sprintf("%d/%d %s", attempt, remaining, code))
+			if len(passphrases) == 0 {
+				callback("", false)
+				return
+			}
+			p := passphrases[0]
+			passphrases = passphrases[1:]
+			callback(p, true)
+		}, func(err error) {
+			errc <- err
+			close(errc)
+		})
+		err = readErr(errc)
+
+		if tc.wantErrIs != nil && err != tc.wantErrIs {
+			t.Errorf("%s: incorrect error; got %v, want %v", tc.description, err, tc.wantErrIs)
+		}
+		if tc.wantErrIs == nil && help.CodeOf(err) != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want code %s", tc.description, err, tc.wantErr)
+		}
+		if diff := pretty.Diff(prompts, tc.wantPrompts); diff != nil {
+			t.Errorf("%s: incorrect prompts; -got +want: %s", tc.description, diff)
+		}
+
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Errorf("%s: failed to get loaded keys: %v", tc.description, err)
+		}
+		if got := len(loaded) == 1; got != tc.wantLoaded {
+			t.Errorf("%s: incorrect loaded state; got %t, want %t", tc.description, got, tc.wantLoaded)
+		}
+	}
+}
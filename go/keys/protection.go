@@ -0,0 +1,98 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// Protection describes how a configured key is protected at rest.
+type Protection string
+
+const (
+	// ProtectionDefault selects the protection implied by the private
+	// key: ProtectionPassphrase if it is encrypted, and
+	// ProtectionPlaintext otherwise.
+	ProtectionDefault Protection = ""
+	// ProtectionPassphrase indicates that the private key is stored
+	// encrypted with a passphrase chosen by the user, which is required
+	// to load or export it.
+	ProtectionPassphrase Protection = "passphrase"
+	// ProtectionPlaintext indicates that the private key is stored
+	// unencrypted.  Anyone with access to the browser profile (or, unless
+	// the key is stored only on this device, the Chrome Sync account) can
+	// read it.
+	ProtectionPlaintext Protection = "plaintext"
+)
+
+// Description returns a human-readable description of the protection.
+func (p Protection) Description() string {
+	switch p {
+	case ProtectionPassphrase:
+		return "encrypted with a passphrase"
+	case ProtectionPlaintext:
+		return "stored unencrypted"
+	default:
+		return "unknown protection"
+	}
+}
+
+// protectionFor returns the protection with which a private key is stored,
+// given the protection requested when it was added.  It fails if the key is
+// not stored as requested.
+func protectionFor(requested Protection, encrypted bool) (Protection, error) {
+	switch requested {
+	case ProtectionDefault:
+		if encrypted {
+			return ProtectionPassphrase, nil
+		}
+		return ProtectionPlaintext, nil
+	case ProtectionPassphrase:
+		if !encrypted {
+			return ProtectionDefault, help.Errorf(help.InvalidPrivateKey, "private key must be encrypted with a passphrase")
+		}
+	case ProtectionPlaintext:
+		if encrypted {
+			return ProtectionDefault, help.Errorf(help.InvalidPrivateKey, "private key must not be encrypted")
+		}
+	default:
+		return ProtectionDefault, help.Errorf(help.InvalidPrivateKey, "unknown protection %q", string(requested))
+	}
+	return requested, nil
+}
+
+// protection returns the protection with which the key is stored.  Keys
+// configured by older versions do not record it; it is implied by the
+// private key.
+func (s *storedKey) protection() Protection {
+	if s.Protection != ProtectionDefault {
+		return s.Protection
+	}
+	if s.Encrypted() {
+		return ProtectionPassphrase
+	}
+	return ProtectionPlaintext
+}
+
+// checkProtection verifies that the key is stored with the protection that
+// was recorded when it was added.  A key configured to be protected by a
+// passphrase that is stored unencrypted (e.g., because it was replaced in
+// storage) is refused rather than silently used without one.
+func (s *storedKey) checkProtection() error {
+	if _, err := protectionFor(s.Protection, s.pemEncrypted()); err != nil {
+		return help.Errorf(help.InvalidPrivateKey, "key is not stored as %s: %v", s.protection().Description(), err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestProtectionFor(t *testing.T) {
+	testcases := []struct {
+		description string
+		requested   Protection
+		encrypted   bool
+		want        Protection
+		wantErr     bool
+	}{
+		{
+			description: "default for encrypted key",
+			encrypted:   true,
+			want:        ProtectionPassphrase,
+		},
+		{
+			description: "default for unencrypted key",
+			want:        ProtectionPlaintext,
+		},
+		{
+			description: "passphrase for encrypted key",
+			requested:   ProtectionPassphrase,
+			encrypted:   true,
+			want:        ProtectionPassphrase,
+		},
+		{
+			description: "passphrase for unencrypted key",
+			requested:   ProtectionPassphrase,
+			wantErr:     true,
+		},
+		{
+			description: "plaintext for unencrypted key",
+			requested:   ProtectionPlaintext,
+			want:        ProtectionPlaintext,
+		},
+		{
+			description: "plaintext for encrypted key",
+			requested:   ProtectionPlaintext,
+			encrypted:   true,
+			wantErr:     true,
+		},
+		{
+			description: "unknown protection",
+			requested:   Protection("bogus"),
+			encrypted:   true,
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := protectionFor(tc.requested, tc.encrypted)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %v", tc.description, err, tc.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect protection; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestAddProtection(t *testing.T) {
+	testcases := []struct {
+		description    string
+		pemPrivateKey  string
+		protection     Protection
+		wantProtection Protection
+		wantErrCode    help.Code
+	}{
+		{
+			description:    "encrypted key",
+			pemPrivateKey:  testdata.ValidPrivateKey,
+			wantProtection: ProtectionPassphrase,
+		},
+		{
+			description:    "unencrypted key",
+			pemPrivateKey:  testdata.ValidPrivateKeyWithoutPassphrase,
+			wantProtection: ProtectionPlaintext,
+		},
+		{
+			description:    "explicit protection",
+			pemPrivateKey:  testdata.ValidPrivateKey,
+			protection:     ProtectionPassphrase,
+			wantProtection: ProtectionPassphrase,
+		},
+		{
+			description:   "fail on unencrypted key requiring passphrase",
+			pemPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			protection:    ProtectionPassphrase,
+			wantErrCode:   help.InvalidPrivateKey,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		err := syncAdd(mgr, "some-key", tc.pemPrivateKey, &AddOptions{Protection: tc.protection})
+		if diff := pretty.Diff(help.CodeOf(err), tc.wantErrCode); diff != nil {
+			t.Errorf("%s: incorrect error %v; -got +want: %s", tc.description, err, diff)
+		}
+
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to get configured keys: %v", tc.description, err)
+		}
+		var got []Protection
+		for _, k := range configured {
+			got = append(got, k.Protection)
+		}
+		var want []Protection
+		if tc.wantProtection != ProtectionDefault {
+			want = append(want, tc.wantProtection)
+		}
+		if diff := pretty.Diff(got, want); diff != nil {
+			t.Errorf("%s: incorrect protection; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestStoredProtection(t *testing.T) {
+	testcases := []struct {
+		description    string
+		protection     Protection
+		pemPrivateKey  string
+		passphrase     string
+		wantProtection Protection
+		wantErrCode    help.Code
+	}{
+		{
+			description:    "encrypted key",
+			protection:     ProtectionPassphrase,
+			pemPrivateKey:  testdata.ValidPrivateKey,
+			passphrase:     testdata.ValidPrivateKeyPassphrase,
+			wantProtection: ProtectionPassphrase,
+		},
+		{
+			description:    "unencrypted key",
+			protection:     ProtectionPlaintext,
+			pemPrivateKey:  testdata.ValidPrivateKeyWithoutPassphrase,
+			wantProtection: ProtectionPlaintext,
+		},
+		{
+			description:    "protection not recorded",
+			pemPrivateKey:  testdata.ValidPrivateKeyWithoutPassphrase,
+			wantProtection: ProtectionPlaintext,
+		},
+		{
+			description:    "refuse encrypted key stored unencrypted",
+			protection:     ProtectionPassphrase,
+			pemPrivateKey:  testdata.ValidPrivateKeyWithoutPassphrase,
+			wantProtection: ProtectionPassphrase,
+			wantErrCode:    help.InvalidPrivateKey,
+		},
+	}
+
+	for _, tc := range testcases {
+		data := storedKeyData(ID("1"), "some-key", tc.pemPrivateKey, 100)
+		if tc.protection != ProtectionDefault {
+			data["protection"] = string(tc.protection)
+		}
+		storage := fakes.NewMemStorage()
+		storage.Set(map[string]interface{}{storageKey(ID("1")): data}, func(err error) {
+			if err != nil {
+				t.Fatalf("%s: failed to write key: %v", tc.description, err)
+			}
+		})
+		mgr := NewManager(agent.NewKeyring(), storage, fakes.NewMemStorage())
+
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to get configured keys: %v", tc.description, err)
+		}
+		if len(configured) != 1 {
+			t.Fatalf("%s: incorrect number of configured keys; got %d, want 1", tc.description, len(configured))
+		}
+		if diff := pretty.Diff(configured[0].Protection, tc.wantProtection); diff != nil {
+			t.Errorf("%s: incorrect protection; -got +want: %s", tc.description, diff)
+		}
+
+		err = syncLoad(mgr, ID("1"), tc.passphrase)
+		if diff := pretty.Diff(help.CodeOf(err), tc.wantErrCode); diff != nil {
+			t.Errorf("%s: incorrect error from Load %v; -got +want: %s", tc.description, err, diff)
+		}
+		_, err = syncExport(mgr, ID("1"), tc.passphrase, keyformat.PrivateOpenSSH, "export-passphrase")
+		if diff := pretty.Diff(help.CodeOf(err), tc.wantErrCode); diff != nil {
+			t.Errorf("%s: incorrect error from Export %v; -got +want: %s", tc.description, err, diff)
+		}
+	}
+}
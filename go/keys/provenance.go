@@ -0,0 +1,158 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// Source describes how a key entered the system.
+type Source string
+
+const (
+	// SourceUnknown indicates that the source of the key was not recorded
+	// (e.g., the key was configured by an earlier version).
+	SourceUnknown Source = ""
+	// SourcePasted indicates that the key was pasted by the user.
+	SourcePasted Source = "pasted"
+	// SourceFile indicates that the key was imported from a file.
+	SourceFile Source = "file"
+	// SourceGenerated indicates that the key was generated by the
+	// extension.
+	SourceGenerated Source = "generated"
+	// SourceClient indicates that the key was pushed by a client; the
+	// source detail identifies the client.
+	SourceClient Source = "client"
+	// SourceProvisioned indicates that the key was provisioned by an
+	// administrator; the source detail identifies the manifest from which
+	// it was provisioned.
+	SourceProvisioned Source = "provisioned"
+	// SourceSynced matches keys that were configured on another device
+	// and delivered by Chrome Sync.  It is only used for filtering; keys
+	// retain the source recorded on the device where they were added.
+	SourceSynced Source = "synced"
+)
+
+// Sources lists the sources by which keys may be filtered.
+var Sources = []Source{SourcePasted, SourceFile, SourceGenerated, SourceClient, SourceProvisioned, SourceSynced, SourceUnknown}
+
+// Description returns a human-readable description of the source.
+func (s Source) Description() string {
+	switch s {
+	case SourcePasted:
+		return "Pasted"
+	case SourceFile:
+		return "Imported from file"
+	case SourceGenerated:
+		return "Generated"
+	case SourceClient:
+		return "Pushed by client"
+	case SourceProvisioned:
+		return "Provisioned by administrator"
+	case SourceSynced:
+		return "Synced from another device"
+	}
+	return "Unknown"
+}
+
+// Provenance returns a human-readable description of how the key entered the
+// system.
+func (k *ConfiguredKey) Provenance() string {
+	desc := k.Source.Description()
+	if k.SourceDetail != "" {
+		desc = fmt.Sprintf("%s (%s)", desc, k.SourceDetail)
+	}
+	if k.Synced && k.DeviceName != "" {
+		desc = fmt.Sprintf("%s on %s", desc, k.DeviceName)
+	}
+	return desc
+}
+
+// MatchesSource determines if the key entered the system via the specified
+// source.  SourceSynced matches all keys configured on another device.
+func (k *ConfiguredKey) MatchesSource(s Source) bool {
+	if s == SourceSynced {
+		return k.Synced
+	}
+	return k.Source == s
+}
+
+// FilterBySource returns the keys that entered the system via the specified
+// source.
+func FilterBySource(keys []*ConfiguredKey, s Source) []*ConfiguredKey {
+	var result []*ConfiguredKey
+	for _, k := range keys {
+		if k.MatchesSource(s) {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
+const (
+	// deviceIDKey is the key in local storage under which the ID for
+	// this device is stored.
+	deviceIDKey = "device.id"
+)
+
+// device returns the unique ID for this device, allocating one if needed.
+// callback is invoked with the result.
+func (m *manager) device(callback func(id string, err error)) {
+	if m.deviceID != "" {
+		callback(m.deviceID, nil)
+		return
+	}
+
+	var id string
+	var deviceErr error
+	d := newDispatcher(func() { callback(id, deviceErr) })
+	op := d.add("read device ID")
+	m.localStorage.Get(func(data map[string]interface{}, err error) {
+		if !op.start() {
+			return
+		}
+		if err != nil {
+			deviceErr = fmt.Errorf("failed to read device ID: %v", err)
+			op.done()
+			return
+		}
+		if stored, ok := data[deviceIDKey].(string); ok && stored != "" {
+			m.deviceID = stored
+			id = stored
+			op.done()
+			return
+		}
+
+		newDevice, err := newID(m.providers.Default().Rand())
+		if err != nil {
+			deviceErr = help.Wrap(err, "failed to generate device ID")
+			op.done()
+			return
+		}
+		m.localStorage.Set(map[string]interface{}{deviceIDKey: string(newDevice)}, func(err error) {
+			if err != nil {
+				deviceErr = fmt.Errorf("failed to write device ID: %v", err)
+				op.done()
+				return
+			}
+			m.deviceID = string(newDevice)
+			id = m.deviceID
+			op.done()
+		})
+	})
+	d.wait()
+}
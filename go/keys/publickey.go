@@ -0,0 +1,90 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"golang.org/x/crypto/ssh"
+)
+
+// PublicKey implements Manager.PublicKey.
+func (m *manager) PublicKey(id ID, format keyformat.PublicFormat, callback func(encoded string, err error)) {
+	m.readKey(id, func(key *storedKey, err error) {
+		if err != nil {
+			callback("", help.Errorf(help.StorageFailure, "failed to read key: %v", err))
+			return
+		}
+		if key == nil {
+			callback("", help.Errorf(help.KeyNotFound, "failed to find key with ID %s", id))
+			return
+		}
+
+		pub, err := m.configuredPublicKey(key)
+		if err != nil {
+			callback("", err)
+			return
+		}
+		encoded, err := keyformat.EncodePublic(pub, key.Name, format)
+		if err != nil {
+			callback("", fmt.Errorf("failed to export public key: %v", err))
+			return
+		}
+		callback(encoded, nil)
+	})
+}
+
+// configuredPublicKey determines the public key of a configured key without
+// its passphrase.  It is taken from the key if it is loaded, from the
+// private key if it is not encrypted, or from the unencrypted copy of the
+// public key that OpenSSH's format records alongside an encrypted private
+// key.  Other encrypted keys must be loaded first.
+func (m *manager) configuredPublicKey(key *storedKey) (ssh.PublicKey, error) {
+	loaded, err := m.agent.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list loaded keys: %v", err)
+	}
+	for _, l := range loaded {
+		lk := &LoadedKey{Comment: l.Comment}
+		if lk.ID() != key.ID {
+			continue
+		}
+		pub, err := ssh.ParsePublicKey(l.Marshal())
+		if err != nil {
+			continue
+		}
+		// A certificate loaded with the key has the same ID; export
+		// the key it certifies.
+		if cert, ok := pub.(*ssh.Certificate); ok {
+			pub = cert.Key
+		}
+		return pub, nil
+	}
+
+	if err := m.unseal(key); err != nil {
+		return nil, err
+	}
+	if !key.Encrypted() {
+		if pub := m.publicKey(key); pub != nil {
+			return pub, nil
+		}
+	}
+	if pub, _, err := keyformat.InspectOpenSSH([]byte(key.PEMPrivateKey)); err == nil {
+		return pub, nil
+	}
+	return nil, fmt.Errorf("the public key of %q is only available once the key is loaded", key.Name)
+}
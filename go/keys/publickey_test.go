@@ -0,0 +1,137 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestPublicKey(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(provider.NewDeterministicRand("public-key"))
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	edSigner, err := ssh.NewSignerFromKey(edKey)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	encryptedOpenSSH, err := keyformat.MarshalOpenSSH(edKey, "user@host", []byte("secret"), provider.NewDeterministicRand("public-key"))
+	if err != nil {
+		t.Fatalf("failed to encode key: %v", err)
+	}
+	plaintext := mustParseBlob(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+	encrypted := mustParseBlob(testdata.ValidPrivateKeyBlob)
+
+	mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), []*initialKey{
+		{
+			Name:          "plaintext-key",
+			PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+		},
+		{
+			Name:          "encrypted-key",
+			PEMPrivateKey: testdata.ValidPrivateKey,
+		},
+		{
+			Name:          "encrypted-openssh-key",
+			PEMPrivateKey: encryptedOpenSSH,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+
+	testcases := []struct {
+		description string
+		name        string
+		passphrase  string
+		format      keyformat.PublicFormat
+		want        ssh.PublicKey
+		wantErr     error
+	}{
+		{
+			description: "unencrypted key",
+			name:        "plaintext-key",
+			format:      keyformat.PublicOpenSSH,
+			want:        plaintext,
+		},
+		{
+			description: "RFC 4716 format",
+			name:        "plaintext-key",
+			format:      keyformat.PublicRFC4716,
+			want:        plaintext,
+		},
+		{
+			description: "encrypted key in OpenSSH format",
+			name:        "encrypted-openssh-key",
+			format:      keyformat.PublicOpenSSH,
+			want:        edSigner.PublicKey(),
+		},
+		{
+			description: "encrypted key not loaded",
+			name:        "encrypted-key",
+			format:      keyformat.PublicOpenSSH,
+			wantErr:     errors.New(`the public key of "encrypted-key" is only available once the key is loaded`),
+		},
+		{
+			description: "encrypted key loaded",
+			name:        "encrypted-key",
+			passphrase:  testdata.ValidPrivateKeyPassphrase,
+			format:      keyformat.PublicOpenSSH,
+			want:        encrypted,
+		},
+	}
+
+	for _, tc := range testcases {
+		id, err := findKey(mgr, InvalidID, tc.name)
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if tc.passphrase != "" {
+			if err := syncLoad(mgr, id, tc.passphrase); err != nil {
+				t.Fatalf("%s: failed to load key: %v", tc.description, err)
+			}
+		}
+
+		encoded, err := syncPublicKey(mgr, id, tc.format)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if tc.want == nil {
+			continue
+		}
+		want, err := keyformat.EncodePublic(tc.want, tc.name, tc.format)
+		if err != nil {
+			t.Fatalf("%s: failed to encode public key: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(encoded, want); diff != nil {
+			t.Errorf("%s: incorrect public key; -got +want: %s", tc.description, diff)
+		}
+	}
+
+	if _, err := syncPublicKey(mgr, ID("missing"), keyformat.PublicOpenSSH); help.CodeOf(err) != help.KeyNotFound {
+		t.Errorf("incorrect error for missing key; got %v, want code %s", err, help.KeyNotFound)
+	}
+}
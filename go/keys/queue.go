@@ -0,0 +1,79 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"sync"
+)
+
+// writeQueue serializes operations that modify configured keys.  The manager
+// in the background page is the single writer for configured keys; other
+// pages (e.g., the options page in several windows, or the popup) proxy
+// their changes to it via a Client.  Each change reads, modifies and writes
+// storage through a chain of asynchronous callbacks, so without
+// serialization two changes issued concurrently can interleave such that
+// one silently overwrites the other (e.g., a key removed in one window is
+// written back by a change made in another).
+type writeQueue struct {
+	mu      sync.Mutex
+	running bool
+	pending []func(done func())
+}
+
+// run invokes op once all previously-queued operations have completed.  op
+// must invoke done exactly once when it is complete.
+func (q *writeQueue) run(op func(done func())) {
+	q.mu.Lock()
+	if q.running {
+		q.pending = append(q.pending, op)
+		q.mu.Unlock()
+		return
+	}
+	q.running = true
+	q.mu.Unlock()
+	q.start(op)
+}
+
+// runErr is a convenience wrapper around run for operations that complete
+// with an error.  callback is invoked with the outcome of op, before the
+// next queued operation is started.
+func (q *writeQueue) runErr(op func(callback func(err error)), callback func(err error)) {
+	q.run(func(done func()) {
+		op(func(err error) {
+			callback(err)
+			done()
+		})
+	})
+}
+
+// start invokes op, starting the next queued operation once it is done.
+func (q *writeQueue) start(op func(done func())) {
+	var once sync.Once
+	op(func() { once.Do(q.next) })
+}
+
+// next starts the next queued operation, if any.
+func (q *writeQueue) next() {
+	q.mu.Lock()
+	if len(q.pending) == 0 {
+		q.running = false
+		q.mu.Unlock()
+		return
+	}
+	op := q.pending[0]
+	q.pending = q.pending[1:]
+	q.mu.Unlock()
+	q.start(op)
+}
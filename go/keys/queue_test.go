@@ -0,0 +1,152 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+)
+
+func TestWriteQueue(t *testing.T) {
+	var q writeQueue
+	var events []string
+	var dones []func()
+	op := func(name string) func(done func()) {
+		return func(done func()) {
+			events = append(events, "start "+name)
+			dones = append(dones, func() {
+				events = append(events, "done "+name)
+				done()
+			})
+		}
+	}
+
+	q.run(op("a"))
+	q.run(op("b"))
+	q.run(op("c"))
+	// Complete each operation in turn; the next is only started once the
+	// previous one is done.
+	for i := 0; i < len(dones); i++ {
+		dones[i]()
+	}
+
+	want := []string{"start a", "done a", "start b", "done b", "start c", "done c"}
+	if diff := pretty.Diff(events, want); diff != nil {
+		t.Errorf("incorrect events; -got +want: %s", diff)
+	}
+
+	// The queue is idle again, so a new operation starts immediately.
+	events = nil
+	q.run(op("d"))
+	if diff := pretty.Diff(events, []string{"start d"}); diff != nil {
+		t.Errorf("incorrect events; -got +want: %s", diff)
+	}
+}
+
+// deferredStore is a PersistentStore whose operations are not performed until
+// flushed, simulating the latency of Chrome's storage APIs.  Operations on
+// all deferredStores sharing the same pending list are performed in the
+// order in which they were issued.
+type deferredStore struct {
+	PersistentStore
+	pending *[]func()
+}
+
+func (d *deferredStore) later(op func()) {
+	*d.pending = append(*d.pending, op)
+}
+
+func (d *deferredStore) Set(data map[string]interface{}, callback func(err error)) {
+	d.later(func() { d.PersistentStore.Set(data, callback) })
+}
+
+func (d *deferredStore) Get(callback func(data map[string]interface{}, err error)) {
+	d.later(func() { d.PersistentStore.Get(callback) })
+}
+
+func (d *deferredStore) GetItems(keys []string, callback func(data map[string]interface{}, err error)) {
+	d.later(func() { d.PersistentStore.GetItems(keys, callback) })
+}
+
+func (d *deferredStore) Delete(keys []string, callback func(err error)) {
+	d.later(func() { d.PersistentStore.Delete(keys, callback) })
+}
+
+// flush performs pending operations until there are none left.
+func flush(pending *[]func()) {
+	for len(*pending) > 0 {
+		op := (*pending)[0]
+		*pending = (*pending)[1:]
+		op()
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	var pending []func()
+	syncStorage := &deferredStore{PersistentStore: fakes.NewMemStorage(), pending: &pending}
+	localStorage := &deferredStore{PersistentStore: fakes.NewMemStorage(), pending: &pending}
+	mgr := NewManager(nil, syncStorage, localStorage)
+
+	// Two windows add keys with the same name at the same time; only one
+	// may claim the name.
+	var addErrs []error
+	for i := 0; i < 2; i++ {
+		mgr.Add("some-key", testdata.ValidPrivateKey, &AddOptions{UniqueName: true}, func(err error) {
+			addErrs = append(addErrs, err)
+		})
+	}
+	flush(&pending)
+	if len(addErrs) != 2 || addErrs[0] != nil || help.CodeOf(addErrs[1]) != help.NameTaken {
+		t.Errorf("incorrect errors adding keys with the same name: %v", addErrs)
+	}
+
+	var configured []*ConfiguredKey
+	mgr.Configured(func(keys []*ConfiguredKey, err error) {
+		if err != nil {
+			t.Errorf("failed to read configured keys: %v", err)
+		}
+		configured = keys
+	})
+	flush(&pending)
+	if diff := pretty.Diff(configuredKeyNames(configured), []string{"some-key"}); diff != nil {
+		t.Fatalf("incorrect configured keys; -got +want: %s", diff)
+	}
+	id := configured[0].ID
+
+	// One window marks the key as a canary while another removes it.  The
+	// key must not be written back after it is removed.
+	var canaryErr, removeErr error
+	mgr.SetCanary(id, true, func(err error) { canaryErr = err })
+	mgr.Remove(id, func(err error) { removeErr = err })
+	flush(&pending)
+	if canaryErr != nil || removeErr != nil {
+		t.Errorf("unexpected errors: canary %v, remove %v", canaryErr, removeErr)
+	}
+
+	mgr.Configured(func(keys []*ConfiguredKey, err error) {
+		if err != nil {
+			t.Errorf("failed to read configured keys: %v", err)
+		}
+		configured = keys
+	})
+	flush(&pending)
+	if diff := pretty.Diff(configuredKeyNames(configured), []string(nil)); diff != nil {
+		t.Errorf("removed key was written back; -got +want: %s", diff)
+	}
+}
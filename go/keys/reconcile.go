@@ -0,0 +1,192 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ReconcilePolicy configures the periodic cleanup of orphaned keys.  A key
+// is orphaned if it was loaded from a configured key, but no key with the
+// same ID is configured any longer; for example, because the key was
+// removed on another device and the removal was synced to this one.
+type ReconcilePolicy struct {
+	// Interval is the time between checks for orphaned keys.  Zero
+	// disables periodic checks.
+	Interval time.Duration
+	// Unload indicates that orphaned keys are unloaded.  If false, they
+	// are only reported.
+	Unload bool
+}
+
+// DefaultReconcilePolicy checks for orphaned keys every 5 minutes, and
+// unloads any that are found.
+var DefaultReconcilePolicy = ReconcilePolicy{
+	Interval: 5 * time.Minute,
+	Unload:   true,
+}
+
+// Reconciler finds keys loaded in the agent whose configured key no longer
+// exists, and unloads them.
+type Reconciler struct {
+	mgr    Manager
+	policy ReconcilePolicy
+
+	mu sync.Mutex
+	// removed contains the IDs of keys removed on this device.  A user
+	// may choose to keep a key loaded when removing it, so these are not
+	// orphans.
+	removed map[ID]bool
+	timer   *time.Timer
+	// stopped indicates that Stop was invoked, so that a check already
+	// in progress does not schedule another.
+	stopped bool
+}
+
+// NewReconciler returns a Reconciler that checks the keys managed by mgr
+// according to policy.  lifecycle should be the Lifecycle used by mgr; keys
+// it reports as removed were removed on this device, possibly by a user who
+// chose to keep them loaded, so they are not considered orphaned.  If
+// lifecycle is nil, such keys are unloaded too.
+func NewReconciler(mgr Manager, lifecycle *Lifecycle, policy ReconcilePolicy) *Reconciler {
+	r := &Reconciler{
+		mgr:     mgr,
+		policy:  policy,
+		removed: make(map[ID]bool),
+	}
+	if lifecycle != nil {
+		lifecycle.Subscribe(r.observe)
+	}
+	return r
+}
+
+// observe records keys removed on this device.  A key that is loaded again
+// is no longer exempt.
+func (r *Reconciler) observe(e *Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch e.To {
+	case StateRemoved:
+		r.removed[e.ID] = true
+	case StateLoading:
+		delete(r.removed, e.ID)
+	}
+}
+
+// orphaned determines if a loaded key is orphaned, given the IDs of the
+// configured keys.
+func (r *Reconciler) orphaned(l *LoadedKey, configured map[ID]bool) bool {
+	id := l.ID()
+	if id == InvalidID || configured[id] {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.removed[id]
+}
+
+// Reconcile finds orphaned keys, and unloads them if the policy permits.
+// Keys not loaded from a configured key (e.g., those added by ssh-add) are
+// never considered orphaned.  callback is invoked with the orphaned keys that were
+// found.  If the configured keys cannot be read, nothing is unloaded.
+func (r *Reconciler) Reconcile(callback func(orphans []*LoadedKey, err error)) {
+	// Read the loaded keys before the configured keys, so that a key
+	// loaded in the meantime is never mistaken for an orphan.
+	r.mgr.Loaded(func(loaded []*LoadedKey, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		r.mgr.Configured(func(configured []*ConfiguredKey, err error) {
+			if err != nil {
+				callback(nil, fmt.Errorf("failed to read configured keys: %v", err))
+				return
+			}
+
+			ids := make(map[ID]bool)
+			for _, c := range configured {
+				ids[c.ID] = true
+			}
+			var orphans []*LoadedKey
+			for _, l := range loaded {
+				if r.orphaned(l, ids) {
+					orphans = append(orphans, l)
+				}
+			}
+			if !r.policy.Unload {
+				callback(orphans, nil)
+				return
+			}
+
+			var next func(i int)
+			next = func(i int) {
+				if i == len(orphans) {
+					callback(orphans, nil)
+					return
+				}
+				r.mgr.Unload(orphans[i], func(err error) {
+					if err != nil {
+						callback(orphans, fmt.Errorf("failed to unload orphaned key %s: %v", orphans[i].ID(), err))
+						return
+					}
+					next(i + 1)
+				})
+			}
+			next(0)
+		})
+	})
+}
+
+// Start checks for orphaned keys at the interval configured in the policy.
+// report is invoked after each check that finds orphaned keys or fails.
+// Start does nothing if the interval is zero.
+func (r *Reconciler) Start(report func(orphans []*LoadedKey, err error)) {
+	if r.policy.Interval <= 0 {
+		return
+	}
+
+	var run func()
+	run = func() {
+		r.Reconcile(func(orphans []*LoadedKey, err error) {
+			if len(orphans) > 0 || err != nil {
+				report(orphans, err)
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if !r.stopped {
+				r.timer = time.AfterFunc(r.policy.Interval, run)
+			}
+		})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = false
+	r.timer = time.AfterFunc(r.policy.Interval, run)
+}
+
+// Stop stops periodic checks started by Start.
+func (r *Reconciler) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func sortedBlobs(keys []*LoadedKey) []string {
+	result := loadedKeyBlobs(keys)
+	sort.Strings(result)
+	return result
+}
+
+func TestReconcile(t *testing.T) {
+	both := []string{testdata.ValidPrivateKeyBlob, testdata.ValidPrivateKeyWithoutPassphraseBlob}
+	sort.Strings(both)
+
+	testcases := []struct {
+		description string
+		remove      []string
+		ephemeral   bool
+		lifecycle   bool
+		storageErr  fakes.Errs
+		policy      ReconcilePolicy
+		wantOrphans []string
+		wantLoaded  []string
+		wantErr     error
+	}{
+		{
+			description: "no orphaned keys",
+			policy:      ReconcilePolicy{Unload: true},
+			wantLoaded:  both,
+		},
+		{
+			description: "unload orphaned key",
+			remove:      []string{"good-key"},
+			policy:      ReconcilePolicy{Unload: true},
+			wantOrphans: []string{testdata.ValidPrivateKeyBlob},
+			wantLoaded:  []string{testdata.ValidPrivateKeyWithoutPassphraseBlob},
+		},
+		{
+			description: "report orphaned key without unloading",
+			remove:      []string{"good-key"},
+			policy:      ReconcilePolicy{Unload: false},
+			wantOrphans: []string{testdata.ValidPrivateKeyBlob},
+			wantLoaded:  both,
+		},
+		{
+			description: "keep keys removed on this device",
+			remove:      []string{"good-key"},
+			lifecycle:   true,
+			policy:      ReconcilePolicy{Unload: true},
+			wantLoaded:  both,
+		},
+		{
+			description: "ignore keys not loaded from configured keys",
+			remove:      []string{"other-key"},
+			ephemeral:   true,
+			policy:      ReconcilePolicy{Unload: true},
+			wantLoaded:  both,
+		},
+		{
+			description: "unload nothing if configured keys cannot be read",
+			remove:      []string{"good-key"},
+			storageErr:  fakes.Errs{Get: errors.New("storage failed")},
+			policy:      ReconcilePolicy{Unload: true},
+			wantLoaded:  both,
+			wantErr:     errors.New("failed to read configured keys: failed to read keys: failed to read from storage: storage failed"),
+		},
+	}
+
+	for _, tc := range testcases {
+		syncStorage := fakes.NewMemStorage()
+		var lifecycle *Lifecycle
+		var opts []ManagerOption
+		if tc.lifecycle {
+			lifecycle = NewLifecycle()
+			opts = append(opts, WithLifecycle(lifecycle))
+		}
+		mgr, err := newTestManager(agent.NewKeyring(), syncStorage, fakes.NewMemStorage(), []*initialKey{
+			{
+				Name:          "good-key",
+				PEMPrivateKey: testdata.ValidPrivateKey,
+				Load:          true,
+				Passphrase:    testdata.ValidPrivateKeyPassphrase,
+			},
+			{
+				Name:          "other-key",
+				PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+				Load:          !tc.ephemeral,
+			},
+		}, opts...)
+		if err != nil {
+			t.Fatalf("%s: failed to initialize manager: %v", tc.description, err)
+		}
+		r := NewReconciler(mgr, lifecycle, tc.policy)
+		if tc.ephemeral {
+			if err := syncLoadEphemeral(mgr, "other-key", testdata.ValidPrivateKeyWithoutPassphrase); err != nil {
+				t.Fatalf("%s: failed to load ephemeral key: %v", tc.description, err)
+			}
+		}
+		for _, name := range tc.remove {
+			id, err := findKey(mgr, InvalidID, name)
+			if err != nil {
+				t.Fatalf("%s: failed to find key %s: %v", tc.description, name, err)
+			}
+			if err := syncRemove(mgr, id); err != nil {
+				t.Fatalf("%s: failed to remove key %s: %v", tc.description, name, err)
+			}
+		}
+
+		syncStorage.SetError(tc.storageErr)
+		var orphans []*LoadedKey
+		r.Reconcile(func(o []*LoadedKey, e error) {
+			orphans, err = o, e
+		})
+		syncStorage.SetError(fakes.Errs{})
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(sortedBlobs(orphans), tc.wantOrphans); diff != nil {
+			t.Errorf("%s: incorrect orphaned keys; -got +want: %s", tc.description, diff)
+		}
+
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Errorf("%s: failed to get loaded keys: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(sortedBlobs(loaded), tc.wantLoaded); diff != nil {
+			t.Errorf("%s: incorrect loaded keys; -got +want: %s", tc.description, diff)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// leakyProvider is a Provider whose errors quote the private key and
+// passphrase it was given, as careless lower layers might.
+type leakyProvider struct {
+	provider.Provider
+}
+
+func (p *leakyProvider) Name() string {
+	return "leaky"
+}
+
+func (p *leakyProvider) ParsePrivateKey(pemBytes, passphrase []byte) (interface{}, error) {
+	return nil, fmt.Errorf("failed to decrypt %s with passphrase %q", pemBytes, passphrase)
+}
+
+func TestRedaction(t *testing.T) {
+	const (
+		passphrase       = "correct horse battery staple"
+		exportPassphrase = "export passphrase"
+	)
+	// blobName is a valid name that looks like key material.
+	blobName := testdata.ValidPrivateKeyWithoutPassphraseBlob[:90]
+
+	// Sensitive material that must not appear in any error.
+	sensitive := []string{
+		passphrase,
+		exportPassphrase,
+		"BEGIN RSA PRIVATE KEY",
+		strings.Split(strings.TrimSpace(testdata.ValidPrivateKey), "\n")[4],
+		blobName,
+	}
+
+	def := provider.NewSoftware(nil)
+	providers := provider.NewRegistry(def)
+	providers.Register(&leakyProvider{Provider: def})
+	mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), nil, WithProviders(providers))
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+	if err := syncAdd(mgr, "leaky-key", testdata.ValidPrivateKey, &AddOptions{Provider: "leaky"}); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	id, err := findKey(mgr, InvalidID, "leaky-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+
+	testcases := []struct {
+		description string
+		op          func() error
+		wantCode    help.Code
+	}{
+		{
+			description: "load",
+			op: func() error {
+				return syncLoad(mgr, id, passphrase)
+			},
+			wantCode: help.InvalidPrivateKey,
+		},
+		{
+			description: "export",
+			op: func() error {
+				_, err := syncExport(mgr, id, passphrase, keyformat.PrivatePKCS8, exportPassphrase)
+				return err
+			},
+			wantCode: help.InvalidPrivateKey,
+		},
+		{
+			description: "add",
+			op: func() error {
+				// The name is quoted in the error; it is
+				// indistinguishable from key material.
+				if err := syncAdd(mgr, blobName, testdata.ValidPrivateKey, nil); err != nil {
+					return fmt.Errorf("failed to add key: %v", err)
+				}
+				return syncAdd(mgr, blobName, testdata.ValidPrivateKey, &AddOptions{UniqueName: true})
+			},
+			wantCode: help.NameTaken,
+		},
+	}
+
+	for _, tc := range testcases {
+		err := tc.op()
+		if err == nil {
+			t.Errorf("%s: unexpected success", tc.description)
+			continue
+		}
+		for _, s := range sensitive {
+			if strings.Contains(err.Error(), s) {
+				t.Errorf("%s: error contains sensitive material %q: %v", tc.description, s, err)
+			}
+		}
+		if code := help.CodeOf(err); code != tc.wantCode {
+			t.Errorf("%s: incorrect help code; got %s, want %s", tc.description, code, tc.wantCode)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/google/chrome-ssh-agent/go/remote"
+)
+
+// remoteEndpoint returns the URL of the signing service holding a remote
+// key, or an empty string if the key is not a remote key.
+func remoteEndpoint(k *storedKey) string {
+	if k.Provider != remote.ProviderName {
+		return ""
+	}
+	rk, err := remote.Parse([]byte(k.PEMPrivateKey))
+	if err != nil {
+		return ""
+	}
+	return rk.Endpoint
+}
+
+// confirmReason returns the reason each signature using a key must be
+// allowed by the user, or an empty string if it need not be.  Signatures
+// using remote keys are allowed individually, since the data signed is
+// sent to another service.
+func confirmReason(k *storedKey) string {
+	if k.Provider != remote.ProviderName {
+		return ""
+	}
+	host := "a remote signing service"
+	if u, err := url.Parse(remoteEndpoint(k)); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return fmt.Sprintf("The key is held by %s, which will compute the signature.", host)
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"github.com/google/chrome-ssh-agent/go/remote"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// remoteEndpointURL is the endpoint of the fake signing service.
+	remoteEndpointURL = "https://signer.example.com/sign"
+)
+
+// fakeSigningService is a remote.Poster that signs requests using a private
+// key held in memory, as a remote signing service would.
+type fakeSigningService struct {
+	priv ed25519.PrivateKey
+	// keyIDs contains the key IDs of the requests received.
+	keyIDs []string
+}
+
+// Post implements remote.Poster.Post.
+func (f *fakeSigningService) Post(url string, body []byte, callback func(body []byte, err error)) {
+	var req struct {
+		KeyID  string `json:"keyId"`
+		Digest string `json:"digest"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		callback(nil, err)
+		return
+	}
+	f.keyIDs = append(f.keyIDs, req.KeyID)
+	data, _ := base64.StdEncoding.DecodeString(req.Digest)
+	sig := ed25519.Sign(f.priv, data)
+	rsp, _ := json.Marshal(map[string]string{"signature": base64.StdEncoding.EncodeToString(sig)})
+	callback(rsp, nil)
+}
+
+// newRemoteKey returns a PEM-encoded remote key, the service holding it, and
+// a provider registry able to load it.
+func newRemoteKey(t *testing.T) (string, *fakeSigningService, *provider.Registry) {
+	pubKey, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	pub, err := ssh.NewPublicKey(pubKey)
+	if err != nil {
+		t.Fatalf("failed to convert public key: %v", err)
+	}
+	encoded, err := remote.Encode(&remote.Key{Endpoint: remoteEndpointURL, KeyID: "team/deploy", PublicKey: pub})
+	if err != nil {
+		t.Fatalf("failed to encode remote key: %v", err)
+	}
+	service := &fakeSigningService{priv: priv}
+	providers := provider.NewRegistry(provider.NewSoftware(nil))
+	providers.Register(remote.NewProvider(service, remote.DefaultTimeout))
+	return encoded, service, providers
+}
+
+func TestRemoteKey(t *testing.T) {
+	encoded, service, providers := newRemoteKey(t)
+	agt := agent.NewKeyring()
+	mgr := NewManager(agt, fakes.NewMemStorage(), fakes.NewMemStorage(), WithProviders(providers))
+
+	if err := syncAdd(mgr, "remote-key", encoded, nil); err != nil {
+		t.Fatalf("failed to add remote key: %v", err)
+	}
+	id, err := findKey(mgr, InvalidID, "remote-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+
+	configured, err := syncConfigured(mgr)
+	if err != nil {
+		t.Fatalf("failed to get configured keys: %v", err)
+	}
+	if got := configured[0]; got.Remote != remoteEndpointURL || got.Type != ssh.KeyAlgoED25519 || got.Encrypted {
+		t.Errorf("incorrect configured key; got remote %q, type %q, encrypted %t", got.Remote, got.Type, got.Encrypted)
+	}
+
+	// There is no private key to export.
+	if _, err := syncExport(mgr, id, "", keyformat.PrivateOpenSSH, ""); err == nil || !strings.Contains(err.Error(), "remote signing service") {
+		t.Errorf("incorrect error exporting remote key; got %v", err)
+	}
+
+	// Signatures are computed by the service.
+	if err := syncLoad(mgr, id, ""); err != nil {
+		t.Fatalf("failed to load remote key: %v", err)
+	}
+	loaded, err := agt.List()
+	if err != nil || len(loaded) != 1 {
+		t.Fatalf("failed to list loaded keys: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(loaded[0].Blob)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+	sig, err := agt.Sign(pub, []byte("some-data"))
+	if err != nil {
+		t.Fatalf("failed to sign using remote key: %v", err)
+	}
+	if err := pub.Verify([]byte("some-data"), sig); err != nil {
+		t.Errorf("signature failed verification: %v", err)
+	}
+	if want := []string{"team/deploy"}; len(service.keyIDs) != 1 || service.keyIDs[0] != want[0] {
+		t.Errorf("incorrect requests sent to service; got %v, want %v", service.keyIDs, want)
+	}
+
+	// Remote keys that could never be used are refused.
+	block, _ := pem.Decode([]byte(encoded))
+	block.Headers["Endpoint"] = "http://signer.example.com/sign"
+	err = syncAdd(mgr, "insecure-key", string(pem.EncodeToMemory(block)), nil)
+	if got := help.CodeOf(err); got != help.InvalidPrivateKey {
+		t.Errorf("incorrect error adding insecure remote key; got %v (code %q), want code %q", err, got, help.InvalidPrivateKey)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// ItemStatus is the outcome of an operation on a single key within an
+// operation on many keys.
+type ItemStatus int
+
+const (
+	// ItemSucceeded indicates that the operation succeeded.
+	ItemSucceeded ItemStatus = iota
+	// ItemFailed indicates that the operation failed.
+	ItemFailed
+	// ItemCancelled indicates that the operation was not attempted
+	// because it was cancelled.
+	ItemCancelled
+)
+
+// String returns a human-readable description of the status.
+func (s ItemStatus) String() string {
+	switch s {
+	case ItemSucceeded:
+		return "Succeeded"
+	case ItemFailed:
+		return "Failed"
+	case ItemCancelled:
+		return "Cancelled"
+	}
+	return "Unknown"
+}
+
+// ItemResult is the outcome of an operation on a single key.
+type ItemResult struct {
+	// ID is the ID of the key.
+	ID ID
+	// Status is the outcome of the operation.
+	Status ItemStatus
+	// Err is the error encountered, or nil if the operation succeeded.
+	Err error
+	// Code identifies the help topic relevant to Err, if any.
+	Code help.Code
+}
+
+// Result is the outcome of an operation on many keys (e.g., loading all
+// keys).  It records the outcome for each key, along with summary counts.
+type Result struct {
+	// Action describes the operation (e.g., 'load'), and is used in
+	// error messages.
+	Action string
+	// Items contains the outcome for each key, in the order in which
+	// they completed.
+	Items []*ItemResult
+	// Succeeded is the number of keys for which the operation succeeded.
+	Succeeded int
+	// Failed is the number of keys for which the operation failed.
+	Failed int
+	// Cancelled is the number of keys for which the operation was
+	// cancelled.
+	Cancelled int
+}
+
+// NewResult returns an empty Result for the specified action.
+func NewResult(action string) *Result {
+	return &Result{Action: action}
+}
+
+// Record adds the outcome of the operation on the key with the specified ID.
+// ErrBatchCancelled indicates that the operation was cancelled.
+func (r *Result) Record(id ID, err error) *ItemResult {
+	item := &ItemResult{ID: id, Err: err, Code: help.CodeOf(err)}
+	switch {
+	case err == nil:
+		item.Status = ItemSucceeded
+		r.Succeeded++
+	case err == ErrBatchCancelled:
+		item.Status = ItemCancelled
+		r.Cancelled++
+	default:
+		item.Status = ItemFailed
+		r.Failed++
+	}
+	r.Items = append(r.Items, item)
+	return item
+}
+
+// Total returns the number of keys for which an outcome was recorded.
+func (r *Result) Total() int {
+	return len(r.Items)
+}
+
+// Summary returns a human-readable summary of the outcome (e.g., '3 of 5
+// keys succeeded; 1 failed; 1 cancelled').
+func (r *Result) Summary() string {
+	parts := []string{fmt.Sprintf("%d of %d keys succeeded", r.Succeeded, r.Total())}
+	if r.Failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", r.Failed))
+	}
+	if r.Cancelled > 0 {
+		parts = append(parts, fmt.Sprintf("%d cancelled", r.Cancelled))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Err returns an error summarizing the outcome, or nil if the operation
+// succeeded for all keys.  ErrBatchCancelled is returned if the operation was
+// cancelled.
+func (r *Result) Err() error {
+	if r.Cancelled > 0 {
+		return ErrBatchCancelled
+	}
+	if r.Failed > 0 {
+		return fmt.Errorf("failed to %s %d of %d keys", r.Action, r.Failed, r.Total())
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/kr/pretty"
+)
+
+func TestResult(t *testing.T) {
+	testcases := []struct {
+		description  string
+		errs         []error
+		wantStatuses []ItemStatus
+		wantCodes    []help.Code
+		wantSummary  string
+		wantErr      error
+	}{
+		{
+			description:  "all succeeded",
+			errs:         []error{nil, nil},
+			wantStatuses: []ItemStatus{ItemSucceeded, ItemSucceeded},
+			wantCodes:    []help.Code{help.None, help.None},
+			wantSummary:  "2 of 2 keys succeeded",
+		},
+		{
+			description:  "some failed",
+			errs:         []error{nil, help.Errorf(help.IncorrectPassphrase, "bad passphrase"), errors.New("failed")},
+			wantStatuses: []ItemStatus{ItemSucceeded, ItemFailed, ItemFailed},
+			wantCodes:    []help.Code{help.None, help.IncorrectPassphrase, help.None},
+			wantSummary:  "1 of 3 keys succeeded; 2 failed",
+			wantErr:      errors.New("failed to load 2 of 3 keys"),
+		},
+		{
+			description:  "cancelled",
+			errs:         []error{errors.New("failed"), ErrBatchCancelled},
+			wantStatuses: []ItemStatus{ItemFailed, ItemCancelled},
+			wantCodes:    []help.Code{help.None, help.None},
+			wantSummary:  "0 of 2 keys succeeded; 1 failed; 1 cancelled",
+			wantErr:      ErrBatchCancelled,
+		},
+	}
+
+	for _, tc := range testcases {
+		r := NewResult("load")
+		for i, err := range tc.errs {
+			r.Record(ID(string('a'+rune(i))), err)
+		}
+
+		var statuses []ItemStatus
+		var codes []help.Code
+		for _, item := range r.Items {
+			statuses = append(statuses, item.Status)
+			codes = append(codes, item.Code)
+		}
+		if diff := pretty.Diff(statuses, tc.wantStatuses); diff != nil {
+			t.Errorf("%s: incorrect statuses; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(codes, tc.wantCodes); diff != nil {
+			t.Errorf("%s: incorrect codes; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(r.Summary(), tc.wantSummary); diff != nil {
+			t.Errorf("%s: incorrect summary; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(r.Err(), tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+	}
+}
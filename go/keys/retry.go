@@ -0,0 +1,187 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// ErrTimeout is returned when an operation does not complete within the
+// configured timeout.  Operations forwarded by a Client return it
+// unwrapped, so callers may compare against it directly.
+var ErrTimeout = help.Errorf(help.Timeout, "operation timed out")
+
+// RetryPolicy configures timeouts and retries for operations on persistent
+// storage.
+type RetryPolicy struct {
+	// Timeout is how long a single attempt may take before it is
+	// abandoned.  Zero disables the timeout.
+	Timeout time.Duration
+	// Attempts is the maximum number of attempts, including the first.
+	Attempts int
+	// Backoff is the delay before the first retry.  It doubles for each
+	// subsequent retry.
+	Backoff time.Duration
+}
+
+// DefaultRetryPolicy is a RetryPolicy suitable for Chrome's storage APIs.
+var DefaultRetryPolicy = RetryPolicy{
+	Timeout:  5 * time.Second,
+	Attempts: 3,
+	Backoff:  250 * time.Millisecond,
+}
+
+// MaxDuration returns the longest an operation may take under the policy,
+// including all attempts and the delays between them.
+func (p RetryPolicy) MaxDuration() time.Duration {
+	d := time.Duration(p.Attempts) * p.Timeout
+	backoff := p.Backoff
+	for i := 1; i < p.Attempts; i++ {
+		d += backoff
+		backoff *= 2
+	}
+	return d
+}
+
+// WithRetryPolicy specifies that operations on persistent storage should be
+// abandoned if they do not complete within the policy's timeout, and retried
+// if they time out or fail transiently.  By default, operations are neither
+// timed out nor retried.
+func WithRetryPolicy(policy RetryPolicy) ManagerOption {
+	return func(m *manager) {
+		m.storage = newRetryingStore(m.storage, policy)
+		m.localStorage = newRetryingStore(m.localStorage, policy)
+	}
+}
+
+// withTimeout invokes op, then invokes callback with the result op reports
+// via done, or with ErrTimeout if op does not report a result within
+// timeout.  callback is invoked exactly once; a result reported after the
+// timeout is discarded.  A zero timeout disables the timeout.
+func withTimeout(timeout time.Duration, op func(done func(v interface{}, err error)), callback func(v interface{}, err error)) {
+	var once sync.Once
+	finish := func(v interface{}, err error) {
+		once.Do(func() { callback(v, err) })
+	}
+	if timeout > 0 {
+		t := time.AfterFunc(timeout, func() { finish(nil, ErrTimeout) })
+		op(func(v interface{}, err error) {
+			t.Stop()
+			finish(v, err)
+		})
+		return
+	}
+	op(finish)
+}
+
+// retryable returns true if err may succeed when retried: the operation
+// timed out, or Chrome's limit on the rate of writes was exceeded.
+func retryable(err error) bool {
+	if err == ErrTimeout {
+		return true
+	}
+	return strings.Contains(err.Error(), "MAX_WRITE_OPERATIONS")
+}
+
+// retryingStore is a PersistentStore that times out and retries operations
+// on an underlying store according to a RetryPolicy.
+type retryingStore struct {
+	store  PersistentStore
+	policy RetryPolicy
+}
+
+// newRetryingStore returns a PersistentStore that applies policy to
+// operations on store.
+func newRetryingStore(store PersistentStore, policy RetryPolicy) *retryingStore {
+	return &retryingStore{store: store, policy: policy}
+}
+
+// retry invokes op until it succeeds, fails with an error that is not
+// retryable, or the policy's attempts are exhausted.  callback is invoked
+// with the final result.
+func (r *retryingStore) retry(op func(done func(v interface{}, err error)), callback func(v interface{}, err error)) {
+	attempt := 1
+	backoff := r.policy.Backoff
+	var try func()
+	try = func() {
+		withTimeout(r.policy.Timeout, op, func(v interface{}, err error) {
+			if err == nil || attempt >= r.policy.Attempts || !retryable(err) {
+				callback(v, err)
+				return
+			}
+			attempt++
+			time.AfterFunc(backoff, try)
+			backoff *= 2
+		})
+	}
+	try()
+}
+
+// Set implements PersistentStore.Set.
+func (r *retryingStore) Set(data map[string]interface{}, callback func(err error)) {
+	r.retry(func(done func(v interface{}, err error)) {
+		r.store.Set(data, func(err error) { done(nil, err) })
+	}, func(v interface{}, err error) {
+		callback(err)
+	})
+}
+
+// Get implements PersistentStore.Get.
+func (r *retryingStore) Get(callback func(data map[string]interface{}, err error)) {
+	r.retry(func(done func(v interface{}, err error)) {
+		r.store.Get(func(data map[string]interface{}, err error) { done(data, err) })
+	}, func(v interface{}, err error) {
+		data, _ := v.(map[string]interface{})
+		callback(data, err)
+	})
+}
+
+// GetItems implements PersistentStore.GetItems.
+func (r *retryingStore) GetItems(keys []string, callback func(data map[string]interface{}, err error)) {
+	r.retry(func(done func(v interface{}, err error)) {
+		r.store.GetItems(keys, func(data map[string]interface{}, err error) { done(data, err) })
+	}, func(v interface{}, err error) {
+		data, _ := v.(map[string]interface{})
+		callback(data, err)
+	})
+}
+
+// Delete implements PersistentStore.Delete.
+func (r *retryingStore) Delete(keys []string, callback func(err error)) {
+	r.retry(func(done func(v interface{}, err error)) {
+		r.store.Delete(keys, func(err error) { done(nil, err) })
+	}, func(v interface{}, err error) {
+		callback(err)
+	})
+}
+
+// BytesInUse implements PersistentStore.BytesInUse.
+func (r *retryingStore) BytesInUse(keys []string, callback func(bytes int, err error)) {
+	r.retry(func(done func(v interface{}, err error)) {
+		r.store.BytesInUse(keys, func(bytes int, err error) { done(bytes, err) })
+	}, func(v interface{}, err error) {
+		bytes, _ := v.(int)
+		callback(bytes, err)
+	})
+}
+
+// Quota implements PersistentStore.Quota.
+func (r *retryingStore) Quota() (total, perItem int) {
+	return r.store.Quota()
+}
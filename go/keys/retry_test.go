@@ -0,0 +1,158 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/gopherjs/gopherjs/js"
+	"github.com/kr/pretty"
+)
+
+// hangingStore is a PersistentStore whose first few operations never
+// complete, and whose subsequent operations fail with a fixed error (if
+// any) or are performed on the underlying store.
+type hangingStore struct {
+	PersistentStore
+	hang     int
+	err      error
+	attempts int
+}
+
+func (h *hangingStore) Get(callback func(data map[string]interface{}, err error)) {
+	h.attempts++
+	if h.attempts <= h.hang {
+		return
+	}
+	if h.err != nil {
+		callback(nil, h.err)
+		return
+	}
+	h.PersistentStore.Get(callback)
+}
+
+func TestRetryingStore(t *testing.T) {
+	policy := RetryPolicy{
+		Timeout:  10 * time.Millisecond,
+		Attempts: 3,
+		Backoff:  time.Millisecond,
+	}
+	testcases := []struct {
+		description  string
+		hang         int
+		err          error
+		wantErr      error
+		wantData     map[string]interface{}
+		wantAttempts int
+	}{
+		{
+			description:  "succeeds immediately",
+			wantData:     map[string]interface{}{"some-key": "some-value"},
+			wantAttempts: 1,
+		},
+		{
+			description:  "succeeds after timeout",
+			hang:         2,
+			wantData:     map[string]interface{}{"some-key": "some-value"},
+			wantAttempts: 3,
+		},
+		{
+			description:  "attempts exhausted",
+			hang:         3,
+			wantErr:      ErrTimeout,
+			wantAttempts: 3,
+		},
+		{
+			description:  "rate limit retried",
+			err:          errors.New("failed to get: This request exceeds the MAX_WRITE_OPERATIONS_PER_MINUTE quota."),
+			wantErr:      errors.New("failed to get: This request exceeds the MAX_WRITE_OPERATIONS_PER_MINUTE quota."),
+			wantAttempts: 3,
+		},
+		{
+			description:  "other errors not retried",
+			err:          errors.New("failed"),
+			wantErr:      errors.New("failed"),
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		mem := fakes.NewMemStorage()
+		mem.Set(map[string]interface{}{"some-key": "some-value"}, func(err error) {})
+		store := &hangingStore{PersistentStore: mem, hang: tc.hang, err: tc.err}
+		r := newRetryingStore(store, policy)
+
+		done := make(chan struct{})
+		var gotData map[string]interface{}
+		var gotErr error
+		r.Get(func(data map[string]interface{}, err error) {
+			gotData, gotErr = data, err
+			close(done)
+		})
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: operation did not complete", tc.description)
+		}
+
+		if diff := pretty.Diff(gotErr, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if tc.wantData != nil {
+			if diff := pretty.Diff(gotData, tc.wantData); diff != nil {
+				t.Errorf("%s: incorrect data; -got +want: %s", tc.description, diff)
+			}
+		}
+		if store.attempts != tc.wantAttempts {
+			t.Errorf("%s: incorrect attempts; got %d, want %d", tc.description, store.attempts, tc.wantAttempts)
+		}
+	}
+}
+
+func TestRetryPolicyMaxDuration(t *testing.T) {
+	p := RetryPolicy{Timeout: time.Second, Attempts: 3, Backoff: 100 * time.Millisecond}
+	if got, want := p.MaxDuration(), 3*time.Second+300*time.Millisecond; got != want {
+		t.Errorf("MaxDuration() = %v, want %v", got, want)
+	}
+	if DefaultRetryPolicy.MaxDuration() >= defaultClientTimeout {
+		t.Errorf("client times out before the default retry policy is exhausted")
+	}
+}
+
+// silentSender is a MessageSender that never receives a response.
+type silentSender struct{}
+
+func (silentSender) SendMessage(msg interface{}, callback func(rsp *js.Object)) {}
+
+func (silentSender) Error() error {
+	return nil
+}
+
+func TestClientTimeout(t *testing.T) {
+	cli := NewClient(silentSender{}, WithTimeout(10*time.Millisecond))
+	errc := make(chan error, 1)
+	cli.Remove(ID("1"), func(err error) { errc <- err })
+	select {
+	case err := <-errc:
+		if err != ErrTimeout {
+			t.Errorf("incorrect error; got %v, want %v", err, ErrTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("call did not time out")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// SetRevoked implements Manager.SetRevoked.
+func (m *manager) SetRevoked(id ID, revoked bool, callback func(err error)) {
+	m.writes.runErr(func(callback func(err error)) {
+		m.setRevoked(id, revoked, callback)
+	}, callback)
+}
+
+// setRevoked rewrites the stored key with the specified ID to revoke it or
+// clear its revocation.  A key that is revoked is also unloaded from this
+// device.  callback is invoked when complete.
+func (m *manager) setRevoked(id ID, revoked bool, callback func(err error)) {
+	m.readKey(id, func(key *storedKey, err error) {
+		if err != nil {
+			callback(help.Errorf(help.StorageFailure, "failed to read key: %v", err))
+			return
+		}
+		if key == nil {
+			callback(help.Errorf(help.KeyNotFound, "failed to find key with ID %s", id))
+			return
+		}
+
+		key.Revoked = revoked
+		key.Updated = nowMillis()
+		data := map[string]interface{}{
+			storageKey(id): key.value(),
+		}
+		m.storeFor(key.DeviceOnly).Set(data, func(err error) {
+			if err != nil {
+				callback(help.Errorf(help.StorageFailure, "failed to write key: %v", err))
+				return
+			}
+			if m.audit != nil {
+				action := "revoked key %q"
+				if !revoked {
+					action = "cleared revocation of key %q"
+				}
+				m.audit.Record(audit.NewEntry("revoke", "options", string(id), true, fmt.Sprintf(action, key.Name)), nil)
+			}
+			if !revoked {
+				callback(nil)
+				return
+			}
+			unloadIDs(m, map[ID]bool{id: true}, func(unloaded []*LoadedKey, err error) {
+				callback(err)
+			})
+		})
+	})
+}
+
+// EnforceRevocations unloads every loaded key that has been revoked; for
+// example, after a revocation is synced from another device.  callback is
+// invoked with the keys that were unloaded.
+func EnforceRevocations(mgr Manager, callback func(unloaded []*LoadedKey, err error)) {
+	mgr.Configured(func(configured []*ConfiguredKey, err error) {
+		// Keys that could be read are still enforced if others could
+		// not be.
+		if err != nil && !IsDegraded(err) {
+			callback(nil, fmt.Errorf("failed to read configured keys: %v", err))
+			return
+		}
+
+		revoked := make(map[ID]bool)
+		for _, c := range configured {
+			if c.Revoked {
+				revoked[c.ID] = true
+			}
+		}
+		if len(revoked) == 0 {
+			callback(nil, nil)
+			return
+		}
+		unloadIDs(mgr, revoked, callback)
+	})
+}
+
+// unloadIDs unloads the loaded keys with the specified IDs.  Failing to unload
+// one key does not prevent others from being unloaded; callback is invoked
+// with the keys that were unloaded, and the first error encountered.
+func unloadIDs(mgr Manager, ids map[ID]bool, callback func(unloaded []*LoadedKey, err error)) {
+	mgr.Loaded(func(loaded []*LoadedKey, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to list loaded keys: %v", err))
+			return
+		}
+
+		var matched []*LoadedKey
+		for _, l := range loaded {
+			if ids[l.ID()] {
+				matched = append(matched, l)
+			}
+		}
+
+		var unloaded []*LoadedKey
+		var firstErr error
+		var next func(i int)
+		next = func(i int) {
+			if i == len(matched) {
+				callback(unloaded, firstErr)
+				return
+			}
+			mgr.Unload(matched[i], func(err error) {
+				if err == nil {
+					unloaded = append(unloaded, matched[i])
+				} else if firstErr == nil {
+					firstErr = fmt.Errorf("failed to unload key %s: %v", matched[i].ID(), err)
+				}
+				next(i + 1)
+			})
+		}
+		next(0)
+	})
+}
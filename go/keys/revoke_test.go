@@ -0,0 +1,158 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestRevoke(t *testing.T) {
+	testcases := []struct {
+		description string
+		revoke      bool
+		clear       bool
+		wantLoaded  int
+		wantRevoked bool
+		wantLoadErr help.Code
+	}{
+		{
+			description: "ordinary key loads",
+			wantLoaded:  1,
+		},
+		{
+			description: "revoked key is unloaded and refused",
+			revoke:      true,
+			wantRevoked: true,
+			wantLoadErr: help.KeyRevoked,
+		},
+		{
+			description: "cleared key loads",
+			revoke:      true,
+			clear:       true,
+			wantLoaded:  1,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKey, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase); err != nil {
+			t.Fatalf("%s: failed to load key: %v", tc.description, err)
+		}
+
+		if tc.revoke {
+			if err := syncSetRevoked(mgr, id, true); err != nil {
+				t.Errorf("%s: failed to revoke key: %v", tc.description, err)
+			}
+		}
+		if tc.clear {
+			if err := syncSetRevoked(mgr, id, false); err != nil {
+				t.Errorf("%s: failed to clear revocation: %v", tc.description, err)
+			}
+		}
+
+		err = syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase)
+		if got := help.CodeOf(err); got != tc.wantLoadErr {
+			t.Errorf("%s: incorrect load error; got %v, want code %q", tc.description, err, tc.wantLoadErr)
+		}
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Errorf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		if len(loaded) != tc.wantLoaded {
+			t.Errorf("%s: incorrect number of loaded keys; got %d, want %d", tc.description, len(loaded), tc.wantLoaded)
+		}
+		configured, err := syncConfigured(mgr)
+		if err != nil || len(configured) != 1 {
+			t.Fatalf("%s: failed to read configured keys: %v", tc.description, err)
+		}
+		if configured[0].Revoked != tc.wantRevoked {
+			t.Errorf("%s: incorrect revoked; got %t, want %t", tc.description, configured[0].Revoked, tc.wantRevoked)
+		}
+	}
+}
+
+func TestEnforceRevocations(t *testing.T) {
+	// Two devices share synced storage, as they would through Chrome
+	// Sync.
+	sync := fakes.NewMemStorage()
+	self := NewManager(agent.NewKeyring(), sync, fakes.NewMemStorage())
+	other := NewManager(agent.NewKeyring(), sync, fakes.NewMemStorage())
+
+	if err := syncAdd(self, "some-key", testdata.ValidPrivateKey, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	if err := syncAdd(self, "other-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	id, err := findKey(self, InvalidID, "some-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	keep, err := findKey(self, InvalidID, "other-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	if err := syncLoad(other, id, testdata.ValidPrivateKeyPassphrase); err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	if err := syncLoad(other, keep, ""); err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+
+	// Nothing is unloaded until a key is revoked.
+	var unloaded []*LoadedKey
+	EnforceRevocations(other, func(u []*LoadedKey, err error) {
+		if err != nil {
+			t.Errorf("failed to enforce revocations: %v", err)
+		}
+		unloaded = u
+	})
+	if len(unloaded) != 0 {
+		t.Errorf("incorrect keys unloaded before revocation; got %v, want none", loadedKeyIds(unloaded))
+	}
+
+	if err := syncSetRevoked(self, id, true); err != nil {
+		t.Fatalf("failed to revoke key: %v", err)
+	}
+	EnforceRevocations(other, func(u []*LoadedKey, err error) {
+		if err != nil {
+			t.Errorf("failed to enforce revocations: %v", err)
+		}
+		unloaded = u
+	})
+	if diff := pretty.Diff(loadedKeyIds(unloaded), []ID{id}); diff != nil {
+		t.Errorf("incorrect keys unloaded; -got +want: %s", diff)
+	}
+	loaded, err := syncLoaded(other)
+	if err != nil {
+		t.Fatalf("failed to list loaded keys: %v", err)
+	}
+	if diff := pretty.Diff(loadedKeyIds(loaded), []ID{keep}); diff != nil {
+		t.Errorf("incorrect keys remain loaded; -got +want: %s", diff)
+	}
+}
@@ -0,0 +1,456 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// dayNames are the names of the days of the week accepted in a schedule,
+// indexed by time.Weekday.
+var dayNames = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// weekOrder lists the days of the week in the order in which they are
+// written in a schedule.
+var weekOrder = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+// noTime indicates that a schedule does not load (or unload) the key.
+const noTime = -1
+
+// Schedule specifies the times at which a key is loaded and unloaded
+// automatically.  It is written as the days to which it applies, followed by
+// the times at which the key is loaded and unloaded (e.g., 'Mon-Fri
+// 08:45-18:00').  Either time may be omitted (e.g., 'Daily -18:00' only
+// unloads the key).  If the key is unloaded at an earlier time of day than it
+// is loaded (e.g., 'Mon-Fri 22:00-06:00'), it is unloaded the following
+// morning, even if the schedule does not apply to that day.  Times are in the
+// device's local time zone.
+type Schedule struct {
+	// Days indicates the days of the week to which the schedule
+	// applies, indexed by time.Weekday.
+	Days [7]bool
+	// Load is the time at which the key is loaded, in minutes after
+	// midnight, or -1 if it is not loaded automatically.
+	Load int
+	// Unload is the time at which the key is unloaded, in minutes after
+	// midnight, or -1 if it is not unloaded automatically.
+	Unload int
+}
+
+// parseDay parses the name of a day of the week.
+func parseDay(s string) (time.Weekday, error) {
+	for i, n := range dayNames {
+		if strings.EqualFold(s, n) {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q; use Mon, Tue, Wed, Thu, Fri, Sat or Sun", s)
+}
+
+// weekIndex returns the position of the day within weekOrder.
+func weekIndex(d time.Weekday) int {
+	return (int(d) + 6) % 7
+}
+
+// parseDays parses the days to which a schedule applies: 'Daily', or a
+// comma-separated list of days and ranges of days (e.g., 'Mon-Fri,Sun').
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	if strings.EqualFold(s, "daily") {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return days, fmt.Errorf("invalid range of days %q", part)
+		}
+		first, err := parseDay(bounds[0])
+		if err != nil {
+			return days, err
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = parseDay(bounds[1]); err != nil {
+				return days, err
+			}
+		}
+		if weekIndex(last) < weekIndex(first) {
+			return days, fmt.Errorf("invalid range of days %q; weeks begin on Monday", part)
+		}
+		for i := weekIndex(first); i <= weekIndex(last); i++ {
+			days[weekOrder[i]] = true
+		}
+	}
+	return days, nil
+}
+
+// parseTimeOfDay parses a time of day in 24-hour format (e.g., '18:00') and
+// returns the number of minutes after midnight, or -1 if s is empty.
+func parseTimeOfDay(s string) (int, error) {
+	if s == "" {
+		return noTime, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("invalid time %q; use 24-hour time (e.g., 18:00)", s)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 23 {
+		return 0, fmt.Errorf("invalid hour in time %q", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid minute in time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// ParseSchedule parses a schedule (e.g., 'Mon-Fri 08:45-18:00').
+func ParseSchedule(s string) (*Schedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid schedule %q; use days followed by times (e.g., 'Mon-Fri 08:45-18:00')", s)
+	}
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	times := strings.Split(fields[1], "-")
+	if len(times) != 2 {
+		return nil, fmt.Errorf("invalid times %q; use the time at which the key is loaded and unloaded (e.g., 08:45-18:00)", fields[1])
+	}
+	load, err := parseTimeOfDay(times[0])
+	if err != nil {
+		return nil, err
+	}
+	unload, err := parseTimeOfDay(times[1])
+	if err != nil {
+		return nil, err
+	}
+	if load == noTime && unload == noTime {
+		return nil, errors.New("schedule must load or unload the key")
+	}
+	if load == unload {
+		return nil, errors.New("schedule must not load and unload the key at the same time")
+	}
+	return &Schedule{Days: days, Load: load, Unload: unload}, nil
+}
+
+// formatTimeOfDay formats a time in minutes after midnight, or returns the
+// empty string if it is -1.
+func formatTimeOfDay(t int) string {
+	if t == noTime {
+		return ""
+	}
+	return fmt.Sprintf("%02d:%02d", t/60, t%60)
+}
+
+// days returns the days to which the schedule applies, as written in it.
+// Runs of three or more days are written as ranges.
+func (s *Schedule) days() string {
+	var parts []string
+	for i := 0; i < len(weekOrder); {
+		if !s.Days[weekOrder[i]] {
+			i++
+			continue
+		}
+		j := i
+		for j+1 < len(weekOrder) && s.Days[weekOrder[j+1]] {
+			j++
+		}
+		switch {
+		case j-i+1 == len(weekOrder):
+			return "Daily"
+		case j-i >= 2:
+			parts = append(parts, dayNames[weekOrder[i]]+"-"+dayNames[weekOrder[j]])
+		default:
+			for k := i; k <= j; k++ {
+				parts = append(parts, dayNames[weekOrder[k]])
+			}
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// String returns the schedule in the form accepted by ParseSchedule.
+func (s *Schedule) String() string {
+	return fmt.Sprintf("%s %s-%s", s.days(), formatTimeOfDay(s.Load), formatTimeOfDay(s.Unload))
+}
+
+// Description returns a human-readable description of the schedule (e.g.,
+// 'Loaded at 08:45 and unloaded at 18:00, Mon-Fri').
+func (s *Schedule) Description() string {
+	var what string
+	switch {
+	case s.Unload == noTime:
+		what = fmt.Sprintf("Loaded at %s", formatTimeOfDay(s.Load))
+	case s.Load == noTime:
+		what = fmt.Sprintf("Unloaded at %s", formatTimeOfDay(s.Unload))
+	default:
+		what = fmt.Sprintf("Loaded at %s and unloaded at %s", formatTimeOfDay(s.Load), formatTimeOfDay(s.Unload))
+	}
+	if s.overnight() {
+		what += " the next day"
+	}
+	days := s.days()
+	if days == "Daily" {
+		days = "daily"
+	}
+	return fmt.Sprintf("%s, %s", what, days)
+}
+
+// at returns the time at which the event scheduled at t minutes after
+// midnight occurs on the day containing day.
+func at(day time.Time, t int) time.Time {
+	y, m, d := day.Date()
+	return time.Date(y, m, d, t/60, t%60, 0, 0, day.Location())
+}
+
+// overnight determines if the key is unloaded the day after it is loaded
+// (e.g., 'Mon-Fri 22:00-06:00').
+func (s *Schedule) overnight() bool {
+	return s.Load != noTime && s.Unload != noTime && s.Unload < s.Load
+}
+
+// scheduledEvent is a time at which a key is loaded or unloaded.
+type scheduledEvent struct {
+	when time.Time
+	load bool
+}
+
+// events returns the events scheduled by the schedule for the day containing
+// day, if it is one of the days to which the schedule applies.  The unload
+// of an overnight schedule occurs on the following day.
+func (s *Schedule) events(day time.Time) []scheduledEvent {
+	if !s.Days[day.Weekday()] {
+		return nil
+	}
+	var events []scheduledEvent
+	if s.Load != noTime {
+		events = append(events, scheduledEvent{when: at(day, s.Load), load: true})
+	}
+	if s.Unload != noTime {
+		unloadDay := day
+		if s.overnight() {
+			unloadDay = day.AddDate(0, 0, 1)
+		}
+		events = append(events, scheduledEvent{when: at(unloadDay, s.Unload)})
+	}
+	return events
+}
+
+// Last returns the most recent scheduled event after since, up to and
+// including now.  load indicates whether the key is loaded (as opposed to
+// unloaded) at that time.  ok is false if no event was scheduled in that
+// period.
+func (s *Schedule) Last(since, now time.Time) (when time.Time, load bool, ok bool) {
+	// Events repeat weekly, so the most recent is within the last
+	// week.
+	for i := 0; i <= 7; i++ {
+		for _, e := range s.events(now.AddDate(0, 0, -i)) {
+			if e.when.After(now) || !e.when.After(since) {
+				continue
+			}
+			if !ok || e.when.After(when) {
+				when, load, ok = e.when, e.load, true
+			}
+		}
+	}
+	return when, load, ok
+}
+
+// Next returns the first scheduled event after now.  load indicates whether
+// the key is loaded (as opposed to unloaded) at that time.
+func (s *Schedule) Next(now time.Time) (when time.Time, load bool) {
+	// The unload of an overnight schedule that began yesterday may be
+	// still to come.
+	found := false
+	for i := -1; i <= 7; i++ {
+		for _, e := range s.events(now.AddDate(0, 0, i)) {
+			if !e.when.After(now) {
+				continue
+			}
+			if !found || e.when.Before(when) {
+				when, load, found = e.when, e.load, true
+			}
+		}
+	}
+	return when, load
+}
+
+// SetSchedule implements Manager.SetSchedule.
+func (m *manager) SetSchedule(id ID, schedule string, callback func(err error)) {
+	m.writes.runErr(func(callback func(err error)) {
+		m.setSchedule(id, schedule, callback)
+	}, callback)
+}
+
+// setSchedule rewrites the stored key with the specified ID to set the
+// schedule on which it is loaded and unloaded.  callback is invoked when
+// complete.
+func (m *manager) setSchedule(id ID, schedule string, callback func(err error)) {
+	var sched *Schedule
+	if schedule != "" {
+		var err error
+		if sched, err = ParseSchedule(schedule); err != nil {
+			callback(err)
+			return
+		}
+		schedule = sched.String()
+	}
+
+	m.readKey(id, func(key *storedKey, err error) {
+		if err != nil {
+			callback(help.Errorf(help.StorageFailure, "failed to read key: %v", err))
+			return
+		}
+		if key == nil {
+			callback(help.Errorf(help.KeyNotFound, "failed to find key with ID %s", id))
+			return
+		}
+		if sched != nil && sched.Load != noTime && key.Encrypted() {
+			callback(fmt.Errorf("key %q is encrypted with a passphrase, so cannot be loaded on a schedule", key.Name))
+			return
+		}
+
+		key.Schedule = schedule
+		key.Updated = nowMillis()
+		data := map[string]interface{}{
+			storageKey(id): key.value(),
+		}
+		m.storeFor(key.DeviceOnly).Set(data, func(err error) {
+			if err != nil {
+				callback(help.Errorf(help.StorageFailure, "failed to write key: %v", err))
+				return
+			}
+			if m.audit != nil {
+				action := fmt.Sprintf("key %q scheduled: %s", key.Name, schedule)
+				if sched == nil {
+					action = fmt.Sprintf("key %q is no longer scheduled", key.Name)
+				}
+				m.audit.Record(audit.NewEntry("schedule", "options", string(id), true, action), nil)
+			}
+			callback(nil)
+		})
+	})
+}
+
+// Scheduler loads and unloads keys at the times in their schedules (see
+// ConfiguredKey.Schedule).  Keys are loaded using Manager.Load, so the load
+// policy is consulted as when the user loads them.
+type Scheduler struct {
+	mgr Manager
+
+	mu sync.Mutex
+	// last is the time up to which scheduled events have been handled.
+	last time.Time
+}
+
+// NewScheduler returns a Scheduler that loads and unloads the keys managed
+// by mgr.  Events scheduled after since are handled by the first call to
+// Run.
+func NewScheduler(mgr Manager, since time.Time) *Scheduler {
+	return &Scheduler{
+		mgr:  mgr,
+		last: since,
+	}
+}
+
+// Run handles the events scheduled since the previous call to Run, up to and
+// including now.  If several events for a key were scheduled in that time
+// (e.g., while the device was asleep), only the most recent is handled.  Keys
+// already in the scheduled state are left alone, as are revoked keys.
+// callback is invoked with the outcome of loading and unloading keys.
+func (s *Scheduler) Run(now time.Time, callback func(loaded, unloaded *Result, err error)) {
+	s.mu.Lock()
+	since := s.last
+	if now.After(s.last) {
+		s.last = now
+	}
+	s.mu.Unlock()
+
+	loadResult, unloadResult := NewResult("load"), NewResult("unload")
+	s.mgr.Loaded(func(loaded []*LoadedKey, err error) {
+		if err != nil {
+			callback(loadResult, unloadResult, fmt.Errorf("failed to list loaded keys: %v", err))
+			return
+		}
+		s.mgr.Configured(func(configured []*ConfiguredKey, err error) {
+			if err != nil {
+				callback(loadResult, unloadResult, fmt.Errorf("failed to read configured keys: %v", err))
+				return
+			}
+			isLoaded := make(map[ID]*LoadedKey)
+			for _, l := range loaded {
+				isLoaded[l.ID()] = l
+			}
+
+			var load []ID
+			var unload []*LoadedKey
+			for _, ck := range configured {
+				if ck.Schedule == "" {
+					continue
+				}
+				sched, err := ParseSchedule(ck.Schedule)
+				if err != nil {
+					continue
+				}
+				_, toLoad, ok := sched.Last(since, now)
+				if !ok {
+					continue
+				}
+				l := isLoaded[ck.ID]
+				switch {
+				case toLoad && l == nil && !ck.Revoked:
+					load = append(load, ck.ID)
+				case !toLoad && l != nil:
+					unload = append(unload, l)
+				}
+			}
+
+			var next func(i int)
+			next = func(i int) {
+				if i < len(unload) {
+					s.mgr.Unload(unload[i], func(err error) {
+						unloadResult.Record(unload[i].ID(), err)
+						next(i + 1)
+					})
+					return
+				}
+				if j := i - len(unload); j < len(load) {
+					s.mgr.Load(load[j], "", func(err error) {
+						loadResult.Record(load[j], err)
+						next(i + 1)
+					})
+					return
+				}
+				callback(loadResult, unloadResult, nil)
+			}
+			next(0)
+		})
+	})
+}
@@ -0,0 +1,341 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestParseSchedule(t *testing.T) {
+	testcases := []struct {
+		description     string
+		schedule        string
+		want            string
+		wantDescription string
+		wantErr         bool
+	}{
+		{
+			description:     "weekdays",
+			schedule:        "Mon-Fri 08:45-18:00",
+			want:            "Mon-Fri 08:45-18:00",
+			wantDescription: "Loaded at 08:45 and unloaded at 18:00, Mon-Fri",
+		},
+		{
+			description:     "list of days",
+			schedule:        "sun,MON,tue,wed 9:00-",
+			want:            "Mon-Wed,Sun 09:00-",
+			wantDescription: "Loaded at 09:00, Mon-Wed,Sun",
+		},
+		{
+			description:     "daily",
+			schedule:        "  Daily   -23:59 ",
+			want:            "Daily -23:59",
+			wantDescription: "Unloaded at 23:59, daily",
+		},
+		{
+			description:     "every day listed",
+			schedule:        "Mon-Thu,Fri-Sun 00:00-12:00",
+			want:            "Daily 00:00-12:00",
+			wantDescription: "Loaded at 00:00 and unloaded at 12:00, daily",
+		},
+		{
+			description:     "overnight",
+			schedule:        "Mon-Fri 22:00-06:00",
+			want:            "Mon-Fri 22:00-06:00",
+			wantDescription: "Loaded at 22:00 and unloaded at 06:00 the next day, Mon-Fri",
+		},
+		{
+			description: "range ending before it begins",
+			schedule:    "Fri-Mon 08:45-18:00",
+			wantErr:     true,
+		},
+		{
+			description: "unknown day",
+			schedule:    "Someday 08:45-18:00",
+			wantErr:     true,
+		},
+		{
+			description: "missing times",
+			schedule:    "Mon-Fri",
+			wantErr:     true,
+		},
+		{
+			description: "neither load nor unload",
+			schedule:    "Mon-Fri -",
+			wantErr:     true,
+		},
+		{
+			description: "same time",
+			schedule:    "Mon-Fri 08:45-08:45",
+			wantErr:     true,
+		},
+		{
+			description: "invalid hour",
+			schedule:    "Mon-Fri 24:00-",
+			wantErr:     true,
+		},
+		{
+			description: "invalid minute",
+			schedule:    "Mon-Fri 08:5-",
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		s, err := ParseSchedule(tc.schedule)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %t", tc.description, err, tc.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if diff := pretty.Diff(s.String(), tc.want); diff != nil {
+			t.Errorf("%s: incorrect schedule; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(s.Description(), tc.wantDescription); diff != nil {
+			t.Errorf("%s: incorrect description; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestScheduleEvents(t *testing.T) {
+	// 2018-05-07 is a Monday.
+	day := func(d, h, m int) time.Time {
+		return time.Date(2018, 5, d, h, m, 0, 0, time.UTC)
+	}
+	testcases := []struct {
+		description  string
+		schedule     string
+		since        time.Time
+		now          time.Time
+		wantLast     time.Time
+		wantLoad     bool
+		wantOk       bool
+		wantNext     time.Time
+		wantNextLoad bool
+	}{
+		{
+			description: "load in period",
+			schedule:    "Mon-Fri 08:45-18:00",
+			since:       day(7, 8, 44),
+			now:         day(7, 8, 45),
+			wantLast:    day(7, 8, 45),
+			wantLoad:    true,
+			wantOk:      true,
+			wantNext:    day(7, 18, 0),
+		},
+		{
+			description: "nothing in period",
+			schedule:    "Mon-Fri 08:45-18:00",
+			since:       day(7, 8, 45),
+			now:         day(7, 9, 0),
+			wantNext:    day(7, 18, 0),
+		},
+		{
+			description:  "most recent of several events",
+			schedule:     "Mon-Fri 08:45-18:00",
+			since:        day(6, 12, 0),
+			now:          day(8, 19, 0),
+			wantLast:     day(8, 18, 0),
+			wantOk:       true,
+			wantNext:     day(9, 8, 45),
+			wantNextLoad: true,
+		},
+		{
+			description:  "weekend skipped",
+			schedule:     "Mon-Fri 08:45-18:00",
+			since:        day(11, 18, 0),
+			now:          day(13, 12, 0),
+			wantNext:     day(14, 8, 45),
+			wantNextLoad: true,
+		},
+		{
+			description:  "load only",
+			schedule:     "Sat 08:45-",
+			since:        day(1, 0, 0),
+			now:          day(13, 12, 0),
+			wantLast:     day(12, 8, 45),
+			wantLoad:     true,
+			wantOk:       true,
+			wantNext:     day(19, 8, 45),
+			wantNextLoad: true,
+		},
+		{
+			description:  "overnight unload on day after last listed day",
+			schedule:     "Mon-Fri 22:00-06:00",
+			since:        day(11, 23, 0),
+			now:          day(12, 7, 0),
+			wantLast:     day(12, 6, 0),
+			wantOk:       true,
+			wantNext:     day(14, 22, 0),
+			wantNextLoad: true,
+		},
+		{
+			description: "overnight unload still to come",
+			schedule:    "Mon-Fri 22:00-06:00",
+			since:       day(11, 21, 0),
+			now:         day(11, 23, 0),
+			wantLast:    day(11, 22, 0),
+			wantLoad:    true,
+			wantOk:      true,
+			wantNext:    day(12, 6, 0),
+		},
+		{
+			description:  "overnight not loaded at weekend",
+			schedule:     "Mon-Fri 22:00-06:00",
+			since:        day(12, 7, 0),
+			now:          day(14, 5, 0),
+			wantNext:     day(14, 22, 0),
+			wantNextLoad: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		s, err := ParseSchedule(tc.schedule)
+		if err != nil {
+			t.Fatalf("%s: failed to parse schedule: %v", tc.description, err)
+		}
+		last, load, ok := s.Last(tc.since, tc.now)
+		if ok != tc.wantOk || (ok && (!last.Equal(tc.wantLast) || load != tc.wantLoad)) {
+			t.Errorf("%s: incorrect last event; got %v, %t, %t, want %v, %t, %t", tc.description, last, load, ok, tc.wantLast, tc.wantLoad, tc.wantOk)
+		}
+		next, load := s.Next(tc.now)
+		if !next.Equal(tc.wantNext) || load != tc.wantNextLoad {
+			t.Errorf("%s: incorrect next event; got %v, %t, want %v, %t", tc.description, next, load, tc.wantNext, tc.wantNextLoad)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	errRefused := errors.New("refused")
+	testcases := []struct {
+		description  string
+		refuse       bool
+		wantLoaded   int
+		wantUnloaded int
+		wantFailed   int
+	}{
+		{
+			description:  "loaded and unloaded on schedule",
+			wantLoaded:   1,
+			wantUnloaded: 1,
+		},
+		{
+			description: "load refused by policy",
+			refuse:      true,
+			wantFailed:  1,
+		},
+	}
+
+	for _, tc := range testcases {
+		policy := func(pub ssh.PublicKey, callback func(err error)) {
+			if tc.refuse {
+				callback(errRefused)
+				return
+			}
+			callback(nil)
+		}
+		mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), []*initialKey{
+			{
+				Name:          "work-key",
+				PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			},
+			{
+				Name:          "encrypted-key",
+				PEMPrivateKey: testdata.ValidPrivateKey,
+			},
+		}, WithLoadPolicy(policy))
+		if err != nil {
+			t.Fatalf("%s: failed to initialize manager: %v", tc.description, err)
+		}
+
+		// Keys encrypted with a passphrase may only be unloaded on a
+		// schedule.
+		id, err := findKey(mgr, InvalidID, "encrypted-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncSetSchedule(mgr, id, "Mon-Fri 08:45-18:00"); err == nil {
+			t.Errorf("%s: encrypted key unexpectedly scheduled to load", tc.description)
+		}
+		if err := syncSetSchedule(mgr, id, "Mon-Fri -18:00"); err != nil {
+			t.Errorf("%s: failed to schedule encrypted key to unload: %v", tc.description, err)
+		}
+		id, err = findKey(mgr, InvalidID, "work-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncSetSchedule(mgr, id, "Someday"); err == nil {
+			t.Errorf("%s: invalid schedule unexpectedly set", tc.description)
+		}
+		if err := syncSetSchedule(mgr, id, "mon-fri 8:45-18:00"); err != nil {
+			t.Fatalf("%s: failed to set schedule: %v", tc.description, err)
+		}
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to list keys: %v", tc.description, err)
+		}
+		for _, k := range configured {
+			if k.ID == id && k.Schedule != "Mon-Fri 08:45-18:00" {
+				t.Errorf("%s: incorrect schedule; got %q", tc.description, k.Schedule)
+			}
+		}
+
+		// 2018-05-07 is a Monday.
+		s := NewScheduler(mgr, time.Date(2018, 5, 7, 8, 0, 0, 0, time.UTC))
+		run := func(h, m int) (int, int, int) {
+			type result struct {
+				loaded, unloaded *Result
+				err              error
+			}
+			done := make(chan result, 1)
+			s.Run(time.Date(2018, 5, 7, h, m, 0, 0, time.UTC), func(loaded, unloaded *Result, err error) {
+				done <- result{loaded, unloaded, err}
+			})
+			r := <-done
+			if r.err != nil {
+				t.Errorf("%s: failed to run schedule at %02d:%02d: %v", tc.description, h, m, r.err)
+			}
+			return r.loaded.Succeeded, r.unloaded.Succeeded, r.loaded.Failed + r.unloaded.Failed
+		}
+
+		loaded, _, failed := run(9, 0)
+		if loaded != tc.wantLoaded || failed != tc.wantFailed {
+			t.Errorf("%s: incorrect result loading; got %d loaded, %d failed, want %d, %d", tc.description, loaded, failed, tc.wantLoaded, tc.wantFailed)
+		}
+		// Events already handled are not handled again.
+		if loaded, unloaded, failed := run(12, 0); loaded+unloaded+failed != 0 {
+			t.Errorf("%s: keys unexpectedly loaded or unloaded again", tc.description)
+		}
+		if _, unloaded, _ := run(18, 0); unloaded != tc.wantUnloaded {
+			t.Errorf("%s: incorrect number of keys unloaded; got %d, want %d", tc.description, unloaded, tc.wantUnloaded)
+		}
+		keys, err := syncLoaded(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		if len(keys) != 0 {
+			t.Errorf("%s: keys still loaded after schedule; got %d", tc.description, len(keys))
+		}
+	}
+}
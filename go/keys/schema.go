@@ -0,0 +1,229 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+)
+
+// fieldKind is the type of value expected for a field of a stored key.
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	numberField
+	boolField
+)
+
+// String returns a human-readable name for the kind of field.
+func (f fieldKind) String() string {
+	switch f {
+	case stringField:
+		return "string"
+	case numberField:
+		return "number"
+	case boolField:
+		return "boolean"
+	}
+	return "unknown"
+}
+
+// matches determines if a value read from storage is of the expected kind.
+func (f fieldKind) matches(v interface{}) bool {
+	switch f {
+	case stringField:
+		_, ok := v.(string)
+		return ok
+	case numberField:
+		switch v.(type) {
+		case float64, int, int64:
+			return true
+		}
+		return false
+	case boolField:
+		_, ok := v.(bool)
+		return ok
+	}
+	return false
+}
+
+// SchemaVersion is the version of the format in which keys are stored.  It
+// is reported in diagnostic bundles and recorded in storage by SelfCheck,
+// and must be incremented whenever a change to storedKeySchema prevents
+// earlier versions from reading keys.
+const SchemaVersion = 1
+
+// storedKeyField describes a field of a stored key.
+type storedKeyField struct {
+	// name is the name under which the field is stored.
+	name string
+	kind fieldKind
+	// required indicates that the field must be present and, for strings,
+	// non-empty.
+	required bool
+}
+
+// storedKeySchema describes the fields of a stored key.  Fields are checked
+// in this order, so that a key with several malformed fields is always
+// reported the same way.  Fields not listed here are permitted so that keys
+// written by newer versions can still be read.
+var storedKeySchema = []storedKeyField{
+	{name: "id", kind: stringField, required: true},
+	{name: "name", kind: stringField},
+	{name: "pemPrivateKey", kind: stringField, required: true},
+	{name: "updated", kind: numberField},
+	{name: "deviceOnly", kind: boolField},
+	{name: "provider", kind: stringField},
+	{name: "source", kind: stringField},
+	{name: "sourceDetail", kind: stringField},
+	{name: "created", kind: numberField},
+	{name: "deviceId", kind: stringField},
+	{name: "deviceName", kind: stringField},
+	{name: "attestation", kind: stringField},
+	{name: "canary", kind: boolField},
+	{name: "revoked", kind: boolField},
+	{name: "loadOnStartup", kind: boolField},
+	{name: "schedule", kind: stringField},
+	{name: "notes", kind: stringField},
+	{name: "protection", kind: stringField},
+	{name: "certificate", kind: stringField},
+	{name: "totpSecret", kind: stringField},
+	{name: "metadata", kind: numberField},
+	{name: "encrypted", kind: boolField},
+	{name: "keyType", kind: stringField},
+	{name: "bits", kind: numberField},
+	{name: "fingerprintSHA256", kind: stringField},
+	{name: "fingerprintMD5", kind: stringField},
+	{name: "profile", kind: stringField},
+}
+
+// knownField determines if name is a field listed in storedKeySchema.
+func knownField(name string) bool {
+	for _, f := range storedKeySchema {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateStoredKey checks that a value read from persistent storage under
+// the specified storage key conforms to storedKeySchema.
+func validateStoredKey(key string, v interface{}) (map[string]interface{}, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("stored key is not an object")
+	}
+
+	for _, f := range storedKeySchema {
+		name := f.name
+		fv, present := m[name]
+		if !present || fv == nil {
+			if f.required {
+				return nil, fmt.Errorf("stored key is missing field %s", name)
+			}
+			continue
+		}
+		if !f.kind.matches(fv) {
+			return nil, fmt.Errorf("stored key field %s must be a %s", name, f.kind)
+		}
+		if f.required && f.kind == stringField && fv.(string) == "" {
+			return nil, fmt.Errorf("stored key field %s must not be empty", name)
+		}
+	}
+
+	if id := m["id"].(string); storageKey(ID(id)) != key {
+		return nil, fmt.Errorf("stored key ID %s does not match storage key %s", id, key)
+	}
+	return m, nil
+}
+
+// newStoredKey converts a value read from persistent storage under the
+// specified storage key into a storedKey.  An error is returned if the value
+// does not conform to storedKeySchema.
+func newStoredKey(key string, v interface{}) (*storedKey, error) {
+	m, err := validateStoredKey(key, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return storedKeyFromValue(m)
+}
+
+// parseStoredKeys returns the stored keys contained in data read from
+// persistent storage.  Items that are not keys are ignored.  The storage keys
+// of items that are malformed are returned in corrupt.
+func parseStoredKeys(data map[string]interface{}) (keys []*storedKey, corrupt []string) {
+	for k, v := range data {
+		if !strings.HasPrefix(k, keyPrefix) {
+			continue
+		}
+
+		sk, err := newStoredKey(k, v)
+		if err != nil {
+			corrupt = append(corrupt, k)
+			continue
+		}
+		keys = append(keys, sk)
+	}
+	return keys, corrupt
+}
+
+const (
+	// quarantinePrefix is the prefix under which malformed keys are moved
+	// so that they are no longer read as keys, but remain available for
+	// recovery.  The full key is of the form 'quarantine.key.<id>'.
+	quarantinePrefix = "quarantine."
+)
+
+// quarantine moves the malformed items with the specified storage keys out of
+// the way.  data contains the items as read from store. Failures are logged
+// rather than returned, since quarantine is a best-effort attempt to avoid
+// repeatedly encountering the same corrupt items.  callback is invoked when
+// complete.
+func (m *manager) quarantine(store PersistentStore, data map[string]interface{}, corrupt []string, callback func()) {
+	if len(corrupt) == 0 {
+		callback()
+		return
+	}
+
+	moved := make(map[string]interface{})
+	for _, k := range corrupt {
+		moved[quarantinePrefix+k] = data[k]
+		log.Printf("Quarantining malformed key %s", k)
+		if m.audit != nil {
+			m.audit.Record(audit.NewEntry("quarantine", "storage", k, true, "moved malformed key to "+quarantinePrefix+k), nil)
+		}
+	}
+
+	store.Set(moved, func(err error) {
+		if err != nil {
+			log.Printf("Failed to quarantine malformed keys: %v", err)
+			callback()
+			return
+		}
+		store.Delete(corrupt, func(err error) {
+			if err != nil {
+				log.Printf("Failed to remove quarantined keys: %v", err)
+			}
+			callback()
+		})
+	})
+}
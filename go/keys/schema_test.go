@@ -0,0 +1,154 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestValidateStoredKey(t *testing.T) {
+	testcases := []struct {
+		description string
+		key         string
+		value       interface{}
+		wantErr     error
+	}{
+		{
+			description: "valid key",
+			key:         "key.1",
+			value:       storedKeyData(ID("1"), "name", "pem", 100),
+		},
+		{
+			description: "unknown fields are permitted",
+			key:         "key.1",
+			value: map[string]interface{}{
+				"id":            "1",
+				"pemPrivateKey": "pem",
+				"futureField":   42.0,
+			},
+		},
+		{
+			description: "not an object",
+			key:         "key.1",
+			value:       "garbage",
+			wantErr:     errors.New("stored key is not an object"),
+		},
+		{
+			description: "missing required field",
+			key:         "key.1",
+			value: map[string]interface{}{
+				"id":   "1",
+				"name": "name",
+			},
+			wantErr: errors.New("stored key is missing field pemPrivateKey"),
+		},
+		{
+			description: "empty required field",
+			key:         "key.",
+			value: map[string]interface{}{
+				"id":            "",
+				"pemPrivateKey": "pem",
+			},
+			wantErr: errors.New("stored key field id must not be empty"),
+		},
+		{
+			description: "incorrect type",
+			key:         "key.1",
+			value: map[string]interface{}{
+				"id":            "1",
+				"name":          42.0,
+				"pemPrivateKey": "pem",
+			},
+			wantErr: errors.New("stored key field name must be a string"),
+		},
+		{
+			description: "mismatched ID",
+			key:         "key.2",
+			value:       storedKeyData(ID("1"), "name", "pem", 100),
+			wantErr:     errors.New("stored key ID 1 does not match storage key key.2"),
+		},
+	}
+
+	for _, tc := range testcases {
+		_, err := validateStoredKey(tc.key, tc.value)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	storage := fakes.NewMemStorage()
+	mgr, err := newTestManager(agent.NewKeyring(), storage, fakes.NewMemStorage(), []*initialKey{
+		{
+			Name:          "good-key",
+			PEMPrivateKey: testdata.ValidPrivateKey,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+
+	// Corrupt a key behind the manager's back.
+	storage.Set(map[string]interface{}{
+		"key.bad": map[string]interface{}{"id": "bad", "name": 42},
+	}, func(err error) {
+		if err != nil {
+			t.Fatalf("failed to write corrupt key: %v", err)
+		}
+	})
+
+	// Attempting to load the key fails, and quarantines it.  Fields are
+	// checked in order, so the malformed name is reported before the
+	// missing private key.
+	err = syncLoad(mgr, ID("bad"), "")
+	wantErr := errors.New("failed to read key: key has been quarantined: stored key field name must be a string")
+	if err == nil || err.Error() != wantErr.Error() {
+		t.Errorf("incorrect error; got %v, want %v", err, wantErr)
+	}
+
+	// Configured keys exclude the corrupt key.
+	configured, err := syncConfigured(mgr)
+	if err != nil {
+		t.Errorf("failed to get configured keys: %v", err)
+	}
+	if diff := pretty.Diff(configuredKeyNames(configured), []string{"good-key"}); diff != nil {
+		t.Errorf("incorrect configured keys; -got +want: %s", diff)
+	}
+
+	// The corrupt key remains available for recovery.
+	storage.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			t.Fatalf("failed to read storage: %v", err)
+		}
+		var quarantined []string
+		for k := range data {
+			if k == "key.bad" || k == quarantinePrefix+"key.bad" {
+				quarantined = append(quarantined, k)
+			}
+		}
+		sort.Strings(quarantined)
+		if diff := pretty.Diff(quarantined, []string{"quarantine.key.bad"}); diff != nil {
+			t.Errorf("incorrect quarantined keys; -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,207 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// SchemaVersionKey is the key under which the SchemaVersion of the
+	// keys in synced storage is recorded by SelfCheck.
+	SchemaVersionKey = "schemaVersion"
+)
+
+// Health is the outcome of a self-check.
+type Health string
+
+const (
+	// Healthy indicates that nothing is wrong.
+	Healthy Health = "green"
+	// Degraded indicates a problem that does not prevent keys from being
+	// used, but that the user may need to address.
+	Degraded Health = "amber"
+	// Failing indicates that stored keys may be damaged or unusable.
+	Failing Health = "red"
+)
+
+// healthRank orders each Health from best to worst.
+var healthRank = map[Health]int{
+	Healthy:  0,
+	Degraded: 1,
+	Failing:  2,
+}
+
+// Worse returns the worse of h and o.
+func (h Health) Worse(o Health) Health {
+	if healthRank[o] > healthRank[h] {
+		return o
+	}
+	return h
+}
+
+// Names of the checks performed by SelfCheck.
+const (
+	// SchemaCheck verifies the schema version of stored keys.
+	SchemaCheck = "schema"
+	// FingerprintCheck recomputes the fingerprints of stored keys.
+	FingerprintCheck = "fingerprints"
+	// VaultCheck verifies the master key cached for the session.
+	VaultCheck = "vault"
+)
+
+// CheckResult is the outcome of a single check performed by SelfCheck.
+type CheckResult struct {
+	// Name is the name of the check (e.g., FingerprintCheck).
+	Name string `codec:"name"`
+	// Health is the outcome of the check.
+	Health Health `codec:"health"`
+	// Detail describes the outcome.
+	Detail string `codec:"detail"`
+}
+
+// SelfCheck implements Manager.SelfCheck.
+func (m *manager) SelfCheck(sample int, callback func(results []*CheckResult, err error)) {
+	m.checkSchema(func(schema *CheckResult) {
+		m.readVault(func(params *vaultParams, err error) {
+			vault := m.checkVault(params, err)
+			m.readKeys(func(keys []*storedKey, err error) {
+				fingerprints := m.checkFingerprints(keys, sample)
+				if d, ok := err.(*ErrDegraded); ok {
+					fingerprints.Health = fingerprints.Health.Worse(Degraded)
+					fingerprints.Detail = fmt.Sprintf("%s; only some keys could be read: %v", fingerprints.Detail, d.Err)
+				} else if err != nil {
+					fingerprints = &CheckResult{
+						Name:   FingerprintCheck,
+						Health: Failing,
+						Detail: fmt.Sprintf("failed to read keys: %v", err),
+					}
+				}
+				callback([]*CheckResult{schema, fingerprints, vault}, nil)
+			})
+		})
+	})
+}
+
+// checkSchema compares the schema version recorded in synced storage with
+// SchemaVersion.  If none is recorded, or it is older, SchemaVersion is
+// recorded.  callback is invoked with the result.
+func (m *manager) checkSchema(callback func(r *CheckResult)) {
+	result := func(health Health, format string, args ...interface{}) {
+		callback(&CheckResult{Name: SchemaCheck, Health: health, Detail: fmt.Sprintf(format, args...)})
+	}
+	m.storage.GetItems([]string{SchemaVersionKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			result(Failing, "failed to read schema version: %v", err)
+			return
+		}
+		// Numbers are decoded from storage as float64.
+		version, _ := data[SchemaVersionKey].(float64)
+		switch {
+		case int(version) > SchemaVersion:
+			result(Degraded, "keys were stored by a newer version of the extension (schema version %d; this version supports %d)", int(version), SchemaVersion)
+		case int(version) == SchemaVersion:
+			result(Healthy, "schema version %d", SchemaVersion)
+		default:
+			m.storage.Set(map[string]interface{}{SchemaVersionKey: float64(SchemaVersion)}, func(err error) {
+				if err != nil {
+					result(Degraded, "failed to record schema version: %v", err)
+					return
+				}
+				result(Healthy, "recorded schema version %d", SchemaVersion)
+			})
+		}
+	})
+}
+
+// checkVault verifies that the master key cached for the session, if any,
+// decrypts the check value stored with the vault's parameters.  params and
+// err are as returned by readVault.
+func (m *manager) checkVault(params *vaultParams, err error) *CheckResult {
+	result := func(health Health, format string, args ...interface{}) *CheckResult {
+		return &CheckResult{Name: VaultCheck, Health: health, Detail: fmt.Sprintf(format, args...)}
+	}
+	switch {
+	case err != nil:
+		return result(Failing, "%v", err)
+	case params == nil:
+		return result(Healthy, "no master passphrase is set")
+	case m.vault == nil:
+		return result(Healthy, "vault is locked")
+	}
+	check, err := m.vault.open(params.Check)
+	if help.CodeOf(err) == help.VaultLocked {
+		return result(Degraded, "master passphrase was changed on another device; unlock the vault again")
+	} else if err != nil {
+		return result(Failing, "master key does not decrypt the vault: %v", err)
+	} else if check != vaultCheckText {
+		return result(Failing, "master key does not decrypt the vault: incorrect check value")
+	}
+	return result(Healthy, "master key decrypts the vault")
+}
+
+// checkFingerprints recomputes the fingerprints of up to sample randomly
+// chosen keys for which fingerprints were recorded, and compares them to
+// the recorded fingerprints.  Keys sealed by the vault are unsealed using
+// the master key cached for the session; they are skipped if the vault is
+// locked.
+func (m *manager) checkFingerprints(keys []*storedKey, sample int) *CheckResult {
+	var candidates []*storedKey
+	for _, k := range keys {
+		if k.hasMetadata() && k.FingerprintSHA256 != "" {
+			candidates = append(candidates, k)
+		}
+	}
+
+	var checked int
+	var failed []string
+	for _, i := range rand.Perm(len(candidates)) {
+		if checked >= sample {
+			break
+		}
+		k := *candidates[i]
+		if err := m.unseal(&k); help.CodeOf(err) == help.VaultLocked {
+			continue
+		} else if err != nil {
+			failed = append(failed, fmt.Sprintf("%q could not be unsealed", k.Name))
+			checked++
+			continue
+		}
+		checked++
+		if pub := m.publicKey(&k); pub == nil {
+			failed = append(failed, fmt.Sprintf("%q could not be parsed", k.Name))
+		} else if fp := ssh.FingerprintSHA256(pub); fp != k.FingerprintSHA256 {
+			failed = append(failed, fmt.Sprintf("%q has fingerprint %s, but %s was recorded", k.Name, fp, k.FingerprintSHA256))
+		}
+	}
+
+	if len(failed) > 0 {
+		return &CheckResult{
+			Name:   FingerprintCheck,
+			Health: Failing,
+			Detail: fmt.Sprintf("%d of %d keys checked failed: %s", len(failed), checked, strings.Join(failed, "; ")),
+		}
+	}
+	return &CheckResult{
+		Name:   FingerprintCheck,
+		Health: Healthy,
+		Detail: fmt.Sprintf("verified %d of %d keys", checked, len(candidates)),
+	}
+}
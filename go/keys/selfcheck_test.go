@@ -0,0 +1,181 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// checkHealth runs a self-check and compares the health reported by each
+// check to want, indexed by check name.  It returns the results, indexed by
+// check name.
+func checkHealth(t *testing.T, description string, mgr Manager, want map[string]Health) map[string]*CheckResult {
+	results, err := syncSelfCheck(mgr, 10)
+	if err != nil {
+		t.Fatalf("%s: self-check failed: %v", description, err)
+	}
+	got := make(map[string]Health)
+	byName := make(map[string]*CheckResult)
+	for _, r := range results {
+		got[r.Name] = r.Health
+		byName[r.Name] = r
+	}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("%s: incorrect health; -got +want: %s", description, diff)
+	}
+	return byName
+}
+
+// setStoredField sets a field of every key in storage.
+func setStoredField(t *testing.T, storage *fakes.MemStorage, field string, value interface{}) {
+	storage.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			t.Fatalf("failed to read storage: %v", err)
+		}
+		for k, v := range data {
+			m, ok := v.(map[string]interface{})
+			if !KeysChanged(map[string]interface{}{k: v}) || !ok {
+				continue
+			}
+			m[field] = value
+			storage.Set(map[string]interface{}{k: m}, func(err error) {
+				if err != nil {
+					t.Fatalf("failed to write %s: %v", k, err)
+				}
+			})
+		}
+	})
+}
+
+func TestSelfCheck(t *testing.T) {
+	syncStorage := fakes.NewMemStorage()
+	mgr, err := newTestManager(agent.NewKeyring(), syncStorage, fakes.NewMemStorage(), []*initialKey{
+		{
+			Name:          "encrypted-key",
+			PEMPrivateKey: testdata.ValidPrivateKey,
+		},
+		{
+			Name:          "plaintext-key",
+			PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+	healthy := map[string]Health{
+		SchemaCheck:      Healthy,
+		FingerprintCheck: Healthy,
+		VaultCheck:       Healthy,
+	}
+
+	// The schema version is recorded by the first check.  Only the
+	// fingerprint of the unencrypted key is recorded, so only it is
+	// checked.
+	results := checkHealth(t, "initially", mgr, healthy)
+	if got, want := results[SchemaCheck].Detail, "recorded schema version 1"; got != want {
+		t.Errorf("incorrect initial schema detail; got %q, want %q", got, want)
+	}
+	if got, want := results[FingerprintCheck].Detail, "verified 1 of 1 keys"; got != want {
+		t.Errorf("incorrect fingerprint detail; got %q, want %q", got, want)
+	}
+	results = checkHealth(t, "recorded schema", mgr, healthy)
+	if got, want := results[SchemaCheck].Detail, "schema version 1"; got != want {
+		t.Errorf("incorrect schema detail; got %q, want %q", got, want)
+	}
+
+	// Keys stored by a newer version may not be fully understood.
+	syncStorage.Set(map[string]interface{}{SchemaVersionKey: float64(SchemaVersion + 1)}, func(err error) {
+		if err != nil {
+			t.Fatalf("failed to write schema version: %v", err)
+		}
+	})
+	checkHealth(t, "newer schema", mgr, map[string]Health{
+		SchemaCheck:      Degraded,
+		FingerprintCheck: Healthy,
+		VaultCheck:       Healthy,
+	})
+	syncStorage.Delete([]string{SchemaVersionKey}, func(err error) {
+		if err != nil {
+			t.Fatalf("failed to delete schema version: %v", err)
+		}
+	})
+
+	// Keys sealed by the vault are checked while it is unlocked.
+	if err := syncSetMasterPassphrase(mgr, "", "master"); err != nil {
+		t.Fatalf("failed to set master passphrase: %v", err)
+	}
+	results = checkHealth(t, "unlocked vault", mgr, healthy)
+	if got, want := results[FingerprintCheck].Detail, "verified 1 of 1 keys"; got != want {
+		t.Errorf("incorrect fingerprint detail with unlocked vault; got %q, want %q", got, want)
+	}
+	other := NewManager(agent.NewKeyring(), syncStorage, fakes.NewMemStorage())
+	results = checkHealth(t, "locked vault", other, healthy)
+	if got, want := results[FingerprintCheck].Detail, "verified 0 of 1 keys"; got != want {
+		t.Errorf("incorrect fingerprint detail with locked vault; got %q, want %q", got, want)
+	}
+
+	// A master key cached before the master passphrase was changed
+	// elsewhere no longer decrypts the vault.
+	if err := syncUnlockVault(other, "master"); err != nil {
+		t.Fatalf("failed to unlock vault: %v", err)
+	}
+	if err := syncSetMasterPassphrase(mgr, "master", "changed"); err != nil {
+		t.Fatalf("failed to change master passphrase: %v", err)
+	}
+	checkHealth(t, "changed master passphrase", other, map[string]Health{
+		SchemaCheck:      Healthy,
+		FingerprintCheck: Healthy,
+		VaultCheck:       Degraded,
+	})
+	if err := syncSetMasterPassphrase(mgr, "changed", ""); err != nil {
+		t.Fatalf("failed to remove master passphrase: %v", err)
+	}
+
+	// A recorded fingerprint that does not match the private key is
+	// reported.
+	setStoredField(t, syncStorage, "fingerprintSHA256", "SHA256:wrong")
+	results = checkHealth(t, "mismatched fingerprint", mgr, map[string]Health{
+		SchemaCheck:      Healthy,
+		FingerprintCheck: Failing,
+		VaultCheck:       Healthy,
+	})
+	if got := results[FingerprintCheck].Detail; !strings.Contains(got, `"plaintext-key" has fingerprint`) {
+		t.Errorf("incorrect fingerprint detail for mismatch; got %q", got)
+	}
+}
+
+func TestHealthWorse(t *testing.T) {
+	testcases := []struct {
+		h, o Health
+		want Health
+	}{
+		{h: Healthy, o: Healthy, want: Healthy},
+		{h: Healthy, o: Degraded, want: Degraded},
+		{h: Failing, o: Degraded, want: Failing},
+		{h: Degraded, o: Failing, want: Failing},
+	}
+
+	for _, tc := range testcases {
+		if got := tc.h.Worse(tc.o); got != tc.want {
+			t.Errorf("%s.Worse(%s): got %s, want %s", tc.h, tc.o, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// SetLoadOnStartup implements Manager.SetLoadOnStartup.
+func (m *manager) SetLoadOnStartup(id ID, enabled bool, callback func(err error)) {
+	m.writes.runErr(func(callback func(err error)) {
+		m.setLoadOnStartup(id, enabled, callback)
+	}, callback)
+}
+
+// setLoadOnStartup rewrites the stored key with the specified ID to set
+// whether it is loaded when the agent starts.  callback is invoked when
+// complete.
+func (m *manager) setLoadOnStartup(id ID, enabled bool, callback func(err error)) {
+	m.readKey(id, func(key *storedKey, err error) {
+		if err != nil {
+			callback(help.Errorf(help.StorageFailure, "failed to read key: %v", err))
+			return
+		}
+		if key == nil {
+			callback(help.Errorf(help.KeyNotFound, "failed to find key with ID %s", id))
+			return
+		}
+		if enabled && key.Encrypted() {
+			callback(fmt.Errorf("key %q is encrypted with a passphrase, so cannot be loaded on startup", key.Name))
+			return
+		}
+
+		key.LoadOnStartup = enabled
+		key.Updated = nowMillis()
+		data := map[string]interface{}{
+			storageKey(id): key.value(),
+		}
+		m.storeFor(key.DeviceOnly).Set(data, func(err error) {
+			if err != nil {
+				callback(help.Errorf(help.StorageFailure, "failed to write key: %v", err))
+				return
+			}
+			if m.audit != nil {
+				action := "key %q will be loaded on startup"
+				if !enabled {
+					action = "key %q will no longer be loaded on startup"
+				}
+				m.audit.Record(audit.NewEntry("startup", "options", string(id), true, fmt.Sprintf(action, key.Name)), nil)
+			}
+			callback(nil)
+		})
+	})
+}
+
+// LoadOnStartup implements Manager.LoadOnStartup.
+func (m *manager) LoadOnStartup(callback func(loaded int, err error)) {
+	m.readKeys(func(keys []*storedKey, err error) {
+		// Load the keys that could be read, even if others could not.
+		if _, ok := err.(*ErrDegraded); !ok && err != nil {
+			callback(0, fmt.Errorf("failed to read keys: %v", err))
+			return
+		}
+
+		m.Loaded(func(loaded []*LoadedKey, err error) {
+			if err != nil {
+				callback(0, fmt.Errorf("failed to list loaded keys: %v", err))
+				return
+			}
+			isLoaded := make(map[ID]bool)
+			for _, l := range loaded {
+				isLoaded[l.ID()] = true
+			}
+
+			var ids []ID
+			for _, k := range keys {
+				if !k.LoadOnStartup || k.Revoked || k.Encrypted() || isLoaded[k.ID] {
+					continue
+				}
+				if sealed(k.PEMPrivateKey) && m.vault == nil {
+					continue
+				}
+				ids = append(ids, k.ID)
+			}
+			m.loadEach(ids, callback)
+		})
+	})
+}
+
+// loadEach loads the keys with the specified IDs one at a time, without a
+// passphrase.  callback is invoked with the number of keys loaded, and an
+// error describing the first failure, if any.
+func (m *manager) loadEach(ids []ID, callback func(loaded int, err error)) {
+	loaded := 0
+	failed := 0
+	var firstErr error
+	var next func(i int)
+	next = func(i int) {
+		if i == len(ids) {
+			if failed > 0 {
+				callback(loaded, help.Wrap(firstErr, fmt.Sprintf("failed to load %d of %d keys", failed, len(ids))))
+				return
+			}
+			callback(loaded, nil)
+			return
+		}
+		m.Load(ids[i], "", func(err error) {
+			if err != nil {
+				failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+			} else {
+				loaded++
+			}
+			next(i + 1)
+		})
+	}
+	next(0)
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestLoadOnStartup(t *testing.T) {
+	testcases := []struct {
+		description string
+		vault       bool
+		unlock      bool
+		wantLoaded  int
+	}{
+		{
+			description: "unencrypted key loaded",
+			wantLoaded:  1,
+		},
+		{
+			description: "sealed key skipped while vault locked",
+			vault:       true,
+		},
+		{
+			description: "sealed key loaded once vault unlocked",
+			vault:       true,
+			unlock:      true,
+			wantLoaded:  1,
+		},
+	}
+
+	for _, tc := range testcases {
+		storage := fakes.NewMemStorage()
+		localStorage := fakes.NewMemStorage()
+		mgr, err := newTestManager(agent.NewKeyring(), storage, localStorage, []*initialKey{
+			{
+				Name:          "startup-key",
+				PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			},
+			{
+				Name:          "encrypted-key",
+				PEMPrivateKey: testdata.ValidPrivateKey,
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to initialize manager: %v", tc.description, err)
+		}
+		if tc.vault {
+			if err := syncSetMasterPassphrase(mgr, "", "master"); err != nil {
+				t.Fatalf("%s: failed to set master passphrase: %v", tc.description, err)
+			}
+		}
+
+		// Keys encrypted with a passphrase cannot be marked.
+		id, err := findKey(mgr, InvalidID, "encrypted-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncSetLoadOnStartup(mgr, id, true); err == nil {
+			t.Errorf("%s: encrypted key unexpectedly marked", tc.description)
+		}
+		id, err = findKey(mgr, InvalidID, "startup-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncSetLoadOnStartup(mgr, id, true); err != nil {
+			t.Fatalf("%s: failed to mark key: %v", tc.description, err)
+		}
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to list keys: %v", tc.description, err)
+		}
+		for _, k := range configured {
+			if got, want := k.LoadOnStartup, k.ID == id; got != want {
+				t.Errorf("%s: incorrect setting for %s; got %t, want %t", tc.description, k.Name, got, want)
+			}
+		}
+
+		// Simulate a restart, with an empty agent and a locked
+		// vault.
+		mgr = NewManager(agent.NewKeyring(), storage, localStorage)
+		if tc.unlock {
+			if err := syncUnlockVault(mgr, "master"); err != nil {
+				t.Fatalf("%s: failed to unlock vault: %v", tc.description, err)
+			}
+		}
+		loaded, err := syncLoadOnStartup(mgr)
+		if err != nil {
+			t.Errorf("%s: failed to load keys: %v", tc.description, err)
+		}
+		if loaded != tc.wantLoaded {
+			t.Errorf("%s: incorrect number of keys loaded; got %d, want %d", tc.description, loaded, tc.wantLoaded)
+		}
+		keys, err := syncLoaded(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		if len(keys) != tc.wantLoaded {
+			t.Errorf("%s: incorrect number of loaded keys; got %d, want %d", tc.description, len(keys), tc.wantLoaded)
+		}
+
+		// Keys that are already loaded are not loaded again.
+		if loaded, err := syncLoadOnStartup(mgr); err != nil || loaded != 0 {
+			t.Errorf("%s: incorrect result loading again; got %d, %v, want 0, nil", tc.description, loaded, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// ThrottlePolicy configures how attempts to decrypt a key are slowed down
+// after an incorrect passphrase, so that someone with access to an unlocked
+// session cannot quickly try many passphrases.
+type ThrottlePolicy struct {
+	// Backoff is how long after an incorrect passphrase the next attempt
+	// is refused.  It doubles for each subsequent consecutive incorrect
+	// passphrase.
+	Backoff time.Duration
+	// MaxBackoff is the longest delay between attempts, other than a
+	// lockout.
+	MaxBackoff time.Duration
+	// LockoutAfter is the number of consecutive incorrect passphrases
+	// after which the key is locked out.  Zero disables lockout.
+	LockoutAfter int
+	// Lockout is how long a key remains locked out.
+	Lockout time.Duration
+}
+
+// DefaultThrottlePolicy is a ThrottlePolicy that permits the occasional
+// mistyped passphrase without noticeable delay.
+var DefaultThrottlePolicy = ThrottlePolicy{
+	Backoff:      time.Second,
+	MaxBackoff:   time.Minute,
+	LockoutAfter: 10,
+	Lockout:      15 * time.Minute,
+}
+
+// WithThrottlePolicy specifies that attempts to load or export a key should
+// be throttled according to policy after an incorrect passphrase.  By
+// default, attempts are not throttled.
+//
+// Failures are tracked only in memory; they are forgotten when the
+// extension restarts.
+func WithThrottlePolicy(policy ThrottlePolicy) ManagerOption {
+	return func(m *manager) {
+		m.throttle = newThrottle(policy)
+	}
+}
+
+// ErrThrottled is returned when an attempt to decrypt a key is refused
+// because of previous incorrect passphrases.
+type ErrThrottled struct {
+	// ID is the ID of the key.
+	ID ID
+	// RetryAfter is how long until the next attempt is permitted.
+	RetryAfter time.Duration
+	// LockedOut indicates that the key is locked out, rather than only
+	// delayed.
+	LockedOut bool
+}
+
+// Error implements the error interface.
+func (e *ErrThrottled) Error() string {
+	// Round up, so that a retry after the reported delay succeeds.
+	wait := (e.RetryAfter + time.Second - 1) / time.Second * time.Second
+	if e.LockedOut {
+		return fmt.Sprintf("key is locked after too many incorrect passphrases; try again in %v", wait)
+	}
+	return fmt.Sprintf("incorrect passphrase entered recently; try again in %v", wait)
+}
+
+// HelpCode implements help.Coder.
+func (e *ErrThrottled) HelpCode() help.Code {
+	return help.TooManyAttempts
+}
+
+// attempts tracks the incorrect passphrases entered for a single key.
+type attempts struct {
+	// failures is the number of consecutive incorrect passphrases.
+	failures int
+	// next is the time at which the next attempt is permitted.
+	next time.Time
+	// lockedOut indicates that next is the end of a lockout.
+	lockedOut bool
+}
+
+// throttle tracks incorrect passphrases for each key, and refuses attempts
+// that are made too soon after them.
+type throttle struct {
+	policy ThrottlePolicy
+	now    func() time.Time
+	mu     sync.Mutex
+	keys   map[ID]*attempts
+}
+
+// newThrottle returns a throttle that applies policy.
+func newThrottle(policy ThrottlePolicy) *throttle {
+	return &throttle{
+		policy: policy,
+		now:    time.Now,
+		keys:   make(map[ID]*attempts),
+	}
+}
+
+// check returns an *ErrThrottled if an attempt to decrypt the key with the
+// specified ID is not yet permitted.  A nil throttle permits all attempts.
+func (t *throttle) check(id ID) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := t.keys[id]
+	if a == nil {
+		return nil
+	}
+	if wait := a.next.Sub(t.now()); wait > 0 {
+		return &ErrThrottled{ID: id, RetryAfter: wait, LockedOut: a.lockedOut}
+	}
+	if a.lockedOut {
+		// The lockout has expired; start afresh.
+		delete(t.keys, id)
+	}
+	return nil
+}
+
+// failed records an incorrect passphrase for the key with the specified ID.
+// It returns the number of consecutive incorrect passphrases, and whether
+// the key is now locked out.
+func (t *throttle) failed(id ID) (failures int, lockedOut bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := t.keys[id]
+	if a == nil {
+		a = &attempts{}
+		t.keys[id] = a
+	}
+	a.failures++
+	if t.policy.LockoutAfter > 0 && a.failures >= t.policy.LockoutAfter {
+		a.next = t.now().Add(t.policy.Lockout)
+		a.lockedOut = true
+		return a.failures, true
+	}
+	backoff := t.policy.Backoff
+	for i := 1; i < a.failures && backoff < t.policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if t.policy.MaxBackoff > 0 && backoff > t.policy.MaxBackoff {
+		backoff = t.policy.MaxBackoff
+	}
+	a.next = t.now().Add(backoff)
+	return a.failures, false
+}
+
+// succeeded forgets the incorrect passphrases entered for the key with the
+// specified ID.
+func (t *throttle) succeeded(id ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.keys, id)
+}
+
+// passphraseResult records the outcome of an attempt to decrypt the key with
+// the specified ID for action (e.g., 'load'); incorrect is true if the
+// passphrase was incorrect.
+func (m *manager) passphraseResult(id ID, action string, incorrect bool) {
+	if m.throttle == nil {
+		return
+	}
+	if !incorrect {
+		m.throttle.succeeded(id)
+		return
+	}
+
+	failures, lockedOut := m.throttle.failed(id)
+	if m.audit == nil {
+		return
+	}
+	m.audit.Record(audit.NewEntry(action, "passphrase", string(id), false, fmt.Sprintf("incorrect passphrase (%d consecutive)", failures)), nil)
+	if lockedOut {
+		m.audit.Record(audit.NewEntry(action, "lockout", string(id), false, fmt.Sprintf("locked for %v after %d incorrect passphrases", m.throttle.policy.Lockout, failures)), nil)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// fakeClock is a clock that only advances when told to.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func TestThrottle(t *testing.T) {
+	policy := ThrottlePolicy{
+		Backoff:      time.Second,
+		MaxBackoff:   4 * time.Second,
+		LockoutAfter: 5,
+		Lockout:      time.Minute,
+	}
+
+	// Each step either records a failure or success, or advances the
+	// clock, and then checks whether an attempt is permitted.
+	type step struct {
+		fail      bool
+		succeed   bool
+		advance   time.Duration
+		wantErr   *ErrThrottled
+		wantCount int
+	}
+	id := ID("1")
+	steps := []step{
+		{wantErr: nil},
+		{fail: true, wantErr: &ErrThrottled{ID: id, RetryAfter: time.Second}},
+		{advance: time.Second, wantErr: nil},
+		{fail: true, wantErr: &ErrThrottled{ID: id, RetryAfter: 2 * time.Second}},
+		{advance: time.Second, wantErr: &ErrThrottled{ID: id, RetryAfter: time.Second}},
+		{advance: time.Second, wantErr: nil},
+		{fail: true, wantErr: &ErrThrottled{ID: id, RetryAfter: 4 * time.Second}},
+		{advance: 4 * time.Second},
+		// The backoff is capped at MaxBackoff.
+		{fail: true, wantErr: &ErrThrottled{ID: id, RetryAfter: 4 * time.Second}},
+		{advance: 4 * time.Second},
+		{fail: true, wantErr: &ErrThrottled{ID: id, RetryAfter: time.Minute, LockedOut: true}},
+		{advance: 59 * time.Second, wantErr: &ErrThrottled{ID: id, RetryAfter: time.Second, LockedOut: true}},
+		{advance: time.Second, wantErr: nil},
+		// The count starts afresh once the lockout has expired.
+		{fail: true, wantErr: &ErrThrottled{ID: id, RetryAfter: time.Second}},
+		{succeed: true, wantErr: nil},
+		{fail: true, wantErr: &ErrThrottled{ID: id, RetryAfter: time.Second}},
+	}
+
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	th := newThrottle(policy)
+	th.now = clock.now
+	for i, s := range steps {
+		if s.fail {
+			th.failed(id)
+		}
+		if s.succeed {
+			th.succeeded(id)
+		}
+		clock.advance(s.advance)
+
+		var got *ErrThrottled
+		if err := th.check(id); err != nil {
+			got = err.(*ErrThrottled)
+		}
+		if diff := pretty.Diff(got, s.wantErr); diff != nil {
+			t.Errorf("step %d: incorrect result; -got +want: %s", i, diff)
+		}
+		if err := th.check(ID("other")); err != nil {
+			t.Errorf("step %d: other key throttled: %v", i, err)
+		}
+	}
+}
+
+func TestThrottleDisabled(t *testing.T) {
+	var th *throttle
+	if err := th.check(ID("1")); err != nil {
+		t.Errorf("nil throttle refused attempt: %v", err)
+	}
+}
+
+func TestErrThrottled(t *testing.T) {
+	testcases := []struct {
+		err  *ErrThrottled
+		want string
+	}{
+		{
+			err:  &ErrThrottled{RetryAfter: 1500 * time.Millisecond},
+			want: "incorrect passphrase entered recently; try again in 2s",
+		},
+		{
+			err:  &ErrThrottled{RetryAfter: 15 * time.Minute, LockedOut: true},
+			want: "key is locked after too many incorrect passphrases; try again in 15m0s",
+		},
+	}
+
+	for _, tc := range testcases {
+		if diff := pretty.Diff(tc.err.Error(), tc.want); diff != nil {
+			t.Errorf("incorrect message; -got +want: %s", diff)
+		}
+		if diff := pretty.Diff(help.CodeOf(tc.err), help.TooManyAttempts); diff != nil {
+			t.Errorf("incorrect help code; -got +want: %s", diff)
+		}
+	}
+}
+
+func TestLoadThrottled(t *testing.T) {
+	auditLog := audit.NewLog(fakes.NewMemStorage(), 20)
+	mgr := NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), WithAuditLog(auditLog), WithThrottlePolicy(ThrottlePolicy{
+		Backoff:      time.Second,
+		MaxBackoff:   time.Minute,
+		LockoutAfter: 2,
+		Lockout:      time.Hour,
+	}))
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	mgr.(*manager).throttle.now = clock.now
+
+	if err := syncAdd(mgr, "good-key", testdata.ValidPrivateKey, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	id, err := findKey(mgr, InvalidID, "good-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+
+	// An incorrect passphrase delays the next attempt, even with the
+	// correct passphrase.
+	err = syncLoad(mgr, id, "incorrect")
+	if diff := pretty.Diff(help.CodeOf(err), help.IncorrectPassphrase); diff != nil {
+		t.Errorf("incorrect error from first load %v; -got +want: %s", err, diff)
+	}
+	err = syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase)
+	if diff := pretty.Diff(help.CodeOf(err), help.TooManyAttempts); diff != nil {
+		t.Errorf("incorrect error from load during backoff %v; -got +want: %s", err, diff)
+	}
+
+	// Export is throttled too, and a second incorrect passphrase locks
+	// the key.
+	clock.advance(time.Second)
+	_, err = syncExport(mgr, id, "incorrect", keyformat.PrivateOpenSSH, "export-passphrase")
+	if diff := pretty.Diff(help.CodeOf(err), help.IncorrectPassphrase); diff != nil {
+		t.Errorf("incorrect error from export %v; -got +want: %s", err, diff)
+	}
+	clock.advance(time.Minute)
+	err = syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase)
+	if te, ok := err.(*ErrThrottled); !ok || !te.LockedOut {
+		t.Errorf("incorrect error from load during lockout; got %v, want lockout", err)
+	}
+
+	// The key may be loaded once the lockout expires.
+	clock.advance(time.Hour)
+	if err := syncLoad(mgr, id, testdata.ValidPrivateKeyPassphrase); err != nil {
+		t.Errorf("failed to load key after lockout: %v", err)
+	}
+
+	auditLog.Entries(func(entries []*audit.Entry, err error) {
+		if err != nil {
+			t.Errorf("failed to read audit log: %v", err)
+		}
+		var got []string
+		for _, e := range entries {
+			if e.Requester == "passphrase" || e.Requester == "lockout" {
+				got = append(got, e.Action+": "+e.Detail)
+			}
+		}
+		want := []string{
+			"load: incorrect passphrase (1 consecutive)",
+			"export: incorrect passphrase (2 consecutive)",
+			"export: locked for 1h0m0s after 2 incorrect passphrases",
+		}
+		if diff := pretty.Diff(got, want); diff != nil {
+			t.Errorf("incorrect audit entries; -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,246 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/agenthooks"
+	"github.com/google/chrome-ssh-agent/go/totp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// DefaultTOTPTimeout is how long a signature waits for the user to
+	// enter a confirmation code before it is refused.
+	DefaultTOTPTimeout = time.Minute
+	// MaxTOTPAttempts is the number of incorrect codes that may be
+	// entered for a signature before it is refused.
+	MaxTOTPAttempts = 3
+)
+
+var (
+	// ErrTOTPTimeout is returned when no confirmation code was entered
+	// before the signature request timed out.
+	ErrTOTPTimeout = errors.New("signature refused: no confirmation code was entered")
+	// ErrTOTPIncorrect is returned when too many incorrect confirmation
+	// codes were entered for a signature request.
+	ErrTOTPIncorrect = errors.New("signature refused: incorrect confirmation code")
+	// ErrTOTPNotPending is returned when a confirmation code is entered
+	// for a signature request that is no longer waiting for one.
+	ErrTOTPNotPending = errors.New("signature request is no longer waiting for a confirmation code")
+)
+
+// TOTPChallenge is a signature request waiting for the user to enter a
+// confirmation code.
+type TOTPChallenge struct {
+	// Challenge identifies the request; codes are entered using it.
+	Challenge int `codec:"challenge"`
+	// ID is the ID of the key with which the signature was requested.
+	ID ID `codec:"id"`
+	// Name is the name of the key.
+	Name string `codec:"name"`
+	// Expires is the time at which the request is refused if no code has
+	// been entered, in milliseconds since the Unix epoch.
+	Expires int64 `codec:"expires"`
+}
+
+// totpKey is a loaded key for which signatures must be confirmed.
+type totpKey struct {
+	name   string
+	secret string
+}
+
+// pendingTOTP is a signature request waiting for a confirmation code.
+type pendingTOTP struct {
+	challenge *TOTPChallenge
+	secret    string
+	failures  int
+	// done receives the outcome of the request.  It is buffered so that
+	// the outcome can be delivered without waiting for the request.
+	done chan error
+}
+
+// TOTPGuard requires that signatures using selected keys be confirmed with a
+// time-based one-time code (RFC 6238) from the user's authenticator app.  A
+// signature request is held until the user enters a code, and refused if
+// they do not do so in time.
+type TOTPGuard struct {
+	mu sync.Mutex
+	// keys contains the keys requiring confirmation, keyed by ID.
+	keys map[ID]*totpKey
+	// pending contains the requests waiting for a code, keyed by
+	// challenge.
+	pending map[int]*pendingTOTP
+	// next is the challenge assigned to the next request.
+	next int
+	// timeout is how long a request waits for a code.
+	timeout time.Duration
+	// challenged is invoked each time a request starts waiting.
+	challenged func(c *TOTPChallenge)
+	// now returns the current time.  It may be replaced in tests.
+	now func() time.Time
+}
+
+// NewTOTPGuard returns a TOTPGuard for which no keys require confirmation.
+// A signature request waits up to timeout for a code; challenged is invoked
+// each time one starts waiting, so the user can be asked for a code.
+func NewTOTPGuard(timeout time.Duration, challenged func(c *TOTPChallenge)) *TOTPGuard {
+	return &TOTPGuard{
+		keys:       make(map[ID]*totpKey),
+		pending:    make(map[int]*pendingTOTP),
+		next:       1,
+		timeout:    timeout,
+		challenged: challenged,
+		now:        time.Now,
+	}
+}
+
+// set records the secret for the key with the specified ID and name.  An
+// empty secret indicates that signatures need not be confirmed.
+func (g *TOTPGuard) set(id ID, name, secret string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if secret != "" {
+		g.keys[id] = &totpKey{name: name, secret: secret}
+	} else {
+		delete(g.keys, id)
+	}
+}
+
+// CheckSign determines if the specified loaded key may be used to sign.  If
+// the key requires confirmation, it waits until the user enters a correct
+// code (see Respond), or the request times out.
+func (g *TOTPGuard) CheckSign(key *agent.Key) error {
+	id := (&LoadedKey{Comment: key.Comment}).ID()
+	if id == InvalidID {
+		return nil
+	}
+
+	g.mu.Lock()
+	k, ok := g.keys[id]
+	if !ok {
+		g.mu.Unlock()
+		return nil
+	}
+	p := &pendingTOTP{
+		challenge: &TOTPChallenge{
+			Challenge: g.next,
+			ID:        id,
+			Name:      k.name,
+			Expires:   g.now().Add(g.timeout).UnixNano() / int64(time.Millisecond),
+		},
+		secret: k.secret,
+		done:   make(chan error, 1),
+	}
+	g.next++
+	g.pending[p.challenge.Challenge] = p
+	g.mu.Unlock()
+
+	if g.challenged != nil {
+		c := *p.challenge
+		g.challenged(&c)
+	}
+
+	var err error
+	select {
+	case err = <-p.done:
+	case <-time.After(g.timeout):
+		g.mu.Lock()
+		delete(g.pending, p.challenge.Challenge)
+		g.mu.Unlock()
+		// A code may have been accepted just before the request was
+		// removed.
+		select {
+		case err = <-p.done:
+		default:
+			err = ErrTOTPTimeout
+		}
+	}
+	if err != nil {
+		log.Printf("TOTP: refused signature using key %q (%s): %v", k.name, id, err)
+	}
+	return err
+}
+
+// Pending returns the signature requests waiting for a confirmation code,
+// oldest first.
+func (g *TOTPGuard) Pending() []*TOTPChallenge {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var result []*TOTPChallenge
+	for _, p := range g.pending {
+		c := *p.challenge
+		result = append(result, &c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Challenge < result[j].Challenge
+	})
+	return result
+}
+
+// Respond supplies the confirmation code entered by the user for a signature
+// request.  If the code is correct, the signature proceeds.  If it is not,
+// an error is returned and the user may try again; after MaxTOTPAttempts
+// incorrect codes the signature is refused.
+func (g *TOTPGuard) Respond(challenge int, code string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.pending[challenge]
+	if !ok {
+		return ErrTOTPNotPending
+	}
+	if err := totp.Validate(p.secret, code, g.now()); err != nil {
+		if err == totp.ErrInvalidCode {
+			return err
+		}
+		p.failures++
+		if p.failures >= MaxTOTPAttempts {
+			delete(g.pending, challenge)
+			p.done <- ErrTOTPIncorrect
+			return ErrTOTPIncorrect
+		}
+		return err
+	}
+	delete(g.pending, challenge)
+	p.done <- nil
+	return nil
+}
+
+// Hooks returns hooks that hold signatures until they are confirmed when
+// installed in an agenthooks.Agent.
+func (g *TOTPGuard) Hooks() *agenthooks.Hooks {
+	return &agenthooks.Hooks{
+		BeforeSign: func(key *agent.Key, data []byte) error {
+			return g.CheckSign(key)
+		},
+	}
+}
+
+// WithTOTPGuard specifies a guard that is told which keys require
+// confirmation codes as they are loaded.  By default, signatures are not
+// confirmed, even for keys configured with a secret.
+func WithTOTPGuard(guard *TOTPGuard) ManagerOption {
+	return func(m *manager) {
+		m.totp = guard
+	}
+}
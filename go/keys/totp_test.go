@@ -0,0 +1,161 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/agenthooks"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/totp"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// totpSecret is the secret shared with the user's authenticator app.
+	totpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+)
+
+func TestTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	testcases := []struct {
+		description    string
+		secret         string
+		codes          []string
+		timeout        time.Duration
+		wantAddErr     bool
+		wantChallenged []string
+		wantRespondErr []error
+		wantErr        error
+	}{
+		{
+			description: "key without secret signs",
+		},
+		{
+			description:    "correct code",
+			secret:         totpSecret,
+			codes:          []string{"050471"},
+			wantChallenged: []string{"some-key"},
+			wantRespondErr: []error{nil},
+		},
+		{
+			description:    "correct code after incorrect code",
+			secret:         "gezd gnbv gy3t qojq gezd gnbv gy3t qojq",
+			codes:          []string{"123456", "050471"},
+			wantChallenged: []string{"some-key"},
+			wantRespondErr: []error{totp.ErrIncorrectCode, nil},
+		},
+		{
+			description:    "malformed code is not counted",
+			secret:         totpSecret,
+			codes:          []string{"12", "123456", "123456", "050471"},
+			wantChallenged: []string{"some-key"},
+			wantRespondErr: []error{totp.ErrInvalidCode, totp.ErrIncorrectCode, totp.ErrIncorrectCode, nil},
+		},
+		{
+			description:    "too many incorrect codes",
+			secret:         totpSecret,
+			codes:          []string{"123456", "123456", "123456", "050471"},
+			wantChallenged: []string{"some-key"},
+			wantRespondErr: []error{totp.ErrIncorrectCode, totp.ErrIncorrectCode, ErrTOTPIncorrect, ErrTOTPNotPending},
+			wantErr:        ErrTOTPIncorrect,
+		},
+		{
+			description:    "no code entered",
+			secret:         totpSecret,
+			timeout:        10 * time.Millisecond,
+			wantChallenged: []string{"some-key"},
+			wantErr:        ErrTOTPTimeout,
+		},
+		{
+			description: "invalid secret",
+			secret:      "not a secret!",
+			wantAddErr:  true,
+		},
+	}
+
+	for _, tc := range testcases {
+		timeout := tc.timeout
+		if timeout == 0 {
+			timeout = DefaultTOTPTimeout
+		}
+		var guard *TOTPGuard
+		var challenged []string
+		var respondErr []error
+		guard = NewTOTPGuard(timeout, func(c *TOTPChallenge) {
+			challenged = append(challenged, c.Name)
+			if diff := pretty.Diff(guard.Pending(), []*TOTPChallenge{c}); diff != nil {
+				t.Errorf("%s: incorrect pending challenges; -got +want: %s", tc.description, diff)
+			}
+			// Enter the codes as the user would while the
+			// signature waits.
+			for _, code := range tc.codes {
+				respondErr = append(respondErr, guard.Respond(c.Challenge, code))
+			}
+		})
+		guard.now = func() time.Time { return now }
+		agt := agenthooks.New(keyring.New())
+		agt.Install(guard.Hooks())
+		mgr := NewManager(agt, fakes.NewMemStorage(), fakes.NewMemStorage(), WithTOTPGuard(guard))
+		err := syncAdd(mgr, "some-key", testdata.ValidPrivateKeyWithoutPassphrase, &AddOptions{TOTPSecret: tc.secret})
+		if gotErr := err != nil; gotErr != tc.wantAddErr {
+			t.Errorf("%s: incorrect add error; got %v, want error %t", tc.description, err, tc.wantAddErr)
+		}
+		if err != nil {
+			continue
+		}
+		id, err := findKey(mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncLoad(mgr, id, ""); err != nil {
+			t.Fatalf("%s: failed to load key: %v", tc.description, err)
+		}
+
+		configured, err := syncConfigured(mgr)
+		if err != nil {
+			t.Fatalf("%s: failed to get configured keys: %v", tc.description, err)
+		}
+		if got, want := configured[0].TOTP, tc.secret != ""; got != want {
+			t.Errorf("%s: incorrect TOTP; got %t, want %t", tc.description, got, want)
+		}
+
+		loaded, err := agt.List()
+		if err != nil || len(loaded) != 1 {
+			t.Fatalf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		pub, err := ssh.ParsePublicKey(loaded[0].Blob)
+		if err != nil {
+			t.Fatalf("%s: failed to parse public key: %v", tc.description, err)
+		}
+		_, err = agt.Sign(pub, []byte("some-data"))
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(challenged, tc.wantChallenged); diff != nil {
+			t.Errorf("%s: incorrect challenged keys; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(respondErr, tc.wantRespondErr); diff != nil {
+			t.Errorf("%s: incorrect respond errors; -got +want: %s", tc.description, diff)
+		}
+		if got := guard.Pending(); len(got) != 0 {
+			t.Errorf("%s: challenges still pending after signature: %v", tc.description, got)
+		}
+	}
+}
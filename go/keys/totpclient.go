@@ -0,0 +1,169 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/codec"
+	"github.com/google/chrome-ssh-agent/go/totp"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// Define a distinct type for each message used to enter confirmation codes.
+// These are distinct from those used by the Server.
+const (
+	msgTypeTOTPPending int = 5000 + iota
+	msgTypeTOTPPendingRsp
+	msgTypeTOTPRespond
+	msgTypeTOTPRespondRsp
+)
+
+type msgTOTPPending struct {
+	*msgHeader
+}
+
+type rspTOTPPending struct {
+	*msgHeader
+	Challenges interface{} `js:"challenges"`
+}
+
+type msgTOTPRespond struct {
+	*msgHeader
+	Challenge int    `js:"challenge"`
+	Code      string `js:"code"`
+}
+
+type rspTOTPRespond struct {
+	*msgHeader
+	Err string `js:"err"`
+}
+
+// totpErrs are the errors returned by TOTPGuard.Respond that are recreated by
+// the client, so that callers can tell them apart.
+var totpErrs = []error{
+	ErrTOTPIncorrect,
+	ErrTOTPNotPending,
+	totp.ErrIncorrectCode,
+	totp.ErrInvalidCode,
+}
+
+// makeTOTPErr converts an error string returned by ServeTOTP to an error.
+func makeTOTPErr(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, err := range totpErrs {
+		if s == err.Error() {
+			return err
+		}
+	}
+	return errors.New(s)
+}
+
+// ServeTOTP allows other extension pages to list the signature requests
+// waiting for a confirmation code, and enter codes for them, using
+// TOTPClient.
+func ServeTOTP(guard *TOTPGuard, msg MessageReceiver) {
+	msg.OnMessage(func(headerObj *js.Object, sender *js.Object, sendResponse func(interface{})) bool {
+		if !fromExtension(sender, msg.ExtensionURL()) {
+			return false
+		}
+		header := &msgHeader{Object: headerObj}
+		switch header.Type {
+		case msgTypeTOTPPending:
+			rsp := &rspTOTPPending{msgHeader: header}
+			rsp.Type = msgTypeTOTPPendingRsp
+			rsp.Challenges = mustEncode(guard.Pending())
+			sendResponse(rsp)
+		case msgTypeTOTPRespond:
+			m := &msgTOTPRespond{msgHeader: header}
+			rsp := &rspTOTPRespond{msgHeader: header}
+			rsp.Type = msgTypeTOTPRespondRsp
+			rsp.Err = makeErrStr(guard.Respond(m.Challenge, m.Code))
+			sendResponse(rsp)
+		}
+		return false
+	})
+}
+
+// TOTPClient lists signature requests waiting for a confirmation code, and
+// enters codes for them, using the TOTPGuard served by ServeTOTP (typically
+// in the background page).
+type TOTPClient struct {
+	msg MessageSender
+}
+
+// NewTOTPClient returns a TOTPClient that sends requests using the supplied
+// messaging API.
+func NewTOTPClient(msg MessageSender) *TOTPClient {
+	return &TOTPClient{msg: msg}
+}
+
+// sendMessage sends msg using sender, and invokes callback with the
+// response.  If no response is received within defaultClientTimeout, callback
+// is invoked with ErrTimeout.
+func sendMessage(sender MessageSender, msg interface{}, callback func(rsp *js.Object, err error)) {
+	withTimeout(defaultClientTimeout, func(done func(v interface{}, err error)) {
+		sender.SendMessage(msg, func(rsp *js.Object) {
+			if err := sender.Error(); err != nil {
+				done(nil, fmt.Errorf("failed to send message: %v", err))
+				return
+			}
+			done(rsp, nil)
+		})
+	}, func(v interface{}, err error) {
+		rsp, _ := v.(*js.Object)
+		callback(rsp, err)
+	})
+}
+
+// Pending returns the signature requests waiting for a confirmation code;
+// see TOTPGuard.Pending.
+func (c *TOTPClient) Pending(callback func(challenges []*TOTPChallenge, err error)) {
+	msg := &msgTOTPPending{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeTOTPPending
+	sendMessage(c.msg, msg, func(rspObj *js.Object, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		rsp := &rspTOTPPending{msgHeader: &msgHeader{Object: rspObj}}
+		var challenges []*TOTPChallenge
+		if err := codec.Decode(rsp.Challenges, &challenges); err != nil {
+			callback(nil, fmt.Errorf("failed to decode challenges: %v", err))
+			return
+		}
+		callback(challenges, nil)
+	})
+}
+
+// Respond enters a confirmation code for a signature request; see
+// TOTPGuard.Respond.
+func (c *TOTPClient) Respond(challenge int, code string, callback func(err error)) {
+	msg := &msgTOTPRespond{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeTOTPRespond
+	msg.Challenge = challenge
+	msg.Code = code
+	sendMessage(c.msg, msg, func(rspObj *js.Object, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		rsp := &rspTOTPRespond{msgHeader: &msgHeader{Object: rspObj}}
+		callback(makeTOTPErr(rsp.Err))
+	})
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"golang.org/x/crypto/ssh"
+)
+
+// md5FingerprintPrefix introduces a fingerprint in the legacy MD5 format
+// (e.g., as printed by 'ssh-add -l -E md5').
+const md5FingerprintPrefix = "MD5:"
+
+// normalizeFingerprint returns fp in the form used to compare it with the
+// fingerprint of a loaded key.  Fingerprints without a recognized prefix
+// are assumed to be SHA256 fingerprints.
+func normalizeFingerprint(fp string) string {
+	fp = strings.TrimSpace(fp)
+	switch {
+	case strings.HasPrefix(strings.ToUpper(fp), md5FingerprintPrefix):
+		return md5FingerprintPrefix + strings.ToLower(fp[len(md5FingerprintPrefix):])
+	case strings.HasPrefix(strings.ToUpper(fp), strings.ToUpper(fingerprintPrefix)):
+		return fingerprintPrefix + fp[len(fingerprintPrefix):]
+	default:
+		return fingerprintPrefix + fp
+	}
+}
+
+// matchesFingerprint determines if the loaded key has the fingerprint fp,
+// which must be normalized using normalizeFingerprint.
+func matchesFingerprint(key *LoadedKey, fp string) bool {
+	pub, err := ssh.ParsePublicKey(key.Blob)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(fp, md5FingerprintPrefix) {
+		return fp == md5FingerprintPrefix+ssh.FingerprintLegacyMD5(pub)
+	}
+	return fp == ssh.FingerprintSHA256(pub)
+}
+
+// UnloadByFingerprint implements Manager.UnloadByFingerprint.
+func (m *manager) UnloadByFingerprint(fp string, callback func(err error)) {
+	want := normalizeFingerprint(fp)
+	m.Loaded(func(loaded []*LoadedKey, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+
+		for _, l := range loaded {
+			if matchesFingerprint(l, want) {
+				m.Unload(l, callback)
+				return
+			}
+		}
+		callback(help.Errorf(help.KeyNotFound, "failed to find loaded key with fingerprint %s", strings.TrimSpace(fp)))
+	})
+}
+
+// UnloadByName implements Manager.UnloadByName.
+func (m *manager) UnloadByName(name string, callback func(err error)) {
+	want := normalizeName(name)
+	m.readKeys(func(keys []*storedKey, err error) {
+		if err != nil {
+			callback(help.Errorf(help.StorageFailure, "failed to read keys: %v", err))
+			return
+		}
+		names := make(map[ID]string)
+		for _, k := range keys {
+			names[k.ID] = normalizeName(k.Name)
+		}
+
+		m.Loaded(func(loaded []*LoadedKey, err error) {
+			if err != nil {
+				callback(err)
+				return
+			}
+
+			// A key may be named by the name of its configured key,
+			// or by its nickname; the latter distinguishes keys that
+			// share a name.
+			var matches []*LoadedKey
+			for _, l := range loaded {
+				if n, ok := names[l.ID()]; (ok && n == want) || normalizeName(l.Nickname()) == want {
+					matches = append(matches, l)
+				}
+			}
+
+			switch len(matches) {
+			case 0:
+				callback(help.Errorf(help.KeyNotFound, "failed to find loaded key named %q", strings.TrimSpace(name)))
+			case 1:
+				m.Unload(matches[0], callback)
+			default:
+				callback(fmt.Errorf("%d loaded keys are named %q; unload by fingerprint instead", len(matches), strings.TrimSpace(name)))
+			}
+		})
+	})
+}
@@ -0,0 +1,304 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func mustParseBlob(blob string) ssh.PublicKey {
+	b, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		panic(fmt.Sprintf("failed to decode blob: %v", err))
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse public key: %v", err))
+	}
+	return pub
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	testcases := []struct {
+		fp   string
+		want string
+	}{
+		{
+			fp:   "SHA256:KKE7aAXbGqQMhd2F+5zxUTL3ITL6hHZ8JTrm2J97Arc",
+			want: "SHA256:KKE7aAXbGqQMhd2F+5zxUTL3ITL6hHZ8JTrm2J97Arc",
+		},
+		{
+			fp:   "  sha256:KKE7aAXbGqQMhd2F+5zxUTL3ITL6hHZ8JTrm2J97Arc\n",
+			want: "SHA256:KKE7aAXbGqQMhd2F+5zxUTL3ITL6hHZ8JTrm2J97Arc",
+		},
+		{
+			fp:   "KKE7aAXbGqQMhd2F+5zxUTL3ITL6hHZ8JTrm2J97Arc",
+			want: "SHA256:KKE7aAXbGqQMhd2F+5zxUTL3ITL6hHZ8JTrm2J97Arc",
+		},
+		{
+			fp:   "md5:0A:1B:2C",
+			want: "MD5:0a:1b:2c",
+		},
+	}
+
+	for _, tc := range testcases {
+		if diff := pretty.Diff(normalizeFingerprint(tc.fp), tc.want); diff != nil {
+			t.Errorf("%q: incorrect result; -got +want: %s", tc.fp, diff)
+		}
+	}
+}
+
+func TestUnloadByFingerprint(t *testing.T) {
+	pub := mustParseBlob(testdata.ValidPrivateKeyBlob)
+
+	testcases := []struct {
+		description string
+		fp          string
+		wantLoaded  []string
+		wantCode    help.Code
+		wantErr     bool
+	}{
+		{
+			description: "SHA256 fingerprint",
+			fp:          ssh.FingerprintSHA256(pub),
+			wantLoaded: []string{
+				testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			},
+		},
+		{
+			description: "fingerprint without prefix",
+			fp:          strings.TrimPrefix(ssh.FingerprintSHA256(pub), "SHA256:"),
+			wantLoaded: []string{
+				testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			},
+		},
+		{
+			description: "MD5 fingerprint",
+			fp:          "MD5:" + strings.ToUpper(ssh.FingerprintLegacyMD5(pub)),
+			wantLoaded: []string{
+				testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			},
+		},
+		{
+			description: "fail on unknown fingerprint",
+			fp:          "SHA256:AAAA",
+			wantLoaded: []string{
+				testdata.ValidPrivateKeyBlob,
+				testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			},
+			wantCode: help.KeyNotFound,
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), []*initialKey{
+			{
+				Name:          "good-key",
+				PEMPrivateKey: testdata.ValidPrivateKey,
+				Load:          true,
+				Passphrase:    testdata.ValidPrivateKeyPassphrase,
+			},
+			{
+				Name:          "other-key",
+				PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+				Load:          true,
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to initialize manager: %v", tc.description, err)
+		}
+
+		err = syncUnloadByFingerprint(mgr, tc.fp)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %v", tc.description, err, tc.wantErr)
+		}
+		if diff := pretty.Diff(help.CodeOf(err), tc.wantCode); diff != nil {
+			t.Errorf("%s: incorrect help code; -got +want: %s", tc.description, diff)
+		}
+
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Errorf("%s: failed to get loaded keys: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(loadedKeyBlobs(loaded), tc.wantLoaded); diff != nil {
+			t.Errorf("%s: incorrect loaded keys; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestUnloadByName(t *testing.T) {
+	pub := mustParseBlob(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+	nickname := fmt.Sprintf("work (%s)", fingerprintFragment(ssh.FingerprintSHA256(pub)))
+
+	testcases := []struct {
+		description string
+		initial     []*initialKey
+		loadAll     bool
+		name        string
+		wantLoaded  []string
+		wantCode    help.Code
+		wantErr     bool
+	}{
+		{
+			description: "unload by name",
+			initial: []*initialKey{
+				{
+					Name:          "good-key",
+					PEMPrivateKey: testdata.ValidPrivateKey,
+					Load:          true,
+					Passphrase:    testdata.ValidPrivateKeyPassphrase,
+				},
+				{
+					Name:          "other-key",
+					PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+					Load:          true,
+				},
+			},
+			name: "good-key",
+			wantLoaded: []string{
+				testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			},
+		},
+		{
+			description: "name differs in case and whitespace",
+			initial: []*initialKey{
+				{
+					Name:          "good-key",
+					PEMPrivateKey: testdata.ValidPrivateKey,
+					Load:          true,
+					Passphrase:    testdata.ValidPrivateKeyPassphrase,
+				},
+			},
+			name:       " Good-Key ",
+			wantLoaded: []string{},
+		},
+		{
+			description: "fail on key that is not loaded",
+			initial: []*initialKey{
+				{
+					Name:          "good-key",
+					PEMPrivateKey: testdata.ValidPrivateKey,
+					Load:          true,
+					Passphrase:    testdata.ValidPrivateKeyPassphrase,
+				},
+				{
+					Name:          "other-key",
+					PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+				},
+			},
+			name: "other-key",
+			wantLoaded: []string{
+				testdata.ValidPrivateKeyBlob,
+			},
+			wantCode: help.KeyNotFound,
+			wantErr:  true,
+		},
+		{
+			description: "fail on name shared by loaded keys",
+			initial: []*initialKey{
+				{
+					Name:          "work",
+					PEMPrivateKey: testdata.ValidPrivateKey,
+				},
+				{
+					Name:          "work",
+					PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+				},
+			},
+			loadAll: true,
+			name:    "work",
+			wantLoaded: []string{
+				testdata.ValidPrivateKeyBlob,
+				testdata.ValidPrivateKeyWithoutPassphraseBlob,
+			},
+			wantErr: true,
+		},
+		{
+			description: "unload by nickname",
+			initial: []*initialKey{
+				{
+					Name:          "work",
+					PEMPrivateKey: testdata.ValidPrivateKey,
+				},
+				{
+					Name:          "work",
+					PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+				},
+			},
+			loadAll: true,
+			name:    nickname,
+			wantLoaded: []string{
+				testdata.ValidPrivateKeyBlob,
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr, err := newTestManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage(), tc.initial)
+		if err != nil {
+			t.Fatalf("%s: failed to initialize manager: %v", tc.description, err)
+		}
+
+		// Keys that share a name can't be loaded by newTestManager, so
+		// load them here.
+		if tc.loadAll {
+			configured, err := syncConfigured(mgr)
+			if err != nil {
+				t.Fatalf("%s: failed to get configured keys: %v", tc.description, err)
+			}
+			for _, k := range configured {
+				passphrase := ""
+				if k.Encrypted {
+					passphrase = testdata.ValidPrivateKeyPassphrase
+				}
+				if err := syncLoad(mgr, k.ID, passphrase); err != nil {
+					t.Fatalf("%s: failed to load key: %v", tc.description, err)
+				}
+			}
+		}
+
+		err = syncUnloadByName(mgr, tc.name)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %v", tc.description, err, tc.wantErr)
+		}
+		if diff := pretty.Diff(help.CodeOf(err), tc.wantCode); diff != nil {
+			t.Errorf("%s: incorrect help code; -got +want: %s", tc.description, diff)
+		}
+
+		loaded, err := syncLoaded(mgr)
+		if err != nil {
+			t.Errorf("%s: failed to get loaded keys: %v", tc.description, err)
+		}
+		// Keys are loaded in no particular order.
+		blobs := loadedKeyBlobs(loaded)
+		sort.Strings(blobs)
+		sort.Strings(tc.wantLoaded)
+		if diff := pretty.Diff(blobs, tc.wantLoaded); diff != nil {
+			t.Errorf("%s: incorrect loaded keys; -got +want: %s", tc.description, diff)
+		}
+	}
+}
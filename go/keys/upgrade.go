@@ -0,0 +1,225 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/chrome-ssh-agent/go/codec"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// UpgradeReportKey is the key under which the outcome of the most
+	// recent upgrade that changed anything is stored.  It is stored on
+	// each device, since loaded keys are upgraded on each device.
+	UpgradeReportKey = "upgrade.report"
+)
+
+// Relabeler changes the comment of a key loaded into the agent.  It is
+// implemented by keyring.Keyring.
+type Relabeler interface {
+	// Relabel replaces the comment of the loaded key.
+	Relabel(key ssh.PublicKey, comment string) error
+}
+
+// WithRelabeler specifies how Upgrade changes the comments of keys loaded
+// by older versions.  By default, loaded keys are not upgraded.
+func WithRelabeler(relabeler Relabeler) ManagerOption {
+	return func(m *manager) {
+		m.relabeler = relabeler
+	}
+}
+
+// UpgradeResult describes the keys upgraded from formats used by older
+// versions of the extension.
+type UpgradeResult struct {
+	// Time is the time at which the upgrade completed, in milliseconds
+	// since the Unix epoch.
+	Time int64 `codec:"time"`
+	// Keys are the IDs of configured keys in which metadata was recorded
+	// (see ConfiguredKey.KeyType).
+	Keys []ID `codec:"keys,omitempty"`
+	// Loaded are the IDs of loaded keys whose comment now includes the
+	// key's nickname (see LoadedKey.Nickname).
+	Loaded []ID `codec:"loaded,omitempty"`
+}
+
+// Empty indicates that nothing was upgraded.
+func (r *UpgradeResult) Empty() bool {
+	return len(r.Keys) == 0 && len(r.Loaded) == 0
+}
+
+// loadedComment returns the comment with which a configured key is loaded
+// into the agent.  See commentPrefix for the format.
+func loadedComment(id ID, nickname string) string {
+	return fmt.Sprintf("%s%s %s", commentPrefix, id, nickname)
+}
+
+// Upgrade implements Manager.Upgrade.
+func (m *manager) Upgrade(callback func(result *UpgradeResult, err error)) {
+	m.writes.run(func(done func()) {
+		callback := func(result *UpgradeResult, err error) {
+			callback(result, err)
+			done()
+		}
+
+		m.readKeys(func(keys []*storedKey, err error) {
+			if err != nil {
+				callback(nil, help.Errorf(help.StorageFailure, "failed to read keys: %v", err))
+				return
+			}
+
+			result := &UpgradeResult{}
+			m.upgradeStored(keys, result, func(err error) {
+				if err != nil {
+					callback(nil, err)
+					return
+				}
+				if err := m.upgradeLoaded(keys, result); err != nil {
+					callback(nil, err)
+					return
+				}
+				result.Time = nowMillis()
+				callback(result, nil)
+			})
+		})
+	})
+}
+
+// upgradeStored records metadata in stored keys configured by versions that
+// did not record it (see describe).  Keys sealed by the vault already
+// record it, since it is recorded when they are sealed.  The IDs of the
+// upgraded keys are added to result.
+func (m *manager) upgradeStored(keys []*storedKey, result *UpgradeResult, callback func(err error)) {
+	writes := map[bool]map[string]interface{}{
+		false: make(map[string]interface{}),
+		true:  make(map[string]interface{}),
+	}
+	for _, k := range keys {
+		// Keys written by newer versions are left alone.
+		if k.Metadata >= metadataVersion || sealed(k.PEMPrivateKey) {
+			continue
+		}
+		p, err := m.providers.Lookup(k.Provider)
+		if err != nil {
+			p = m.providers.Default()
+		}
+		k.describe(p)
+		// Updated is left unchanged: the metadata is determined by the
+		// private key, so copies upgraded on other devices are the
+		// same, and must not take precedence over real changes.
+		writes[k.DeviceOnly][storageKey(k.ID)] = k.value()
+		result.Keys = append(result.Keys, k.ID)
+	}
+	sort.Slice(result.Keys, func(i, j int) bool { return result.Keys[i] < result.Keys[j] })
+
+	write := func(deviceOnly bool, callback func(err error)) {
+		if len(writes[deviceOnly]) == 0 {
+			callback(nil)
+			return
+		}
+		m.storeFor(deviceOnly).Set(writes[deviceOnly], callback)
+	}
+	write(false, func(err error) {
+		if err != nil {
+			callback(help.Errorf(help.StorageFailure, "failed to write keys: %v", err))
+			return
+		}
+		write(true, func(err error) {
+			if err != nil {
+				callback(help.Errorf(help.StorageFailure, "failed to write local keys: %v", err))
+				return
+			}
+			callback(nil)
+		})
+	})
+}
+
+// upgradeLoaded relabels configured keys that were loaded by versions whose
+// comment did not include the key's nickname.  keys are the configured keys.
+// The IDs of the relabelled keys are added to result.
+func (m *manager) upgradeLoaded(keys []*storedKey, result *UpgradeResult) error {
+	if m.relabeler == nil {
+		return nil
+	}
+	loaded, err := m.agent.List()
+	if err != nil {
+		return fmt.Errorf("failed to list loaded keys: %v", err)
+	}
+
+	names := nicknames(keys, m.fingerprint)
+	for _, l := range loaded {
+		lk := &LoadedKey{Comment: l.Comment}
+		id := lk.ID()
+		if id == InvalidID || lk.Nickname() != "" {
+			continue
+		}
+		nickname, ok := names[id]
+		if !ok {
+			// Keys that are no longer configured are unloaded by
+			// the Reconciler.
+			continue
+		}
+		if err := m.relabeler.Relabel(l, loadedComment(id, nickname)); err != nil {
+			return fmt.Errorf("failed to relabel loaded key: %v", err)
+		}
+		result.Loaded = append(result.Loaded, id)
+	}
+	return nil
+}
+
+// WriteUpgradeReport stores the outcome of an upgrade in store, replacing any
+// earlier report.  callback is invoked when complete.
+func WriteUpgradeReport(store SettingsStore, r *UpgradeResult, callback func(err error)) {
+	store.Set(map[string]interface{}{UpgradeReportKey: mustEncode(r)}, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to write upgrade report: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// ReadUpgradeReport reads the most recent upgrade report from store.
+// callback is invoked with a nil report if none has been stored.
+func ReadUpgradeReport(store SettingsStore, callback func(r *UpgradeResult, err error)) {
+	store.GetItems([]string{UpgradeReportKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read upgrade report: %v", err))
+			return
+		}
+		v, ok := data[UpgradeReportKey]
+		if !ok || v == nil {
+			callback(nil, nil)
+			return
+		}
+		r := &UpgradeResult{}
+		if err := codec.Decode(v, r); err != nil {
+			callback(nil, fmt.Errorf("failed to decode upgrade report: %v", err))
+			return
+		}
+		callback(r, nil)
+	})
+}
+
+// UpgradeReportChanged determines if a change to storage includes a new
+// upgrade report.
+func UpgradeReportChanged(changes map[string]interface{}) bool {
+	_, ok := changes[UpgradeReportKey]
+	return ok
+}
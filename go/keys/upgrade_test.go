@@ -0,0 +1,143 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+)
+
+func TestUpgrade(t *testing.T) {
+	syncStorage, localStorage := fakes.NewMemStorage(), fakes.NewMemStorage()
+	kr := keyring.New()
+	mgr, err := newTestManager(kr, syncStorage, localStorage, []*initialKey{
+		{
+			Name:          "synced-key",
+			PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			Load:          true,
+		},
+		{
+			Name:          "device-key",
+			PEMPrivateKey: testdata.ValidPrivateKey,
+			DeviceOnly:    true,
+		},
+	}, WithRelabeler(kr))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	// Make the keys look as if they were configured and loaded by an
+	// older version.
+	stripMetadata(t, syncStorage)
+	stripMetadata(t, localStorage)
+	syncedID, err := findKey(mgr, InvalidID, "synced-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	deviceID, err := findKey(mgr, InvalidID, "device-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	loaded, err := kr.List()
+	if err != nil || len(loaded) != 1 {
+		t.Fatalf("failed to list loaded keys: got %d keys, err %v", len(loaded), err)
+	}
+	if err := kr.Relabel(loaded[0], commentPrefix+string(syncedID)); err != nil {
+		t.Fatalf("failed to relabel key: %v", err)
+	}
+
+	// Keys configured by this version are left alone.
+	if err := syncAdd(mgr, "current-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+
+	result, err := syncUpgrade(mgr)
+	if err != nil {
+		t.Fatalf("failed to upgrade: %v", err)
+	}
+	wantKeys := []ID{syncedID, deviceID}
+	sort.Slice(wantKeys, func(i, j int) bool { return wantKeys[i] < wantKeys[j] })
+	if diff := pretty.Diff(result.Keys, wantKeys); diff != nil {
+		t.Errorf("incorrect upgraded keys; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(result.Loaded, []ID{syncedID}); diff != nil {
+		t.Errorf("incorrect upgraded loaded keys; -got +want: %s", diff)
+	}
+	if result.Time == 0 {
+		t.Errorf("upgrade time not recorded")
+	}
+
+	for _, s := range []*fakes.MemStorage{syncStorage, localStorage} {
+		s.Get(func(data map[string]interface{}, err error) {
+			if err != nil {
+				t.Fatalf("failed to read storage: %v", err)
+			}
+			keys, _ := parseStoredKeys(data)
+			for _, k := range keys {
+				if !k.hasMetadata() {
+					t.Errorf("key %q not upgraded", k.Name)
+				}
+			}
+		})
+	}
+	loadedKeys, err := syncLoaded(mgr)
+	if err != nil {
+		t.Fatalf("failed to list loaded keys: %v", err)
+	}
+	if len(loadedKeys) != 1 || loadedKeys[0].ID() != syncedID || loadedKeys[0].Nickname() == "" {
+		t.Errorf("loaded key not relabelled; got %+v", loadedKeys)
+	}
+
+	// Nothing is left to upgrade.
+	result, err = syncUpgrade(mgr)
+	if err != nil {
+		t.Fatalf("failed to upgrade again: %v", err)
+	}
+	if !result.Empty() {
+		t.Errorf("upgraded again; got %+v", result)
+	}
+}
+
+func TestUpgradeReport(t *testing.T) {
+	store := fakes.NewMemStorage()
+	ReadUpgradeReport(store, func(r *UpgradeResult, err error) {
+		if err != nil {
+			t.Fatalf("failed to read missing report: %v", err)
+		}
+		if r != nil {
+			t.Errorf("incorrect missing report; got %+v, want nil", r)
+		}
+	})
+
+	want := &UpgradeResult{Time: 1500000000000, Keys: []ID{ID("1")}, Loaded: []ID{ID("1")}}
+	WriteUpgradeReport(store, want, func(err error) {
+		if err != nil {
+			t.Fatalf("failed to write report: %v", err)
+		}
+	})
+	ReadUpgradeReport(store, func(r *UpgradeResult, err error) {
+		if err != nil {
+			t.Fatalf("failed to read report: %v", err)
+		}
+		if diff := pretty.Diff(r, want); diff != nil {
+			t.Errorf("incorrect report; -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// KeyUsage is the storage space used by a configured key.
+type KeyUsage struct {
+	// ID is the unique ID for the key.
+	ID ID `codec:"id"`
+	// Bytes is the space used by the key, in bytes.
+	Bytes int `codec:"bytes"`
+	// DeviceOnly indicates the key is stored only on this device.
+	DeviceOnly bool `codec:"deviceOnly"`
+}
+
+// StorageUsage describes the storage space used by configured keys, and the
+// space remaining.  A quota of zero indicates that there is no limit.
+type StorageUsage struct {
+	// Keys is the space used by each configured key.
+	Keys []*KeyUsage `codec:"keys"`
+	// SyncBytesInUse is the space used in synced storage, in bytes.
+	SyncBytesInUse int `codec:"syncBytesInUse"`
+	// SyncQuota is the maximum space that may be used in synced storage.
+	SyncQuota int `codec:"syncQuota"`
+	// SyncQuotaPerKey is the maximum space that may be used by a single
+	// key in synced storage.
+	SyncQuotaPerKey int `codec:"syncQuotaPerKey"`
+	// LocalBytesInUse is the space used in storage on this device, in
+	// bytes.
+	LocalBytesInUse int `codec:"localBytesInUse"`
+	// LocalQuota is the maximum space that may be used in storage on this
+	// device.
+	LocalQuota int `codec:"localQuota"`
+}
+
+// itemSize returns the space used by an item in storage, computed in the same
+// way as Chrome's storage API (i.e., the length of the key plus the length of
+// the value serialized as JSON).
+func itemSize(key string, value interface{}) int {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		log.Printf("failed to serialize %s: %v", key, err)
+		return len(key)
+	}
+	// Encode appends a trailing newline, which is not stored.
+	return len(key) + b.Len() - 1
+}
+
+// checkQuota determines if writing the specified item to store would exceed
+// the storage limits.  callback is invoked with an error if so.
+func checkQuota(store PersistentStore, key string, value interface{}, callback func(err error)) {
+	total, perItem := store.Quota()
+	size := itemSize(key, value)
+	if perItem > 0 && size > perItem {
+		callback(help.Errorf(help.StorageQuotaExceeded, "key requires %d bytes, but at most %d bytes may be used by a single key", size, perItem))
+		return
+	}
+	if total == 0 {
+		callback(nil)
+		return
+	}
+
+	store.BytesInUse(nil, func(bytes int, err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to check storage quota: %v", err))
+			return
+		}
+		if bytes+size > total {
+			callback(help.Errorf(help.StorageQuotaExceeded, "key requires %d bytes, but only %d bytes remain", size, total-bytes))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// keyUsage returns the space used by each key contained in data read from
+// storage.
+func keyUsage(data map[string]interface{}, deviceOnly bool) []*KeyUsage {
+	var result []*KeyUsage
+	keys, _ := parseStoredKeys(data)
+	for _, k := range keys {
+		u := &KeyUsage{}
+		u.ID = k.ID
+		u.Bytes = itemSize(storageKey(k.ID), data[storageKey(k.ID)])
+		u.DeviceOnly = deviceOnly
+		result = append(result, u)
+	}
+	return result
+}
+
+// Usage implements Manager.Usage.
+func (m *manager) Usage(callback func(usage *StorageUsage, err error)) {
+	usage := &StorageUsage{}
+	usage.SyncQuota, usage.SyncQuotaPerKey = m.storage.Quota()
+	usage.LocalQuota, _ = m.localStorage.Quota()
+
+	m.storage.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read from storage: %v", err))
+			return
+		}
+		keys := keyUsage(data, false)
+
+		m.localStorage.Get(func(data map[string]interface{}, err error) {
+			if err != nil {
+				callback(nil, fmt.Errorf("failed to read from local storage: %v", err))
+				return
+			}
+			usage.Keys = append(keys, keyUsage(data, true)...)
+
+			m.storage.BytesInUse(nil, func(bytes int, err error) {
+				if err != nil {
+					callback(nil, fmt.Errorf("failed to get storage usage: %v", err))
+					return
+				}
+				usage.SyncBytesInUse = bytes
+
+				m.localStorage.BytesInUse(nil, func(bytes int, err error) {
+					if err != nil {
+						callback(nil, fmt.Errorf("failed to get local storage usage: %v", err))
+						return
+					}
+					usage.LocalBytesInUse = bytes
+					callback(usage, nil)
+				})
+			})
+		})
+	})
+}
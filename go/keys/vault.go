@@ -0,0 +1,761 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// VaultKey is the key under which the parameters of the vault are
+	// stored.  They are stored in synced storage, so that the same master
+	// passphrase unlocks the vault on each device.
+	VaultKey = "vault"
+
+	// vaultBlockType is the type of the PEM block in which a sealed
+	// private key is stored.
+	vaultBlockType = "CHROME-SSH-AGENT VAULT"
+	// vaultSaltHeader is the header of a sealed private key that
+	// identifies the master key with which it was sealed.
+	vaultSaltHeader = "Vault-Salt"
+	// vaultCheckText is sealed with the master key and stored with the
+	// vault's parameters, so that a master passphrase can be verified
+	// without reading any keys.
+	vaultCheckText = "chrome-ssh-agent vault"
+
+	// vaultVersion is the version of the parameters of new vaults, whose
+	// master key is derived with PBKDF2-SHA256.
+	vaultVersion = 2
+	// vaultArgonVersion is the version of the parameters of vaults whose
+	// master key is derived with Argon2id.  They are still opened, but
+	// new master keys are not derived this way: Argon2id runs in Go, which
+	// stalls the background page for seconds under GopherJS.
+	vaultArgonVersion = 1
+	// vaultSaltSize is the size of the salt, in bytes.
+	vaultSaltSize = 16
+	// vaultKeySize is the size of the AES-256 master key, in bytes.
+	vaultKeySize = 32
+	// vaultIterations is the number of PBKDF2-SHA256 iterations with
+	// which new master keys are derived.  The number used is stored with
+	// the vault, so it may be raised later.
+	vaultIterations = 600000
+	// vaultMaxIterations bounds the number of PBKDF2-SHA256 iterations
+	// read from synced storage, so that a corrupt record cannot make each
+	// unlock take minutes.
+	vaultMaxIterations = 10000000
+	// vaultMaxArgonPasses, vaultMaxArgonMemory (in KiB) and
+	// vaultMaxArgonParallelism bound the Argon2id parameters read from
+	// synced storage, so that a corrupt record cannot make each unlock
+	// exhaust memory or hang the background page.
+	vaultMaxArgonPasses      = 16
+	vaultMaxArgonMemory      = 256 * 1024
+	vaultMaxArgonParallelism = 16
+)
+
+// vaultParams are the parameters of the vault, as stored under VaultKey.
+type vaultParams struct {
+	Version int
+	Salt    string
+	// Iterations is the number of PBKDF2-SHA256 iterations with which
+	// the master key is derived.
+	Iterations int
+	// Passes, Memory (in KiB) and Parallelism are the Argon2id
+	// parameters with which the master key is derived, for vaults of
+	// vaultArgonVersion.
+	Passes      uint32
+	Memory      uint32
+	Parallelism uint8
+	// Check is vaultCheckText sealed with the master key.
+	Check string
+	// Previous are the master keys replaced by this one while the master
+	// passphrase is being changed, so that keys not yet resealed can
+	// still be opened.  They are cleared once every key is resealed.
+	Previous []vaultPrevious
+}
+
+// vaultPrevious is a master key replaced by that of the vault.
+type vaultPrevious struct {
+	// Salt identifies the keys sealed with the replaced master key.
+	Salt string
+	// Key is the replaced master key, sealed with the vault's.
+	Key string
+}
+
+// VaultKDF derives a master key of keyLen bytes from a passphrase with
+// PBKDF2-SHA256, using the number of iterations recorded with the vault.
+// callback is invoked with the key; it may be invoked asynchronously.
+type VaultKDF func(passphrase, salt []byte, iterations, keyLen int, callback func(key []byte, err error))
+
+// WithVaultKDF specifies the function used to derive the master key of the
+// vault (e.g., one using WebCrypto, so that derivation does not block the
+// page).  By default, it is derived in Go.
+func WithVaultKDF(kdf VaultKDF) ManagerOption {
+	return func(m *manager) {
+		m.kdf = kdf
+	}
+}
+
+// goVaultKDF implements VaultKDF in Go.
+func goVaultKDF(passphrase, salt []byte, iterations, keyLen int, callback func(key []byte, err error)) {
+	callback(pbkdf2.Key(passphrase, salt, iterations, keyLen, sha256.New), nil)
+}
+
+// vaultKDFParams are the parameters from which a master key is derived.
+type vaultKDFParams struct {
+	salt        string
+	iterations  int
+	passes      uint32
+	memory      uint32
+	parallelism uint8
+}
+
+// vaultSession is the master key of an unlocked vault.  It is cached for the
+// rest of the session (but the master passphrase never is), so that keys can
+// be sealed and unsealed without deriving it each time.
+type vaultSession struct {
+	// params are the parameters from which key was derived.  The key is
+	// only used while the vault's parameters match them.
+	params vaultKDFParams
+	key    []byte
+	// previous are the master keys replaced by key that have not yet
+	// been cleared from the vault's parameters.  Only their salt is set
+	// in params.
+	previous []*vaultSession
+}
+
+// newVaultParams returns parameters for a new vault, with a salt read from r.
+func newVaultParams(r io.Reader) (*vaultParams, error) {
+	salt := make([]byte, vaultSaltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+	return &vaultParams{
+		Version:    vaultVersion,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Iterations: vaultIterations,
+	}, nil
+}
+
+// parseVaultParams parses the parameters of the vault as read from storage.
+func parseVaultParams(v interface{}) (*vaultParams, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("vault parameters are not an object")
+	}
+	// Numbers are decoded from storage as float64.
+	version, _ := m["version"].(float64)
+	iterations, _ := m["iterations"].(float64)
+	passes, _ := m["passes"].(float64)
+	memory, _ := m["memory"].(float64)
+	parallelism, _ := m["parallelism"].(float64)
+	salt, _ := m["salt"].(string)
+	check, _ := m["check"].(string)
+	if salt == "" || check == "" {
+		return nil, errors.New("invalid vault parameters")
+	}
+	switch int(version) {
+	case vaultVersion:
+		if iterations < 1 {
+			return nil, errors.New("invalid vault parameters")
+		}
+		if iterations > vaultMaxIterations {
+			return nil, fmt.Errorf("vault parameters exceed limits (iterations %v)", iterations)
+		}
+	case vaultArgonVersion:
+		if passes < 1 || memory < 8*parallelism || parallelism < 1 {
+			return nil, errors.New("invalid vault parameters")
+		}
+		if passes > vaultMaxArgonPasses || memory > vaultMaxArgonMemory || parallelism > vaultMaxArgonParallelism {
+			return nil, fmt.Errorf("vault parameters exceed limits (passes %v, memory %v KiB, parallelism %v)", passes, memory, parallelism)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported vault version %v", version)
+	}
+	var previous []vaultPrevious
+	if v, ok := m["previous"]; ok {
+		l, ok := v.([]interface{})
+		if !ok {
+			return nil, errors.New("previous master keys are not a list")
+		}
+		for _, v := range l {
+			pm, _ := v.(map[string]interface{})
+			salt, _ := pm["salt"].(string)
+			key, _ := pm["key"].(string)
+			if salt == "" || key == "" {
+				return nil, errors.New("invalid previous master key")
+			}
+			previous = append(previous, vaultPrevious{Salt: salt, Key: key})
+		}
+	}
+	return &vaultParams{
+		Version:     int(version),
+		Salt:        salt,
+		Iterations:  int(iterations),
+		Passes:      uint32(passes),
+		Memory:      uint32(memory),
+		Parallelism: uint8(parallelism),
+		Check:       check,
+		Previous:    previous,
+	}, nil
+}
+
+// value returns the parameters in the form in which they are stored.
+func (p *vaultParams) value() map[string]interface{} {
+	v := map[string]interface{}{
+		"version": p.Version,
+		"salt":    p.Salt,
+		"check":   p.Check,
+	}
+	if p.Version == vaultArgonVersion {
+		v["passes"] = p.Passes
+		v["memory"] = p.Memory
+		v["parallelism"] = p.Parallelism
+	} else {
+		v["iterations"] = p.Iterations
+	}
+	if len(p.Previous) > 0 {
+		var previous []interface{}
+		for _, prev := range p.Previous {
+			previous = append(previous, map[string]interface{}{
+				"salt": prev.Salt,
+				"key":  prev.Key,
+			})
+		}
+		v["previous"] = previous
+	}
+	return v
+}
+
+// kdfParams returns the parameters from which the master key is derived.
+func (p *vaultParams) kdfParams() vaultKDFParams {
+	return vaultKDFParams{
+		salt:        p.Salt,
+		iterations:  p.Iterations,
+		passes:      p.Passes,
+		memory:      p.Memory,
+		parallelism: p.Parallelism,
+	}
+}
+
+// session derives the master key from passphrase using kdf (or Argon2id, for
+// vaults of vaultArgonVersion), and invokes callback with the session.
+func (p *vaultParams) session(passphrase string, kdf VaultKDF, callback func(s *vaultSession, err error)) {
+	salt, err := base64.StdEncoding.DecodeString(p.Salt)
+	if err != nil {
+		callback(nil, fmt.Errorf("invalid vault salt: %v", err))
+		return
+	}
+	if p.Version == vaultArgonVersion {
+		callback(p.keySession(argon2.IDKey([]byte(passphrase), salt, p.Passes, p.Memory, p.Parallelism, vaultKeySize)))
+		return
+	}
+	kdf([]byte(passphrase), salt, p.Iterations, vaultKeySize, func(key []byte, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to derive master key: %v", err))
+			return
+		}
+		callback(p.keySession(key))
+	})
+}
+
+// keySession returns a session using the master key derived from the master
+// passphrase, with the previous master keys opened.  It fails with
+// help.IncorrectPassphrase if the passphrase is incorrect, unless the
+// parameters are new and have no check value yet.
+func (p *vaultParams) keySession(key []byte) (*vaultSession, error) {
+	s := &vaultSession{
+		params: p.kdfParams(),
+		key:    key,
+	}
+	if p.Check == "" {
+		return s, nil
+	}
+	check, err := s.open(p.Check)
+	if err != nil || subtle.ConstantTimeCompare([]byte(check), []byte(vaultCheckText)) != 1 {
+		return nil, help.Errorf(help.IncorrectPassphrase, "incorrect master passphrase")
+	}
+	for _, prev := range p.Previous {
+		text, err := s.open(prev.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open previous master key: %v", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(text)
+		if err != nil || len(key) != vaultKeySize {
+			return nil, errors.New("invalid previous master key")
+		}
+		s.previous = append(s.previous, &vaultSession{
+			params: vaultKDFParams{salt: prev.Salt},
+			key:    key,
+		})
+	}
+	return s, nil
+}
+
+// aead returns the cipher with which the session seals data.
+func (s *vaultSession) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts text with the master key, using a nonce read from r.  The
+// result is a PEM block identifying the master key by its salt.
+func (s *vaultSession) seal(text string, r io.Reader) (string, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return "", fmt.Errorf("failed to create cipher: %v", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	block := &pem.Block{
+		Type:    vaultBlockType,
+		Headers: map[string]string{vaultSaltHeader: s.params.salt},
+		Bytes:   aead.Seal(nonce, nonce, []byte(text), nil),
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+// open decrypts text sealed by seal, with the master key or one it
+// replaced.  It fails with help.VaultLocked if it was sealed with a
+// different master key (e.g., one since changed on another device).
+func (s *vaultSession) open(sealed string) (string, error) {
+	block, _ := pem.Decode([]byte(sealed))
+	if block == nil || block.Type != vaultBlockType {
+		return "", errors.New("not sealed by the vault")
+	}
+	if salt := block.Headers[vaultSaltHeader]; salt != s.params.salt {
+		for _, prev := range s.previous {
+			if prev.params.salt == salt {
+				return prev.open(sealed)
+			}
+		}
+		return "", help.Errorf(help.VaultLocked, "the key was sealed with a different master passphrase; unlock the vault again")
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return "", fmt.Errorf("failed to create cipher: %v", err)
+	}
+	if len(block.Bytes) < aead.NonceSize() {
+		return "", errors.New("sealed data is truncated")
+	}
+	nonce, ciphertext := block.Bytes[:aead.NonceSize()], block.Bytes[aead.NonceSize():]
+	text, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt sealed data: %v", err)
+	}
+	return string(text), nil
+}
+
+// wipe overwrites the master key, so that it does not linger in memory once
+// the session no longer uses it.
+func (s *vaultSession) wipe() {
+	for i := range s.key {
+		s.key[i] = 0
+	}
+	for _, prev := range s.previous {
+		prev.wipe()
+	}
+}
+
+// unlocked determines if the master key cached for the session was derived
+// from params.  If it was derived from other parameters (e.g., the master
+// passphrase was since changed on another device), it is discarded.
+func (m *manager) unlocked(params *vaultParams) bool {
+	if m.vault == nil {
+		return false
+	}
+	if m.vault.params != params.kdfParams() {
+		m.forgetVault()
+		return false
+	}
+	return true
+}
+
+// forgetVault discards the master key cached for the session.
+func (m *manager) forgetVault() {
+	if m.vault != nil {
+		m.vault.wipe()
+		m.vault = nil
+	}
+}
+
+// sealed determines if a stored private key is sealed by the vault.
+func sealed(pemPrivateKey string) bool {
+	block, _ := pem.Decode([]byte(pemPrivateKey))
+	return block != nil && block.Type == vaultBlockType
+}
+
+// readVault reads the parameters of the vault from synced storage.
+// callback is invoked with nil parameters if no master passphrase is set.
+func (m *manager) readVault(callback func(params *vaultParams, err error)) {
+	m.storage.GetItems([]string{VaultKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, help.Errorf(help.StorageFailure, "failed to read vault: %v", err))
+			return
+		}
+		v, ok := data[VaultKey]
+		if !ok || v == nil {
+			callback(nil, nil)
+			return
+		}
+		params, err := parseVaultParams(v)
+		if err != nil {
+			callback(nil, help.Errorf(help.StorageFailure, "failed to read vault: %v", err))
+			return
+		}
+		callback(params, nil)
+	})
+}
+
+// secrets returns the fields of a stored key that are sealed by the vault:
+// its private key, and the secret from which its TOTP codes are generated.
+func (s *storedKey) secrets() []*string {
+	return []*string{&s.PEMPrivateKey, &s.TOTPSecret}
+}
+
+// unseal replaces the secrets of a key read from storage with the secrets
+// sealed in them, if any.  It fails with help.VaultLocked if the vault has
+// not been unlocked.  The key must not be written back to storage
+// afterwards.
+func (m *manager) unseal(key *storedKey) error {
+	for _, secret := range key.secrets() {
+		if !sealed(*secret) {
+			continue
+		}
+		if m.vault == nil {
+			return help.Errorf(help.VaultLocked, "key %q is sealed by the vault; unlock it with the master passphrase", key.Name)
+		}
+		text, err := m.vault.open(*secret)
+		if err != nil {
+			return help.Wrap(err, fmt.Sprintf("failed to unseal key %q", key.Name))
+		}
+		*secret = text
+	}
+	return nil
+}
+
+// sealNew seals the secrets of a new key if a master passphrase is set.
+func (m *manager) sealNew(key *storedKey, callback func(err error)) {
+	m.readVault(func(params *vaultParams, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		if params == nil {
+			callback(nil)
+			return
+		}
+		if !m.unlocked(params) {
+			callback(help.Errorf(help.VaultLocked, "keys are sealed by the vault; unlock it with the master passphrase to add keys"))
+			return
+		}
+		for _, secret := range key.secrets() {
+			if *secret == "" {
+				continue
+			}
+			if *secret, err = m.vault.seal(*secret, rand.Reader); err != nil {
+				callback(fmt.Errorf("failed to seal key: %v", err))
+				return
+			}
+		}
+		callback(nil)
+	})
+}
+
+// VaultStatus implements Manager.VaultStatus.
+func (m *manager) VaultStatus(callback func(enabled, unlocked bool, err error)) {
+	m.readVault(func(params *vaultParams, err error) {
+		if err != nil {
+			callback(false, false, err)
+			return
+		}
+		if params == nil {
+			callback(false, false, nil)
+			return
+		}
+		callback(true, m.unlocked(params), nil)
+	})
+}
+
+// UnlockVault implements Manager.UnlockVault.
+func (m *manager) UnlockVault(passphrase string, callback func(err error)) {
+	m.readVault(func(params *vaultParams, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		if params == nil {
+			callback(errors.New("no master passphrase is set"))
+			return
+		}
+		params.session(passphrase, m.kdf, func(s *vaultSession, err error) {
+			if err != nil {
+				if m.audit != nil && help.CodeOf(err) == help.IncorrectPassphrase {
+					m.audit.Record(audit.NewEntry("vault", "options", "", false, "incorrect master passphrase"), nil)
+				}
+				callback(err)
+				return
+			}
+			m.forgetVault()
+			m.vault = s
+			callback(nil)
+		})
+	})
+}
+
+// LockVault implements Manager.LockVault.
+func (m *manager) LockVault(callback func(err error)) {
+	m.forgetVault()
+	callback(nil)
+}
+
+// SetMasterPassphrase implements Manager.SetMasterPassphrase.
+func (m *manager) SetMasterPassphrase(current, passphrase string, callback func(err error)) {
+	m.writes.runErr(func(callback func(err error)) {
+		m.readVault(func(params *vaultParams, err error) {
+			if err != nil {
+				callback(err)
+				return
+			}
+			if params == nil && passphrase == "" {
+				callback(errors.New("no master passphrase is set"))
+				return
+			}
+			openVault(params, current, m.kdf, func(old *vaultSession, err error) {
+				if err != nil {
+					callback(err)
+					return
+				}
+				newVault(passphrase, old, m.kdf, func(nextParams *vaultParams, next *vaultSession, err error) {
+					if err != nil {
+						callback(err)
+						return
+					}
+					m.changeVault(old, next, nextParams, callback)
+				})
+			})
+		})
+	}, callback)
+}
+
+// openVault derives the session for the vault with the specified parameters,
+// or a nil session if params is nil (i.e., no master passphrase is set).
+func openVault(params *vaultParams, passphrase string, kdf VaultKDF, callback func(s *vaultSession, err error)) {
+	if params == nil {
+		callback(nil, nil)
+		return
+	}
+	params.session(passphrase, kdf, callback)
+}
+
+// newVault returns the parameters and session of a vault with a new master
+// passphrase, replacing the session old (which may be nil).  The parameters
+// record the master keys replaced, sealed with the new one.  If passphrase is
+// empty, both are nil.
+func newVault(passphrase string, old *vaultSession, kdf VaultKDF, callback func(params *vaultParams, s *vaultSession, err error)) {
+	if passphrase == "" {
+		callback(nil, nil, nil)
+		return
+	}
+	params, err := newVaultParams(rand.Reader)
+	if err != nil {
+		callback(nil, nil, err)
+		return
+	}
+	params.session(passphrase, kdf, func(s *vaultSession, err error) {
+		if err != nil {
+			callback(nil, nil, err)
+			return
+		}
+		if params.Check, err = s.seal(vaultCheckText, rand.Reader); err != nil {
+			callback(nil, nil, err)
+			return
+		}
+		if old != nil {
+			for _, prev := range append([]*vaultSession{old}, old.previous...) {
+				key, err := s.seal(base64.StdEncoding.EncodeToString(prev.key), rand.Reader)
+				if err != nil {
+					callback(nil, nil, err)
+					return
+				}
+				params.Previous = append(params.Previous, vaultPrevious{Salt: prev.params.salt, Key: key})
+				s.previous = append(s.previous, &vaultSession{
+					params: vaultKDFParams{salt: prev.params.salt},
+					key:    append([]byte(nil), prev.key...),
+				})
+			}
+		}
+		callback(params, s, nil)
+	})
+}
+
+// changeVault reseals every key, replacing the session old with next, and
+// writes nextParams as the parameters of the vault.  Either session may be
+// nil; if next is nil, the master passphrase is removed.
+func (m *manager) changeVault(old, next *vaultSession, nextParams *vaultParams, callback func(err error)) {
+	// Once the new parameters are written, the session uses the new
+	// master key, which also opens keys not yet resealed.
+	adopt := func() {
+		if old != nil {
+			old.wipe()
+		}
+		m.forgetVault()
+		m.vault = next
+	}
+
+	// The new parameters are written before any key is resealed, along
+	// with the master keys they replace, so that every key can still be
+	// opened if a later write fails.  The replaced master keys are
+	// cleared once every key is resealed.  When the master passphrase is
+	// removed, the parameters are kept until every key is unsealed.
+	stage := func(callback func(err error)) {
+		if nextParams == nil {
+			callback(nil)
+			return
+		}
+		m.storage.Set(map[string]interface{}{VaultKey: nextParams.value()}, callback)
+	}
+	stage(func(err error) {
+		if err != nil {
+			callback(help.Errorf(help.StorageFailure, "failed to write vault: %v", err))
+			return
+		}
+		m.reseal(old, next, func(err error) {
+			if err != nil {
+				if next != nil {
+					adopt()
+					err = help.Wrap(err, "the master passphrase was changed, but not every key was resealed; set it again to finish")
+				}
+				callback(err)
+				return
+			}
+			m.finishVault(nextParams, func(err error) {
+				if err != nil {
+					if next != nil {
+						adopt()
+					}
+					callback(help.Errorf(help.StorageFailure, "failed to write vault: %v", err))
+					return
+				}
+				adopt()
+				if m.audit != nil {
+					detail := "set master passphrase"
+					if next == nil {
+						detail = "removed master passphrase"
+					}
+					m.audit.Record(audit.NewEntry("vault", "options", "", true, detail), nil)
+				}
+				callback(nil)
+			})
+		})
+	})
+}
+
+// finishVault writes the parameters of the vault once every key is resealed,
+// without the master keys they replaced.  If params is nil, they are
+// removed.
+func (m *manager) finishVault(params *vaultParams, callback func(err error)) {
+	if params == nil {
+		m.storage.Delete([]string{VaultKey}, callback)
+		return
+	}
+	params.Previous = nil
+	m.storage.Set(map[string]interface{}{VaultKey: params.value()}, callback)
+}
+
+// reseal rewrites every configured key, opening secrets sealed with old and
+// sealing them with next.  Either may be nil: secrets are stored unsealed if
+// next is nil.  Metadata is recorded for keys that lack it before they are
+// sealed, since it can no longer be determined from a sealed key.
+func (m *manager) reseal(old, next *vaultSession, callback func(err error)) {
+	m.readKeys(func(keys []*storedKey, err error) {
+		if err != nil {
+			callback(help.Errorf(help.StorageFailure, "failed to read keys: %v", err))
+			return
+		}
+
+		writes := map[bool]map[string]interface{}{
+			false: make(map[string]interface{}),
+			true:  make(map[string]interface{}),
+		}
+		for _, k := range keys {
+			for _, secret := range k.secrets() {
+				if !sealed(*secret) {
+					continue
+				}
+				if old == nil {
+					callback(help.Errorf(help.VaultLocked, "key %q is sealed, but no master passphrase is set", k.Name))
+					return
+				}
+				if *secret, err = old.open(*secret); err != nil {
+					callback(help.Wrap(err, fmt.Sprintf("failed to unseal key %q", k.Name)))
+					return
+				}
+			}
+			if !k.hasMetadata() {
+				p, err := m.providers.Lookup(k.Provider)
+				if err != nil {
+					p = m.providers.Default()
+				}
+				k.describe(p)
+			}
+			if next != nil {
+				for _, secret := range k.secrets() {
+					if *secret == "" {
+						continue
+					}
+					if *secret, err = next.seal(*secret, rand.Reader); err != nil {
+						callback(fmt.Errorf("failed to seal key %q: %v", k.Name, err))
+						return
+					}
+				}
+			}
+			k.Updated = nowMillis()
+			writes[k.DeviceOnly][storageKey(k.ID)] = k.value()
+		}
+
+		m.storeFor(false).Set(writes[false], func(err error) {
+			if err != nil {
+				callback(help.Errorf(help.StorageFailure, "failed to write keys: %v", err))
+				return
+			}
+			m.storeFor(true).Set(writes[true], func(err error) {
+				if err != nil {
+					callback(help.Errorf(help.StorageFailure, "failed to write local keys: %v", err))
+					return
+				}
+				callback(nil)
+			})
+		})
+	})
+}
@@ -0,0 +1,605 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/agenthooks"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// storedPEMs returns the private keys stored in store, indexed by name.
+func storedPEMs(t *testing.T, store PersistentStore) map[string]string {
+	result := make(map[string]string)
+	store.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			t.Fatalf("failed to read storage: %v", err)
+		}
+		keys, _ := parseStoredKeys(data)
+		for _, k := range keys {
+			result[k.Name] = k.PEMPrivateKey
+		}
+	})
+	return result
+}
+
+func checkVaultStatus(t *testing.T, description string, mgr Manager, wantEnabled, wantUnlocked bool) {
+	enabled, unlocked, err := syncVaultStatus(mgr)
+	if err != nil {
+		t.Fatalf("%s: failed to read vault status: %v", description, err)
+	}
+	if diff := pretty.Diff([]bool{enabled, unlocked}, []bool{wantEnabled, wantUnlocked}); diff != nil {
+		t.Errorf("%s: incorrect vault status (enabled, unlocked); -got +want: %s", description, diff)
+	}
+}
+
+func TestVault(t *testing.T) {
+	syncStorage, localStorage := fakes.NewMemStorage(), fakes.NewMemStorage()
+	mgr, err := newTestManager(agent.NewKeyring(), syncStorage, localStorage, []*initialKey{
+		{
+			Name:          "synced-key",
+			PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+		},
+		{
+			Name:          "device-key",
+			PEMPrivateKey: testdata.ValidPrivateKey,
+			DeviceOnly:    true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+	synced, err := findKey(mgr, InvalidID, "synced-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	device, err := findKey(mgr, InvalidID, "device-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	checkVaultStatus(t, "initially", mgr, false, false)
+
+	// Setting a master passphrase seals every key in both storage areas.
+	if err := syncSetMasterPassphrase(mgr, "", "master"); err != nil {
+		t.Fatalf("failed to set master passphrase: %v", err)
+	}
+	checkVaultStatus(t, "after setting master passphrase", mgr, true, true)
+	for _, store := range []PersistentStore{syncStorage, localStorage} {
+		for name, pem := range storedPEMs(t, store) {
+			if !sealed(pem) || strings.Contains(pem, "PRIVATE KEY") {
+				t.Errorf("%s: key not sealed: %s", name, pem)
+			}
+		}
+	}
+	if err := syncLoad(mgr, synced, ""); err != nil {
+		t.Errorf("failed to load unencrypted key from unlocked vault: %v", err)
+	}
+	if err := syncLoad(mgr, device, testdata.ValidPrivateKeyPassphrase); err != nil {
+		t.Errorf("failed to load encrypted key from unlocked vault: %v", err)
+	}
+
+	// Keys configured later are sealed too.
+	if err := syncAdd(mgr, "new-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+		t.Fatalf("failed to add key to unlocked vault: %v", err)
+	}
+	if pem := storedPEMs(t, syncStorage)["new-key"]; !sealed(pem) {
+		t.Errorf("new key not sealed: %s", pem)
+	}
+
+	// A new session starts with the vault locked.
+	other := NewManager(agent.NewKeyring(), syncStorage, localStorage)
+	checkVaultStatus(t, "new session", other, true, false)
+	if err := syncLoad(other, synced, ""); help.CodeOf(err) != help.VaultLocked {
+		t.Errorf("incorrect error loading key from locked vault; got %v, want code %s", err, help.VaultLocked)
+	}
+	if err := syncAdd(other, "locked-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); help.CodeOf(err) != help.VaultLocked {
+		t.Errorf("incorrect error adding key to locked vault; got %v, want code %s", err, help.VaultLocked)
+	}
+	if err := syncUnlockVault(other, "wrong"); help.CodeOf(err) != help.IncorrectPassphrase {
+		t.Errorf("incorrect error unlocking vault with wrong passphrase; got %v, want code %s", err, help.IncorrectPassphrase)
+	}
+	if err := syncUnlockVault(other, "master"); err != nil {
+		t.Fatalf("failed to unlock vault: %v", err)
+	}
+	checkVaultStatus(t, "unlocked session", other, true, true)
+	if err := syncLoad(other, synced, ""); err != nil {
+		t.Errorf("failed to load key after unlocking vault: %v", err)
+	}
+
+	// Changing the master passphrase requires the current one, and
+	// locks the vault in other sessions.
+	if err := syncSetMasterPassphrase(mgr, "wrong", "changed"); help.CodeOf(err) != help.IncorrectPassphrase {
+		t.Errorf("incorrect error changing master passphrase with wrong passphrase; got %v, want code %s", err, help.IncorrectPassphrase)
+	}
+	if err := syncSetMasterPassphrase(mgr, "master", "changed"); err != nil {
+		t.Fatalf("failed to change master passphrase: %v", err)
+	}
+	checkVaultStatus(t, "after change in other session", other, true, false)
+	if err := syncLoad(other, synced, ""); help.CodeOf(err) != help.VaultLocked {
+		t.Errorf("incorrect error loading key resealed in other session; got %v, want code %s", err, help.VaultLocked)
+	}
+	if err := syncUnlockVault(other, "changed"); err != nil {
+		t.Fatalf("failed to unlock vault with changed passphrase: %v", err)
+	}
+	if err := syncLoad(other, synced, ""); err != nil {
+		t.Errorf("failed to load key after unlocking vault with changed passphrase: %v", err)
+	}
+
+	// Removing the master passphrase stores the original keys again.
+	if err := syncSetMasterPassphrase(mgr, "changed", ""); err != nil {
+		t.Fatalf("failed to remove master passphrase: %v", err)
+	}
+	checkVaultStatus(t, "after removing master passphrase", mgr, false, false)
+	want := map[string]string{
+		"synced-key": testdata.ValidPrivateKeyWithoutPassphrase,
+		"new-key":    testdata.ValidPrivateKeyWithoutPassphrase,
+	}
+	if diff := pretty.Diff(storedPEMs(t, syncStorage), want); diff != nil {
+		t.Errorf("incorrect synced keys after removing master passphrase; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(storedPEMs(t, localStorage), map[string]string{"device-key": testdata.ValidPrivateKey}); diff != nil {
+		t.Errorf("incorrect device-only keys after removing master passphrase; -got +want: %s", diff)
+	}
+	if err := syncLoad(other, synced, ""); err != nil {
+		t.Errorf("failed to load key after removing master passphrase: %v", err)
+	}
+}
+
+// failingVaultStore fails writes of the vault's parameters for which fail
+// returns true.
+type failingVaultStore struct {
+	PersistentStore
+	fail func(params map[string]interface{}) bool
+}
+
+func (s *failingVaultStore) Set(data map[string]interface{}, callback func(err error)) {
+	if params, ok := data[VaultKey].(map[string]interface{}); ok && s.fail != nil && s.fail(params) {
+		callback(errors.New("storage failed"))
+		return
+	}
+	s.PersistentStore.Set(data, callback)
+}
+
+func TestVaultPartialChange(t *testing.T) {
+	testcases := []struct {
+		description    string
+		failParams     func(params map[string]interface{}) bool
+		localErr       fakes.Errs
+		wantPassphrase string
+	}{
+		{
+			description:    "new parameters not written",
+			failParams:     func(params map[string]interface{}) bool { return true },
+			wantPassphrase: "master",
+		},
+		{
+			description:    "device-only keys not resealed",
+			localErr:       fakes.Errs{Set: errors.New("storage failed")},
+			wantPassphrase: "changed",
+		},
+		{
+			description:    "previous master keys not cleared",
+			failParams:     func(params map[string]interface{}) bool { return params["previous"] == nil },
+			wantPassphrase: "changed",
+		},
+	}
+
+	for _, tc := range testcases {
+		syncStorage, localStorage := fakes.NewMemStorage(), fakes.NewMemStorage()
+		mgr, err := newTestManager(agent.NewKeyring(), syncStorage, localStorage, []*initialKey{
+			{
+				Name:          "synced-key",
+				PEMPrivateKey: testdata.ValidPrivateKeyWithoutPassphrase,
+			},
+			{
+				Name:          "device-key",
+				PEMPrivateKey: testdata.ValidPrivateKey,
+				DeviceOnly:    true,
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to initialize manager: %v", tc.description, err)
+		}
+		if err := syncSetMasterPassphrase(mgr, "", "master"); err != nil {
+			t.Fatalf("%s: failed to set master passphrase: %v", tc.description, err)
+		}
+
+		failing := NewManager(agent.NewKeyring(), &failingVaultStore{syncStorage, tc.failParams}, localStorage)
+		localStorage.SetError(tc.localErr)
+		if err := syncSetMasterPassphrase(failing, "master", "changed"); err == nil {
+			t.Errorf("%s: changing master passphrase unexpectedly succeeded", tc.description)
+		}
+		localStorage.SetError(fakes.Errs{})
+
+		// Every key can still be opened with one of the master
+		// passphrases, and changing it again finishes resealing them.
+		for _, passphrase := range []string{tc.wantPassphrase, "final"} {
+			other := NewManager(agent.NewKeyring(), syncStorage, localStorage)
+			if err := syncUnlockVault(other, passphrase); err != nil {
+				t.Fatalf("%s: failed to unlock vault with %q: %v", tc.description, passphrase, err)
+			}
+			for name, keyPassphrase := range map[string]string{
+				"synced-key": "",
+				"device-key": testdata.ValidPrivateKeyPassphrase,
+			} {
+				id, err := findKey(other, InvalidID, name)
+				if err != nil {
+					t.Fatalf("%s: failed to find key: %v", tc.description, err)
+				}
+				if err := syncLoad(other, id, keyPassphrase); err != nil {
+					t.Errorf("%s: failed to load %s after unlocking vault with %q: %v", tc.description, name, passphrase, err)
+				}
+			}
+			if passphrase == tc.wantPassphrase {
+				if err := syncSetMasterPassphrase(other, passphrase, "final"); err != nil {
+					t.Errorf("%s: failed to change master passphrase again: %v", tc.description, err)
+				}
+			}
+		}
+		syncStorage.GetItems([]string{VaultKey}, func(data map[string]interface{}, err error) {
+			if err != nil {
+				t.Fatalf("%s: failed to read vault: %v", tc.description, err)
+			}
+			if previous := data[VaultKey].(map[string]interface{})["previous"]; previous != nil {
+				t.Errorf("%s: previous master keys not cleared: %v", tc.description, previous)
+			}
+		})
+	}
+}
+
+func TestVaultSessionOpen(t *testing.T) {
+	params, err := newVaultParams(strings.NewReader(strings.Repeat("s", vaultSaltSize)))
+	if err != nil {
+		t.Fatalf("failed to create parameters: %v", err)
+	}
+	var s *vaultSession
+	params.session("master", goVaultKDF, func(session *vaultSession, err error) {
+		if err != nil {
+			t.Fatalf("failed to derive key: %v", err)
+		}
+		s = session
+	})
+	sealedText, err := s.seal("secret", strings.NewReader(strings.Repeat("n", 12)))
+	if err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+
+	block, _ := pem.Decode([]byte(sealedText))
+	block.Bytes[len(block.Bytes)-1] ^= 1
+	tampered := string(pem.EncodeToMemory(block))
+
+	testcases := []struct {
+		description string
+		sealed      string
+		want        string
+		wantErr     bool
+	}{
+		{
+			description: "sealed",
+			sealed:      sealedText,
+			want:        "secret",
+		},
+		{
+			description: "tampered",
+			sealed:      tampered,
+			wantErr:     true,
+		},
+		{
+			description: "different salt",
+			sealed:      strings.Replace(sealedText, s.params.salt, "c29tZS1vdGhlci1zYWx0", 1),
+			wantErr:     true,
+		},
+		{
+			description: "not sealed",
+			sealed:      testdata.ValidPrivateKey,
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := s.open(tc.sealed)
+		if diff := pretty.Diff(err != nil, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error state; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect text; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestVaultTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	var guard *TOTPGuard
+	guard = NewTOTPGuard(DefaultTOTPTimeout, func(c *TOTPChallenge) {
+		if err := guard.Respond(c.Challenge, "050471"); err != nil {
+			t.Errorf("failed to respond to challenge: %v", err)
+		}
+	})
+	guard.now = func() time.Time { return now }
+	agt := agenthooks.New(keyring.New())
+	agt.Install(guard.Hooks())
+	syncStorage := fakes.NewMemStorage()
+	mgr := NewManager(agt, syncStorage, fakes.NewMemStorage(), WithTOTPGuard(guard))
+
+	if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKeyWithoutPassphrase, &AddOptions{TOTPSecret: totpSecret}); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	if err := syncSetMasterPassphrase(mgr, "", "master"); err != nil {
+		t.Fatalf("failed to set master passphrase: %v", err)
+	}
+
+	// The TOTP secret is sealed along with the private key.
+	syncStorage.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			t.Fatalf("failed to read storage: %v", err)
+		}
+		keys, _ := parseStoredKeys(data)
+		if len(keys) != 1 || !sealed(keys[0].TOTPSecret) {
+			t.Errorf("TOTP secret not sealed: %# v", pretty.Formatter(keys))
+		}
+	})
+
+	// It is unsealed when the key is loaded from the unlocked vault.
+	id, err := findKey(mgr, InvalidID, "some-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	if err := syncLoad(mgr, id, ""); err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	loaded, err := agt.List()
+	if err != nil || len(loaded) != 1 {
+		t.Fatalf("failed to list loaded keys: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(loaded[0].Blob)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+	if _, err := agt.Sign(pub, []byte("some-data")); err != nil {
+		t.Errorf("failed to sign with correct code: %v", err)
+	}
+}
+
+func TestParseVaultParams(t *testing.T) {
+	params := func(iterations float64) map[string]interface{} {
+		return map[string]interface{}{
+			"version":    float64(vaultVersion),
+			"salt":       "c2FsdA==",
+			"iterations": iterations,
+			"check":      "check",
+		}
+	}
+	argonParams := func(passes, memory, parallelism float64) map[string]interface{} {
+		return map[string]interface{}{
+			"version":     float64(vaultArgonVersion),
+			"salt":        "c2FsdA==",
+			"passes":      passes,
+			"memory":      memory,
+			"parallelism": parallelism,
+			"check":       "check",
+		}
+	}
+	testcases := []struct {
+		description string
+		params      interface{}
+		wantErr     bool
+	}{
+		{
+			description: "default parameters",
+			params:      params(vaultIterations),
+		},
+		{
+			description: "maximum iterations",
+			params:      params(vaultMaxIterations),
+		},
+		{
+			description: "too many iterations",
+			params:      params(vaultMaxIterations + 1),
+			wantErr:     true,
+		},
+		{
+			description: "no iterations",
+			params:      params(0),
+			wantErr:     true,
+		},
+		{
+			description: "Argon2id parameters",
+			params:      argonParams(4, 16*1024, 1),
+		},
+		{
+			description: "maximum Argon2id parameters",
+			params:      argonParams(vaultMaxArgonPasses, vaultMaxArgonMemory, vaultMaxArgonParallelism),
+		},
+		{
+			description: "too many passes",
+			params:      argonParams(vaultMaxArgonPasses+1, 16*1024, 1),
+			wantErr:     true,
+		},
+		{
+			description: "too much memory",
+			params:      argonParams(4, 4*1024*1024, 1),
+			wantErr:     true,
+		},
+		{
+			description: "too much parallelism",
+			params:      argonParams(4, 16*1024, 255),
+			wantErr:     true,
+		},
+		{
+			description: "too little memory for parallelism",
+			params:      argonParams(4, 8, 2),
+			wantErr:     true,
+		},
+		{
+			description: "no passes",
+			params:      argonParams(0, 16*1024, 1),
+			wantErr:     true,
+		},
+		{
+			description: "unsupported version",
+			params: map[string]interface{}{
+				"version": float64(vaultVersion + 1),
+				"salt":    "c2FsdA==",
+				"check":   "check",
+			},
+			wantErr: true,
+		},
+		{
+			description: "not an object",
+			params:      "vault",
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		_, err := parseVaultParams(tc.params)
+		if diff := pretty.Diff(err != nil, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error state (%v); -got +want: %s", tc.description, err, diff)
+		}
+	}
+}
+
+func TestVaultKeyCache(t *testing.T) {
+	derived := 0
+	// The key may be derived asynchronously, as it is by WebCrypto.
+	kdf := func(passphrase, salt []byte, iterations, keyLen int, callback func(key []byte, err error)) {
+		derived++
+		go goVaultKDF(passphrase, salt, iterations, keyLen, callback)
+	}
+	syncStorage := fakes.NewMemStorage()
+	mgr := NewManager(agent.NewKeyring(), syncStorage, fakes.NewMemStorage(), WithVaultKDF(kdf))
+	if err := syncAdd(mgr, "some-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	id, err := findKey(mgr, InvalidID, "some-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	if err := syncSetMasterPassphrase(mgr, "", "master"); err != nil {
+		t.Fatalf("failed to set master passphrase: %v", err)
+	}
+
+	// The master key is derived once, and used for later operations.
+	for i := 0; i < 3; i++ {
+		if err := syncLoad(mgr, id, ""); err != nil {
+			t.Fatalf("failed to load key: %v", err)
+		}
+	}
+	if err := syncAdd(mgr, "other-key", testdata.ValidPrivateKey, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	if diff := pretty.Diff(derived, 1); diff != nil {
+		t.Errorf("incorrect derivations while unlocked; -got +want: %s", diff)
+	}
+
+	// Locking the vault discards the cached master key.
+	if err := syncLockVault(mgr); err != nil {
+		t.Fatalf("failed to lock vault: %v", err)
+	}
+	if err := syncLoad(mgr, id, ""); help.CodeOf(err) != help.VaultLocked {
+		t.Errorf("incorrect error loading key after locking vault; got %v, want code %s", err, help.VaultLocked)
+	}
+	if err := syncUnlockVault(mgr, "master"); err != nil {
+		t.Fatalf("failed to unlock vault: %v", err)
+	}
+	if diff := pretty.Diff(derived, 2); diff != nil {
+		t.Errorf("incorrect derivations after unlocking again; -got +want: %s", diff)
+	}
+
+	// The cached master key is discarded if the parameters from which it
+	// was derived change, even if the salt does not.
+	syncStorage.GetItems([]string{VaultKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			t.Fatalf("failed to read vault: %v", err)
+		}
+		params := data[VaultKey].(map[string]interface{})
+		params["iterations"] = float64(vaultIterations + 1)
+		syncStorage.Set(map[string]interface{}{VaultKey: params}, func(err error) {
+			if err != nil {
+				t.Fatalf("failed to write vault: %v", err)
+			}
+		})
+	})
+	checkVaultStatus(t, "after parameters changed", mgr, true, false)
+}
+
+func TestVaultArgon(t *testing.T) {
+	// Vaults whose master key is derived with Argon2id are still opened.
+	params, err := newVaultParams(strings.NewReader(strings.Repeat("s", vaultSaltSize)))
+	if err != nil {
+		t.Fatalf("failed to create parameters: %v", err)
+	}
+	params.Version = vaultArgonVersion
+	params.Iterations = 0
+	params.Passes, params.Memory, params.Parallelism = 1, 64, 1
+	params.session("master", goVaultKDF, func(s *vaultSession, err error) {
+		if err != nil {
+			t.Fatalf("failed to derive key: %v", err)
+		}
+		if params.Check, err = s.seal(vaultCheckText, strings.NewReader(strings.Repeat("n", 12))); err != nil {
+			t.Fatalf("failed to seal: %v", err)
+		}
+	})
+	syncStorage := fakes.NewMemStorage()
+	syncStorage.Set(map[string]interface{}{VaultKey: params.value()}, func(err error) {
+		if err != nil {
+			t.Fatalf("failed to write vault: %v", err)
+		}
+	})
+
+	mgr := NewManager(agent.NewKeyring(), syncStorage, fakes.NewMemStorage())
+	if err := syncUnlockVault(mgr, "wrong"); help.CodeOf(err) != help.IncorrectPassphrase {
+		t.Errorf("incorrect error unlocking vault with wrong passphrase; got %v, want code %s", err, help.IncorrectPassphrase)
+	}
+	if err := syncUnlockVault(mgr, "master"); err != nil {
+		t.Fatalf("failed to unlock vault: %v", err)
+	}
+
+	// Changing the master passphrase derives the new master key with
+	// PBKDF2-SHA256.
+	if err := syncSetMasterPassphrase(mgr, "master", "changed"); err != nil {
+		t.Fatalf("failed to change master passphrase: %v", err)
+	}
+	syncStorage.GetItems([]string{VaultKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			t.Fatalf("failed to read vault: %v", err)
+		}
+		version := data[VaultKey].(map[string]interface{})["version"]
+		if diff := pretty.Diff(version, float64(vaultVersion)); diff != nil {
+			t.Errorf("incorrect vault version after changing master passphrase; -got +want: %s", diff)
+		}
+	})
+	checkVaultStatus(t, "after changing master passphrase", mgr, true, true)
+}
+
+func BenchmarkVaultKDF(b *testing.B) {
+	salt := []byte(strings.Repeat("s", vaultSaltSize))
+	for i := 0; i < b.N; i++ {
+		pbkdf2.Key([]byte("master"), salt, vaultIterations, vaultKeySize, sha256.New)
+	}
+}
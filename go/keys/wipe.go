@@ -0,0 +1,144 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Wiper erases everything the extension keeps: keys loaded in the agent,
+// configured keys, and all other data in persistent storage (e.g., settings,
+// approvals and the audit log).  It is intended for handing a device over to
+// someone else, or decommissioning it.
+//
+// If a master passphrase is set, the vault is erased first: the key derived
+// from the passphrase is forgotten, and the parameters from which it is
+// derived (see VaultKey) are deleted.  Keys sealed by the vault can then no
+// longer be decrypted, even if deleting them fails or a copy of storage
+// survives.  Keys are then deleted from storage, and Chrome Sync deletes
+// synced keys from the user's other devices.
+type Wiper struct {
+	mgr    Manager
+	agent  agent.Agent
+	stores []PersistentStore
+}
+
+// NewWiper returns a Wiper that unloads keys using mgr, removes anything left
+// in agt, and deletes all data from each of stores.
+func NewWiper(mgr Manager, agt agent.Agent, stores ...PersistentStore) *Wiper {
+	return &Wiper{
+		mgr:    mgr,
+		agent:  agt,
+		stores: stores,
+	}
+}
+
+// Wipe unloads all keys, erases the vault, and deletes all data from storage.  Wiping continues
+// after a failure so that as much as possible is erased; callback is invoked
+// with the first error encountered, if any.
+func (w *Wiper) Wipe(callback func(err error)) {
+	var firstErr error
+	record := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// Unload keys through the manager so that their state is tracked,
+	// then remove anything else (e.g., ephemeral keys) from the agent.
+	// Keys the manager failed to unload are removed along with them.
+	UnloadAll(w.mgr, func(result *Result, err error) {
+		if err := w.agent.RemoveAll(); err != nil {
+			record(fmt.Errorf("failed to unload keys: %v", err))
+		}
+
+		w.mgr.LockVault(func(err error) {
+			if err != nil {
+				record(fmt.Errorf("failed to lock vault: %v", err))
+			}
+			w.eachStore(eraseVault, record, func() {
+				w.eachStore(clearStore, record, func() {
+					callback(firstErr)
+				})
+			})
+		})
+	})
+}
+
+// eachStore applies op to each store in turn, recording any error, and then
+// invokes done.
+func (w *Wiper) eachStore(op func(store PersistentStore, callback func(err error)), record func(err error), done func()) {
+	var next func(i int)
+	next = func(i int) {
+		if i == len(w.stores) {
+			done()
+			return
+		}
+		op(w.stores[i], func(err error) {
+			record(err)
+			next(i + 1)
+		})
+	}
+	next(0)
+}
+
+// eraseVault deletes the parameters of the vault from store, if they are
+// stored there.  Without them, keys sealed by the vault cannot be decrypted.
+func eraseVault(store PersistentStore, callback func(err error)) {
+	store.GetItems([]string{VaultKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to read vault: %v", err))
+			return
+		}
+		if _, ok := data[VaultKey]; !ok {
+			callback(nil)
+			return
+		}
+		store.Delete([]string{VaultKey}, func(err error) {
+			if err != nil {
+				callback(fmt.Errorf("failed to erase vault: %v", err))
+				return
+			}
+			callback(nil)
+		})
+	})
+}
+
+// clearStore deletes all data from store.
+func clearStore(store PersistentStore, callback func(err error)) {
+	store.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to read storage: %v", err))
+			return
+		}
+		var keys []string
+		for k := range data {
+			keys = append(keys, k)
+		}
+		if len(keys) == 0 {
+			callback(nil)
+			return
+		}
+		store.Delete(keys, func(err error) {
+			if err != nil {
+				callback(fmt.Errorf("failed to delete data: %v", err))
+				return
+			}
+			callback(nil)
+		})
+	})
+}
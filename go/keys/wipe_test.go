@@ -0,0 +1,172 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestWipe(t *testing.T) {
+	testcases := []struct {
+		description string
+		syncErr     fakes.Errs
+		wantErr     error
+		wantSync    int
+	}{
+		{
+			description: "wipe all data",
+		},
+		{
+			description: "continue after failing to clear storage",
+			syncErr:     fakes.Errs{Delete: errors.New("delete failed")},
+			wantErr:     errors.New("failed to delete data: delete failed"),
+			wantSync:    2,
+		},
+	}
+
+	for _, tc := range testcases {
+		syncStorage := fakes.NewMemStorage()
+		localStorage := fakes.NewMemStorage()
+		agt := agent.NewKeyring()
+		mgr := NewManager(agt, syncStorage, localStorage)
+
+		if err := syncAdd(mgr, "synced-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		if err := syncAdd(mgr, "device-key", testdata.ValidPrivateKey, &AddOptions{DeviceOnly: true}); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		id, err := findKey(mgr, InvalidID, "synced-key")
+		if err != nil {
+			t.Fatalf("%s: failed to find key: %v", tc.description, err)
+		}
+		if err := syncLoad(mgr, id, ""); err != nil {
+			t.Fatalf("%s: failed to load key: %v", tc.description, err)
+		}
+		if err := syncLoadEphemeral(mgr, "ephemeral-key", testdata.ValidPrivateKeyWithoutPassphrase); err != nil {
+			t.Fatalf("%s: failed to load ephemeral key: %v", tc.description, err)
+		}
+		syncStorage.Set(map[string]interface{}{"some-setting": "some-value"}, func(err error) {})
+
+		syncStorage.SetError(tc.syncErr)
+		var gotErr error
+		NewWiper(mgr, agt, syncStorage, localStorage).Wipe(func(err error) {
+			gotErr = err
+		})
+		syncStorage.SetError(fakes.Errs{})
+		if diff := pretty.Diff(gotErr, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+
+		loaded, err := agt.List()
+		if err != nil {
+			t.Fatalf("%s: failed to list loaded keys: %v", tc.description, err)
+		}
+		if len(loaded) != 0 {
+			t.Errorf("%s: %d keys still loaded", tc.description, len(loaded))
+		}
+		for _, s := range []struct {
+			name  string
+			store *fakes.MemStorage
+			want  int
+		}{
+			{"sync", syncStorage, tc.wantSync},
+			{"local", localStorage, 0},
+		} {
+			s.store.Get(func(data map[string]interface{}, err error) {
+				if err != nil {
+					t.Fatalf("%s: failed to read %s storage: %v", tc.description, s.name, err)
+				}
+				if len(data) != s.want {
+					t.Errorf("%s: incorrect items in %s storage; got %d, want %d", tc.description, s.name, len(data), s.want)
+				}
+			})
+		}
+	}
+}
+
+// deleteRecorder records the keys deleted from a PersistentStore.
+type deleteRecorder struct {
+	PersistentStore
+	deleted [][]string
+}
+
+func (d *deleteRecorder) Delete(keys []string, callback func(err error)) {
+	d.deleted = append(d.deleted, keys)
+	d.PersistentStore.Delete(keys, callback)
+}
+
+func TestWipeVault(t *testing.T) {
+	syncStorage := &deleteRecorder{PersistentStore: fakes.NewMemStorage()}
+	localStorage := fakes.NewMemStorage()
+	agt := agent.NewKeyring()
+	mgr := NewManager(agt, syncStorage, localStorage)
+
+	if err := syncSetMasterPassphrase(mgr, "", "master"); err != nil {
+		t.Fatalf("failed to set master passphrase: %v", err)
+	}
+	if err := syncAdd(mgr, "sealed-key", testdata.ValidPrivateKeyWithoutPassphrase, nil); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+
+	// Keep a copy of the sealed keys, as if deleting them had failed.
+	survived := make(map[string]interface{})
+	syncStorage.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			t.Fatalf("failed to read storage: %v", err)
+		}
+		for k, v := range data {
+			if k != VaultKey {
+				survived[k] = v
+			}
+		}
+	})
+
+	var gotErr error
+	NewWiper(mgr, agt, syncStorage, localStorage).Wipe(func(err error) {
+		gotErr = err
+	})
+	if gotErr != nil {
+		t.Fatalf("failed to wipe: %v", gotErr)
+	}
+	if len(syncStorage.deleted) == 0 {
+		t.Fatalf("nothing deleted from sync storage")
+	}
+	if diff := pretty.Diff(syncStorage.deleted[0], []string{VaultKey}); diff != nil {
+		t.Errorf("vault not erased first; -got +want: %s", diff)
+	}
+	checkVaultStatus(t, "after wipe", mgr, false, false)
+
+	// The surviving keys can no longer be decrypted.
+	restored := fakes.NewMemStorage()
+	restored.Set(survived, func(err error) {})
+	mgr = NewManager(agent.NewKeyring(), restored, fakes.NewMemStorage())
+	id, err := findKey(mgr, InvalidID, "sealed-key")
+	if err != nil {
+		t.Fatalf("failed to find key: %v", err)
+	}
+	if err := syncUnlockVault(mgr, "master"); err == nil {
+		t.Errorf("unlocked vault after wipe")
+	}
+	if err := syncLoad(mgr, id, ""); err == nil {
+		t.Errorf("loaded sealed key after wipe")
+	}
+}
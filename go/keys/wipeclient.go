@@ -0,0 +1,82 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// Define a distinct type for each message used to wipe all data.  These are
+// distinct from those used by the Server.
+const (
+	msgTypeWipe int = 6000 + iota
+	msgTypeWipeRsp
+)
+
+type msgWipe struct {
+	*msgHeader
+}
+
+type rspWipe struct {
+	*msgHeader
+	Err string `js:"err"`
+}
+
+// ServeWipe allows other extension pages to wipe all data using WipeClient.
+func ServeWipe(wiper *Wiper, msg MessageReceiver) {
+	msg.OnMessage(func(headerObj *js.Object, sender *js.Object, sendResponse func(interface{})) bool {
+		if !fromExtension(sender, msg.ExtensionURL()) {
+			return false
+		}
+		header := &msgHeader{Object: headerObj}
+		if header.Type != msgTypeWipe {
+			return false
+		}
+		wiper.Wipe(func(err error) {
+			rsp := &rspWipe{msgHeader: header}
+			rsp.Type = msgTypeWipeRsp
+			rsp.Err = makeErrStr(err)
+			sendResponse(rsp)
+		})
+		return true
+	})
+}
+
+// WipeClient wipes all data using the Wiper served by ServeWipe (typically
+// in the background page).
+type WipeClient struct {
+	msg MessageSender
+}
+
+// NewWipeClient returns a WipeClient that sends requests using the supplied
+// messaging API.
+func NewWipeClient(msg MessageSender) *WipeClient {
+	return &WipeClient{msg: msg}
+}
+
+// Wipe unloads all keys and deletes all data; see Wiper.Wipe.
+func (c *WipeClient) Wipe(callback func(err error)) {
+	msg := &msgWipe{msgHeader: &msgHeader{Object: js.Global.Get("Object").New()}}
+	msg.Type = msgTypeWipe
+	sendMessage(c.msg, msg, func(rspObj *js.Object, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		rsp := &rspWipe{msgHeader: &msgHeader{Object: rspObj}}
+		callback(makeErr(rsp.Err, help.None))
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keytype is a registry of the SSH public key algorithms known to the
+// extension.  Support for a new algorithm (e.g., a post-quantum or hybrid
+// algorithm as it is adopted by OpenSSH) is added by adding an entry to the
+// types table.
+//
+// Keys of an algorithm that is not registered, or that is registered but
+// cannot yet be used for signing, may still be stored and listed; their
+// public key is passed through unparsed.  Such keys cannot be loaded into
+// the agent.
+//
+// See section 6 of RFC 4251 for the syntax of algorithm names, and
+// PROTOCOL.key in the OpenSSH source for the layout of private keys.
+package keytype
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Type describes an SSH public key algorithm.
+type Type struct {
+	// Algorithm is the algorithm's name (e.g., 'ssh-ed25519').
+	Algorithm string
+	// Name is the short name by which keys of the algorithm are
+	// described (e.g., 'ed25519').
+	Name string
+	// PrivateFields is the number of length-prefixed fields that follow
+	// the algorithm's name in the private section of a key in OpenSSH's
+	// format, or zero if the layout is not a sequence of such fields.
+	// It is used to locate the comment without parsing the key.
+	PrivateFields int
+	// Signing indicates if keys of the algorithm can be parsed, loaded
+	// into the agent, and used to sign requests.
+	Signing bool
+}
+
+// types lists the registered algorithms.
+var types = []*Type{
+	{Algorithm: ssh.KeyAlgoRSA, Name: "rsa", PrivateFields: 6, Signing: true},
+	{Algorithm: ssh.KeyAlgoDSA, Name: "dsa", PrivateFields: 5, Signing: true},
+	{Algorithm: ssh.KeyAlgoECDSA256, Name: "ecdsa", PrivateFields: 3, Signing: true},
+	{Algorithm: ssh.KeyAlgoECDSA384, Name: "ecdsa", PrivateFields: 3, Signing: true},
+	{Algorithm: ssh.KeyAlgoECDSA521, Name: "ecdsa", PrivateFields: 3, Signing: true},
+	{Algorithm: ssh.KeyAlgoED25519, Name: "ed25519", PrivateFields: 2, Signing: true},
+	// Keys held by a FIDO security key.  The private section includes a
+	// single-byte flags field, so the comment cannot be located by
+	// counting fields.
+	{Algorithm: "sk-ecdsa-sha2-nistp256@openssh.com", Name: "ecdsa-sk"},
+	{Algorithm: "sk-ssh-ed25519@openssh.com", Name: "ed25519-sk"},
+}
+
+// registry indexes the registered algorithms by name.
+var registry = make(map[string]*Type)
+
+func init() {
+	for _, t := range types {
+		Register(t)
+	}
+}
+
+// Register adds an algorithm to the registry, replacing any existing entry
+// with the same name.
+func Register(t *Type) {
+	registry[t.Algorithm] = t
+}
+
+// Lookup returns the registered algorithm with the specified name, or nil
+// if it is not registered.
+func Lookup(algorithm string) *Type {
+	return registry[algorithm]
+}
+
+// Name returns the short name (e.g., 'ed25519') of an algorithm.  The
+// algorithm's name is returned if it is not registered.
+func Name(algorithm string) string {
+	if t := Lookup(algorithm); t != nil {
+		return t.Name
+	}
+	return algorithm
+}
+
+// Signing determines if keys of an algorithm can be loaded into the agent.
+func Signing(algorithm string) bool {
+	t := Lookup(algorithm)
+	return t != nil && t.Signing
+}
+
+const (
+	// maxNameLength is the maximum length of an algorithm name.
+	maxNameLength = 64
+)
+
+// Valid determines if an algorithm's name is syntactically valid: at most
+// 64 printable US-ASCII characters other than commas, and either a name
+// assigned by IANA or a locally-defined name of the form 'name@domain'.
+func Valid(algorithm string) bool {
+	if algorithm == "" || len(algorithm) > maxNameLength {
+		return false
+	}
+	for i := 0; i < len(algorithm); i++ {
+		if c := algorithm[i]; c <= ' ' || c > '~' || c == ',' {
+			return false
+		}
+	}
+	switch strings.Count(algorithm, "@") {
+	case 0:
+		return true
+	case 1:
+		i := strings.Index(algorithm, "@")
+		return i > 0 && i < len(algorithm)-1
+	}
+	return false
+}
+
+// opaqueKey is the public key of an algorithm that cannot be used for
+// signing.  Its wire encoding is retained unparsed, so that it can be
+// fingerprinted and exported.
+type opaqueKey struct {
+	algorithm string
+	blob      []byte
+}
+
+// Type implements ssh.PublicKey.Type.
+func (k *opaqueKey) Type() string {
+	return k.algorithm
+}
+
+// Marshal implements ssh.PublicKey.Marshal.
+func (k *opaqueKey) Marshal() []byte {
+	return k.blob
+}
+
+// Verify implements ssh.PublicKey.Verify.
+func (k *opaqueKey) Verify(data []byte, sig *ssh.Signature) error {
+	return fmt.Errorf("signatures by keys of type %q cannot be verified", k.algorithm)
+}
+
+// ParsePublicKey parses a public key in the SSH wire format.  Keys of an
+// algorithm that cannot be used for signing, but whose name is valid, are
+// returned unparsed; the resulting key can be fingerprinted and exported,
+// but cannot verify signatures.
+func ParsePublicKey(blob []byte) (ssh.PublicKey, error) {
+	pub, err := ssh.ParsePublicKey(blob)
+	if err == nil {
+		return pub, nil
+	}
+
+	// A key of an algorithm used for signing must be parsed.
+	var header struct {
+		Algorithm string
+		Rest      []byte `ssh:"rest"`
+	}
+	if ssh.Unmarshal(blob, &header) != nil || !Valid(header.Algorithm) || Signing(header.Algorithm) {
+		return nil, err
+	}
+	if len(header.Rest) == 0 {
+		return nil, errors.New("public key contains no key material")
+	}
+	return &opaqueKey{
+		algorithm: header.Algorithm,
+		blob:      append([]byte(nil), blob...),
+	}, nil
+}
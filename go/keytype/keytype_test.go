@@ -0,0 +1,171 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keytype
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestValid(t *testing.T) {
+	testcases := []struct {
+		description string
+		algorithm   string
+		want        bool
+	}{
+		{
+			description: "assigned name",
+			algorithm:   "ssh-ed25519",
+			want:        true,
+		},
+		{
+			description: "local name",
+			algorithm:   "sk-ssh-ed25519@openssh.com",
+			want:        true,
+		},
+		{
+			description: "empty",
+			algorithm:   "",
+		},
+		{
+			description: "comma",
+			algorithm:   "ssh-rsa,ssh-dss",
+		},
+		{
+			description: "space",
+			algorithm:   "ssh rsa",
+		},
+		{
+			description: "multiple at signs",
+			algorithm:   "a@b@example.com",
+		},
+		{
+			description: "empty domain",
+			algorithm:   "pq-key@",
+		},
+		{
+			description: "too long",
+			algorithm:   "x-0123456789012345678901234567890123456789012345678901234567890123",
+		},
+	}
+	for _, tc := range testcases {
+		if got := Valid(tc.algorithm); got != tc.want {
+			t.Errorf("%s: incorrect result; got %t, want %t", tc.description, got, tc.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	testcases := []struct {
+		description string
+		algorithm   string
+		wantName    string
+		wantSigning bool
+	}{
+		{
+			description: "signing",
+			algorithm:   ssh.KeyAlgoECDSA384,
+			wantName:    "ecdsa",
+			wantSigning: true,
+		},
+		{
+			description: "security key",
+			algorithm:   "sk-ssh-ed25519@openssh.com",
+			wantName:    "ed25519-sk",
+		},
+		{
+			description: "not registered",
+			algorithm:   "pq-key@example.com",
+			wantName:    "pq-key@example.com",
+		},
+	}
+	for _, tc := range testcases {
+		got := []interface{}{Name(tc.algorithm), Signing(tc.algorithm)}
+		want := []interface{}{tc.wantName, tc.wantSigning}
+		if diff := pretty.Diff(got, want); diff != nil {
+			t.Errorf("%s: incorrect result; -got +want: %s", tc.description, diff)
+		}
+	}
+
+	Register(&Type{Algorithm: "pq-key@example.com", Name: "pq", Signing: false})
+	defer delete(registry, "pq-key@example.com")
+	if got := Name("pq-key@example.com"); got != "pq" {
+		t.Errorf("incorrect name after registration; got %q, want %q", got, "pq")
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	rsaBlob, err := base64.StdEncoding.DecodeString(testdata.ValidPrivateKeyBlob)
+	if err != nil {
+		t.Fatalf("failed to decode blob: %v", err)
+	}
+	unknown := ssh.Marshal(struct{ Algorithm, Key string }{"pq-key@example.com", "key material"})
+	truncated := ssh.Marshal(struct{ Algorithm string }{"pq-key@example.com"})
+	invalid := ssh.Marshal(struct{ Algorithm, Key string }{"pq key", "key material"})
+	malformed := ssh.Marshal(struct{ Algorithm, Key string }{ssh.KeyAlgoRSA, "short"})
+
+	testcases := []struct {
+		description string
+		blob        []byte
+		wantType    string
+		wantErr     bool
+	}{
+		{
+			description: "parsed",
+			blob:        rsaBlob,
+			wantType:    ssh.KeyAlgoRSA,
+		},
+		{
+			description: "passed through",
+			blob:        unknown,
+			wantType:    "pq-key@example.com",
+		},
+		{
+			description: "no key material",
+			blob:        truncated,
+			wantErr:     true,
+		},
+		{
+			description: "invalid algorithm",
+			blob:        invalid,
+			wantErr:     true,
+		},
+		{
+			description: "malformed signing key",
+			blob:        malformed,
+			wantErr:     true,
+		},
+	}
+	for _, tc := range testcases {
+		pub, err := ParsePublicKey(tc.blob)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %t", tc.description, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := pub.Type(); got != tc.wantType {
+			t.Errorf("%s: incorrect type; got %q, want %q", tc.description, got, tc.wantType)
+		}
+		if diff := pretty.Diff(pub.Marshal(), tc.blob); diff != nil {
+			t.Errorf("%s: incorrect encoding; -got +want: %s", tc.description, diff)
+		}
+	}
+}
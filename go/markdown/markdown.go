@@ -0,0 +1,200 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package markdown parses the small subset of Markdown used for free-form
+// notes into a tree of nodes.  The tree is rendered by building DOM
+// elements for each node; text is never interpreted as HTML.
+//
+// The supported subset is:
+//
+//	Paragraphs, separated by blank lines.
+//	Bulleted lists, with items beginning '- ' or '* '.
+//	**strong**, *emphasis* and _emphasis_, and `code`.
+//	[links](https://example.com), for http and https URLs only.
+package markdown
+
+import (
+	"strings"
+)
+
+// Kind is the type of a node.
+type Kind int
+
+const (
+	// Text is plain text.
+	Text Kind = iota
+	// Paragraph is a paragraph containing inline nodes.
+	Paragraph
+	// List is a bulleted list containing ListItem nodes.
+	List
+	// ListItem is a list item containing inline nodes.
+	ListItem
+	// Strong is strongly-emphasized text.
+	Strong
+	// Emphasis is emphasized text.
+	Emphasis
+	// Code is text displayed as code.  It contains no child nodes.
+	Code
+	// Link is a hyperlink to URL.
+	Link
+)
+
+// Node is a node in the parsed tree.
+type Node struct {
+	// Kind is the type of the node.
+	Kind Kind
+	// Text is the text of Text and Code nodes.
+	Text string
+	// URL is the destination of Link nodes.
+	URL string
+	// Children are the nodes contained in this node.
+	Children []*Node
+}
+
+// Parse parses s, returning the block-level nodes (paragraphs and lists).
+func Parse(s string) []*Node {
+	var blocks []*Node
+	var para []string
+	var list *Node
+
+	flushPara := func() {
+		if len(para) > 0 {
+			blocks = append(blocks, &Node{Kind: Paragraph, Children: parseInline(strings.Join(para, " "))})
+			para = nil
+		}
+	}
+	flushList := func() {
+		if list != nil {
+			blocks = append(blocks, list)
+			list = nil
+		}
+	}
+
+	for _, line := range strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			flushPara()
+			flushList()
+		case strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* "):
+			flushPara()
+			if list == nil {
+				list = &Node{Kind: List}
+			}
+			item := &Node{Kind: ListItem, Children: parseInline(strings.TrimSpace(line[2:]))}
+			list.Children = append(list.Children, item)
+		case list != nil:
+			// A continuation of the previous list item.
+			item := list.Children[len(list.Children)-1]
+			item.Children = append(item.Children, &Node{Kind: Text, Text: " "})
+			item.Children = append(item.Children, parseInline(line)...)
+		default:
+			para = append(para, line)
+		}
+	}
+	flushPara()
+	flushList()
+	return blocks
+}
+
+// parseInline parses inline formatting in s.
+func parseInline(s string) []*Node {
+	var nodes []*Node
+	var text []byte
+	flushText := func() {
+		if len(text) > 0 {
+			nodes = append(nodes, &Node{Kind: Text, Text: string(text)})
+			text = nil
+		}
+	}
+
+	for i := 0; i < len(s); {
+		// Underscores within words (e.g., in host names) are not
+		// emphasis.
+		if s[i] == '_' && i > 0 && isWordByte(s[i-1]) {
+			text = append(text, s[i])
+			i++
+			continue
+		}
+		if n, width := parseSpan(s[i:]); n != nil {
+			flushText()
+			nodes = append(nodes, n)
+			i += width
+			continue
+		}
+		text = append(text, s[i])
+		i++
+	}
+	flushText()
+	return nodes
+}
+
+// parseSpan parses a formatted span at the start of s.  It returns the
+// parsed node and the number of bytes consumed, or nil if s does not start
+// with a complete span.
+func parseSpan(s string) (*Node, int) {
+	switch {
+	case strings.HasPrefix(s, "`"):
+		if end := strings.Index(s[1:], "`"); end > 0 {
+			return &Node{Kind: Code, Text: s[1 : 1+end]}, end + 2
+		}
+	case strings.HasPrefix(s, "**"):
+		if end := strings.Index(s[2:], "**"); end > 0 && flanked(s[2:2+end]) {
+			return &Node{Kind: Strong, Children: parseInline(s[2 : 2+end])}, end + 4
+		}
+	case strings.HasPrefix(s, "*") || strings.HasPrefix(s, "_"):
+		end := strings.Index(s[1:], s[:1])
+		if s[0] == '_' && end > 0 && end+2 < len(s) && isWordByte(s[end+2]) {
+			break
+		}
+		if end > 0 && flanked(s[1:1+end]) {
+			return &Node{Kind: Emphasis, Children: parseInline(s[1 : 1+end])}, end + 2
+		}
+	case strings.HasPrefix(s, "["):
+		return parseLink(s)
+	}
+	return nil, 0
+}
+
+// flanked determines if emphasized text neither begins nor ends with
+// whitespace, so that (e.g.) 'a * b * c' is not emphasized.
+func flanked(s string) bool {
+	return strings.TrimSpace(s) == s
+}
+
+// isWordByte determines if b is part of a word.
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// parseLink parses a link of the form '[text](url)' at the start of s.
+// Links to URLs other than http and https are not recognized.
+func parseLink(s string) (*Node, int) {
+	textEnd := strings.Index(s, "](")
+	if textEnd < 1 {
+		return nil, 0
+	}
+	urlEnd := strings.Index(s[textEnd+2:], ")")
+	if urlEnd < 1 {
+		return nil, 0
+	}
+	url := s[textEnd+2 : textEnd+2+urlEnd]
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, 0
+	}
+	if strings.ContainsAny(url, " \t") {
+		return nil, 0
+	}
+	return &Node{Kind: Link, URL: url, Children: parseInline(s[1:textEnd])}, textEnd + 2 + urlEnd + 1
+}
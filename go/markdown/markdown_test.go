@@ -0,0 +1,125 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package markdown
+
+import (
+	"testing"
+
+	"github.com/kr/pretty"
+)
+
+func text(s string) *Node {
+	return &Node{Kind: Text, Text: s}
+}
+
+func TestParse(t *testing.T) {
+	testcases := []struct {
+		description string
+		in          string
+		want        []*Node
+	}{
+		{
+			description: "empty",
+			in:          "",
+		},
+		{
+			description: "paragraphs",
+			in:          "First line\nsecond line\n\nAnother paragraph",
+			want: []*Node{
+				{Kind: Paragraph, Children: []*Node{text("First line second line")}},
+				{Kind: Paragraph, Children: []*Node{text("Another paragraph")}},
+			},
+		},
+		{
+			description: "list",
+			in:          "Servers:\n- prod-1\n* prod-2\n  and prod-3\n\nDone",
+			want: []*Node{
+				{Kind: Paragraph, Children: []*Node{text("Servers:")}},
+				{Kind: List, Children: []*Node{
+					{Kind: ListItem, Children: []*Node{text("prod-1")}},
+					{Kind: ListItem, Children: []*Node{text("prod-2"), text(" "), text("and prod-3")}},
+				}},
+				{Kind: Paragraph, Children: []*Node{text("Done")}},
+			},
+		},
+		{
+			description: "inline formatting",
+			in:          "**Important**: use *only* for _deploys_, see `TICKET-1`",
+			want: []*Node{
+				{Kind: Paragraph, Children: []*Node{
+					{Kind: Strong, Children: []*Node{text("Important")}},
+					text(": use "),
+					{Kind: Emphasis, Children: []*Node{text("only")}},
+					text(" for "),
+					{Kind: Emphasis, Children: []*Node{text("deploys")}},
+					text(", see "),
+					{Kind: Code, Text: "TICKET-1"},
+				}},
+			},
+		},
+		{
+			description: "link",
+			in:          "See [the **runbook**](https://example.com/runbook).",
+			want: []*Node{
+				{Kind: Paragraph, Children: []*Node{
+					text("See "),
+					{Kind: Link, URL: "https://example.com/runbook", Children: []*Node{
+						text("the "),
+						{Kind: Strong, Children: []*Node{text("runbook")}},
+					}},
+					text("."),
+				}},
+			},
+		},
+		{
+			description: "unsupported link scheme",
+			in:          "[click](javascript:alert(1))",
+			want: []*Node{
+				{Kind: Paragraph, Children: []*Node{text("[click](javascript:alert(1))")}},
+			},
+		},
+		{
+			description: "unterminated formatting",
+			in:          "a * b ** c ` d",
+			want: []*Node{
+				{Kind: Paragraph, Children: []*Node{text("a * b ** c ` d")}},
+			},
+		},
+		{
+			description: "underscores within words",
+			in:          "host_name_1 and _this_",
+			want: []*Node{
+				{Kind: Paragraph, Children: []*Node{
+					text("host_name_1 and "),
+					{Kind: Emphasis, Children: []*Node{text("this")}},
+				}},
+			},
+		},
+		{
+			description: "HTML is text",
+			in:          "<b>not bold</b>",
+			want: []*Node{
+				{Kind: Paragraph, Children: []*Node{text("<b>not bold</b>")}},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		got := Parse(tc.in)
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect result; -got +want: %s", tc.description, diff)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nassh composes connection profiles for the Secure Shell extension
+// (nassh) that are preconfigured to use this extension as their SSH agent.
+//
+// Profiles are exported as JSON containing Secure Shell preferences, keyed
+// by preference name (e.g., '/nassh/profiles/<id>/hostname').  Another
+// extension cannot write Secure Shell's preferences directly; the exported
+// preferences are imported from within Secure Shell.
+package nassh
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AgentOption returns the Secure Shell option that directs it to use the
+// extension with the specified ID as its SSH agent.
+func AgentOption(extensionID string) string {
+	return "--ssh-agent=" + extensionID
+}
+
+// Profile is a Secure Shell connection profile.
+type Profile struct {
+	// ID uniquely identifies the profile within Secure Shell.
+	ID string
+	// Description is the name of the profile displayed by Secure Shell.
+	Description string
+	// Username is the user to log in as.
+	Username string
+	// Hostname is the server to connect to.
+	Hostname string
+	// Port is the port to connect to, or zero to use the default port.
+	Port int
+	// Options are the options passed to Secure Shell (entered in the
+	// 'SSH Relay Server Options' field).
+	Options string
+}
+
+// newProfileID returns a new randomly-generated profile ID, using randomness
+// from r.
+func newProfileID(r io.Reader) (string, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewProfile returns a profile that connects to dest (in the form
+// 'user@host' or 'user@host:port') using the extension with the specified ID
+// as its SSH agent.  The profile ID is generated using randomness from r.
+func NewProfile(dest, description, extensionID string, r io.Reader) (*Profile, error) {
+	username, hostname, port, err := ParseDestination(dest)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newProfileID(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate profile ID: %v", err)
+	}
+	if description == "" {
+		description = strings.TrimSpace(dest)
+	}
+	return &Profile{
+		ID:          id,
+		Description: description,
+		Username:    username,
+		Hostname:    hostname,
+		Port:        port,
+		Options:     AgentOption(extensionID),
+	}, nil
+}
+
+// ParseDestination parses a destination in the form 'user@host' or
+// 'user@host:port'.  An IPv6 address must be enclosed in brackets if a port
+// is specified.
+func ParseDestination(dest string) (username, hostname string, port int, err error) {
+	dest = strings.TrimSpace(dest)
+	i := strings.LastIndex(dest, "@")
+	if i <= 0 || i == len(dest)-1 {
+		return "", "", 0, errors.New("destination must be of the form user@host[:port]")
+	}
+	username, hostname = dest[:i], dest[i+1:]
+
+	if strings.HasPrefix(hostname, "[") {
+		end := strings.Index(hostname, "]")
+		if end < 0 {
+			return "", "", 0, errors.New("missing ']' in host")
+		}
+		rest := hostname[end+1:]
+		hostname = hostname[1:end]
+		if rest == "" {
+			return username, hostname, 0, nil
+		}
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", 0, errors.New("unexpected characters after host")
+		}
+		port, err = parsePort(rest[1:])
+		return username, hostname, port, err
+	}
+
+	if j := strings.LastIndex(hostname, ":"); j >= 0 && strings.Count(hostname, ":") == 1 {
+		port, err = parsePort(hostname[j+1:])
+		if err != nil {
+			return "", "", 0, err
+		}
+		hostname = hostname[:j]
+	}
+	if hostname == "" {
+		return "", "", 0, errors.New("host must not be empty")
+	}
+	return username, hostname, port, nil
+}
+
+// parsePort parses a TCP port number.
+func parsePort(s string) (int, error) {
+	p, err := strconv.Atoi(s)
+	if err != nil || p < 1 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return p, nil
+}
+
+// profilePref returns the name of a preference for a profile.
+func profilePref(id, name string) string {
+	return fmt.Sprintf("/nassh/profiles/%s/%s", id, name)
+}
+
+// Preferences returns the Secure Shell preferences that define the supplied
+// profiles.
+func Preferences(profiles []*Profile) map[string]interface{} {
+	prefs := make(map[string]interface{})
+	var ids []string
+	for _, p := range profiles {
+		ids = append(ids, p.ID)
+		prefs[profilePref(p.ID, "description")] = p.Description
+		prefs[profilePref(p.ID, "username")] = p.Username
+		prefs[profilePref(p.ID, "hostname")] = p.Hostname
+		prefs[profilePref(p.ID, "nassh-options")] = p.Options
+		if p.Port != 0 {
+			prefs[profilePref(p.ID, "port")] = p.Port
+		}
+	}
+	sort.Strings(ids)
+	prefs["/nassh/profile-ids"] = ids
+	return prefs
+}
+
+// Export returns the supplied profiles as indented JSON, suitable for
+// importing into Secure Shell.
+func Export(profiles []*Profile) (string, error) {
+	b, err := json.MarshalIndent(Preferences(profiles), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode profiles: %v", err)
+	}
+	return string(b), nil
+}
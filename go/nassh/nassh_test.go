@@ -0,0 +1,131 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nassh
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/kr/pretty"
+)
+
+func TestParseDestination(t *testing.T) {
+	testcases := []struct {
+		description  string
+		dest         string
+		wantUsername string
+		wantHostname string
+		wantPort     int
+		wantErr      error
+	}{
+		{
+			description:  "user and host",
+			dest:         "alice@example.com",
+			wantUsername: "alice",
+			wantHostname: "example.com",
+		},
+		{
+			description:  "user, host and port",
+			dest:         " alice@example.com:2222 ",
+			wantUsername: "alice",
+			wantHostname: "example.com",
+			wantPort:     2222,
+		},
+		{
+			description:  "username containing @",
+			dest:         "alice@corp@example.com",
+			wantUsername: "alice@corp",
+			wantHostname: "example.com",
+		},
+		{
+			description:  "IPv6 address",
+			dest:         "alice@::1",
+			wantUsername: "alice",
+			wantHostname: "::1",
+		},
+		{
+			description:  "bracketed IPv6 address and port",
+			dest:         "alice@[::1]:22",
+			wantUsername: "alice",
+			wantHostname: "::1",
+			wantPort:     22,
+		},
+		{
+			description: "missing user",
+			dest:        "example.com",
+			wantErr:     errors.New("destination must be of the form user@host[:port]"),
+		},
+		{
+			description: "invalid port",
+			dest:        "alice@example.com:ssh",
+			wantErr:     errors.New(`invalid port "ssh"`),
+		},
+		{
+			description: "empty host",
+			dest:        "alice@:22",
+			wantErr:     errors.New("host must not be empty"),
+		},
+	}
+
+	for _, tc := range testcases {
+		username, hostname, port, err := ParseDestination(tc.dest)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		got := []interface{}{username, hostname, port}
+		want := []interface{}{tc.wantUsername, tc.wantHostname, tc.wantPort}
+		if diff := pretty.Diff(got, want); diff != nil {
+			t.Errorf("%s: incorrect destination; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	r := bytes.NewReader([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+	p, err := NewProfile("alice@example.com:2222", "", "some-extension-id", r)
+	if err != nil {
+		t.Fatalf("failed to create profile: %v", err)
+	}
+	want := &Profile{
+		ID:          "0102030405060708",
+		Description: "alice@example.com:2222",
+		Username:    "alice",
+		Hostname:    "example.com",
+		Port:        2222,
+		Options:     "--ssh-agent=some-extension-id",
+	}
+	if diff := pretty.Diff(p, want); diff != nil {
+		t.Errorf("incorrect profile; -got +want: %s", diff)
+	}
+
+	got, err := Export([]*Profile{p})
+	if err != nil {
+		t.Fatalf("failed to export profile: %v", err)
+	}
+	wantJSON := `{
+  "/nassh/profile-ids": [
+    "0102030405060708"
+  ],
+  "/nassh/profiles/0102030405060708/description": "alice@example.com:2222",
+  "/nassh/profiles/0102030405060708/hostname": "example.com",
+  "/nassh/profiles/0102030405060708/nassh-options": "--ssh-agent=some-extension-id",
+  "/nassh/profiles/0102030405060708/port": 2222,
+  "/nassh/profiles/0102030405060708/username": "alice"
+}`
+	if diff := pretty.Diff(got, wantJSON); diff != nil {
+		t.Errorf("incorrect export; -got +want: %s", diff)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command nativehost is a Chrome native messaging host that serves the
+// extension's SSH Agent on a local socket, so that local SSH clients (and
+// chrome-ssh-add) can use keys loaded in the browser.
+//
+// Chrome starts the host when the extension connects to it, and the host
+// exits when the extension disconnects.  Install it for the current user by
+// running:
+//
+//	nativehost -install -extension-id=<extension ID>
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/nativemsg"
+)
+
+var (
+	install     = flag.Bool("install", false, "Install the native messaging host manifest for the current user")
+	extensionID = flag.String("extension-id", "eechpbnaifiimgajnomdipfaamobdfha", "ID of the extension permitted to use the host")
+)
+
+// installManifest registers this binary as a native messaging host for the
+// current user.
+func installManifest() error {
+	path, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to determine path to host: %v", err)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to determine path to host: %v", err)
+	}
+
+	dir, err := nativemsg.ManifestDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+
+	b, err := json.MarshalIndent(nativemsg.NewManifest(path, *extensionID), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	file := filepath.Join(dir, nativemsg.HostName+".json")
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	fmt.Printf("Installed native messaging host manifest at %s\n", file)
+	return nil
+}
+
+// listen listens on the socket at the specified path, replacing any stale
+// socket left by a previous instance.
+func listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %v", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", path, err)
+	}
+	// Only the current user may use the keys.
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %v", err)
+	}
+	return l, nil
+}
+
+func main() {
+	// Chrome passes the origin of the extension as the first argument; it
+	// is not a flag, and would stop flag parsing.  Chrome writes our
+	// standard error to its own log.
+	if len(os.Args) > 1 && strings.HasPrefix(os.Args[1], "chrome-extension://") {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	flag.Parse()
+
+	if *install {
+		if err := installManifest(); err != nil {
+			log.Fatalf("Failed to install: %v", err)
+		}
+		return
+	}
+
+	path := nativemsg.DefaultSocketPath()
+	l, err := listen(path)
+	if err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	relay := nativemsg.NewRelay(os.Stdin, os.Stdout)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				log.Printf("Failed to accept connection: %v", err)
+				return
+			}
+			go func() {
+				defer c.Close()
+				if err := relay.Serve(c); err != nil {
+					log.Printf("Failed to serve connection: %v", err)
+				}
+			}()
+		}
+	}()
+
+	// Chrome closes our standard input when the extension disconnects.
+	<-relay.Done()
+	l.Close()
+	os.Remove(path)
+}
@@ -0,0 +1,277 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nativemsg relays the SSH Agent protocol between local clients and
+// the extension using Chrome's native messaging protocol.
+//
+// The extension connects to a native messaging host, and serves the SSH Agent
+// protocol over the connection using the same message format as for Chrome's
+// Secure Shell Extension: each agent message (without its length prefix) is
+// sent as a JSON object of the form {"data": [<byte>, <byte>, ...]}.
+//
+// See https://developer.chrome.com/apps/nativeMessaging.
+package nativemsg
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+const (
+	// HostName is the name of the native messaging host to which the
+	// extension connects.
+	HostName = "com.google.chrome_ssh_agent"
+
+	// SocketEnv is the environment variable that overrides the path of
+	// the socket on which the native messaging host serves the SSH Agent
+	// protocol.
+	SocketEnv = "CHROME_SSH_AGENT_SOCK"
+
+	// maxMessageSize is the maximum size of a message sent from a native
+	// messaging host to Chrome.  Messages sent by Chrome may be larger,
+	// but agent responses never approach this limit.
+	maxMessageSize = 1024 * 1024
+)
+
+var (
+	// ErrMessageTooLarge indicates that a message exceeds the size
+	// permitted by the native messaging protocol.
+	ErrMessageTooLarge = errors.New("message too large")
+	// ErrDisconnected indicates that the extension has disconnected.
+	ErrDisconnected = errors.New("extension disconnected")
+)
+
+// DefaultSocketPath returns the path of the socket on which the native
+// messaging host serves the SSH Agent protocol.  The path may be overridden
+// using the SocketEnv environment variable.
+func DefaultSocketPath() string {
+	if p := os.Getenv(SocketEnv); p != "" {
+		return p
+	}
+	if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" {
+		return filepath.Join(d, "chrome-ssh-agent.sock")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "chrome-ssh-agent.sock")
+}
+
+// ReadMessage reads a single native message from r.  Native messages are
+// prefixed with their length as a 32-bit integer in native byte order; all
+// platforms supported by Chrome are little-endian.
+func ReadMessage(r io.Reader) ([]byte, error) {
+	var l uint32
+	if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, l)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("failed to read message: %v", err)
+	}
+	return msg, nil
+}
+
+// WriteMessage writes a single native message to w.
+func WriteMessage(w io.Writer, msg []byte) error {
+	if len(msg) > maxMessageSize {
+		return ErrMessageTooLarge
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(msg))); err != nil {
+		return fmt.Errorf("failed to write message length: %v", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+	return nil
+}
+
+// agentMessage is an agent message as exchanged with the extension.  Data is
+// a slice of numbers rather than []byte since encoding/json would otherwise
+// encode it as a base64 string.
+type agentMessage struct {
+	Data []int `json:"data"`
+}
+
+// EncodeAgentMessage encodes an agent message (without its length prefix)
+// as a native message.
+func EncodeAgentMessage(b []byte) ([]byte, error) {
+	m := agentMessage{Data: make([]int, len(b))}
+	for i, v := range b {
+		m.Data[i] = int(v)
+	}
+	return json.Marshal(&m)
+}
+
+// DecodeAgentMessage decodes an agent message (without its length prefix)
+// from a native message.
+func DecodeAgentMessage(msg []byte) ([]byte, error) {
+	var m agentMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse message: %v", err)
+	}
+	if m.Data == nil {
+		return nil, errors.New("message did not contain data")
+	}
+	b := make([]byte, len(m.Data))
+	for i, v := range m.Data {
+		if v < 0 || v > 255 {
+			return nil, fmt.Errorf("message contained invalid byte %d", v)
+		}
+		b[i] = byte(v)
+	}
+	return b, nil
+}
+
+// Relay forwards agent requests to the extension and returns its responses.
+// The agent protocol consists of a response for each request, so requests
+// from concurrent clients are forwarded one at a time.
+type Relay struct {
+	mu sync.Mutex
+	// w writes messages to the extension.
+	w io.Writer
+	// rsps receives messages sent by the extension.  It is closed when
+	// the extension disconnects.
+	rsps chan []byte
+	// done is closed when the extension disconnects.
+	done chan struct{}
+}
+
+// NewRelay returns a Relay that reads messages from the extension using r
+// and writes messages to the extension using w.  A native messaging host
+// uses its standard input and output.
+func NewRelay(r io.Reader, w io.Writer) *Relay {
+	rl := &Relay{
+		w:    w,
+		rsps: make(chan []byte),
+		done: make(chan struct{}),
+	}
+	go rl.read(r)
+	return rl
+}
+
+// read reads messages sent by the extension until it disconnects.  The
+// extension only sends responses to requests, so reading continuously allows
+// a disconnect to be detected even while no request is outstanding.
+func (rl *Relay) read(r io.Reader) {
+	defer close(rl.done)
+	defer close(rl.rsps)
+	for {
+		msg, err := ReadMessage(r)
+		if err != nil {
+			return
+		}
+		rl.rsps <- msg
+	}
+}
+
+// Done returns a channel that is closed when the extension disconnects.
+func (rl *Relay) Done() <-chan struct{} {
+	return rl.done
+}
+
+// Call sends the agent request to the extension and returns its response.
+// Both exclude the length prefix.
+func (rl *Relay) Call(req []byte) ([]byte, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	msg, err := EncodeAgentMessage(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteMessage(rl.w, msg); err != nil {
+		return nil, err
+	}
+	rsp, ok := <-rl.rsps
+	if !ok {
+		return nil, ErrDisconnected
+	}
+	return DecodeAgentMessage(rsp)
+}
+
+// Serve forwards agent requests read from c (e.g., a connection to the
+// socket used by SSH clients) to the extension, and writes its responses
+// back to c.  It returns when c is closed, or an error is encountered.
+func (rl *Relay) Serve(c io.ReadWriter) error {
+	for {
+		var l uint32
+		if err := binary.Read(c, binary.BigEndian, &l); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read request length: %v", err)
+		}
+		if l > maxMessageSize {
+			return ErrMessageTooLarge
+		}
+		req := make([]byte, l)
+		if _, err := io.ReadFull(c, req); err != nil {
+			return fmt.Errorf("failed to read request: %v", err)
+		}
+
+		rsp, err := rl.Call(req)
+		if err != nil {
+			return err
+		}
+
+		framed := make([]byte, 4+len(rsp))
+		binary.BigEndian.PutUint32(framed, uint32(len(rsp)))
+		copy(framed[4:], rsp)
+		if _, err := c.Write(framed); err != nil {
+			return fmt.Errorf("failed to write response: %v", err)
+		}
+	}
+}
+
+// Manifest is the manifest that registers a native messaging host with
+// Chrome.
+//
+// See https://developer.chrome.com/apps/nativeMessaging#native-messaging-host.
+type Manifest struct {
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	Path           string   `json:"path"`
+	Type           string   `json:"type"`
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// NewManifest returns the manifest for the native messaging host at the
+// specified path, which may only be used by the specified extension.
+func NewManifest(path, extensionID string) *Manifest {
+	return &Manifest{
+		Name:           HostName,
+		Description:    "SSH Agent for Google Chrome",
+		Path:           path,
+		Type:           "stdio",
+		AllowedOrigins: []string{fmt.Sprintf("chrome-extension://%s/", extensionID)},
+	}
+}
+
+// ManifestDir returns the directory in which Chrome looks for the manifests
+// of native messaging hosts installed for the current user.
+func ManifestDir() (string, error) {
+	home := os.Getenv("HOME")
+	switch runtime.GOOS {
+	case "linux":
+		return filepath.Join(home, ".config", "google-chrome", "NativeMessagingHosts"), nil
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "Google", "Chrome", "NativeMessagingHosts"), nil
+	}
+	return "", fmt.Errorf("native messaging hosts are not supported on %s", runtime.GOOS)
+}
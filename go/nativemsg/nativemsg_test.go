@@ -0,0 +1,221 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nativemsg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestMessage(t *testing.T) {
+	var buf bytes.Buffer
+	want := []byte(`{"data":[1,2,3]}`)
+	if err := WriteMessage(&buf, want); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	if diff := pretty.Diff(buf.Bytes()[:4], []byte{byte(len(want)), 0, 0, 0}); diff != nil {
+		t.Errorf("incorrect length prefix; -got +want: %s", diff)
+	}
+	got, err := ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect message; -got +want: %s", diff)
+	}
+
+	if err := WriteMessage(&buf, make([]byte, maxMessageSize+1)); err != ErrMessageTooLarge {
+		t.Errorf("incorrect error for large message; got %v, want %v", err, ErrMessageTooLarge)
+	}
+}
+
+func TestAgentMessage(t *testing.T) {
+	testcases := []struct {
+		description string
+		msg         string
+		want        []byte
+		wantErr     bool
+	}{
+		{
+			description: "valid message",
+			msg:         `{"data":[0,17,255]}`,
+			want:        []byte{0, 17, 255},
+		},
+		{
+			description: "missing data",
+			msg:         `{}`,
+			wantErr:     true,
+		},
+		{
+			description: "invalid byte",
+			msg:         `{"data":[256]}`,
+			wantErr:     true,
+		},
+		{
+			description: "malformed",
+			msg:         `not json`,
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := DecodeAgentMessage([]byte(tc.msg))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %v", tc.description, err, tc.wantErr)
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect data; -got +want: %s", tc.description, diff)
+		}
+		if tc.wantErr {
+			continue
+		}
+
+		encoded, err := EncodeAgentMessage(got)
+		if err != nil {
+			t.Errorf("%s: failed to encode message: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(string(encoded), tc.msg); diff != nil {
+			t.Errorf("%s: incorrect encoded message; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+// fakeExtension serves the SSH Agent protocol over native messages in the
+// same way as the extension, using the supplied agent.  It returns the
+// streams a native messaging host would use to communicate with it.
+func fakeExtension(t *testing.T, a agent.Agent) (fromExt io.Reader, toExt io.Writer) {
+	hostIn, extOut := io.Pipe()
+	extIn, hostOut := io.Pipe()
+	agentSide, extSide := net.Pipe()
+	go agent.ServeAgent(a, agentSide)
+
+	// Forward requests from the host to the agent.
+	go func() {
+		for {
+			msg, err := ReadMessage(extIn)
+			if err != nil {
+				extSide.Close()
+				return
+			}
+			req, err := DecodeAgentMessage(msg)
+			if err != nil {
+				t.Errorf("extension received invalid message: %v", err)
+				extSide.Close()
+				return
+			}
+			binary.Write(extSide, binary.BigEndian, uint32(len(req)))
+			extSide.Write(req)
+		}
+	}()
+	// Forward responses from the agent to the host.
+	go func() {
+		for {
+			var l uint32
+			if err := binary.Read(extSide, binary.BigEndian, &l); err != nil {
+				extOut.Close()
+				return
+			}
+			rsp := make([]byte, l)
+			if _, err := io.ReadFull(extSide, rsp); err != nil {
+				extOut.Close()
+				return
+			}
+			msg, _ := EncodeAgentMessage(rsp)
+			WriteMessage(extOut, msg)
+		}
+	}()
+	return hostIn, hostOut
+}
+
+func TestRelay(t *testing.T) {
+	r, w := fakeExtension(t, agent.NewKeyring())
+	relay := NewRelay(r, w)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go relay.Serve(server)
+	cli := agent.NewClient(client)
+
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		t.Fatalf("failed to parse private key: %v", err)
+	}
+	if err := cli.Add(agent.AddedKey{PrivateKey: priv, Comment: "some-key"}); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+
+	listed, err := cli.List()
+	if err != nil {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+	var comments []string
+	for _, k := range listed {
+		comments = append(comments, k.Comment)
+	}
+	if diff := pretty.Diff(comments, []string{"some-key"}); diff != nil {
+		t.Errorf("incorrect keys; -got +want: %s", diff)
+	}
+
+	if err := cli.Lock([]byte("secret")); err != nil {
+		t.Errorf("failed to lock agent: %v", err)
+	}
+	if listed, err = cli.List(); err != nil || len(listed) != 0 {
+		t.Errorf("incorrect keys while locked: got %d keys, error %v", len(listed), err)
+	}
+	if err := cli.Unlock([]byte("secret")); err != nil {
+		t.Errorf("failed to unlock agent: %v", err)
+	}
+
+	if err := cli.RemoveAll(); err != nil {
+		t.Errorf("failed to remove keys: %v", err)
+	}
+	if listed, err = cli.List(); err != nil || len(listed) != 0 {
+		t.Errorf("incorrect keys after removal: got %d keys, error %v", len(listed), err)
+	}
+}
+
+func TestNewManifest(t *testing.T) {
+	got := NewManifest("/path/to/host", "some-extension")
+	want := &Manifest{
+		Name:           HostName,
+		Description:    "SSH Agent for Google Chrome",
+		Path:           "/path/to/host",
+		Type:           "stdio",
+		AllowedOrigins: []string{"chrome-extension://some-extension/"},
+	}
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect manifest; -got +want: %s", diff)
+	}
+}
+
+func TestRelayDisconnected(t *testing.T) {
+	r, w := io.Pipe()
+	relay := NewRelay(r, ioutil.Discard)
+	w.Close()
+
+	<-relay.Done()
+	if _, err := relay.Call([]byte{11}); err != ErrDisconnected {
+		t.Errorf("incorrect error; got %v, want %v", err, ErrDisconnected)
+	}
+}
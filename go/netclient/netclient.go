@@ -0,0 +1,298 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netclient sends the HTTPS requests made by the extension (e.g., to
+// fetch the provisioning manifest, or to ask a remote signer for a
+// signature).  Each attempt is bounded by a timeout, transient failures are
+// retried with increasing delays, and requests made while the browser is
+// offline wait in a queue until it is online again.
+//
+// Requests are made using the browser's fetch API (see chrome.C.Fetch).  It
+// never exposes the server's certificate chain or public key, and Chrome
+// offers no extension API that does, so certificates and SPKI hashes cannot
+// be pinned: servers are authenticated only by the browser's own certificate
+// verification.  Endpoints must use HTTPS for that to mean anything.
+package netclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout is how long each attempt waits for a response.
+	DefaultTimeout = 30 * time.Second
+	// DefaultAttempts is how many times a request is attempted before
+	// it fails.
+	DefaultAttempts = 3
+	// DefaultBackoff is how long the first retry waits; each later
+	// retry waits twice as long as the one before.
+	DefaultBackoff = time.Second
+	// DefaultQueueLimit is how many requests may wait for the browser to
+	// be online.
+	DefaultQueueLimit = 32
+	// DefaultQueueTimeout is how long a request waits for the browser to
+	// be online before it fails.
+	DefaultQueueTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrTimeout is returned when the server does not respond in time.
+	ErrTimeout = errors.New("request timed out")
+	// ErrOffline is returned when the browser is not online again before
+	// a queued request times out.
+	ErrOffline = errors.New("request failed: the browser is offline")
+	// ErrQueueFull is returned when too many requests are already
+	// waiting for the browser to be online.
+	ErrQueueFull = errors.New("request failed: too many requests are waiting for the browser to be online")
+)
+
+// Transport sends single HTTPS requests.  It is implemented by chrome.C; using
+// this interface allows for alternate implementations during testing.
+type Transport interface {
+	// Fetch retrieves the document at the specified URL, and invokes
+	// callback with its body.
+	Fetch(url string, callback func(body []byte, err error))
+	// Post sends body to the specified URL, and invokes callback with
+	// the body of the response.
+	Post(url string, body []byte, callback func(body []byte, err error))
+}
+
+// StatusError is implemented by errors returned by a Transport when the
+// server responds with an unsuccessful status (e.g., chrome.HTTPError).
+type StatusError interface {
+	error
+	// HTTPStatus returns the HTTP status of the response.
+	HTTPStatus() int
+}
+
+// Option customizes the behavior of a Client returned by New.
+type Option func(c *Client)
+
+// WithTimeout specifies how long each attempt waits for a response.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetries specifies how many times a request is attempted, and how long
+// the first retry waits.
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.attempts = attempts
+		c.backoff = backoff
+	}
+}
+
+// WithQueue specifies how many requests may wait for the browser to be
+// online, and for how long.
+func WithQueue(limit int, timeout time.Duration) Option {
+	return func(c *Client) {
+		c.queueLimit = limit
+		c.queueTimeout = timeout
+	}
+}
+
+// request is a request made using a Client.
+type request struct {
+	url string
+	// post is true for requests sent using Transport.Post.
+	post     bool
+	body     []byte
+	callback func(body []byte, err error)
+	// expire fails the request if it waits in the queue too long.
+	expire *time.Timer
+}
+
+// Client sends HTTPS requests using a Transport.  It implements Transport
+// itself, so it can be used wherever one is expected.
+type Client struct {
+	transport    Transport
+	timeout      time.Duration
+	attempts     int
+	backoff      time.Duration
+	queueLimit   int
+	queueTimeout time.Duration
+
+	mu sync.Mutex
+	// online is false while the browser is offline.
+	online bool
+	// queue contains the requests waiting for the browser to be online,
+	// oldest first.
+	queue []*request
+}
+
+// New returns a Client that sends requests using t.  The browser is assumed to
+// be online until SetOnline reports otherwise.
+func New(t Transport, opts ...Option) *Client {
+	c := &Client{
+		transport:    t,
+		timeout:      DefaultTimeout,
+		attempts:     DefaultAttempts,
+		backoff:      DefaultBackoff,
+		queueLimit:   DefaultQueueLimit,
+		queueTimeout: DefaultQueueTimeout,
+		online:       true,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// Fetch implements Transport.Fetch.
+func (c *Client) Fetch(url string, callback func(body []byte, err error)) {
+	c.send(&request{url: url, callback: callback})
+}
+
+// Post implements Transport.Post.
+func (c *Client) Post(url string, body []byte, callback func(body []byte, err error)) {
+	c.send(&request{url: url, post: true, body: body, callback: callback})
+}
+
+// SetOnline records whether the browser is online (see
+// chrome.C.OnOnlineChanged).  Queued requests are sent once it is.
+func (c *Client) SetOnline(online bool) {
+	c.mu.Lock()
+	c.online = online
+	var ready []*request
+	if online {
+		ready, c.queue = c.queue, nil
+	}
+	c.mu.Unlock()
+
+	for _, r := range ready {
+		r.expire.Stop()
+		c.attempt(r, 1)
+	}
+}
+
+// Queued returns the number of requests waiting for the browser to be online.
+func (c *Client) Queued() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queue)
+}
+
+// send sends a request, or queues it if the browser is offline.
+func (c *Client) send(r *request) {
+	c.mu.Lock()
+	if c.online {
+		c.mu.Unlock()
+		c.attempt(r, 1)
+		return
+	}
+	if len(c.queue) >= c.queueLimit {
+		c.mu.Unlock()
+		r.callback(nil, ErrQueueFull)
+		return
+	}
+	c.queue = append(c.queue, r)
+	r.expire = time.AfterFunc(c.queueTimeout, func() {
+		c.dequeue(r)
+	})
+	c.mu.Unlock()
+}
+
+// dequeue fails a request that waited in the queue too long.  It does
+// nothing if the request has already left the queue.
+func (c *Client) dequeue(r *request) {
+	c.mu.Lock()
+	found := false
+	for i, q := range c.queue {
+		if q == r {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			found = true
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	if found {
+		r.callback(nil, ErrOffline)
+	}
+}
+
+// attempt makes the nth attempt to send a request.
+func (c *Client) attempt(r *request, n int) {
+	var mu sync.Mutex
+	done := false
+	// finish returns true the first time it is invoked, so that the
+	// request completes exactly once whether it times out or not.
+	finish := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return false
+		}
+		done = true
+		return true
+	}
+
+	timer := time.AfterFunc(c.timeout, func() {
+		if finish() {
+			c.retry(r, n, ErrTimeout)
+		}
+	})
+	callback := func(body []byte, err error) {
+		if !finish() {
+			return
+		}
+		timer.Stop()
+		if err != nil {
+			c.retry(r, n, err)
+			return
+		}
+		r.callback(body, nil)
+	}
+	if r.post {
+		c.transport.Post(r.url, r.body, callback)
+	} else {
+		c.transport.Fetch(r.url, callback)
+	}
+}
+
+// retry retries a request after its nth attempt failed with err, if the
+// failure may be transient and attempts remain.
+func (c *Client) retry(r *request, n int, err error) {
+	if n >= c.attempts || !transient(err) {
+		r.callback(nil, err)
+		return
+	}
+	c.mu.Lock()
+	online := c.online
+	c.mu.Unlock()
+	if !online {
+		// The request likely failed because the browser went
+		// offline; it is sent once the browser is online again.
+		c.send(r)
+		return
+	}
+	time.AfterFunc(c.backoff<<uint(n-1), func() {
+		c.attempt(r, n+1)
+	})
+}
+
+// transient determines if a request that failed with err may succeed if it
+// is retried.  Requests the server refused are not retried, unless it is
+// overloaded or failed itself.
+func transient(err error) bool {
+	if s, ok := err.(StatusError); ok {
+		status := s.HTTPStatus()
+		return status == 429 || status >= 500
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netclient
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+)
+
+// statusError is returned by fakeTransport for an unsuccessful status.
+type statusError int
+
+func (e statusError) Error() string   { return fmt.Sprintf("request failed with status %d", int(e)) }
+func (e statusError) HTTPStatus() int { return int(e) }
+
+// fakeTransport responds to each request with the next of a sequence of
+// responses.  A nil response is never answered.
+type fakeTransport struct {
+	mu        sync.Mutex
+	responses []*response
+	requests  []string
+}
+
+type response struct {
+	body string
+	err  error
+}
+
+func (f *fakeTransport) respond(url string, callback func(body []byte, err error)) {
+	f.mu.Lock()
+	f.requests = append(f.requests, url)
+	var r *response
+	if len(f.responses) > 0 {
+		r, f.responses = f.responses[0], f.responses[1:]
+	}
+	f.mu.Unlock()
+	if r == nil {
+		return
+	}
+	if r.err != nil {
+		callback(nil, r.err)
+		return
+	}
+	callback([]byte(r.body), nil)
+}
+
+func (f *fakeTransport) Fetch(url string, callback func(body []byte, err error)) {
+	f.respond(url, callback)
+}
+
+func (f *fakeTransport) Post(url string, body []byte, callback func(body []byte, err error)) {
+	f.respond(url+" "+string(body), callback)
+}
+
+func (f *fakeTransport) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+// result is the outcome of a request.
+type result struct {
+	body string
+	err  error
+}
+
+// wait returns a callback for a request, and a channel receiving its result.
+func wait() (func(body []byte, err error), chan result) {
+	c := make(chan result, 1)
+	return func(body []byte, err error) {
+		c <- result{body: string(body), err: err}
+	}, c
+}
+
+func TestRetry(t *testing.T) {
+	testcases := []struct {
+		description string
+		responses   []*response
+		want        result
+		wantSent    int
+	}{
+		{
+			description: "success",
+			responses:   []*response{{body: "ok"}},
+			want:        result{body: "ok"},
+			wantSent:    1,
+		},
+		{
+			description: "network failure retried",
+			responses:   []*response{{err: errors.New("network error")}, {body: "ok"}},
+			want:        result{body: "ok"},
+			wantSent:    2,
+		},
+		{
+			description: "server failure retried",
+			responses:   []*response{{err: statusError(503)}, {err: statusError(429)}, {body: "ok"}},
+			want:        result{body: "ok"},
+			wantSent:    3,
+		},
+		{
+			description: "timeout retried",
+			responses:   []*response{nil, {body: "ok"}},
+			want:        result{body: "ok"},
+			wantSent:    2,
+		},
+		{
+			description: "refused request not retried",
+			responses:   []*response{{err: statusError(404)}, {body: "ok"}},
+			want:        result{err: statusError(404)},
+			wantSent:    1,
+		},
+		{
+			description: "attempts exhausted",
+			responses:   []*response{{err: statusError(500)}, {err: statusError(500)}, {err: statusError(502)}, {body: "ok"}},
+			want:        result{err: statusError(502)},
+			wantSent:    3,
+		},
+		{
+			description: "every attempt times out",
+			responses:   []*response{nil, nil, nil},
+			want:        result{err: ErrTimeout},
+			wantSent:    3,
+		},
+	}
+
+	for _, tc := range testcases {
+		transport := &fakeTransport{responses: tc.responses}
+		c := New(transport, WithTimeout(20*time.Millisecond), WithRetries(3, time.Millisecond))
+		callback, done := wait()
+		c.Fetch("https://example.com/doc", callback)
+		got := <-done
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect result; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(len(transport.sent()), tc.wantSent); diff != nil {
+			t.Errorf("%s: incorrect number of attempts; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestOfflineQueue(t *testing.T) {
+	transport := &fakeTransport{responses: []*response{{body: "first"}, {body: "second"}}}
+	c := New(transport, WithQueue(2, time.Minute))
+	c.SetOnline(false)
+
+	// Requests made while offline wait until the browser is online.
+	first, firstDone := wait()
+	c.Post("https://example.com/hook", []byte("1"), first)
+	second, secondDone := wait()
+	c.Post("https://example.com/hook", []byte("2"), second)
+	third, thirdDone := wait()
+	c.Post("https://example.com/hook", []byte("3"), third)
+	if diff := pretty.Diff(<-thirdDone, result{err: ErrQueueFull}); diff != nil {
+		t.Errorf("incorrect result when queue is full; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(transport.sent(), []string(nil)); diff != nil {
+		t.Errorf("requests sent while offline; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(c.Queued(), 2); diff != nil {
+		t.Errorf("incorrect number of queued requests; -got +want: %s", diff)
+	}
+
+	// They are sent in order once it is.
+	c.SetOnline(true)
+	if diff := pretty.Diff([]result{<-firstDone, <-secondDone}, []result{{body: "first"}, {body: "second"}}); diff != nil {
+		t.Errorf("incorrect results once online; -got +want: %s", diff)
+	}
+	want := []string{"https://example.com/hook 1", "https://example.com/hook 2"}
+	if diff := pretty.Diff(transport.sent(), want); diff != nil {
+		t.Errorf("incorrect requests once online; -got +want: %s", diff)
+	}
+	if diff := pretty.Diff(c.Queued(), 0); diff != nil {
+		t.Errorf("requests still queued once online; -got +want: %s", diff)
+	}
+}
+
+func TestOfflineQueueTimeout(t *testing.T) {
+	transport := &fakeTransport{responses: []*response{{body: "ok"}}}
+	c := New(transport, WithQueue(DefaultQueueLimit, 10*time.Millisecond))
+	c.SetOnline(false)
+
+	callback, done := wait()
+	c.Fetch("https://example.com/doc", callback)
+	if diff := pretty.Diff(<-done, result{err: ErrOffline}); diff != nil {
+		t.Errorf("incorrect result; -got +want: %s", diff)
+	}
+
+	// The request is not sent once the browser is online again.
+	c.SetOnline(true)
+	if diff := pretty.Diff(transport.sent(), []string(nil)); diff != nil {
+		t.Errorf("expired request sent; -got +want: %s", diff)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notify delivers notifications to the user through a channel
+// selected in settings: system notifications, an in-page toast on the
+// extension's pages, an alert on the toolbar icon, or not at all.
+// Headless and kiosk setups may not display system notifications, so the
+// channel can be changed per device.
+package notify
+
+import (
+	"fmt"
+	"log"
+)
+
+// Kind identifies a channel through which notifications are delivered.
+type Kind string
+
+const (
+	// System delivers notifications as system notifications.
+	System Kind = "system"
+	// Toast delivers notifications as a toast displayed on any open
+	// extension page (e.g., the options page).
+	Toast Kind = "toast"
+	// Badge delivers notifications as alerts on the extension's toolbar
+	// icon.
+	Badge Kind = "badge"
+	// None discards notifications.
+	None Kind = "none"
+
+	// DefaultKind is the channel used if none has been selected.  System
+	// notifications require an optional permission that is only requested
+	// when they are selected, so they are not the default.
+	DefaultKind = Badge
+)
+
+// Kinds lists the available channels, in the order they should be
+// displayed.
+var Kinds = []Kind{System, Toast, Badge, None}
+
+// Description returns a human-readable description of the channel.
+func (k Kind) Description() string {
+	switch k {
+	case System:
+		return "System notifications"
+	case Toast:
+		return "Messages on extension pages"
+	case Badge:
+		return "Alerts on the toolbar icon"
+	case None:
+		return "None"
+	}
+	return string(k)
+}
+
+// ParseKind parses the name of a channel.
+func ParseKind(s string) (Kind, error) {
+	for _, k := range Kinds {
+		if string(k) == s {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown notification channel %q", s)
+}
+
+// Notifier delivers notifications to the user.
+type Notifier interface {
+	// Notify delivers a notification with the specified title and
+	// message.  Delivery is best-effort; failures are logged.
+	Notify(title, message string)
+}
+
+// SystemAPI provides access to system notifications.  See
+// chrome.C.CreateNotification for details.
+type SystemAPI interface {
+	CreateNotification(title, message string, callback func(err error))
+}
+
+// system delivers notifications as system notifications.
+type system struct {
+	api SystemAPI
+}
+
+// NewSystem returns a Notifier that delivers notifications as system
+// notifications.
+func NewSystem(api SystemAPI) Notifier {
+	return &system{api: api}
+}
+
+// Notify implements Notifier.Notify.
+func (s *system) Notify(title, message string) {
+	s.api.CreateNotification(title, message, func(err error) {
+		if err != nil {
+			log.Printf("Failed to display notification %q: %v", title, err)
+		}
+	})
+}
+
+// Alerter raises alerts on the extension's toolbar icon.  See
+// toolbar.Status.Alert for details.
+type Alerter interface {
+	Alert(message string)
+}
+
+// badge delivers notifications as alerts on the toolbar icon.
+type badge struct {
+	alerter Alerter
+}
+
+// NewBadge returns a Notifier that delivers notifications as alerts on the
+// extension's toolbar icon.  The alerts are displayed in the icon's tooltip
+// until the user opens the extension's popup.
+func NewBadge(alerter Alerter) Notifier {
+	return &badge{alerter: alerter}
+}
+
+// Notify implements Notifier.Notify.
+func (b *badge) Notify(title, message string) {
+	b.alerter.Alert(fmt.Sprintf("%s: %s", title, message))
+}
+
+// none discards notifications.
+type none struct{}
+
+// NewNone returns a Notifier that discards notifications.
+func NewNone() Notifier {
+	return none{}
+}
+
+// Notify implements Notifier.Notify.
+func (none) Notify(title, message string) {}
@@ -0,0 +1,228 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/toolbar"
+	"github.com/kr/pretty"
+)
+
+func TestSystem(t *testing.T) {
+	api := fakes.NewNotifications()
+	n := NewSystem(api)
+	n.Notify("some-title", "some-message")
+	want := []*fakes.Notification{{Title: "some-title", Message: "some-message"}}
+	if diff := pretty.Diff(api.Displayed, want); diff != nil {
+		t.Errorf("incorrect notifications; -got +want: %s", diff)
+	}
+
+	// Failures are logged, but otherwise ignored.
+	api.Err = errors.New("failed")
+	n.Notify("other-title", "other-message")
+	if diff := pretty.Diff(api.Displayed, want); diff != nil {
+		t.Errorf("incorrect notifications; -got +want: %s", diff)
+	}
+}
+
+// fakeAlerter records the alerts raised on the toolbar icon.
+type fakeAlerter struct {
+	alerts []string
+}
+
+func (f *fakeAlerter) Alert(message string) {
+	f.alerts = append(f.alerts, message)
+}
+
+func TestBadge(t *testing.T) {
+	testcases := []struct {
+		description string
+		keys        int
+		count       int
+		want        fakes.Toolbar
+	}{
+		{
+			description: "one notification",
+			count:       1,
+			want:        fakes.Toolbar{Badge: "!", Color: "#d93025", Title: "No keys loaded\nsome-title: some-message"},
+		},
+		{
+			description: "several notifications",
+			count:       2,
+			want:        fakes.Toolbar{Badge: "!", Color: "#d93025", Title: "No keys loaded\nsome-title: some-message\nsome-title: some-message"},
+		},
+		{
+			description: "keys loaded",
+			keys:        2,
+			count:       1,
+			want:        fakes.Toolbar{Badge: "2", Color: "#d93025", Title: "2 keys loaded\nsome-title: some-message"},
+		},
+	}
+
+	for _, tc := range testcases {
+		tb := fakes.NewToolbar()
+		status := toolbar.New(tb)
+		status.KeyringChanged(&keyring.State{Keys: tc.keys})
+		n := NewBadge(status)
+		for i := 0; i < tc.count; i++ {
+			n.Notify("some-title", "some-message")
+		}
+		if diff := pretty.Diff(*tb, tc.want); diff != nil {
+			t.Errorf("%s: incorrect toolbar; -got +want: %s", tc.description, diff)
+		}
+
+		status.ClearAlerts()
+		if tb.Badge == "!" || tb.Color == tc.want.Color {
+			t.Errorf("%s: alerts not cleared; got badge %q, color %q", tc.description, tb.Badge, tb.Color)
+		}
+	}
+}
+
+func TestToast(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	var got []string
+	OnToast(hub, func(title, message string) {
+		got = append(got, title, message)
+	})
+
+	NewToast(hub).Notify("some-title", "some-message")
+	if diff := pretty.Diff(got, []string{"some-title", "some-message"}); diff != nil {
+		t.Errorf("incorrect toast; -got +want: %s", diff)
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	testcases := []struct {
+		s        string
+		wantKind Kind
+		wantErr  error
+	}{
+		{s: "system", wantKind: System},
+		{s: "toast", wantKind: Toast},
+		{s: "badge", wantKind: Badge},
+		{s: "none", wantKind: None},
+		{s: "bogus", wantErr: errors.New(`unknown notification channel "bogus"`)},
+	}
+
+	for _, tc := range testcases {
+		kind, err := ParseKind(tc.s)
+		if diff := pretty.Diff(kind, tc.wantKind); diff != nil {
+			t.Errorf("%s: incorrect kind; -got +want: %s", tc.s, diff)
+		}
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.s, diff)
+		}
+	}
+}
+
+func TestSelector(t *testing.T) {
+	testcases := []struct {
+		description string
+		stored      interface{}
+		kind        Kind
+		wantKind    Kind
+		wantWritten Kind
+		wantErr     error
+		wantSystem  int
+		wantAlerts  []string
+	}{
+		{
+			description: "default channel",
+			wantKind:    Badge,
+			wantAlerts:  []string{"some-title: some-message"},
+		},
+		{
+			description: "unknown channel stored",
+			stored:      "bogus",
+			wantKind:    Badge,
+			wantAlerts:  []string{"some-title: some-message"},
+		},
+		{
+			description: "select system",
+			kind:        System,
+			wantKind:    System,
+			wantWritten: System,
+			wantSystem:  1,
+		},
+		{
+			description: "select badge",
+			kind:        Badge,
+			wantKind:    Badge,
+			wantWritten: Badge,
+			wantAlerts:  []string{"some-title: some-message"},
+		},
+		{
+			description: "select none",
+			kind:        None,
+			wantKind:    None,
+			wantWritten: None,
+		},
+		{
+			description: "select unknown channel",
+			kind:        Kind("bogus"),
+			wantKind:    Badge,
+			wantErr:     errors.New(`unknown notification channel "bogus"`),
+			wantAlerts:  []string{"some-title: some-message"},
+		},
+	}
+
+	for _, tc := range testcases {
+		store := fakes.NewMemStorage()
+		if tc.stored != nil {
+			store.Set(map[string]interface{}{SettingKey: tc.stored}, func(err error) {})
+		}
+		api := fakes.NewNotifications()
+		alerter := &fakeAlerter{}
+		sel := NewSelector(map[Kind]Notifier{
+			System: NewSystem(api),
+			Badge:  NewBadge(alerter),
+			None:   NewNone(),
+		})
+
+		if tc.kind != "" {
+			WriteKind(store, tc.kind, func(err error) {
+				if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+					t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+				}
+			})
+		}
+		sel.Reload(store, func(err error) {
+			if err != nil {
+				t.Errorf("%s: failed to reload: %v", tc.description, err)
+			}
+		})
+		if diff := pretty.Diff(sel.Kind(), tc.wantKind); diff != nil {
+			t.Errorf("%s: incorrect kind; -got +want: %s", tc.description, diff)
+		}
+		store.GetItems([]string{SettingKey}, func(data map[string]interface{}, err error) {
+			got, _ := data[SettingKey].(string)
+			if tc.stored == nil && got != string(tc.wantWritten) {
+				t.Errorf("%s: incorrect stored kind; got %q, want %q", tc.description, got, tc.wantWritten)
+			}
+		})
+
+		sel.Notify("some-title", "some-message")
+		if diff := pretty.Diff(len(api.Displayed), tc.wantSystem); diff != nil {
+			t.Errorf("%s: incorrect system notifications; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(alerter.alerts, tc.wantAlerts); diff != nil {
+			t.Errorf("%s: incorrect alerts; -got +want: %s", tc.description, diff)
+		}
+	}
+}
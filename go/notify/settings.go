@@ -0,0 +1,104 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/storage"
+)
+
+const (
+	// SettingKey is the key under which the selected channel is stored.
+	// It is stored per-device; it is never synced.
+	SettingKey = "notify.channel"
+)
+
+// ReadKind returns the channel selected in settings, or DefaultKind if none
+// (or an unknown channel) has been selected.  callback is invoked with the
+// result.
+func ReadKind(store storage.Settings, callback func(kind Kind, err error)) {
+	store.GetItems([]string{SettingKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(DefaultKind, fmt.Errorf("failed to read notification channel: %v", err))
+			return
+		}
+		s, _ := data[SettingKey].(string)
+		kind, err := ParseKind(s)
+		if err != nil {
+			kind = DefaultKind
+		}
+		callback(kind, nil)
+	})
+}
+
+// WriteKind stores the channel selected in settings.  callback is invoked
+// when complete.
+func WriteKind(store storage.Settings, kind Kind, callback func(err error)) {
+	if _, err := ParseKind(string(kind)); err != nil {
+		callback(err)
+		return
+	}
+	store.Set(map[string]interface{}{SettingKey: string(kind)}, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to write notification channel: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// Selector is a Notifier that delivers notifications through the channel
+// selected in settings.
+type Selector struct {
+	channels map[Kind]Notifier
+	kind     Kind
+}
+
+// NewSelector returns a Selector that delivers notifications through one of
+// the supplied channels.  DefaultKind is used until Reload is invoked.
+func NewSelector(channels map[Kind]Notifier) *Selector {
+	return &Selector{
+		channels: channels,
+		kind:     DefaultKind,
+	}
+}
+
+// Kind returns the channel through which notifications are delivered.
+func (s *Selector) Kind() Kind {
+	return s.kind
+}
+
+// Reload reads the channel selected in settings, and uses it for subsequent
+// notifications.  It should be invoked at startup, and whenever the setting
+// changes.  callback is invoked when complete.
+func (s *Selector) Reload(store storage.Settings, callback func(err error)) {
+	ReadKind(store, func(kind Kind, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		s.kind = kind
+		callback(nil)
+	})
+}
+
+// Notify implements Notifier.Notify.  Notifications are discarded if no
+// implementation was supplied for the selected channel.
+func (s *Selector) Notify(title, message string) {
+	if n := s.channels[s.kind]; n != nil {
+		n.Notify(title, message)
+	}
+}
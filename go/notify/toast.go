@@ -0,0 +1,86 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// Define a distinct type for each message.  These are embedded in each
+// message, and are distinct from those used by keys.Server and
+// bridge.Server.
+const (
+	msgTypeToast int = 3000 + iota
+)
+
+// msgToast is broadcast to the extension's pages to display a toast.
+type msgToast struct {
+	*js.Object
+	Type    int    `js:"type"`
+	Title   string `js:"title"`
+	Message string `js:"message"`
+}
+
+// MessageSender sends messages within the extension.  See
+// chrome.C.SendMessage and chrome.C.Error for details.
+type MessageSender interface {
+	SendMessage(msg interface{}, callback func(rsp *js.Object))
+	Error() error
+}
+
+// MessageReceiver receives messages sent within the extension.  See
+// chrome.C.OnMessage for details.
+type MessageReceiver interface {
+	OnMessage(callback func(header *js.Object, sender *js.Object, sendResponse func(interface{})) bool)
+}
+
+// toast delivers notifications as a toast on the extension's open pages.
+type toast struct {
+	msg MessageSender
+}
+
+// NewToast returns a Notifier that broadcasts notifications to the
+// extension's open pages, which display them using OnToast.  Notifications
+// are lost if no page is open.
+func NewToast(msg MessageSender) Notifier {
+	return &toast{msg: msg}
+}
+
+// Notify implements Notifier.Notify.
+func (t *toast) Notify(title, message string) {
+	m := &msgToast{Object: js.Global.Get("Object").New()}
+	m.Type = msgTypeToast
+	m.Title = title
+	m.Message = message
+	t.msg.SendMessage(m, func(rsp *js.Object) {
+		// Pages never respond, so an error is always reported; it
+		// is read only so that Chrome does not log it as unchecked.
+		t.msg.Error()
+	})
+}
+
+// OnToast installs a callback that is invoked with each notification
+// delivered by a Notifier returned from NewToast.  It is used by extension
+// pages to display the notifications.
+func OnToast(msg MessageReceiver, callback func(title, message string)) {
+	msg.OnMessage(func(header *js.Object, sender *js.Object, sendResponse func(interface{})) bool {
+		m := &msgToast{Object: header}
+		if m.Type != msgTypeToast {
+			return false
+		}
+		callback(m.Title, m.Message)
+		return false
+	})
+}
@@ -15,18 +15,79 @@
 package main
 
 import (
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/bridge"
 	"github.com/google/chrome-ssh-agent/go/chrome"
 	"github.com/google/chrome-ssh-agent/go/dom"
+	"github.com/google/chrome-ssh-agent/go/external"
+	"github.com/google/chrome-ssh-agent/go/faults"
 	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/netclient"
+	"github.com/google/chrome-ssh-agent/go/notify"
 	"github.com/google/chrome-ssh-agent/go/optionsui"
+	"github.com/google/chrome-ssh-agent/go/presence"
+	"github.com/google/chrome-ssh-agent/go/provisioning"
+	"github.com/google/chrome-ssh-agent/go/selfcheck"
 	"github.com/google/chrome-ssh-agent/go/testing"
+	"github.com/google/chrome-ssh-agent/go/toolbar"
 )
 
 func main() {
 	c := chrome.New(nil)
+	// Inject faults into storage if configured during development (see
+	// the faults package), so that loading states can be exercised.
+	injector := faults.New()
+	injector.Load(c.LocalStorage(), nil)
+	c.LocalStorage().OnChanged(func(changes map[string]interface{}) {
+		if faults.Changed(changes) {
+			injector.Load(c.LocalStorage(), nil)
+		}
+	})
+	syncStorage := injector.Wrap(c.SyncStorage(), "sync")
+	localStorage := injector.Wrap(c.LocalStorage(), "local")
 	mgr := keys.NewClient(c)
 	d := dom.New(dom.Doc)
-	ui := optionsui.New(mgr, d)
+	acl := bridge.NewACL(localStorage, c)
+	extensions := external.NewACL(localStorage, c)
+	approvals := provisioning.New(localStorage, netclient.New(c), nil)
+	auditLog := audit.NewLog(localStorage, audit.DefaultSize)
+	dir := presence.New(syncStorage, localStorage)
+	ui := optionsui.New(mgr, acl, extensions, approvals, auditLog, localStorage, c, dir, keys.NewWipeClient(c), c.ExtensionID(), d)
+
+	// Display notifications delivered as toasts, and clear any alerts
+	// from the toolbar icon now that the user has looked.
+	notify.OnToast(c, ui.ShowToast)
+	toolbar.ClearAlerts(c)
+
+	// Changes to keys are made by the background page on behalf of every
+	// window.  Redisplay keys when they change, including changes made
+	// from another window or synced from another device, and when
+	// another device changes the keys it reports as loaded.
+	refresh := func(changes map[string]interface{}) {
+		if keys.KeysChanged(changes) || presence.Changed(changes) {
+			ui.Refresh()
+		}
+	}
+	c.SyncStorage().OnChanged(refresh)
+	c.LocalStorage().OnChanged(refresh)
+
+	// Display the outcome of the self-check when the background page
+	// completes it.
+	c.LocalStorage().OnChanged(func(changes map[string]interface{}) {
+		if selfcheck.ReportChanged(changes) {
+			ui.RefreshSelfCheck()
+		}
+		if keys.UpgradeReportChanged(changes) {
+			ui.RefreshUpgrade()
+		}
+	})
+
+	// Display extensions as they ask to connect.
+	c.LocalStorage().OnChanged(func(changes map[string]interface{}) {
+		if external.Changed(changes) {
+			ui.RefreshExtensions()
+		}
+	})
 
 	qs := dom.NewURLSearchParams(dom.DefaultQueryString())
 	if qs.Has("test") {
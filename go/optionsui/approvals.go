@@ -0,0 +1,36 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// submitApproval stores the approval for a key pasted by the user, as
+// returned by their administrator.
+func (u *UI) submitApproval() {
+	u.dom.RemoveChildren(u.approvalStatus)
+	u.approvals.Approve(u.dom.Value(u.approvalInput), func(fingerprint string, err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to store approval"))
+			return
+		}
+		u.setError(nil)
+		u.dom.SetValue(u.approvalInput, "")
+		u.dom.AppendChild(u.approvalStatus, u.dom.NewText(fmt.Sprintf("Key %s is approved; it may now be loaded.", fingerprint)), nil)
+	})
+}
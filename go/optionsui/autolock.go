@@ -0,0 +1,86 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// autoLockIdleText describes an idle period after which the agent locks,
+// for display to the user.
+func autoLockIdleText(d time.Duration) string {
+	if d <= 0 {
+		return "Never"
+	}
+	return lifetimeText(d)
+}
+
+// populateAutoLock populates the choices for locking the agent
+// automatically, and selects those in the auto-lock policy.
+func (u *UI) populateAutoLock() {
+	u.dom.RemoveChildren(u.autoLockIdle)
+	for _, d := range keys.AutoLockIdlePeriods {
+		d := d
+		u.dom.AppendChild(u.autoLockIdle, u.dom.NewElement("option"), func(opt *js.Object) {
+			opt.Set("value", strconv.FormatInt(int64(d/time.Second), 10))
+			u.dom.AppendChild(opt, u.dom.NewText(autoLockIdleText(d)), nil)
+		})
+	}
+	u.dom.RemoveChildren(u.autoLockAction)
+	for _, a := range keys.AutoLockActions {
+		a := a
+		u.dom.AppendChild(u.autoLockAction, u.dom.NewElement("option"), func(opt *js.Object) {
+			opt.Set("value", string(a))
+			u.dom.AppendChild(opt, u.dom.NewText(a.Description()), nil)
+		})
+	}
+	u.mgr.AutoLockPolicy(func(policy *keys.AutoLockPolicy, err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to read auto-lock policy"))
+			return
+		}
+		u.dom.SetChecked(u.autoLockScreen, policy.OnScreenLock)
+		u.dom.SetValue(u.autoLockIdle, strconv.FormatInt(int64(policy.Idle/time.Second), 10))
+		u.dom.SetValue(u.autoLockAction, string(policy.Action))
+	})
+}
+
+// setAutoLock stores the auto-lock policy selected by the user.
+func (u *UI) setAutoLock() {
+	secs, err := strconv.ParseInt(u.dom.Value(u.autoLockIdle), 10, 64)
+	if err != nil {
+		u.setError(help.Wrap(err, "failed to parse idle period"))
+		u.populateAutoLock()
+		return
+	}
+	policy := &keys.AutoLockPolicy{
+		OnScreenLock: u.dom.Checked(u.autoLockScreen),
+		Idle:         time.Duration(secs) * time.Second,
+		Action:       keys.AutoLockAction(u.dom.Value(u.autoLockAction)),
+	}
+	u.mgr.SetAutoLockPolicy(policy, func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to change auto-lock policy"))
+			u.populateAutoLock()
+			return
+		}
+		u.setError(nil)
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// removeAll removes all configured keys.  A dialog lists the keys that will
+// be removed, and prompts the user to confirm.
+func (u *UI) removeAll() {
+	u.bulk(keys.PlanRemoveAll, "Remove all", "removed")
+}
+
+// unloadAll unloads all keys from the agent.  A dialog lists the keys that
+// will be unloaded, and prompts the user to confirm.
+func (u *UI) unloadAll() {
+	u.bulk(keys.PlanUnloadAll, "Unload all", "unloaded")
+}
+
+// bulk performs a destructive operation on many keys.  The operation is
+// first planned without making changes, and the affected keys are listed in
+// a dialog.  If the user confirms, exactly the listed keys are changed, and
+// the outcome is displayed with the given title.  verb describes the change
+// (e.g., 'removed').
+func (u *UI) bulk(plan func(mgr keys.Manager, callback func(plan *keys.Plan, err error)), title, verb string) {
+	plan(u.mgr, func(p *keys.Plan, err error) {
+		if err != nil {
+			u.setError(err)
+			return
+		}
+		if len(p.Changes) == 0 {
+			u.setError(fmt.Errorf("no keys to be %s", verb))
+			return
+		}
+		u.promptBulk(p, verb, func(yes bool) {
+			if !yes {
+				return
+			}
+			p.Apply(u.mgr, func(result *keys.Result) {
+				u.setError(result.Err())
+				u.showResult(title, result)
+				u.updateKeys()
+			})
+		})
+	})
+}
+
+// promptBulk displays a dialog listing the keys changed by the plan, and
+// prompting the user to confirm.  callback is invoked when the dialog is
+// closed; the yes parameter indicates if the user confirmed.
+func (u *UI) promptBulk(p *keys.Plan, verb string, callback func(yes bool)) {
+	u.dom.AppendChild(u.bulkSummary, u.dom.NewText(fmt.Sprintf("The following %d keys will be %s:", len(p.Changes), verb)), nil)
+	for _, c := range p.Changes {
+		c := c
+		u.dom.AppendChild(u.bulkList, u.dom.NewElement("li"), func(item *js.Object) {
+			u.dom.AppendChild(item, u.dom.NewText(c.Name), nil)
+		})
+	}
+	done := func(yes bool) {
+		u.dom.RemoveChildren(u.bulkSummary)
+		u.dom.RemoveChildren(u.bulkList)
+		u.bulkYes = u.dom.RemoveEventListeners(u.bulkYes)
+		u.bulkNo = u.dom.RemoveEventListeners(u.bulkNo)
+		u.dom.Close(u.bulkDialog)
+		callback(yes)
+	}
+	u.dom.OnClick(u.bulkYes, func() { done(true) })
+	u.dom.OnClick(u.bulkNo, func() { done(false) })
+	u.dom.ShowModal(u.bulkDialog)
+}
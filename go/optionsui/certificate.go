@@ -0,0 +1,47 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// certificateID returns the ID of the element listing the warnings for the
+// certificate of the key with the specified ID.
+func certificateID(id keys.ID) string {
+	return fmt.Sprintf("keyCertificate-%s", id)
+}
+
+// renderCertificateWarnings lists the restrictions and problems with the
+// certificate of the key with the specified ID beneath its name, so that the
+// user learns why a server may reject it before trying to log in.
+func (u *UI) renderCertificateWarnings(cell *js.Object, id keys.ID, warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+	u.dom.AppendChild(cell, u.dom.NewElement("ul"), func(list *js.Object) {
+		list.Set("className", "keyCertificate")
+		list.Set("id", certificateID(id))
+		for _, w := range warnings {
+			w := w
+			u.dom.AppendChild(list, u.dom.NewElement("li"), func(item *js.Object) {
+				u.dom.AppendChild(item, u.dom.NewText(w), nil)
+			})
+		}
+	})
+}
@@ -0,0 +1,125 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"errors"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/softtoken"
+)
+
+// derive derives a key from a passphrase and service name.  It displays a
+// dialog prompting the user for the service name and passphrase, then a
+// dialog displaying the fingerprint of the derived key.  If the user confirms
+// the fingerprint, the key is loaded into the agent; it is never stored.
+func (u *UI) derive() {
+	u.promptDerive(func(service, passphrase, confirm string, ok bool) {
+		if !ok {
+			return
+		}
+		if passphrase != confirm {
+			u.setError(errors.New("Passphrases do not match"))
+			return
+		}
+		u.deriveKey(service, passphrase)
+	})
+}
+
+// deriveKey derives a key from the passphrase and service name, and loads it
+// into the agent once the user has confirmed its fingerprint.
+func (u *UI) deriveKey(service, passphrase string) {
+	priv, err := softtoken.Derive(passphrase, service)
+	if err != nil {
+		u.setError(err)
+		return
+	}
+	fp, err := softtoken.Fingerprint(priv)
+	if err != nil {
+		u.setError(err)
+		return
+	}
+
+	name := softtoken.NormalizeService(service)
+	u.promptFingerprint(name, fp, func(ok bool) {
+		if !ok {
+			return
+		}
+		pemKey, err := softtoken.MarshalPEM(priv, name)
+		if err != nil {
+			u.setError(err)
+			return
+		}
+		u.mgr.LoadEphemeral(name, pemKey, func(err error) {
+			if err != nil {
+				u.setError(help.Wrap(err, "failed to load derived key"))
+				return
+			}
+			u.setError(nil)
+			u.updateKeys()
+		})
+	})
+}
+
+// promptDerive displays a dialog prompting the user for a service name, and
+// for a passphrase (entered twice).  callback is invoked when the dialog is
+// closed; the ok parameter indicates if the user clicked OK.
+func (u *UI) promptDerive(callback func(service, passphrase, confirm string, ok bool)) {
+	reset := func() {
+		u.dom.SetValue(u.deriveService, "")
+		u.dom.SetValue(u.derivePassphrase, "")
+		u.dom.SetValue(u.deriveConfirm, "")
+		u.deriveOk = u.dom.RemoveEventListeners(u.deriveOk)
+		u.deriveCancel = u.dom.RemoveEventListeners(u.deriveCancel)
+		u.dom.Close(u.deriveDialog)
+	}
+	u.dom.OnClick(u.deriveOk, func() {
+		s := u.dom.Value(u.deriveService)
+		p := u.dom.Value(u.derivePassphrase)
+		c := u.dom.Value(u.deriveConfirm)
+		reset()
+		callback(s, p, c, true)
+	})
+	u.dom.OnClick(u.deriveCancel, func() {
+		reset()
+		callback("", "", "", false)
+	})
+	u.dom.ShowModal(u.deriveDialog)
+}
+
+// promptFingerprint displays a dialog showing the fingerprint of the key
+// derived for the named service, so the user can confirm that they entered
+// the same passphrase as before.  callback is invoked when the dialog is
+// closed; the ok parameter indicates if the user chose to load the key.
+func (u *UI) promptFingerprint(name, fingerprint string, callback func(ok bool)) {
+	u.dom.RemoveChildren(u.fingerprintName)
+	u.dom.AppendChild(u.fingerprintName, u.dom.NewText(name), nil)
+	u.dom.RemoveChildren(u.fingerprintValue)
+	u.dom.AppendChild(u.fingerprintValue, u.dom.NewText(fingerprint), nil)
+	reset := func() {
+		u.fingerprintLoad = u.dom.RemoveEventListeners(u.fingerprintLoad)
+		u.fingerprintCancel = u.dom.RemoveEventListeners(u.fingerprintCancel)
+		u.dom.Close(u.fingerprintDialog)
+	}
+	u.dom.OnClick(u.fingerprintLoad, func() {
+		reset()
+		callback(true)
+	})
+	u.dom.OnClick(u.fingerprintCancel, func() {
+		reset()
+		callback(false)
+	})
+	u.dom.ShowModal(u.fingerprintDialog)
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/diagnostics"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/selfcheck"
+)
+
+const (
+	// recentErrors is the number of errors displayed to the user that
+	// are retained for inclusion in a diagnostic bundle.
+	recentErrors = 20
+)
+
+// diagnosticSettingKeys are the keys of the settings included in a
+// diagnostic bundle.
+var diagnosticSettingKeys = append(append([]string(nil), diagnostics.DefaultSettingKeys...), fileOnlySettingKey)
+
+// diagnosticBundle collects a diagnostic bundle describing the state of the
+// extension.  callback is invoked with the result.
+func (u *UI) diagnosticBundle(now time.Time, callback func(b *diagnostics.Bundle)) {
+	selfcheck.ReadReport(u.settings, func(r *selfcheck.Report, err error) {
+		if err != nil {
+			// The bundle is still useful without the report.
+			u.setError(err)
+		}
+		keys.ReadUpgradeReport(u.settings, func(upgrade *keys.UpgradeResult, err error) {
+			if err != nil {
+				u.setError(err)
+			}
+			diagnostics.Collect(&diagnostics.Sources{
+				Keys:        u.mgr,
+				Settings:    u.settings,
+				SettingKeys: diagnosticSettingKeys,
+				Audit:       u.auditLog,
+				Errors:      u.errors,
+				SelfCheck:   r,
+				Upgrade:     upgrade,
+				Platform:    diagnostics.CurrentPlatform(),
+			}, now, callback)
+		})
+	})
+}
+
+// RefreshUpgrade displays the keys most recently upgraded from formats used
+// by older versions.  It should be invoked when a new report is stored (see
+// keys.UpgradeReportChanged).
+func (u *UI) RefreshUpgrade() {
+	keys.ReadUpgradeReport(u.settings, func(r *keys.UpgradeResult, err error) {
+		if err != nil {
+			u.setError(err)
+			return
+		}
+
+		u.dom.RemoveChildren(u.diagnosticsUpgrade)
+		if r == nil {
+			u.dom.AppendChild(u.diagnosticsUpgrade, u.dom.NewText("No keys have needed upgrading from older versions."), nil)
+			return
+		}
+		upgraded := time.Unix(0, r.Time*int64(time.Millisecond)).Format(time.RFC1123)
+		u.dom.AppendChild(u.diagnosticsUpgrade, u.dom.NewText(fmt.Sprintf("Upgraded %d configured and %d loaded keys from older versions (%s).", len(r.Keys), len(r.Loaded), upgraded)), nil)
+	})
+}
+
+// downloadDiagnostics saves a diagnostic bundle, which the user may attach
+// to a bug report.  The bundle never includes private key material.
+func (u *UI) downloadDiagnostics() {
+	now := time.Now()
+	u.diagnosticBundle(now, func(b *diagnostics.Bundle) {
+		enc, err := diagnostics.Encode(b)
+		if err != nil {
+			u.setError(fmt.Errorf("failed to create diagnostic bundle: %v", err))
+			return
+		}
+		u.dom.Download(diagnostics.Filename(now), "application/json", enc)
+	})
+}
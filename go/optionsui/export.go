@@ -0,0 +1,125 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"errors"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// populatePrivateFormats populates the list of formats in which a private
+// key may be exported.
+func (u *UI) populatePrivateFormats() {
+	u.dom.RemoveChildren(u.exportFormat)
+	for _, f := range keyformat.PrivateFormats {
+		u.dom.AppendChild(u.exportFormat, u.dom.NewElement("option"), func(opt *js.Object) {
+			opt.Set("value", string(f))
+			u.dom.AppendChild(opt, u.dom.NewText(f.Description()), nil)
+		})
+	}
+	u.dom.SetValue(u.exportFormat, string(keyformat.PrivateOpenSSH))
+}
+
+// exportKey exports the private key for a configured key.  A dialog warns
+// the user and prompts for the format, the key's current passphrase (if it
+// is encrypted), and a new passphrase (entered twice) used to encrypt the
+// exported key.  The exported key is then displayed so it may be copied.
+func (u *UI) exportKey(k *displayedKey) {
+	ck := u.configured[k.ID]
+	if ck == nil {
+		u.setError(errors.New("only configured keys may be exported"))
+		return
+	}
+
+	u.promptExport(k.Name, ck.Encrypted, func(format keyformat.PrivateFormat, passphrase, exportPassphrase, confirm string, ok bool) {
+		if !ok {
+			return
+		}
+		if exportPassphrase == "" {
+			u.setError(errors.New("A passphrase is required to export a key"))
+			return
+		}
+		if exportPassphrase != confirm {
+			u.setError(errors.New("Passphrases do not match"))
+			return
+		}
+		u.mgr.Export(k.ID, passphrase, format, exportPassphrase, func(encoded string, err error) {
+			if err != nil {
+				u.setError(help.Wrap(err, "failed to export key"))
+				return
+			}
+			u.setError(nil)
+			u.showExported(k.Name, encoded)
+		})
+	})
+}
+
+// promptExport displays a dialog warning the user that the private key for
+// the named key is about to be exported, and prompting for the format and
+// passphrases.  The current passphrase is only requested if the stored key
+// is encrypted.  callback is invoked when the dialog is closed; the ok
+// parameter indicates if the user clicked Export.
+func (u *UI) promptExport(name string, encrypted bool, callback func(format keyformat.PrivateFormat, passphrase, exportPassphrase, confirm string, ok bool)) {
+	u.dom.RemoveChildren(u.exportName)
+	u.dom.AppendChild(u.exportName, u.dom.NewText(name), nil)
+	u.exportCurrent.Set("hidden", !encrypted)
+	reset := func() {
+		u.dom.RemoveChildren(u.exportName)
+		u.dom.SetValue(u.exportFormat, string(keyformat.PrivateOpenSSH))
+		u.dom.SetValue(u.exportPassphrase, "")
+		u.dom.SetValue(u.exportNewPassphrase, "")
+		u.dom.SetValue(u.exportConfirm, "")
+		u.exportOk = u.dom.RemoveEventListeners(u.exportOk)
+		u.exportCancel = u.dom.RemoveEventListeners(u.exportCancel)
+		u.dom.Close(u.exportDialog)
+	}
+	u.dom.OnClick(u.exportOk, func() {
+		f := keyformat.PrivateFormat(u.dom.Value(u.exportFormat))
+		p := u.dom.Value(u.exportPassphrase)
+		n := u.dom.Value(u.exportNewPassphrase)
+		c := u.dom.Value(u.exportConfirm)
+		reset()
+		callback(f, p, n, c, true)
+	})
+	u.dom.OnClick(u.exportCancel, func() {
+		reset()
+		callback("", "", "", "", false)
+	})
+	u.dom.ShowModal(u.exportDialog)
+}
+
+// showExported displays a dialog containing the exported private key for the
+// named key, which may be copied to the clipboard.  The key is cleared when
+// the dialog is closed.
+func (u *UI) showExported(name, encoded string) {
+	u.dom.RemoveChildren(u.exportedName)
+	u.dom.AppendChild(u.exportedName, u.dom.NewText(name), nil)
+	u.dom.SetValue(u.exportedText, encoded)
+	u.dom.OnClick(u.exportedCopy, func() {
+		if !u.clipboard.Copy(u.exportedText) {
+			u.setError(errors.New("Failed to copy to clipboard; copy the text manually"))
+		}
+	})
+	u.dom.OnClick(u.exportedClose, func() {
+		u.dom.SetValue(u.exportedText, "")
+		u.exportedCopy = u.dom.RemoveEventListeners(u.exportedCopy)
+		u.exportedClose = u.dom.RemoveEventListeners(u.exportedClose)
+		u.dom.Close(u.exportedDialog)
+	})
+	u.dom.ShowModal(u.exportedDialog)
+}
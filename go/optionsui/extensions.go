@@ -0,0 +1,232 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/external"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/permissions"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// ExtensionRow describes a row of the connected extensions table.
+type ExtensionRow struct {
+	// ID is the ID of the extension.
+	ID string
+	// Name is the name of the extension, or a placeholder if it is
+	// unknown.
+	Name string
+	// Status describes whether the extension may connect.
+	Status string
+	// Approve indicates that the extension may be approved.
+	Approve bool
+	// Deny indicates that the extension may be denied.
+	Deny bool
+	// Forget indicates that the decision for the extension may be
+	// removed, so that the user is asked again.
+	Forget bool
+	// Scopes lists each scope, and whether it is granted.  It is empty
+	// unless the extension may connect.
+	Scopes []*ScopeRow
+	// EditScopes indicates that the scopes may be granted or revoked.
+	EditScopes bool
+}
+
+// ScopeRow describes whether a scope is granted to an extension.
+type ScopeRow struct {
+	// Scope is the scope.
+	Scope external.Scope
+	// Label describes the scope.
+	Label string
+	// Granted indicates that the scope is granted.
+	Granted bool
+}
+
+// scopeLabels describes each scope.
+var scopeLabels = map[external.Scope]string{
+	external.ScopeListKeys: "List keys",
+	external.ScopeSign:     "Sign",
+	external.ScopeAddKey:   "Add keys",
+}
+
+// scopeRows returns whether each scope is granted, given the granted
+// scopes.
+func scopeRows(granted []external.Scope) []*ScopeRow {
+	var result []*ScopeRow
+	for _, s := range external.AllScopes {
+		r := &ScopeRow{Scope: s, Label: scopeLabels[s]}
+		for _, g := range granted {
+			if g == s {
+				r.Granted = true
+			}
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// extensionRows returns the rows of the connected extensions table.
+func extensionRows(extensions []*external.Extension) []*ExtensionRow {
+	var result []*ExtensionRow
+	for _, e := range extensions {
+		r := &ExtensionRow{ID: e.ID, Name: e.Name}
+		if r.Name == "" {
+			r.Name = "Unknown extension"
+		}
+		switch {
+		case e.Trusted:
+			r.Status = "Always allowed"
+			r.Scopes = scopeRows(e.Scopes)
+		case e.Decision == external.Pending:
+			r.Status = fmt.Sprintf("Waiting for approval since %s", e.Requested.Format("2006-01-02 15:04"))
+			r.Approve = true
+			r.Deny = true
+		case e.Decision == external.Approved:
+			r.Status = "Approved"
+			r.Forget = true
+			r.Scopes = scopeRows(e.Scopes)
+			r.EditScopes = true
+		case e.Decision == external.Denied:
+			r.Status = "Denied"
+			r.Forget = true
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// extensionButtonID returns the value of the 'id' attribute to be assigned
+// to a button acting on an extension.
+func extensionButtonID(action, id string) string {
+	return fmt.Sprintf("%s-extension-%s", action, id)
+}
+
+// extensionScopeID returns the value of the 'id' attribute to be assigned
+// to the checkbox granting a scope to an extension.
+func extensionScopeID(scope external.Scope, id string) string {
+	return fmt.Sprintf("scope-%s-extension-%s", scope, id)
+}
+
+// showExtensionNames requests permission to read the names of other
+// extensions, then redisplays the connected extensions.
+func (u *UI) showExtensionNames() {
+	permissions.Enable(u.perms, permissions.Management, func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to show extension names"))
+			return
+		}
+		u.setError(nil)
+		u.updateExtensions()
+	})
+}
+
+// decideExtension performs an action (e.g., external.ACL.Approve) on the
+// extension with the specified ID.
+func (u *UI) decideExtension(id string, action func(id string, callback func(err error)), desc string) {
+	action(id, func(err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to %s extension: %v", desc, err))
+			return
+		}
+		u.setError(nil)
+		u.updateExtensions()
+	})
+}
+
+// setExtensionScope grants or revokes a scope for the extension with the
+// specified ID.
+func (u *UI) setExtensionScope(id string, scope external.Scope, grant bool) {
+	u.extensions.SetScope(id, scope, grant, func(err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to change extension access: %v", err))
+		} else {
+			u.setError(nil)
+		}
+		u.updateExtensions()
+	})
+}
+
+// RefreshExtensions redisplays the connected extensions (e.g., because
+// another extension asked to connect).
+func (u *UI) RefreshExtensions() {
+	u.updateExtensions()
+}
+
+// updateExtensions queries the extensions that have asked to connect, then
+// refreshes the UI to reflect them.
+func (u *UI) updateExtensions() {
+	u.extensions.Extensions(func(extensions []*external.Extension, err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to get connected extensions: %v", err))
+			return
+		}
+
+		u.dom.RemoveChildren(u.extensionsData)
+		for _, r := range extensionRows(extensions) {
+			r := r
+			u.dom.AppendChild(u.extensionsData, u.dom.NewElement("tr"), func(row *js.Object) {
+				u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
+					cell.Set("title", r.ID)
+					u.dom.AppendChild(cell, u.dom.NewText(r.Name), nil)
+				})
+				u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
+					u.dom.AppendChild(cell, u.dom.NewText(r.Status), nil)
+				})
+				u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
+					for _, sc := range r.Scopes {
+						sc := sc
+						u.dom.AppendChild(cell, u.dom.NewElement("label"), func(label *js.Object) {
+							label.Set("className", "extensionScope")
+							u.dom.AppendChild(label, u.dom.NewElement("input"), func(box *js.Object) {
+								box.Set("type", "checkbox")
+								box.Set("id", extensionScopeID(sc.Scope, r.ID))
+								box.Set("disabled", !r.EditScopes)
+								u.dom.SetChecked(box, sc.Granted)
+								u.dom.OnChange(box, func() {
+									u.setExtensionScope(r.ID, sc.Scope, u.dom.Checked(box))
+								})
+							})
+							u.dom.AppendChild(label, u.dom.NewText(sc.Label), nil)
+						})
+					}
+				})
+				u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
+					button := func(action, label string, show bool, onClick func()) {
+						if !show {
+							return
+						}
+						u.dom.AppendChild(cell, u.dom.NewElement("button"), func(btn *js.Object) {
+							btn.Set("type", "button")
+							btn.Set("id", extensionButtonID(action, r.ID))
+							u.dom.AppendChild(btn, u.dom.NewText(label), nil)
+							u.dom.OnClick(btn, onClick)
+						})
+					}
+					button("approve", "Approve", r.Approve, func() {
+						u.decideExtension(r.ID, u.extensions.Approve, "approve")
+					})
+					button("deny", "Deny", r.Deny, func() {
+						u.decideExtension(r.ID, u.extensions.Deny, "deny")
+					})
+					button("forget", "Remove", r.Forget, func() {
+						u.decideExtension(r.ID, u.extensions.Forget, "remove")
+					})
+				})
+			})
+		}
+	})
+}
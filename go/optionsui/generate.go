@@ -0,0 +1,136 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/attestation"
+	"github.com/google/chrome-ssh-agent/go/entropy"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/provider"
+)
+
+// generate generates a new key.  It displays a dialog prompting the user for
+// a name, key type and an optional passphrase.  If the user continues, the
+// key is generated and added to the manager.
+func (u *UI) generate() {
+	u.promptGenerate(func(name, keyType, passphrase string, deviceOnly bool, ok bool) {
+		if !ok {
+			return
+		}
+		u.generateKey(name, keyType, passphrase, deviceOnly)
+	})
+}
+
+// generateKey generates a new key of the specified type (one of the
+// provider.KeyType constants) with the default size, along with an
+// attestation describing how it was generated, and adds it to the manager.
+func (u *UI) generateKey(name, keyType, passphrase string, deviceOnly bool) {
+	p := provider.NewSoftware(nil)
+	pemKey, signer, err := provider.Generate(p, keyType, 0, passphrase)
+	if err != nil {
+		u.setError(err)
+		return
+	}
+	stmt := &attestation.Statement{
+		ExtensionID: u.extensionID,
+		Provider:    p.Name(),
+		Created:     time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	a, err := attestation.New(stmt, signer, entropy.Reader)
+	if err != nil {
+		u.setError(err)
+		return
+	}
+	encoded, err := a.Marshal()
+	if err != nil {
+		u.setError(err)
+		return
+	}
+
+	opts := &keys.AddOptions{
+		DeviceOnly:  deviceOnly,
+		Source:      keys.SourceGenerated,
+		UniqueName:  true,
+		Attestation: encoded,
+	}
+	u.mgr.Add(name, pemKey, opts, func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to add generated key"))
+			return
+		}
+		u.setError(nil)
+		u.updateKeys()
+	})
+}
+
+// promptGenerate displays a dialog prompting the user for the name and type
+// of a key to generate, an optional passphrase used to encrypt it, and
+// whether the key should be stored only on this device.  callback is invoked
+// when the dialog is closed; the ok parameter indicates if the user clicked
+// OK.
+func (u *UI) promptGenerate(callback func(name, keyType, passphrase string, deviceOnly bool, ok bool)) {
+	reset := func() {
+		u.dom.SetValue(u.generateName, "")
+		u.dom.SetValue(u.generatePassphrase, "")
+		u.dom.SetValue(u.generateType, provider.KeyTypeECDSA)
+		u.dom.SetChecked(u.generateDeviceOnly, false)
+		u.generateOk = u.dom.RemoveEventListeners(u.generateOk)
+		u.generateCancel = u.dom.RemoveEventListeners(u.generateCancel)
+		u.dom.Close(u.generateDialog)
+	}
+	u.dom.OnClick(u.generateOk, func() {
+		n := u.dom.Value(u.generateName)
+		k := u.dom.Value(u.generateType)
+		p := u.dom.Value(u.generatePassphrase)
+		d := u.dom.Checked(u.generateDeviceOnly)
+		reset()
+		callback(n, k, p, d, true)
+	})
+	u.dom.OnClick(u.generateCancel, func() {
+		reset()
+		callback("", "", "", false, false)
+	})
+	u.dom.ShowModal(u.generateDialog)
+}
+
+// showAttestation displays a dialog containing the attestation produced when
+// the specified key was generated, which may be copied to the clipboard.
+func (u *UI) showAttestation(k *displayedKey) {
+	ck := u.configured[k.ID]
+	if ck == nil || ck.Attestation == "" {
+		u.setError(errors.New("no attestation is available for this key"))
+		return
+	}
+
+	u.dom.RemoveChildren(u.attestationName)
+	u.dom.AppendChild(u.attestationName, u.dom.NewText(k.Name), nil)
+	u.dom.SetValue(u.attestationText, ck.Attestation)
+	u.dom.OnClick(u.attestationCopy, func() {
+		if !u.clipboard.Copy(u.attestationText) {
+			u.setError(errors.New("Failed to copy to clipboard; copy the text manually"))
+		}
+	})
+	u.dom.OnClick(u.attestationClose, func() {
+		u.dom.SetValue(u.attestationText, "")
+		u.attestationCopy = u.dom.RemoveEventListeners(u.attestationCopy)
+		u.attestationClose = u.dom.RemoveEventListeners(u.attestationClose)
+		u.dom.Close(u.attestationDialog)
+	})
+	u.dom.ShowModal(u.attestationDialog)
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// helpState indicates what is currently displayed in the help panel.
+type helpState int
+
+const (
+	// helpHidden indicates that the help panel is empty.
+	helpHidden helpState = iota
+	// helpForError indicates that the help panel displays guidance for the
+	// displayed error.
+	helpForError
+	// helpAll indicates that the help panel displays all topics, as
+	// requested by the user.
+	helpAll
+)
+
+// appendTopic appends the content of a help topic to the supplied element.
+func (u *UI) appendTopic(parent *js.Object, topic *help.Topic) {
+	u.dom.AppendChild(parent, u.dom.NewElement("div"), func(div *js.Object) {
+		div.Set("className", "helpTopic")
+		div.Set("id", "help-"+string(topic.Code))
+		u.dom.AppendChild(div, u.dom.NewElement("h4"), func(h *js.Object) {
+			u.dom.AppendChild(h, u.dom.NewText(topic.Title), nil)
+		})
+		for _, p := range topic.Paragraphs {
+			u.dom.AppendChild(div, u.dom.NewElement("p"), func(para *js.Object) {
+				u.dom.AppendChild(para, u.dom.NewText(p), nil)
+			})
+		}
+	})
+}
+
+// showHelp displays the topics in the help panel. If no topics are
+// supplied, the help panel is cleared.
+func (u *UI) showHelp(state helpState, topics []*help.Topic) {
+	u.dom.RemoveChildren(u.helpPanel)
+	u.help = helpHidden
+	if len(topics) == 0 {
+		return
+	}
+
+	u.help = state
+	for _, t := range topics {
+		u.appendTopic(u.helpPanel, t)
+	}
+}
+
+// showErrorHelp updates the help panel to display guidance for err, if any
+// is available.  Guidance displayed at the user's request is left intact.
+func (u *UI) showErrorHelp(err error) {
+	if u.help == helpAll {
+		return
+	}
+
+	var topics []*help.Topic
+	if t := help.Lookup(help.CodeOf(err)); t != nil {
+		topics = append(topics, t)
+	}
+	u.showHelp(helpForError, topics)
+}
+
+// toggleHelp displays all help topics, or hides them if they are already
+// displayed.
+func (u *UI) toggleHelp() {
+	if u.help == helpAll {
+		u.showHelp(helpHidden, nil)
+		return
+	}
+	u.showHelp(helpAll, help.Topics())
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+)
+
+// populateIDScheme displays whether keys are identified by their public key
+// fingerprint.
+func (u *UI) populateIDScheme() {
+	u.mgr.IDScheme(func(scheme keys.IDScheme, err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to read ID scheme"))
+			return
+		}
+		u.dom.SetChecked(u.idFingerprint, scheme == keys.IDFingerprint)
+	})
+}
+
+// setIDScheme stores the ID scheme selected by the user.  When fingerprints
+// are selected, existing keys are migrated, and the number migrated is
+// displayed.
+func (u *UI) setIDScheme() {
+	scheme := keys.IDRandom
+	if u.dom.Checked(u.idFingerprint) {
+		scheme = keys.IDFingerprint
+	}
+	u.dom.RemoveChildren(u.idSchemeStatus)
+	u.mgr.SetIDScheme(scheme, func(migrated int, err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to change ID scheme"))
+			u.populateIDScheme()
+			return
+		}
+		u.setError(nil)
+		if scheme == keys.IDFingerprint {
+			u.dom.AppendChild(u.idSchemeStatus, u.dom.NewText(fmt.Sprintf("Migrated %d keys to fingerprint IDs.", migrated)), nil)
+		}
+		u.updateKeys()
+	})
+}
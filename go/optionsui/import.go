@@ -0,0 +1,63 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+)
+
+const (
+	// fileOnlySettingKey is the key under which the file-only import
+	// setting is stored.  It is stored per-device; it is never synced.
+	fileOnlySettingKey = "import.fileOnly"
+)
+
+// populateImportMode reads the file-only import setting, and adapts the Add
+// dialog accordingly.
+func (u *UI) populateImportMode() {
+	u.settings.GetItems([]string{fileOnlySettingKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to read import setting: %v", err))
+			return
+		}
+		fileOnly, _ := data[fileOnlySettingKey].(bool)
+		u.dom.SetChecked(u.importFileOnly, fileOnly)
+		u.applyImportMode(fileOnly)
+	})
+}
+
+// setImportMode stores the file-only import setting selected by the user.
+func (u *UI) setImportMode() {
+	fileOnly := u.dom.Checked(u.importFileOnly)
+	u.applyImportMode(fileOnly)
+	u.settings.Set(map[string]interface{}{fileOnlySettingKey: fileOnly}, func(err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to write import setting: %v", err))
+			return
+		}
+		u.setError(nil)
+	})
+}
+
+// applyImportMode adapts the Add dialog to the file-only import setting.
+// When enabled, the textarea into which a private key may be pasted is
+// hidden, and private keys read from a file are never placed in the DOM.
+func (u *UI) applyImportMode(fileOnly bool) {
+	u.fileOnly = fileOnly
+	u.addKeyInput.Set("hidden", fileOnly)
+	if fileOnly {
+		u.dom.SetValue(u.addKey, "")
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/authorizedkeys"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+	"github.com/gopherjs/gopherjs/js"
+	"golang.org/x/crypto/ssh"
+)
+
+// publicKey returns the public key corresponding to a displayed key. Only
+// loaded keys have a public key available.
+func (d *displayedKey) publicKey() (ssh.PublicKey, error) {
+	if d.Blob == "" {
+		return nil, errors.New("public key is only available once the key is loaded")
+	}
+	blob, err := base64.StdEncoding.DecodeString(d.Blob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode blob: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(blob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	return pub, nil
+}
+
+// installSnippet returns the authorized_keys entry for a key with the
+// specified restrictions, along with the shell command that installs it on a
+// server.
+func installSnippet(k *displayedKey, r *authorizedkeys.Restrictions, oneLiner bool) (line, command string, err error) {
+	pub, err := k.publicKey()
+	if err != nil {
+		return "", "", err
+	}
+	line, err = authorizedkeys.Line(pub, k.Name, r)
+	if err != nil {
+		return "", "", err
+	}
+	return line, authorizedkeys.InstallCommand(line, oneLiner), nil
+}
+
+// exportPublicKey returns the public key for a key in the specified format.
+func exportPublicKey(k *displayedKey, f keyformat.PublicFormat) (string, error) {
+	pub, err := k.publicKey()
+	if err != nil {
+		return "", err
+	}
+	return keyformat.EncodePublic(pub, k.Name, f)
+}
+
+// populatePublicFormats populates the list of formats in which a public key
+// may be exported.
+func (u *UI) populatePublicFormats() {
+	u.dom.RemoveChildren(u.installFormat)
+	for _, f := range keyformat.PublicFormats {
+		u.dom.AppendChild(u.installFormat, u.dom.NewElement("option"), func(opt *js.Object) {
+			opt.Set("value", string(f))
+			u.dom.AppendChild(opt, u.dom.NewText(f.Description()), nil)
+		})
+	}
+	u.dom.SetValue(u.installFormat, string(keyformat.PublicOpenSSH))
+}
+
+// installRestrictions returns the restrictions entered by the user in the
+// install dialog.
+func (u *UI) installRestrictions() (*authorizedkeys.Restrictions, error) {
+	expiry, err := authorizedkeys.ParseExpiry(u.dom.Value(u.installExpiry))
+	if err != nil {
+		return nil, err
+	}
+	return &authorizedkeys.Restrictions{
+		From:              authorizedkeys.ParseList(u.dom.Value(u.installFrom)),
+		Command:           u.dom.Value(u.installCommand),
+		ExpiryTime:        expiry,
+		NoAgentForwarding: u.dom.Checked(u.installNoAgentForwarding),
+		NoPortForwarding:  u.dom.Checked(u.installNoPortForwarding),
+		NoX11Forwarding:   u.dom.Checked(u.installNoX11Forwarding),
+		NoPTY:             u.dom.Checked(u.installNoPty),
+		PermitOpen:        authorizedkeys.ParseList(u.dom.Value(u.installPermitOpen)),
+	}, nil
+}
+
+// install displays a dialog containing the command that installs the
+// specified key on a server.  The public key of a configured key that is not
+// loaded is requested from the manager; if it cannot be determined without
+// the key's passphrase, an error is displayed instead.
+func (u *UI) install(k *displayedKey) {
+	if k.Blob != "" {
+		u.showInstall(k)
+		return
+	}
+	u.mgr.PublicKey(k.ID, keyformat.PublicOpenSSH, func(encoded string, err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to get public key: %v", err))
+			return
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(encoded))
+		if err != nil {
+			u.setError(fmt.Errorf("failed to parse public key: %v", err))
+			return
+		}
+		withBlob := *k
+		withBlob.Blob = base64.StdEncoding.EncodeToString(pub.Marshal())
+		u.showInstall(&withBlob)
+	})
+}
+
+// showInstall displays a dialog containing the command that installs the
+// specified key on a server.  The command is updated as the user edits the
+// restrictions, and may be copied to the clipboard.
+func (u *UI) showInstall(k *displayedKey) {
+	setError := func(err error) {
+		u.dom.RemoveChildren(u.installError)
+		if err != nil {
+			u.dom.AppendChild(u.installError, u.dom.NewText(err.Error()), nil)
+		}
+	}
+	update := func() {
+		var line, command string
+		r, err := u.installRestrictions()
+		if err == nil {
+			line, command, err = installSnippet(k, r, u.dom.Checked(u.installOneLiner))
+		}
+		pub, pubErr := exportPublicKey(k, keyformat.PublicFormat(u.dom.Value(u.installFormat)))
+		if err == nil {
+			err = pubErr
+		}
+		setError(err)
+		u.dom.SetValue(u.installLine, line)
+		u.dom.SetValue(u.installSnippet, command)
+		u.dom.SetValue(u.installPublicKey, pub)
+	}
+	copyFrom := func(o *js.Object) func() {
+		return func() {
+			if !u.clipboard.Copy(o) {
+				setError(errors.New("Failed to copy to clipboard; copy the text manually"))
+			}
+		}
+	}
+
+	// Inputs and checkboxes that affect the generated entry.
+	inputs := []**js.Object{&u.installFrom, &u.installCommand, &u.installPermitOpen, &u.installExpiry}
+	checkboxes := []**js.Object{&u.installNoPortForwarding, &u.installNoAgentForwarding, &u.installNoX11Forwarding, &u.installNoPty, &u.installOneLiner}
+
+	u.dom.RemoveChildren(u.installName)
+	u.dom.AppendChild(u.installName, u.dom.NewText(k.Name), nil)
+	for _, i := range inputs {
+		u.dom.OnInput(*i, update)
+	}
+	for _, c := range checkboxes {
+		u.dom.OnChange(*c, update)
+	}
+	u.dom.OnClick(u.installCopy, copyFrom(u.installSnippet))
+	u.dom.OnClick(u.installCopyLine, copyFrom(u.installLine))
+	u.dom.OnClick(u.installCopyPublicKey, copyFrom(u.installPublicKey))
+	u.dom.OnChange(u.installFormat, update)
+	u.dom.OnClick(u.installClose, func() {
+		for _, i := range inputs {
+			u.dom.SetValue(*i, "")
+			*i = u.dom.RemoveEventListeners(*i)
+		}
+		for _, c := range checkboxes {
+			u.dom.SetChecked(*c, c == &u.installOneLiner)
+			*c = u.dom.RemoveEventListeners(*c)
+		}
+		u.dom.SetValue(u.installLine, "")
+		u.dom.SetValue(u.installSnippet, "")
+		u.dom.SetValue(u.installPublicKey, "")
+		u.dom.SetValue(u.installFormat, string(keyformat.PublicOpenSSH))
+		setError(nil)
+		u.installCopy = u.dom.RemoveEventListeners(u.installCopy)
+		u.installCopyLine = u.dom.RemoveEventListeners(u.installCopyLine)
+		u.installCopyPublicKey = u.dom.RemoveEventListeners(u.installCopyPublicKey)
+		u.installFormat = u.dom.RemoveEventListeners(u.installFormat)
+		u.installClose = u.dom.RemoveEventListeners(u.installClose)
+		u.dom.Close(u.installDialog)
+	})
+	update()
+	u.dom.ShowModal(u.installDialog)
+}
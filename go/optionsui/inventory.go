@@ -0,0 +1,62 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// populateInventoryFormats populates the list of formats in which the key
+// inventory may be exported.
+func (u *UI) populateInventoryFormats() {
+	u.dom.RemoveChildren(u.inventoryFormat)
+	for _, f := range keys.InventoryFormats {
+		u.dom.AppendChild(u.inventoryFormat, u.dom.NewElement("option"), func(opt *js.Object) {
+			opt.Set("value", string(f))
+			u.dom.AppendChild(opt, u.dom.NewText(f.Description()), nil)
+		})
+	}
+	u.dom.SetValue(u.inventoryFormat, string(keys.InventoryCSV))
+}
+
+// generateInventory exports metadata for all keys in the selected format.
+// The inventory never includes private key material.
+func (u *UI) generateInventory() {
+	format := keys.InventoryFormat(u.dom.Value(u.inventoryFormat))
+	u.mgr.Inventory(func(items []*keys.InventoryItem, err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to generate inventory: %v", err))
+			return
+		}
+		exported, err := keys.EncodeInventory(items, format)
+		if err != nil {
+			u.setError(fmt.Errorf("failed to export inventory: %v", err))
+			return
+		}
+		u.dom.SetValue(u.inventoryExport, exported)
+		u.setError(nil)
+	})
+}
+
+// copyInventory copies the exported inventory to the clipboard.
+func (u *UI) copyInventory() {
+	if !u.clipboard.Copy(u.inventoryExport) {
+		u.setError(errors.New("Failed to copy to clipboard; copy the text manually"))
+	}
+}
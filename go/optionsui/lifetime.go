@@ -0,0 +1,169 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// countdownInterval is how often the time remaining for loaded keys is
+// refreshed.
+const countdownInterval = time.Second
+
+// countdown is the display of the time remaining for a loaded key.
+type countdown struct {
+	elem    *js.Object
+	expires time.Time
+}
+
+// lifetimeText describes a lifetime for display to the user.
+func lifetimeText(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "Until unloaded"
+	case d%time.Hour == 0:
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	case d == time.Minute:
+		return "1 minute"
+	default:
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	}
+}
+
+// countdownText describes the time remaining until a loaded key expires.
+func countdownText(remaining time.Duration) string {
+	if remaining <= 0 {
+		return "Expired"
+	}
+	// Round up, so that 'Expired' is only shown once the key has actually
+	// expired.
+	secs := int64((remaining + time.Second - 1) / time.Second)
+	h, m, s := secs/3600, secs/60%60, secs%60
+	if h > 0 {
+		return fmt.Sprintf("Expires in %d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("Expires in %d:%02d", m, s)
+}
+
+// populateLoadLifetime populates the list of lifetimes with which keys may
+// be loaded, and selects the one in settings.
+func (u *UI) populateLoadLifetime() {
+	u.dom.RemoveChildren(u.loadLifetime)
+	for _, d := range keys.LoadLifetimes {
+		d := d
+		u.dom.AppendChild(u.loadLifetime, u.dom.NewElement("option"), func(opt *js.Object) {
+			opt.Set("value", strconv.FormatInt(int64(d/time.Second), 10))
+			u.dom.AppendChild(opt, u.dom.NewText(lifetimeText(d)), nil)
+		})
+	}
+	keys.ReadLoadLifetime(u.settings, func(d time.Duration, err error) {
+		if err != nil {
+			u.setError(err)
+		}
+		u.dom.SetValue(u.loadLifetime, strconv.FormatInt(int64(d/time.Second), 10))
+	})
+}
+
+// setLoadLifetime stores the lifetime selected by the user.  It applies to
+// keys loaded from then on.
+func (u *UI) setLoadLifetime() {
+	secs, err := strconv.ParseInt(u.dom.Value(u.loadLifetime), 10, 64)
+	if err != nil {
+		u.setError(help.Wrap(err, "failed to parse key lifetime"))
+		u.populateLoadLifetime()
+		return
+	}
+	keys.WriteLoadLifetime(u.settings, time.Duration(secs)*time.Second, func(err error) {
+		if err != nil {
+			u.setError(err)
+			u.populateLoadLifetime()
+			return
+		}
+		u.setError(nil)
+	})
+}
+
+// populateOnDemand displays whether keys are loaded on demand when a client
+// asks to sign using a key that is not loaded.
+func (u *UI) populateOnDemand() {
+	keys.ReadOnDemand(u.settings, func(enabled bool, err error) {
+		if err != nil {
+			u.setError(err)
+		}
+		u.dom.SetChecked(u.loadOnDemand, enabled)
+	})
+}
+
+// setOnDemand stores whether keys are loaded on demand, as selected by the
+// user.
+func (u *UI) setOnDemand() {
+	keys.WriteOnDemand(u.settings, u.dom.Checked(u.loadOnDemand), func(err error) {
+		if err != nil {
+			u.setError(err)
+			u.populateOnDemand()
+			return
+		}
+		u.setError(nil)
+	})
+}
+
+// renderCountdown appends the time remaining until a loaded key expires to
+// parent.  It is refreshed until the keys are next displayed.
+func (u *UI) renderCountdown(parent *js.Object, expires time.Time) {
+	u.dom.AppendChild(parent, u.dom.NewElement("span"), func(span *js.Object) {
+		span.Set("className", "keyCountdown")
+		u.dom.AppendChild(span, u.dom.NewText(countdownText(time.Until(expires))), nil)
+		u.countdowns = append(u.countdowns, &countdown{elem: span, expires: expires})
+	})
+}
+
+// scheduleCountdowns arranges for the displayed countdowns to be refreshed.
+// Any previously-scheduled refresh is cancelled.
+func (u *UI) scheduleCountdowns() {
+	if u.countdownTimer != nil {
+		u.countdownTimer.Stop()
+		u.countdownTimer = nil
+	}
+	if len(u.countdowns) == 0 {
+		return
+	}
+	u.countdownTimer = time.AfterFunc(countdownInterval, u.tickCountdowns)
+}
+
+// tickCountdowns refreshes the displayed countdowns.  Once a key expires,
+// the agent no longer lists it, so the keys are refreshed instead.
+func (u *UI) tickCountdowns() {
+	now := time.Now()
+	for _, c := range u.countdowns {
+		if !c.expires.After(now) {
+			u.updateKeys()
+			return
+		}
+	}
+	for _, c := range u.countdowns {
+		u.dom.RemoveChildren(c.elem)
+		u.dom.AppendChild(c.elem, u.dom.NewText(countdownText(c.expires.Sub(now))), nil)
+	}
+	u.scheduleCountdowns()
+}
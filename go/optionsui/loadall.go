@@ -0,0 +1,101 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+const (
+	// loadAllWorkers is the maximum number of keys decrypted at once when
+	// loading all keys.
+	loadAllWorkers = 2
+)
+
+// loadAll loads all configured keys that are not currently loaded.  A dialog
+// prompts the user for a passphrase, which is used for all encrypted keys.
+// Progress is displayed while keys are loaded, along with a button to cancel.
+func (u *UI) loadAll() {
+	if u.batch != nil {
+		// A batch is already in progress.
+		return
+	}
+
+	var ids []keys.ID
+	encrypted := false
+	for _, k := range u.keys {
+		if k.ID == keys.InvalidID || k.Loaded {
+			continue
+		}
+		ids = append(ids, k.ID)
+		encrypted = encrypted || k.Encrypted
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	prompt := u.promptPassphrase
+	if !encrypted {
+		prompt = func(callback func(passphrase string, ok bool)) {
+			callback("", true)
+		}
+	}
+
+	prompt(func(passphrase string, ok bool) {
+		if !ok {
+			return
+		}
+
+		u.clearResult()
+		u.setLoadAllProgress(fmt.Sprintf("Loading 0 of %d keys...", len(ids)))
+		u.batch = u.loader.LoadAll(ids, passphrase, func(p *keys.LoadProgress) {
+			u.setLoadAllProgress(fmt.Sprintf("Loading %d of %d keys...", p.Done, p.Total))
+		}, func(r *keys.Result) {
+			u.batch = nil
+			u.setLoadAllProgress("")
+			if r.Err() != nil {
+				u.showResult("Load all keys", r)
+			}
+			u.updateKeys()
+		})
+	})
+}
+
+// cancelLoadAll cancels loading of all keys.
+func (u *UI) cancelLoadAll() {
+	if u.batch != nil {
+		u.batch.Cancel()
+	}
+}
+
+// setLoadAllProgress displays the progress of loading all keys, along with a
+// button to cancel.  If the text is empty, progress is cleared.
+func (u *UI) setLoadAllProgress(text string) {
+	u.dom.RemoveChildren(u.loadAllProgress)
+	if text == "" {
+		return
+	}
+
+	u.dom.AppendChild(u.loadAllProgress, u.dom.NewText(text), nil)
+	u.dom.AppendChild(u.loadAllProgress, u.dom.NewElement("button"), func(btn *js.Object) {
+		btn.Set("type", "button")
+		btn.Set("id", "loadAllCancel")
+		u.dom.AppendChild(btn, u.dom.NewText("Cancel"), nil)
+		u.dom.OnClick(btn, u.cancelLoadAll)
+	})
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/permissions"
+)
+
+// populateNativeEnabled displays whether command-line clients are allowed
+// to use the agent via the native messaging host.  The optional permission
+// is itself the setting; it is held only while the feature is enabled.
+func (u *UI) populateNativeEnabled() {
+	permissions.Granted(u.perms, permissions.NativeMessaging, func(granted bool, err error) {
+		if err != nil {
+			u.setError(err)
+			return
+		}
+		u.dom.SetChecked(u.nativeEnabled, granted)
+	})
+}
+
+// setNativeEnabled requests or relinquishes the permission required to
+// communicate with the native messaging host, as selected by the user.  If
+// the user declines, the feature remains disabled.
+func (u *UI) setNativeEnabled() {
+	update, action := permissions.Disable, "disable"
+	if u.dom.Checked(u.nativeEnabled) {
+		update, action = permissions.Enable, "enable"
+	}
+	update(u.perms, permissions.NativeMessaging, func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to "+action+" command-line clients"))
+			u.populateNativeEnabled()
+			return
+		}
+		u.setError(nil)
+	})
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/markdown"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// notesID returns the value of the 'id' attribute assigned to the element
+// displaying the notes for a key.
+func notesID(id keys.ID) string {
+	return fmt.Sprintf("keyNotes-%s", id)
+}
+
+// editNotes displays a dialog in which the user may edit the notes for a
+// configured key.  If the user saves them, they are stored with the key.
+func (u *UI) editNotes(k *displayedKey) {
+	ck := u.configured[k.ID]
+	if ck == nil {
+		u.setError(errors.New("only configured keys may have notes"))
+		return
+	}
+
+	u.promptNotes(k.Name, ck.Notes, func(notes string, ok bool) {
+		if !ok {
+			return
+		}
+		u.mgr.SetNotes(k.ID, notes, func(err error) {
+			if err != nil {
+				u.setError(help.Wrap(err, "failed to update notes"))
+				return
+			}
+			u.setError(nil)
+			u.updateKeys()
+		})
+	})
+}
+
+// promptNotes displays a dialog prompting the user to edit the notes for
+// the named key.  callback is invoked when the dialog is closed; the ok
+// parameter indicates if the user clicked Save.
+func (u *UI) promptNotes(name, notes string, callback func(notes string, ok bool)) {
+	u.dom.RemoveChildren(u.notesName)
+	u.dom.AppendChild(u.notesName, u.dom.NewText(name), nil)
+	u.dom.SetValue(u.notesText, notes)
+	reset := func() {
+		u.dom.RemoveChildren(u.notesName)
+		u.dom.SetValue(u.notesText, "")
+		u.notesOk = u.dom.RemoveEventListeners(u.notesOk)
+		u.notesCancel = u.dom.RemoveEventListeners(u.notesCancel)
+		u.dom.Close(u.notesDialog)
+	}
+	u.dom.OnClick(u.notesOk, func() {
+		n := u.dom.Value(u.notesText)
+		reset()
+		callback(n, true)
+	})
+	u.dom.OnClick(u.notesCancel, func() {
+		reset()
+		callback("", false)
+	})
+	u.dom.ShowModal(u.notesDialog)
+}
+
+// renderMarkdown appends elements displaying the parsed Markdown nodes to
+// parent.  Text is always added as text nodes, never as HTML.
+func (u *UI) renderMarkdown(parent *js.Object, nodes []*markdown.Node) {
+	for _, n := range nodes {
+		n := n
+		var tag string
+		switch n.Kind {
+		case markdown.Text:
+			u.dom.AppendChild(parent, u.dom.NewText(n.Text), nil)
+			continue
+		case markdown.Paragraph:
+			tag = "p"
+		case markdown.List:
+			tag = "ul"
+		case markdown.ListItem:
+			tag = "li"
+		case markdown.Strong:
+			tag = "strong"
+		case markdown.Emphasis:
+			tag = "em"
+		case markdown.Code:
+			tag = "code"
+		case markdown.Link:
+			tag = "a"
+		default:
+			continue
+		}
+		u.dom.AppendChild(parent, u.dom.NewElement(tag), func(elt *js.Object) {
+			switch n.Kind {
+			case markdown.Code:
+				u.dom.AppendChild(elt, u.dom.NewText(n.Text), nil)
+			case markdown.Link:
+				elt.Set("href", n.URL)
+				elt.Set("target", "_blank")
+				elt.Set("rel", "noopener noreferrer")
+			}
+			u.renderMarkdown(elt, n.Children)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/notify"
+	"github.com/google/chrome-ssh-agent/go/permissions"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+const (
+	// toastDuration is how long a toast is displayed.
+	toastDuration = 10 * time.Second
+)
+
+// populateNotifyChannels populates the list of channels through which
+// notifications may be delivered, and selects the one in settings.
+func (u *UI) populateNotifyChannels() {
+	u.dom.RemoveChildren(u.notifyChannel)
+	for _, k := range notify.Kinds {
+		u.dom.AppendChild(u.notifyChannel, u.dom.NewElement("option"), func(opt *js.Object) {
+			opt.Set("value", string(k))
+			u.dom.AppendChild(opt, u.dom.NewText(k.Description()), nil)
+		})
+	}
+	notify.ReadKind(u.settings, func(kind notify.Kind, err error) {
+		if err != nil {
+			u.setError(err)
+		}
+		u.dom.SetValue(u.notifyChannel, string(kind))
+	})
+}
+
+// setNotifyChannel stores the notification channel selected by the user.
+// System notifications require an optional permission, which is requested
+// when they are selected and relinquished otherwise.  If the user declines,
+// the previously-selected channel is restored.
+func (u *UI) setNotifyChannel() {
+	kind := notify.Kind(u.dom.Value(u.notifyChannel))
+	update := permissions.Disable
+	if kind == notify.System {
+		update = permissions.Enable
+	}
+	update(u.perms, permissions.Notifications, func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to change notification channel"))
+			u.populateNotifyChannels()
+			return
+		}
+		notify.WriteKind(u.settings, kind, func(err error) {
+			if err != nil {
+				u.setError(err)
+				return
+			}
+			u.setError(nil)
+		})
+	})
+}
+
+// ShowToast displays a notification delivered through the toast channel.
+// The toast is removed after toastDuration.
+func (u *UI) ShowToast(title, message string) {
+	u.dom.AppendChild(u.toasts, u.dom.NewElement("div"), func(div *js.Object) {
+		div.Set("className", "toast")
+		u.dom.AppendChild(div, u.dom.NewElement("strong"), func(s *js.Object) {
+			u.dom.AppendChild(s, u.dom.NewText(title), nil)
+		})
+		u.dom.AppendChild(div, u.dom.NewText(" "+message), nil)
+		time.AfterFunc(toastDuration, func() {
+			div.Call("remove")
+		})
+	})
+}
+
+// clipboardCleared notifies the user that text copied from the page was
+// cleared from the clipboard, or that it could not be.
+func (u *UI) clipboardCleared(ok bool) {
+	if !ok {
+		u.ShowToast("Clipboard not cleared", "Copied text could not be cleared from the clipboard; clear it manually.")
+		return
+	}
+	u.ShowToast("Clipboard cleared", "Copied text was cleared from the clipboard.")
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// allowOrigin approves the origin entered by the user to use the bridge.
+func (u *UI) allowOrigin() {
+	origin := u.dom.Value(u.originInput)
+	u.acl.Allow(origin, func(err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to approve website: %v", err))
+			return
+		}
+
+		u.dom.SetValue(u.originInput, "")
+		u.setError(nil)
+		u.updateOrigins()
+	})
+}
+
+// revokeOrigin withdraws approval for the specified origin.
+func (u *UI) revokeOrigin(origin string) {
+	u.acl.Revoke(origin, func(err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to revoke website: %v", err))
+			return
+		}
+
+		u.setError(nil)
+		u.updateOrigins()
+	})
+}
+
+// revokeButtonID returns the value of the 'id' attribute to be assigned to the
+// button that revokes an origin.
+func revokeButtonID(origin string) string {
+	return fmt.Sprintf("revoke-%s", origin)
+}
+
+// updateOrigins queries the approved origins, then refreshes the UI to
+// reflect them.
+func (u *UI) updateOrigins() {
+	u.acl.Origins(func(origins []string, err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to get approved websites: %v", err))
+			return
+		}
+
+		u.origins = origins
+		u.dom.RemoveChildren(u.originsData)
+		for _, o := range origins {
+			o := o
+			u.dom.AppendChild(u.originsData, u.dom.NewElement("tr"), func(row *js.Object) {
+				u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
+					u.dom.AppendChild(cell, u.dom.NewText(o), nil)
+				})
+				u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
+					u.dom.AppendChild(cell, u.dom.NewElement("button"), func(btn *js.Object) {
+						btn.Set("type", "button")
+						btn.Set("id", revokeButtonID(o))
+						u.dom.AppendChild(btn, u.dom.NewText("Revoke"), nil)
+						u.dom.OnClick(btn, func() {
+							u.revokeOrigin(o)
+						})
+					})
+				})
+			})
+		}
+	})
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"strconv"
+
+	"github.com/google/chrome-ssh-agent/go/agentport"
+)
+
+// populateParallelism displays the number of sign requests processed
+// concurrently for each connection.
+func (u *UI) populateParallelism() {
+	agentport.ReadParallelism(u.settings, func(parallelism int, err error) {
+		if err != nil {
+			u.setError(err)
+		}
+		u.dom.SetValue(u.agentParallelism, strconv.Itoa(parallelism))
+	})
+}
+
+// setParallelism stores the number of sign requests processed concurrently
+// for each connection, as entered by the user.  If the number is invalid,
+// the stored number is redisplayed.
+func (u *UI) setParallelism() {
+	parallelism, err := strconv.Atoi(u.dom.Value(u.agentParallelism))
+	if err != nil {
+		parallelism = 0
+	}
+	agentport.WriteParallelism(u.settings, parallelism, func(err error) {
+		if err != nil {
+			u.setError(err)
+			u.populateParallelism()
+			return
+		}
+		u.setError(nil)
+	})
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/pmimport"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// importFromPasswordManager imports the SSH keys in an export produced by a
+// password manager.  A dialog prompts the user for the export and lists the
+// keys found in it; if the user continues, each is added, and the outcome is
+// displayed.
+func (u *UI) importFromPasswordManager() {
+	u.promptPasswordManagerImport(func(file string, format pmimport.Format, imported []*pmimport.Key, deviceOnly bool, ok bool) {
+		if !ok {
+			return
+		}
+		detail := fmt.Sprintf("%s (%s export)", file, format.Description())
+		u.importKeys(imported, detail, deviceOnly, func(result *keys.Result) {
+			u.setError(result.Err())
+			u.showResult("Import", result)
+			u.updateKeys()
+		})
+	})
+}
+
+// importNotes returns the notes stored with an imported key.  Tags have no
+// equivalent in the extension, so they are appended to the notes.
+func importNotes(k *pmimport.Key) string {
+	notes := strings.TrimSpace(k.Notes)
+	if len(k.Tags) == 0 {
+		return notes
+	}
+	tags := fmt.Sprintf("Tags: %s", strings.Join(k.Tags, ", "))
+	if notes == "" {
+		return tags
+	}
+	return fmt.Sprintf("%s\n\n%s", notes, tags)
+}
+
+// importKeys adds the imported keys one at a time.  A key whose name is
+// already taken is added under a similar name instead.  callback is invoked
+// with the outcome once all keys have been added; since Add does not report
+// the ID assigned to a key, each is recorded by name.
+func (u *UI) importKeys(imported []*pmimport.Key, detail string, deviceOnly bool, callback func(result *keys.Result)) {
+	result := keys.NewResult("import")
+	taken := u.displayedNames()
+
+	if len(imported) == 0 {
+		callback(result)
+		return
+	}
+
+	var add func(i int, name string)
+	add = func(i int, name string) {
+		k := imported[i]
+		opts := &keys.AddOptions{
+			DeviceOnly:   deviceOnly,
+			Source:       keys.SourceFile,
+			SourceDetail: detail,
+			UniqueName:   true,
+			Notes:        importNotes(k),
+		}
+		u.mgr.Add(name, k.PrivateKey, opts, func(err error) {
+			if help.CodeOf(err) == help.NameTaken {
+				taken = append(taken, name)
+				add(i, keys.SuggestName(name, taken))
+				return
+			}
+			if err != nil {
+				err = help.Wrap(err, "failed to add key")
+			}
+			result.Record(keys.ID(name), err)
+			taken = append(taken, name)
+			if i+1 == len(imported) {
+				callback(result)
+				return
+			}
+			add(i+1, imported[i+1].Name)
+		})
+	}
+	add(0, imported[0].Name)
+}
+
+// promptPasswordManagerImport displays a dialog prompting the user for an
+// export produced by a password manager, and whether the keys should be
+// stored only on this device.  The keys found in the export are listed as
+// soon as it is chosen.  The dialog remains open until an export containing
+// keys is chosen or the user cancels.  callback is invoked when the dialog
+// is closed; the ok parameter indicates if the user continued.
+//
+// The private keys are never placed in the DOM.
+func (u *UI) promptPasswordManagerImport(callback func(file string, format pmimport.Format, imported []*pmimport.Key, deviceOnly bool, ok bool)) {
+	var file string
+	var format pmimport.Format
+	var imported []*pmimport.Key
+	setStatus := func(status string) {
+		u.dom.RemoveChildren(u.pmImportStatus)
+		u.dom.AppendChild(u.pmImportStatus, u.dom.NewText(status), nil)
+	}
+	u.dom.OnChange(u.pmImportFile, func() {
+		u.readFileBytes(u.pmImportFile, func(name string, contents []byte, err error) {
+			file, format, imported = name, "", nil
+			u.dom.RemoveChildren(u.pmImportList)
+			if err != nil {
+				setStatus(err.Error())
+				return
+			}
+			f, ks, err := pmimport.Parse(contents)
+			if err != nil {
+				setStatus(fmt.Sprintf("Failed to read %s: %v", name, err))
+				return
+			}
+			format, imported = f, ks
+			setStatus(fmt.Sprintf("The following %d keys will be imported from the %s export:", len(ks), f.Description()))
+			for _, k := range ks {
+				k := k
+				u.dom.AppendChild(u.pmImportList, u.dom.NewElement("li"), func(item *js.Object) {
+					u.dom.AppendChild(item, u.dom.NewText(k.Name), nil)
+				})
+			}
+		})
+	})
+	reset := func() {
+		u.dom.SetValue(u.pmImportFile, "")
+		u.dom.SetChecked(u.pmImportDeviceOnly, false)
+		u.dom.RemoveChildren(u.pmImportStatus)
+		u.dom.RemoveChildren(u.pmImportList)
+		u.pmImportOk = u.dom.RemoveEventListeners(u.pmImportOk)
+		u.pmImportCancel = u.dom.RemoveEventListeners(u.pmImportCancel)
+		u.pmImportFile = u.dom.RemoveEventListeners(u.pmImportFile)
+		u.dom.Close(u.pmImportDialog)
+	}
+	u.dom.OnClick(u.pmImportOk, func() {
+		if len(imported) == 0 {
+			setStatus("Choose an export containing SSH keys")
+			return
+		}
+		d := u.dom.Checked(u.pmImportDeviceOnly)
+		reset()
+		callback(file, format, imported, d, true)
+	})
+	u.dom.OnClick(u.pmImportCancel, func() {
+		reset()
+		callback("", "", nil, false, false)
+	})
+	u.dom.ShowModal(u.pmImportDialog)
+}
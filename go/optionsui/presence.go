@@ -0,0 +1,113 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/presence"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// remoteUnloadID returns the ID of the button that asks another device to
+// unload a key.
+func remoteUnloadID(id keys.ID, device string) string {
+	return fmt.Sprintf("remoteUnload-%s-%s", id, device)
+}
+
+// populatePresence displays whether the keys loaded on this device are
+// shared with the user's other devices.
+func (u *UI) populatePresence() {
+	u.presence.Settings(func(s *presence.Settings, err error) {
+		u.dom.RemoveChildren(u.presenceStatus)
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to read sharing settings"))
+			return
+		}
+		status := "Loaded keys are not shared with other devices."
+		if s.Enabled {
+			u.dom.SetValue(u.presenceName, s.Name)
+			status = fmt.Sprintf("Loaded keys are shared with other devices, which list this one as '%s'.", s.Name)
+		}
+		u.presenceDisable.Set("disabled", !s.Enabled)
+		u.dom.AppendChild(u.presenceStatus, u.dom.NewText(status), nil)
+	})
+}
+
+// enablePresence shares the keys loaded on this device, using the name and
+// passphrase entered by the user.
+func (u *UI) enablePresence() {
+	passphrase := u.dom.Value(u.presencePassphrase)
+	u.dom.SetValue(u.presencePassphrase, "")
+	u.presence.Enable(passphrase, u.dom.Value(u.presenceName), func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to share loaded keys"))
+			return
+		}
+		u.setError(nil)
+		u.populatePresence()
+		u.updateKeys()
+	})
+}
+
+// disablePresence stops sharing the keys loaded on this device.
+func (u *UI) disablePresence() {
+	u.presence.Disable(func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to stop sharing loaded keys"))
+			return
+		}
+		u.setError(nil)
+		u.populatePresence()
+		u.updateKeys()
+	})
+}
+
+// requestRemoteUnload asks another device to unload a key.
+func (u *UI) requestRemoteUnload(id keys.ID, device string) {
+	u.presence.RequestUnload(device, id, func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to request unload"))
+			return
+		}
+		u.setError(nil)
+		u.updateKeys()
+	})
+}
+
+// renderRemoteLoads appends the other devices on which a key is loaded to
+// cell.
+func (u *UI) renderRemoteLoads(cell *js.Object, id keys.ID, remote []*RemoteLoad) {
+	for _, r := range remote {
+		r := r
+		u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
+			div.Set("className", "keyRemote")
+			div.Set("title", r.Title)
+			u.dom.AppendChild(div, u.dom.NewText(r.Text), nil)
+			if r.Requested {
+				return
+			}
+			u.dom.AppendChild(div, u.dom.NewElement("button"), func(btn *js.Object) {
+				btn.Set("id", remoteUnloadID(id, r.Device))
+				u.dom.AppendChild(btn, u.dom.NewText("Unload There"), nil)
+				u.dom.OnClick(btn, func() {
+					u.requestRemoteUnload(id, r.Device)
+				})
+			})
+		})
+	}
+}
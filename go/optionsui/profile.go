@@ -0,0 +1,75 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+)
+
+// editProfile displays a dialog in which the user may choose the profile to
+// which a configured key belongs.  If the user saves it, the key is moved to
+// that profile.
+func (u *UI) editProfile(k *displayedKey) {
+	ck := u.configured[k.ID]
+	if ck == nil {
+		u.setError(errors.New("only configured keys may be moved to a profile"))
+		return
+	}
+
+	u.promptProfile(k.Name, ck.Profile, func(profile string, ok bool) {
+		if !ok {
+			return
+		}
+		u.mgr.SetProfile(k.ID, profile, func(err error) {
+			if err != nil {
+				u.setError(help.Wrap(err, "failed to update profile"))
+				return
+			}
+			u.setError(nil)
+			u.updateKeys()
+		})
+	})
+}
+
+// promptProfile displays a dialog prompting the user to choose the profile
+// for the named key.  callback is invoked when the dialog is closed; the ok
+// parameter indicates if the user clicked Save.  An empty profile selects
+// the default profile.
+func (u *UI) promptProfile(name, profile string, callback func(profile string, ok bool)) {
+	u.dom.RemoveChildren(u.profileName)
+	u.dom.AppendChild(u.profileName, u.dom.NewText(name), nil)
+	u.dom.SetValue(u.profileText, profile)
+	reset := func() {
+		u.dom.RemoveChildren(u.profileName)
+		u.dom.SetValue(u.profileText, "")
+		u.profileOk = u.dom.RemoveEventListeners(u.profileOk)
+		u.profileCancel = u.dom.RemoveEventListeners(u.profileCancel)
+		u.dom.Close(u.profileDialog)
+	}
+	u.dom.OnClick(u.profileOk, func() {
+		p := strings.TrimSpace(u.dom.Value(u.profileText))
+		reset()
+		callback(p, true)
+	})
+	u.dom.OnClick(u.profileCancel, func() {
+		reset()
+		callback(keys.DefaultProfile, false)
+	})
+	u.dom.ShowModal(u.profileDialog)
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/entropy"
+	"github.com/google/chrome-ssh-agent/go/nassh"
+)
+
+// addProfile adds a Secure Shell connection profile for the destination
+// entered by the user, and updates the exported profiles to include it.
+func (u *UI) addProfile() {
+	p, err := nassh.NewProfile(u.dom.Value(u.profileDest), u.dom.Value(u.profileDescription), u.extensionID, entropy.Reader)
+	if err != nil {
+		u.setError(fmt.Errorf("failed to create connection profile: %v", err))
+		return
+	}
+
+	profiles := append(u.profiles, p)
+	exported, err := nassh.Export(profiles)
+	if err != nil {
+		u.setError(fmt.Errorf("failed to export connection profiles: %v", err))
+		return
+	}
+
+	u.profiles = profiles
+	u.dom.SetValue(u.profileDest, "")
+	u.dom.SetValue(u.profileDescription, "")
+	u.dom.SetValue(u.profileExport, exported)
+	u.setError(nil)
+}
+
+// copyProfiles copies the exported connection profiles to the clipboard.
+func (u *UI) copyProfiles() {
+	if !u.clipboard.Copy(u.profileExport) {
+		u.setError(errors.New("Failed to copy to clipboard; copy the text manually"))
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+const (
+	// allSources is the value of the source filter option that displays
+	// keys from all sources.
+	allSources = "all"
+)
+
+// populateSourceFilter fills in the options from which the user may select
+// the source of keys to display.
+func (u *UI) populateSourceFilter() {
+	u.dom.RemoveChildren(u.sourceFilter)
+	addOption := func(value, text string) {
+		u.dom.AppendChild(u.sourceFilter, u.dom.NewElement("option"), func(opt *js.Object) {
+			opt.Set("value", value)
+			u.dom.AppendChild(opt, u.dom.NewText(text), nil)
+		})
+	}
+	addOption(allSources, "All keys")
+	for _, s := range keys.Sources {
+		addOption(string(s), s.Description())
+	}
+	u.dom.SetValue(u.sourceFilter, allSources)
+}
+
+// provenanceText returns a human-readable description of how a configured
+// key entered the system.
+func provenanceText(k *keys.ConfiguredKey) string {
+	if k.Created == 0 {
+		return k.Provenance()
+	}
+	created := time.Unix(0, k.Created*int64(time.Millisecond))
+	return fmt.Sprintf("%s, %s", k.Provenance(), created.Format("2006-01-02 15:04"))
+}
+
+// showKey determines if a key should be displayed given the source filter
+// selected by the user.  Keys that are loaded but not configured are only
+// displayed if no filter is selected.
+func (u *UI) showKey(k *displayedKey) bool {
+	filter := u.dom.Value(u.sourceFilter)
+	if filter == "" || filter == allSources {
+		return true
+	}
+	ck := u.configured[k.ID]
+	if ck == nil {
+		return false
+	}
+	return ck.MatchesSource(keys.Source(filter))
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/replay"
+)
+
+// populateReplay displays the settings with which duplicate signature
+// requests are detected.
+func (u *UI) populateReplay() {
+	replay.ReadSettings(u.settings, func(s *replay.Settings, err error) {
+		if err != nil {
+			u.setError(err)
+		}
+		u.dom.SetValue(u.replayWindow, strconv.Itoa(int(s.Window/time.Second)))
+		u.dom.SetChecked(u.replayBlock, s.Block)
+	})
+}
+
+// setReplay stores the settings with which duplicate signature requests are
+// detected, as entered by the user.  If the window is invalid, the stored
+// settings are redisplayed.
+func (u *UI) setReplay() {
+	secs, err := strconv.Atoi(u.dom.Value(u.replayWindow))
+	if err != nil {
+		secs = -1
+	}
+	s := &replay.Settings{
+		Window: time.Duration(secs) * time.Second,
+		Block:  u.dom.Checked(u.replayBlock),
+	}
+	replay.WriteSettings(u.settings, s, func(err error) {
+		if err != nil {
+			u.setError(err)
+			u.populateReplay()
+			return
+		}
+		u.setError(nil)
+	})
+}
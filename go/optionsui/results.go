@@ -0,0 +1,79 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// keyName returns the name of the displayed key with the specified ID, or
+// the ID itself if the key is not displayed.
+func (u *UI) keyName(id keys.ID) string {
+	for _, k := range u.keys {
+		if k.ID == id && k.Name != "" {
+			return u.displayName(k)
+		}
+	}
+	return string(id)
+}
+
+// clearResult removes any displayed result of an operation on many keys.
+func (u *UI) clearResult() {
+	u.dom.RemoveChildren(u.results)
+}
+
+// showResult displays the outcome of an operation on many keys: a summary,
+// followed by a table listing the outcome for each key.
+func (u *UI) showResult(title string, r *keys.Result) {
+	u.clearResult()
+
+	u.dom.AppendChild(u.results, u.dom.NewElement("div"), func(div *js.Object) {
+		div.Set("className", "resultSummary")
+		u.dom.AppendChild(div, u.dom.NewText(fmt.Sprintf("%s: %s", title, r.Summary())), nil)
+		u.dom.AppendChild(div, u.dom.NewElement("button"), func(btn *js.Object) {
+			btn.Set("type", "button")
+			btn.Set("id", "resultsDismiss")
+			u.dom.AppendChild(btn, u.dom.NewText("Dismiss"), nil)
+			u.dom.OnClick(btn, u.clearResult)
+		})
+	})
+
+	u.dom.AppendChild(u.results, u.dom.NewElement("table"), func(table *js.Object) {
+		for _, item := range r.Items {
+			item := item
+			u.dom.AppendChild(table, u.dom.NewElement("tr"), func(row *js.Object) {
+				row.Set("className", "result"+item.Status.String())
+				for _, text := range []string{u.keyName(item.ID), item.Status.String(), errorText(item.Err)} {
+					text := text
+					u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
+						u.dom.AppendChild(cell, u.dom.NewText(text), nil)
+					})
+				}
+			})
+		}
+	})
+}
+
+// errorText returns the message for an error, or the empty string if there
+// is no error.
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+)
+
+// scheduleID returns the value of the 'id' attribute of the element
+// describing the schedule of the key with the specified ID.
+func scheduleID(id keys.ID) string {
+	return fmt.Sprintf("schedule-%s", id)
+}
+
+// scheduleTitle describes the next event in a schedule after now (e.g., 'Next
+// unloaded 2018-05-07 18:00').
+func scheduleTitle(s *keys.Schedule, now time.Time) string {
+	next, load := s.Next(now)
+	what := "unloaded"
+	if load {
+		what = "loaded"
+	}
+	return fmt.Sprintf("Next %s %s", what, next.Format("2006-01-02 15:04"))
+}
+
+// editSchedule displays a dialog in which the user may set the schedule on
+// which a configured key is loaded and unloaded.  If the user saves it, the
+// key's schedule is updated.
+func (u *UI) editSchedule(k *displayedKey) {
+	ck := u.configured[k.ID]
+	if ck == nil {
+		u.setError(errors.New("only configured keys may be scheduled"))
+		return
+	}
+
+	u.promptSchedule(k.Name, ck.Schedule, func(schedule string, ok bool) {
+		if !ok {
+			return
+		}
+		u.mgr.SetSchedule(k.ID, schedule, func(err error) {
+			if err != nil {
+				u.setError(help.Wrap(err, "failed to update schedule"))
+				return
+			}
+			u.setError(nil)
+			u.updateKeys()
+		})
+	})
+}
+
+// promptSchedule displays a dialog prompting the user to set the schedule
+// for the named key.  callback is invoked when the dialog is closed; the ok
+// parameter indicates if the user clicked Save.  An empty schedule clears
+// it.
+func (u *UI) promptSchedule(name, schedule string, callback func(schedule string, ok bool)) {
+	u.dom.RemoveChildren(u.scheduleName)
+	u.dom.AppendChild(u.scheduleName, u.dom.NewText(name), nil)
+	u.dom.SetValue(u.scheduleText, schedule)
+	reset := func() {
+		u.dom.RemoveChildren(u.scheduleName)
+		u.dom.SetValue(u.scheduleText, "")
+		u.scheduleOk = u.dom.RemoveEventListeners(u.scheduleOk)
+		u.scheduleCancel = u.dom.RemoveEventListeners(u.scheduleCancel)
+		u.dom.Close(u.scheduleDialog)
+	}
+	u.dom.OnClick(u.scheduleOk, func() {
+		s := strings.TrimSpace(u.dom.Value(u.scheduleText))
+		reset()
+		callback(s, true)
+	})
+	u.dom.OnClick(u.scheduleCancel, func() {
+		reset()
+		callback("", false)
+	})
+	u.dom.ShowModal(u.scheduleDialog)
+}
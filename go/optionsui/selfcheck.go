@@ -0,0 +1,93 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/selfcheck"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// healthText describes the overall outcome of a self-check.
+var healthText = map[keys.Health]string{
+	keys.Healthy:  "Green: no problems found",
+	keys.Degraded: "Amber: problems found that do not prevent keys from being used",
+	keys.Failing:  "Red: stored keys may be damaged, or the agent is not responding",
+}
+
+// populateSelfCheck displays the self-check settings and the most recent
+// report.
+func (u *UI) populateSelfCheck() {
+	selfcheck.ReadSettings(u.settings, func(s *selfcheck.Settings, err error) {
+		if err != nil {
+			u.setError(err)
+		}
+		u.dom.SetChecked(u.selfCheckEnabled, s.Enabled)
+		u.dom.SetValue(u.selfCheckSample, strconv.Itoa(s.Sample))
+	})
+	u.RefreshSelfCheck()
+}
+
+// setSelfCheck stores the self-check settings, as entered by the user.  If
+// the number of keys is invalid, the stored settings are redisplayed.
+func (u *UI) setSelfCheck() {
+	sample, err := strconv.Atoi(u.dom.Value(u.selfCheckSample))
+	if err != nil {
+		sample = -1
+	}
+	s := &selfcheck.Settings{
+		Enabled: u.dom.Checked(u.selfCheckEnabled),
+		Sample:  sample,
+	}
+	selfcheck.WriteSettings(u.settings, s, func(err error) {
+		if err != nil {
+			u.setError(err)
+			u.populateSelfCheck()
+			return
+		}
+		u.setError(nil)
+	})
+}
+
+// RefreshSelfCheck displays the most recent self-check report.  It should
+// be invoked when a new report is stored (see selfcheck.ReportChanged).
+func (u *UI) RefreshSelfCheck() {
+	selfcheck.ReadReport(u.settings, func(r *selfcheck.Report, err error) {
+		if err != nil {
+			u.setError(err)
+			return
+		}
+
+		u.dom.RemoveChildren(u.selfCheckStatus)
+		u.dom.RemoveChildren(u.selfCheckResults)
+		if r == nil {
+			u.dom.AppendChild(u.selfCheckStatus, u.dom.NewText("The self-check has not run yet."), nil)
+			return
+		}
+		checked := time.Unix(0, r.Time*int64(time.Millisecond)).Format(time.RFC1123)
+		u.dom.AppendChild(u.selfCheckStatus, u.dom.NewText(fmt.Sprintf("%s (checked %s)", healthText[r.Health], checked)), nil)
+		for _, c := range r.Checks {
+			c := c
+			u.dom.AppendChild(u.selfCheckResults, u.dom.NewElement("li"), func(item *js.Object) {
+				item.Set("className", "selfCheck-"+string(c.Health))
+				u.dom.AppendChild(item, u.dom.NewText(fmt.Sprintf("%s: %s", c.Name, c.Detail)), nil)
+			})
+		}
+	})
+}
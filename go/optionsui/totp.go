@@ -0,0 +1,47 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"errors"
+
+	"github.com/google/chrome-ssh-agent/go/totp"
+)
+
+// totpIssuer labels the entries created in authenticator apps.
+const totpIssuer = "SSH Agent"
+
+// showTOTPSetup displays the secret from which the user's authenticator app
+// generates confirmation codes for the named key.
+func (u *UI) showTOTPSetup(name, secret string) {
+	u.dom.RemoveChildren(u.totpSetupName)
+	u.dom.AppendChild(u.totpSetupName, u.dom.NewText(name), nil)
+	u.dom.RemoveChildren(u.totpSetupSecret)
+	u.dom.AppendChild(u.totpSetupSecret, u.dom.NewText(secret), nil)
+	u.dom.SetValue(u.totpSetupURI, totp.URI(totpIssuer, name, secret))
+	u.dom.OnClick(u.totpSetupCopy, func() {
+		if !u.clipboard.Copy(u.totpSetupURI) {
+			u.setError(errors.New("Failed to copy to clipboard; copy the text manually"))
+		}
+	})
+	u.dom.OnClick(u.totpSetupClose, func() {
+		u.dom.RemoveChildren(u.totpSetupSecret)
+		u.dom.SetValue(u.totpSetupURI, "")
+		u.totpSetupCopy = u.dom.RemoveEventListeners(u.totpSetupCopy)
+		u.totpSetupClose = u.dom.RemoveEventListeners(u.totpSetupClose)
+		u.dom.Close(u.totpSetupDialog)
+	})
+	u.dom.ShowModal(u.totpSetupDialog)
+}
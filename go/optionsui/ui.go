@@ -25,9 +25,24 @@ import (
 	"sort"
 	"time"
 
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/bridge"
+	"github.com/google/chrome-ssh-agent/go/clipboard"
+	"github.com/google/chrome-ssh-agent/go/diagnostics"
 	"github.com/google/chrome-ssh-agent/go/dom"
+	"github.com/google/chrome-ssh-agent/go/external"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keyformat"
 	"github.com/google/chrome-ssh-agent/go/keys"
 	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/markdown"
+	"github.com/google/chrome-ssh-agent/go/nassh"
+	"github.com/google/chrome-ssh-agent/go/permissions"
+	"github.com/google/chrome-ssh-agent/go/presence"
+	"github.com/google/chrome-ssh-agent/go/provisioning"
+	"github.com/google/chrome-ssh-agent/go/redact"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	"github.com/google/chrome-ssh-agent/go/totp"
 	"github.com/gopherjs/gopherjs/js"
 	"github.com/kr/pretty"
 )
@@ -35,142 +50,783 @@ import (
 // UI implements the behavior underlying the user interface for the extension's
 // options.
 type UI struct {
-	mgr              keys.Manager
-	dom              *dom.DOM
-	passphraseDialog *js.Object
-	passphraseInput  *js.Object
-	passphraseOk     *js.Object
-	passphraseCancel *js.Object
-	addButton        *js.Object
-	addDialog        *js.Object
-	addName          *js.Object
-	addKey           *js.Object
-	addOk            *js.Object
-	addCancel        *js.Object
-	removeDialog     *js.Object
-	removeName       *js.Object
-	removeYes        *js.Object
-	removeNo         *js.Object
-	errorText        *js.Object
-	keysData         *js.Object
-	keys             []*displayedKey
-}
-
-// New returns a new UI instance that manages keys using the supplied manager.
-// domObj is the DOM instance corresponding to the document in which the Options
-// UI is displayed.
-func New(mgr keys.Manager, domObj *dom.DOM) *UI {
+	mgr                      keys.Manager
+	loader                   *keys.BatchLoader
+	acl                      *bridge.ACL
+	approvals                *provisioning.Provisioner
+	settings                 storage.Settings
+	perms                    permissions.API
+	wiper                    Wiper
+	dom                      *dom.DOM
+	passphraseDialog         *js.Object
+	passphraseInput          *js.Object
+	passphraseOk             *js.Object
+	passphraseCancel         *js.Object
+	passphraseStatus         *js.Object
+	totpSetupDialog          *js.Object
+	totpSetupName            *js.Object
+	totpSetupSecret          *js.Object
+	totpSetupURI             *js.Object
+	totpSetupCopy            *js.Object
+	totpSetupClose           *js.Object
+	addButton                *js.Object
+	loadAllButton            *js.Object
+	unloadAllButton          *js.Object
+	removeAllButton          *js.Object
+	wipeButton               *js.Object
+	wipeDialog               *js.Object
+	wipeConfirm              *js.Object
+	wipeStatus               *js.Object
+	wipeOk                   *js.Object
+	wipeCancel               *js.Object
+	pmImportButton           *js.Object
+	pmImportDialog           *js.Object
+	pmImportFile             *js.Object
+	pmImportStatus           *js.Object
+	pmImportList             *js.Object
+	pmImportDeviceOnly       *js.Object
+	pmImportOk               *js.Object
+	pmImportCancel           *js.Object
+	bulkDialog               *js.Object
+	bulkSummary              *js.Object
+	bulkList                 *js.Object
+	bulkYes                  *js.Object
+	bulkNo                   *js.Object
+	loadAllProgress          *js.Object
+	results                  *js.Object
+	batch                    *keys.Batch
+	addDialog                *js.Object
+	addName                  *js.Object
+	addKey                   *js.Object
+	addCertificate           *js.Object
+	addTOTP                  *js.Object
+	addDeviceOnly            *js.Object
+	addKeyInput              *js.Object
+	addFile                  *js.Object
+	addFileStatus            *js.Object
+	addFindings              *js.Object
+	readFile                 func(o *js.Object, callback func(name, contents string, err error))
+	readFileBytes            func(o *js.Object, callback func(name string, contents []byte, err error))
+	addOk                    *js.Object
+	addCancel                *js.Object
+	generateButton           *js.Object
+	generateDialog           *js.Object
+	generateName             *js.Object
+	generatePassphrase       *js.Object
+	generateType             *js.Object
+	generateDeviceOnly       *js.Object
+	generateOk               *js.Object
+	generateCancel           *js.Object
+	attestationDialog        *js.Object
+	attestationName          *js.Object
+	attestationText          *js.Object
+	attestationCopy          *js.Object
+	attestationClose         *js.Object
+	notesDialog              *js.Object
+	notesName                *js.Object
+	notesText                *js.Object
+	notesOk                  *js.Object
+	notesCancel              *js.Object
+	profileDialog            *js.Object
+	profileName              *js.Object
+	profileText              *js.Object
+	profileOk                *js.Object
+	profileCancel            *js.Object
+	scheduleDialog           *js.Object
+	scheduleName             *js.Object
+	scheduleText             *js.Object
+	scheduleOk               *js.Object
+	scheduleCancel           *js.Object
+	deriveButton             *js.Object
+	deriveDialog             *js.Object
+	deriveService            *js.Object
+	derivePassphrase         *js.Object
+	deriveConfirm            *js.Object
+	deriveOk                 *js.Object
+	deriveCancel             *js.Object
+	fingerprintDialog        *js.Object
+	fingerprintName          *js.Object
+	fingerprintValue         *js.Object
+	fingerprintLoad          *js.Object
+	fingerprintCancel        *js.Object
+	installDialog            *js.Object
+	installName              *js.Object
+	installSnippet           *js.Object
+	installLine              *js.Object
+	installFormat            *js.Object
+	installPublicKey         *js.Object
+	installFrom              *js.Object
+	installCommand           *js.Object
+	installPermitOpen        *js.Object
+	installExpiry            *js.Object
+	installNoPortForwarding  *js.Object
+	installNoAgentForwarding *js.Object
+	installNoX11Forwarding   *js.Object
+	installNoPty             *js.Object
+	installOneLiner          *js.Object
+	installError             *js.Object
+	installCopy              *js.Object
+	installCopyLine          *js.Object
+	installCopyPublicKey     *js.Object
+	installClose             *js.Object
+	exportDialog             *js.Object
+	exportName               *js.Object
+	exportFormat             *js.Object
+	exportCurrent            *js.Object
+	exportPassphrase         *js.Object
+	exportNewPassphrase      *js.Object
+	exportConfirm            *js.Object
+	exportOk                 *js.Object
+	exportCancel             *js.Object
+	exportedDialog           *js.Object
+	exportedName             *js.Object
+	exportedText             *js.Object
+	exportedCopy             *js.Object
+	exportedClose            *js.Object
+	removeDialog             *js.Object
+	removeName               *js.Object
+	removeYes                *js.Object
+	removeNo                 *js.Object
+	removeDependents         *js.Object
+	removeUnload             *js.Object
+	errorText                *js.Object
+	helpButton               *js.Object
+	helpPanel                *js.Object
+	help                     helpState
+	keysData                 *js.Object
+	keys                     []*displayedKey
+	configured               map[keys.ID]*keys.ConfiguredKey
+	sourceFilter             *js.Object
+	storageUsage             *js.Object
+	usage                    *keys.StorageUsage
+	originInput              *js.Object
+	originAllow              *js.Object
+	originsData              *js.Object
+	origins                  []string
+	extensions               *external.ACL
+	extensionNames           *js.Object
+	extensionsData           *js.Object
+	extensionID              string
+	profileDest              *js.Object
+	profileDescription       *js.Object
+	profileAdd               *js.Object
+	profileExport            *js.Object
+	profileCopy              *js.Object
+	profiles                 []*nassh.Profile
+	inventoryFormat          *js.Object
+	inventoryGenerate        *js.Object
+	inventoryExport          *js.Object
+	inventoryCopy            *js.Object
+	auditLog                 *audit.Log
+	errors                   *diagnostics.ErrorLog
+	diagnosticsDownload      *js.Object
+	diagnosticsUpgrade       *js.Object
+	selfCheckEnabled         *js.Object
+	selfCheckSample          *js.Object
+	selfCheckStatus          *js.Object
+	selfCheckResults         *js.Object
+	approvalInput            *js.Object
+	approvalSubmit           *js.Object
+	approvalStatus           *js.Object
+	notifyChannel            *js.Object
+	importFileOnly           *js.Object
+	nativeEnabled            *js.Object
+	idFingerprint            *js.Object
+	idSchemeStatus           *js.Object
+	agentParallelism         *js.Object
+	replayWindow             *js.Object
+	replayBlock              *js.Object
+	loadLifetime             *js.Object
+	loadOnDemand             *js.Object
+	autoLockScreen           *js.Object
+	autoLockIdle             *js.Object
+	autoLockAction           *js.Object
+	presence                 *presence.Directory
+	presenceName             *js.Object
+	presencePassphrase       *js.Object
+	presenceEnable           *js.Object
+	presenceDisable          *js.Object
+	presenceStatus           *js.Object
+	devices                  []*presence.Device
+	vaultStatus              *js.Object
+	vaultCurrent             *js.Object
+	vaultNew                 *js.Object
+	vaultConfirm             *js.Object
+	vaultSet                 *js.Object
+	vaultUnlock              *js.Object
+	inventory                []*keys.InventoryItem
+	countdowns               []*countdown
+	countdownTimer           *time.Timer
+	fileOnly                 bool
+	toasts                   *js.Object
+	clipboard                *clipboard.Clipboard
+}
+
+// New returns a new UI instance that manages keys using the supplied manager,
+// websites approved to use the bridge using acl, and other extensions
+// allowed to connect to the agent using extensions. Approvals for keys
+// entered by the user are stored using approvals. Entries in auditLog are
+// included in diagnostic bundles. Settings are kept in
+// settings, and optional permissions are requested using perms. The keys
+// loaded on the user's other devices are read from dir. All data is erased
+// using wiper. extensionID is the ID of this extension, used when
+// generating Secure Shell connection profiles.  domObj is the DOM instance corresponding
+// to the document in which the Options UI is displayed.
+func New(mgr keys.Manager, acl *bridge.ACL, extensions *external.ACL, approvals *provisioning.Provisioner, auditLog *audit.Log, settings storage.Settings, perms permissions.API, dir *presence.Directory, wiper Wiper, extensionID string, domObj *dom.DOM) *UI {
 	result := &UI{
-		mgr:              mgr,
-		dom:              domObj,
-		passphraseDialog: domObj.GetElement("passphraseDialog"),
-		passphraseInput:  domObj.GetElement("passphrase"),
-		passphraseOk:     domObj.GetElement("passphraseOk"),
-		passphraseCancel: domObj.GetElement("passphraseCancel"),
-		addButton:        domObj.GetElement("add"),
-		addDialog:        domObj.GetElement("addDialog"),
-		addName:          domObj.GetElement("addName"),
-		addKey:           domObj.GetElement("addKey"),
-		addOk:            domObj.GetElement("addOk"),
-		addCancel:        domObj.GetElement("addCancel"),
-		removeDialog:     domObj.GetElement("removeDialog"),
-		removeName:       domObj.GetElement("removeName"),
-		removeYes:        domObj.GetElement("removeYes"),
-		removeNo:         domObj.GetElement("removeNo"),
-		errorText:        domObj.GetElement("errorMessage"),
-		keysData:         domObj.GetElement("keysData"),
+		mgr:                      mgr,
+		loader:                   keys.NewBatchLoader(mgr, loadAllWorkers),
+		acl:                      acl,
+		extensions:               extensions,
+		approvals:                approvals,
+		settings:                 settings,
+		perms:                    perms,
+		wiper:                    wiper,
+		dom:                      domObj,
+		passphraseDialog:         domObj.GetElement("passphraseDialog"),
+		passphraseInput:          domObj.GetElement("passphrase"),
+		passphraseOk:             domObj.GetElement("passphraseOk"),
+		passphraseCancel:         domObj.GetElement("passphraseCancel"),
+		passphraseStatus:         domObj.GetElement("passphraseStatus"),
+		totpSetupDialog:          domObj.GetElement("totpSetupDialog"),
+		totpSetupName:            domObj.GetElement("totpSetupName"),
+		totpSetupSecret:          domObj.GetElement("totpSetupSecret"),
+		totpSetupURI:             domObj.GetElement("totpSetupURI"),
+		totpSetupCopy:            domObj.GetElement("totpSetupCopy"),
+		totpSetupClose:           domObj.GetElement("totpSetupClose"),
+		addButton:                domObj.GetElement("add"),
+		loadAllButton:            domObj.GetElement("loadAll"),
+		unloadAllButton:          domObj.GetElement("unloadAll"),
+		removeAllButton:          domObj.GetElement("removeAll"),
+		wipeButton:               domObj.GetElement("wipe"),
+		wipeDialog:               domObj.GetElement("wipeDialog"),
+		wipeConfirm:              domObj.GetElement("wipeConfirm"),
+		wipeStatus:               domObj.GetElement("wipeStatus"),
+		wipeOk:                   domObj.GetElement("wipeOk"),
+		wipeCancel:               domObj.GetElement("wipeCancel"),
+		pmImportButton:           domObj.GetElement("pmImport"),
+		pmImportDialog:           domObj.GetElement("pmImportDialog"),
+		pmImportFile:             domObj.GetElement("pmImportFile"),
+		pmImportStatus:           domObj.GetElement("pmImportStatus"),
+		pmImportList:             domObj.GetElement("pmImportList"),
+		pmImportDeviceOnly:       domObj.GetElement("pmImportDeviceOnly"),
+		pmImportOk:               domObj.GetElement("pmImportOk"),
+		pmImportCancel:           domObj.GetElement("pmImportCancel"),
+		bulkDialog:               domObj.GetElement("bulkDialog"),
+		bulkSummary:              domObj.GetElement("bulkSummary"),
+		bulkList:                 domObj.GetElement("bulkList"),
+		bulkYes:                  domObj.GetElement("bulkYes"),
+		bulkNo:                   domObj.GetElement("bulkNo"),
+		loadAllProgress:          domObj.GetElement("loadAllProgress"),
+		results:                  domObj.GetElement("results"),
+		addDialog:                domObj.GetElement("addDialog"),
+		addName:                  domObj.GetElement("addName"),
+		addKey:                   domObj.GetElement("addKey"),
+		addCertificate:           domObj.GetElement("addCertificate"),
+		addTOTP:                  domObj.GetElement("addTOTP"),
+		addDeviceOnly:            domObj.GetElement("addDeviceOnly"),
+		addKeyInput:              domObj.GetElement("addKeyInput"),
+		addFile:                  domObj.GetElement("addFile"),
+		addFileStatus:            domObj.GetElement("addFileStatus"),
+		addFindings:              domObj.GetElement("addFindings"),
+		readFile:                 domObj.ReadFile,
+		readFileBytes:            domObj.ReadFileBytes,
+		addOk:                    domObj.GetElement("addOk"),
+		addCancel:                domObj.GetElement("addCancel"),
+		generateButton:           domObj.GetElement("generate"),
+		generateDialog:           domObj.GetElement("generateDialog"),
+		generateName:             domObj.GetElement("generateName"),
+		generatePassphrase:       domObj.GetElement("generatePassphrase"),
+		generateType:             domObj.GetElement("generateType"),
+		generateDeviceOnly:       domObj.GetElement("generateDeviceOnly"),
+		generateOk:               domObj.GetElement("generateOk"),
+		generateCancel:           domObj.GetElement("generateCancel"),
+		attestationDialog:        domObj.GetElement("attestationDialog"),
+		attestationName:          domObj.GetElement("attestationName"),
+		attestationText:          domObj.GetElement("attestationText"),
+		attestationCopy:          domObj.GetElement("attestationCopy"),
+		attestationClose:         domObj.GetElement("attestationClose"),
+		notesDialog:              domObj.GetElement("notesDialog"),
+		notesName:                domObj.GetElement("notesName"),
+		notesText:                domObj.GetElement("notesText"),
+		notesOk:                  domObj.GetElement("notesOk"),
+		notesCancel:              domObj.GetElement("notesCancel"),
+		profileDialog:            domObj.GetElement("profileDialog"),
+		profileName:              domObj.GetElement("profileName"),
+		profileText:              domObj.GetElement("profileText"),
+		profileOk:                domObj.GetElement("profileOk"),
+		profileCancel:            domObj.GetElement("profileCancel"),
+		scheduleDialog:           domObj.GetElement("scheduleDialog"),
+		scheduleName:             domObj.GetElement("scheduleName"),
+		scheduleText:             domObj.GetElement("scheduleText"),
+		scheduleOk:               domObj.GetElement("scheduleOk"),
+		scheduleCancel:           domObj.GetElement("scheduleCancel"),
+		deriveButton:             domObj.GetElement("derive"),
+		deriveDialog:             domObj.GetElement("deriveDialog"),
+		deriveService:            domObj.GetElement("deriveService"),
+		derivePassphrase:         domObj.GetElement("derivePassphrase"),
+		deriveConfirm:            domObj.GetElement("deriveConfirm"),
+		deriveOk:                 domObj.GetElement("deriveOk"),
+		deriveCancel:             domObj.GetElement("deriveCancel"),
+		fingerprintDialog:        domObj.GetElement("fingerprintDialog"),
+		fingerprintName:          domObj.GetElement("fingerprintName"),
+		fingerprintValue:         domObj.GetElement("fingerprintValue"),
+		fingerprintLoad:          domObj.GetElement("fingerprintLoad"),
+		fingerprintCancel:        domObj.GetElement("fingerprintCancel"),
+		installDialog:            domObj.GetElement("installDialog"),
+		installName:              domObj.GetElement("installName"),
+		installSnippet:           domObj.GetElement("installSnippet"),
+		installLine:              domObj.GetElement("installLine"),
+		installFormat:            domObj.GetElement("installFormat"),
+		installPublicKey:         domObj.GetElement("installPublicKey"),
+		installFrom:              domObj.GetElement("installFrom"),
+		installCommand:           domObj.GetElement("installCommand"),
+		installPermitOpen:        domObj.GetElement("installPermitOpen"),
+		installExpiry:            domObj.GetElement("installExpiry"),
+		installNoPortForwarding:  domObj.GetElement("installNoPortForwarding"),
+		installNoAgentForwarding: domObj.GetElement("installNoAgentForwarding"),
+		installNoX11Forwarding:   domObj.GetElement("installNoX11Forwarding"),
+		installNoPty:             domObj.GetElement("installNoPty"),
+		installOneLiner:          domObj.GetElement("installOneLiner"),
+		installError:             domObj.GetElement("installError"),
+		installCopy:              domObj.GetElement("installCopy"),
+		installCopyLine:          domObj.GetElement("installCopyLine"),
+		installCopyPublicKey:     domObj.GetElement("installCopyPublicKey"),
+		installClose:             domObj.GetElement("installClose"),
+		exportDialog:             domObj.GetElement("exportDialog"),
+		exportName:               domObj.GetElement("exportName"),
+		exportFormat:             domObj.GetElement("exportFormat"),
+		exportCurrent:            domObj.GetElement("exportCurrent"),
+		exportPassphrase:         domObj.GetElement("exportPassphrase"),
+		exportNewPassphrase:      domObj.GetElement("exportNewPassphrase"),
+		exportConfirm:            domObj.GetElement("exportConfirm"),
+		exportOk:                 domObj.GetElement("exportOk"),
+		exportCancel:             domObj.GetElement("exportCancel"),
+		exportedDialog:           domObj.GetElement("exportedDialog"),
+		exportedName:             domObj.GetElement("exportedName"),
+		exportedText:             domObj.GetElement("exportedText"),
+		exportedCopy:             domObj.GetElement("exportedCopy"),
+		exportedClose:            domObj.GetElement("exportedClose"),
+		removeDialog:             domObj.GetElement("removeDialog"),
+		removeName:               domObj.GetElement("removeName"),
+		removeYes:                domObj.GetElement("removeYes"),
+		removeNo:                 domObj.GetElement("removeNo"),
+		removeDependents:         domObj.GetElement("removeDependents"),
+		removeUnload:             domObj.GetElement("removeUnload"),
+		errorText:                domObj.GetElement("errorMessage"),
+		helpButton:               domObj.GetElement("help"),
+		helpPanel:                domObj.GetElement("helpPanel"),
+		keysData:                 domObj.GetElement("keysData"),
+		sourceFilter:             domObj.GetElement("sourceFilter"),
+		storageUsage:             domObj.GetElement("storageUsage"),
+		originInput:              domObj.GetElement("originInput"),
+		originAllow:              domObj.GetElement("originAllow"),
+		originsData:              domObj.GetElement("originsData"),
+		extensionNames:           domObj.GetElement("extensionNames"),
+		extensionsData:           domObj.GetElement("extensionsData"),
+		extensionID:              extensionID,
+		profileDest:              domObj.GetElement("profileDest"),
+		profileDescription:       domObj.GetElement("profileDescription"),
+		profileAdd:               domObj.GetElement("profileAdd"),
+		profileExport:            domObj.GetElement("profileExport"),
+		profileCopy:              domObj.GetElement("profileCopy"),
+		inventoryFormat:          domObj.GetElement("inventoryFormat"),
+		inventoryGenerate:        domObj.GetElement("inventoryGenerate"),
+		inventoryExport:          domObj.GetElement("inventoryExport"),
+		inventoryCopy:            domObj.GetElement("inventoryCopy"),
+		auditLog:                 auditLog,
+		errors:                   diagnostics.NewErrorLog(recentErrors),
+		diagnosticsDownload:      domObj.GetElement("diagnosticsDownload"),
+		diagnosticsUpgrade:       domObj.GetElement("diagnosticsUpgrade"),
+		selfCheckEnabled:         domObj.GetElement("selfCheckEnabled"),
+		selfCheckSample:          domObj.GetElement("selfCheckSample"),
+		selfCheckStatus:          domObj.GetElement("selfCheckStatus"),
+		selfCheckResults:         domObj.GetElement("selfCheckResults"),
+		approvalInput:            domObj.GetElement("approvalInput"),
+		approvalSubmit:           domObj.GetElement("approvalSubmit"),
+		approvalStatus:           domObj.GetElement("approvalStatus"),
+		notifyChannel:            domObj.GetElement("notifyChannel"),
+		importFileOnly:           domObj.GetElement("importFileOnly"),
+		nativeEnabled:            domObj.GetElement("nativeEnabled"),
+		idFingerprint:            domObj.GetElement("idFingerprint"),
+		idSchemeStatus:           domObj.GetElement("idSchemeStatus"),
+		agentParallelism:         domObj.GetElement("agentParallelism"),
+		replayWindow:             domObj.GetElement("replayWindow"),
+		replayBlock:              domObj.GetElement("replayBlock"),
+		loadLifetime:             domObj.GetElement("loadLifetime"),
+		loadOnDemand:             domObj.GetElement("loadOnDemand"),
+		autoLockScreen:           domObj.GetElement("autoLockScreen"),
+		autoLockIdle:             domObj.GetElement("autoLockIdle"),
+		autoLockAction:           domObj.GetElement("autoLockAction"),
+		presence:                 dir,
+		presenceName:             domObj.GetElement("presenceName"),
+		presencePassphrase:       domObj.GetElement("presencePassphrase"),
+		presenceEnable:           domObj.GetElement("presenceEnable"),
+		presenceDisable:          domObj.GetElement("presenceDisable"),
+		presenceStatus:           domObj.GetElement("presenceStatus"),
+		vaultStatus:              domObj.GetElement("vaultStatus"),
+		vaultCurrent:             domObj.GetElement("vaultCurrent"),
+		vaultNew:                 domObj.GetElement("vaultNew"),
+		vaultConfirm:             domObj.GetElement("vaultConfirm"),
+		vaultSet:                 domObj.GetElement("vaultSet"),
+		vaultUnlock:              domObj.GetElement("vaultUnlock"),
+		toasts:                   domObj.GetElement("toasts"),
 	}
+	result.clipboard = clipboard.New(domObj, clipboard.DefaultClearDelay, result.clipboardCleared)
 
 	// Populate keys on initial display
 	result.dom.OnDOMContentLoaded(result.updateKeys)
+	// Populate approved websites on initial display
+	result.dom.OnDOMContentLoaded(result.updateOrigins)
+	// Populate source filter on initial display
+	result.dom.OnDOMContentLoaded(result.populateSourceFilter)
+	// Populate public key formats on initial display
+	result.dom.OnDOMContentLoaded(result.populatePublicFormats)
+	// Populate private key formats on initial display
+	result.dom.OnDOMContentLoaded(result.populatePrivateFormats)
+	// Populate inventory formats on initial display
+	result.dom.OnDOMContentLoaded(result.populateInventoryFormats)
+	// Populate notification channels on initial display
+	result.dom.OnDOMContentLoaded(result.populateNotifyChannels)
+	// Store the notification channel when it changes
+	result.dom.OnChange(result.notifyChannel, result.setNotifyChannel)
+	// Apply the key import mode on initial display
+	result.dom.OnDOMContentLoaded(result.populateImportMode)
+	// Store the key import mode when it changes
+	result.dom.OnChange(result.importFileOnly, result.setImportMode)
+	// Display whether command-line clients are allowed on initial display
+	result.dom.OnDOMContentLoaded(result.populateNativeEnabled)
+	// Request or relinquish access for command-line clients when changed
+	result.dom.OnChange(result.nativeEnabled, result.setNativeEnabled)
+	// Display the ID scheme on initial display
+	result.dom.OnDOMContentLoaded(result.populateIDScheme)
+	// Store the ID scheme and migrate existing keys when it changes
+	result.dom.OnChange(result.idFingerprint, result.setIDScheme)
+	// Display the sign request parallelism on initial display
+	result.dom.OnDOMContentLoaded(result.populateParallelism)
+	// Store the sign request parallelism when it changes
+	result.dom.OnChange(result.agentParallelism, result.setParallelism)
+	// Display the replay detection settings on initial display
+	result.dom.OnDOMContentLoaded(result.populateReplay)
+	// Store the replay detection settings when they change
+	result.dom.OnChange(result.replayWindow, result.setReplay)
+	result.dom.OnChange(result.replayBlock, result.setReplay)
+	// Display whether loaded keys are shared on initial display
+	result.dom.OnDOMContentLoaded(result.populatePresence)
+	// Start or stop sharing loaded keys when requested
+	result.dom.OnClick(result.presenceEnable, result.enablePresence)
+	result.dom.OnClick(result.presenceDisable, result.disablePresence)
+	result.dom.OnDOMContentLoaded(result.populateVault)
+	result.dom.OnClick(result.vaultSet, result.setMasterPassphrase)
+	result.dom.OnClick(result.vaultUnlock, result.unlockVault)
+	// Populate key lifetimes on initial display
+	result.dom.OnDOMContentLoaded(result.populateLoadLifetime)
+	// Store the key lifetime when it changes
+	result.dom.OnChange(result.loadLifetime, result.setLoadLifetime)
+	// Display whether keys are loaded on demand on initial display
+	result.dom.OnDOMContentLoaded(result.populateOnDemand)
+	// Store whether keys are loaded on demand when it changes
+	result.dom.OnChange(result.loadOnDemand, result.setOnDemand)
+	// Display the auto-lock policy on initial display
+	result.dom.OnDOMContentLoaded(result.populateAutoLock)
+	// Store the auto-lock policy when any part of it changes
+	result.dom.OnChange(result.autoLockScreen, result.setAutoLock)
+	result.dom.OnChange(result.autoLockIdle, result.setAutoLock)
+	result.dom.OnChange(result.autoLockAction, result.setAutoLock)
+	// Redisplay keys when the source filter changes
+	result.dom.OnChange(result.sourceFilter, result.updateDisplayedKeys)
 	// Configure new key on click
 	result.dom.OnClick(result.addButton, result.add)
+	// Generate new key on click
+	result.dom.OnClick(result.generateButton, result.generate)
+	// Derive key from a passphrase on click
+	result.dom.OnClick(result.deriveButton, result.derive)
+	// Import keys exported from a password manager on click
+	result.dom.OnClick(result.pmImportButton, result.importFromPasswordManager)
+	// Load all keys on click
+	result.dom.OnClick(result.loadAllButton, result.loadAll)
+	// Unload all keys on click, after confirming which will be unloaded
+	result.dom.OnClick(result.unloadAllButton, result.unloadAll)
+	// Remove all keys on click, after confirming which will be removed
+	result.dom.OnClick(result.removeAllButton, result.removeAll)
+	// Wipe all data on click, after the user types the confirmation
+	result.dom.OnClick(result.wipeButton, result.wipe)
+	// Approve website on click
+	result.dom.OnClick(result.originAllow, result.allowOrigin)
+	// Display the extensions that have asked to connect on initial display
+	result.dom.OnDOMContentLoaded(result.updateExtensions)
+	// Request permission to read extension names on click
+	result.dom.OnClick(result.extensionNames, result.showExtensionNames)
+	// Add Secure Shell connection profile on click
+	result.dom.OnClick(result.profileAdd, result.addProfile)
+	// Copy exported Secure Shell connection profiles on click
+	result.dom.OnClick(result.profileCopy, result.copyProfiles)
+	// Export key inventory on click
+	result.dom.OnClick(result.inventoryGenerate, result.generateInventory)
+	// Copy exported key inventory on click
+	result.dom.OnClick(result.inventoryCopy, result.copyInventory)
+	// Download a diagnostic bundle on click
+	result.dom.OnClick(result.diagnosticsDownload, result.downloadDiagnostics)
+	// Display keys upgraded from older versions on initial display
+	result.dom.OnDOMContentLoaded(result.RefreshUpgrade)
+	// Display the self-check settings and report on initial display
+	result.dom.OnDOMContentLoaded(result.populateSelfCheck)
+	// Store the self-check settings when they change
+	result.dom.OnChange(result.selfCheckEnabled, result.setSelfCheck)
+	result.dom.OnChange(result.selfCheckSample, result.setSelfCheck)
+	// Store an administrator's approval for a key on click
+	result.dom.OnClick(result.approvalSubmit, result.submitApproval)
+	// Display help on click
+	result.dom.OnClick(result.helpButton, result.toggleHelp)
 	return result
 }
 
-// setError updates the UI to display the supplied error. If the supplied error
-// is nil, then any displayed error is cleared.
+// setError updates the UI to display the supplied error, along with any
+// troubleshooting guidance associated with it. If the supplied error is nil,
+// then any displayed error is cleared.
 func (u *UI) setError(err error) {
 	// Clear any existing error
 	u.dom.RemoveChildren(u.errorText)
 
 	if err != nil {
-		u.dom.AppendChild(u.errorText, u.dom.NewText(err.Error()), nil)
+		u.dom.AppendChild(u.errorText, u.dom.NewText(redact.String(err.Error())), nil)
+		u.errors.Record(err)
 	}
+	u.showErrorHelp(err)
 }
 
 // add configures a new key.  It displays a dialog prompting the user for a name
 // and the corresponding private key.  If the user continues, the key is
 // added to the manager.
 func (u *UI) add() {
-	u.promptAdd(func(name, privateKey string, ok bool) {
+	u.addWith("", "", "", "", false, false)
+}
+
+// addWith configures a new key, displaying a dialog initially populated with
+// the supplied values.  file is the name of the file from which the private
+// key was read, or empty if it was pasted.  certificate is an optional
+// OpenSSH certificate for the key.  requireCode indicates that signatures
+// using the key must be confirmed with a code from an authenticator app; a
+// secret is generated and displayed once the key is added.  If the name is
+// already taken, the dialog is displayed again with a suggested alternative.
+func (u *UI) addWith(name, privateKey, certificate, file string, deviceOnly, requireCode bool) {
+	u.promptAdd(name, privateKey, certificate, file, deviceOnly, requireCode, func(name, privateKey, certificate, file string, deviceOnly, requireCode bool, ok bool) {
 		if !ok {
 			return
 		}
-		u.mgr.Add(name, privateKey, func(err error) {
+		opts := &keys.AddOptions{
+			DeviceOnly:  deviceOnly,
+			Source:      keys.SourcePasted,
+			UniqueName:  true,
+			Certificate: certificate,
+		}
+		if file != "" {
+			opts.Source = keys.SourceFile
+			opts.SourceDetail = file
+		}
+		if requireCode {
+			secret, err := totp.GenerateSecret(nil)
 			if err != nil {
 				u.setError(fmt.Errorf("failed to add key: %v", err))
 				return
 			}
-
-			u.setError(nil)
-			u.updateKeys()
+			opts.TOTPSecret = secret
+		}
+		// Keys in PuTTY's format are converted when they are added,
+		// which requires the passphrase of an encrypted one.
+		if !keyformat.PPKEncrypted([]byte(privateKey)) {
+			u.finishAdd(name, privateKey, certificate, file, deviceOnly, requireCode, opts)
+			return
+		}
+		u.promptPassphrase(func(passphrase string, ok bool) {
+			if !ok {
+				return
+			}
+			opts.Passphrase = passphrase
+			u.finishAdd(name, privateKey, certificate, file, deviceOnly, requireCode, opts)
 		})
 	})
 }
 
-// promptAdd displays a dialog prompting the user for a name and private key.
-// callback is invoked when the dialog is closed; the ok parameter indicates
-// if the user clicked OK.
-func (u *UI) promptAdd(callback func(name, privateKey string, ok bool)) {
-	u.dom.OnClick(u.addOk, func() {
-		n := u.dom.Value(u.addName)
-		k := u.dom.Value(u.addKey)
+// finishAdd adds a key, as configured by addWith.  If the name is taken, the
+// user is prompted again with a suggested alternative.
+func (u *UI) finishAdd(name, privateKey, certificate, file string, deviceOnly, requireCode bool, opts *keys.AddOptions) {
+	u.mgr.Add(name, privateKey, opts, func(err error) {
+		if help.CodeOf(err) == help.NameTaken {
+			suggestion := keys.SuggestName(name, u.displayedNames())
+			u.setError(help.Errorf(help.NameTaken, "failed to add key: a key named %q already exists; try %q instead", name, suggestion))
+			u.addWith(suggestion, privateKey, certificate, file, deviceOnly, requireCode)
+			return
+		}
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to add key"))
+			return
+		}
+
+		u.setError(nil)
+		u.updateKeys()
+		if opts.TOTPSecret != "" {
+			u.showTOTPSetup(name, opts.TOTPSecret)
+		}
+	})
+}
+
+// displayName returns the name by which a displayed key is listed.  Keys
+// that share a name are listed by their nickname, which tells them apart.
+func (u *UI) displayName(k *displayedKey) string {
+	if ck := u.configured[k.ID]; ck != nil {
+		return ck.DisplayName()
+	}
+	return k.Name
+}
+
+// displayedNames returns the names of the keys currently displayed.
+func (u *UI) displayedNames() []string {
+	var result []string
+	for _, k := range u.keys {
+		if k.Name != "" {
+			result = append(result, k.Name)
+		}
+	}
+	return result
+}
+
+// promptAdd displays a dialog prompting the user for a name and private key,
+// an optional certificate, whether the key should be stored only on this
+// device, and whether signatures must be confirmed with a code.  The private key may be pasted, or read from a file.  The dialog is initially populated with
+// the supplied values.  callback is invoked when the dialog is closed; the ok
+// parameter indicates if the user clicked OK, and file is the name of the
+// file from which the private key was read, if any.
+//
+// If file-only import is enabled, the private key is never placed in the
+// DOM; it is held only by this function until the callback is invoked.
+func (u *UI) promptAdd(name, privateKey, certificate, file string, deviceOnly, requireCode bool, callback func(name, privateKey, certificate, file string, deviceOnly, requireCode bool, ok bool)) {
+	fileOnly := u.fileOnly
+	u.dom.SetValue(u.addName, name)
+	if !fileOnly {
+		u.dom.SetValue(u.addKey, privateKey)
+	}
+	u.dom.SetValue(u.addCertificate, certificate)
+	u.dom.SetChecked(u.addDeviceOnly, deviceOnly)
+	u.dom.SetChecked(u.addTOTP, requireCode)
+	u.showAddFile(file)
+	u.showAddFindings(privateKey)
+	u.dom.OnInput(u.addKey, func() {
+		u.showAddFindings(u.dom.Value(u.addKey))
+	})
+	u.dom.OnChange(u.addFile, func() {
+		u.readFile(u.addFile, func(name, contents string, err error) {
+			if err != nil {
+				u.setError(err)
+				return
+			}
+			privateKey, file = contents, name
+			if !fileOnly {
+				u.dom.SetValue(u.addKey, contents)
+			}
+			u.showAddFile(file)
+			u.showAddFindings(contents)
+		})
+	})
+	reset := func() {
 		u.dom.SetValue(u.addName, "")
 		u.dom.SetValue(u.addKey, "")
+		u.dom.SetValue(u.addCertificate, "")
+		u.dom.SetValue(u.addFile, "")
+		u.dom.SetChecked(u.addDeviceOnly, false)
+		u.dom.SetChecked(u.addTOTP, false)
+		u.showAddFile("")
+		u.showAddFindings("")
+		u.addKey = u.dom.RemoveEventListeners(u.addKey)
 		u.addOk = u.dom.RemoveEventListeners(u.addOk)
 		u.addCancel = u.dom.RemoveEventListeners(u.addCancel)
+		u.addFile = u.dom.RemoveEventListeners(u.addFile)
 		u.dom.Close(u.addDialog)
-		callback(n, k, true)
+	}
+	u.dom.OnClick(u.addOk, func() {
+		n := u.dom.Value(u.addName)
+		k := privateKey
+		if !fileOnly {
+			k = u.dom.Value(u.addKey)
+			if k != privateKey {
+				// Edited after being read; treat it as pasted.
+				file = ""
+			}
+		}
+		c := u.dom.Value(u.addCertificate)
+		d := u.dom.Checked(u.addDeviceOnly)
+		r := u.dom.Checked(u.addTOTP)
+		reset()
+		callback(n, k, c, file, d, r, true)
 	})
 	u.dom.OnClick(u.addCancel, func() {
-		u.dom.SetValue(u.addName, "")
-		u.dom.SetValue(u.addKey, "")
-		u.addOk = u.dom.RemoveEventListeners(u.addOk)
-		u.addCancel = u.dom.RemoveEventListeners(u.addCancel)
-		u.dom.Close(u.addDialog)
-		callback("", "", false)
+		reset()
+		callback("", "", "", "", false, false, false)
 	})
 	u.dom.ShowModal(u.addDialog)
 }
 
+// showAddFile displays the name of the file from which the private key was
+// read in the Add dialog.
+func (u *UI) showAddFile(file string) {
+	u.dom.RemoveChildren(u.addFileStatus)
+	if file != "" {
+		u.dom.AppendChild(u.addFileStatus, u.dom.NewText(fmt.Sprintf("Read private key from %s", file)), nil)
+	}
+}
+
+// showAddFindings lists the problems keys.Lint finds with the private key in
+// the Add dialog, so the user can address them before adding it.
+func (u *UI) showAddFindings(privateKey string) {
+	u.dom.RemoveChildren(u.addFindings)
+	for _, f := range keys.Lint(privateKey) {
+		msg := f.Message
+		u.dom.AppendChild(u.addFindings, u.dom.NewElement("li"), func(item *js.Object) {
+			u.dom.AppendChild(item, u.dom.NewText(msg), nil)
+		})
+	}
+}
+
 // load loads the key with the specified ID.  A dialog prompts the user for a
-// passphrase if the private key is encrypted.
+// passphrase if the private key is encrypted.  If the passphrase is
+// incorrect, the dialog is displayed again until the user has made
+// keys.DefaultPassphraseAttempts attempts.
 func (u *UI) load(id keys.ID, encrypted bool) {
-	prompt := u.promptPassphrase
+	prompt := keys.PassphraseFunc(u.promptPassphraseAttempt)
 	if !encrypted {
 		// Use a dummy callback that doesn't actually prompt if no
 		// passphrase is required.
-		prompt = func(callback func(passphrase string, ok bool)) {
+		prompt = func(attempt, remaining int, lastErr error, callback func(passphrase string, ok bool)) {
 			callback("", true)
 		}
 	}
 
-	prompt(func(passphrase string, ok bool) {
-		if !ok {
+	keys.LoadWithPassphrase(u.mgr, id, keys.DefaultPassphraseAttempts, prompt, func(err error) {
+		if err == keys.ErrLoadCancelled {
 			return
 		}
-		u.mgr.Load(id, passphrase, func(err error) {
-			if err != nil {
-				u.setError(fmt.Errorf("failed to load key: %v", err))
-				return
-			}
-			u.setError(nil)
-			u.updateKeys()
-		})
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to load key"))
+			return
+		}
+		u.setError(nil)
+		u.updateKeys()
 	})
 }
 
+// promptPassphraseAttempt displays a dialog prompting the user for a
+// passphrase to load a key.  If a previous attempt failed, the dialog reports
+// why, along with the number of attempts remaining.  It implements
+// keys.PassphraseFunc.
+func (u *UI) promptPassphraseAttempt(attempt, remaining int, lastErr error, callback func(passphrase string, ok bool)) {
+	state := newLoadDialogState(attempt, remaining, lastErr)
+	u.dom.RemoveChildren(u.passphraseStatus)
+	if state.Status != "" {
+		u.dom.AppendChild(u.passphraseStatus, u.dom.NewText(state.Status), nil)
+	}
+	u.promptPassphrase(callback)
+}
+
 // promptPassphrase displays a dialog prompting the user for a passphrase.
 // callback is invoked when the dialog is closed; the ok parameter indicates
 // if the user clicked OK.
@@ -178,6 +834,7 @@ func (u *UI) promptPassphrase(callback func(passphrase string, ok bool)) {
 	u.dom.OnClick(u.passphraseOk, func() {
 		p := u.dom.Value(u.passphraseInput)
 		u.dom.SetValue(u.passphraseInput, "")
+		u.dom.RemoveChildren(u.passphraseStatus)
 		u.passphraseOk = u.dom.RemoveEventListeners(u.passphraseOk)
 		u.passphraseCancel = u.dom.RemoveEventListeners(u.passphraseCancel)
 		u.dom.Close(u.passphraseDialog)
@@ -185,6 +842,7 @@ func (u *UI) promptPassphrase(callback func(passphrase string, ok bool)) {
 	})
 	u.dom.OnClick(u.passphraseCancel, func() {
 		u.dom.SetValue(u.passphraseInput, "")
+		u.dom.RemoveChildren(u.passphraseStatus)
 		u.passphraseOk = u.dom.RemoveEventListeners(u.passphraseOk)
 		u.passphraseCancel = u.dom.RemoveEventListeners(u.passphraseCancel)
 		u.dom.Close(u.passphraseDialog)
@@ -197,7 +855,7 @@ func (u *UI) promptPassphrase(callback func(passphrase string, ok bool)) {
 func (u *UI) unload(key *keys.LoadedKey) {
 	u.mgr.Unload(key, func(err error) {
 		if err != nil {
-			u.setError(fmt.Errorf("failed to unload key: %v", err))
+			u.setError(help.Wrap(err, "failed to unload key"))
 			return
 		}
 		u.setError(nil)
@@ -206,48 +864,115 @@ func (u *UI) unload(key *keys.LoadedKey) {
 }
 
 // promptRemove displays a dialog prompting the user to confirm that a key
-// should be removed. callback is invoked when the dialog is closed; the yes
-// parameter indicates if the user clicked Yes.
-func (u *UI) promptRemove(name string, callback func(yes bool)) {
+// should be removed.  deps lists the data that depends on the key.  If the
+// key is loaded, the user must explicitly choose between unloading it too,
+// or leaving it loaded.  callback is invoked when the dialog is closed; the
+// yes parameter indicates if the user confirmed, and unload if the key
+// should also be unloaded.
+func (u *UI) promptRemove(name string, deps []*keys.Dependency, callback func(yes, unload bool)) {
 	u.dom.RemoveChildren(u.removeName)
 	u.dom.AppendChild(u.removeName, u.dom.NewText(name), nil)
-	u.dom.OnClick(u.removeYes, func() {
-		u.dom.RemoveChildren(u.removeName)
-		u.removeYes = u.dom.RemoveEventListeners(u.removeYes)
-		u.removeNo = u.dom.RemoveEventListeners(u.removeNo)
-		u.dom.Close(u.removeDialog)
-		callback(true)
-	})
-	u.dom.OnClick(u.removeNo, func() {
+	loaded := false
+	for _, d := range deps {
+		d := d
+		loaded = loaded || d.Kind == keys.DependsLoaded
+		u.dom.AppendChild(u.removeDependents, u.dom.NewElement("li"), func(item *js.Object) {
+			u.dom.AppendChild(item, u.dom.NewText(d.Description), nil)
+		})
+	}
+	if loaded {
+		u.dom.SetValue(u.removeYes, "Remove, Keep Loaded")
+	}
+	u.removeUnload.Set("hidden", !loaded)
+	done := func(yes, unload bool) {
 		u.dom.RemoveChildren(u.removeName)
+		u.dom.RemoveChildren(u.removeDependents)
+		u.dom.SetValue(u.removeYes, "Yes")
+		u.removeUnload.Set("hidden", true)
 		u.removeYes = u.dom.RemoveEventListeners(u.removeYes)
+		u.removeUnload = u.dom.RemoveEventListeners(u.removeUnload)
 		u.removeNo = u.dom.RemoveEventListeners(u.removeNo)
 		u.dom.Close(u.removeDialog)
-		callback(false)
-	})
+		callback(yes, unload)
+	}
+	u.dom.OnClick(u.removeYes, func() { done(true, false) })
+	u.dom.OnClick(u.removeUnload, func() { done(true, true) })
+	u.dom.OnClick(u.removeNo, func() { done(false, false) })
 	u.dom.ShowModal(u.removeDialog)
 }
 
-// remove removes the key with the specified ID.  A dialog prompts the user to
-// confirm that the key should be removed.
+// remove removes the key with the specified ID.  A dialog lists the data
+// that depends on the key, and prompts the user to confirm that the key
+// should be removed.
 func (u *UI) remove(id keys.ID, name string) {
-	u.promptRemove(name, func(yes bool) {
-		if !yes {
+	keys.Dependencies(u.mgr, id, func(deps []*keys.Dependency, err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to remove key"))
 			return
 		}
 
-		u.mgr.Remove(id, func(err error) {
-			if err != nil {
-				u.setError(fmt.Errorf("failed to remove key: %v", err))
+		u.promptRemove(name, deps, func(yes, unload bool) {
+			if !yes {
 				return
 			}
 
-			u.setError(nil)
-			u.updateKeys()
+			remove := u.mgr.Remove
+			if unload {
+				remove = func(id keys.ID, callback func(err error)) {
+					keys.RemoveAndUnload(u.mgr, id, callback)
+				}
+			}
+			remove(id, func(err error) {
+				if err != nil {
+					u.setError(help.Wrap(err, "failed to remove key"))
+					return
+				}
+
+				u.setError(nil)
+				u.updateKeys()
+			})
 		})
 	})
 }
 
+// setCanary marks or unmarks the key with the specified ID as a canary.
+func (u *UI) setCanary(id keys.ID, canary bool) {
+	u.mgr.SetCanary(id, canary, func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to update key"))
+			return
+		}
+		u.setError(nil)
+		u.updateKeys()
+	})
+}
+
+// setLoadOnStartup sets whether the key with the specified ID is loaded when
+// Chrome starts.
+func (u *UI) setLoadOnStartup(id keys.ID, enabled bool) {
+	u.mgr.SetLoadOnStartup(id, enabled, func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to update key"))
+			return
+		}
+		u.setError(nil)
+		u.updateKeys()
+	})
+}
+
+// setRevoked revokes the key with the specified ID, or clears its
+// revocation.
+func (u *UI) setRevoked(id keys.ID, revoked bool) {
+	u.mgr.SetRevoked(id, revoked, func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to update key"))
+			return
+		}
+		u.setError(nil)
+		u.updateKeys()
+	})
+}
+
 // displayedKey represents a key displayed in the UI.
 type displayedKey struct {
 	// ID is the unique ID corresponding to the key.
@@ -260,10 +985,15 @@ type displayedKey struct {
 	Encrypted bool
 	// Name is the human-readable name assigned to the key.
 	Name string
+	// DeviceOnly indicates the key is stored only on this device.
+	DeviceOnly bool
 	// Type is the type of key (e.g., 'ssh-rsa').
 	Type string
 	// Blob is the public key material for the key.
 	Blob string
+	// Expires is the time at which the key expires, in milliseconds
+	// since the epoch, or zero if it was loaded without a lifetime.
+	Expires int64
 }
 
 func (d *displayedKey) LoadedKey() (*keys.LoadedKey, error) {
@@ -272,9 +1002,9 @@ func (d *displayedKey) LoadedKey() (*keys.LoadedKey, error) {
 		return nil, fmt.Errorf("failed to decode blob: %v", err)
 	}
 
-	l := &keys.LoadedKey{Object: js.Global.Get("Object").New()}
+	l := &keys.LoadedKey{}
 	l.Type = d.Type
-	l.SetBlob(blob)
+	l.Blob = blob
 	return l, nil
 }
 
@@ -294,6 +1024,34 @@ const (
 	UnloadButton
 	// RemoveButton indicates that the button removes the key.
 	RemoveButton
+	// InstallButton indicates that the button displays the command to
+	// install the key on a server.
+	InstallButton
+	// AttestationButton indicates that the button displays the
+	// attestation produced when the key was generated.
+	AttestationButton
+	// ExportButton indicates that the button exports the private key.
+	ExportButton
+	// CanaryButton indicates that the button marks or unmarks the key as
+	// a canary.
+	CanaryButton
+	// NotesButton indicates that the button edits the notes for the key.
+	NotesButton
+	// ExtendButton indicates that the button loads the key again,
+	// restarting its lifetime.
+	ExtendButton
+	// RevokeButton indicates that the button revokes the key, or clears
+	// its revocation.
+	RevokeButton
+	// ProfileButton indicates that the button moves the key to another
+	// profile.
+	ProfileButton
+	// StartupButton indicates that the button sets whether the key is
+	// loaded when Chrome starts.
+	StartupButton
+	// ScheduleButton indicates that the button edits the schedule on
+	// which the key is loaded and unloaded.
+	ScheduleButton
 )
 
 // buttonID returns the value of the 'id' attribute to be assigned to the HTML
@@ -307,6 +1065,26 @@ func buttonID(kind buttonKind, id keys.ID) string {
 		s = "unload"
 	case RemoveButton:
 		s = "remove"
+	case InstallButton:
+		s = "install"
+	case AttestationButton:
+		s = "attestation"
+	case ExportButton:
+		s = "export"
+	case CanaryButton:
+		s = "canary"
+	case NotesButton:
+		s = "notes"
+	case ExtendButton:
+		s = "extend"
+	case RevokeButton:
+		s = "revoke"
+	case ProfileButton:
+		s = "profile"
+	case StartupButton:
+		s = "startup"
+	case ScheduleButton:
+		s = "schedule"
 	}
 	return fmt.Sprintf("%s-%s", s, id)
 }
@@ -315,82 +1093,164 @@ func buttonID(kind buttonKind, id keys.ID) string {
 // displayed.
 func (u *UI) updateDisplayedKeys() {
 	u.dom.RemoveChildren(u.keysData)
+	u.countdowns = nil
+	for _, r := range u.keyRows() {
+		u.renderKeyRow(r)
+	}
+	u.scheduleCountdowns()
+}
 
-	for _, k := range u.keys {
-		k := k
-		u.dom.AppendChild(u.keysData, u.dom.NewElement("tr"), func(row *js.Object) {
-			// Key name
-			u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
-				u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
-					div.Set("className", "keyName")
-					u.dom.AppendChild(div, u.dom.NewText(k.Name), nil)
-				})
-			})
-
-			// Controls
-			u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
-				u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
-					div.Set("className", "keyControls")
-					if k.ID == keys.InvalidID {
-						// We only control keys with a valid ID.
-						return
-					}
-
-					if k.Loaded {
-						// Unload button
-						u.dom.AppendChild(div, u.dom.NewElement("button"), func(btn *js.Object) {
-							btn.Set("type", "button")
-							btn.Set("id", buttonID(UnloadButton, k.ID))
-							u.dom.AppendChild(btn, u.dom.NewText("Unload"), nil)
-							u.dom.OnClick(btn, func() {
-								l, err := k.LoadedKey()
-								if err != nil {
-									u.setError(fmt.Errorf("Failed to get loaded key: %v", err))
-									return
-								}
-								u.unload(l)
-							})
-						})
-					} else {
-						// Load button
-						u.dom.AppendChild(div, u.dom.NewElement("button"), func(btn *js.Object) {
-							btn.Set("type", "button")
-							btn.Set("id", buttonID(LoadButton, k.ID))
-							u.dom.AppendChild(btn, u.dom.NewText("Load"), nil)
-							u.dom.OnClick(btn, func() {
-								u.load(k.ID, k.Encrypted)
-							})
-						})
-					}
+// renderKeyRow appends a row describing a key to the keys table.
+func (u *UI) renderKeyRow(r *KeyRow) {
+	u.dom.AppendChild(u.keysData, u.dom.NewElement("tr"), func(row *js.Object) {
+		// Key name
+		u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
+			u.renderKeyDetail(cell, r.ID, r.Detail)
+		})
 
-					// Remove button
+		// Controls
+		u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
+			u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
+				div.Set("className", "keyControls")
+				for _, b := range r.Buttons {
+					b := b
 					u.dom.AppendChild(div, u.dom.NewElement("button"), func(btn *js.Object) {
 						btn.Set("type", "button")
-						btn.Set("id", buttonID(RemoveButton, k.ID))
-						u.dom.AppendChild(btn, u.dom.NewText("Remove"), nil)
+						btn.Set("id", buttonID(b.Kind, r.ID))
+						if b.Title != "" {
+							btn.Set("title", b.Title)
+						}
+						u.dom.AppendChild(btn, u.dom.NewText(b.Label), nil)
 						u.dom.OnClick(btn, func() {
-							u.remove(k.ID, k.Name)
+							u.keyAction(b.Kind, r.key)
 						})
 					})
-				})
+				}
 			})
+		})
 
-			// Type
-			u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
-				u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
-					div.Set("className", "keyType")
-					u.dom.AppendChild(div, u.dom.NewText(k.Type), nil)
-				})
+		// Type
+		u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
+			u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
+				div.Set("className", "keyType")
+				u.dom.AppendChild(div, u.dom.NewText(r.Type), nil)
 			})
+		})
 
-			// Blob
-			u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
-				u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
-					div.Set("className", "keyBlob")
-					u.dom.AppendChild(div, u.dom.NewText(k.Blob), nil)
-				})
+		// Blob
+		u.dom.AppendChild(row, u.dom.NewElement("td"), func(cell *js.Object) {
+			u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
+				div.Set("className", "keyBlob")
+				u.dom.AppendChild(div, u.dom.NewText(r.Blob), nil)
 			})
 		})
+	})
+}
+
+// renderKeyDetail appends the description of the key with the specified ID
+// to a cell of the keys table: its name and badges, followed by its
+// fingerprint, its notes, its schedule and any warnings about its
+// certificate.
+func (u *UI) renderKeyDetail(cell *js.Object, id keys.ID, d *KeyDetail) {
+	u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
+		div.Set("className", "keyName")
+		if d.Provenance != "" {
+			div.Set("title", d.Provenance)
+		}
+		u.dom.AppendChild(div, u.dom.NewText(d.Name), nil)
+		if d.Size != "" {
+			u.dom.AppendChild(div, u.dom.NewElement("span"), func(size *js.Object) {
+				size.Set("className", "keySize")
+				u.dom.AppendChild(size, u.dom.NewText(d.Size), nil)
+			})
+		}
+		for _, b := range d.Badges {
+			b := b
+			u.dom.AppendChild(div, u.dom.NewElement("span"), func(badge *js.Object) {
+				badge.Set("className", b.Class)
+				badge.Set("title", b.Title)
+				u.dom.AppendChild(badge, u.dom.NewText(b.Label), nil)
+			})
+		}
+		if !d.Expires.IsZero() {
+			u.renderCountdown(div, d.Expires)
+		}
+	})
+	if d.Fingerprint != "" {
+		u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
+			div.Set("className", "keyFingerprint")
+			u.dom.AppendChild(div, u.dom.NewText(d.Fingerprint), nil)
+		})
+	}
+	if d.Notes != "" {
+		u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
+			div.Set("className", "keyNotes")
+			div.Set("id", notesID(id))
+			u.renderMarkdown(div, markdown.Parse(d.Notes))
+		})
+	}
+	if d.Schedule != nil {
+		u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
+			div.Set("className", "keySchedule")
+			div.Set("id", scheduleID(id))
+			div.Set("title", scheduleTitle(d.Schedule, time.Now()))
+			u.dom.AppendChild(div, u.dom.NewText(d.Schedule.Description()), nil)
+		})
+	}
+	u.renderCertificateWarnings(cell, id, d.CertificateWarnings)
+	u.renderRemoteLoads(cell, id, d.RemoteLoads)
+	for _, r := range d.UsedBy {
+		r := r
+		u.dom.AppendChild(cell, u.dom.NewElement("div"), func(div *js.Object) {
+			div.Set("className", "keyUsedBy")
+			div.Set("title", r.Title)
+			u.dom.AppendChild(div, u.dom.NewText(r.Text), nil)
+		})
+	}
+}
+
+// keyAction performs the action of a button displayed for a key.
+func (u *UI) keyAction(kind buttonKind, k *displayedKey) {
+	switch kind {
+	case LoadButton:
+		u.load(k.ID, k.Encrypted)
+	case UnloadButton:
+		l, err := k.LoadedKey()
+		if err != nil {
+			u.setError(fmt.Errorf("Failed to get loaded key: %v", err))
+			return
+		}
+		u.unload(l)
+	case InstallButton:
+		u.install(k)
+	case AttestationButton:
+		u.showAttestation(k)
+	case ExportButton:
+		u.exportKey(k)
+	case CanaryButton:
+		if ck := u.configured[k.ID]; ck != nil {
+			u.setCanary(k.ID, !ck.Canary)
+		}
+	case RevokeButton:
+		if ck := u.configured[k.ID]; ck != nil {
+			u.setRevoked(k.ID, !ck.Revoked)
+		}
+	case StartupButton:
+		if ck := u.configured[k.ID]; ck != nil {
+			u.setLoadOnStartup(k.ID, !ck.LoadOnStartup)
+		}
+	case NotesButton:
+		u.editNotes(k)
+	case ProfileButton:
+		u.editProfile(k)
+	case ScheduleButton:
+		u.editSchedule(k)
+	case ExtendButton:
+		if ck := u.configured[k.ID]; ck != nil {
+			u.load(k.ID, ck.Encrypted)
+		}
+	case RemoveButton:
+		u.remove(k.ID, u.displayName(k))
 	}
 }
 
@@ -411,9 +1271,10 @@ func mergeKeys(configured []*keys.ConfiguredKey, loaded []*keys.LoadedKey) []*di
 	for _, l := range loaded {
 		// Gather basic fields we get for any loaded key.
 		dk := &displayedKey{
-			Loaded: true,
-			Type:   l.Type,
-			Blob:   base64.StdEncoding.EncodeToString(l.Blob()),
+			Loaded:  true,
+			Type:    l.Type,
+			Blob:    base64.StdEncoding.EncodeToString(l.Blob),
+			Expires: l.Expires,
 		}
 		// Attempt to figure out if this is a key we loaded. If so, fill
 		// in some additional information.  It is possible that a key with
@@ -424,6 +1285,7 @@ func mergeKeys(configured []*keys.ConfiguredKey, loaded []*keys.LoadedKey) []*di
 				loadedIds[id] = true
 				dk.ID = id
 				dk.Name = ak.Name
+				dk.DeviceOnly = ak.DeviceOnly
 			}
 		}
 		result = append(result, dk)
@@ -437,10 +1299,11 @@ func mergeKeys(configured []*keys.ConfiguredKey, loaded []*keys.LoadedKey) []*di
 		}
 
 		result = append(result, &displayedKey{
-			ID:        a.ID,
-			Loaded:    false,
-			Encrypted: a.Encrypted,
-			Name:      a.Name,
+			ID:         a.ID,
+			Loaded:     false,
+			Encrypted:  a.Encrypted,
+			Name:       a.Name,
+			DeviceOnly: a.DeviceOnly,
 		})
 	}
 
@@ -465,24 +1328,67 @@ func mergeKeys(configured []*keys.ConfiguredKey, loaded []*keys.LoadedKey) []*di
 	return result
 }
 
+// Refresh redisplays the configured and loaded keys.  It should be invoked
+// when keys are changed elsewhere (e.g., in another window), so that the
+// user does not act on a stale list.
+func (u *UI) Refresh() {
+	u.updateKeys()
+}
+
 // updateKeys queries the manager for configured and loaded keys, then triggers
 // UI updates to reflect the current state.
 func (u *UI) updateKeys() {
 	u.mgr.Configured(func(configured []*keys.ConfiguredKey, err error) {
-		if err != nil {
-			u.setError(fmt.Errorf("failed to get configured keys: %v", err))
+		// If only some keys could be read, display them along with a
+		// warning, rather than an empty list.
+		var warning error
+		if keys.IsDegraded(err) {
+			warning = help.Wrap(err, "some keys could not be read; the list may be incomplete")
+		} else if err != nil {
+			u.setError(help.Wrap(err, "failed to get configured keys"))
 			return
 		}
 
 		u.mgr.Loaded(func(loaded []*keys.LoadedKey, err error) {
 			if err != nil {
-				u.setError(fmt.Errorf("failed to get loaded keys: %v", err))
+				u.setError(help.Wrap(err, "failed to get loaded keys"))
 				return
 			}
 
-			u.setError(nil)
-			u.keys = mergeKeys(configured, loaded)
-			u.updateDisplayedKeys()
+			u.mgr.Usage(func(usage *keys.StorageUsage, err error) {
+				if err != nil {
+					u.setError(help.Wrap(err, "failed to get storage usage"))
+					return
+				}
+
+				// Keys loaded on other devices are displayed
+				// if they can be read, but failing to read them
+				// does not hide the keys on this device.
+				u.presence.Devices(func(devices []*presence.Device, err error) {
+					if err != nil && warning == nil {
+						warning = help.Wrap(err, "failed to read keys loaded on other devices")
+					}
+
+					// Likewise for who has used each key.
+					u.mgr.Inventory(func(inventory []*keys.InventoryItem, err error) {
+						if err != nil && warning == nil {
+							warning = help.Wrap(err, "failed to read how keys have been used")
+						}
+
+						u.setError(warning)
+						u.keys = mergeKeys(configured, loaded)
+						u.configured = make(map[keys.ID]*keys.ConfiguredKey)
+						for _, k := range configured {
+							u.configured[k.ID] = k
+						}
+						u.usage = usage
+						u.devices = devices
+						u.inventory = inventory
+						u.updateDisplayedKeys()
+						u.updateDisplayedUsage()
+					})
+				})
+			})
 		})
 	})
 }
@@ -0,0 +1,74 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// formatBytes returns a human-readable representation of a number of bytes.
+func formatBytes(b int) string {
+	if b < 1024 {
+		return fmt.Sprintf("%d B", b)
+	}
+	return fmt.Sprintf("%.1f KB", float64(b)/1024)
+}
+
+// keyBytes returns the storage space used by the key with the specified ID,
+// or zero if it is unknown.
+func (u *UI) keyBytes(id keys.ID) int {
+	if u.usage == nil {
+		return 0
+	}
+	for _, k := range u.usage.Keys {
+		if k.ID == id {
+			return k.Bytes
+		}
+	}
+	return 0
+}
+
+// usageText returns a description of the space used in a storage area.
+func usageText(area string, inUse, quota int) string {
+	if quota == 0 {
+		return fmt.Sprintf("%s: %s used", area, formatBytes(inUse))
+	}
+	remaining := quota - inUse
+	if remaining < 0 {
+		remaining = 0
+	}
+	return fmt.Sprintf("%s: %s of %s used (%s remaining)", area, formatBytes(inUse), formatBytes(quota), formatBytes(remaining))
+}
+
+// updateDisplayedUsage refreshes the UI to reflect the storage space used by
+// configured keys.
+func (u *UI) updateDisplayedUsage() {
+	u.dom.RemoveChildren(u.storageUsage)
+	if u.usage == nil {
+		return
+	}
+
+	for _, line := range []string{
+		usageText("Synced storage", u.usage.SyncBytesInUse, u.usage.SyncQuota),
+		usageText("Storage on this device", u.usage.LocalBytesInUse, u.usage.LocalQuota),
+	} {
+		u.dom.AppendChild(u.storageUsage, u.dom.NewElement("div"), func(div *js.Object) {
+			u.dom.AppendChild(div, u.dom.NewText(line), nil)
+		})
+	}
+}
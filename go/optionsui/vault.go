@@ -0,0 +1,99 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"errors"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// populateVault displays whether a master passphrase is set, and whether the
+// vault is unlocked.
+func (u *UI) populateVault() {
+	u.mgr.VaultStatus(func(enabled, unlocked bool, err error) {
+		u.dom.RemoveChildren(u.vaultStatus)
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to read vault status"))
+			return
+		}
+		status := "No master passphrase is set; private keys are stored as they were added."
+		if enabled && unlocked {
+			status = "Private keys are sealed with the master passphrase.  The vault is unlocked until Chrome is restarted."
+		} else if enabled {
+			status = "Private keys are sealed with the master passphrase.  Unlock the vault to load, export or add keys."
+		}
+		u.vaultUnlock.Set("disabled", !enabled || unlocked)
+		u.dom.AppendChild(u.vaultStatus, u.dom.NewText(status), nil)
+	})
+}
+
+// setMasterPassphrase sets, changes or removes the master passphrase, using
+// the passphrases entered by the user.
+func (u *UI) setMasterPassphrase() {
+	current := u.dom.Value(u.vaultCurrent)
+	passphrase := u.dom.Value(u.vaultNew)
+	confirm := u.dom.Value(u.vaultConfirm)
+	u.clearVaultInputs()
+	if passphrase != confirm {
+		u.setError(errors.New("failed to set master passphrase: the new passphrases do not match"))
+		return
+	}
+	u.mgr.SetMasterPassphrase(current, passphrase, func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to set master passphrase"))
+			return
+		}
+		u.setError(nil)
+		if passphrase == "" {
+			u.ShowToast("Master passphrase removed", "Private keys are no longer sealed.")
+		} else {
+			u.ShowToast("Master passphrase set", "Private keys are sealed with the master passphrase.")
+		}
+		u.populateVault()
+	})
+}
+
+// unlockVault unlocks the vault using the current master passphrase entered
+// by the user.  Sealed keys marked to be loaded on startup, which could not
+// be loaded while the vault was locked, are then loaded.
+func (u *UI) unlockVault() {
+	passphrase := u.dom.Value(u.vaultCurrent)
+	u.clearVaultInputs()
+	u.mgr.UnlockVault(passphrase, func(err error) {
+		if err != nil {
+			u.setError(help.Wrap(err, "failed to unlock vault"))
+			return
+		}
+		u.setError(nil)
+		u.populateVault()
+		u.mgr.LoadOnStartup(func(loaded int, err error) {
+			if err != nil {
+				u.setError(help.Wrap(err, "failed to load keys marked to load on startup"))
+			}
+			if loaded > 0 {
+				u.updateKeys()
+			}
+		})
+	})
+}
+
+// clearVaultInputs clears the passphrases entered in the vault pane, so
+// that they do not linger on the page.
+func (u *UI) clearVaultInputs() {
+	u.dom.SetValue(u.vaultCurrent, "")
+	u.dom.SetValue(u.vaultNew, "")
+	u.dom.SetValue(u.vaultConfirm, "")
+}
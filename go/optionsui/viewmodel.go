@@ -0,0 +1,441 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/keytype"
+	"github.com/google/chrome-ssh-agent/go/presence"
+	"golang.org/x/crypto/ssh"
+)
+
+// The types in this file describe what the UI displays, computed from the
+// results returned by the manager.  They contain no DOM objects, so the
+// rendering code only needs to lay them out, and what is displayed can be
+// tested without inspecting the DOM.
+
+// Badge is a short label displayed beside the name of a key, highlighting
+// how it is stored or used.
+type Badge struct {
+	// Class is the CSS class of the badge.
+	Class string
+	// Label is the text of the badge.
+	Label string
+	// Title explains the badge when the user hovers over it.
+	Title string
+}
+
+// KeyButton is a button displayed for a key.
+type KeyButton struct {
+	// Kind identifies the action performed by the button.
+	Kind buttonKind
+	// Label is the text of the button.
+	Label string
+	// Title explains the button when the user hovers over it.  It may be
+	// empty.
+	Title string
+}
+
+// KeyDetail describes a key, as displayed beneath its name.
+type KeyDetail struct {
+	// Name is the name by which the key is listed.
+	Name string
+	// Provenance describes how the key entered the system.  It is empty
+	// for keys that are not configured.
+	Provenance string
+	// Size is the storage space used by the key, or empty if unknown.
+	Size string
+	// Fingerprint is the SHA256 fingerprint of the key, or empty if it
+	// cannot be determined until the key is loaded.
+	Fingerprint string
+	// Badges highlight how the key is stored or used.
+	Badges []*Badge
+	// Notes are the notes for the key, in Markdown.
+	Notes string
+	// Schedule is the schedule on which the key is loaded and unloaded,
+	// or nil if it has none.
+	Schedule *keys.Schedule
+	// Expires is the time at which the loaded key expires, or the zero
+	// time if it was loaded without a lifetime.
+	Expires time.Time
+	// CertificateWarnings lists the restrictions and problems with the
+	// key's certificate.
+	CertificateWarnings []string
+	// RemoteLoads lists the user's other devices on which the key is
+	// loaded.
+	RemoteLoads []*RemoteLoad
+	// UsedBy lists who has used the key to sign, most recently used
+	// first.
+	UsedBy []*RequesterUse
+}
+
+// RequesterUse describes the signatures made using a key on behalf of a
+// single requester.
+type RequesterUse struct {
+	// Text summarizes the signatures (e.g., "Used by
+	// https://example.com: 2 signatures, last 2018-05-01 09:30").
+	Text string
+	// Title gives the page from which the most recent signature was
+	// requested.  It may be empty.
+	Title string
+}
+
+// RemoteLoad describes another of the user's devices on which a key is
+// loaded.
+type RemoteLoad struct {
+	// Device is the ID of the device.
+	Device string
+	// Text describes where the key is loaded (e.g., "Also loaded on
+	// 'work laptop'").
+	Text string
+	// Title gives the time at which the device last changed the keys
+	// it reports as loaded.
+	Title string
+	// Requested indicates that the device has been asked to unload the
+	// key, and has not yet done so.
+	Requested bool
+}
+
+// KeyRow describes a row of the keys table.
+type KeyRow struct {
+	// ID is the ID of the key, or keys.InvalidID for keys that are
+	// loaded but not configured.
+	ID keys.ID
+	// Detail describes the key.
+	Detail *KeyDetail
+	// Buttons are the buttons displayed for the key, in order.
+	Buttons []*KeyButton
+	// Type is the type of key (e.g., 'ssh-rsa'), if loaded.
+	Type string
+	// Blob is the base64-encoded public key material, if loaded.
+	Blob string
+
+	// key is the displayed key on which the buttons act.
+	key *displayedKey
+}
+
+// newKeyDetail returns the description of a key.  ck is the configured key,
+// or nil if the key is not configured.  bytes is the storage space used by
+// the key, or zero if unknown.
+func newKeyDetail(k *displayedKey, ck *keys.ConfiguredKey, bytes int) *KeyDetail {
+	d := &KeyDetail{
+		Name:        k.Name,
+		Fingerprint: blobFingerprint(k.Blob),
+	}
+	if k.Expires > 0 {
+		d.Expires = time.Unix(0, k.Expires*int64(time.Millisecond))
+	}
+	if bytes > 0 {
+		d.Size = formatBytes(bytes)
+	}
+	if k.DeviceOnly {
+		d.Badges = append(d.Badges, &Badge{
+			Class: "deviceOnlyBadge",
+			Label: "This device only",
+			Title: "Stored only on this device; not synced",
+		})
+	}
+	if ck == nil {
+		return d
+	}
+
+	d.Name = ck.DisplayName()
+	d.Provenance = provenanceText(ck)
+	if d.Fingerprint == "" {
+		d.Fingerprint = ck.FingerprintSHA256
+	}
+	// Remote keys have no private key to protect.
+	if ck.Protection == keys.ProtectionPlaintext && ck.Remote == "" {
+		d.Badges = append(d.Badges, &Badge{
+			Class: "plaintextBadge",
+			Label: "Unencrypted",
+			Title: "Stored without a passphrase; anyone with access to your browser profile can read it",
+		})
+	}
+	if ck.Remote != "" {
+		d.Badges = append(d.Badges, &Badge{
+			Class: "remoteBadge",
+			Label: "Remote",
+			Title: fmt.Sprintf("Held by the signing service at %s; each signature must be allowed", ck.Remote),
+		})
+	}
+	if ck.Certificate != "" {
+		d.Badges = append(d.Badges, &Badge{
+			Class: "certificateBadge",
+			Label: "Certificate",
+			Title: "Loaded along with an SSH certificate",
+		})
+		d.CertificateWarnings = ck.CertificateWarnings
+	}
+	if ck.TOTP {
+		d.Badges = append(d.Badges, &Badge{
+			Class: "totpBadge",
+			Label: "Code required",
+			Title: "Each signature must be confirmed with a code from your authenticator app",
+		})
+	}
+	if ck.Canary {
+		d.Badges = append(d.Badges, &Badge{
+			Class: "canaryBadge",
+			Label: "Canary",
+			Title: "Listed to clients, but signatures are always refused",
+		})
+	}
+	if ck.Revoked {
+		d.Badges = append(d.Badges, &Badge{
+			Class: "revokedBadge",
+			Label: "Revoked",
+			Title: "Unloaded on all of your devices, and may not be loaded until the revocation is cleared",
+		})
+	}
+	if ck.Type != "" && !keytype.Signing(ck.Type) {
+		d.Badges = append(d.Badges, &Badge{
+			Class: "unsupportedBadge",
+			Label: keytype.Name(ck.Type),
+			Title: "Keys of this type can be stored, but not yet loaded",
+		})
+	}
+	if ck.Profile != keys.DefaultProfile {
+		d.Badges = append(d.Badges, &Badge{
+			Class: "profileBadge",
+			Label: ck.Profile,
+			Title: fmt.Sprintf("Only available to clients connecting to the '%s' profile", ck.Profile),
+		})
+	}
+	d.Notes = ck.Notes
+	if ck.Schedule != "" {
+		if s, err := keys.ParseSchedule(ck.Schedule); err == nil {
+			d.Schedule = s
+		}
+	}
+	return d
+}
+
+// keyButtons returns the buttons displayed for a key.  ck is the configured
+// key, or nil if the key is not configured.  Keys without a valid ID cannot
+// be controlled, so have no buttons.
+func keyButtons(k *displayedKey, ck *keys.ConfiguredKey) []*KeyButton {
+	if k.ID == keys.InvalidID {
+		return nil
+	}
+
+	var result []*KeyButton
+	if k.Loaded {
+		result = append(result, &KeyButton{Kind: UnloadButton, Label: "Unload"})
+		if k.Expires > 0 && ck != nil && !ck.Revoked {
+			result = append(result, &KeyButton{Kind: ExtendButton, Label: "Extend", Title: "Load the key again, restarting its lifetime"})
+		}
+	}
+	loadable := ck != nil && !ck.Revoked && (ck.Type == "" || keytype.Signing(ck.Type))
+	if !k.Loaded && (ck == nil || loadable) {
+		result = append(result, &KeyButton{Kind: LoadButton, Label: "Load"})
+	}
+	if k.Blob != "" || (ck != nil && !ck.Revoked) {
+		result = append(result, &KeyButton{Kind: InstallButton, Label: "Install", Title: "Install this key on a server"})
+	}
+	if ck != nil && ck.Attestation != "" {
+		result = append(result, &KeyButton{Kind: AttestationButton, Label: "Attestation", Title: "Show how this key was generated"})
+	}
+	if ck != nil {
+		canary := "Mark Canary"
+		if ck.Canary {
+			canary = "Unmark Canary"
+		}
+		revoke := &KeyButton{Kind: RevokeButton, Label: "Revoke", Title: "Unload this key on all of your devices, and refuse to load it again"}
+		if ck.Revoked {
+			revoke = &KeyButton{Kind: RevokeButton, Label: "Clear Revocation", Title: "Allow this key to be loaded again"}
+		}
+		// Remote keys have no private key to export.
+		if ck.Remote == "" {
+			result = append(result, &KeyButton{Kind: ExportButton, Label: "Export", Title: "Export the private key for use with other tools"})
+		}
+		result = append(result, &KeyButton{Kind: CanaryButton, Label: canary, Title: "A canary key is listed to clients, but signatures using it are refused and reported"})
+		// Keys encrypted with a passphrase cannot be loaded without the
+		// user.
+		if loadable && !ck.Encrypted {
+			startup := &KeyButton{Kind: StartupButton, Label: "Load on Startup", Title: "Load this key automatically when Chrome starts"}
+			if ck.LoadOnStartup {
+				startup = &KeyButton{Kind: StartupButton, Label: "Don't Load on Startup", Title: "Stop loading this key automatically when Chrome starts"}
+			}
+			result = append(result, startup)
+		}
+		// Keys encrypted with a passphrase may still be unloaded on a
+		// schedule.
+		if loadable {
+			result = append(result, &KeyButton{Kind: ScheduleButton, Label: "Schedule", Title: "Load and unload this key automatically at set times"})
+		}
+		result = append(result,
+			revoke,
+			&KeyButton{Kind: NotesButton, Label: "Notes", Title: "Describe what this key is for"},
+			&KeyButton{Kind: ProfileButton, Label: "Profile", Title: "Choose which clients may use this key"},
+		)
+	}
+	return append(result, &KeyButton{Kind: RemoveButton, Label: "Remove"})
+}
+
+// newKeyRow returns the row of the keys table describing a key.  ck is the
+// configured key, or nil if the key is not configured.  bytes is the storage
+// space used by the key, or zero if unknown.
+func newKeyRow(k *displayedKey, ck *keys.ConfiguredKey, bytes int) *KeyRow {
+	return &KeyRow{
+		ID:      k.ID,
+		Detail:  newKeyDetail(k, ck, bytes),
+		Buttons: keyButtons(k, ck),
+		Type:    k.Type,
+		Blob:    k.Blob,
+		key:     k,
+	}
+}
+
+// remoteLoads returns the devices among devices on which a key is loaded.
+func remoteLoads(k *displayedKey, devices []*presence.Device) []*RemoteLoad {
+	if k.ID == keys.InvalidID {
+		return nil
+	}
+
+	prefix := "Loaded"
+	if k.Loaded {
+		prefix = "Also loaded"
+	}
+	var result []*RemoteLoad
+	for _, d := range devices {
+		if !containsID(d.Loaded, k.ID) {
+			continue
+		}
+		r := &RemoteLoad{
+			Device:    d.ID,
+			Text:      fmt.Sprintf("%s on '%s'", prefix, d.Name),
+			Title:     fmt.Sprintf("Last updated %s", d.Updated.Format("2006-01-02 15:04")),
+			Requested: containsID(d.Unloading, k.ID),
+		}
+		if r.Requested {
+			r.Text += "; unload requested"
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// containsID determines if id is among ids.
+func containsID(ids []keys.ID, id keys.ID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// usedBy returns who has used a key to sign, according to items.
+func usedBy(k *displayedKey, items []*keys.InventoryItem) []*RequesterUse {
+	var item *keys.InventoryItem
+	for _, i := range items {
+		if k.ID != keys.InvalidID && i.ID == k.ID {
+			item = i
+			break
+		}
+		if k.ID == keys.InvalidID && i.ID == keys.InvalidID && i.Fingerprint != "" && i.Fingerprint == blobFingerprint(k.Blob) {
+			item = i
+			break
+		}
+	}
+	if item == nil {
+		return nil
+	}
+
+	var result []*RequesterUse
+	for _, u := range item.UsedBy {
+		noun := "signatures"
+		if u.Count == 1 {
+			noun = "signature"
+		}
+		last := time.Unix(0, u.Last*int64(time.Millisecond))
+		r := &RequesterUse{
+			Text: fmt.Sprintf("Used by %s: %d %s, last %s", u.Requester, u.Count, noun, last.Format("2006-01-02 15:04")),
+		}
+		if u.Page != "" {
+			r.Title = fmt.Sprintf("Most recently from %s", u.Page)
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// blobFingerprint returns the SHA256 fingerprint of the base64-encoded public
+// key material, or the empty string if it cannot be parsed.
+func blobFingerprint(blob string) string {
+	b, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return ""
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(pub)
+}
+
+// keyRows returns the rows of the keys table, omitting keys excluded by the
+// source filter.
+func (u *UI) keyRows() []*KeyRow {
+	var result []*KeyRow
+	for _, k := range u.keys {
+		if !u.showKey(k) {
+			continue
+		}
+		r := newKeyRow(k, u.configured[k.ID], u.keyBytes(k.ID))
+		r.Detail.RemoteLoads = remoteLoads(k, u.devices)
+		r.Detail.UsedBy = usedBy(k, u.inventory)
+		result = append(result, r)
+	}
+	return result
+}
+
+// LoadDialogState describes the dialog prompting for the passphrase needed
+// to load a key.
+type LoadDialogState struct {
+	// Attempt is the number of the attempt (starting at 1).
+	Attempt int
+	// Remaining is the number of attempts remaining, including this one.
+	Remaining int
+	// Status explains why the previous attempt failed, or is empty for
+	// the first attempt.
+	Status string
+}
+
+// newLoadDialogState returns the state of the passphrase dialog for an
+// attempt to load a key.  lastErr is the error with which the previous
+// attempt failed, or nil for the first attempt.
+func newLoadDialogState(attempt, remaining int, lastErr error) *LoadDialogState {
+	s := &LoadDialogState{Attempt: attempt, Remaining: remaining}
+	if lastErr == nil {
+		return s
+	}
+	msg := "Incorrect passphrase"
+	if help.CodeOf(lastErr) != help.IncorrectPassphrase {
+		msg = lastErr.Error()
+	}
+	plural := "s"
+	if remaining == 1 {
+		plural = ""
+	}
+	s.Status = fmt.Sprintf("%s; %d attempt%s remaining", msg, remaining, plural)
+	return s
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"fmt"
+	"strings"
+)
+
+// wipeConfirmation is the text the user must type to confirm that all data
+// is to be wiped.
+const wipeConfirmation = "wipe all data"
+
+// Wiper erases all data kept by the extension.  It is implemented by
+// keys.Wiper and keys.WipeClient.
+type Wiper interface {
+	Wipe(callback func(err error))
+}
+
+// wipe erases all data kept by the extension, once the user has confirmed by
+// typing wipeConfirmation.
+func (u *UI) wipe() {
+	u.promptWipe(func(ok bool) {
+		if !ok {
+			return
+		}
+		u.wiper.Wipe(func(err error) {
+			if err != nil {
+				u.setError(fmt.Errorf("failed to wipe all data: %v", err))
+			} else {
+				u.setError(nil)
+				u.ShowToast("Wiped", "All keys and settings were erased from this browser profile.")
+			}
+			u.updateKeys()
+			u.updateOrigins()
+			u.updateExtensions()
+		})
+	})
+}
+
+// promptWipe displays a dialog asking the user to confirm that all data is
+// to be wiped by typing wipeConfirmation.  The dialog remains open until the
+// confirmation is typed correctly or the user cancels.  callback is invoked
+// when the dialog is closed; the ok parameter indicates if the user
+// confirmed.
+func (u *UI) promptWipe(callback func(ok bool)) {
+	reset := func() {
+		u.dom.SetValue(u.wipeConfirm, "")
+		u.dom.RemoveChildren(u.wipeStatus)
+		u.wipeOk = u.dom.RemoveEventListeners(u.wipeOk)
+		u.wipeCancel = u.dom.RemoveEventListeners(u.wipeCancel)
+		u.dom.Close(u.wipeDialog)
+	}
+	u.dom.OnClick(u.wipeOk, func() {
+		if strings.ToLower(strings.TrimSpace(u.dom.Value(u.wipeConfirm))) != wipeConfirmation {
+			u.dom.RemoveChildren(u.wipeStatus)
+			u.dom.AppendChild(u.wipeStatus, u.dom.NewText(fmt.Sprintf("Type '%s' to confirm", wipeConfirmation)), nil)
+			return
+		}
+		reset()
+		callback(true)
+	})
+	u.dom.OnClick(u.wipeCancel, func() {
+		reset()
+		callback(false)
+	})
+	u.dom.ShowModal(u.wipeDialog)
+}
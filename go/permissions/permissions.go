@@ -0,0 +1,130 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package permissions requests optional permissions at runtime.  To keep the
+// permissions held by the extension to a minimum, a permission needed only
+// by an optional feature is requested when the feature is enabled in
+// settings, and relinquished when it is disabled.
+package permissions
+
+import (
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+// Permission is an optional API permission declared in the manifest.
+type Permission string
+
+const (
+	// Notifications permits system notifications to be displayed.
+	Notifications Permission = "notifications"
+	// NativeMessaging permits communication with the native messaging
+	// host that serves command-line clients.
+	NativeMessaging Permission = "nativeMessaging"
+	// Management permits the names of other extensions to be read, so
+	// that the user can tell which extensions are connecting to the
+	// agent.
+	Management Permission = "management"
+)
+
+// Description returns a human-readable description of what the permission
+// allows (e.g., 'display system notifications').
+func (p Permission) Description() string {
+	switch p {
+	case Notifications:
+		return "display system notifications"
+	case NativeMessaging:
+		return "communicate with command-line clients"
+	case Management:
+		return "read the names of other extensions"
+	}
+	return string(p)
+}
+
+// API provides access to Chrome's permissions API.  See chrome.C for
+// details on the methods; using this interface allows for alternate
+// implementations during testing.
+type API interface {
+	// RequestPermissions requests API permissions. See
+	// chrome.C.RequestPermissions() for details.
+	RequestPermissions(perms []string, callback func(granted bool, err error))
+
+	// ContainsPermissions checks whether API permissions are held. See
+	// chrome.C.ContainsPermissions() for details.
+	ContainsPermissions(perms []string, callback func(granted bool, err error))
+
+	// RemovePermissions relinquishes API permissions. See
+	// chrome.C.RemovePermissions() for details.
+	RemovePermissions(perms []string, callback func(err error))
+}
+
+// Granted determines whether the permission is currently held.  callback is
+// invoked with the result.
+func Granted(api API, p Permission, callback func(granted bool, err error)) {
+	api.ContainsPermissions([]string{string(p)}, callback)
+}
+
+// Enable requests the permission if it is not already held.  It must be
+// invoked in response to a user gesture, since the user may be prompted.
+// callback is invoked when complete; if the user declined, the error has
+// the help.PermissionDenied code.
+func Enable(api API, p Permission, callback func(err error)) {
+	Granted(api, p, func(granted bool, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		if granted {
+			callback(nil)
+			return
+		}
+		api.RequestPermissions([]string{string(p)}, func(granted bool, err error) {
+			if err != nil {
+				callback(err)
+				return
+			}
+			if !granted {
+				callback(help.Errorf(help.PermissionDenied, "permission to %s was not granted", p.Description()))
+				return
+			}
+			callback(nil)
+		})
+	})
+}
+
+// Disable relinquishes the permission, if it is held.  callback is invoked
+// when complete.
+func Disable(api API, p Permission, callback func(err error)) {
+	Granted(api, p, func(granted bool, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		if !granted {
+			callback(nil)
+			return
+		}
+		api.RemovePermissions([]string{string(p)}, callback)
+	})
+}
+
+// Contains returns true if perms (as reported by
+// chrome.C.OnPermissionsChanged()) includes the permission.
+func Contains(perms []string, p Permission) bool {
+	for _, s := range perms {
+		if s == string(p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package permissions
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/kr/pretty"
+)
+
+func TestEnableDisable(t *testing.T) {
+	testcases := []struct {
+		description string
+		granted     bool
+		deny        bool
+		enable      bool
+		wantGranted bool
+		wantCode    help.Code
+	}{
+		{
+			description: "enable grants permission",
+			enable:      true,
+			wantGranted: true,
+		},
+		{
+			description: "enable when already granted",
+			granted:     true,
+			deny:        true,
+			enable:      true,
+			wantGranted: true,
+		},
+		{
+			description: "enable declined by user",
+			deny:        true,
+			enable:      true,
+			wantGranted: false,
+			wantCode:    help.PermissionDenied,
+		},
+		{
+			description: "disable relinquishes permission",
+			granted:     true,
+			enable:      false,
+			wantGranted: false,
+		},
+		{
+			description: "disable when not granted",
+			enable:      false,
+			wantGranted: false,
+		},
+	}
+
+	for _, tc := range testcases {
+		api := fakes.NewPermissions()
+		api.Deny = tc.deny
+		if tc.granted {
+			api.Permissions[string(Notifications)] = true
+		}
+
+		var gotErr error
+		if tc.enable {
+			Enable(api, Notifications, func(err error) { gotErr = err })
+		} else {
+			Disable(api, Notifications, func(err error) { gotErr = err })
+		}
+		if diff := pretty.Diff(help.CodeOf(gotErr), tc.wantCode); diff != nil {
+			t.Errorf("%s: incorrect error code; -got +want: %s", tc.description, diff)
+		}
+		if tc.wantCode == help.None && gotErr != nil {
+			t.Errorf("%s: unexpected error: %v", tc.description, gotErr)
+		}
+
+		Granted(api, Notifications, func(granted bool, err error) {
+			if err != nil {
+				t.Errorf("%s: failed to check permission: %v", tc.description, err)
+			}
+			if granted != tc.wantGranted {
+				t.Errorf("%s: incorrect permission state; got %v, want %v", tc.description, granted, tc.wantGranted)
+			}
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	perms := []string{"storage", "nativeMessaging"}
+	if !Contains(perms, NativeMessaging) {
+		t.Errorf("Contains(%v, %s) = false, want true", perms, NativeMessaging)
+	}
+	if Contains(perms, Notifications) {
+		t.Errorf("Contains(%v, %s) = true, want false", perms, Notifications)
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pmimport reads SSH keys from the exports produced by password
+// managers, so that users can move their keys into the extension.  It
+// supports 1Password's 1PUX export (either the .1pux archive or the
+// export.data file within it) and Bitwarden's unencrypted JSON export.
+package pmimport
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/keyformat"
+)
+
+// Format is the format of an export.
+type Format string
+
+const (
+	// Format1Password is 1Password's 1PUX export.
+	Format1Password Format = "1password"
+	// FormatBitwarden is Bitwarden's unencrypted JSON export.
+	FormatBitwarden Format = "bitwarden"
+)
+
+// Description returns a human-readable description of the format.
+func (f Format) Description() string {
+	switch f {
+	case Format1Password:
+		return "1Password"
+	case FormatBitwarden:
+		return "Bitwarden"
+	}
+	return string(f)
+}
+
+var (
+	// ErrUnknownFormat indicates that an export was not produced by a
+	// supported password manager.
+	ErrUnknownFormat = errors.New("not a 1Password (.1pux) or Bitwarden (.json) export")
+	// ErrEncrypted indicates that an export is encrypted.
+	ErrEncrypted = errors.New("the export is encrypted; export again without a password")
+	// ErrNoKeys indicates that an export contains no SSH keys.
+	ErrNoKeys = errors.New("the export contains no SSH keys")
+)
+
+// Key is an SSH key read from an export.
+type Key struct {
+	// Name is the name of the item in the password manager.
+	Name string
+	// PrivateKey is the PEM-encoded private key, in a format that can be
+	// added to the extension.
+	PrivateKey string
+	// Notes are the notes attached to the item.
+	Notes string
+	// Tags are the tags (1Password) or folders and collections
+	// (Bitwarden) in which the item was filed.
+	Tags []string
+}
+
+// zipMagic begins every zip archive.
+var zipMagic = []byte("PK\x03\x04")
+
+// Parse reads the SSH keys in an export, detecting the password manager that
+// produced it.  Items that are not SSH keys are ignored.
+func Parse(data []byte) (Format, []*Key, error) {
+	if bytes.HasPrefix(data, zipMagic) {
+		keys, err := parse1PUXArchive(data)
+		return Format1Password, keys, err
+	}
+
+	var probe struct {
+		Accounts  json.RawMessage `json:"accounts"`
+		Items     json.RawMessage `json:"items"`
+		Encrypted bool            `json:"encrypted"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return "", nil, ErrUnknownFormat
+	}
+	switch {
+	case probe.Accounts != nil:
+		keys, err := parse1PUX(data)
+		return Format1Password, keys, err
+	case probe.Encrypted:
+		return FormatBitwarden, nil, ErrEncrypted
+	case probe.Items != nil:
+		keys, err := parseBitwarden(data)
+		return FormatBitwarden, keys, err
+	}
+	return "", nil, ErrUnknownFormat
+}
+
+// normalizePrivateKey returns the private key in a format that can be added
+// to the extension.  Unencrypted PKCS#8 keys (as exported by 1Password) are
+// converted to OpenSSH's format, using name as the comment; other formats are
+// returned unchanged.
+func normalizePrivateKey(privateKey, name string) (string, error) {
+	privateKey = strings.TrimSpace(privateKey)
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return "", errors.New("private key is not PEM-encoded")
+	}
+	if block.Type != "PRIVATE KEY" {
+		return privateKey + "\n", nil
+	}
+
+	priv, err := keyformat.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	return keyformat.MarshalOpenSSH(priv, name, nil, rand.Reader)
+}
+
+// finish converts the private keys read from an export, and checks that at
+// least one was found.
+func finish(keys []*Key) ([]*Key, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	for _, k := range keys {
+		pk, err := normalizePrivateKey(k.PrivateKey, k.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key %q: %v", k.Name, err)
+		}
+		k.PrivateKey = pk
+	}
+	return keys, nil
+}
+
+// parse1PUXArchive reads the SSH keys in a .1pux archive.  The items are
+// stored in export.data within the archive.
+func parse1PUXArchive(data []byte) ([]*Key, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open 1Password export: %v", err)
+	}
+	for _, f := range r.File {
+		if f.Name != "export.data" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open export.data: %v", err)
+		}
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read export.data: %v", err)
+		}
+		return parse1PUX(b)
+	}
+	return nil, errors.New("1Password export does not contain export.data")
+}
+
+// onePasswordSSHKey is the category of 1Password items holding SSH keys.
+const onePasswordSSHKey = "114"
+
+// onePasswordExport is the subset of 1Password's export.data needed to read
+// SSH keys.
+type onePasswordExport struct {
+	Accounts []struct {
+		Vaults []struct {
+			Items []struct {
+				CategoryUUID string `json:"categoryUuid"`
+				Overview     struct {
+					Title string   `json:"title"`
+					Tags  []string `json:"tags"`
+				} `json:"overview"`
+				Details struct {
+					NotesPlain string `json:"notesPlain"`
+					Sections   []struct {
+						Fields []struct {
+							Value struct {
+								SSHKey *struct {
+									PrivateKey string `json:"privateKey"`
+								} `json:"sshKey"`
+							} `json:"value"`
+						} `json:"fields"`
+					} `json:"sections"`
+				} `json:"details"`
+			} `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+// parse1PUX reads the SSH keys in 1Password's export.data.
+func parse1PUX(data []byte) ([]*Key, error) {
+	var export onePasswordExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("failed to parse 1Password export: %v", err)
+	}
+
+	var keys []*Key
+	for _, a := range export.Accounts {
+		for _, v := range a.Vaults {
+			for _, item := range v.Items {
+				if item.CategoryUUID != onePasswordSSHKey {
+					continue
+				}
+				for _, s := range item.Details.Sections {
+					for _, f := range s.Fields {
+						if f.Value.SSHKey == nil || f.Value.SSHKey.PrivateKey == "" {
+							continue
+						}
+						keys = append(keys, &Key{
+							Name:       item.Overview.Title,
+							PrivateKey: f.Value.SSHKey.PrivateKey,
+							Notes:      item.Details.NotesPlain,
+							Tags:       item.Overview.Tags,
+						})
+					}
+				}
+			}
+		}
+	}
+	return finish(keys)
+}
+
+// bitwardenSSHKey is the type of Bitwarden items holding SSH keys.
+const bitwardenSSHKey = 5
+
+// bitwardenExport is the subset of Bitwarden's JSON export needed to read SSH
+// keys.
+type bitwardenExport struct {
+	Folders []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Collections []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"collections"`
+	Items []struct {
+		Type          int      `json:"type"`
+		Name          string   `json:"name"`
+		Notes         string   `json:"notes"`
+		FolderID      string   `json:"folderId"`
+		CollectionIDs []string `json:"collectionIds"`
+		SSHKey        *struct {
+			PrivateKey string `json:"privateKey"`
+		} `json:"sshKey"`
+	} `json:"items"`
+}
+
+// parseBitwarden reads the SSH keys in Bitwarden's JSON export.  Bitwarden
+// has no tags; the folder and collections in which an item is filed are used
+// instead.
+func parseBitwarden(data []byte) ([]*Key, error) {
+	var export bitwardenExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("failed to parse Bitwarden export: %v", err)
+	}
+
+	folders := make(map[string]string)
+	for _, f := range export.Folders {
+		folders[f.ID] = f.Name
+	}
+	collections := make(map[string]string)
+	for _, c := range export.Collections {
+		collections[c.ID] = c.Name
+	}
+
+	var keys []*Key
+	for _, item := range export.Items {
+		if item.Type != bitwardenSSHKey || item.SSHKey == nil || item.SSHKey.PrivateKey == "" {
+			continue
+		}
+		k := &Key{
+			Name:       item.Name,
+			PrivateKey: item.SSHKey.PrivateKey,
+			Notes:      item.Notes,
+		}
+		if name := folders[item.FolderID]; name != "" {
+			k.Tags = append(k.Tags, name)
+		}
+		for _, id := range item.CollectionIDs {
+			if name := collections[id]; name != "" {
+				k.Tags = append(k.Tags, name)
+			}
+		}
+		keys = append(keys, k)
+	}
+	return finish(keys)
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package popup displays prompts in a small popup window owned by the
+// background page, so that the user can respond to them even when no
+// extension page is open.
+package popup
+
+import (
+	"log"
+	"sync"
+)
+
+const (
+	// Page is the page displayed in the popup window, relative to the
+	// extension's root.
+	Page = "html/prompt.html"
+	// Width is the width of the popup window, in pixels.
+	Width = 600
+	// Height is the height of the popup window, in pixels.
+	Height = 300
+)
+
+// WindowsAPI provides access to browser windows.  See chrome.C.CreatePopup,
+// chrome.C.FocusWindow and chrome.C.OnWindowRemoved for details.
+type WindowsAPI interface {
+	CreatePopup(page string, width, height int, callback func(windowID int, err error))
+	FocusWindow(windowID int, callback func(err error))
+	OnWindowRemoved(callback func(windowID int))
+}
+
+// Popup is a popup window in which prompts are displayed.  At most one
+// window is open at a time; showing the popup while it is open brings the
+// window to the front rather than opening another.  The page displayed in
+// the window is responsible for closing it once there is nothing left to
+// prompt for.
+type Popup struct {
+	api    WindowsAPI
+	page   string
+	width  int
+	height int
+
+	mu sync.Mutex
+	// open is true if the window is open, in which case id is its ID.
+	open bool
+	id   int
+	// opening is true while the window is being opened.
+	opening bool
+}
+
+// New returns a Popup that displays the specified page, relative to the
+// extension's root, in a window of the specified size.
+func New(api WindowsAPI, page string, width, height int) *Popup {
+	p := &Popup{
+		api:    api,
+		page:   page,
+		width:  width,
+		height: height,
+	}
+	api.OnWindowRemoved(p.removed)
+	return p
+}
+
+// removed is invoked when a window is closed.
+func (p *Popup) removed(windowID int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.open && p.id == windowID {
+		p.open = false
+	}
+}
+
+// Show opens the popup window, or brings it to the front if it is already
+// open.  Failures are logged; the user can still respond to prompts from
+// the extension's other pages.
+func (p *Popup) Show() {
+	p.mu.Lock()
+	if p.opening {
+		p.mu.Unlock()
+		return
+	}
+	if !p.open {
+		p.opening = true
+		p.mu.Unlock()
+		p.create()
+		return
+	}
+	id := p.id
+	p.mu.Unlock()
+
+	p.api.FocusWindow(id, func(err error) {
+		if err == nil {
+			return
+		}
+		// The window was closed, but we have yet to be told; open
+		// another.
+		p.mu.Lock()
+		if p.opening || (p.open && p.id != id) {
+			p.mu.Unlock()
+			return
+		}
+		p.open = false
+		p.opening = true
+		p.mu.Unlock()
+		p.create()
+	})
+}
+
+// create opens a new popup window.  The caller must have set opening.
+func (p *Popup) create() {
+	p.api.CreatePopup(p.page, p.width, p.height, func(windowID int, err error) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.opening = false
+		if err != nil {
+			log.Printf("Failed to open prompt window: %v", err)
+			return
+		}
+		p.open = true
+		p.id = windowID
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package popup
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/kr/pretty"
+)
+
+func TestShow(t *testing.T) {
+	w := fakes.NewWindows()
+	p := New(w, Page, Width, Height)
+
+	// The first prompt opens the window.
+	p.Show()
+	want := map[int]*fakes.Window{
+		1: {ID: 1, Page: Page, Width: Width, Height: Height},
+	}
+	if diff := pretty.Diff(w.Open, want); diff != nil {
+		t.Errorf("incorrect windows after first prompt; -got +want: %s", diff)
+	}
+
+	// Later prompts bring it to the front.
+	p.Show()
+	want[1].Focused = 1
+	if diff := pretty.Diff(w.Open, want); diff != nil {
+		t.Errorf("incorrect windows after second prompt; -got +want: %s", diff)
+	}
+
+	// Once the user closes it, another is opened.
+	w.Close(1)
+	p.Show()
+	want = map[int]*fakes.Window{
+		2: {ID: 2, Page: Page, Width: Width, Height: Height},
+	}
+	if diff := pretty.Diff(w.Open, want); diff != nil {
+		t.Errorf("incorrect windows after closing; -got +want: %s", diff)
+	}
+}
+
+func TestShowClosedUnnoticed(t *testing.T) {
+	w := fakes.NewWindows()
+	p := New(w, Page, Width, Height)
+	p.Show()
+
+	// The window is closed without an event being delivered; it cannot
+	// be brought to the front, so another is opened.
+	delete(w.Open, 1)
+	p.Show()
+	if w.Created != 2 {
+		t.Errorf("incorrect number of windows opened; got %d, want 2", w.Created)
+	}
+	if _, ok := w.Open[2]; !ok {
+		t.Errorf("replacement window not open; got %v", w.Open)
+	}
+}
+
+func TestShowFailure(t *testing.T) {
+	w := fakes.NewWindows()
+	p := New(w, Page, Width, Height)
+
+	// Failures are logged, and the window is opened next time.
+	w.Err = errors.New("failed")
+	p.Show()
+	if len(w.Open) != 0 {
+		t.Errorf("unexpected windows open after failure; got %v", w.Open)
+	}
+
+	w.Err = nil
+	p.Show()
+	if len(w.Open) != 1 {
+		t.Errorf("incorrect windows open after recovery; got %v, want 1", w.Open)
+	}
+}
@@ -0,0 +1,502 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package presence shares which keys are loaded on each of the user's
+// devices through Chrome Sync.  Each device publishes a record listing the
+// configured keys loaded in its agent, so that the options page on another
+// device can show where else a key is loaded.  A device may also ask another
+// to unload a key; the request is acted upon once it is synced to that
+// device.
+//
+// Sharing is optional, and must be enabled on each device.  Records and
+// requests are encrypted with a key derived from a passphrase that the user
+// enters on each device, so they reveal nothing to anyone able to read the
+// user's synced data who does not know the passphrase.  Records from devices
+// that use a different passphrase cannot be read, and are ignored.
+package presence
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// MinPassphraseLength is the minimum length of the passphrase from
+	// which the key used to encrypt records is derived.
+	MinPassphraseLength = 12
+
+	// SecretKey is the key under which the key used to encrypt records is
+	// stored.  Sharing is enabled on a device if it is present.  It is
+	// stored per-device; it is never synced.
+	SecretKey = "presence.secret"
+	// NameKey is the key under which the name by which this device is
+	// listed on other devices is stored.  It is stored per-device.
+	NameKey = "presence.name"
+	// deviceKey is the key under which the ID of this device is stored.
+	// It is stored per-device, and kept when sharing is disabled so that
+	// the device keeps its identity if sharing is enabled again.
+	deviceKey = "presence.device"
+
+	// recordPrefix is the prefix for the synced items holding each
+	// device's record; it is followed by the device's ID.
+	recordPrefix = "presence.record."
+	// unloadPrefix is the prefix for the synced items holding requests
+	// to unload keys; it is followed by the ID of the device asked to
+	// unload the keys, a period, and the ID of the device asking.  Each
+	// device writes only its own requests, so requests from different
+	// devices never overwrite one another.
+	unloadPrefix = "presence.unload."
+
+	// salt is used when deriving the key used to encrypt records.  It is
+	// the same on every device, so that the same passphrase derives the
+	// same key everywhere.  It is versioned so that the derivation can be
+	// changed in the future.
+	salt = "chrome-ssh-agent/presence/v1"
+
+	// Argon2id parameters.  These must never change for a given salt;
+	// doing so would prevent devices from reading each other's records.
+	argonTime    = 1
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	secretSize   = 32
+
+	// deviceIDSize is the number of random bytes in a device ID.
+	deviceIDSize = 8
+)
+
+var (
+	// ErrShortPassphrase indicates that the passphrase is too short.
+	ErrShortPassphrase = fmt.Errorf("passphrase must be at least %d characters", MinPassphraseLength)
+	// ErrMissingName indicates that no name was supplied for this
+	// device.
+	ErrMissingName = errors.New("device name must be supplied")
+	// ErrDisabled indicates that sharing is not enabled on this device.
+	ErrDisabled = errors.New("sharing loaded keys is not enabled on this device")
+)
+
+// Changed determines if a change to storage affects the records or requests
+// shared by any device, or the settings on this device.  changes is the set
+// of changed items, as passed to chrome.Storage.OnChanged().
+func Changed(changes map[string]interface{}) bool {
+	for k := range changes {
+		if strings.HasPrefix(k, "presence.") {
+			return true
+		}
+	}
+	return false
+}
+
+// Device describes the configured keys loaded on another of the user's
+// devices.
+type Device struct {
+	// ID uniquely identifies the device.
+	ID string
+	// Name is the name chosen for the device by the user.
+	Name string
+	// Updated is the time at which the device last changed its record.
+	Updated time.Time
+	// Loaded lists the configured keys loaded on the device.
+	Loaded []keys.ID
+	// Unloading lists the keys that this device has asked it to unload,
+	// but which it has not yet acted upon.
+	Unloading []keys.ID
+}
+
+// Settings describes whether sharing is enabled on this device.
+type Settings struct {
+	// Enabled indicates that sharing is enabled.
+	Enabled bool
+	// Name is the name by which this device is listed on other devices.
+	Name string
+}
+
+// record is the encrypted content of the item published by each device.
+type record struct {
+	Name    string    `json:"name"`
+	Updated int64     `json:"updated"`
+	Keys    []keys.ID `json:"keys"`
+}
+
+// request is the encrypted content of a request to unload keys.
+type request struct {
+	Keys []keys.ID `json:"keys"`
+}
+
+// config is the configuration of sharing on this device.
+type config struct {
+	secret []byte
+	device string
+	name   string
+}
+
+// Directory publishes the keys loaded on this device, and reads those loaded
+// on the user's other devices.
+type Directory struct {
+	sync  storage.Store
+	local storage.Store
+}
+
+// New returns a Directory that shares records through sync, and stores the
+// settings for this device in local.
+func New(sync, local storage.Store) *Directory {
+	return &Directory{
+		sync:  sync,
+		local: local,
+	}
+}
+
+// deriveSecret returns the key used to encrypt records, derived from
+// passphrase.
+func deriveSecret(passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), []byte(salt), argonTime, argonMemory, argonThreads, secretSize)
+}
+
+// newDeviceID returns a new random device ID.
+func newDeviceID() (string, error) {
+	b := make([]byte, deviceIDSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate device ID: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// recordItem returns the name of the synced item holding a device's record.
+func recordItem(device string) string {
+	return recordPrefix + device
+}
+
+// requestItem returns the name of the synced item holding the requests made
+// by one device for another to unload keys.
+func requestItem(target, requester string) string {
+	return fmt.Sprintf("%s%s.%s", unloadPrefix, target, requester)
+}
+
+// parseRequestItem returns the devices named by an item holding requests to
+// unload keys.  ok is false if the item does not hold requests.
+func parseRequestItem(item string) (target, requester string, ok bool) {
+	if !strings.HasPrefix(item, unloadPrefix) {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(item, unloadPrefix), ".")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// seal encrypts v for storage in the named item.  The item's name is
+// authenticated, so that an encrypted value cannot be moved to another item.
+func seal(secret []byte, item string, v interface{}) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s: %v", item, err)
+	}
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(item))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts the value stored in the named item into v.  It fails if the
+// value was encrypted with a different key.
+func open(secret []byte, item string, value interface{}, v interface{}) error {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s has unexpected type %T", item, value)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %v", item, err)
+	}
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return fmt.Errorf("%s is truncated", item)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(item))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %v", item, err)
+	}
+	if err := json.Unmarshal(plaintext, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", item, err)
+	}
+	return nil
+}
+
+// newAEAD returns the cipher used to encrypt records with secret.
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return aead, nil
+}
+
+// config reads the configuration of sharing on this device.  It returns nil
+// if sharing is not enabled.
+func (d *Directory) config(callback func(c *config, err error)) {
+	d.local.GetItems([]string{SecretKey, NameKey, deviceKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read sharing settings: %v", err))
+			return
+		}
+		s, _ := data[SecretKey].(string)
+		device, _ := data[deviceKey].(string)
+		if s == "" || device == "" {
+			callback(nil, nil)
+			return
+		}
+		secret, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to decode sharing key: %v", err))
+			return
+		}
+		name, _ := data[NameKey].(string)
+		callback(&config{secret: secret, device: device, name: name}, nil)
+	})
+}
+
+// Settings reads whether sharing is enabled on this device.
+func (d *Directory) Settings(callback func(s *Settings, err error)) {
+	d.config(func(c *config, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		if c == nil {
+			callback(&Settings{}, nil)
+			return
+		}
+		callback(&Settings{Enabled: true, Name: c.name}, nil)
+	})
+}
+
+// Enable enables sharing on this device, which is listed on other devices
+// under name.  The same passphrase must be used on every device.  If sharing
+// is already enabled, the passphrase and name are replaced.
+func (d *Directory) Enable(passphrase, name string, callback func(err error)) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		callback(ErrMissingName)
+		return
+	}
+	if len([]rune(passphrase)) < MinPassphraseLength {
+		callback(ErrShortPassphrase)
+		return
+	}
+
+	d.local.GetItems([]string{deviceKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to read sharing settings: %v", err))
+			return
+		}
+		device, _ := data[deviceKey].(string)
+		if device == "" {
+			if device, err = newDeviceID(); err != nil {
+				callback(err)
+				return
+			}
+		}
+		settings := map[string]interface{}{
+			SecretKey: base64.StdEncoding.EncodeToString(deriveSecret(passphrase)),
+			NameKey:   name,
+			deviceKey: device,
+		}
+		d.local.Set(settings, func(err error) {
+			if err != nil {
+				callback(fmt.Errorf("failed to write sharing settings: %v", err))
+				return
+			}
+			callback(nil)
+		})
+	})
+}
+
+// Disable disables sharing on this device.  Its record is deleted, along with
+// the requests it made and those made of it.
+func (d *Directory) Disable(callback func(err error)) {
+	d.config(func(c *config, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		if c == nil {
+			callback(nil)
+			return
+		}
+
+		d.sync.Get(func(data map[string]interface{}, err error) {
+			if err != nil {
+				callback(fmt.Errorf("failed to read shared records: %v", err))
+				return
+			}
+			items := []string{recordItem(c.device)}
+			for item := range data {
+				if target, requester, ok := parseRequestItem(item); ok && (target == c.device || requester == c.device) {
+					items = append(items, item)
+				}
+			}
+			d.sync.Delete(items, func(err error) {
+				if err != nil {
+					callback(fmt.Errorf("failed to delete shared records: %v", err))
+					return
+				}
+				d.local.Delete([]string{SecretKey}, func(err error) {
+					if err != nil {
+						callback(fmt.Errorf("failed to write sharing settings: %v", err))
+						return
+					}
+					callback(nil)
+				})
+			})
+		})
+	})
+}
+
+// Devices returns the user's other devices that share the keys loaded on
+// them, ordered by name.  Devices whose records cannot be read (e.g., because
+// they use a different passphrase) are omitted.  If sharing is not enabled on
+// this device, no devices are returned.
+func (d *Directory) Devices(callback func(devices []*Device, err error)) {
+	d.config(func(c *config, err error) {
+		if err != nil || c == nil {
+			callback(nil, err)
+			return
+		}
+
+		d.sync.Get(func(data map[string]interface{}, err error) {
+			if err != nil {
+				callback(nil, fmt.Errorf("failed to read shared records: %v", err))
+				return
+			}
+
+			byID := make(map[string]*Device)
+			var result []*Device
+			for item, value := range data {
+				if !strings.HasPrefix(item, recordPrefix) {
+					continue
+				}
+				id := strings.TrimPrefix(item, recordPrefix)
+				var r record
+				if id == c.device || open(c.secret, item, value, &r) != nil {
+					continue
+				}
+				dev := &Device{
+					ID:      id,
+					Name:    r.Name,
+					Updated: time.Unix(0, r.Updated*int64(time.Millisecond)),
+					Loaded:  r.Keys,
+				}
+				byID[id] = dev
+				result = append(result, dev)
+			}
+
+			for item, value := range data {
+				target, requester, ok := parseRequestItem(item)
+				if !ok || requester != c.device || byID[target] == nil {
+					continue
+				}
+				var r request
+				if open(c.secret, item, value, &r) != nil {
+					continue
+				}
+				byID[target].Unloading = r.Keys
+			}
+
+			sort.Slice(result, func(i, j int) bool {
+				if result[i].Name != result[j].Name {
+					return result[i].Name < result[j].Name
+				}
+				return result[i].ID < result[j].ID
+			})
+			callback(result, nil)
+		})
+	})
+}
+
+// RequestUnload asks another device to unload a key.  The device acts on the
+// request once it is synced to it.
+func (d *Directory) RequestUnload(device string, id keys.ID, callback func(err error)) {
+	d.config(func(c *config, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		if c == nil {
+			callback(ErrDisabled)
+			return
+		}
+
+		item := requestItem(device, c.device)
+		d.sync.GetItems([]string{item}, func(data map[string]interface{}, err error) {
+			if err != nil {
+				callback(fmt.Errorf("failed to read unload requests: %v", err))
+				return
+			}
+			// Add to any outstanding request.  One that cannot be
+			// read (e.g., because the passphrase has changed) is
+			// replaced.
+			var r request
+			if value, ok := data[item]; ok && open(c.secret, item, value, &r) != nil {
+				r = request{}
+			}
+			for _, k := range r.Keys {
+				if k == id {
+					callback(nil)
+					return
+				}
+			}
+			r.Keys = append(r.Keys, id)
+
+			sealed, err := seal(c.secret, item, &r)
+			if err != nil {
+				callback(err)
+				return
+			}
+			d.sync.Set(map[string]interface{}{item: sealed}, func(err error) {
+				if err != nil {
+					callback(fmt.Errorf("failed to write unload request: %v", err))
+					return
+				}
+				callback(nil)
+			})
+		})
+	})
+}
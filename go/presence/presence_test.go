@@ -0,0 +1,404 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presence
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	testPassphrase  = "correct horse battery"
+	otherPassphrase = "incorrect horse battery"
+)
+
+// device is one of the user's devices.  Configured keys are synced between
+// devices, but each has its own agent and local storage.
+type device struct {
+	dir *Directory
+	mgr keys.Manager
+}
+
+func newDevice(sync *fakes.MemStorage) *device {
+	local := fakes.NewMemStorage()
+	return &device{
+		dir: New(sync, local),
+		mgr: keys.NewManager(agent.NewKeyring(), sync, local),
+	}
+}
+
+func syncEnable(dir *Directory, passphrase, name string) error {
+	var result error
+	dir.Enable(passphrase, name, func(err error) {
+		result = err
+	})
+	return result
+}
+
+func syncDisable(dir *Directory) error {
+	var result error
+	dir.Disable(func(err error) {
+		result = err
+	})
+	return result
+}
+
+func syncSettings(dir *Directory) (*Settings, error) {
+	var result *Settings
+	var resultErr error
+	dir.Settings(func(s *Settings, err error) {
+		result, resultErr = s, err
+	})
+	return result, resultErr
+}
+
+func syncDevices(dir *Directory) ([]*Device, error) {
+	var result []*Device
+	var resultErr error
+	dir.Devices(func(devices []*Device, err error) {
+		result, resultErr = devices, err
+	})
+	return result, resultErr
+}
+
+func syncRequestUnload(dir *Directory, device string, id keys.ID) error {
+	var result error
+	dir.RequestUnload(device, id, func(err error) {
+		result = err
+	})
+	return result
+}
+
+func syncPublish(d *device) ([]*keys.LoadedKey, error) {
+	var result []*keys.LoadedKey
+	var resultErr error
+	d.dir.publish(d.mgr, func(unloaded []*keys.LoadedKey, err error) {
+		result, resultErr = unloaded, err
+	})
+	return result, resultErr
+}
+
+// addKey configures a key on d, returning its ID.
+func addKey(d *device, name, privateKey string) keys.ID {
+	var id keys.ID
+	d.mgr.Add(name, privateKey, nil, func(err error) {
+		if err != nil {
+			panic(err)
+		}
+	})
+	d.mgr.Configured(func(configured []*keys.ConfiguredKey, err error) {
+		if err != nil {
+			panic(err)
+		}
+		for _, c := range configured {
+			if c.Name == name {
+				id = c.ID
+			}
+		}
+	})
+	return id
+}
+
+// loadKey loads a configured key on d.
+func loadKey(d *device, id keys.ID, passphrase string) {
+	d.mgr.Load(id, passphrase, func(err error) {
+		if err != nil {
+			panic(err)
+		}
+	})
+}
+
+// loadedIDs returns the IDs of the keys loaded on d.
+func loadedIDs(d *device) []keys.ID {
+	var result []keys.ID
+	d.mgr.Loaded(func(loaded []*keys.LoadedKey, err error) {
+		if err != nil {
+			panic(err)
+		}
+		for _, l := range loaded {
+			result = append(result, l.ID())
+		}
+	})
+	return result
+}
+
+// deviceID returns the ID of d, as listed on other devices.
+func deviceID(d *device) string {
+	var result string
+	d.dir.config(func(c *config, err error) {
+		if err != nil || c == nil {
+			panic("sharing not enabled")
+		}
+		result = c.device
+	})
+	return result
+}
+
+func TestEnable(t *testing.T) {
+	testcases := []struct {
+		description  string
+		passphrase   string
+		name         string
+		wantErr      error
+		wantSettings *Settings
+	}{
+		{
+			description:  "enabled",
+			passphrase:   testPassphrase,
+			name:         " work laptop ",
+			wantSettings: &Settings{Enabled: true, Name: "work laptop"},
+		},
+		{
+			description:  "short passphrase",
+			passphrase:   "short",
+			name:         "work laptop",
+			wantErr:      ErrShortPassphrase,
+			wantSettings: &Settings{},
+		},
+		{
+			description:  "missing name",
+			passphrase:   testPassphrase,
+			name:         " ",
+			wantErr:      ErrMissingName,
+			wantSettings: &Settings{},
+		},
+	}
+
+	for _, tc := range testcases {
+		d := newDevice(fakes.NewMemStorage())
+		if err := syncEnable(d.dir, tc.passphrase, tc.name); err != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want %v", tc.description, err, tc.wantErr)
+		}
+		s, err := syncSettings(d.dir)
+		if err != nil {
+			t.Errorf("%s: failed to read settings: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(s, tc.wantSettings); diff != nil {
+			t.Errorf("%s: incorrect settings; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestDevices(t *testing.T) {
+	sync := fakes.NewMemStorage()
+	self, other, stranger, disabled := newDevice(sync), newDevice(sync), newDevice(sync), newDevice(sync)
+	for _, d := range []struct {
+		device     *device
+		passphrase string
+		name       string
+	}{
+		{self, testPassphrase, "self"},
+		{other, testPassphrase, "other"},
+		{stranger, otherPassphrase, "stranger"},
+	} {
+		if err := syncEnable(d.device.dir, d.passphrase, d.name); err != nil {
+			t.Fatalf("failed to enable sharing on %s: %v", d.name, err)
+		}
+	}
+
+	id := addKey(self, "some-key", testdata.ValidPrivateKey)
+	for _, d := range []*device{self, other, stranger, disabled} {
+		loadKey(d, id, testdata.ValidPrivateKeyPassphrase)
+		if _, err := syncPublish(d); err != nil {
+			t.Fatalf("failed to publish: %v", err)
+		}
+	}
+
+	// Only the other device using the same passphrase is listed.
+	devices, err := syncDevices(self.dir)
+	if err != nil {
+		t.Fatalf("failed to read devices: %v", err)
+	}
+	var names []string
+	for _, d := range devices {
+		names = append(names, d.Name)
+		if diff := pretty.Diff(d.Loaded, []keys.ID{id}); diff != nil {
+			t.Errorf("incorrect keys loaded on %s; -got +want: %s", d.Name, diff)
+		}
+	}
+	if diff := pretty.Diff(names, []string{"other"}); diff != nil {
+		t.Errorf("incorrect devices; -got +want: %s", diff)
+	}
+
+	// Devices on which sharing is not enabled see nothing.
+	devices, err = syncDevices(disabled.dir)
+	if err != nil {
+		t.Errorf("failed to read devices while disabled: %v", err)
+	}
+	if len(devices) != 0 {
+		t.Errorf("incorrect devices while disabled; got %d, want 0", len(devices))
+	}
+
+	// Once sharing is disabled, the device's record is deleted.
+	if err := syncDisable(other.dir); err != nil {
+		t.Fatalf("failed to disable sharing: %v", err)
+	}
+	devices, err = syncDevices(self.dir)
+	if err != nil {
+		t.Errorf("failed to read devices after disabling: %v", err)
+	}
+	if len(devices) != 0 {
+		t.Errorf("incorrect devices after disabling; got %d, want 0", len(devices))
+	}
+}
+
+func TestRequestUnload(t *testing.T) {
+	sync := fakes.NewMemStorage()
+	self, other := newDevice(sync), newDevice(sync)
+	for _, d := range []*device{self, other} {
+		if err := syncEnable(d.dir, testPassphrase, "some-device"); err != nil {
+			t.Fatalf("failed to enable sharing: %v", err)
+		}
+	}
+	id := addKey(self, "some-key", testdata.ValidPrivateKey)
+	keep := addKey(self, "other-key", testdata.ValidPrivateKeyWithoutPassphrase)
+	loadKey(other, id, testdata.ValidPrivateKeyPassphrase)
+	loadKey(other, keep, "")
+	if _, err := syncPublish(other); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+
+	// Requests are listed until the other device acts upon them.
+	target := deviceID(other)
+	if err := syncRequestUnload(self.dir, target, id); err != nil {
+		t.Fatalf("failed to request unload: %v", err)
+	}
+	devices, err := syncDevices(self.dir)
+	if err != nil || len(devices) != 1 {
+		t.Fatalf("failed to read devices; got %d, err %v", len(devices), err)
+	}
+	if diff := pretty.Diff(devices[0].Unloading, []keys.ID{id}); diff != nil {
+		t.Errorf("incorrect pending requests; -got +want: %s", diff)
+	}
+
+	unloaded, err := syncPublish(other)
+	if err != nil {
+		t.Errorf("failed to publish after request: %v", err)
+	}
+	if len(unloaded) != 1 || unloaded[0].ID() != id {
+		t.Errorf("incorrect keys unloaded; got %v, want %s", unloaded, id)
+	}
+	if diff := pretty.Diff(loadedIDs(other), []keys.ID{keep}); diff != nil {
+		t.Errorf("incorrect keys remain loaded; -got +want: %s", diff)
+	}
+
+	devices, err = syncDevices(self.dir)
+	if err != nil || len(devices) != 1 {
+		t.Fatalf("failed to read devices after unload; got %d, err %v", len(devices), err)
+	}
+	if diff := pretty.Diff(devices[0].Loaded, []keys.ID{keep}); diff != nil {
+		t.Errorf("incorrect keys listed after unload; -got +want: %s", diff)
+	}
+	if len(devices[0].Unloading) != 0 {
+		t.Errorf("request not deleted after unload; got %v", devices[0].Unloading)
+	}
+}
+
+func TestRequestUnloadDisabled(t *testing.T) {
+	d := newDevice(fakes.NewMemStorage())
+	if err := syncRequestUnload(d.dir, "some-device", keys.ID("some-id")); err != ErrDisabled {
+		t.Errorf("incorrect error; got %v, want %v", err, ErrDisabled)
+	}
+}
+
+func TestPublishUnchanged(t *testing.T) {
+	sync := fakes.NewMemStorage()
+	d := newDevice(sync)
+	if err := syncEnable(d.dir, testPassphrase, "some-device"); err != nil {
+		t.Fatalf("failed to enable sharing: %v", err)
+	}
+	item := recordItem(deviceID(d))
+	read := func() interface{} {
+		var result interface{}
+		sync.GetItems([]string{item}, func(data map[string]interface{}, err error) {
+			result = data[item]
+		})
+		return result
+	}
+
+	if _, err := syncPublish(d); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+	first := read()
+	if first == nil {
+		t.Fatalf("record not written")
+	}
+
+	// The record is only rewritten when it changes.
+	if _, err := syncPublish(d); err != nil {
+		t.Fatalf("failed to publish again: %v", err)
+	}
+	if read() != first {
+		t.Errorf("unchanged record was rewritten")
+	}
+	loadKey(d, addKey(d, "some-key", testdata.ValidPrivateKey), testdata.ValidPrivateKeyPassphrase)
+	if _, err := syncPublish(d); err != nil {
+		t.Fatalf("failed to publish after load: %v", err)
+	}
+	if read() == first {
+		t.Errorf("changed record was not rewritten")
+	}
+}
+
+func TestSeal(t *testing.T) {
+	secret, otherSecret := deriveSecret(testPassphrase), deriveSecret(otherPassphrase)
+	sealed, err := seal(secret, "some-item", &request{Keys: []keys.ID{"some-id"}})
+	if err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+
+	testcases := []struct {
+		description string
+		secret      []byte
+		item        string
+		wantErr     bool
+	}{
+		{
+			description: "same secret and item",
+			secret:      secret,
+			item:        "some-item",
+		},
+		{
+			description: "different secret",
+			secret:      otherSecret,
+			item:        "some-item",
+			wantErr:     true,
+		},
+		{
+			description: "different item",
+			secret:      secret,
+			item:        "other-item",
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		var r request
+		err := open(tc.secret, tc.item, sealed, &r)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want error %v", tc.description, err, tc.wantErr)
+		}
+		if err == nil {
+			if diff := pretty.Diff(r.Keys, []keys.ID{"some-id"}); diff != nil {
+				t.Errorf("%s: incorrect request; -got +want: %s", tc.description, diff)
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presence
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/keys"
+)
+
+// Publisher keeps this device's record up to date, and acts on requests from
+// the user's other devices to unload keys.  It is used by the background
+// page.
+type Publisher struct {
+	dir    *Directory
+	mgr    keys.Manager
+	report func(unloaded []*keys.LoadedKey, err error)
+
+	mu sync.Mutex
+	// running indicates that an update is in progress.
+	running bool
+	// pending indicates that another update was requested while one was
+	// in progress.
+	pending bool
+}
+
+// NewPublisher returns a Publisher that publishes the keys loaded by mgr
+// through dir.  report is invoked after each update that unloads keys at
+// another device's request, or fails.
+func NewPublisher(dir *Directory, mgr keys.Manager, report func(unloaded []*keys.LoadedKey, err error)) *Publisher {
+	return &Publisher{
+		dir:    dir,
+		mgr:    mgr,
+		report: report,
+	}
+}
+
+// Update acts on any requests to unload keys, then publishes the keys loaded
+// on this device.  It should be invoked whenever the loaded keys, the
+// settings, or the requests made of this device change.  If an update is
+// already in progress, another follows it, so that the latest state is
+// always published.
+func (p *Publisher) Update() {
+	p.mu.Lock()
+	if p.running {
+		p.pending = true
+		p.mu.Unlock()
+		return
+	}
+	p.running = true
+	p.mu.Unlock()
+
+	var run func()
+	run = func() {
+		p.dir.publish(p.mgr, func(unloaded []*keys.LoadedKey, err error) {
+			if len(unloaded) > 0 || err != nil {
+				p.report(unloaded, err)
+			}
+
+			p.mu.Lock()
+			again := p.pending
+			p.pending = false
+			p.running = again
+			p.mu.Unlock()
+			if again {
+				run()
+			}
+		})
+	}
+	run()
+}
+
+// publish acts on any requests to unload keys loaded by mgr, then publishes
+// the keys that remain loaded.  The record is only written if it has
+// changed.  callback is invoked with the keys that were unloaded.  Failing to
+// unload one key does not prevent others from being unloaded.
+func (d *Directory) publish(mgr keys.Manager, callback func(unloaded []*keys.LoadedKey, err error)) {
+	d.config(func(c *config, err error) {
+		if err != nil || c == nil {
+			callback(nil, err)
+			return
+		}
+
+		d.sync.Get(func(data map[string]interface{}, err error) {
+			if err != nil {
+				callback(nil, fmt.Errorf("failed to read shared records: %v", err))
+				return
+			}
+
+			// Collect the requests made of this device.  Those
+			// that cannot be read can never be acted upon, so are
+			// deleted too.
+			requested := make(map[keys.ID]bool)
+			var handled []string
+			for item, value := range data {
+				target, _, ok := parseRequestItem(item)
+				if !ok || target != c.device {
+					continue
+				}
+				handled = append(handled, item)
+				var r request
+				if open(c.secret, item, value, &r) != nil {
+					continue
+				}
+				for _, id := range r.Keys {
+					requested[id] = true
+				}
+			}
+
+			mgr.Loaded(func(loaded []*keys.LoadedKey, err error) {
+				if err != nil {
+					callback(nil, fmt.Errorf("failed to list loaded keys: %v", err))
+					return
+				}
+
+				var unloaded []*keys.LoadedKey
+				var firstErr error
+				remaining := make(map[keys.ID]bool)
+				var next func(i int)
+				next = func(i int) {
+					if i < len(loaded) {
+						l := loaded[i]
+						id := l.ID()
+						if id == keys.InvalidID {
+							next(i + 1)
+							return
+						}
+						if !requested[id] {
+							remaining[id] = true
+							next(i + 1)
+							return
+						}
+						mgr.Unload(l, func(err error) {
+							if err != nil {
+								remaining[id] = true
+								if firstErr == nil {
+									firstErr = fmt.Errorf("failed to unload key %s: %v", id, err)
+								}
+							} else {
+								unloaded = append(unloaded, l)
+							}
+							next(i + 1)
+						})
+						return
+					}
+
+					d.finishPublish(c, data, handled, remaining, func(err error) {
+						if firstErr == nil {
+							firstErr = err
+						}
+						callback(unloaded, firstErr)
+					})
+				}
+				next(0)
+			})
+		})
+	})
+}
+
+// finishPublish deletes the requests that were handled, and writes this
+// device's record listing the remaining keys if it has changed.  data is the
+// content of synced storage, read before the requests were handled.
+func (d *Directory) finishPublish(c *config, data map[string]interface{}, handled []string, remaining map[keys.ID]bool, callback func(err error)) {
+	deleteHandled := func(callback func(err error)) {
+		if len(handled) == 0 {
+			callback(nil)
+			return
+		}
+		d.sync.Delete(handled, func(err error) {
+			if err != nil {
+				callback(fmt.Errorf("failed to delete unload requests: %v", err))
+				return
+			}
+			callback(nil)
+		})
+	}
+
+	r := &record{Name: c.name}
+	for id := range remaining {
+		r.Keys = append(r.Keys, id)
+	}
+	sort.Slice(r.Keys, func(i, j int) bool { return r.Keys[i] < r.Keys[j] })
+
+	item := recordItem(c.device)
+	var old record
+	if value, ok := data[item]; ok && open(c.secret, item, value, &old) == nil && sameRecord(&old, r) {
+		deleteHandled(callback)
+		return
+	}
+
+	r.Updated = time.Now().UnixNano() / int64(time.Millisecond)
+	sealed, err := seal(c.secret, item, r)
+	if err != nil {
+		callback(err)
+		return
+	}
+	d.sync.Set(map[string]interface{}{item: sealed}, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to write record: %v", err))
+			return
+		}
+		deleteHandled(callback)
+	})
+}
+
+// sameRecord determines if two records list the same keys under the same
+// name.  The time at which they were updated is ignored.
+func sameRecord(a, b *record) bool {
+	if a.Name != b.Name || len(a.Keys) != len(b.Keys) {
+		return false
+	}
+	for i := range a.Keys {
+		if a.Keys[i] != b.Keys[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presence
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+)
+
+func TestPublisher(t *testing.T) {
+	sync := fakes.NewMemStorage()
+	self, other := newDevice(sync), newDevice(sync)
+	for _, d := range []*device{self, other} {
+		if err := syncEnable(d.dir, testPassphrase, "some-device"); err != nil {
+			t.Fatalf("failed to enable sharing: %v", err)
+		}
+	}
+	id := addKey(self, "some-key", testdata.ValidPrivateKey)
+	loadKey(other, id, testdata.ValidPrivateKeyPassphrase)
+
+	var reports [][]*keys.LoadedKey
+	p := NewPublisher(other.dir, other.mgr, func(unloaded []*keys.LoadedKey, err error) {
+		if err != nil {
+			t.Errorf("update failed: %v", err)
+		}
+		reports = append(reports, unloaded)
+	})
+
+	// Updates that unload nothing are not reported.
+	p.Update()
+	if len(reports) != 0 {
+		t.Errorf("incorrect reports without requests; got %d, want 0", len(reports))
+	}
+
+	if err := syncRequestUnload(self.dir, deviceID(other), id); err != nil {
+		t.Fatalf("failed to request unload: %v", err)
+	}
+	p.Update()
+	if len(reports) != 1 || len(reports[0]) != 1 || reports[0][0].ID() != id {
+		t.Errorf("incorrect reports after request; got %v, want key %s unloaded", reports, id)
+	}
+	if ids := loadedIDs(other); len(ids) != 0 {
+		t.Errorf("incorrect keys remain loaded; got %v, want none", ids)
+	}
+}
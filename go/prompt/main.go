@@ -0,0 +1,31 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/google/chrome-ssh-agent/go/chrome"
+	"github.com/google/chrome-ssh-agent/go/dom"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/promptui"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+func main() {
+	c := chrome.New(nil)
+	d := dom.New(dom.Doc)
+	promptui.New(keys.NewTOTPClient(c), keys.NewConfirmClient(c), keys.NewPassphrasePromptClient(c), func() {
+		js.Global.Get("window").Call("close")
+	}, d)
+}
@@ -0,0 +1,375 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package promptui implements the prompt page, which the background page
+// opens in a popup window (see the popup package) when it needs the user to
+// confirm a signature or enter a passphrase.  Prompts are displayed one at
+// a time, and can be answered using only the keyboard: the input has focus,
+// Enter submits it, and Escape cancels it (refusing a signature that must
+// be allowed).  The window closes once nothing
+// is left waiting.
+package promptui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/dom"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/redact"
+	"github.com/google/chrome-ssh-agent/go/totp"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// closeDelay is how long the window waits for further prompts before it
+// closes.  A key whose passphrase was incorrect is only asked for again
+// once loading it has failed.
+const closeDelay = 500 * time.Millisecond
+
+// TOTPResponder lists signature requests waiting for a confirmation code, and
+// enters codes for them.  It is implemented by keys.TOTPClient.
+type TOTPResponder interface {
+	Pending(callback func(challenges []*keys.TOTPChallenge, err error))
+	Respond(challenge int, code string, callback func(err error))
+}
+
+// ConfirmResponder lists signature requests waiting for the user to allow
+// them, and allows or refuses them.  It is implemented by
+// keys.ConfirmClient.
+type ConfirmResponder interface {
+	Pending(callback func(requests []*keys.ConfirmRequest, err error))
+	Respond(request int, allow bool, callback func(err error))
+}
+
+// PassphraseResponder lists keys waiting for a passphrase, and enters
+// passphrases for them.  It is implemented by keys.PassphrasePromptClient.
+type PassphraseResponder interface {
+	Pending(callback func(requests []*keys.PassphraseRequest, err error))
+	Respond(request int, passphrase string, ok bool, callback func(err error))
+}
+
+// UI implements the prompt page.
+type UI struct {
+	totp        TOTPResponder
+	confirm     ConfirmResponder
+	passphrases PassphraseResponder
+	closeWindow func()
+	// after invokes f after d has elapsed.  It may be replaced in tests.
+	after func(d time.Duration, f func())
+
+	// skipped contains the signature requests for which the user
+	// declined to enter a code.  They are left to time out.
+	skipped map[int]bool
+	// submit and cancel are invoked when the prompt that is displayed is
+	// submitted or cancelled, or are nil if none is displayed.
+	submit func()
+	cancel func()
+
+	dom              *dom.DOM
+	confirmPrompt    *js.Object
+	confirmName      *js.Object
+	confirmRequester *js.Object
+	confirmReason    *js.Object
+	confirmAllow     *js.Object
+	confirmDeny      *js.Object
+	totpPrompt       *js.Object
+	totpName         *js.Object
+	totpInput        *js.Object
+	totpStatus       *js.Object
+	totpCancel       *js.Object
+	passphrasePrompt *js.Object
+	passphraseName   *js.Object
+	passphraseInput  *js.Object
+	passphraseStatus *js.Object
+	passphraseCancel *js.Object
+	errorText        *js.Object
+}
+
+// New returns a new UI instance that answers prompts using the supplied
+// responders.  closeWindow is invoked to close the window once nothing is
+// left waiting.
+func New(totp TOTPResponder, confirm ConfirmResponder, passphrases PassphraseResponder, closeWindow func(), domObj *dom.DOM) *UI {
+	result := &UI{
+		totp:        totp,
+		confirm:     confirm,
+		passphrases: passphrases,
+		closeWindow: closeWindow,
+		after: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+		skipped:          make(map[int]bool),
+		dom:              domObj,
+		confirmPrompt:    domObj.GetElement("confirmPrompt"),
+		confirmName:      domObj.GetElement("confirmName"),
+		confirmRequester: domObj.GetElement("confirmRequester"),
+		confirmReason:    domObj.GetElement("confirmReason"),
+		confirmAllow:     domObj.GetElement("confirmAllow"),
+		confirmDeny:      domObj.GetElement("confirmDeny"),
+		totpPrompt:       domObj.GetElement("totpPrompt"),
+		totpName:         domObj.GetElement("totpName"),
+		totpInput:        domObj.GetElement("totp"),
+		totpStatus:       domObj.GetElement("totpStatus"),
+		totpCancel:       domObj.GetElement("totpCancel"),
+		passphrasePrompt: domObj.GetElement("passphrasePrompt"),
+		passphraseName:   domObj.GetElement("passphraseName"),
+		passphraseInput:  domObj.GetElement("passphrase"),
+		passphraseStatus: domObj.GetElement("passphraseStatus"),
+		passphraseCancel: domObj.GetElement("passphraseCancel"),
+		errorText:        domObj.GetElement("errorMessage"),
+	}
+
+	for _, form := range []*js.Object{result.confirmPrompt, result.totpPrompt, result.passphrasePrompt} {
+		result.dom.OnSubmit(form, result.doSubmit)
+		result.dom.OnKeyDown(form, func(key string) {
+			if key == "Escape" {
+				result.doCancel()
+			}
+		})
+	}
+	result.dom.OnClick(result.confirmDeny, result.doCancel)
+	result.dom.OnClick(result.totpCancel, result.doCancel)
+	result.dom.OnClick(result.passphraseCancel, result.doCancel)
+
+	result.dom.OnDOMContentLoaded(result.Next)
+	return result
+}
+
+// doSubmit submits the prompt that is displayed, if any.
+func (u *UI) doSubmit() {
+	if f := u.submit; f != nil {
+		u.submit, u.cancel = nil, nil
+		f()
+	}
+}
+
+// doCancel cancels the prompt that is displayed, if any.
+func (u *UI) doCancel() {
+	if f := u.cancel; f != nil {
+		u.submit, u.cancel = nil, nil
+		f()
+	}
+}
+
+// setError displays an error, or clears it if err is nil.
+func (u *UI) setError(err error) {
+	u.dom.RemoveChildren(u.errorText)
+	if err != nil {
+		u.dom.AppendChild(u.errorText, u.dom.NewText(redact.String(err.Error())), nil)
+	}
+}
+
+// setStatus displays a status message below the input of a prompt.
+func (u *UI) setStatus(status *js.Object, text string) {
+	u.dom.RemoveChildren(status)
+	if text != "" {
+		u.dom.AppendChild(status, u.dom.NewText(text), nil)
+	}
+}
+
+// show displays the specified prompt, with focus on focus (its input, or
+// for a prompt without one, its default button), and hides the others.
+// submit and cancel are invoked when it is submitted or cancelled.
+func (u *UI) show(prompt, focus *js.Object, submit, cancel func()) {
+	u.confirmPrompt.Set("hidden", prompt != u.confirmPrompt)
+	u.totpPrompt.Set("hidden", prompt != u.totpPrompt)
+	u.passphrasePrompt.Set("hidden", prompt != u.passphrasePrompt)
+	u.submit, u.cancel = submit, cancel
+	u.dom.Focus(focus)
+}
+
+// hide hides all prompts.
+func (u *UI) hide() {
+	u.confirmPrompt.Set("hidden", true)
+	u.totpPrompt.Set("hidden", true)
+	u.passphrasePrompt.Set("hidden", true)
+	u.submit, u.cancel = nil, nil
+}
+
+// Next displays the oldest signature request waiting to be allowed, or if
+// there is none, the oldest waiting for a confirmation code, or failing
+// that, the oldest key waiting for a passphrase.  If nothing is waiting, the
+// window is closed.
+func (u *UI) Next() {
+	u.pending(func(a *keys.ConfirmRequest, c *keys.TOTPChallenge, r *keys.PassphraseRequest) {
+		switch {
+		case a != nil:
+			u.promptConfirm(a)
+		case c != nil:
+			u.promptTOTP(c, nil)
+		case r != nil:
+			u.promptPassphrase(r)
+		default:
+			u.hide()
+			u.after(closeDelay, u.closeIfIdle)
+		}
+	})
+}
+
+// closeIfIdle closes the window, unless something started waiting since it
+// was last checked.
+func (u *UI) closeIfIdle() {
+	u.pending(func(a *keys.ConfirmRequest, c *keys.TOTPChallenge, r *keys.PassphraseRequest) {
+		if a != nil || c != nil || r != nil {
+			u.Next()
+			return
+		}
+		if u.dom.TextContent(u.errorText) != "" {
+			// Leave errors displayed until the user closes the
+			// window.
+			return
+		}
+		u.closeWindow()
+	})
+}
+
+// pending invokes callback with the oldest signature request waiting to be
+// allowed, the oldest waiting for a confirmation code, and the oldest key
+// waiting for a passphrase.  Any may be nil if there is none.
+func (u *UI) pending(callback func(a *keys.ConfirmRequest, c *keys.TOTPChallenge, r *keys.PassphraseRequest)) {
+	u.confirm.Pending(func(requests []*keys.ConfirmRequest, err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to list signature requests: %v", err))
+		}
+		var request *keys.ConfirmRequest
+		if len(requests) > 0 {
+			request = requests[0]
+		}
+		u.pendingCodes(func(c *keys.TOTPChallenge, r *keys.PassphraseRequest) {
+			callback(request, c, r)
+		})
+	})
+}
+
+// pendingCodes invokes callback with the oldest signature request waiting
+// for a confirmation code, and the oldest key waiting for a passphrase.
+// Either may be nil if there is none.
+func (u *UI) pendingCodes(callback func(c *keys.TOTPChallenge, r *keys.PassphraseRequest)) {
+	u.totp.Pending(func(challenges []*keys.TOTPChallenge, err error) {
+		if err != nil {
+			u.setError(fmt.Errorf("failed to list signature requests: %v", err))
+		}
+		var challenge *keys.TOTPChallenge
+		for _, c := range challenges {
+			if !u.skipped[c.Challenge] {
+				challenge = c
+				break
+			}
+		}
+
+		u.passphrases.Pending(func(requests []*keys.PassphraseRequest, err error) {
+			if err != nil {
+				u.setError(fmt.Errorf("failed to list keys waiting for a passphrase: %v", err))
+			}
+			var request *keys.PassphraseRequest
+			if len(requests) > 0 {
+				request = requests[0]
+			}
+			callback(challenge, request)
+		})
+	})
+}
+
+// promptTOTP prompts the user for a confirmation code for a signature
+// request.  If lastErr is not nil, the prompt reports that the previous
+// code was refused.
+func (u *UI) promptTOTP(c *keys.TOTPChallenge, lastErr error) {
+	u.dom.RemoveChildren(u.totpName)
+	u.dom.AppendChild(u.totpName, u.dom.NewText(c.Name), nil)
+	status := ""
+	if lastErr != nil {
+		status = fmt.Sprintf("Code refused (%v); try again", lastErr)
+	}
+	u.setStatus(u.totpStatus, status)
+
+	u.dom.SetValue(u.totpInput, "")
+	u.show(u.totpPrompt, u.totpInput, func() {
+		code := u.dom.Value(u.totpInput)
+		u.dom.SetValue(u.totpInput, "")
+		u.totp.Respond(c.Challenge, code, func(err error) {
+			switch err {
+			case nil:
+			case totp.ErrIncorrectCode, totp.ErrInvalidCode:
+				u.promptTOTP(c, err)
+				return
+			default:
+				u.setError(fmt.Errorf("failed to confirm signature using %q: %v", c.Name, err))
+			}
+			u.Next()
+		})
+	}, func() {
+		// The request is left to time out.
+		u.skipped[c.Challenge] = true
+		u.Next()
+	})
+}
+
+// promptPassphrase prompts the user for the passphrase of a key waiting to
+// be loaded.
+func (u *UI) promptPassphrase(r *keys.PassphraseRequest) {
+	u.dom.RemoveChildren(u.passphraseName)
+	u.dom.AppendChild(u.passphraseName, u.dom.NewText(r.Name), nil)
+	status := ""
+	if r.Incorrect {
+		plural := "s"
+		if r.Remaining == 1 {
+			plural = ""
+		}
+		status = fmt.Sprintf("Incorrect passphrase; %d attempt%s remaining", r.Remaining, plural)
+	}
+	u.setStatus(u.passphraseStatus, status)
+	u.dom.SetValue(u.passphraseInput, "")
+
+	respond := func(passphrase string, ok bool) {
+		u.passphrases.Respond(r.Request, passphrase, ok, func(err error) {
+			if err != nil && err != keys.ErrPassphraseNotPending {
+				u.setError(fmt.Errorf("failed to load %q: %v", r.Name, err))
+			}
+			u.Next()
+		})
+	}
+	u.show(u.passphrasePrompt, u.passphraseInput, func() {
+		p := u.dom.Value(u.passphraseInput)
+		u.dom.SetValue(u.passphraseInput, "")
+		respond(p, true)
+	}, func() {
+		u.dom.SetValue(u.passphraseInput, "")
+		respond("", false)
+	})
+}
+
+// promptConfirm asks the user to allow or refuse a signature request.
+func (u *UI) promptConfirm(a *keys.ConfirmRequest) {
+	u.dom.RemoveChildren(u.confirmName)
+	u.dom.AppendChild(u.confirmName, u.dom.NewText(a.Name), nil)
+	u.dom.RemoveChildren(u.confirmRequester)
+	if a.Requester != "" {
+		u.dom.AppendChild(u.confirmRequester, u.dom.NewText(fmt.Sprintf("It was requested by %s.", a.Requester)), nil)
+	}
+	u.dom.RemoveChildren(u.confirmReason)
+	u.dom.AppendChild(u.confirmReason, u.dom.NewText(a.Reason), nil)
+
+	respond := func(allow bool) {
+		u.confirm.Respond(a.Request, allow, func(err error) {
+			if err != nil && err != keys.ErrConfirmNotPending {
+				u.setError(fmt.Errorf("failed to respond to signature request using %q: %v", a.Name, err))
+			}
+			u.Next()
+		})
+	}
+	u.show(u.confirmPrompt, u.confirmAllow, func() {
+		respond(true)
+	}, func() {
+		respond(false)
+	})
+}
@@ -0,0 +1,376 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promptui
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/dom"
+	dt "github.com/google/chrome-ssh-agent/go/dom/testing"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/totp"
+	"github.com/kr/pretty"
+)
+
+var (
+	promptHTML = ""
+)
+
+func init() {
+	b, err := ioutil.ReadFile("../../html/prompt.html")
+	if err != nil {
+		panic(fmt.Sprintf("failed to read prompt html: %v", err))
+	}
+
+	promptHTML = string(b)
+}
+
+// fakeTOTPResponder is a TOTPResponder that records the codes entered, and
+// returns the supplied errors for them in turn.  A request remains pending
+// until a code is accepted, or the signature is refused.
+type fakeTOTPResponder struct {
+	challenges []*keys.TOTPChallenge
+	errs       []error
+	entered    []string
+}
+
+func (f *fakeTOTPResponder) Pending(callback func(challenges []*keys.TOTPChallenge, err error)) {
+	callback(f.challenges, nil)
+}
+
+func (f *fakeTOTPResponder) Respond(challenge int, code string, callback func(err error)) {
+	f.entered = append(f.entered, fmt.Sprintf("%d:%s", challenge, code))
+	var err error
+	if len(f.errs) > 0 {
+		err, f.errs = f.errs[0], f.errs[1:]
+	}
+	if err != totp.ErrIncorrectCode && err != totp.ErrInvalidCode {
+		var remaining []*keys.TOTPChallenge
+		for _, c := range f.challenges {
+			if c.Challenge != challenge {
+				remaining = append(remaining, c)
+			}
+		}
+		f.challenges = remaining
+	}
+	callback(err)
+}
+
+// fakeConfirmResponder is a ConfirmResponder that records the responses
+// made.
+type fakeConfirmResponder struct {
+	requests []*keys.ConfirmRequest
+	entered  []string
+}
+
+func (f *fakeConfirmResponder) Pending(callback func(requests []*keys.ConfirmRequest, err error)) {
+	callback(f.requests, nil)
+}
+
+func (f *fakeConfirmResponder) Respond(request int, allow bool, callback func(err error)) {
+	f.entered = append(f.entered, fmt.Sprintf("%d:%t", request, allow))
+	var remaining []*keys.ConfirmRequest
+	for _, r := range f.requests {
+		if r.Request != request {
+			remaining = append(remaining, r)
+		}
+	}
+	f.requests = remaining
+	callback(nil)
+}
+
+// fakePassphraseResponder is a PassphraseResponder that records the
+// passphrases entered.  Like a key being loaded, an incorrect passphrase is
+// asked for again until no attempts remain.
+type fakePassphraseResponder struct {
+	requests []*keys.PassphraseRequest
+	correct  string
+	next     int
+	entered  []string
+}
+
+func (f *fakePassphraseResponder) Pending(callback func(requests []*keys.PassphraseRequest, err error)) {
+	callback(f.requests, nil)
+}
+
+func (f *fakePassphraseResponder) Respond(request int, passphrase string, ok bool, callback func(err error)) {
+	f.entered = append(f.entered, fmt.Sprintf("%d:%s:%t", request, passphrase, ok))
+	r := f.requests[0]
+	f.requests = f.requests[1:]
+	if ok && passphrase != f.correct && r.Remaining > 1 {
+		f.next++
+		f.requests = append(f.requests, &keys.PassphraseRequest{
+			Request:   f.next,
+			ID:        r.ID,
+			Name:      r.Name,
+			Attempt:   r.Attempt + 1,
+			Remaining: r.Remaining - 1,
+			Incorrect: true,
+		})
+	}
+	callback(nil)
+}
+
+type testHarness struct {
+	totp        *fakeTOTPResponder
+	confirm     *fakeConfirmResponder
+	passphrases *fakePassphraseResponder
+	closed      bool
+	dom         *dom.DOM
+	UI          *UI
+}
+
+func newHarness(confirms []*keys.ConfirmRequest, challenges []*keys.TOTPChallenge, errs []error, requests []*keys.PassphraseRequest) *testHarness {
+	h := &testHarness{
+		totp:    &fakeTOTPResponder{challenges: challenges, errs: errs},
+		confirm: &fakeConfirmResponder{requests: confirms},
+		passphrases: &fakePassphraseResponder{
+			requests: requests,
+			correct:  "correct",
+			next:     len(requests),
+		},
+		dom: dom.New(dt.NewDocForTesting(promptHTML)),
+	}
+	h.UI = New(h.totp, h.confirm, h.passphrases, func() { h.closed = true }, h.dom)
+	h.UI.after = func(d time.Duration, f func()) { f() }
+
+	// In our test, DOMContentLoaded is not called automatically. Do it here.
+	h.dom.DoDOMContentLoaded()
+	return h
+}
+
+func TestPromptTOTP(t *testing.T) {
+	challenges := []*keys.TOTPChallenge{
+		{Challenge: 1, Name: "first-key"},
+		{Challenge: 2, Name: "second-key"},
+	}
+	testcases := []struct {
+		description string
+		codes       []string
+		errs        []error
+		wantEntered []string
+		wantStatus  []string
+		wantError   string
+		wantClosed  bool
+	}{
+		{
+			description: "confirm each signature",
+			codes:       []string{"111111", "222222"},
+			wantEntered: []string{"1:111111", "2:222222"},
+			wantStatus:  []string{"", ""},
+			wantClosed:  true,
+		},
+		{
+			description: "incorrect code",
+			codes:       []string{"123456", "111111", "222222"},
+			errs:        []error{totp.ErrIncorrectCode},
+			wantEntered: []string{"1:123456", "1:111111", "2:222222"},
+			wantStatus:  []string{"", "Code refused (incorrect code); try again", ""},
+			wantClosed:  true,
+		},
+		{
+			description: "cancel first signature",
+			codes:       []string{"", "222222"},
+			wantEntered: []string{"2:222222"},
+			wantStatus:  []string{"", ""},
+			wantClosed:  true,
+		},
+		{
+			description: "signature refused",
+			codes:       []string{"123456", "222222"},
+			errs:        []error{keys.ErrTOTPIncorrect},
+			wantEntered: []string{"1:123456", "2:222222"},
+			wantStatus:  []string{"", ""},
+			wantError:   `failed to confirm signature using "first-key": signature refused: incorrect confirmation code`,
+		},
+	}
+
+	for _, tc := range testcases {
+		h := newHarness(nil, challenges, tc.errs, nil)
+
+		// Enter each code as the prompt is displayed, pressing Enter
+		// to submit it.  An empty code cancels the prompt by pressing
+		// Escape.
+		var status []string
+		for _, code := range tc.codes {
+			status = append(status, h.dom.TextContent(h.UI.totpStatus))
+			if code == "" {
+				h.dom.DoKeyDown(h.UI.totpInput, "Escape")
+				continue
+			}
+			h.dom.SetValue(h.UI.totpInput, code)
+			h.dom.DoSubmit(h.UI.totpPrompt)
+		}
+
+		if diff := pretty.Diff(h.totp.entered, tc.wantEntered); diff != nil {
+			t.Errorf("%s: incorrect codes entered; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(status, tc.wantStatus); diff != nil {
+			t.Errorf("%s: incorrect status; -got +want: %s", tc.description, diff)
+		}
+		if got := h.dom.TextContent(h.UI.errorText); got != tc.wantError {
+			t.Errorf("%s: incorrect error; got %q, want %q", tc.description, got, tc.wantError)
+		}
+		if h.closed != tc.wantClosed {
+			t.Errorf("%s: incorrect closed; got %t, want %t", tc.description, h.closed, tc.wantClosed)
+		}
+	}
+}
+
+func TestPromptPassphrase(t *testing.T) {
+	requests := []*keys.PassphraseRequest{
+		{Request: 1, ID: keys.ID("1"), Name: "some-key", Attempt: 1, Remaining: 3},
+	}
+	testcases := []struct {
+		description string
+		passphrases []string
+		cancel      bool
+		wantEntered []string
+		wantStatus  []string
+	}{
+		{
+			description: "correct passphrase",
+			passphrases: []string{"correct"},
+			wantEntered: []string{"1:correct:true"},
+			wantStatus:  []string{""},
+		},
+		{
+			description: "incorrect passphrase",
+			passphrases: []string{"incorrect", "incorrect", "correct"},
+			wantEntered: []string{"1:incorrect:true", "2:incorrect:true", "3:correct:true"},
+			wantStatus: []string{
+				"",
+				"Incorrect passphrase; 2 attempts remaining",
+				"Incorrect passphrase; 1 attempt remaining",
+			},
+		},
+		{
+			description: "cancelled",
+			cancel:      true,
+			wantEntered: []string{"1::false"},
+			wantStatus:  []string{""},
+		},
+	}
+
+	for _, tc := range testcases {
+		h := newHarness(nil, nil, nil, requests)
+
+		var status []string
+		if tc.cancel {
+			status = append(status, h.dom.TextContent(h.UI.passphraseStatus))
+			h.dom.DoClick(h.UI.passphraseCancel)
+		}
+		for _, p := range tc.passphrases {
+			status = append(status, h.dom.TextContent(h.UI.passphraseStatus))
+			h.dom.SetValue(h.UI.passphraseInput, p)
+			h.dom.DoSubmit(h.UI.passphrasePrompt)
+		}
+
+		if diff := pretty.Diff(h.passphrases.entered, tc.wantEntered); diff != nil {
+			t.Errorf("%s: incorrect passphrases entered; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(status, tc.wantStatus); diff != nil {
+			t.Errorf("%s: incorrect status; -got +want: %s", tc.description, diff)
+		}
+		if got := h.dom.Value(h.UI.passphraseInput); got != "" {
+			t.Errorf("%s: passphrase left in input; got %q", tc.description, got)
+		}
+		if !h.closed {
+			t.Errorf("%s: window not closed", tc.description)
+		}
+	}
+}
+
+func TestPromptConfirm(t *testing.T) {
+	requests := []*keys.ConfirmRequest{
+		{Request: 1, Name: "first-key", Reason: "The key is held by signer.example.com, which will compute the signature."},
+		{Request: 2, Name: "second-key", Reason: "The key was added with the confirm constraint, so each signature must be allowed.", Requester: "native-host (connection 3)"},
+	}
+	testcases := []struct {
+		description string
+		allow       []bool
+		wantEntered []string
+	}{
+		{
+			description: "allow each signature",
+			allow:       []bool{true, true},
+			wantEntered: []string{"1:true", "2:true"},
+		},
+		{
+			description: "deny first signature",
+			allow:       []bool{false, true},
+			wantEntered: []string{"1:false", "2:true"},
+		},
+	}
+
+	for _, tc := range testcases {
+		h := newHarness(requests, nil, nil, nil)
+
+		// Allow each signature by pressing Enter, and deny it by
+		// pressing Escape.
+		var names, requesters []string
+		for _, allow := range tc.allow {
+			names = append(names, h.dom.TextContent(h.UI.confirmName))
+			requesters = append(requesters, h.dom.TextContent(h.UI.confirmRequester))
+			if !allow {
+				h.dom.DoKeyDown(h.UI.confirmAllow, "Escape")
+				continue
+			}
+			h.dom.DoSubmit(h.UI.confirmPrompt)
+		}
+
+		if diff := pretty.Diff(h.confirm.entered, tc.wantEntered); diff != nil {
+			t.Errorf("%s: incorrect responses; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(names, []string{"first-key", "second-key"}); diff != nil {
+			t.Errorf("%s: incorrect keys displayed; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(requesters, []string{"", "It was requested by native-host (connection 3)."}); diff != nil {
+			t.Errorf("%s: incorrect requesters displayed; -got +want: %s", tc.description, diff)
+		}
+		if got, want := h.dom.TextContent(h.UI.confirmReason), requests[1].Reason; got != want {
+			t.Errorf("%s: incorrect reason; got %q, want %q", tc.description, got, want)
+		}
+		if !h.closed {
+			t.Errorf("%s: window not closed", tc.description)
+		}
+	}
+}
+
+func TestPromptOrder(t *testing.T) {
+	// Signatures waiting to be allowed are shown first, then those
+	// waiting for a code, before keys are loaded.
+	h := newHarness([]*keys.ConfirmRequest{{Request: 1, Name: "remote-key"}}, []*keys.TOTPChallenge{{Challenge: 1, Name: "totp-key"}}, nil, []*keys.PassphraseRequest{
+		{Request: 1, Name: "encrypted-key", Attempt: 1, Remaining: 3},
+	})
+	if h.UI.confirmPrompt.Get("hidden").Bool() || !h.UI.totpPrompt.Get("hidden").Bool() {
+		t.Errorf("signature not confirmed first")
+	}
+	h.dom.DoSubmit(h.UI.confirmPrompt)
+	if h.UI.totpPrompt.Get("hidden").Bool() || !h.UI.passphrasePrompt.Get("hidden").Bool() {
+		t.Errorf("confirmation code not requested first")
+	}
+	h.dom.SetValue(h.UI.totpInput, "123456")
+	h.dom.DoSubmit(h.UI.totpPrompt)
+	if !h.UI.totpPrompt.Get("hidden").Bool() || h.UI.passphrasePrompt.Get("hidden").Bool() {
+		t.Errorf("passphrase not requested after confirmation code")
+	}
+	if h.closed {
+		t.Errorf("window closed while a key is waiting for a passphrase")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/chrome-ssh-agent/go/entropy"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/softtoken"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// ecPrivateKeyType is the PEM block type for an ECDSA private key.
+	ecPrivateKeyType = "EC PRIVATE KEY"
+
+	// KeyTypeEd25519 selects an Ed25519 key.
+	KeyTypeEd25519 = "ed25519"
+	// KeyTypeECDSA selects an ECDSA key.
+	KeyTypeECDSA = "ecdsa"
+	// KeyTypeRSA selects an RSA key.
+	KeyTypeRSA = "rsa"
+
+	// DefaultECDSABits is the curve size used for ECDSA keys if none is
+	// specified.
+	DefaultECDSABits = 256
+	// DefaultRSABits is the modulus size used for RSA keys if none is
+	// specified.
+	DefaultRSABits = 3072
+)
+
+// GenerateKey generates a new ECDSA P-256 key using randomness from p.  The
+// key is returned PEM-encoded, encrypted with passphrase unless it is empty,
+// along with a signer that may be used to sign with the key (e.g., to
+// produce an attestation) before it is discarded.
+func GenerateKey(p Provider, passphrase string) (pemPrivateKey string, signer ssh.Signer, err error) {
+	return Generate(p, KeyTypeECDSA, DefaultECDSABits, passphrase)
+}
+
+// Generate generates a new key of the specified type (one of the KeyType
+// constants) using randomness from p.  bits selects the curve size for
+// ECDSA keys (256, 384 or 521) and the modulus size for RSA keys (2048, 3072
+// or 4096); if zero, a default is used.  It must be zero for Ed25519 keys.
+// The key is encoded as by EncodePEM; the result is as for GenerateKey.
+func Generate(p Provider, keyType string, bits int, passphrase string) (pemPrivateKey string, signer ssh.Signer, err error) {
+	if bits == 0 {
+		bits = defaultBits[keyType]
+	}
+	if err := checkGenerate(keyType, bits); err != nil {
+		return "", nil, err
+	}
+	if err := entropy.Check(p.Rand()); err != nil {
+		return "", nil, help.Wrap(err, "failed to generate key")
+	}
+
+	var priv interface{}
+	switch keyType {
+	case KeyTypeEd25519:
+		_, priv, err = ed25519.GenerateKey(p.Rand())
+	case KeyTypeECDSA:
+		priv, err = ecdsa.GenerateKey(curves[bits], p.Rand())
+	case KeyTypeRSA:
+		priv, err = rsa.GenerateKey(p.Rand(), bits)
+	}
+	if err != nil {
+		return "", nil, help.Wrap(err, "failed to generate key")
+	}
+
+	pemPrivateKey, err = EncodePEM(priv, "", passphrase, p.Rand())
+	if err != nil {
+		return "", nil, err
+	}
+	signer, err = ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create signer: %v", err)
+	}
+	return pemPrivateKey, signer, nil
+}
+
+// EncodePEM returns priv PEM-encoded in a form the software provider can
+// parse, encrypted with passphrase unless it is empty.  RSA and ECDSA keys
+// are encoded as PKCS#1 and SEC 1 keys respectively.  Ed25519 keys are
+// encoded in OpenSSH's format, recording comment.  Keys are encrypted using
+// the legacy PEM encryption (RFC 1421) with AES-256; OpenSSH itself does not
+// accept Ed25519 keys encrypted this way, but they are only stored, and are
+// re-encoded when exported.  r is the source of randomness for the IV.
+func EncodePEM(priv interface{}, comment, passphrase string, r io.Reader) (string, error) {
+	var block *pem.Block
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: rsaPrivateKeyType, Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode key: %v", err)
+		}
+		block = &pem.Block{Type: ecPrivateKeyType, Bytes: der}
+	case *ed25519.PrivateKey:
+		return EncodePEM(*k, comment, passphrase, r)
+	case ed25519.PrivateKey:
+		encoded, err := softtoken.MarshalPEM(k, comment)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode key: %v", err)
+		}
+		if block, _ = pem.Decode([]byte(encoded)); block == nil {
+			return "", errors.New("failed to encode key")
+		}
+	default:
+		return "", fmt.Errorf("unsupported private key type %T", priv)
+	}
+
+	if passphrase != "" {
+		var err error
+		block, err = x509.EncryptPEMBlock(r, block.Type, block.Bytes, []byte(passphrase), x509.PEMCipherAES256)
+		if err != nil {
+			return "", help.Wrap(err, "failed to encrypt key")
+		}
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+// defaultBits are the sizes used for each key type if none is specified.
+var defaultBits = map[string]int{
+	KeyTypeECDSA: DefaultECDSABits,
+	KeyTypeRSA:   DefaultRSABits,
+}
+
+// curves are the curves that may be used for ECDSA keys, indexed by size.
+var curves = map[int]elliptic.Curve{
+	256: elliptic.P256(),
+	384: elliptic.P384(),
+	521: elliptic.P521(),
+}
+
+// rsaSizes are the modulus sizes that may be used for RSA keys.
+var rsaSizes = map[int]bool{
+	2048: true,
+	3072: true,
+	4096: true,
+}
+
+// checkGenerate checks that a key of the specified type and size may be
+// generated.
+func checkGenerate(keyType string, bits int) error {
+	switch keyType {
+	case KeyTypeEd25519:
+		if bits != 0 {
+			return fmt.Errorf("invalid size for Ed25519 key: %d", bits)
+		}
+	case KeyTypeECDSA:
+		if curves[bits] == nil {
+			return fmt.Errorf("invalid size for ECDSA key: %d; must be 256, 384 or 521", bits)
+		}
+	case KeyTypeRSA:
+		if !rsaSizes[bits] {
+			return fmt.Errorf("invalid size for RSA key: %d; must be 2048, 3072 or 4096", bits)
+		}
+	default:
+		return fmt.Errorf("unsupported key type: %q", keyType)
+	}
+	return nil
+}
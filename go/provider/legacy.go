@@ -0,0 +1,143 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+	"golang.org/x/crypto/ssh"
+)
+
+// LegacyCiphers are the ciphers, as named in the DEK-Info header, that may be
+// used to encrypt private keys in the legacy PEM format (i.e., those with a
+// 'Proc-Type: 4,ENCRYPTED' header; see RFC 1421).  Newer formats (OpenSSH and
+// PKCS#8) name their ciphers differently, and are not affected.
+var LegacyCiphers = []string{
+	"AES-128-CBC",
+	"AES-192-CBC",
+	"AES-256-CBC",
+	"DES-EDE3-CBC",
+	"DES-CBC",
+}
+
+// convertCommand converts a private key to the OpenSSH format, re-encrypting
+// it with a cipher that is supported.
+const convertCommand = "ssh-keygen -p -f <file>"
+
+// ErrUnsupportedCipher is returned for a private key in the legacy PEM format
+// that is encrypted with a cipher that is not one of LegacyCiphers.
+type ErrUnsupportedCipher struct {
+	// Cipher is the cipher named in the DEK-Info header, or empty if the
+	// header is missing.
+	Cipher string
+}
+
+// Error implements the error interface.
+func (e *ErrUnsupportedCipher) Error() string {
+	if e.Cipher == "" {
+		return fmt.Sprintf("private key is encrypted, but does not name its cipher (the DEK-Info header is missing); convert it with '%s'", convertCommand)
+	}
+	return fmt.Sprintf("private key is encrypted with unsupported cipher %s (supported: %s); convert it with '%s'", e.Cipher, strings.Join(LegacyCiphers, ", "), convertCommand)
+}
+
+// HelpCode implements help.Coder.
+func (e *ErrUnsupportedCipher) HelpCode() help.Code {
+	return help.InvalidPrivateKey
+}
+
+// legacyEncrypted determines if a PEM block is a private key in the legacy
+// PEM format that is encrypted: the Proc-Type header contains 'ENCRYPTED'
+// (see RFC 1421 Section 4.6.1.1), or a DEK-Info header names a cipher.
+func legacyEncrypted(block *pem.Block) bool {
+	_, dek := block.Headers["DEK-Info"]
+	return dek || strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED")
+}
+
+// legacyCipher returns the cipher named in the DEK-Info header of a PEM
+// block, or the empty string if there is none.
+func legacyCipher(block *pem.Block) string {
+	dek := block.Headers["DEK-Info"]
+	if i := strings.Index(dek, ","); i >= 0 {
+		dek = dek[:i]
+	}
+	return strings.ToUpper(strings.TrimSpace(dek))
+}
+
+// checkLegacyCipher returns *ErrUnsupportedCipher if block is an encrypted
+// private key in the legacy PEM format whose cipher is not supported.
+func checkLegacyCipher(block *pem.Block) error {
+	if !legacyEncrypted(block) {
+		return nil
+	}
+	c := legacyCipher(block)
+	for _, supported := range LegacyCiphers {
+		if c == supported {
+			return nil
+		}
+	}
+	return &ErrUnsupportedCipher{Cipher: c}
+}
+
+// CheckCipher returns *ErrUnsupportedCipher if the PEM-encoded private key is
+// in the legacy PEM format and encrypted with a cipher that is not
+// supported, so that it can be refused before it is stored rather than when
+// it is first loaded.  Other keys, including those that cannot be decoded,
+// are not checked.
+func CheckCipher(pemPrivateKey string) error {
+	block, _ := pem.Decode([]byte(pemPrivateKey))
+	if block == nil {
+		return nil
+	}
+	return checkLegacyCipher(block)
+}
+
+// decryptLegacy decrypts an encrypted private key in the legacy PEM format,
+// returning the DER-encoded key.  x509.IncorrectPasswordError is returned
+// if the passphrase is incorrect.
+func decryptLegacy(block *pem.Block, passphrase []byte) ([]byte, error) {
+	if err := checkLegacyCipher(block); err != nil {
+		return nil, err
+	}
+	der, err := x509.DecryptPEMBlock(block, passphrase)
+	if err == x509.IncorrectPasswordError {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key: %v", err)
+	}
+	return der, nil
+}
+
+// parseLegacy parses an encrypted private key in the legacy PEM format
+// other than an RSA key (i.e., an ECDSA or DSA key).
+//
+// The padding checked when decrypting detects most, but not all, incorrect
+// passphrases; a key that decrypts but then cannot be parsed was almost
+// certainly decrypted with the wrong passphrase, so
+// x509.IncorrectPasswordError is returned.
+func parseLegacy(block *pem.Block, passphrase []byte) (interface{}, error) {
+	der, err := decryptLegacy(block, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ssh.ParseRawPrivateKey(pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}))
+	if err != nil {
+		return nil, x509.IncorrectPasswordError
+	}
+	return priv, nil
+}
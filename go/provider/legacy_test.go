@@ -0,0 +1,184 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+// legacyKey is an unencrypted private key in the legacy PEM format.
+type legacyKey struct {
+	description string
+	block       *pem.Block
+	blob        string
+}
+
+// legacyKeys returns unencrypted RSA and ECDSA private keys in the legacy PEM
+// format.
+func legacyKeys(t *testing.T) []*legacyKey {
+	rsaBlock, _ := pem.Decode([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if rsaBlock == nil {
+		t.Fatalf("failed to decode RSA key")
+	}
+
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), NewDeterministicRand("ecdsa"))
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(ec)
+	if err != nil {
+		t.Fatalf("failed to marshal ECDSA key: %v", err)
+	}
+	ecPub, err := ssh.NewPublicKey(&ec.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to get ECDSA public key: %v", err)
+	}
+
+	return []*legacyKey{
+		{
+			description: "RSA",
+			block:       rsaBlock,
+			blob:        testdata.ValidPrivateKeyWithoutPassphraseBlob,
+		},
+		{
+			description: "ECDSA",
+			block:       &pem.Block{Type: ecPrivateKeyType, Bytes: der},
+			blob:        base64.StdEncoding.EncodeToString(ecPub.Marshal()),
+		},
+	}
+}
+
+func TestLegacyCiphers(t *testing.T) {
+	ciphers := map[string]x509.PEMCipher{
+		"DES-CBC":      x509.PEMCipherDES,
+		"DES-EDE3-CBC": x509.PEMCipher3DES,
+		"AES-128-CBC":  x509.PEMCipherAES128,
+		"AES-192-CBC":  x509.PEMCipherAES192,
+		"AES-256-CBC":  x509.PEMCipherAES256,
+	}
+	if len(ciphers) != len(LegacyCiphers) {
+		t.Errorf("incorrect number of legacy ciphers; got %d, want %d", len(LegacyCiphers), len(ciphers))
+	}
+
+	p := NewSoftware(nil)
+	for _, k := range legacyKeys(t) {
+		for _, name := range LegacyCiphers {
+			description := k.description + " " + name
+			encrypted, err := x509.EncryptPEMBlock(NewDeterministicRand(description), k.block.Type, k.block.Bytes, []byte("secret"), ciphers[name])
+			if err != nil {
+				t.Fatalf("%s: failed to encrypt key: %v", description, err)
+			}
+			if got := encrypted.Headers["DEK-Info"]; !strings.HasPrefix(got, name+",") {
+				t.Errorf("%s: incorrect DEK-Info header %q", description, got)
+			}
+			pemBytes := pem.EncodeToMemory(encrypted)
+
+			if err := CheckCipher(string(pemBytes)); err != nil {
+				t.Errorf("%s: supported cipher refused: %v", description, err)
+			}
+			if _, err := p.ParsePrivateKey(pemBytes, []byte("wrong")); err != x509.IncorrectPasswordError {
+				t.Errorf("%s: incorrect error for wrong passphrase; got %v, want %v", description, err, x509.IncorrectPasswordError)
+			}
+			priv, err := p.ParsePrivateKey(pemBytes, []byte("secret"))
+			if err != nil {
+				t.Errorf("%s: failed to parse key: %v", description, err)
+				continue
+			}
+			signer, err := ssh.NewSignerFromKey(priv)
+			if err != nil {
+				t.Errorf("%s: failed to create signer: %v", description, err)
+				continue
+			}
+			if diff := pretty.Diff(base64.StdEncoding.EncodeToString(signer.PublicKey().Marshal()), k.blob); diff != nil {
+				t.Errorf("%s: incorrect key; -got +want: %s", description, diff)
+			}
+		}
+	}
+}
+
+func TestUnsupportedLegacyCipher(t *testing.T) {
+	testcases := []struct {
+		description string
+		headers     map[string]string
+		wantErr     error
+	}{
+		{
+			description: "unsupported cipher",
+			headers: map[string]string{
+				"Proc-Type": "4,ENCRYPTED",
+				"DEK-Info":  "BF-CBC,0102030405060708",
+			},
+			wantErr: &ErrUnsupportedCipher{Cipher: "BF-CBC"},
+		},
+		{
+			description: "lower case cipher name",
+			headers: map[string]string{
+				"Proc-Type": "4,ENCRYPTED",
+				"DEK-Info":  "camellia-256-cbc,0102030405060708",
+			},
+			wantErr: &ErrUnsupportedCipher{Cipher: "CAMELLIA-256-CBC"},
+		},
+		{
+			description: "missing DEK-Info header",
+			headers: map[string]string{
+				"Proc-Type": "4,ENCRYPTED",
+			},
+			wantErr: &ErrUnsupportedCipher{},
+		},
+	}
+
+	p := NewSoftware(nil)
+	for _, k := range legacyKeys(t) {
+		for _, tc := range testcases {
+			description := k.description + " " + tc.description
+			pemBytes := pem.EncodeToMemory(&pem.Block{Type: k.block.Type, Headers: tc.headers, Bytes: k.block.Bytes})
+
+			if diff := pretty.Diff(CheckCipher(string(pemBytes)), tc.wantErr); diff != nil {
+				t.Errorf("%s: incorrect error from CheckCipher; -got +want: %s", description, diff)
+			}
+			_, err := p.ParsePrivateKey(pemBytes, []byte("secret"))
+			if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+				t.Errorf("%s: incorrect error from ParsePrivateKey; -got +want: %s", description, diff)
+			}
+		}
+	}
+
+	err := &ErrUnsupportedCipher{Cipher: "BF-CBC"}
+	if want := "ssh-keygen -p -f <file>"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not suggest %q", err.Error(), want)
+	}
+}
+
+func TestCheckCipherIgnoresOtherKeys(t *testing.T) {
+	for _, key := range []string{
+		testdata.ValidPrivateKey,
+		testdata.ValidPrivateKeyWithoutPassphrase,
+		"bogus-key-data",
+	} {
+		if err := CheckCipher(key); err != nil {
+			t.Errorf("unexpected error for key: %v", err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package provider abstracts the cryptographic primitives used to manage
+// keys, so that alternate implementations (e.g., hardware-backed keys) can be
+// supplied per key, and so that tests can use deterministic randomness.
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	"github.com/google/chrome-ssh-agent/go/entropy"
+	"golang.org/x/crypto/ssh"
+)
+
+// Provider supplies randomness and decodes stored private keys.
+type Provider interface {
+	// Name uniquely identifies the provider.  It is stored alongside
+	// each key so that the same provider is used when the key is loaded.
+	Name() string
+
+	// Rand returns the source of randomness.
+	Rand() io.Reader
+
+	// ParsePrivateKey decodes a stored private key, using passphrase to
+	// decrypt it if it is encrypted.  The result is a private key that
+	// may be added to an agent (e.g., a crypto.Signer).
+	ParsePrivateKey(pemBytes, passphrase []byte) (interface{}, error)
+}
+
+const (
+	// SoftwareName is the name of the software provider.
+	SoftwareName = "software"
+)
+
+// software is a Provider implemented entirely in software.
+type software struct {
+	rand io.Reader
+}
+
+// NewSoftware returns a Provider implemented entirely in software, which
+// obtains randomness from r.  If r is nil, entropy.Reader is used.
+func NewSoftware(r io.Reader) Provider {
+	if r == nil {
+		r = entropy.Reader
+	}
+	return &software{rand: r}
+}
+
+// Name implements Provider.Name.
+func (s *software) Name() string {
+	return SoftwareName
+}
+
+// Rand implements Provider.Rand.
+func (s *software) Rand() io.Reader {
+	return s.rand
+}
+
+// ParsePrivateKey implements Provider.ParsePrivateKey.
+func (s *software) ParsePrivateKey(pemBytes, passphrase []byte) (interface{}, error) {
+	// The passphrase is ignored if the key is not encrypted.
+	if block, _ := pem.Decode(pemBytes); block != nil {
+		switch {
+		case block.Type == rsaPrivateKeyType:
+			return parsePKCS1(block, passphrase)
+		case legacyEncrypted(block) && (block.Type == ecPrivateKeyType || block.Type == dsaPrivateKeyType):
+			return parseLegacy(block, passphrase)
+		}
+	}
+	return ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+}
+
+// Registry contains the available providers.
+type Registry struct {
+	providers map[string]Provider
+	def       Provider
+}
+
+// NewRegistry returns a Registry containing only the default provider, which
+// is used for keys that do not specify a provider.
+func NewRegistry(def Provider) *Registry {
+	r := &Registry{
+		providers: make(map[string]Provider),
+		def:       def,
+	}
+	r.Register(def)
+	return r
+}
+
+// Register makes a provider available.  Any existing provider with the same
+// name is replaced.
+func (r *Registry) Register(p Provider) {
+	r.providers[p.Name()] = p
+}
+
+// Default returns the default provider.
+func (r *Registry) Default() Provider {
+	return r.def
+}
+
+// Lookup returns the provider with the specified name.  The empty name
+// refers to the default provider.
+func (r *Registry) Lookup(name string) (Provider, error) {
+	if name == "" {
+		return r.def, nil
+	}
+	p, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+	return p, nil
+}
+
+// deterministicReader is an io.Reader returning a deterministic stream of
+// bytes derived from a seed.
+type deterministicReader struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+// NewDeterministicRand returns a source of randomness that always returns
+// the same stream of bytes for the same seed.  It must only be used in tests.
+func NewDeterministicRand(seed string) io.Reader {
+	return &deterministicReader{seed: []byte(seed)}
+}
+
+// Read implements io.Reader.
+func (d *deterministicReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(d.buf) == 0 {
+			var c [8]byte
+			binary.BigEndian.PutUint64(c[:], d.counter)
+			d.counter++
+			h := sha256.Sum256(append(append([]byte(nil), d.seed...), c[:]...))
+			d.buf = h[:]
+		}
+		copied := copy(p[n:], d.buf)
+		d.buf = d.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
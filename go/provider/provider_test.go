@@ -0,0 +1,262 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/entropy"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDeterministicRand(t *testing.T) {
+	read := func(seed string, n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(NewDeterministicRand(seed), b); err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		return b
+	}
+
+	if !bytes.Equal(read("seed", 100), read("seed", 100)) {
+		t.Errorf("same seed produced different output")
+	}
+	if bytes.Equal(read("seed", 100), read("other", 100)) {
+		t.Errorf("different seeds produced same output")
+	}
+	if !bytes.Equal(read("seed", 10), read("seed", 100)[:10]) {
+		t.Errorf("output depends on read size")
+	}
+}
+
+func TestSoftwareParsePrivateKey(t *testing.T) {
+	testcases := []struct {
+		description string
+		pem         string
+		passphrase  string
+		wantBlob    string
+		wantErr     error
+	}{
+		{
+			description: "encrypted key",
+			pem:         testdata.ValidPrivateKey,
+			passphrase:  testdata.ValidPrivateKeyPassphrase,
+			wantBlob:    testdata.ValidPrivateKeyBlob,
+		},
+		{
+			description: "unencrypted key",
+			pem:         testdata.ValidPrivateKeyWithoutPassphrase,
+			wantBlob:    testdata.ValidPrivateKeyWithoutPassphraseBlob,
+		},
+		{
+			description: "invalid key",
+			pem:         "bogus-key-data",
+			wantErr:     errors.New("ssh: no key found"),
+		},
+	}
+
+	p := NewSoftware(nil)
+	for _, tc := range testcases {
+		priv, err := p.ParsePrivateKey([]byte(tc.pem), []byte(tc.passphrase))
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Errorf("%s: failed to create signer: %v", tc.description, err)
+			continue
+		}
+		blob := base64.StdEncoding.EncodeToString(signer.PublicKey().Marshal())
+		if diff := pretty.Diff(blob, tc.wantBlob); diff != nil {
+			t.Errorf("%s: incorrect key; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	testcases := []struct {
+		description string
+		passphrase  string
+	}{
+		{description: "unencrypted key"},
+		{description: "encrypted key", passphrase: "secret"},
+	}
+
+	for _, tc := range testcases {
+		p := NewSoftware(NewDeterministicRand("seed"))
+		pemKey, signer, err := GenerateKey(p, tc.passphrase)
+		if err != nil {
+			t.Fatalf("%s: failed to generate key: %v", tc.description, err)
+		}
+
+		if tc.passphrase != "" {
+			if _, err := ssh.ParseRawPrivateKey([]byte(pemKey)); err == nil {
+				t.Errorf("%s: key parsed without passphrase", tc.description)
+			}
+		}
+		priv, err := p.ParsePrivateKey([]byte(pemKey), []byte(tc.passphrase))
+		if err != nil {
+			t.Fatalf("%s: failed to parse generated key: %v", tc.description, err)
+		}
+		parsed, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatalf("%s: failed to create signer: %v", tc.description, err)
+		}
+		if !bytes.Equal(parsed.PublicKey().Marshal(), signer.PublicKey().Marshal()) {
+			t.Errorf("%s: parsed key does not match signer", tc.description)
+		}
+		if got := signer.PublicKey().Type(); got != ssh.KeyAlgoECDSA256 {
+			t.Errorf("%s: incorrect key type: got %s, want %s", tc.description, got, ssh.KeyAlgoECDSA256)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	testcases := []struct {
+		description string
+		keyType     string
+		bits        int
+		passphrase  string
+		wantType    string
+		wantErr     bool
+	}{
+		{
+			description: "Ed25519 key",
+			keyType:     KeyTypeEd25519,
+			wantType:    ssh.KeyAlgoED25519,
+		},
+		{
+			description: "encrypted Ed25519 key",
+			keyType:     KeyTypeEd25519,
+			passphrase:  "secret",
+			wantType:    ssh.KeyAlgoED25519,
+		},
+		{
+			description: "Ed25519 key with size",
+			keyType:     KeyTypeEd25519,
+			bits:        256,
+			wantErr:     true,
+		},
+		{
+			description: "default ECDSA key",
+			keyType:     KeyTypeECDSA,
+			wantType:    ssh.KeyAlgoECDSA256,
+		},
+		{
+			description: "encrypted ECDSA P-384 key",
+			keyType:     KeyTypeECDSA,
+			bits:        384,
+			passphrase:  "secret",
+			wantType:    ssh.KeyAlgoECDSA384,
+		},
+		{
+			description: "ECDSA key with invalid size",
+			keyType:     KeyTypeECDSA,
+			bits:        512,
+			wantErr:     true,
+		},
+		{
+			description: "encrypted RSA key",
+			keyType:     KeyTypeRSA,
+			bits:        2048,
+			passphrase:  "secret",
+			wantType:    ssh.KeyAlgoRSA,
+		},
+		{
+			description: "RSA key with invalid size",
+			keyType:     KeyTypeRSA,
+			bits:        1024,
+			wantErr:     true,
+		},
+		{
+			description: "unsupported key type",
+			keyType:     "dsa",
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		p := NewSoftware(NewDeterministicRand("seed"))
+		pemKey, signer, err := Generate(p, tc.keyType, tc.bits, tc.passphrase)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: generated key; want error", tc.description)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to generate key: %v", tc.description, err)
+		}
+
+		priv, err := p.ParsePrivateKey([]byte(pemKey), []byte(tc.passphrase))
+		if err != nil {
+			t.Fatalf("%s: failed to parse generated key: %v", tc.description, err)
+		}
+		parsed, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatalf("%s: failed to create signer: %v", tc.description, err)
+		}
+		if !bytes.Equal(parsed.PublicKey().Marshal(), signer.PublicKey().Marshal()) {
+			t.Errorf("%s: parsed key does not match signer", tc.description)
+		}
+		if got := signer.PublicKey().Type(); got != tc.wantType {
+			t.Errorf("%s: incorrect key type: got %s, want %s", tc.description, got, tc.wantType)
+		}
+	}
+}
+
+// brokenRand is a source of randomness that only returns zeros.
+type brokenRand struct{}
+
+func (b brokenRand) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestGenerateKeyBrokenRand(t *testing.T) {
+	p := NewSoftware(entropy.New(brokenRand{}, entropy.DefaultTimeout))
+	_, _, err := GenerateKey(p, "")
+	if diff := pretty.Diff(help.CodeOf(err), help.EntropyUnavailable); diff != nil {
+		t.Errorf("incorrect help code; -got +want: %s", diff)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	def := NewSoftware(nil)
+	r := NewRegistry(def)
+
+	if p, err := r.Lookup(""); err != nil || p != def {
+		t.Errorf("empty name: got (%v, %v), want default provider", p, err)
+	}
+	if p, err := r.Lookup(SoftwareName); err != nil || p != def {
+		t.Errorf("software: got (%v, %v), want default provider", p, err)
+	}
+	_, err := r.Lookup("bogus")
+	if diff := pretty.Diff(err, errors.New(`unknown provider "bogus"`)); diff != nil {
+		t.Errorf("unknown provider: incorrect error; -got +want: %s", diff)
+	}
+}
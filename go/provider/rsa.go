@@ -0,0 +1,190 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+const (
+	// rsaPrivateKeyType is the PEM block type of a PKCS#1 RSA private key.
+	rsaPrivateKeyType = "RSA PRIVATE KEY"
+	// dsaPrivateKeyType is the PEM block type of a DSA private key in the
+	// format used by OpenSSL.
+	dsaPrivateKeyType = "DSA PRIVATE KEY"
+	// primeRounds is the number of Miller-Rabin rounds used to check
+	// that the factors of an RSA modulus are prime.
+	primeRounds = 20
+)
+
+// ErrInconsistentRSAKey is returned when the parameters of an RSA private key
+// are inconsistent, and cannot be repaired (e.g., the primes do not multiply
+// to the modulus).
+type ErrInconsistentRSAKey struct {
+	// Reason describes the inconsistency.
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *ErrInconsistentRSAKey) Error() string {
+	return fmt.Sprintf("RSA private key is inconsistent and cannot be repaired: %s", e.Reason)
+}
+
+// HelpCode implements help.Coder.
+func (e *ErrInconsistentRSAKey) HelpCode() help.Code {
+	return help.InvalidPrivateKey
+}
+
+// pkcs1PrivateKey is the ASN.1 structure of a PKCS#1 RSA private key.  See
+// RFC 8017 Appendix A.1.2.
+type pkcs1PrivateKey struct {
+	Version int
+	N       *big.Int
+	E       int
+	D       *big.Int
+	P       *big.Int
+	Q       *big.Int
+	Dp      *big.Int
+	Dq      *big.Int
+	Qinv    *big.Int
+	// Additional primes are not validated; keys with more than two
+	// primes are left to crypto/x509.
+	AdditionalPrimes []asn1.RawValue `asn1:"optional,omitempty"`
+}
+
+// RepairPKCS1 checks that the private exponent and CRT parameters of a
+// DER-encoded PKCS#1 RSA private key are consistent with its primes, and
+// recomputes any that are not.  The returned key is re-encoded if it was
+// repaired, in which case repaired is true.  *ErrInconsistentRSAKey is
+// returned if the key cannot be repaired.
+//
+// crypto/x509 recomputes the CRT parameters when parsing, but rejects a key
+// whose private exponent is inconsistent; other implementations (e.g.,
+// OpenSSH) use the stored CRT parameters, and so produce invalid signatures
+// with keys that are inconsistent.
+func RepairPKCS1(der []byte) (result []byte, repaired bool, err error) {
+	var k pkcs1PrivateKey
+	rest, err := asn1.Unmarshal(der, &k)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse RSA private key: %v", err)
+	}
+	if len(rest) > 0 {
+		return nil, false, fmt.Errorf("failed to parse RSA private key: trailing data")
+	}
+	if len(k.AdditionalPrimes) > 0 {
+		return der, false, nil
+	}
+	if k.N == nil || k.D == nil || k.P == nil || k.Q == nil || k.N.Sign() <= 0 || k.P.Sign() <= 0 || k.Q.Sign() <= 0 {
+		return nil, false, &ErrInconsistentRSAKey{Reason: "missing parameters"}
+	}
+	if k.E < 3 || k.E%2 == 0 {
+		return nil, false, &ErrInconsistentRSAKey{Reason: "invalid public exponent"}
+	}
+	if new(big.Int).Mul(k.P, k.Q).Cmp(k.N) != 0 {
+		return nil, false, &ErrInconsistentRSAKey{Reason: "primes do not match modulus"}
+	}
+	if !k.P.ProbablyPrime(primeRounds) || !k.Q.ProbablyPrime(primeRounds) {
+		return nil, false, &ErrInconsistentRSAKey{Reason: "factors of modulus are not prime"}
+	}
+
+	one := big.NewInt(1)
+	e := big.NewInt(int64(k.E))
+	pMinus1 := new(big.Int).Sub(k.P, one)
+	qMinus1 := new(big.Int).Sub(k.Q, one)
+
+	// d must satisfy d*e = 1 mod (p-1) and mod (q-1).  If it does not,
+	// recompute it as e^-1 mod lcm(p-1, q-1).
+	consistent := func(d *big.Int) bool {
+		de := new(big.Int).Mul(d, e)
+		return new(big.Int).Mod(de, pMinus1).Cmp(one) == 0 && new(big.Int).Mod(de, qMinus1).Cmp(one) == 0
+	}
+	d := k.D
+	if !consistent(d) {
+		gcd := new(big.Int).GCD(nil, nil, pMinus1, qMinus1)
+		lcm := new(big.Int).Mul(pMinus1, qMinus1)
+		lcm.Div(lcm, gcd)
+		d = new(big.Int).ModInverse(e, lcm)
+		if d == nil {
+			return nil, false, &ErrInconsistentRSAKey{Reason: "public exponent is not invertible"}
+		}
+	}
+
+	priv := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: k.N, E: k.E},
+		D:         d,
+		Primes:    []*big.Int{k.P, k.Q},
+	}
+	if err := priv.Validate(); err != nil {
+		return nil, false, &ErrInconsistentRSAKey{Reason: err.Error()}
+	}
+	priv.Precompute()
+
+	if d == k.D && equal(k.Dp, priv.Precomputed.Dp) && equal(k.Dq, priv.Precomputed.Dq) && equal(k.Qinv, priv.Precomputed.Qinv) {
+		return der, false, nil
+	}
+	return x509.MarshalPKCS1PrivateKey(priv), true, nil
+}
+
+// equal determines if a and b are non-nil and equal.
+func equal(a, b *big.Int) bool {
+	return a != nil && b != nil && a.Cmp(b) == 0
+}
+
+// RepairRSA checks a PEM-encoded private key using RepairPKCS1, returning the
+// repaired key, PEM-encoded.  Keys that are not unencrypted PKCS#1 RSA
+// private keys are returned unchanged; they are checked when decrypted.
+func RepairRSA(pemPrivateKey string) (result string, repaired bool, err error) {
+	block, _ := pem.Decode([]byte(pemPrivateKey))
+	if block == nil || block.Type != rsaPrivateKeyType || legacyEncrypted(block) {
+		return pemPrivateKey, false, nil
+	}
+
+	der, repaired, err := RepairPKCS1(block.Bytes)
+	if err != nil || !repaired {
+		return pemPrivateKey, false, err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: rsaPrivateKeyType, Bytes: der})), true, nil
+}
+
+// parsePKCS1 parses a PEM-encoded PKCS#1 RSA private key, using passphrase
+// to decrypt it if it is encrypted.  Inconsistent parameters are repaired in
+// the parsed key.
+func parsePKCS1(block *pem.Block, passphrase []byte) (interface{}, error) {
+	der := block.Bytes
+	encrypted := legacyEncrypted(block)
+	if encrypted {
+		var err error
+		der, err = decryptLegacy(block, passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	der, _, err := RepairPKCS1(der)
+	if _, inconsistent := err.(*ErrInconsistentRSAKey); err != nil && encrypted && !inconsistent {
+		// See parseLegacy.
+		return nil, x509.IncorrectPasswordError
+	} else if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKCS1PrivateKey(der)
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"golang.org/x/crypto/ssh"
+)
+
+// modifiedPKCS1 returns the unencrypted test RSA key, PEM-encoded after
+// applying modify to its parameters.
+func modifiedPKCS1(t *testing.T, modify func(k *pkcs1PrivateKey)) string {
+	block, _ := pem.Decode([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	var k pkcs1PrivateKey
+	if _, err := asn1.Unmarshal(block.Bytes, &k); err != nil {
+		t.Fatalf("failed to parse test key: %v", err)
+	}
+	modify(&k)
+	der, err := asn1.Marshal(k)
+	if err != nil {
+		t.Fatalf("failed to encode test key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: rsaPrivateKeyType, Bytes: der}))
+}
+
+func increment(i *big.Int) *big.Int {
+	return new(big.Int).Add(i, big.NewInt(1))
+}
+
+func rsaPrivateKey(t *testing.T) *rsa.PrivateKey {
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		t.Fatalf("failed to parse test key: %v", err)
+	}
+	return priv.(*rsa.PrivateKey)
+}
+
+func TestRepairRSA(t *testing.T) {
+	ecdsaKey, _, err := GenerateKey(NewSoftware(NewDeterministicRand("seed")), "")
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	testcases := []struct {
+		description  string
+		pem          string
+		wantRepaired bool
+		wantErr      bool
+	}{
+		{
+			description: "consistent key",
+			pem:         testdata.ValidPrivateKeyWithoutPassphrase,
+		},
+		{
+			description: "not an RSA key",
+			pem:         ecdsaKey,
+		},
+		{
+			description: "encrypted key",
+			pem:         testdata.ValidPrivateKey,
+		},
+		{
+			description:  "incorrect CRT coefficient",
+			pem:          modifiedPKCS1(t, func(k *pkcs1PrivateKey) { k.Qinv = increment(k.Qinv) }),
+			wantRepaired: true,
+		},
+		{
+			description:  "incorrect CRT exponents",
+			pem:          modifiedPKCS1(t, func(k *pkcs1PrivateKey) { k.Dp, k.Dq = increment(k.Dp), increment(k.Dq) }),
+			wantRepaired: true,
+		},
+		{
+			description:  "incorrect private exponent",
+			pem:          modifiedPKCS1(t, func(k *pkcs1PrivateKey) { k.D = increment(k.D) }),
+			wantRepaired: true,
+		},
+		{
+			description: "primes do not match modulus",
+			pem:         modifiedPKCS1(t, func(k *pkcs1PrivateKey) { k.P = increment(increment(k.P)) }),
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		repairedPEM, repaired, err := RepairRSA(tc.pem)
+		if tc.wantErr {
+			if _, ok := err.(*ErrInconsistentRSAKey); !ok {
+				t.Errorf("%s: incorrect error; got %v, want *ErrInconsistentRSAKey", tc.description, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to repair key: %v", tc.description, err)
+			continue
+		}
+		if repaired != tc.wantRepaired {
+			t.Errorf("%s: incorrect repaired; got %t, want %t", tc.description, repaired, tc.wantRepaired)
+		}
+		if !repaired {
+			if repairedPEM != tc.pem {
+				t.Errorf("%s: key changed although not repaired", tc.description)
+			}
+			continue
+		}
+
+		// The stored CRT parameters of the repaired key must match
+		// those computed from its primes.
+		if again, _, err := RepairRSA(repairedPEM); err != nil || again != repairedPEM {
+			t.Errorf("%s: repaired key is still inconsistent: %v", tc.description, err)
+		}
+		priv, err := ssh.ParseRawPrivateKey([]byte(repairedPEM))
+		if err != nil {
+			t.Errorf("%s: failed to parse repaired key: %v", tc.description, err)
+			continue
+		}
+		if got := priv.(*rsa.PrivateKey).N.String(); got != rsaPrivateKey(t).N.String() {
+			t.Errorf("%s: repaired key has a different modulus", tc.description)
+		}
+	}
+}
+
+func TestParseRepairsEncryptedRSA(t *testing.T) {
+	inconsistent := modifiedPKCS1(t, func(k *pkcs1PrivateKey) { k.D = increment(k.D) })
+	block, _ := pem.Decode([]byte(inconsistent))
+	encrypted, err := x509.EncryptPEMBlock(NewDeterministicRand("encrypt"), rsaPrivateKeyType, block.Bytes, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatalf("failed to encrypt key: %v", err)
+	}
+	pemBytes := pem.EncodeToMemory(encrypted)
+
+	// The key cannot be parsed without repair.
+	if _, err := ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, []byte("secret")); err == nil {
+		t.Errorf("inconsistent key unexpectedly parsed without repair")
+	}
+
+	p := NewSoftware(nil)
+	if _, err := p.ParsePrivateKey(pemBytes, []byte("wrong")); err != x509.IncorrectPasswordError {
+		t.Errorf("incorrect error for wrong passphrase; got %v, want %v", err, x509.IncorrectPasswordError)
+	}
+	priv, err := p.ParsePrivateKey(pemBytes, []byte("secret"))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	sig, err := signer.Sign(rand.Reader, []byte("data"))
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if err := signer.PublicKey().Verify([]byte("data"), sig); err != nil {
+		t.Errorf("failed to verify signature: %v", err)
+	}
+}
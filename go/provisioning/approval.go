@@ -0,0 +1,141 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioning
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// approvalsKey is the key under which approvals entered by the user
+	// are stored.
+	approvalsKey = "provisioning.approvals"
+)
+
+// approval is the payload of an approval signed by an administrator.
+type approval struct {
+	// Fingerprint is the SHA256 fingerprint of the approved key.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// verifyApproval verifies the signature over a signed approval using pub,
+// and returns the fingerprint of the approved key.  A signed approval has
+// the same form as a signed manifest.
+func verifyApproval(data []byte, pub ed25519.PublicKey) (string, error) {
+	payload, err := verifySigned(data, pub, "approval")
+	if err != nil {
+		return "", err
+	}
+	var a approval
+	if err := json.Unmarshal(payload, &a); err != nil {
+		return "", fmt.Errorf("failed to parse approval: %v", err)
+	}
+	if !strings.HasPrefix(a.Fingerprint, "SHA256:") {
+		return "", fmt.Errorf("approval must name a SHA256 fingerprint; got %q", a.Fingerprint)
+	}
+	return a.Fingerprint, nil
+}
+
+// approvals returns the approvals entered by the user.  They are returned
+// as entered; each must be verified before it is trusted.
+func (p *Provisioner) approvals(callback func(approvals []string, err error)) {
+	p.store.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read approvals: %v", err))
+			return
+		}
+
+		raw, _ := data[approvalsKey].([]interface{})
+		var result []string
+		for _, r := range raw {
+			if a, ok := r.(string); ok {
+				result = append(result, a)
+			}
+		}
+		callback(result, nil)
+	})
+}
+
+// approved determines if the specified key has been approved, using the
+// approval key in the supplied manifest.  Approvals that cannot be verified
+// (e.g., because the administrator has since changed the approval key) are
+// ignored.
+func (p *Provisioner) approved(m *Manifest, pub ssh.PublicKey, callback func(approved bool, err error)) {
+	p.approvals(func(approvals []string, err error) {
+		if err != nil {
+			callback(false, err)
+			return
+		}
+		fp := ssh.FingerprintSHA256(pub)
+		for _, a := range approvals {
+			if got, err := verifyApproval([]byte(a), m.approvalKey); err == nil && got == fp {
+				callback(true, nil)
+				return
+			}
+		}
+		callback(false, nil)
+	})
+}
+
+// Approve verifies an approval signed by an administrator and stores it, so
+// that the key it names may be loaded.  Approvals are verified using the
+// approval key in the most recently applied manifest.  An approval replaces
+// any earlier approval for the same key.  callback is invoked with the
+// fingerprint of the approved key.
+func (p *Provisioner) Approve(data string, callback func(fingerprint string, err error)) {
+	p.applied(func(m *Manifest, err error) {
+		if err != nil {
+			callback("", err)
+			return
+		}
+		if m == nil || !m.RequiresApproval() {
+			callback("", errors.New("the provisioning policy does not require approvals"))
+			return
+		}
+		data = strings.TrimSpace(data)
+		fp, err := verifyApproval([]byte(data), m.approvalKey)
+		if err != nil {
+			callback("", err)
+			return
+		}
+
+		p.approvals(func(approvals []string, err error) {
+			if err != nil {
+				callback("", err)
+				return
+			}
+			updated := []string{data}
+			for _, a := range approvals {
+				if got, err := verifyApproval([]byte(a), m.approvalKey); err == nil && got == fp {
+					continue
+				}
+				updated = append(updated, a)
+			}
+			p.store.Set(map[string]interface{}{approvalsKey: updated}, func(err error) {
+				if err != nil {
+					callback("", fmt.Errorf("failed to write approvals: %v", err))
+					return
+				}
+				callback(fp, nil)
+			})
+		})
+	})
+}
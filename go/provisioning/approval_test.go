@@ -0,0 +1,169 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioning
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// signApproval returns a signed approval for the specified key.
+func signApproval(priv []byte, pub ssh.PublicKey) string {
+	return string(signManifest(priv, `{"fingerprint": "`+ssh.FingerprintSHA256(pub)+`"}`))
+}
+
+func TestVerifyApproval(t *testing.T) {
+	pub, priv := newSigningKey(3)
+	_, otherPriv := newSigningKey(4)
+	key := mustParsePublicKey(testdata.ValidPrivateKeyBlob)
+
+	testcases := []struct {
+		description     string
+		data            []byte
+		wantFingerprint string
+		wantErr         error
+	}{
+		{
+			description:     "valid approval",
+			data:            []byte(signApproval(priv, key)),
+			wantFingerprint: ssh.FingerprintSHA256(key),
+		},
+		{
+			description: "signed by another key",
+			data:        []byte(signApproval(otherPriv, key)),
+			wantErr:     errors.New("approval signature is invalid"),
+		},
+		{
+			description: "MD5 fingerprint",
+			data:        signManifest(priv, `{"fingerprint": "`+ssh.FingerprintLegacyMD5(key)+`"}`),
+			wantErr:     errors.New(`approval must name a SHA256 fingerprint; got "` + ssh.FingerprintLegacyMD5(key) + `"`),
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := verifyApproval(tc.data, pub)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if got != tc.wantFingerprint {
+			t.Errorf("%s: incorrect fingerprint: got %q, want %q", tc.description, got, tc.wantFingerprint)
+		}
+	}
+}
+
+func TestApprove(t *testing.T) {
+	pub, priv := newSigningKey(1)
+	approvalPub, approvalPriv := newSigningKey(3)
+	_, otherPriv := newSigningKey(4)
+	policy := &Policy{URL: testURL, PublicKey: base64.StdEncoding.EncodeToString(pub)}
+	payload := `{"serial": 1, "approvalKey": "` + base64.StdEncoding.EncodeToString(approvalPub) + `"}`
+	key := mustParsePublicKey(testdata.ValidPrivateKeyBlob)
+	other := mustParsePublicKey(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+
+	testcases := []struct {
+		description  string
+		manifest     string
+		approvals    []string
+		wantErr      error
+		wantAllowed  bool
+		wantApproval bool
+	}{
+		{
+			description: "approvals not required",
+			manifest:    `{"serial": 1}`,
+			approvals:   []string{signApproval(approvalPriv, key)},
+			wantErr:     errors.New("the provisioning policy does not require approvals"),
+			wantAllowed: true,
+		},
+		{
+			description:  "key not yet approved",
+			manifest:     payload,
+			wantApproval: true,
+		},
+		{
+			description: "key approved",
+			manifest:    payload,
+			approvals:   []string{signApproval(approvalPriv, key)},
+			wantAllowed: true,
+		},
+		{
+			description:  "other key approved",
+			manifest:     payload,
+			approvals:    []string{signApproval(approvalPriv, other)},
+			wantApproval: true,
+		},
+		{
+			description:  "approval signed by another key",
+			manifest:     payload,
+			approvals:    []string{signApproval(otherPriv, key)},
+			wantErr:      errors.New("approval signature is invalid"),
+			wantApproval: true,
+		},
+		{
+			description: "approval replaced",
+			manifest:    payload,
+			approvals:   []string{signApproval(approvalPriv, key), "  " + signApproval(approvalPriv, key) + "\n"},
+			wantAllowed: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := keys.NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		store := fakes.NewMemStorage()
+		p := New(store, &fakeFetcher{body: signManifest(priv, tc.manifest)}, nil)
+		p.Sync(mgr, policy, func(r *keys.Result, err error) {
+			if err != nil {
+				t.Fatalf("%s: failed to apply manifest: %v", tc.description, err)
+			}
+		})
+
+		var err error
+		for _, a := range tc.approvals {
+			p.Approve(a, func(fp string, e error) {
+				err = e
+			})
+		}
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+
+		p.Allowed(key, func(err error) {
+			if got := err == nil; got != tc.wantAllowed {
+				t.Errorf("%s: incorrect allowed: got %v, want %v (err %v)", tc.description, got, tc.wantAllowed, err)
+			}
+			if got := help.CodeOf(err) == help.ApprovalRequired; got != tc.wantApproval {
+				t.Errorf("%s: incorrect approval required: got %v, want %v (err %v)", tc.description, got, tc.wantApproval, err)
+			}
+		})
+
+		p.approvals(func(approvals []string, err error) {
+			if err != nil {
+				t.Errorf("%s: failed to read approvals: %v", tc.description, err)
+			}
+			if len(approvals) > 1 {
+				t.Errorf("%s: approval for the same key stored more than once: %v", tc.description, approvals)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package provisioning implements enterprise provisioning of keys.  An
+// administrator configures (via Chrome policy) an HTTPS endpoint that serves
+// a signed manifest, along with the public key used to verify it.  The
+// manifest lists the public keys and certificates that may be loaded, and
+// optionally private keys (typically encrypted) for service accounts, which
+// are added to the configured keys.
+package provisioning
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// signedManifest is the document served by the provisioning endpoint.
+type signedManifest struct {
+	// Payload is the base64-encoded manifest (see Manifest).
+	Payload string `json:"payload"`
+	// Signature is the base64-encoded Ed25519 signature over the decoded
+	// payload.
+	Signature string `json:"signature"`
+}
+
+// Entry is a private key to be provisioned.
+type Entry struct {
+	// Name is the name of the key.
+	Name string `json:"name"`
+	// PrivateKey is the PEM-encoded private key.  It is typically
+	// encrypted, with the passphrase distributed separately.
+	PrivateKey string `json:"privateKey"`
+}
+
+// Manifest describes the keys provisioned by an administrator.
+type Manifest struct {
+	// Serial increases each time the manifest is changed.  A manifest
+	// with a lower serial than one already applied is rejected, so that
+	// an older manifest cannot be replayed.
+	Serial int64 `json:"serial"`
+	// AllowedKeys lists the public keys and certificates (in
+	// authorized_keys format) that may be loaded.  If empty, any key may
+	// be loaded.
+	AllowedKeys []string `json:"allowedKeys"`
+	// Keys lists the private keys to be added to the configured keys.
+	Keys []*Entry `json:"keys"`
+	// ApprovalKey is the base64-encoded Ed25519 public key used to verify
+	// approvals.  If set, keys not listed in AllowedKeys may be loaded
+	// only once an administrator has approved them.
+	ApprovalKey string `json:"approvalKey,omitempty"`
+
+	// allowed contains the public keys parsed from AllowedKeys.  For
+	// certificates, the certified key is included.
+	allowed []ssh.PublicKey
+	// approvalKey is the key parsed from ApprovalKey, or nil if approvals
+	// are not required.
+	approvalKey ed25519.PublicKey
+}
+
+// ValidateURL validates that s is an HTTPS URL from which a manifest may be
+// fetched.
+func ValidateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid provisioning URL: %v", err)
+	}
+	if u.Scheme != "https" {
+		return errors.New("provisioning URL must use https")
+	}
+	if u.Host == "" {
+		return errors.New("provisioning URL must include a host")
+	}
+	return nil
+}
+
+// ParsePublicKey parses the base64-encoded Ed25519 public key used to verify
+// manifests.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode provisioning public key: %v", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("provisioning public key must be %d bytes; got %d", ed25519.PublicKeySize, len(b))
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// verifySigned verifies the signature over a signed document (a manifest or
+// an approval) using pub, and returns the decoded payload.  what describes
+// the document in errors.
+func verifySigned(data []byte, pub ed25519.PublicKey, what string) ([]byte, error) {
+	var sm signedManifest
+	if err := json.Unmarshal(data, &sm); err != nil {
+		return nil, fmt.Errorf("failed to parse signed %s: %v", what, err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(sm.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s payload: %v", what, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(sm.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s signature: %v", what, err)
+	}
+	if !ed25519.Verify(pub, payload, sig) {
+		return nil, fmt.Errorf("%s signature is invalid", what)
+	}
+	return payload, nil
+}
+
+// Verify verifies the signature over a signed manifest (as served by the
+// provisioning endpoint) using pub.  It returns the decoded payload and the
+// manifest it contains.
+func Verify(data []byte, pub ed25519.PublicKey) (payload []byte, m *Manifest, err error) {
+	payload, err = verifySigned(data, pub, "manifest")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err = ParseManifest(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return payload, m, nil
+}
+
+// ParseManifest parses a manifest payload.  The caller is responsible for
+// verifying its signature.
+func ParseManifest(payload []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+
+	for _, a := range m.AllowedKeys {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(a))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse allowed key %q: %v", a, err)
+		}
+		if cert, ok := pub.(*ssh.Certificate); ok {
+			pub = cert.Key
+		}
+		m.allowed = append(m.allowed, pub)
+	}
+
+	if m.ApprovalKey != "" {
+		pub, err := ParsePublicKey(m.ApprovalKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid approval key: %v", err)
+		}
+		m.approvalKey = pub
+	}
+
+	names := make(map[string]bool)
+	for _, e := range m.Keys {
+		if e.Name == "" {
+			return nil, errors.New("provisioned key must have a name")
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("duplicate provisioned key %q", e.Name)
+		}
+		names[e.Name] = true
+		if e.PrivateKey == "" {
+			return nil, fmt.Errorf("provisioned key %q has no private key", e.Name)
+		}
+	}
+	return &m, nil
+}
+
+// Allows determines if the manifest permits the specified key to be loaded
+// without an approval.
+func (m *Manifest) Allows(pub ssh.PublicKey) bool {
+	if m.listed(pub) {
+		return true
+	}
+	return len(m.allowed) == 0 && !m.RequiresApproval()
+}
+
+// RequiresApproval determines if keys not listed in the manifest must be
+// approved by an administrator before they may be loaded.
+func (m *Manifest) RequiresApproval() bool {
+	return m.approvalKey != nil
+}
+
+// listed determines if the specified key is listed in AllowedKeys.
+func (m *Manifest) listed(pub ssh.PublicKey) bool {
+	b := pub.Marshal()
+	for _, a := range m.allowed {
+		if bytes.Equal(a.Marshal(), b) {
+			return true
+		}
+	}
+	return false
+}
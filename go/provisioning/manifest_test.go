@@ -0,0 +1,203 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioning
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// newSigningKey returns a deterministic Ed25519 key pair derived from seed.
+func newSigningKey(seed byte) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{seed}, 32)))
+	if err != nil {
+		panic(err)
+	}
+	return pub, priv
+}
+
+// signManifest returns a signed manifest containing the supplied payload.
+func signManifest(priv ed25519.PrivateKey, payload string) []byte {
+	b, err := json.Marshal(&signedManifest{
+		Payload:   base64.StdEncoding.EncodeToString([]byte(payload)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(payload))),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func mustParsePublicKey(blob string) ssh.PublicKey {
+	b, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		panic(err)
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		panic(err)
+	}
+	return pub
+}
+
+func TestValidateURL(t *testing.T) {
+	testcases := []struct {
+		url     string
+		wantErr error
+	}{
+		{url: "https://example.com/manifest.json"},
+		{url: "http://example.com/manifest.json", wantErr: errors.New("provisioning URL must use https")},
+		{url: "https:///manifest.json", wantErr: errors.New("provisioning URL must include a host")},
+	}
+	for _, tc := range testcases {
+		if diff := pretty.Diff(ValidateURL(tc.url), tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.url, diff)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv := newSigningKey(1)
+	_, otherPriv := newSigningKey(2)
+	allowed := "ssh-rsa " + testdata.ValidPrivateKeyBlob + " allowed"
+
+	testcases := []struct {
+		description string
+		data        []byte
+		wantSerial  int64
+		wantNames   []string
+		wantErr     error
+	}{
+		{
+			description: "valid manifest",
+			data:        signManifest(priv, `{"serial": 3, "allowedKeys": ["`+allowed+`"], "keys": [{"name": "svc", "privateKey": "pem"}]}`),
+			wantSerial:  3,
+			wantNames:   []string{"svc"},
+		},
+		{
+			description: "signed by another key",
+			data:        signManifest(otherPriv, `{"serial": 3}`),
+			wantErr:     errors.New("manifest signature is invalid"),
+		},
+		{
+			description: "not a signed manifest",
+			data:        []byte(`{"serial": 3}`),
+			wantErr:     errors.New("manifest signature is invalid"),
+		},
+		{
+			description: "invalid allowed key",
+			data:        signManifest(priv, `{"allowedKeys": ["bogus"]}`),
+			wantErr:     errors.New(`failed to parse allowed key "bogus": ssh: no key found`),
+		},
+		{
+			description: "duplicate key name",
+			data:        signManifest(priv, `{"keys": [{"name": "svc", "privateKey": "a"}, {"name": "svc", "privateKey": "b"}]}`),
+			wantErr:     errors.New(`duplicate provisioned key "svc"`),
+		},
+		{
+			description: "missing private key",
+			data:        signManifest(priv, `{"keys": [{"name": "svc"}]}`),
+			wantErr:     errors.New(`provisioned key "svc" has no private key`),
+		},
+		{
+			description: "invalid approval key",
+			data:        signManifest(priv, `{"approvalKey": "AAAA"}`),
+			wantErr:     errors.New("invalid approval key: provisioning public key must be 32 bytes; got 3"),
+		},
+	}
+
+	for _, tc := range testcases {
+		_, m, err := Verify(tc.data, pub)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if m == nil {
+			continue
+		}
+		var names []string
+		for _, e := range m.Keys {
+			names = append(names, e.Name)
+		}
+		if diff := pretty.Diff(names, tc.wantNames); diff != nil {
+			t.Errorf("%s: incorrect keys; -got +want: %s", tc.description, diff)
+		}
+		if m.Serial != tc.wantSerial {
+			t.Errorf("%s: incorrect serial: got %d, want %d", tc.description, m.Serial, tc.wantSerial)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	allowed := mustParsePublicKey(testdata.ValidPrivateKeyBlob)
+	other := mustParsePublicKey(testdata.ValidPrivateKeyWithoutPassphraseBlob)
+	approvalPub, _ := newSigningKey(3)
+	approvalKey := base64.StdEncoding.EncodeToString(approvalPub)
+
+	testcases := []struct {
+		description string
+		payload     string
+		key         ssh.PublicKey
+		want        bool
+	}{
+		{
+			description: "no allowed keys",
+			payload:     `{}`,
+			key:         other,
+			want:        true,
+		},
+		{
+			description: "key allowed",
+			payload:     `{"allowedKeys": ["ssh-rsa ` + testdata.ValidPrivateKeyBlob + `"]}`,
+			key:         allowed,
+			want:        true,
+		},
+		{
+			description: "key not allowed",
+			payload:     `{"allowedKeys": ["ssh-rsa ` + testdata.ValidPrivateKeyBlob + `"]}`,
+			key:         other,
+			want:        false,
+		},
+		{
+			description: "approval required",
+			payload:     `{"approvalKey": "` + approvalKey + `"}`,
+			key:         other,
+			want:        false,
+		},
+		{
+			description: "listed key does not need approval",
+			payload:     `{"allowedKeys": ["ssh-rsa ` + testdata.ValidPrivateKeyBlob + `"], "approvalKey": "` + approvalKey + `"}`,
+			key:         allowed,
+			want:        true,
+		},
+	}
+
+	for _, tc := range testcases {
+		m, err := ParseManifest([]byte(tc.payload))
+		if err != nil {
+			t.Fatalf("%s: failed to parse manifest: %v", tc.description, err)
+		}
+		if got := m.Allows(tc.key); got != tc.want {
+			t.Errorf("%s: incorrect result: got %v, want %v", tc.description, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioning
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+// Fetcher retrieves documents over HTTPS.  It is typically implemented by a
+// netclient.Client, which retries failed requests; using this interface allows
+// for alternate implementations during testing.
+type Fetcher interface {
+	// Fetch retrieves the document at the specified URL.
+	Fetch(url string, callback func(body []byte, err error))
+}
+
+const (
+	// policyURLKey is the name of the policy specifying the URL from
+	// which the manifest is fetched.
+	policyURLKey = "provisioningUrl"
+	// policyPublicKeyKey is the name of the policy specifying the
+	// base64-encoded Ed25519 public key used to verify the manifest.
+	policyPublicKeyKey = "provisioningPublicKey"
+
+	// manifestKey is the key under which the most recently applied
+	// manifest payload is stored.
+	manifestKey = "provisioning.manifest"
+)
+
+// Policy is the provisioning configuration set by an administrator.
+type Policy struct {
+	// URL is the HTTPS URL from which the manifest is fetched.
+	URL string
+	// PublicKey is the base64-encoded Ed25519 public key used to verify
+	// the manifest.
+	PublicKey string
+}
+
+// ReadPolicy reads the provisioning configuration from managed storage,
+// which is populated from Chrome policy.  callback is invoked with a nil
+// policy if provisioning is not configured.
+func ReadPolicy(managed storage.Store, callback func(policy *Policy, err error)) {
+	managed.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read policy: %v", err))
+			return
+		}
+		u, _ := data[policyURLKey].(string)
+		pub, _ := data[policyPublicKeyKey].(string)
+		if u == "" {
+			callback(nil, nil)
+			return
+		}
+		callback(&Policy{URL: u, PublicKey: pub}, nil)
+	})
+}
+
+// Provisioner applies manifests to the configured keys, and enforces the
+// list of allowed keys in the most recently applied manifest.
+type Provisioner struct {
+	store   storage.Store
+	fetcher Fetcher
+	audit   *audit.Log
+}
+
+// New returns a Provisioner that fetches manifests using fetcher, and keeps
+// the most recently applied manifest in store (which should be local to this
+// device).  Manifests that are applied are recorded in auditLog.
+func New(store storage.Store, fetcher Fetcher, auditLog *audit.Log) *Provisioner {
+	return &Provisioner{
+		store:   store,
+		fetcher: fetcher,
+		audit:   auditLog,
+	}
+}
+
+// applied returns the most recently applied manifest, or nil if no manifest
+// has been applied.
+func (p *Provisioner) applied(callback func(m *Manifest, err error)) {
+	p.store.Get(func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read manifest: %v", err))
+			return
+		}
+		payload, ok := data[manifestKey].(string)
+		if !ok {
+			callback(nil, nil)
+			return
+		}
+		m, err := ParseManifest([]byte(payload))
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to parse stored manifest: %v", err))
+			return
+		}
+		callback(m, nil)
+	})
+}
+
+// Allowed determines if the specified key may be loaded according to the
+// most recently applied manifest.  If no manifest has been applied, all keys
+// may be loaded.  If the manifest requires approvals, keys it does not list
+// may be loaded only once approved (see Approve).  It may be used as a
+// keys.LoadPolicy.
+func (p *Provisioner) Allowed(pub ssh.PublicKey, callback func(err error)) {
+	p.applied(func(m *Manifest, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		if m == nil || m.Allows(pub) {
+			callback(nil)
+			return
+		}
+		if !m.RequiresApproval() {
+			callback(help.Errorf(help.KeyNotAllowed, "key %s is not allowed by the provisioning policy", ssh.FingerprintSHA256(pub)))
+			return
+		}
+		p.approved(m, pub, func(approved bool, err error) {
+			if err != nil {
+				callback(err)
+				return
+			}
+			if !approved {
+				callback(help.Errorf(help.ApprovalRequired, "key %s must be approved by your administrator; send them its fingerprint", ssh.FingerprintSHA256(pub)))
+				return
+			}
+			callback(nil)
+		})
+	})
+}
+
+// Sync fetches the manifest specified by policy, verifies it, and applies it
+// to the keys configured in mgr.  Keys listed in the manifest are added if
+// they are not already configured, and keys previously provisioned from the
+// same URL that are no longer listed are removed.  callback is invoked with
+// the outcome for each key added or removed.
+func (p *Provisioner) Sync(mgr keys.Manager, policy *Policy, callback func(result *keys.Result, err error)) {
+	if err := ValidateURL(policy.URL); err != nil {
+		callback(nil, err)
+		return
+	}
+	pub, err := ParsePublicKey(policy.PublicKey)
+	if err != nil {
+		callback(nil, err)
+		return
+	}
+
+	p.fetcher.Fetch(policy.URL, func(body []byte, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to fetch manifest: %v", err))
+			return
+		}
+		payload, m, err := Verify(body, pub)
+		if err != nil {
+			p.record(policy.URL, false, err.Error())
+			callback(nil, err)
+			return
+		}
+
+		p.applied(func(prev *Manifest, err error) {
+			if err != nil {
+				callback(nil, err)
+				return
+			}
+			if prev != nil && m.Serial < prev.Serial {
+				err := fmt.Errorf("manifest serial %d is older than applied serial %d", m.Serial, prev.Serial)
+				p.record(policy.URL, false, err.Error())
+				callback(nil, err)
+				return
+			}
+
+			p.store.Set(map[string]interface{}{manifestKey: string(payload)}, func(err error) {
+				if err != nil {
+					callback(nil, fmt.Errorf("failed to write manifest: %v", err))
+					return
+				}
+				p.apply(mgr, policy.URL, m, func(result *keys.Result, err error) {
+					if err == nil {
+						p.record(policy.URL, result.Failed == 0, fmt.Sprintf("applied manifest serial %d: %s", m.Serial, result.Summary()))
+					}
+					callback(result, err)
+				})
+			})
+		})
+	})
+}
+
+// record adds an entry to the audit log describing the outcome of applying a
+// manifest.
+func (p *Provisioner) record(url string, allowed bool, detail string) {
+	if p.audit == nil {
+		return
+	}
+	p.audit.Record(audit.NewEntry("provision", url, "", allowed, detail), nil)
+}
+
+// apply adds and removes configured keys so that the keys provisioned from
+// url match those listed in the manifest.
+func (p *Provisioner) apply(mgr keys.Manager, url string, m *Manifest, callback func(result *keys.Result, err error)) {
+	mgr.Configured(func(configured []*keys.ConfiguredKey, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read configured keys: %v", err))
+			return
+		}
+
+		existing := make(map[string]keys.ID)
+		for _, c := range configured {
+			if c.Source == keys.SourceProvisioned && c.SourceDetail == url {
+				existing[c.Name] = c.ID
+			}
+		}
+		listed := make(map[string]bool)
+		var add []*Entry
+		for _, e := range m.Keys {
+			listed[e.Name] = true
+			if _, ok := existing[e.Name]; !ok {
+				add = append(add, e)
+			}
+		}
+		var remove []keys.ID
+		for _, c := range configured {
+			if id, ok := existing[c.Name]; ok && id == c.ID && !listed[c.Name] {
+				remove = append(remove, c.ID)
+			}
+		}
+
+		result := keys.NewResult("provision")
+		addErrs := make(map[string]error)
+		var addNext func(i int)
+		var removeNext func(i int)
+		addNext = func(i int) {
+			if i == len(add) {
+				p.recordAdded(mgr, url, add, addErrs, result, func() {
+					removeNext(0)
+				})
+				return
+			}
+			e := add[i]
+			opts := &keys.AddOptions{
+				DeviceOnly:   true,
+				Source:       keys.SourceProvisioned,
+				SourceDetail: url,
+			}
+			mgr.Add(e.Name, e.PrivateKey, opts, func(err error) {
+				if err != nil {
+					addErrs[e.Name] = help.Wrap(err, fmt.Sprintf("failed to add key %q", e.Name))
+				}
+				addNext(i + 1)
+			})
+		}
+		removeNext = func(i int) {
+			if i == len(remove) {
+				callback(result, nil)
+				return
+			}
+			id := remove[i]
+			mgr.Remove(id, func(err error) {
+				if err != nil {
+					err = help.Wrap(err, "failed to remove key")
+				}
+				result.Record(id, err)
+				removeNext(i + 1)
+			})
+		}
+		addNext(0)
+	})
+}
+
+// recordAdded records the outcome of adding each of the specified entries.
+// The configured keys are read again so that keys that were added are
+// recorded under the ID allocated to them.
+func (p *Provisioner) recordAdded(mgr keys.Manager, url string, added []*Entry, addErrs map[string]error, result *keys.Result, done func()) {
+	if len(added) == 0 {
+		done()
+		return
+	}
+
+	mgr.Configured(func(configured []*keys.ConfiguredKey, readErr error) {
+		ids := make(map[string]keys.ID)
+		for _, c := range configured {
+			if c.Source == keys.SourceProvisioned && c.SourceDetail == url {
+				ids[c.Name] = c.ID
+			}
+		}
+		for _, e := range added {
+			if err := addErrs[e.Name]; err != nil {
+				result.Record(keys.InvalidID, err)
+				continue
+			}
+			id, ok := ids[e.Name]
+			if !ok {
+				if readErr != nil {
+					result.Record(keys.InvalidID, fmt.Errorf("failed to read added key %q: %v", e.Name, readErr))
+				} else {
+					result.Record(keys.InvalidID, fmt.Errorf("added key %q was not found", e.Name))
+				}
+				continue
+			}
+			result.Record(id, nil)
+		}
+		done()
+	})
+}
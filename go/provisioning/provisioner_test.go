@@ -0,0 +1,194 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioning
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/help"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	testURL = "https://example.com/manifest.json"
+)
+
+// fakeFetcher serves a fixed document.
+type fakeFetcher struct {
+	body []byte
+	err  error
+}
+
+// Fetch implements Fetcher.Fetch.
+func (f *fakeFetcher) Fetch(url string, callback func(body []byte, err error)) {
+	callback(f.body, f.err)
+}
+
+// manifestPayload returns a manifest payload provisioning the named keys.
+func manifestPayload(serial int64, allowed []string, names ...string) string {
+	m := &Manifest{Serial: serial, AllowedKeys: allowed}
+	for _, n := range names {
+		m.Keys = append(m.Keys, &Entry{Name: n, PrivateKey: testdata.ValidPrivateKeyWithoutPassphrase})
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+func provisionedNames(mgr keys.Manager) []string {
+	var result []string
+	mgr.Configured(func(configured []*keys.ConfiguredKey, err error) {
+		if err != nil {
+			panic(err)
+		}
+		for _, c := range configured {
+			if c.Source == keys.SourceProvisioned {
+				result = append(result, c.Name)
+			}
+		}
+	})
+	sort.Strings(result)
+	return result
+}
+
+func TestSync(t *testing.T) {
+	pub, priv := newSigningKey(1)
+	_, otherPriv := newSigningKey(2)
+	policy := &Policy{URL: testURL, PublicKey: base64.StdEncoding.EncodeToString(pub)}
+
+	testcases := []struct {
+		description   string
+		initial       []string
+		manifests     [][]byte
+		policy        *Policy
+		fetchErr      error
+		wantNames     []string
+		wantSucceeded int
+		wantErr       error
+	}{
+		{
+			description:   "add provisioned keys",
+			manifests:     [][]byte{signManifest(priv, manifestPayload(1, nil, "a", "b"))},
+			wantNames:     []string{"a", "b"},
+			wantSucceeded: 2,
+		},
+		{
+			description: "remove keys no longer listed",
+			manifests: [][]byte{
+				signManifest(priv, manifestPayload(1, nil, "a", "b")),
+				signManifest(priv, manifestPayload(2, nil, "b", "c")),
+			},
+			wantNames:     []string{"b", "c"},
+			wantSucceeded: 2,
+		},
+		{
+			description: "reject older manifest",
+			manifests: [][]byte{
+				signManifest(priv, manifestPayload(2, nil, "a")),
+				signManifest(priv, manifestPayload(1, nil, "b")),
+			},
+			wantNames: []string{"a"},
+			wantErr:   errors.New("manifest serial 1 is older than applied serial 2"),
+		},
+		{
+			description: "reject invalid signature",
+			manifests:   [][]byte{signManifest(otherPriv, manifestPayload(1, nil, "a"))},
+			wantErr:     errors.New("manifest signature is invalid"),
+		},
+		{
+			description: "reject plain HTTP",
+			manifests:   [][]byte{signManifest(priv, manifestPayload(1, nil, "a"))},
+			policy:      &Policy{URL: "http://example.com/manifest.json", PublicKey: policy.PublicKey},
+			wantErr:     errors.New("provisioning URL must use https"),
+		},
+		{
+			description: "fetch fails",
+			manifests:   [][]byte{nil},
+			fetchErr:    errors.New("network down"),
+			wantErr:     errors.New("failed to fetch manifest: network down"),
+		},
+	}
+
+	for _, tc := range testcases {
+		mgr := keys.NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+		fetcher := &fakeFetcher{err: tc.fetchErr}
+		p := New(fakes.NewMemStorage(), fetcher, nil)
+		pol := tc.policy
+		if pol == nil {
+			pol = policy
+		}
+
+		var result *keys.Result
+		var err error
+		for _, m := range tc.manifests {
+			fetcher.body = m
+			p.Sync(mgr, pol, func(r *keys.Result, e error) {
+				result, err = r, e
+			})
+		}
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if err == nil && result.Succeeded != tc.wantSucceeded {
+			t.Errorf("%s: incorrect number of keys succeeded: got %d, want %d", tc.description, result.Succeeded, tc.wantSucceeded)
+		}
+		if diff := pretty.Diff(provisionedNames(mgr), tc.wantNames); diff != nil {
+			t.Errorf("%s: incorrect provisioned keys; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	pub, priv := newSigningKey(1)
+	policy := &Policy{URL: testURL, PublicKey: base64.StdEncoding.EncodeToString(pub)}
+	allowed := []string{"ssh-rsa " + testdata.ValidPrivateKeyBlob}
+
+	mgr := keys.NewManager(agent.NewKeyring(), fakes.NewMemStorage(), fakes.NewMemStorage())
+	p := New(fakes.NewMemStorage(), &fakeFetcher{body: signManifest(priv, manifestPayload(1, allowed))}, nil)
+
+	// All keys are allowed until a manifest is applied.
+	p.Allowed(mustParsePublicKey(testdata.ValidPrivateKeyWithoutPassphraseBlob), func(err error) {
+		if err != nil {
+			t.Errorf("key not allowed before manifest applied: %v", err)
+		}
+	})
+
+	p.Sync(mgr, policy, func(r *keys.Result, err error) {
+		if err != nil {
+			t.Fatalf("failed to apply manifest: %v", err)
+		}
+	})
+
+	p.Allowed(mustParsePublicKey(testdata.ValidPrivateKeyBlob), func(err error) {
+		if err != nil {
+			t.Errorf("allowed key not allowed: %v", err)
+		}
+	})
+	p.Allowed(mustParsePublicKey(testdata.ValidPrivateKeyWithoutPassphraseBlob), func(err error) {
+		if help.CodeOf(err) != help.KeyNotAllowed {
+			t.Errorf("incorrect error for key not allowed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redact removes sensitive material (e.g., private keys and
+// passphrases) from text before it is included in error messages, logs or
+// audit entries.
+//
+// Errors are frequently built by wrapping the errors of lower layers, which
+// may quote their input.  Redaction is applied where text leaves the
+// component that produced it, so that no layer needs to reason about what
+// the layers beneath it might have included.
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/help"
+)
+
+const (
+	// Placeholder replaces redacted material.
+	Placeholder = "[redacted]"
+	// minBlobLength is the minimum length of a run of base64 characters
+	// that is redacted.  It is long enough to spare fingerprints
+	// (e.g., 'SHA256:' followed by 43 characters), but catches key
+	// material and public key blobs.
+	minBlobLength = 64
+)
+
+var (
+	// pemBlock matches a PEM block, including one whose end is missing
+	// (e.g., because the message was truncated).
+	pemBlock = regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]+-----.*?(?:-----END [A-Z0-9 ]+-----|$)`)
+	// blob matches a long run of base64 characters.
+	blob = regexp.MustCompile(fmt.Sprintf(`[A-Za-z0-9+/]{%d,}={0,2}`, minBlobLength))
+)
+
+// String returns s with PEM blocks, long base64 blobs, and any of the
+// specified secrets (e.g., passphrases) replaced by Placeholder.  Empty
+// secrets are ignored.
+func String(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.Replace(s, secret, Placeholder, -1)
+		}
+	}
+	s = pemBlock.ReplaceAllString(s, Placeholder)
+	return blob.ReplaceAllString(s, Placeholder)
+}
+
+// Error returns err with its message redacted as with String.  If nothing
+// needs to be redacted, err is returned unchanged so that callers may still
+// inspect its type; otherwise the returned error retains err's help topic.
+func Error(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	redacted := String(msg, secrets...)
+	if redacted == msg {
+		return err
+	}
+	if code := help.CodeOf(err); code != help.None {
+		return help.Errorf(code, "%s", redacted)
+	}
+	return errors.New(redacted)
+}
+
+// writer redacts text before writing it to an underlying writer.
+type writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a writer that redacts text, as with String, before
+// writing it to w.  It is intended for use with log.SetOutput; each write
+// must contain complete messages, since material split across writes cannot
+// be recognized.
+func NewWriter(w io.Writer) io.Writer {
+	return &writer{w: w}
+}
+
+// Write implements io.Writer.
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := w.w.Write([]byte(String(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
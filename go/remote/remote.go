@@ -0,0 +1,335 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remote implements keys whose private half never leaves an external
+// signing service (e.g., an HTTPS front end to an enterprise key management
+// system).  Only the public key and the location of the service are stored;
+// each signature is computed by the service.
+//
+// A remote key is stored as a PEM block of type PEMType, whose headers
+// identify the service and the key within it, and whose body is the public
+// key in SSH wire format:
+//
+//	-----BEGIN SSH REMOTE KEY-----
+//	Endpoint: https://signer.example.com/sign
+//	Key-Id: team/prod-deploy
+//
+//	AAAAC3NzaC1lZDI1NTE5AAAAI...
+//	-----END SSH REMOTE KEY-----
+//
+// To sign, the service is sent a JSON request by HTTP POST:
+//
+//	{"keyId": "...", "algorithm": "ssh-ed25519", "hash": "none", "digest": "<base64>"}
+//
+// hash is the name of the hash used to compute digest ("SHA-1", "SHA-256",
+// "SHA-384" or "SHA-512"), or "none" if digest is the data itself.  The
+// service responds with {"signature": "<base64>"}, containing the signature
+// as produced by crypto.Signer (PKCS#1 v1.5 for RSA, ASN.1 for ECDSA), or
+// {"error": "..."} if it refuses.
+package remote
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/entropy"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// ProviderName is the name of the provider used to load remote keys.
+	ProviderName = "remote"
+	// PEMType is the PEM block type of a remote key.
+	PEMType = "SSH REMOTE KEY"
+	// DefaultTimeout is how long a signature waits for the service to
+	// respond before it fails.
+	DefaultTimeout = 30 * time.Second
+
+	// endpointHeader and keyIDHeader are the PEM headers identifying the
+	// service and the key within it.
+	endpointHeader = "Endpoint"
+	keyIDHeader    = "Key-Id"
+)
+
+var (
+	// ErrTimeout is returned when the service does not respond to a
+	// signature request in time.
+	ErrTimeout = errors.New("remote signer did not respond in time")
+	// ErrVerify is returned when the signature returned by the service
+	// does not verify using the key's public key.
+	ErrVerify = errors.New("remote signer returned an invalid signature")
+)
+
+// Key is a key held by a remote signing service.
+type Key struct {
+	// Endpoint is the HTTPS URL to which signature requests are sent.
+	Endpoint string
+	// KeyID identifies the key to the service.
+	KeyID string
+	// PublicKey is the public half of the key.
+	PublicKey ssh.PublicKey
+}
+
+// check validates the key.
+func (k *Key) check() error {
+	u, err := url.Parse(k.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid remote signer endpoint: %v", err)
+	}
+	if u.Scheme != "https" {
+		return errors.New("remote signer endpoint must use https")
+	}
+	if u.Host == "" {
+		return errors.New("remote signer endpoint must include a host")
+	}
+	if k.KeyID == "" || strings.ContainsAny(k.KeyID, "\r\n") {
+		return errors.New("remote key must have a single-line key ID")
+	}
+	if k.PublicKey == nil {
+		return errors.New("remote key must have a public key")
+	}
+	if cryptoPublicKey(k.PublicKey) == nil {
+		return fmt.Errorf("remote keys of type %q are not supported", k.PublicKey.Type())
+	}
+	return nil
+}
+
+// cryptoPublicKey returns pub in the form used by crypto.Signer, or nil if
+// signatures using it cannot be verified (e.g., DSA keys and certificates).
+func cryptoPublicKey(pub ssh.PublicKey) crypto.PublicKey {
+	cpk, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil
+	}
+	switch k := cpk.CryptoPublicKey().(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return k
+	}
+	return nil
+}
+
+// Encode returns the key PEM-encoded, in the form in which it is stored.
+func Encode(k *Key) (string, error) {
+	if err := k.check(); err != nil {
+		return "", err
+	}
+	block := &pem.Block{
+		Type: PEMType,
+		Headers: map[string]string{
+			endpointHeader: k.Endpoint,
+			keyIDHeader:    k.KeyID,
+		},
+		Bytes: k.PublicKey.Marshal(),
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+// IsKey returns true if pemBytes contains a remote key.  It does not check
+// that the key is valid.
+func IsKey(pemBytes []byte) bool {
+	block, _ := pem.Decode(pemBytes)
+	return block != nil && block.Type == PEMType
+}
+
+// Parse decodes a PEM-encoded remote key.
+func Parse(pemBytes []byte) (*Key, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != PEMType {
+		return nil, errors.New("not a remote key")
+	}
+	pub, err := ssh.ParsePublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remote public key: %v", err)
+	}
+	k := &Key{
+		Endpoint:  block.Headers[endpointHeader],
+		KeyID:     block.Headers[keyIDHeader],
+		PublicKey: pub,
+	}
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Poster sends requests over HTTPS.  It is typically implemented by a
+// netclient.Client, which retries failed requests and holds them while the
+// browser is offline; using this interface allows for alternate
+// implementations during testing.
+type Poster interface {
+	// Post sends body to the specified URL, and invokes callback with
+	// the body of the response.
+	Post(url string, body []byte, callback func(body []byte, err error))
+}
+
+// remoteProvider is a provider.Provider for remote keys.
+type remoteProvider struct {
+	poster  Poster
+	timeout time.Duration
+}
+
+// NewProvider returns a provider.Provider that loads remote keys, sending
+// signature requests using poster.  A signature fails if the service does
+// not respond within timeout.
+func NewProvider(poster Poster, timeout time.Duration) provider.Provider {
+	return &remoteProvider{poster: poster, timeout: timeout}
+}
+
+// Name implements provider.Provider.Name.
+func (p *remoteProvider) Name() string {
+	return ProviderName
+}
+
+// Rand implements provider.Provider.Rand.
+func (p *remoteProvider) Rand() io.Reader {
+	return entropy.Reader
+}
+
+// ParsePrivateKey implements provider.Provider.ParsePrivateKey.  Remote keys
+// are never encrypted, so passphrase is ignored.  The result is a
+// crypto.Signer that signs using the service.
+func (p *remoteProvider) ParsePrivateKey(pemBytes, passphrase []byte) (interface{}, error) {
+	k, err := Parse(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &signer{key: k, pub: cryptoPublicKey(k.PublicKey), poster: p.poster, timeout: p.timeout}, nil
+}
+
+// hashNames are the names by which hashes are identified to the service.
+var hashNames = map[crypto.Hash]string{
+	0:             "none",
+	crypto.SHA1:   "SHA-1",
+	crypto.SHA256: "SHA-256",
+	crypto.SHA384: "SHA-384",
+	crypto.SHA512: "SHA-512",
+}
+
+// signRequest is the request sent to the service.
+type signRequest struct {
+	KeyID     string `json:"keyId"`
+	Algorithm string `json:"algorithm"`
+	Hash      string `json:"hash"`
+	Digest    string `json:"digest"`
+}
+
+// signResponse is the response returned by the service.
+type signResponse struct {
+	Signature string `json:"signature"`
+	Error     string `json:"error"`
+}
+
+// postResult is the outcome of a request to the service.
+type postResult struct {
+	body []byte
+	err  error
+}
+
+// signer is a crypto.Signer for a remote key.
+type signer struct {
+	key     *Key
+	pub     crypto.PublicKey
+	poster  Poster
+	timeout time.Duration
+}
+
+// Public implements crypto.Signer.Public.
+func (s *signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign implements crypto.Signer.Sign.  It blocks until the service responds,
+// so it must not be invoked from a Javascript callback.  The signature is
+// verified before it is returned, so that a misbehaving service cannot cause
+// invalid signatures to be sent to servers.
+func (s *signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, ok := hashNames[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("remote signer does not support hash %v", opts.HashFunc())
+	}
+	body, err := json.Marshal(&signRequest{
+		KeyID:     s.key.KeyID,
+		Algorithm: s.key.PublicKey.Type(),
+		Hash:      hash,
+		Digest:    base64.StdEncoding.EncodeToString(digest),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signature request: %v", err)
+	}
+
+	// The result channel is buffered so that a late response does not
+	// block the callback.
+	done := make(chan postResult, 1)
+	s.poster.Post(s.key.Endpoint, body, func(body []byte, err error) {
+		done <- postResult{body: body, err: err}
+	})
+	var r postResult
+	select {
+	case r = <-done:
+	case <-time.After(s.timeout):
+		return nil, ErrTimeout
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("failed to contact remote signer: %v", r.err)
+	}
+
+	var rsp signResponse
+	if err := json.Unmarshal(r.body, &rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse remote signer response: %v", err)
+	}
+	if rsp.Error != "" {
+		return nil, fmt.Errorf("remote signer refused to sign: %s", rsp.Error)
+	}
+	sig, err := base64.StdEncoding.DecodeString(rsp.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode remote signature: %v", err)
+	}
+	if !verify(s.pub, opts.HashFunc(), digest, sig) {
+		return nil, ErrVerify
+	}
+	return sig, nil
+}
+
+// verify returns true if sig is a valid signature of digest using pub.
+func verify(pub crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		var es struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(sig, &es); err != nil || len(rest) != 0 {
+			return false
+		}
+		return ecdsa.Verify(pub, digest, es.R, es.S)
+	case ed25519.PublicKey:
+		return len(sig) == ed25519.SignatureSize && ed25519.Verify(pub, digest, sig)
+	}
+	return false
+}
@@ -0,0 +1,277 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeService signs requests using a private key held in memory.
+type fakeService struct {
+	priv crypto.Signer
+	// refuse is returned as an error instead of signing, if set.
+	refuse string
+	// corrupt causes the signature to be altered before it is returned.
+	corrupt bool
+	// err is returned instead of a response, if set.
+	err error
+	// silent causes requests to go unanswered.
+	silent bool
+	// requests contains the requests received.
+	requests []*signRequest
+}
+
+// Post implements Poster.Post.
+func (f *fakeService) Post(url string, body []byte, callback func(body []byte, err error)) {
+	if f.silent {
+		return
+	}
+	if f.err != nil {
+		callback(nil, f.err)
+		return
+	}
+	var req signRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		callback(nil, err)
+		return
+	}
+	f.requests = append(f.requests, &req)
+	rsp := &signResponse{Error: f.refuse}
+	if f.refuse == "" {
+		digest, _ := base64.StdEncoding.DecodeString(req.Digest)
+		var hash crypto.Hash
+		for h, name := range hashNames {
+			if name == req.Hash {
+				hash = h
+			}
+		}
+		sig, err := f.priv.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		if f.corrupt {
+			sig[len(sig)-1] ^= 1
+		}
+		rsp.Signature = base64.StdEncoding.EncodeToString(sig)
+	}
+	b, _ := json.Marshal(rsp)
+	callback(b, nil)
+}
+
+func ed25519Key(t *testing.T) crypto.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return priv
+}
+
+func ecdsaKey(t *testing.T) crypto.Signer {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return priv
+}
+
+func rsaKey(t *testing.T) crypto.Signer {
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	return priv.(crypto.Signer)
+}
+
+func sshPublicKey(t *testing.T, priv crypto.Signer) ssh.PublicKey {
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatalf("failed to convert public key: %v", err)
+	}
+	return pub
+}
+
+func TestEncodeParse(t *testing.T) {
+	pub := sshPublicKey(t, ed25519Key(t))
+
+	testcases := []struct {
+		description string
+		key         *Key
+		wantErr     bool
+	}{
+		{
+			description: "valid key",
+			key:         &Key{Endpoint: "https://signer.example.com/sign", KeyID: "team/prod", PublicKey: pub},
+		},
+		{
+			description: "endpoint without https",
+			key:         &Key{Endpoint: "http://signer.example.com/sign", KeyID: "team/prod", PublicKey: pub},
+			wantErr:     true,
+		},
+		{
+			description: "endpoint without host",
+			key:         &Key{Endpoint: "https:///sign", KeyID: "team/prod", PublicKey: pub},
+			wantErr:     true,
+		},
+		{
+			description: "missing key ID",
+			key:         &Key{Endpoint: "https://signer.example.com/sign", PublicKey: pub},
+			wantErr:     true,
+		},
+		{
+			description: "multi-line key ID",
+			key:         &Key{Endpoint: "https://signer.example.com/sign", KeyID: "team\nprod", PublicKey: pub},
+			wantErr:     true,
+		},
+		{
+			description: "missing public key",
+			key:         &Key{Endpoint: "https://signer.example.com/sign", KeyID: "team/prod"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		encoded, err := Encode(tc.key)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error from Encode; got %v, wantErr %v", tc.description, err, tc.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if !IsKey([]byte(encoded)) {
+			t.Errorf("%s: encoded key not recognized as a remote key", tc.description)
+		}
+		got, err := Parse([]byte(encoded))
+		if err != nil {
+			t.Errorf("%s: failed to parse encoded key: %v", tc.description, err)
+			continue
+		}
+		if got.Endpoint != tc.key.Endpoint || got.KeyID != tc.key.KeyID || string(got.PublicKey.Marshal()) != string(tc.key.PublicKey.Marshal()) {
+			t.Errorf("%s: incorrect key after round trip; got %+v, want %+v", tc.description, got, tc.key)
+		}
+	}
+
+	if IsKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase)) {
+		t.Errorf("private key recognized as a remote key")
+	}
+	if _, err := Parse([]byte(testdata.ValidPrivateKeyWithoutPassphrase)); err == nil {
+		t.Errorf("parsed private key as a remote key")
+	}
+}
+
+func TestSign(t *testing.T) {
+	data := []byte("some data to sign")
+
+	testcases := []struct {
+		description string
+		service     *fakeService
+		wantHash    string
+		wantErr     bool
+	}{
+		{
+			description: "ed25519",
+			service:     &fakeService{priv: ed25519Key(t)},
+			wantHash:    "none",
+		},
+		{
+			description: "ecdsa",
+			service:     &fakeService{priv: ecdsaKey(t)},
+			wantHash:    "SHA-256",
+		},
+		{
+			description: "rsa",
+			service:     &fakeService{priv: rsaKey(t)},
+			wantHash:    "SHA-1",
+		},
+		{
+			description: "service refuses",
+			service:     &fakeService{priv: ed25519Key(t), refuse: "not authorized"},
+			wantHash:    "none",
+			wantErr:     true,
+		},
+		{
+			description: "invalid signature",
+			service:     &fakeService{priv: ecdsaKey(t), corrupt: true},
+			wantHash:    "SHA-256",
+			wantErr:     true,
+		},
+		{
+			description: "request fails",
+			service:     &fakeService{priv: ed25519Key(t), err: errors.New("network unreachable")},
+			wantErr:     true,
+		},
+		{
+			description: "no response",
+			service:     &fakeService{priv: ed25519Key(t), silent: true},
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		pub := sshPublicKey(t, tc.service.priv)
+		encoded, err := Encode(&Key{Endpoint: "https://signer.example.com/sign", KeyID: "my-key", PublicKey: pub})
+		if err != nil {
+			t.Fatalf("%s: failed to encode key: %v", tc.description, err)
+		}
+
+		p := NewProvider(tc.service, 10*time.Millisecond)
+		priv, err := p.ParsePrivateKey([]byte(encoded), nil)
+		if err != nil {
+			t.Fatalf("%s: failed to parse key: %v", tc.description, err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatalf("%s: failed to create signer: %v", tc.description, err)
+		}
+		if diff := pretty.Diff(signer.PublicKey().Marshal(), pub.Marshal()); diff != nil {
+			t.Errorf("%s: incorrect public key; -got +want: %s", tc.description, diff)
+		}
+
+		sig, err := signer.Sign(rand.Reader, data)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, wantErr %v", tc.description, err, tc.wantErr)
+		}
+		if err == nil {
+			if err := pub.Verify(data, sig); err != nil {
+				t.Errorf("%s: signature failed verification: %v", tc.description, err)
+			}
+		}
+
+		var gotHash string
+		if len(tc.service.requests) > 0 {
+			req := tc.service.requests[0]
+			gotHash = req.Hash
+			if req.KeyID != "my-key" || req.Algorithm != pub.Type() {
+				t.Errorf("%s: incorrect request; got key ID %q and algorithm %q, want %q and %q", tc.description, req.KeyID, req.Algorithm, "my-key", pub.Type())
+			}
+		}
+		if gotHash != tc.wantHash {
+			t.Errorf("%s: incorrect hash; got %q, want %q", tc.description, gotHash, tc.wantHash)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replay detects signature requests that are exact duplicates of a
+// recent request from another connection.  A client that signs the same
+// data twice on separate connections is unusual: it may indicate that a
+// captured request (e.g., an SSH session's exchange hash) is being replayed
+// by another client, or by a host to which the agent was forwarded.
+//
+// Duplicates are reported to a callback, so that they can be recorded in the
+// audit log, and may optionally be refused (see Settings).
+package replay
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrDuplicate is returned when a duplicate signature request is refused.
+var ErrDuplicate = errors.New("refused duplicate of a signature request made by another connection")
+
+// Duplicate describes a signature request that duplicates a recent request
+// from another connection.
+type Duplicate struct {
+	// Fingerprint is the SHA256 fingerprint of the key with which the
+	// signature was requested.
+	Fingerprint string
+	// Conn identifies the connection that made the duplicate request.
+	Conn string
+	// Original identifies the connection that made the original request.
+	Original string
+	// Age is the time elapsed since the original request.
+	Age time.Duration
+	// Blocked indicates that the duplicate request was refused.
+	Blocked bool
+}
+
+// String describes the duplicate for the audit log.
+func (d *Duplicate) String() string {
+	verb := "allowed"
+	if d.Blocked {
+		verb = "refused"
+	}
+	return fmt.Sprintf("%s duplicate of a signature request made %v earlier by %s", verb, d.Age.Round(time.Millisecond), d.Original)
+}
+
+// sighting records the first request to sign a payload.
+type sighting struct {
+	conn string
+	at   time.Time
+}
+
+// Detector tracks recent signature requests across all connections.  The
+// payloads are not retained; only their hashes are.
+type Detector struct {
+	onDuplicate func(d *Duplicate)
+	now         func() time.Time
+
+	mu       sync.Mutex
+	settings Settings
+	// seen maps the hash of each recently signed key and payload to the
+	// first request to sign it.
+	seen map[[sha256.Size]byte]*sighting
+}
+
+// NewDetector returns a Detector using the default settings.  onDuplicate
+// is invoked with each duplicate request detected; it must not block.
+func NewDetector(onDuplicate func(d *Duplicate)) *Detector {
+	return &Detector{
+		onDuplicate: onDuplicate,
+		now:         time.Now,
+		settings:    DefaultSettings,
+		seen:        make(map[[sha256.Size]byte]*sighting),
+	}
+}
+
+// Configure replaces the settings used to detect duplicates.  Requests
+// already seen are forgotten if detection is disabled.
+func (d *Detector) Configure(s *Settings) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.settings = *s
+	if s.Window <= 0 {
+		d.seen = make(map[[sha256.Size]byte]*sighting)
+	}
+}
+
+// hash returns the hash identifying a request to sign data with key.
+func hash(key ssh.PublicKey, data []byte) [sha256.Size]byte {
+	return sha256.Sum256(ssh.Marshal(struct {
+		Key  []byte
+		Data []byte
+	}{key.Marshal(), data}))
+}
+
+// observe records a request from conn to sign data with key.  It returns
+// the duplicate if an identical request was made by another connection
+// within the window, or nil otherwise.
+func (d *Detector) observe(key ssh.PublicKey, data []byte, conn string) *Duplicate {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.settings.Window <= 0 {
+		return nil
+	}
+	now := d.now()
+	for h, s := range d.seen {
+		if now.Sub(s.at) > d.settings.Window {
+			delete(d.seen, h)
+		}
+	}
+
+	h := hash(key, data)
+	s, ok := d.seen[h]
+	if !ok {
+		d.seen[h] = &sighting{conn: conn, at: now}
+		return nil
+	}
+	if s.conn == conn {
+		return nil
+	}
+	return &Duplicate{
+		Fingerprint: ssh.FingerprintSHA256(key),
+		Conn:        conn,
+		Original:    s.conn,
+		Age:         now.Sub(s.at),
+		Blocked:     d.settings.Block,
+	}
+}
+
+// Agent returns an agent.Agent that performs operations using a on behalf
+// of the connection identified by conn, checking each signature request for
+// duplicates.  A separate Agent must be used for each connection.
+func (d *Detector) Agent(a agent.Agent, conn string) agent.Agent {
+	return &connAgent{
+		Agent:    a,
+		detector: d,
+		conn:     conn,
+	}
+}
+
+// connAgent is an agent.Agent checking the signature requests made by a
+// single connection for duplicates.
+type connAgent struct {
+	agent.Agent
+	detector *Detector
+	conn     string
+}
+
+// Sign implements agent.Agent.Sign.
+func (c *connAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	if dup := c.detector.observe(key, data, c.conn); dup != nil {
+		if c.detector.onDuplicate != nil {
+			c.detector.onDuplicate(dup)
+		}
+		if dup.Blocked {
+			return nil, ErrDuplicate
+		}
+	}
+	return c.Agent.Sign(key, data)
+}
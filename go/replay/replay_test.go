@@ -0,0 +1,155 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// request is a signature request made by a connection at a time.
+type request struct {
+	conn string
+	data string
+	at   time.Duration
+}
+
+func TestDetector(t *testing.T) {
+	testcases := []struct {
+		description   string
+		settings      Settings
+		requests      []request
+		wantDuplicate []*Duplicate
+		wantSigned    int
+	}{
+		{
+			description: "distinct payloads",
+			settings:    DefaultSettings,
+			requests: []request{
+				{conn: "a", data: "one"},
+				{conn: "b", data: "two"},
+			},
+			wantSigned: 2,
+		},
+		{
+			description: "same connection",
+			settings:    DefaultSettings,
+			requests: []request{
+				{conn: "a", data: "one"},
+				{conn: "a", data: "one", at: time.Second},
+			},
+			wantSigned: 2,
+		},
+		{
+			description: "duplicate reported",
+			settings:    DefaultSettings,
+			requests: []request{
+				{conn: "a", data: "one"},
+				{conn: "b", data: "one", at: time.Second},
+			},
+			wantDuplicate: []*Duplicate{
+				{Conn: "b", Original: "a", Age: time.Second},
+			},
+			wantSigned: 2,
+		},
+		{
+			description: "duplicate refused",
+			settings:    Settings{Window: time.Minute, Block: true},
+			requests: []request{
+				{conn: "a", data: "one"},
+				{conn: "b", data: "one", at: time.Second},
+			},
+			wantDuplicate: []*Duplicate{
+				{Conn: "b", Original: "a", Age: time.Second, Blocked: true},
+			},
+			wantSigned: 1,
+		},
+		{
+			description: "outside window",
+			settings:    DefaultSettings,
+			requests: []request{
+				{conn: "a", data: "one"},
+				{conn: "b", data: "one", at: 2 * time.Minute},
+			},
+			wantSigned: 2,
+		},
+		{
+			description: "disabled",
+			settings:    Settings{Block: true},
+			requests: []request{
+				{conn: "a", data: "one"},
+				{conn: "b", data: "one", at: time.Second},
+			},
+			wantSigned: 2,
+		},
+	}
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	pub := signer.PublicKey()
+	start := time.Unix(1500000000, 0)
+
+	for _, tc := range testcases {
+		kr := agent.NewKeyring()
+		if err := kr.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+			t.Fatalf("%s: failed to add key: %v", tc.description, err)
+		}
+		var duplicates []*Duplicate
+		d := NewDetector(func(dup *Duplicate) {
+			duplicates = append(duplicates, dup)
+		})
+		d.Configure(&tc.settings)
+
+		signed := 0
+		for _, r := range tc.requests {
+			r := r
+			d.now = func() time.Time { return start.Add(r.at) }
+			_, err := d.Agent(kr, r.conn).Sign(pub, []byte(r.data))
+			if err == nil {
+				signed++
+			} else if err != ErrDuplicate {
+				t.Errorf("%s: failed to sign: %v", tc.description, err)
+			}
+		}
+		for _, dup := range tc.wantDuplicate {
+			dup.Fingerprint = ssh.FingerprintSHA256(pub)
+		}
+		if diff := pretty.Diff(duplicates, tc.wantDuplicate); diff != nil {
+			t.Errorf("%s: incorrect duplicates; -got +want: %s", tc.description, diff)
+		}
+		if signed != tc.wantSigned {
+			t.Errorf("%s: incorrect number of signatures; got %d, want %d", tc.description, signed, tc.wantSigned)
+		}
+	}
+}
+
+func TestDuplicateString(t *testing.T) {
+	d := &Duplicate{Original: "chrome-extension://abc (connection 1)", Age: 1500 * time.Millisecond, Blocked: true}
+	want := "refused duplicate of a signature request made 1.5s earlier by chrome-extension://abc (connection 1)"
+	if got := d.String(); got != want {
+		t.Errorf("incorrect description; got %q, want %q", got, want)
+	}
+}
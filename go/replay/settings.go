@@ -0,0 +1,96 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/storage"
+)
+
+const (
+	// WindowKey is the key under which the window within which duplicate
+	// requests are detected is stored, in seconds.  It is stored
+	// per-device; it is never synced.
+	WindowKey = "replay.window"
+	// BlockKey is the key under which whether duplicate requests are
+	// refused is stored.  It is stored per-device.
+	BlockKey = "replay.block"
+
+	// MaxWindow is the longest window that may be configured.
+	MaxWindow = time.Hour
+)
+
+// Settings configure how duplicate signature requests are detected.
+type Settings struct {
+	// Window is how long a request is remembered; duplicates arriving
+	// later are not detected.  Detection is disabled if it is zero.
+	Window time.Duration
+	// Block indicates that duplicate requests are refused, rather than
+	// only reported.
+	Block bool
+}
+
+// DefaultSettings are used if none have been configured: duplicates within
+// a minute are reported, but not refused.
+var DefaultSettings = Settings{Window: time.Minute}
+
+// Changed determines if a change to storage affects the settings.
+func Changed(changes map[string]interface{}) bool {
+	_, window := changes[WindowKey]
+	_, block := changes[BlockKey]
+	return window || block
+}
+
+// ReadSettings reads the settings from store.  The default settings are
+// used for those that have not been configured (or are invalid).
+func ReadSettings(store storage.Settings, callback func(s *Settings, err error)) {
+	store.GetItems([]string{WindowKey, BlockKey}, func(data map[string]interface{}, err error) {
+		s := DefaultSettings
+		if err != nil {
+			callback(&s, fmt.Errorf("failed to read replay detection settings: %v", err))
+			return
+		}
+		// Numbers are decoded from storage as float64.
+		if secs, ok := data[WindowKey].(float64); ok && secs >= 0 && time.Duration(secs)*time.Second <= MaxWindow {
+			s.Window = time.Duration(secs) * time.Second
+		}
+		if block, ok := data[BlockKey].(bool); ok {
+			s.Block = block
+		}
+		callback(&s, nil)
+	})
+}
+
+// WriteSettings stores the settings in store.  The window is stored in whole
+// seconds.  callback is invoked when complete.
+func WriteSettings(store storage.Settings, s *Settings, callback func(err error)) {
+	if s.Window < 0 || s.Window > MaxWindow {
+		callback(fmt.Errorf("replay detection window must be between 0 and %d seconds", int(MaxWindow/time.Second)))
+		return
+	}
+	data := map[string]interface{}{
+		WindowKey: float64(s.Window / time.Second),
+		BlockKey:  s.Block,
+	}
+	store.Set(data, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to write replay detection settings: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
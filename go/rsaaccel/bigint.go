@@ -0,0 +1,70 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsaaccel
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// bigIntExpSource computes modular exponentiation using Javascript's native
+// BigInt.  Arguments and result are hexadecimal strings.
+const bigIntExpSource = `
+	var zero = BigInt(0), one = BigInt(1);
+	b = BigInt("0x" + b); e = BigInt("0x" + e); m = BigInt("0x" + m);
+	var r = one;
+	b = b % m;
+	while (e > zero) {
+		if ((e & one) === one) {
+			r = (r * b) % m;
+		}
+		e = e >> one;
+		b = (b * b) % m;
+	}
+	return r.toString(16);
+`
+
+var (
+	bigIntExpOnce sync.Once
+	bigIntExpFunc *js.Object
+)
+
+// BigIntExp returns a ModExp that uses the browser's native BigInt, or nil
+// if BigInt is not available (e.g., in older browsers, or when not running
+// under GopherJS).
+func BigIntExp() ModExp {
+	bigIntExpOnce.Do(func() {
+		if js.Global == nil || js.Global.Get("BigInt") == js.Undefined {
+			return
+		}
+		bigIntExpFunc = js.Global.Get("Function").New("b", "e", "m", bigIntExpSource)
+	})
+	if bigIntExpFunc == nil {
+		return nil
+	}
+
+	return func(base, exp, m *big.Int) *big.Int {
+		r := bigIntExpFunc.Invoke(base.Text(16), exp.Text(16), m.Text(16)).String()
+		result, ok := new(big.Int).SetString(r, 16)
+		if !ok {
+			// The signature is verified before it is used, so a
+			// nonsensical result is caught by the caller.
+			return new(big.Int)
+		}
+		return result
+	}
+}
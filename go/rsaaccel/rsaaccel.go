@@ -0,0 +1,177 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rsaaccel signs with RSA keys using a pluggable implementation of
+// modular exponentiation.  In the transpiled extension, math/big performs
+// arithmetic on 32-bit words in Javascript, so an RSA-4096 signature takes
+// long enough to delay each connection noticeably; the browser's native
+// BigInt is far faster.  See BigIntExp.
+package rsaaccel
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// ModExp computes base**exp mod m.  Implementations need not be constant
+// time; signatures are blinded before exponentiation.
+type ModExp func(base, exp, m *big.Int) *big.Int
+
+// GoExp computes modular exponentiation using math/big.
+func GoExp(base, exp, m *big.Int) *big.Int {
+	return new(big.Int).Exp(base, exp, m)
+}
+
+var (
+	// ErrVerify indicates that a computed signature failed verification
+	// (e.g., due to a fault in the exponentiation, or inconsistent
+	// private key parameters).  The signature is discarded rather than
+	// returned, since a faulty CRT signature can reveal the private key.
+	ErrVerify = errors.New("rsaaccel: signature failed verification")
+)
+
+// hashPrefixes contains the DER-encoded DigestInfo prefix for each hash
+// supported for PKCS#1 v1.5 signatures.  See RFC 8017 Section 9.2.
+var hashPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// signer is a crypto.Signer for an RSA key that delegates modular
+// exponentiation.
+type signer struct {
+	priv *rsa.PrivateKey
+	exp  ModExp
+}
+
+// NewSigner returns a crypto.Signer that produces PKCS#1 v1.5 signatures
+// using priv, computing modular exponentiation with exp.  The private key's
+// CRT values are computed if they are missing.  Keys with more than two
+// primes are signed using crypto/rsa.
+func NewSigner(priv *rsa.PrivateKey, exp ModExp) crypto.Signer {
+	if priv.Precomputed.Dp == nil {
+		priv.Precompute()
+	}
+	return &signer{priv: priv, exp: exp}
+}
+
+// Public implements crypto.Signer.Public.
+func (s *signer) Public() crypto.PublicKey {
+	return &s.priv.PublicKey
+}
+
+// Sign implements crypto.Signer.Sign.  Only PKCS#1 v1.5 signatures are
+// supported; digest must have been computed using opts.HashFunc().
+func (s *signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, errors.New("rsaaccel: PSS signatures are not supported")
+	}
+	if len(s.priv.Primes) != 2 {
+		return rsa.SignPKCS1v15(rand, s.priv, opts.HashFunc(), digest)
+	}
+
+	hash := opts.HashFunc()
+	prefix, ok := hashPrefixes[hash]
+	if !ok {
+		return nil, fmt.Errorf("rsaaccel: unsupported hash function %v", hash)
+	}
+	if len(digest) != hash.Size() {
+		return nil, errors.New("rsaaccel: digest has incorrect length")
+	}
+
+	// Encode the digest as EM = 0x00 || 0x01 || PS || 0x00 || T.
+	k := (s.priv.N.BitLen() + 7) / 8
+	tLen := len(prefix) + len(digest)
+	if k < tLen+11 {
+		return nil, rsa.ErrMessageTooLong
+	}
+	em := make([]byte, k)
+	em[1] = 1
+	for i := 2; i < k-tLen-1; i++ {
+		em[i] = 0xff
+	}
+	copy(em[k-tLen:], prefix)
+	copy(em[k-len(digest):], digest)
+
+	c := new(big.Int).SetBytes(em)
+	m, err := s.decrypt(rand, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify the signature before releasing it.  The public exponent is
+	// small, so this is cheap relative to signing.
+	if check := GoExp(m, big.NewInt(int64(s.priv.E)), s.priv.N); check.Cmp(c) != 0 {
+		return nil, ErrVerify
+	}
+
+	sig := m.Bytes()
+	if len(sig) < k {
+		padded := make([]byte, k)
+		copy(padded[k-len(sig):], sig)
+		sig = padded
+	}
+	return sig, nil
+}
+
+// decrypt computes c**d mod n using the CRT values of the private key.  c is
+// blinded with a random value from rand, so that the time taken by exp does
+// not reveal the private key.
+func (s *signer) decrypt(rand io.Reader, c *big.Int) (*big.Int, error) {
+	priv := s.priv
+	n := priv.N
+	e := big.NewInt(int64(priv.E))
+
+	// Choose r invertible mod n, and blind: c' = c * r**e mod n.
+	var r, rInv *big.Int
+	buf := make([]byte, (n.BitLen()+7)/8)
+	for {
+		if _, err := io.ReadFull(rand, buf); err != nil {
+			return nil, fmt.Errorf("rsaaccel: failed to read random value: %v", err)
+		}
+		r = new(big.Int).SetBytes(buf)
+		r.Mod(r, n)
+		if r.Sign() == 0 {
+			continue
+		}
+		rInv = new(big.Int).ModInverse(r, n)
+		if rInv != nil && rInv.Sign() != 0 {
+			break
+		}
+	}
+	blinded := GoExp(r, e, n)
+	blinded.Mul(blinded, c)
+	blinded.Mod(blinded, n)
+
+	// m1 = c'**dP mod p, m2 = c'**dQ mod q, h = qInv * (m1 - m2) mod p,
+	// m' = m2 + h*q.  See RFC 8017 Section 5.1.2.
+	p, q := priv.Primes[0], priv.Primes[1]
+	m1 := s.exp(new(big.Int).Mod(blinded, p), priv.Precomputed.Dp, p)
+	m2 := s.exp(new(big.Int).Mod(blinded, q), priv.Precomputed.Dq, q)
+	h := new(big.Int).Sub(m1, m2)
+	h.Mul(h, priv.Precomputed.Qinv)
+	h.Mod(h, p)
+	m := h.Mul(h, q)
+	m.Add(m, m2)
+
+	// Unblind: m = m' * r**-1 mod n.
+	m.Mul(m, rInv)
+	m.Mod(m, n)
+	return m, nil
+}
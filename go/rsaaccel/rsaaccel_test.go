@@ -0,0 +1,156 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsaaccel
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"math/big"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"golang.org/x/crypto/ssh"
+)
+
+func testKey(t *testing.T) *rsa.PrivateKey {
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	return priv.(*rsa.PrivateKey)
+}
+
+func faultyExp(base, exp, m *big.Int) *big.Int {
+	r := GoExp(base, exp, m)
+	return r.Add(r, big.NewInt(1))
+}
+
+func TestSign(t *testing.T) {
+	data := []byte("some data to sign")
+	sum1 := sha1.Sum(data)
+	sum256 := sha256.Sum256(data)
+	sum512 := sha512.Sum512(data)
+
+	testcases := []struct {
+		description  string
+		exp          ModExp
+		hash         crypto.Hash
+		digest       []byte
+		noPrecompute bool
+		wantErr      error
+	}{
+		{
+			description: "SHA1",
+			exp:         GoExp,
+			hash:        crypto.SHA1,
+			digest:      sum1[:],
+		},
+		{
+			description: "SHA256",
+			exp:         GoExp,
+			hash:        crypto.SHA256,
+			digest:      sum256[:],
+		},
+		{
+			description: "SHA512",
+			exp:         GoExp,
+			hash:        crypto.SHA512,
+			digest:      sum512[:],
+		},
+		{
+			description:  "missing CRT values",
+			exp:          GoExp,
+			hash:         crypto.SHA256,
+			digest:       sum256[:],
+			noPrecompute: true,
+		},
+		{
+			description: "faulty exponentiation",
+			exp:         faultyExp,
+			hash:        crypto.SHA256,
+			digest:      sum256[:],
+			wantErr:     ErrVerify,
+		},
+	}
+
+	for _, tc := range testcases {
+		priv := testKey(t)
+		if tc.noPrecompute {
+			priv.Precomputed = rsa.PrecomputedValues{}
+		}
+		s := NewSigner(priv, tc.exp)
+		sig, err := s.Sign(rand.Reader, tc.digest, tc.hash)
+		if err != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want %v", tc.description, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		// PKCS#1 v1.5 signatures are deterministic, so the signature
+		// must match the one computed by crypto/rsa.
+		want, err := rsa.SignPKCS1v15(nil, testKey(t), tc.hash, tc.digest)
+		if err != nil {
+			t.Fatalf("%s: failed to sign with crypto/rsa: %v", tc.description, err)
+		}
+		if !bytes.Equal(sig, want) {
+			t.Errorf("%s: incorrect signature", tc.description)
+		}
+	}
+}
+
+func TestSSHSigner(t *testing.T) {
+	signer, err := ssh.NewSignerFromKey(NewSigner(testKey(t), GoExp))
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	data := []byte("some data to sign")
+	sig, err := signer.Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if err := signer.PublicKey().Verify(data, sig); err != nil {
+		t.Errorf("failed to verify signature: %v", err)
+	}
+}
+
+func TestBigIntExp(t *testing.T) {
+	exp := BigIntExp()
+	if exp == nil {
+		t.Skip("BigInt is not available")
+	}
+
+	priv := testKey(t)
+	testcases := []struct {
+		base, exp, m *big.Int
+	}{
+		{big.NewInt(0), big.NewInt(5), big.NewInt(7)},
+		{big.NewInt(4), big.NewInt(0), big.NewInt(7)},
+		{big.NewInt(12345), big.NewInt(65537), priv.N},
+		{priv.D, priv.Precomputed.Dp, priv.Primes[0]},
+	}
+	for _, tc := range testcases {
+		got, want := exp(tc.base, tc.exp, tc.m), GoExp(tc.base, tc.exp, tc.m)
+		if got.Cmp(want) != 0 {
+			t.Errorf("incorrect result for %v**%v mod %v; got %v, want %v", tc.base, tc.exp, tc.m, got, want)
+		}
+	}
+}
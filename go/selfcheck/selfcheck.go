@@ -0,0 +1,176 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package selfcheck verifies the integrity of the extension when it starts:
+// that stored keys use a supported schema, that their recorded fingerprints
+// match their private keys, that the master key cached for the session
+// still decrypts the vault, and that the agent answers requests.  The
+// outcome is summarized as green, amber or red (see keys.Health), displayed
+// on the toolbar icon and stored so that the options page can display it.
+package selfcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// AgentCheck verifies that the agent answers a List request.
+	AgentCheck = "agent"
+	// KeysCheck is reported in place of the checks of stored keys if
+	// they could not be performed.
+	KeysCheck = "keys"
+
+	// ListTimeout is how long the agent is given to answer a List
+	// request.
+	ListTimeout = 10 * time.Second
+)
+
+// Checker performs the checks of stored keys.  See keys.Manager.SelfCheck
+// for details; using this interface allows for alternate implementations
+// during testing.
+type Checker interface {
+	SelfCheck(sample int, callback func(results []*keys.CheckResult, err error))
+}
+
+// Check is the outcome of a single check.
+type Check struct {
+	// Name is the name of the check (e.g., keys.FingerprintCheck).
+	Name string `json:"name"`
+	// Health is the outcome of the check.
+	Health keys.Health `json:"health"`
+	// Detail describes the outcome.
+	Detail string `json:"detail"`
+}
+
+// Report is the outcome of a self-check.
+type Report struct {
+	// Time is the time at which the self-check completed, in
+	// milliseconds since the Unix epoch.
+	Time int64 `json:"time"`
+	// Health is the worst outcome of any check.
+	Health keys.Health `json:"health"`
+	// Checks are the outcomes of the individual checks.
+	Checks []*Check `json:"checks"`
+}
+
+// Problems describes the checks that were not healthy.
+func (r *Report) Problems() []string {
+	var result []string
+	for _, c := range r.Checks {
+		if c.Health != keys.Healthy {
+			result = append(result, fmt.Sprintf("%s: %s", c.Name, c.Detail))
+		}
+	}
+	return result
+}
+
+// add appends the outcome of a check to the report.
+func (r *Report) add(name string, health keys.Health, detail string) {
+	r.Checks = append(r.Checks, &Check{Name: name, Health: health, Detail: detail})
+	r.Health = r.Health.Worse(health)
+}
+
+// Run performs the self-check.  The stored keys are checked by mgr,
+// recomputing the fingerprints of up to sample keys, and a List request is
+// issued to a.  now supplies the time at which the check completes.
+// callback is invoked with the report, from a separate goroutine.
+func Run(mgr Checker, a agent.Agent, sample int, now func() time.Time, callback func(r *Report)) {
+	r := &Report{Health: keys.Healthy}
+	mgr.SelfCheck(sample, func(results []*keys.CheckResult, err error) {
+		if err != nil {
+			r.add(KeysCheck, keys.Failing, fmt.Sprintf("failed to check stored keys: %v", err))
+		}
+		for _, res := range results {
+			r.add(res.Name, res.Health, res.Detail)
+		}
+
+		// The agent may block (e.g., while the keyring is busy), so
+		// it is queried from a separate goroutine.
+		go func() {
+			health, detail := checkAgent(a)
+			r.add(AgentCheck, health, detail)
+			r.Time = now().UnixNano() / int64(time.Millisecond)
+			callback(r)
+		}()
+	})
+}
+
+// checkAgent issues a List request to a, and reports whether it was
+// answered within ListTimeout.
+func checkAgent(a agent.Agent) (keys.Health, string) {
+	type listed struct {
+		keys []*agent.Key
+		err  error
+	}
+	done := make(chan listed, 1)
+	go func() {
+		keys, err := a.List()
+		done <- listed{keys: keys, err: err}
+	}()
+
+	select {
+	case l := <-done:
+		if l.err != nil {
+			return keys.Failing, fmt.Sprintf("agent failed to list keys: %v", l.err)
+		}
+		return keys.Healthy, fmt.Sprintf("agent listed %d keys", len(l.keys))
+	case <-time.After(ListTimeout):
+		return keys.Failing, fmt.Sprintf("agent did not answer within %v", ListTimeout)
+	}
+}
+
+// WriteReport stores the report in store, replacing any earlier report.
+// callback is invoked when complete.
+func WriteReport(store storage.Settings, r *Report, callback func(err error)) {
+	enc, err := json.Marshal(r)
+	if err != nil {
+		callback(fmt.Errorf("failed to encode self-check report: %v", err))
+		return
+	}
+	store.Set(map[string]interface{}{ReportKey: string(enc)}, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to write self-check report: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
+
+// ReadReport reads the most recent report from store.  callback is invoked
+// with a nil report if none has been stored.
+func ReadReport(store storage.Settings, callback func(r *Report, err error)) {
+	store.GetItems([]string{ReportKey}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(nil, fmt.Errorf("failed to read self-check report: %v", err))
+			return
+		}
+		enc, ok := data[ReportKey].(string)
+		if !ok {
+			callback(nil, nil)
+			return
+		}
+		var r Report
+		if err := json.Unmarshal([]byte(enc), &r); err != nil {
+			callback(nil, fmt.Errorf("failed to decode self-check report: %v", err))
+			return
+		}
+		callback(&r, nil)
+	})
+}
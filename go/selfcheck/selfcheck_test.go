@@ -0,0 +1,175 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selfcheck
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type fakeChecker struct {
+	results []*keys.CheckResult
+	err     error
+	sample  int
+}
+
+func (f *fakeChecker) SelfCheck(sample int, callback func(results []*keys.CheckResult, err error)) {
+	f.sample = sample
+	callback(f.results, f.err)
+}
+
+// brokenAgent fails every List request.
+type brokenAgent struct {
+	agent.Agent
+}
+
+func (b *brokenAgent) List() ([]*agent.Key, error) {
+	return nil, errors.New("keyring unavailable")
+}
+
+func TestRun(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	healthySchema := &keys.CheckResult{Name: keys.SchemaCheck, Health: keys.Healthy, Detail: "schema version 1"}
+
+	testcases := []struct {
+		description string
+		checker     *fakeChecker
+		agent       agent.Agent
+		want        *Report
+	}{
+		{
+			description: "healthy",
+			checker:     &fakeChecker{results: []*keys.CheckResult{healthySchema}},
+			agent:       agent.NewKeyring(),
+			want: &Report{
+				Time:   1500000000000,
+				Health: keys.Healthy,
+				Checks: []*Check{
+					{Name: keys.SchemaCheck, Health: keys.Healthy, Detail: "schema version 1"},
+					{Name: AgentCheck, Health: keys.Healthy, Detail: "agent listed 0 keys"},
+				},
+			},
+		},
+		{
+			description: "degraded check",
+			checker: &fakeChecker{results: []*keys.CheckResult{
+				healthySchema,
+				{Name: keys.VaultCheck, Health: keys.Degraded, Detail: "unlock again"},
+			}},
+			agent: agent.NewKeyring(),
+			want: &Report{
+				Time:   1500000000000,
+				Health: keys.Degraded,
+				Checks: []*Check{
+					{Name: keys.SchemaCheck, Health: keys.Healthy, Detail: "schema version 1"},
+					{Name: keys.VaultCheck, Health: keys.Degraded, Detail: "unlock again"},
+					{Name: AgentCheck, Health: keys.Healthy, Detail: "agent listed 0 keys"},
+				},
+			},
+		},
+		{
+			description: "stored keys not checked",
+			checker:     &fakeChecker{err: errors.New("timed out")},
+			agent:       agent.NewKeyring(),
+			want: &Report{
+				Time:   1500000000000,
+				Health: keys.Failing,
+				Checks: []*Check{
+					{Name: KeysCheck, Health: keys.Failing, Detail: "failed to check stored keys: timed out"},
+					{Name: AgentCheck, Health: keys.Healthy, Detail: "agent listed 0 keys"},
+				},
+			},
+		},
+		{
+			description: "agent fails",
+			checker:     &fakeChecker{results: []*keys.CheckResult{healthySchema}},
+			agent:       &brokenAgent{},
+			want: &Report{
+				Time:   1500000000000,
+				Health: keys.Failing,
+				Checks: []*Check{
+					{Name: keys.SchemaCheck, Health: keys.Healthy, Detail: "schema version 1"},
+					{Name: AgentCheck, Health: keys.Failing, Detail: "agent failed to list keys: keyring unavailable"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		reports := make(chan *Report, 1)
+		Run(tc.checker, tc.agent, 5, func() time.Time { return now }, func(r *Report) {
+			reports <- r
+		})
+		got := <-reports
+		if tc.checker.sample != 5 {
+			t.Errorf("%s: incorrect sample; got %d, want 5", tc.description, tc.checker.sample)
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect report; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestProblems(t *testing.T) {
+	r := &Report{
+		Health: keys.Failing,
+		Checks: []*Check{
+			{Name: keys.SchemaCheck, Health: keys.Healthy, Detail: "schema version 1"},
+			{Name: keys.VaultCheck, Health: keys.Degraded, Detail: "unlock again"},
+			{Name: AgentCheck, Health: keys.Failing, Detail: "no answer"},
+		},
+	}
+	want := []string{"vault: unlock again", "agent: no answer"}
+	if diff := pretty.Diff(r.Problems(), want); diff != nil {
+		t.Errorf("incorrect problems; -got +want: %s", diff)
+	}
+}
+
+func TestReport(t *testing.T) {
+	store := fakes.NewMemStorage()
+	ReadReport(store, func(r *Report, err error) {
+		if err != nil {
+			t.Errorf("failed to read missing report: %v", err)
+		}
+		if r != nil {
+			t.Errorf("unexpected report before one was written: %+v", r)
+		}
+	})
+
+	want := &Report{
+		Time:   1000,
+		Health: keys.Degraded,
+		Checks: []*Check{{Name: keys.VaultCheck, Health: keys.Degraded, Detail: "unlock again"}},
+	}
+	WriteReport(store, want, func(err error) {
+		if err != nil {
+			t.Errorf("failed to write report: %v", err)
+		}
+	})
+	ReadReport(store, func(r *Report, err error) {
+		if err != nil {
+			t.Errorf("failed to read report: %v", err)
+		}
+		if diff := pretty.Diff(r, want); diff != nil {
+			t.Errorf("incorrect report; -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selfcheck
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/storage"
+)
+
+const (
+	// EnabledKey is the key under which whether the self-check runs at
+	// startup is stored.  It is stored per-device; it is never synced.
+	EnabledKey = "selfCheck.enabled"
+	// SampleKey is the key under which the number of keys whose
+	// fingerprints are recomputed is stored.  It is stored per-device.
+	SampleKey = "selfCheck.sample"
+	// ReportKey is the key under which the most recent report is
+	// stored.  It is stored per-device.
+	ReportKey = "selfCheck.report"
+
+	// MaxSample is the largest number of keys that may be sampled.
+	// Parsing private keys is slow in JavaScript, so it is kept small
+	// to avoid delaying startup.
+	MaxSample = 20
+)
+
+// Settings configure the self-check.
+type Settings struct {
+	// Enabled indicates that the self-check runs at startup.
+	Enabled bool
+	// Sample is the number of keys whose fingerprints are recomputed.
+	Sample int
+}
+
+// DefaultSettings are used if none have been configured: the self-check runs
+// at startup, sampling three keys.
+var DefaultSettings = Settings{Enabled: true, Sample: 3}
+
+// Changed determines if a change to storage affects the settings.
+func Changed(changes map[string]interface{}) bool {
+	_, enabled := changes[EnabledKey]
+	_, sample := changes[SampleKey]
+	return enabled || sample
+}
+
+// ReportChanged determines if a change to storage includes a new report.
+func ReportChanged(changes map[string]interface{}) bool {
+	_, ok := changes[ReportKey]
+	return ok
+}
+
+// ReadSettings reads the settings from store.  The default settings are
+// used for those that have not been configured (or are invalid).
+func ReadSettings(store storage.Settings, callback func(s *Settings, err error)) {
+	store.GetItems([]string{EnabledKey, SampleKey}, func(data map[string]interface{}, err error) {
+		s := DefaultSettings
+		if err != nil {
+			callback(&s, fmt.Errorf("failed to read self-check settings: %v", err))
+			return
+		}
+		if enabled, ok := data[EnabledKey].(bool); ok {
+			s.Enabled = enabled
+		}
+		// Numbers are decoded from storage as float64.
+		if sample, ok := data[SampleKey].(float64); ok && sample >= 0 && sample <= MaxSample {
+			s.Sample = int(sample)
+		}
+		callback(&s, nil)
+	})
+}
+
+// WriteSettings stores the settings in store.  callback is invoked when
+// complete.
+func WriteSettings(store storage.Settings, s *Settings, callback func(err error)) {
+	if s.Sample < 0 || s.Sample > MaxSample {
+		callback(fmt.Errorf("number of keys to check must be between 0 and %d", MaxSample))
+		return
+	}
+	data := map[string]interface{}{
+		EnabledKey: s.Enabled,
+		SampleKey:  float64(s.Sample),
+	}
+	store.Set(data, func(err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to write self-check settings: %v", err))
+			return
+		}
+		callback(nil)
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selfcheck
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/kr/pretty"
+)
+
+func TestReadSettings(t *testing.T) {
+	testcases := []struct {
+		description string
+		stored      map[string]interface{}
+		want        *Settings
+	}{
+		{
+			description: "not configured",
+			want:        &DefaultSettings,
+		},
+		{
+			description: "configured",
+			stored:      map[string]interface{}{EnabledKey: false, SampleKey: float64(10)},
+			want:        &Settings{Enabled: false, Sample: 10},
+		},
+		{
+			description: "sample too large",
+			stored:      map[string]interface{}{SampleKey: float64(100)},
+			want:        &DefaultSettings,
+		},
+		{
+			description: "wrong types",
+			stored:      map[string]interface{}{EnabledKey: "no", SampleKey: "10"},
+			want:        &DefaultSettings,
+		},
+	}
+
+	for _, tc := range testcases {
+		store := fakes.NewMemStorage()
+		if tc.stored != nil {
+			store.Set(tc.stored, func(err error) {
+				if err != nil {
+					t.Fatalf("%s: failed to store settings: %v", tc.description, err)
+				}
+			})
+		}
+		ReadSettings(store, func(s *Settings, err error) {
+			if err != nil {
+				t.Errorf("%s: failed to read settings: %v", tc.description, err)
+			}
+			if diff := pretty.Diff(s, tc.want); diff != nil {
+				t.Errorf("%s: incorrect settings; -got +want: %s", tc.description, diff)
+			}
+		})
+	}
+}
+
+func TestWriteSettings(t *testing.T) {
+	store := fakes.NewMemStorage()
+	WriteSettings(store, &Settings{Enabled: false, Sample: 5}, func(err error) {
+		if err != nil {
+			t.Errorf("failed to write settings: %v", err)
+		}
+	})
+	WriteSettings(store, &Settings{Enabled: true, Sample: MaxSample + 1}, func(err error) {
+		if err == nil {
+			t.Errorf("invalid sample unexpectedly written")
+		}
+	})
+	ReadSettings(store, func(s *Settings, err error) {
+		if err != nil {
+			t.Errorf("failed to read settings: %v", err)
+		}
+		if diff := pretty.Diff(s, &Settings{Enabled: false, Sample: 5}); diff != nil {
+			t.Errorf("incorrect settings; -got +want: %s", diff)
+		}
+	})
+}
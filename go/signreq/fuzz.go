@@ -0,0 +1,45 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build gofuzz
+// +build gofuzz
+
+package signreq
+
+import (
+	"golang.org/x/crypto/ssh"
+)
+
+// Fuzz parses data as the data a client asks the agent to sign, and
+// describes it as it would be described to the user.  It is the entry point
+// for go-fuzz; see the 'fuzz' target in the Makefile.
+func Fuzz(data []byte) int {
+	req, err := Parse(data)
+	if err != nil {
+		return 0
+	}
+	req.Describe()
+
+	// The public key named in the request is compared against the key
+	// asked to sign; it is also parsed when describing the key.
+	pub, err := ssh.ParsePublicKey(req.PublicKey)
+	if err != nil {
+		return 0
+	}
+	ssh.FingerprintSHA256(pub)
+	if !req.MatchesKey(pub.Marshal()) && string(pub.Marshal()) == string(req.PublicKey) {
+		panic("request does not match its own key")
+	}
+	return 1
+}
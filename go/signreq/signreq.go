@@ -0,0 +1,131 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signreq inspects the data that an SSH client asks the agent to
+// sign, so that the user can be shown what they are approving.
+//
+// When authenticating using a public key, the client signs a structure
+// describing the authentication request (see RFC 4252 Section 7):
+//
+//	string    session identifier
+//	byte      SSH_MSG_USERAUTH_REQUEST
+//	string    user name
+//	string    service name
+//	string    "publickey"
+//	boolean   TRUE
+//	string    public key algorithm name
+//	string    public key to be used for authentication
+package signreq
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// msgUserAuthRequest is the SSH_MSG_USERAUTH_REQUEST message number.
+	msgUserAuthRequest = 50
+	// sessionIDLen is the number of bytes of the session identifier
+	// included in descriptions.
+	sessionIDLen = 8
+)
+
+// userAuthRequest is the portion of the signed data following the session
+// identifier.
+type userAuthRequest struct {
+	User      string `sshtype:"50"`
+	Service   string
+	Method    string
+	HasSig    bool
+	Algorithm string
+	PublicKey []byte
+	Rest      []byte `ssh:"rest"`
+}
+
+// Request describes an SSH authentication request.
+type Request struct {
+	// SessionID is the session identifier established by the key
+	// exchange.
+	SessionID []byte
+	// User is the name of the user the client is logging in as.
+	User string
+	// Service is the service being requested (e.g., 'ssh-connection').
+	Service string
+	// Algorithm is the public key algorithm (e.g., 'ssh-ed25519').
+	Algorithm string
+	// PublicKey is the public key material used for authentication.
+	PublicKey []byte
+}
+
+// Parse parses data as an SSH authentication request.  An error is returned
+// if data is not an authentication request; such data may still be
+// legitimately signed (e.g., by 'ssh-keygen -Y sign'), but nothing can be
+// said about what it authorizes.
+func Parse(data []byte) (*Request, error) {
+	var session struct {
+		ID   []byte
+		Rest []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to parse session identifier: %v", err)
+	}
+	if len(session.Rest) == 0 || session.Rest[0] != msgUserAuthRequest {
+		return nil, errors.New("data is not an authentication request")
+	}
+
+	var req userAuthRequest
+	if err := ssh.Unmarshal(session.Rest, &req); err != nil {
+		return nil, fmt.Errorf("failed to parse authentication request: %v", err)
+	}
+	if req.Method != "publickey" || !req.HasSig {
+		return nil, fmt.Errorf("unsupported authentication method %q", req.Method)
+	}
+	if len(req.Rest) != 0 {
+		return nil, errors.New("unexpected data following authentication request")
+	}
+
+	return &Request{
+		SessionID: session.ID,
+		User:      req.User,
+		Service:   req.Service,
+		Algorithm: req.Algorithm,
+		PublicKey: req.PublicKey,
+	}, nil
+}
+
+// Session returns an abbreviated hex-encoded session identifier, suitable for
+// display.
+func (r *Request) Session() string {
+	id := r.SessionID
+	if len(id) > sessionIDLen {
+		return hex.EncodeToString(id[:sessionIDLen]) + "..."
+	}
+	return hex.EncodeToString(id)
+}
+
+// Describe returns a human-readable description of the request.
+func (r *Request) Describe() string {
+	return fmt.Sprintf("log in as %q (service %s, session %s)", r.User, r.Service, r.Session())
+}
+
+// MatchesKey determines if the request authenticates using the public key
+// with the specified public key material.  A mismatch indicates that the
+// client is asking for a signature it will present as coming from another
+// key.
+func (r *Request) MatchesKey(blob []byte) bool {
+	return string(r.PublicKey) == string(blob)
+}
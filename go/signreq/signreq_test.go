@@ -0,0 +1,121 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signreq
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+// authRequest returns the data signed by a client authenticating as user
+// using the specified method.
+func authRequest(sessionID []byte, user, method string, trailing []byte) []byte {
+	data := ssh.Marshal(struct{ ID []byte }{sessionID})
+	data = append(data, ssh.Marshal(userAuthRequest{
+		User:      user,
+		Service:   "ssh-connection",
+		Method:    method,
+		HasSig:    true,
+		Algorithm: "ssh-ed25519",
+		PublicKey: []byte("public-key"),
+	})...)
+	return append(data, trailing...)
+}
+
+func TestParse(t *testing.T) {
+	sessionID := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0xff}
+
+	testcases := []struct {
+		description  string
+		data         []byte
+		want         *Request
+		wantSession  string
+		wantDescribe string
+		wantErr      error
+	}{
+		{
+			description: "authentication request",
+			data:        authRequest(sessionID, "git", "publickey", nil),
+			want: &Request{
+				SessionID: sessionID,
+				User:      "git",
+				Service:   "ssh-connection",
+				Algorithm: "ssh-ed25519",
+				PublicKey: []byte("public-key"),
+			},
+			wantSession:  "0123456789abcdef...",
+			wantDescribe: `log in as "git" (service ssh-connection, session 0123456789abcdef...)`,
+		},
+		{
+			description:  "short session identifier",
+			data:         authRequest([]byte{0xab}, "root", "publickey", nil),
+			want:         &Request{SessionID: []byte{0xab}, User: "root", Service: "ssh-connection", Algorithm: "ssh-ed25519", PublicKey: []byte("public-key")},
+			wantSession:  "ab",
+			wantDescribe: `log in as "root" (service ssh-connection, session ab)`,
+		},
+		{
+			description: "empty",
+			data:        nil,
+			wantErr:     errors.New("failed to parse session identifier: ssh: parse error in message type 0"),
+		},
+		{
+			description: "not an authentication request",
+			data:        ssh.Marshal(struct{ ID []byte }{[]byte("arbitrary data")}),
+			wantErr:     errors.New("data is not an authentication request"),
+		},
+		{
+			description: "other authentication method",
+			data:        authRequest(sessionID, "git", "hostbased", nil),
+			wantErr:     errors.New(`unsupported authentication method "hostbased"`),
+		},
+		{
+			description: "trailing data",
+			data:        authRequest(sessionID, "git", "publickey", []byte("extra")),
+			wantErr:     errors.New("unexpected data following authentication request"),
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := Parse(tc.data)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if err != nil {
+			continue
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect request; -got +want: %s", tc.description, diff)
+		}
+		if got := got.Session(); got != tc.wantSession {
+			t.Errorf("%s: incorrect session; got %q, want %q", tc.description, got, tc.wantSession)
+		}
+		if got := got.Describe(); got != tc.wantDescribe {
+			t.Errorf("%s: incorrect description; got %q, want %q", tc.description, got, tc.wantDescribe)
+		}
+	}
+}
+
+func TestMatchesKey(t *testing.T) {
+	r := &Request{PublicKey: []byte("public-key")}
+	if !r.MatchesKey([]byte("public-key")) {
+		t.Errorf("MatchesKey returned false for same key")
+	}
+	if r.MatchesKey([]byte("other-key")) {
+		t.Errorf("MatchesKey returned true for different key")
+	}
+}
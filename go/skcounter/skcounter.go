@@ -0,0 +1,143 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package skcounter tracks the signature counters reported by security keys
+// (i.e., FIDO authenticators backing 'sk-' SSH keys).  An authenticator
+// increments its counter every time it signs; a counter that fails to
+// increase suggests that the key has been cloned.
+//
+// Counters are persisted so that regressions are detected across restarts.
+// Each regression is recorded in the audit log, and reported to a warning
+// callback so that the user can be notified.
+package skcounter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+// IsSecurityKey determines if a key or signature format denotes a key
+// backed by a security key (e.g., 'sk-ecdsa-sha2-nistp256@openssh.com').
+func IsSecurityKey(format string) bool {
+	return strings.HasPrefix(format, "sk-")
+}
+
+// Signature is a signature produced by a security key.  See PROTOCOL.u2f in
+// the OpenSSH sources for details on the format.
+type Signature struct {
+	// Format is the signature format.
+	Format string
+	// Blob is the signature itself.
+	Blob []byte
+	// Flags are the flags reported by the authenticator (e.g., whether
+	// user presence was verified).
+	Flags byte
+	// Counter is the authenticator's signature counter.
+	Counter uint32
+}
+
+// ParseSignature parses a signature produced by a security key, in wire
+// format.
+func ParseSignature(b []byte) (*Signature, error) {
+	var s Signature
+	if err := ssh.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse signature: %v", err)
+	}
+	if !IsSecurityKey(s.Format) {
+		return nil, fmt.Errorf("signature format %s is not a security key format", s.Format)
+	}
+	return &s, nil
+}
+
+const (
+	// counterPrefix is the prefix for the keys under which counters are
+	// stored. The full key is of the form 'skcounter.<fingerprint>'.
+	counterPrefix = "skcounter."
+)
+
+// ErrRegressed is returned when a signature counter does not exceed the
+// last counter observed for the same key.
+var ErrRegressed = errors.New("signature counter did not increase; the security key may have been cloned")
+
+// Tracker records the last signature counter observed for each security key.
+type Tracker struct {
+	store storage.Settings
+	audit *audit.Log
+	warn  func(fingerprint string, last, current uint32)
+}
+
+// NewTracker returns a Tracker that persists counters in the supplied
+// storage.  Regressions are recorded in auditLog, and reported by invoking
+// warn; either may be nil.
+func NewTracker(store storage.Settings, auditLog *audit.Log, warn func(fingerprint string, last, current uint32)) *Tracker {
+	return &Tracker{
+		store: store,
+		audit: auditLog,
+		warn:  warn,
+	}
+}
+
+// storageKey returns the key under which the counter for a key is stored.
+func storageKey(fingerprint string) string {
+	return counterPrefix + fingerprint
+}
+
+// Observe checks the counter in a signature produced by the specified
+// security key against the last counter observed, and records it.  callback
+// is invoked with ErrRegressed if the counter did not increase.
+//
+// Some authenticators do not implement a counter and always report zero;
+// such signatures are never considered to be regressions.
+func (t *Tracker) Observe(pub ssh.PublicKey, sig *Signature, callback func(err error)) {
+	fp := ssh.FingerprintSHA256(pub)
+	key := storageKey(fp)
+	t.store.GetItems([]string{key}, func(data map[string]interface{}, err error) {
+		if err != nil {
+			callback(fmt.Errorf("failed to read signature counter: %v", err))
+			return
+		}
+
+		var last uint32
+		if v, ok := data[key].(float64); ok {
+			last = uint32(v)
+		}
+		if sig.Counter == 0 && last == 0 {
+			callback(nil)
+			return
+		}
+		if sig.Counter <= last {
+			if t.audit != nil {
+				t.audit.Record(audit.NewEntry("sk-counter", "agent", fp, false, fmt.Sprintf("counter regressed from %d to %d", last, sig.Counter)), nil)
+			}
+			if t.warn != nil {
+				t.warn(fp, last, sig.Counter)
+			}
+			callback(ErrRegressed)
+			return
+		}
+
+		t.store.Set(map[string]interface{}{key: float64(sig.Counter)}, func(err error) {
+			if err != nil {
+				callback(fmt.Errorf("failed to write signature counter: %v", err))
+				return
+			}
+			callback(nil)
+		})
+	})
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skcounter
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+)
+
+const skFormat = "sk-ecdsa-sha2-nistp256@openssh.com"
+
+func TestParseSignature(t *testing.T) {
+	testcases := []struct {
+		description string
+		sig         []byte
+		want        *Signature
+		wantErr     error
+	}{
+		{
+			description: "security key signature",
+			sig:         ssh.Marshal(&Signature{Format: skFormat, Blob: []byte("sig"), Flags: 1, Counter: 42}),
+			want:        &Signature{Format: skFormat, Blob: []byte("sig"), Flags: 1, Counter: 42},
+		},
+		{
+			description: "other signature format",
+			sig:         ssh.Marshal(&Signature{Format: "ssh-rsa", Blob: []byte("sig")}),
+			wantErr:     errors.New("signature format ssh-rsa is not a security key format"),
+		},
+		{
+			description: "truncated signature",
+			sig:         []byte{0, 0},
+			wantErr:     errors.New("failed to parse signature: ssh: short read"),
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := ParseSignature(tc.sig)
+		if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+			t.Errorf("%s: incorrect error; -got +want: %s", tc.description, diff)
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect signature; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestObserve(t *testing.T) {
+	b, err := base64.StdEncoding.DecodeString(testdata.ValidPrivateKeyBlob)
+	if err != nil {
+		t.Fatalf("failed to decode blob: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+
+	testcases := []struct {
+		description  string
+		counters     []uint32
+		wantErrs     []error
+		wantWarnings int
+		wantAudit    int
+	}{
+		{
+			description: "increasing counters",
+			counters:    []uint32{1, 2, 10},
+			wantErrs:    []error{nil, nil, nil},
+		},
+		{
+			description: "counter not implemented",
+			counters:    []uint32{0, 0},
+			wantErrs:    []error{nil, nil},
+		},
+		{
+			description:  "repeated counter",
+			counters:     []uint32{5, 5},
+			wantErrs:     []error{nil, ErrRegressed},
+			wantWarnings: 1,
+			wantAudit:    1,
+		},
+		{
+			description:  "regressed counter",
+			counters:     []uint32{5, 3, 6},
+			wantErrs:     []error{nil, ErrRegressed, nil},
+			wantWarnings: 1,
+			wantAudit:    1,
+		},
+	}
+
+	for _, tc := range testcases {
+		storage := fakes.NewMemStorage()
+		auditLog := audit.NewLog(fakes.NewMemStorage(), 10)
+		warnings := 0
+		tracker := NewTracker(storage, auditLog, func(fingerprint string, last, current uint32) {
+			warnings++
+		})
+
+		var errs []error
+		for _, c := range tc.counters {
+			tracker.Observe(pub, &Signature{Format: skFormat, Counter: c}, func(err error) {
+				errs = append(errs, err)
+			})
+		}
+		if diff := pretty.Diff(errs, tc.wantErrs); diff != nil {
+			t.Errorf("%s: incorrect errors; -got +want: %s", tc.description, diff)
+		}
+		if warnings != tc.wantWarnings {
+			t.Errorf("%s: incorrect warnings; got %d, want %d", tc.description, warnings, tc.wantWarnings)
+		}
+		auditLog.Entries(func(entries []*audit.Entry, err error) {
+			if err != nil {
+				t.Errorf("%s: failed to read audit log: %v", tc.description, err)
+			}
+			if len(entries) != tc.wantAudit {
+				t.Errorf("%s: incorrect audit entries; got %d, want %d", tc.description, len(entries), tc.wantAudit)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package softtoken derives ED25519 keys deterministically from a passphrase
+// and a service name.  The same passphrase and service name always produce
+// the same key, so a key can be recreated on any device without storing it.
+//
+// The derived key is only as strong as the passphrase: anyone who learns (or
+// guesses) the passphrase can recreate the private key, and the key cannot be
+// revoked without also choosing a new passphrase.  Argon2id is used to make
+// guessing expensive, but it does not make a weak passphrase safe.
+package softtoken
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// MinPassphraseLength is the minimum length of a passphrase from
+	// which a key may be derived.
+	MinPassphraseLength = 12
+
+	// saltPrefix is prepended to the normalized service name to form the
+	// salt.  It is versioned so that the derivation can be changed in the
+	// future without silently producing different keys.
+	saltPrefix = "chrome-ssh-agent/soft-token/v1:"
+
+	// Argon2id parameters.  These must never change for a given
+	// saltPrefix; doing so would change the key derived from every
+	// existing passphrase.
+	argonTime    = 1
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	seedSize     = 32
+)
+
+var (
+	// ErrMissingService indicates that no service name was supplied.
+	ErrMissingService = errors.New("service name must be supplied")
+	// ErrShortPassphrase indicates that the passphrase is too short.
+	ErrShortPassphrase = fmt.Errorf("passphrase must be at least %d characters", MinPassphraseLength)
+)
+
+// NormalizeService returns the canonical form of a service name.  Service
+// names are case-insensitive and ignore surrounding whitespace, so that
+// 'Example.com ' and 'example.com' derive the same key.
+func NormalizeService(service string) string {
+	return strings.ToLower(strings.TrimSpace(service))
+}
+
+// Derive returns the ED25519 private key derived from the passphrase and
+// service name.
+func Derive(passphrase, service string) (ed25519.PrivateKey, error) {
+	service = NormalizeService(service)
+	if service == "" {
+		return nil, ErrMissingService
+	}
+	if len([]rune(passphrase)) < MinPassphraseLength {
+		return nil, ErrShortPassphrase
+	}
+
+	seed := argon2.IDKey([]byte(passphrase), []byte(saltPrefix+service), argonTime, argonMemory, argonThreads, seedSize)
+	_, priv, err := ed25519.GenerateKey(bytes.NewReader(seed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	return priv, nil
+}
+
+// Fingerprint returns the SHA256 fingerprint of the public key corresponding
+// to priv, in the format used by OpenSSH (e.g., 'SHA256:...').
+func Fingerprint(priv ed25519.PrivateKey) (string, error) {
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		return "", fmt.Errorf("failed to convert public key: %v", err)
+	}
+	return ssh.FingerprintSHA256(pub), nil
+}
+
+// MarshalPEM returns priv as an unencrypted PEM-encoded private key in
+// OpenSSH's format.  comment is recorded in the key.
+func MarshalPEM(priv ed25519.PrivateKey, comment string) (string, error) {
+	pub, ok := priv.Public().(ed25519.PublicKey)
+	if !ok {
+		return "", errors.New("failed to determine public key")
+	}
+
+	var check [4]byte
+	if _, err := rand.Read(check[:]); err != nil {
+		return "", fmt.Errorf("failed to generate check value: %v", err)
+	}
+	checkVal := binary.BigEndian.Uint32(check[:])
+
+	privBlock := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Pub     []byte
+		Priv    []byte
+		Comment string
+	}{checkVal, checkVal, ssh.KeyAlgoED25519, pub, priv, comment})
+	// The private section is padded to the cipher block size (8 for the
+	// 'none' cipher) with the bytes 1, 2, 3, ...
+	for i := byte(1); len(privBlock)%8 != 0; i++ {
+		privBlock = append(privBlock, i)
+	}
+
+	pubBlock := ssh.Marshal(struct {
+		Keytype string
+		Pub     []byte
+	}{ssh.KeyAlgoED25519, pub})
+
+	body := ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{"none", "none", "", 1, pubBlock, privBlock})
+
+	block := &pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte("openssh-key-v1\x00"), body...),
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package softtoken
+
+import (
+	"testing"
+
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	passphrase = "correct horse battery staple"
+)
+
+func mustFingerprint(t *testing.T, passphrase, service string) string {
+	priv, err := Derive(passphrase, service)
+	if err != nil {
+		t.Fatalf("Derive(%q) failed: %v", service, err)
+	}
+	fp, err := Fingerprint(priv)
+	if err != nil {
+		t.Fatalf("Fingerprint failed: %v", err)
+	}
+	return fp
+}
+
+func TestDerive(t *testing.T) {
+	testcases := []struct {
+		description string
+		passphrase  string
+		service     string
+		wantErr     error
+	}{
+		{
+			description: "valid",
+			passphrase:  passphrase,
+			service:     "example.com",
+		},
+		{
+			description: "missing service",
+			passphrase:  passphrase,
+			service:     "  ",
+			wantErr:     ErrMissingService,
+		},
+		{
+			description: "short passphrase",
+			passphrase:  "too short",
+			service:     "example.com",
+			wantErr:     ErrShortPassphrase,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := Derive(tc.passphrase, tc.service)
+			if diff := pretty.Diff(err, tc.wantErr); diff != nil {
+				t.Errorf("incorrect error; -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestDeriveDeterministic(t *testing.T) {
+	base := mustFingerprint(t, passphrase, "example.com")
+
+	testcases := []struct {
+		description string
+		passphrase  string
+		service     string
+		wantSame    bool
+	}{
+		{
+			description: "same inputs",
+			passphrase:  passphrase,
+			service:     "example.com",
+			wantSame:    true,
+		},
+		{
+			description: "service differs only in case and whitespace",
+			passphrase:  passphrase,
+			service:     " Example.COM ",
+			wantSame:    true,
+		},
+		{
+			description: "different service",
+			passphrase:  passphrase,
+			service:     "example.org",
+		},
+		{
+			description: "different passphrase",
+			passphrase:  passphrase + "!",
+			service:     "example.com",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.description, func(t *testing.T) {
+			fp := mustFingerprint(t, tc.passphrase, tc.service)
+			if same := fp == base; same != tc.wantSame {
+				t.Errorf("incorrect result; got same fingerprint %v, want %v", same, tc.wantSame)
+			}
+		})
+	}
+}
+
+func TestMarshalPEM(t *testing.T) {
+	priv, err := Derive(passphrase, "example.com")
+	if err != nil {
+		t.Fatalf("Derive failed: %v", err)
+	}
+
+	pemKey, err := MarshalPEM(priv, "some-comment")
+	if err != nil {
+		t.Fatalf("MarshalPEM failed: %v", err)
+	}
+
+	parsed, err := ssh.ParseRawPrivateKey([]byte(pemKey))
+	if err != nil {
+		t.Fatalf("failed to parse marshalled key: %v", err)
+	}
+	got, ok := parsed.(*ed25519.PrivateKey)
+	if !ok {
+		t.Fatalf("incorrect key type; got %T, want *ed25519.PrivateKey", parsed)
+	}
+	if diff := pretty.Diff(*got, priv); diff != nil {
+		t.Errorf("incorrect private key; -got +want: %s", diff)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command sshadd manages the keys loaded in the extension's SSH Agent, and
+// accepts the commonly-used flags of OpenSSH's ssh-add.  It connects to the
+// socket served by the native messaging host (see nativehost).
+//
+// Keys added using sshadd are loaded into the agent, but are not configured
+// in the extension; they are lost when the browser restarts.
+package main
+
+import (
+	"bufio"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/chrome-ssh-agent/go/nativemsg"
+	"github.com/google/chrome-ssh-agent/go/provider"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	list        = flag.Bool("l", false, "List fingerprints of all loaded keys")
+	listPublic  = flag.Bool("L", false, "List public keys of all loaded keys")
+	remove      = flag.Bool("d", false, "Remove the keys in the specified files")
+	removeAll   = flag.Bool("D", false, "Remove all loaded keys")
+	lifetime    = flag.String("t", "", "Lifetime of added keys (e.g., 30m, 1h, 1d)")
+	confirm     = flag.Bool("c", false, "Require confirmation before each use of added keys")
+	lock        = flag.Bool("x", false, "Lock the agent with a password")
+	unlock      = flag.Bool("X", false, "Unlock the agent")
+	defaultKeys = []string{"id_rsa", "id_ecdsa", "id_ed25519", "id_dsa"}
+
+	// stdin reads passwords when standard input is not a terminal.
+	stdin = bufio.NewReader(os.Stdin)
+)
+
+// Exit codes match those of ssh-add.
+const (
+	exitFailure      = 1
+	exitNotConnected = 2
+)
+
+// lifetimeUnits maps the units accepted in a lifetime to their length in
+// seconds.
+var lifetimeUnits = map[rune]uint64{
+	's': 1,
+	'm': 60,
+	'h': 60 * 60,
+	'd': 24 * 60 * 60,
+	'w': 7 * 24 * 60 * 60,
+}
+
+// parseLifetime parses a lifetime in the format used by ssh-add: a sequence
+// of numbers, each followed by an optional unit (s, m, h, d or w;
+// case-insensitive).  Numbers without a unit are seconds.  For example,
+// '1h30m' is 5400 seconds.
+func parseLifetime(s string) (uint32, error) {
+	if s == "" {
+		return 0, errors.New("lifetime must not be empty")
+	}
+
+	var total uint64
+	var num string
+	for _, r := range s {
+		if unicode.IsDigit(r) {
+			num += string(r)
+			continue
+		}
+		mult, ok := lifetimeUnits[unicode.ToLower(r)]
+		if !ok || num == "" {
+			return 0, fmt.Errorf("invalid lifetime %q", s)
+		}
+		n, err := strconv.ParseUint(num, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid lifetime %q: %v", s, err)
+		}
+		total += n * mult
+		num = ""
+	}
+	if num != "" {
+		n, err := strconv.ParseUint(num, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid lifetime %q: %v", s, err)
+		}
+		total += n
+	}
+	if total == 0 || total > 0xffffffff {
+		return 0, fmt.Errorf("invalid lifetime %q", s)
+	}
+	return uint32(total), nil
+}
+
+// keyBits returns the size of the key in bits, or 0 if it is unknown.
+func keyBits(pub ssh.PublicKey) int {
+	cpk, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	switch k := cpk.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *dsa.PublicKey:
+		return k.P.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	}
+	return 0
+}
+
+// keyTypeNames maps key algorithms to the names displayed by ssh-add.
+var keyTypeNames = map[string]string{
+	ssh.KeyAlgoRSA:      "RSA",
+	ssh.KeyAlgoDSA:      "DSA",
+	ssh.KeyAlgoECDSA256: "ECDSA",
+	ssh.KeyAlgoECDSA384: "ECDSA",
+	ssh.KeyAlgoECDSA521: "ECDSA",
+	ssh.KeyAlgoED25519:  "ED25519",
+}
+
+// describeKey returns the description of a loaded key displayed by 'ssh-add
+// -l'.
+func describeKey(k *agent.Key) (string, error) {
+	pub, err := ssh.ParsePublicKey(k.Blob)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse public key: %v", err)
+	}
+	name := keyTypeNames[k.Format]
+	if name == "" {
+		name = k.Format
+	}
+	comment := k.Comment
+	if comment == "" {
+		comment = "(no comment)"
+	}
+	return fmt.Sprintf("%d %s %s (%s)", keyBits(pub), ssh.FingerprintSHA256(pub), comment, name), nil
+}
+
+// readPassword prompts for a password on the terminal.
+func readPassword(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return terminal.ReadPassword(int(os.Stdin.Fd()))
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// publicKeyFile returns the public key for the key in the specified file.
+// As with ssh-add, the public key is read from the corresponding '.pub' file
+// if it exists, and otherwise derived from the private key.
+func publicKeyFile(path string) (ssh.PublicKey, error) {
+	pubPath := path
+	if !strings.HasSuffix(pubPath, ".pub") {
+		pubPath += ".pub"
+	}
+	if b, err := ioutil.ReadFile(pubPath); err == nil {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", pubPath, err)
+		}
+		return pub, nil
+	}
+
+	priv, err := readPrivateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine public key for %s: %v", path, err)
+	}
+	return signer.PublicKey(), nil
+}
+
+// readPrivateKey reads the private key from the specified file, prompting
+// for a passphrase if it is encrypted.
+func readPrivateKey(path string) (interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := provider.NewSoftware(nil)
+	priv, err := p.ParsePrivateKey(b, nil)
+	if err == nil {
+		return priv, nil
+	}
+	passphrase, perr := readPassword(fmt.Sprintf("Enter passphrase for %s: ", path))
+	if perr != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %v", perr)
+	}
+	priv, err = p.ParsePrivateKey(b, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return priv, nil
+}
+
+// run performs the operation requested on the command line, and returns the
+// exit code.
+func run(a agent.Agent, files []string) int {
+	switch {
+	case *list || *listPublic:
+		keys, err := a.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list keys: %v\n", err)
+			return exitFailure
+		}
+		if len(keys) == 0 {
+			fmt.Println("The agent has no identities.")
+			return exitFailure
+		}
+		for _, k := range keys {
+			if *listPublic {
+				fmt.Println(k.String())
+				continue
+			}
+			d, err := describeKey(k)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				continue
+			}
+			fmt.Println(d)
+		}
+		return 0
+
+	case *removeAll:
+		if err := a.RemoveAll(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove all identities: %v\n", err)
+			return exitFailure
+		}
+		fmt.Println("All identities removed.")
+		return 0
+
+	case *lock, *unlock:
+		passphrase, err := readPassword("Enter lock password: ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read password: %v\n", err)
+			return exitFailure
+		}
+		if *unlock {
+			if err := a.Unlock(passphrase); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to unlock agent: %v\n", err)
+				return exitFailure
+			}
+			fmt.Println("Agent unlocked.")
+			return 0
+		}
+		again, err := readPassword("Again: ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read password: %v\n", err)
+			return exitFailure
+		}
+		if string(again) != string(passphrase) {
+			fmt.Fprintln(os.Stderr, "Passwords do not match.")
+			return exitFailure
+		}
+		if err := a.Lock(passphrase); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to lock agent: %v\n", err)
+			return exitFailure
+		}
+		fmt.Println("Agent locked.")
+		return 0
+	}
+
+	var secs uint32
+	if *lifetime != "" {
+		var err error
+		if secs, err = parseLifetime(*lifetime); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitFailure
+		}
+	}
+
+	explicit := len(files) > 0
+	if !explicit {
+		for _, n := range defaultKeys {
+			files = append(files, filepath.Join(os.Getenv("HOME"), ".ssh", n))
+		}
+	}
+
+	status := 0
+	for _, f := range files {
+		if !explicit {
+			if _, err := os.Stat(f); err != nil {
+				continue
+			}
+		}
+
+		if *remove {
+			pub, err := publicKeyFile(f)
+			if err == nil {
+				err = a.Remove(pub)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not remove identity \"%s\": %v\n", f, err)
+				status = exitFailure
+				continue
+			}
+			fmt.Printf("Identity removed: %s\n", f)
+			continue
+		}
+
+		priv, err := readPrivateKey(f)
+		if err == nil {
+			err = a.Add(agent.AddedKey{
+				PrivateKey:       priv,
+				Comment:          f,
+				LifetimeSecs:     secs,
+				ConfirmBeforeUse: *confirm,
+			})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not add identity \"%s\": %v\n", f, err)
+			status = exitFailure
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Identity added: %s\n", f)
+		if secs != 0 {
+			fmt.Fprintf(os.Stderr, "Lifetime set to %d seconds\n", secs)
+		}
+		if *confirm {
+			fmt.Fprintln(os.Stderr, "The user must confirm each use of the key")
+		}
+	}
+	return status
+}
+
+func main() {
+	flag.Parse()
+
+	path := nativemsg.DefaultSocketPath()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open a connection to the extension's agent at %s: %v\n", path, err)
+		os.Exit(exitNotConnected)
+	}
+	status := run(agent.NewClient(conn), flag.Args())
+	conn.Close()
+	os.Exit(status)
+}
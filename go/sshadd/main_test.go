@@ -0,0 +1,85 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestParseLifetime(t *testing.T) {
+	testcases := []struct {
+		lifetime string
+		want     uint32
+		wantErr  bool
+	}{
+		{lifetime: "30", want: 30},
+		{lifetime: "30s", want: 30},
+		{lifetime: "10m", want: 600},
+		{lifetime: "1h30m", want: 5400},
+		{lifetime: "1D", want: 86400},
+		{lifetime: "2w", want: 1209600},
+		{lifetime: "1h30", want: 3630},
+		{lifetime: "", wantErr: true},
+		{lifetime: "0", wantErr: true},
+		{lifetime: "h", wantErr: true},
+		{lifetime: "10y", wantErr: true},
+		{lifetime: "-5", wantErr: true},
+		{lifetime: "99999999w", wantErr: true},
+	}
+
+	for _, tc := range testcases {
+		got, err := parseLifetime(tc.lifetime)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: incorrect error; got %v, want error %v", tc.lifetime, err, tc.wantErr)
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%q: incorrect lifetime; -got +want: %s", tc.lifetime, diff)
+		}
+	}
+}
+
+func TestDescribeKey(t *testing.T) {
+	a := agent.NewKeyring()
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ValidPrivateKeyWithoutPassphrase))
+	if err != nil {
+		t.Fatalf("failed to parse private key: %v", err)
+	}
+	if err := a.Add(agent.AddedKey{PrivateKey: priv, Comment: "some-key"}); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	keys, err := a.List()
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+
+	got, err := describeKey(keys[0])
+	if err != nil {
+		t.Fatalf("describeKey failed: %v", err)
+	}
+	pub, err := ssh.ParsePublicKey(keys[0].Blob)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+	want := ssh.FingerprintSHA256(pub) + " some-key (RSA)"
+	if !strings.HasSuffix(got, want) || !strings.HasPrefix(got, "2048 ") {
+		t.Errorf("incorrect description; got %q, want '2048 %s'", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshtest
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// buffer is one direction of a conn.  Unlike net.Pipe, writes never block
+// waiting for a reader; both ends of an SSH connection send their version
+// and key exchange messages before reading the other's.
+type buffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	data   bytes.Buffer
+	closed bool
+}
+
+func newBuffer() *buffer {
+	b := &buffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *buffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.data.Len() == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.data.Len() == 0 {
+		return 0, io.EOF
+	}
+	return b.data.Read(p)
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := b.data.Write(p)
+	b.cond.Broadcast()
+	return n, err
+}
+
+func (b *buffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// addr is the address of both ends of a conn.
+type addr struct{}
+
+func (addr) Network() string { return "sshtest" }
+func (addr) String() string  { return "sshtest" }
+
+// conn is one end of an in-memory, buffered connection created by pipe.
+type conn struct {
+	r, w *buffer
+}
+
+// pipe returns the two ends of an in-memory connection.  Closing either end
+// closes the connection in both directions.
+func pipe() (net.Conn, net.Conn) {
+	a, b := newBuffer(), newBuffer()
+	return &conn{r: a, w: b}, &conn{r: b, w: a}
+}
+
+func (c *conn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *conn) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+func (c *conn) Close() error {
+	c.r.Close()
+	c.w.Close()
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr                { return addr{} }
+func (c *conn) RemoteAddr() net.Addr               { return addr{} }
+func (c *conn) SetDeadline(t time.Time) error      { return nil }
+func (c *conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *conn) SetWriteDeadline(t time.Time) error { return nil }
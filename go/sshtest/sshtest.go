@@ -0,0 +1,155 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sshtest provides an in-process SSH server for tests.  It performs
+// a full publickey authentication, so tests can check that the signatures
+// produced by an agent are accepted by a real SSH server implementation, not
+// merely that they verify.
+package sshtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/chrome-ssh-agent/go/agentport"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// fingerprintExtension is the permissions extension in which the
+	// server records the fingerprint of the key used to authenticate.
+	fingerprintExtension = "fingerprint"
+)
+
+// Server is an in-process SSH server that accepts publickey authentication
+// using a fixed set of authorized keys.  It never accepts any other form of
+// authentication, and does not serve any channels.
+type Server struct {
+	config     *ssh.ServerConfig
+	authorized map[string]bool
+}
+
+// NewServer returns a Server that authorizes the specified keys.  A new host
+// key is generated for each server.
+func NewServer(authorized []ssh.PublicKey) (*Server, error) {
+	host, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create host signer: %v", err)
+	}
+
+	s := &Server{authorized: make(map[string]bool)}
+	for _, k := range authorized {
+		s.authorized[string(k.Marshal())] = true
+	}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: s.checkKey,
+	}
+	s.config.AddHostKey(hostSigner)
+	return s, nil
+}
+
+// checkKey implements ssh.ServerConfig.PublicKeyCallback.  It is invoked
+// only after the client has proven possession of the private key.
+func (s *Server) checkKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if !s.authorized[string(key.Marshal())] {
+		return nil, fmt.Errorf("key %s is not authorized", ssh.FingerprintSHA256(key))
+	}
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			fingerprintExtension: ssh.FingerprintSHA256(key),
+		},
+	}, nil
+}
+
+// Login authenticates to the server as user, using the keys held by a.  It
+// returns the fingerprint of the key the server accepted.
+func (s *Server) Login(user string, a agent.Agent) (string, error) {
+	clientSide, serverSide := pipe()
+	defer clientSide.Close()
+
+	type result struct {
+		fingerprint string
+		err         error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer serverSide.Close()
+		conn, chans, reqs, err := ssh.NewServerConn(serverSide, s.config)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		go func() {
+			for c := range chans {
+				c.Reject(ssh.Prohibited, "no channels are served")
+			}
+		}()
+		done <- result{fingerprint: conn.Permissions.Extensions[fingerprintExtension]}
+		conn.Wait()
+	}()
+
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeysCallback(a.Signers)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(clientSide, "sshtest", config)
+	if err != nil {
+		// The client only reports that authentication failed; the
+		// server knows why.
+		clientSide.Close()
+		if r := <-done; r.err != nil {
+			return "", fmt.Errorf("failed to log in: %v (server: %v)", err, r.err)
+		}
+		return "", fmt.Errorf("failed to log in: %v", err)
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		for c := range chans {
+			c.Reject(ssh.Prohibited, "no channels are served")
+		}
+	}()
+
+	r := <-done
+	if r.err != nil {
+		return "", r.err
+	}
+	if r.fingerprint == "" {
+		return "", errors.New("server did not record the accepted key")
+	}
+	return r.fingerprint, nil
+}
+
+// Connect returns a client that speaks the SSH agent protocol to a, in the
+// same way as an SSH client connected to the extension.  Requests are served
+// by agentport.Serve.  The returned Closer must be closed after use.
+func Connect(a agent.Agent) (agent.Agent, io.Closer) {
+	clientSide, agentSide := pipe()
+	go func() {
+		agentport.Serve(a, agentSide, agentport.DefaultParallelism)
+		agentSide.Close()
+	}()
+	return agent.NewClient(clientSide), clientSide
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/rsaaccel"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newECDSAKey(curve elliptic.Curve) interface{} {
+	priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("failed to generate key: %v", err))
+	}
+	return priv
+}
+
+func newED25519Key() interface{} {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("failed to generate key: %v", err))
+	}
+	return &priv
+}
+
+func parseKey(pemPrivateKey string) interface{} {
+	priv, err := ssh.ParseRawPrivateKey([]byte(pemPrivateKey))
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse private key: %v", err))
+	}
+	return priv
+}
+
+func publicKey(priv interface{}) ssh.PublicKey {
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create signer: %v", err))
+	}
+	return signer.PublicKey()
+}
+
+func TestLogin(t *testing.T) {
+	testcases := []struct {
+		description string
+		key         interface{}
+		rsaExp      rsaaccel.ModExp
+	}{
+		{
+			description: "RSA",
+			key:         parseKey(testdata.ValidPrivateKeyWithoutPassphrase),
+		},
+		{
+			description: "RSA with accelerated signing",
+			key:         parseKey(testdata.ValidPrivateKeyWithoutPassphrase),
+			rsaExp:      rsaaccel.GoExp,
+		},
+		{
+			description: "ECDSA P-256",
+			key:         newECDSAKey(elliptic.P256()),
+		},
+		{
+			description: "ECDSA P-384",
+			key:         newECDSAKey(elliptic.P384()),
+		},
+		{
+			description: "ECDSA P-521",
+			key:         newECDSAKey(elliptic.P521()),
+		},
+		{
+			description: "Ed25519",
+			key:         newED25519Key(),
+		},
+	}
+
+	for _, tc := range testcases {
+		k := keyring.New()
+		if tc.rsaExp != nil {
+			k.SetRSAExp(tc.rsaExp)
+		}
+		if err := k.Add(agent.AddedKey{PrivateKey: tc.key, Comment: "some-key"}); err != nil {
+			t.Errorf("%s: failed to add key: %v", tc.description, err)
+			continue
+		}
+		cli, conn := Connect(k)
+
+		pub := publicKey(tc.key)
+		srv, err := NewServer([]ssh.PublicKey{pub})
+		if err != nil {
+			t.Fatalf("%s: failed to create server: %v", tc.description, err)
+		}
+		got, err := srv.Login("git", cli)
+		if err != nil {
+			t.Errorf("%s: failed to log in: %v", tc.description, err)
+		} else if want := ssh.FingerprintSHA256(pub); got != want {
+			t.Errorf("%s: server accepted incorrect key; got %s, want %s", tc.description, got, want)
+		}
+		conn.Close()
+	}
+}
+
+func TestLoginUnauthorized(t *testing.T) {
+	k := keyring.New()
+	if err := k.Add(agent.AddedKey{PrivateKey: parseKey(testdata.ValidPrivateKeyWithoutPassphrase)}); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	cli, conn := Connect(k)
+	defer conn.Close()
+
+	srv, err := NewServer([]ssh.PublicKey{publicKey(newED25519Key())})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if _, err := srv.Login("git", cli); err == nil {
+		t.Errorf("login unexpectedly succeeded with an unauthorized key")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage defines the interfaces through which the extension reads
+// and writes a storage area.  They are implemented by chrome.Storage; using
+// them allows for alternate implementations during testing.
+package storage
+
+// Settings is the subset of Store used to read and write individual
+// settings.
+type Settings interface {
+	// Set stores new data. See chrome.Storage.Set() for details.
+	Set(data map[string]interface{}, callback func(err error))
+
+	// GetItems gets the data with the specified keys from storage. See
+	// chrome.Storage.GetItems() for details.
+	GetItems(keys []string, callback func(data map[string]interface{}, err error))
+}
+
+// Store provides access to the data in a storage area.
+type Store interface {
+	Settings
+
+	// Get gets data from storage. See chrome.Storage.Get() for details.
+	Get(callback func(data map[string]interface{}, err error))
+
+	// Delete deletes data from storage. See chrome.Storage.Delete() for
+	// details.
+	Delete(keys []string, callback func(err error))
+}
+
+// Area provides access to a storage area, including the space it uses.
+type Area interface {
+	Store
+
+	// BytesInUse gets the amount of space used. See
+	// chrome.Storage.BytesInUse() for details.
+	BytesInUse(keys []string, callback func(bytes int, err error))
+
+	// Quota returns the storage limits. See chrome.Storage.Quota() for
+	// details.
+	Quota() (total, perItem int)
+}
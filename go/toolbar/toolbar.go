@@ -0,0 +1,242 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package toolbar displays the state of the agent on the extension's toolbar
+// icon: the number of loaded keys, whether the agent is locked, problems
+// found by the startup self-check, and alerts that need the user's
+// attention (e.g., requests denied by policy).  The
+// state is driven by subscribing to changes in the keyring and entries in
+// the audit log.
+package toolbar
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// API provides access to the extension's toolbar icon.  See
+// chrome.C.SetBadgeText, chrome.C.SetBadgeBackgroundColor, and
+// chrome.C.SetTitle for details.
+type API interface {
+	SetBadgeText(text string)
+	SetBadgeBackgroundColor(color string)
+	SetTitle(title string)
+}
+
+const (
+	// lockedText is displayed on the badge while the agent is locked.
+	lockedText = "\U0001F512"
+	// alertText is displayed on the badge if there are alerts, but
+	// nothing else to display.
+	alertText = "!"
+
+	// normalColor is the badge color when there is nothing to alert.
+	normalColor = "#1a73e8"
+	// lockedColor is the badge color while the agent is locked.
+	lockedColor = "#5f6368"
+	// alertColor is the badge color while there are alerts, or the
+	// self-check failed.
+	alertColor = "#d93025"
+	// warningColor is the badge color while the self-check reports a
+	// problem that does not prevent keys from being used.
+	warningColor = "#f9ab00"
+
+	// maxAlerts is the number of most recent alerts displayed in the
+	// tooltip.
+	maxAlerts = 3
+)
+
+// Status tracks the state displayed on the toolbar icon.
+type Status struct {
+	api API
+
+	mu     sync.Mutex
+	keys   int
+	locked bool
+	// health is the outcome of the most recent self-check, and problems
+	// describes the checks that were not healthy.
+	health   keys.Health
+	problems []string
+	// alerts are the alerts the user has not yet seen, oldest first.
+	alerts []string
+}
+
+// New returns a Status that displays state on the toolbar icon using api,
+// starting with an empty, unlocked keyring.
+func New(api API) *Status {
+	s := &Status{api: api}
+	s.renderLocked()
+	return s
+}
+
+// KeyringChanged updates the displayed state to reflect a new state of the
+// keyring.  It is suitable for use with keyring.Keyring.Subscribe.
+func (s *Status) KeyringChanged(state *keyring.State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = state.Keys
+	s.locked = state.Locked
+	s.renderLocked()
+}
+
+// SetHealth displays the outcome of a self-check.  problems describes the
+// checks that were not healthy (see selfcheck.Report.Problems); nothing is
+// displayed if there are none.
+func (s *Status) SetHealth(health keys.Health, problems []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.health = health
+	s.problems = problems
+	s.renderLocked()
+}
+
+// Audited raises an alert for entries in the audit log that record a
+// denied request (e.g., a key rejected by policy).  It is suitable for use
+// with audit.Log.Subscribe.
+func (s *Status) Audited(e *audit.Entry) {
+	if e.Allowed {
+		return
+	}
+	s.Alert(fmt.Sprintf("Denied %s for %s: %s", e.Action, e.Requester, e.Detail))
+}
+
+// Alert raises an alert with the specified message.  Alerts are displayed
+// until ClearAlerts is invoked.
+func (s *Status) Alert(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.alerts = append(s.alerts, message)
+	s.renderLocked()
+}
+
+// ClearAlerts removes all alerts, once the user has seen them.
+func (s *Status) ClearAlerts() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.alerts = nil
+	s.renderLocked()
+}
+
+// renderLocked updates the toolbar icon to display the current state.
+func (s *Status) renderLocked() {
+	text, color := "", normalColor
+	var title []string
+	switch {
+	case s.locked:
+		text, color = lockedText, lockedColor
+		title = append(title, "Agent is locked")
+	case s.keys == 1:
+		text = "1"
+		title = append(title, "1 key loaded")
+	case s.keys > 0:
+		text = strconv.Itoa(s.keys)
+		title = append(title, fmt.Sprintf("%d keys loaded", s.keys))
+	default:
+		title = append(title, "No keys loaded")
+	}
+
+	if len(s.problems) > 0 {
+		color = warningColor
+		title = append(title, "Self-check found problems")
+		if s.health == keys.Failing {
+			color = alertColor
+			title[len(title)-1] = "Self-check failed"
+		}
+		if text == "" {
+			text = alertText
+		}
+		title = append(title, s.problems...)
+	}
+
+	if len(s.alerts) > 0 {
+		color = alertColor
+		if text == "" {
+			text = alertText
+		}
+		recent := s.alerts
+		if len(recent) > maxAlerts {
+			title = append(title, fmt.Sprintf("%d earlier alerts", len(recent)-maxAlerts))
+			recent = recent[len(recent)-maxAlerts:]
+		}
+		title = append(title, recent...)
+	}
+
+	s.api.SetBadgeText(text)
+	s.api.SetBadgeBackgroundColor(color)
+	s.api.SetTitle(strings.Join(title, "\n"))
+}
+
+// Define a distinct type for each message.  These are embedded in each
+// message, and are distinct from those used by other servers.
+const (
+	msgTypeClearAlerts int = 4000 + iota
+)
+
+// msgHeader are the common fields included in every message (as an embedded
+// type).
+type msgHeader struct {
+	*js.Object
+	Type int `js:"type"`
+}
+
+// MessageReceiver receives messages sent within the extension.  See
+// chrome.C.OnMessage for details.
+type MessageReceiver interface {
+	OnMessage(callback func(header *js.Object, sender *js.Object, sendResponse func(interface{})) bool)
+}
+
+// MessageSender sends messages within the extension.  See
+// chrome.C.SendMessage and chrome.C.Error for details.
+type MessageSender interface {
+	SendMessage(msg interface{}, callback func(rsp *js.Object))
+	Error() error
+}
+
+// Serve allows other extension pages to clear the alerts displayed by
+// status, using ClearAlerts.
+func Serve(status *Status, msg MessageReceiver) {
+	msg.OnMessage(func(headerObj *js.Object, sender *js.Object, sendResponse func(interface{})) bool {
+		header := &msgHeader{Object: headerObj}
+		if header.Type != msgTypeClearAlerts {
+			return false
+		}
+		status.ClearAlerts()
+		sendResponse(header)
+		return false
+	})
+}
+
+// ClearAlerts asks the Status served by Serve (typically in the background
+// page) to clear its alerts.
+func ClearAlerts(msg MessageSender) {
+	header := &msgHeader{Object: js.Global.Get("Object").New()}
+	header.Type = msgTypeClearAlerts
+	msg.SendMessage(header, func(rsp *js.Object) {
+		if err := msg.Error(); err != nil {
+			log.Printf("Failed to clear alerts: %v", err)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package toolbar
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/keyring"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/kr/pretty"
+)
+
+func TestStatus(t *testing.T) {
+	testcases := []struct {
+		description string
+		state       *keyring.State
+		audited     []*audit.Entry
+		health      keys.Health
+		problems    []string
+		alerts      []string
+		want        fakes.Toolbar
+	}{
+		{
+			description: "no keys",
+			want:        fakes.Toolbar{Color: normalColor, Title: "No keys loaded"},
+		},
+		{
+			description: "one key",
+			state:       &keyring.State{Keys: 1},
+			want:        fakes.Toolbar{Badge: "1", Color: normalColor, Title: "1 key loaded"},
+		},
+		{
+			description: "several keys",
+			state:       &keyring.State{Keys: 3},
+			want:        fakes.Toolbar{Badge: "3", Color: normalColor, Title: "3 keys loaded"},
+		},
+		{
+			description: "locked",
+			state:       &keyring.State{Locked: true},
+			want:        fakes.Toolbar{Badge: lockedText, Color: lockedColor, Title: "Agent is locked"},
+		},
+		{
+			description: "denied request",
+			state:       &keyring.State{Keys: 2},
+			audited: []*audit.Entry{
+				audit.NewEntry("sign", "https://example.com", "key", true, "signed"),
+				audit.NewEntry("sign", "https://evil.com", "key", false, "origin not approved"),
+			},
+			want: fakes.Toolbar{Badge: "2", Color: alertColor, Title: "2 keys loaded\nDenied sign for https://evil.com: origin not approved"},
+		},
+		{
+			description: "healthy",
+			state:       &keyring.State{Keys: 1},
+			health:      keys.Healthy,
+			want:        fakes.Toolbar{Badge: "1", Color: normalColor, Title: "1 key loaded"},
+		},
+		{
+			description: "self-check found problems",
+			state:       &keyring.State{Keys: 1},
+			health:      keys.Degraded,
+			problems:    []string{"vault: unlock again"},
+			want:        fakes.Toolbar{Badge: "1", Color: warningColor, Title: "1 key loaded\nSelf-check found problems\nvault: unlock again"},
+		},
+		{
+			description: "self-check failed",
+			health:      keys.Failing,
+			problems:    []string{"agent: no answer"},
+			want:        fakes.Toolbar{Badge: alertText, Color: alertColor, Title: "No keys loaded\nSelf-check failed\nagent: no answer"},
+		},
+		{
+			description: "self-check problems and alert",
+			health:      keys.Degraded,
+			problems:    []string{"vault: unlock again"},
+			alerts:      []string{"something happened"},
+			want:        fakes.Toolbar{Badge: alertText, Color: alertColor, Title: "No keys loaded\nSelf-check found problems\nvault: unlock again\nsomething happened"},
+		},
+		{
+			description: "alert with no keys",
+			alerts:      []string{"something happened"},
+			want:        fakes.Toolbar{Badge: alertText, Color: alertColor, Title: "No keys loaded\nsomething happened"},
+		},
+		{
+			description: "many alerts",
+			alerts:      []string{"a", "b", "c", "d", "e"},
+			want:        fakes.Toolbar{Badge: alertText, Color: alertColor, Title: "No keys loaded\n2 earlier alerts\nc\nd\ne"},
+		},
+	}
+
+	for _, tc := range testcases {
+		tb := fakes.NewToolbar()
+		s := New(tb)
+		if tc.state != nil {
+			s.KeyringChanged(tc.state)
+		}
+		if tc.health != "" {
+			s.SetHealth(tc.health, tc.problems)
+		}
+		for _, e := range tc.audited {
+			s.Audited(e)
+		}
+		for _, a := range tc.alerts {
+			s.Alert(a)
+		}
+		if diff := pretty.Diff(*tb, tc.want); diff != nil {
+			t.Errorf("%s: incorrect toolbar; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestClearAlerts(t *testing.T) {
+	hub := fakes.NewMessageHub()
+	tb := fakes.NewToolbar()
+	s := New(tb)
+	Serve(s, hub)
+
+	s.KeyringChanged(&keyring.State{Keys: 1})
+	s.Alert("something happened")
+	ClearAlerts(hub)
+
+	want := fakes.Toolbar{Badge: "1", Color: normalColor, Title: "1 key loaded"}
+	if diff := pretty.Diff(*tb, want); diff != nil {
+		t.Errorf("incorrect toolbar; -got +want: %s", diff)
+	}
+}
+
+func TestKeyringSubscription(t *testing.T) {
+	tb := fakes.NewToolbar()
+	s := New(tb)
+	k := keyring.New()
+	k.Subscribe(s.KeyringChanged)
+
+	if err := k.Lock([]byte("secret")); err != nil {
+		t.Fatalf("failed to lock: %v", err)
+	}
+	if diff := pretty.Diff(tb.Badge, lockedText); diff != nil {
+		t.Errorf("incorrect badge while locked; -got +want: %s", diff)
+	}
+	if err := k.Unlock([]byte("secret")); err != nil {
+		t.Fatalf("failed to unlock: %v", err)
+	}
+	if diff := pretty.Diff(tb.Badge, ""); diff != nil {
+		t.Errorf("incorrect badge after unlock; -got +want: %s", diff)
+	}
+}
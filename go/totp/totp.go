@@ -0,0 +1,162 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package totp implements time-based one-time passwords (RFC 6238), as
+// generated by authenticator apps.  Codes are 6 digits, change every 30
+// seconds, and are computed using HMAC-SHA1; these are the defaults assumed by
+// virtually every authenticator app.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the number of digits in each code.
+	Digits = 6
+	// Step is the interval after which the code changes.
+	Step = 30 * time.Second
+	// Skew is the number of steps either side of the current one for
+	// which a code is still accepted, to tolerate clocks that disagree
+	// and codes that change while being entered.
+	Skew = 1
+	// secretSize is the size of generated secrets, in bytes.  RFC 4226
+	// recommends 160 bits.
+	secretSize = 20
+	// minSecretSize is the minimum size of an acceptable secret, in bytes.
+	minSecretSize = 10
+)
+
+var (
+	// ErrInvalidSecret indicates that a secret is not valid base32, or is
+	// too short.
+	ErrInvalidSecret = fmt.Errorf("secret must be at least %d base32 characters", minSecretSize*8/5)
+	// ErrInvalidCode indicates that a code is not the expected number of
+	// digits.
+	ErrInvalidCode = fmt.Errorf("code must be %d digits", Digits)
+	// ErrIncorrectCode indicates that a well-formed code does not match
+	// the secret.
+	ErrIncorrectCode = errors.New("incorrect code")
+)
+
+// encoding is the encoding used for secrets.  Authenticator apps display and
+// accept secrets without padding.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NormalizeSecret returns the canonical form of a base32-encoded secret.
+// Secrets are case-insensitive and may be grouped with spaces or dashes, as
+// they are when displayed by many services.
+func NormalizeSecret(secret string) string {
+	secret = strings.ToUpper(secret)
+	secret = strings.NewReplacer(" ", "", "-", "", "\t", "", "=", "").Replace(secret)
+	return secret
+}
+
+// ParseSecret decodes a base32-encoded secret.
+func ParseSecret(secret string) ([]byte, error) {
+	b, err := encoding.DecodeString(NormalizeSecret(secret))
+	if err != nil || len(b) < minSecretSize {
+		return nil, ErrInvalidSecret
+	}
+	return b, nil
+}
+
+// GenerateSecret returns a new random secret, base32-encoded.  If rnd is nil,
+// crypto/rand is used.
+func GenerateSecret(rnd io.Reader) (string, error) {
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+	b := make([]byte, secretSize)
+	if _, err := io.ReadFull(rnd, b); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %v", err)
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// code returns the code for the specified counter value (RFC 4226 Section
+// 5.3).
+func code(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
+
+// counter returns the step in which t falls.
+func counter(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(Step/time.Second))
+}
+
+// Generate returns the code for the secret at time t.
+func Generate(secret string, t time.Time) (string, error) {
+	key, err := ParseSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate checks that c is a valid code for the secret at time t.  Codes
+// from up to Skew steps either side of t are accepted.  Spaces in the code are
+// ignored, since some authenticator apps display codes in groups.
+func Validate(secret, c string, t time.Time) error {
+	key, err := ParseSecret(secret)
+	if err != nil {
+		return err
+	}
+	c = strings.Replace(c, " ", "", -1)
+	if len(c) != Digits || strings.Trim(c, "0123456789") != "" {
+		return ErrInvalidCode
+	}
+
+	now := counter(t)
+	valid := false
+	for i := -Skew; i <= Skew; i++ {
+		want := code(key, now+uint64(i))
+		if subtle.ConstantTimeCompare([]byte(want), []byte(c)) == 1 {
+			valid = true
+		}
+	}
+	if !valid {
+		return ErrIncorrectCode
+	}
+	return nil
+}
+
+// URI returns an otpauth:// URI describing the secret, from which
+// authenticator apps can be configured (typically by scanning it as a QR
+// code).  issuer and account label the entry in the app.
+func URI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", NormalizeSecret(secret))
+	q.Set("issuer", issuer)
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(account), q.Encode())
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package totp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kr/pretty"
+)
+
+const (
+	// rfcSecret is the SHA1 secret used by the test vectors in RFC 6238
+	// Appendix B ('12345678901234567890'), base32-encoded.
+	rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+)
+
+func TestGenerate(t *testing.T) {
+	testcases := []struct {
+		description string
+		secret      string
+		time        int64
+		want        string
+		wantErr     error
+	}{
+		{
+			description: "RFC 6238 vector at 59",
+			secret:      rfcSecret,
+			time:        59,
+			want:        "287082",
+		},
+		{
+			description: "RFC 6238 vector at 1111111109",
+			secret:      rfcSecret,
+			time:        1111111109,
+			want:        "081804",
+		},
+		{
+			description: "RFC 6238 vector at 1111111111",
+			secret:      rfcSecret,
+			time:        1111111111,
+			want:        "050471",
+		},
+		{
+			description: "RFC 6238 vector at 1234567890",
+			secret:      rfcSecret,
+			time:        1234567890,
+			want:        "005924",
+		},
+		{
+			description: "RFC 6238 vector at 2000000000",
+			secret:      rfcSecret,
+			time:        2000000000,
+			want:        "279037",
+		},
+		{
+			description: "lower case with spaces",
+			secret:      "gezd gnbv gy3t qojq gezd gnbv gy3t qojq",
+			time:        59,
+			want:        "287082",
+		},
+		{
+			description: "invalid base32",
+			secret:      "not a secret!",
+			time:        59,
+			wantErr:     ErrInvalidSecret,
+		},
+		{
+			description: "short secret",
+			secret:      "GEZDGNBV",
+			time:        59,
+			wantErr:     ErrInvalidSecret,
+		},
+	}
+
+	for _, tc := range testcases {
+		got, err := Generate(tc.secret, time.Unix(tc.time, 0))
+		if err != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want %v", tc.description, err, tc.wantErr)
+		}
+		if diff := pretty.Diff(got, tc.want); diff != nil {
+			t.Errorf("%s: incorrect code; -got +want: %s", tc.description, diff)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	testcases := []struct {
+		description string
+		code        string
+		wantErr     error
+	}{
+		{
+			description: "current code",
+			code:        "050471",
+		},
+		{
+			description: "grouped code",
+			code:        "050 471",
+		},
+		{
+			description: "previous code",
+			code:        mustGenerate(t, now.Add(-Step)),
+		},
+		{
+			description: "next code",
+			code:        mustGenerate(t, now.Add(Step)),
+		},
+		{
+			description: "expired code",
+			code:        mustGenerate(t, now.Add(-2*Step)),
+			wantErr:     ErrIncorrectCode,
+		},
+		{
+			description: "incorrect code",
+			code:        "123456",
+			wantErr:     ErrIncorrectCode,
+		},
+		{
+			description: "too short",
+			code:        "05047",
+			wantErr:     ErrInvalidCode,
+		},
+		{
+			description: "not digits",
+			code:        "05047a",
+			wantErr:     ErrInvalidCode,
+		},
+	}
+
+	for _, tc := range testcases {
+		if err := Validate(rfcSecret, tc.code, now); err != tc.wantErr {
+			t.Errorf("%s: incorrect error; got %v, want %v", tc.description, err, tc.wantErr)
+		}
+	}
+}
+
+func mustGenerate(t *testing.T, at time.Time) string {
+	c, err := Generate(rfcSecret, at)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	return c
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret(bytes.NewReader(make([]byte, secretSize)))
+	if err != nil {
+		t.Fatalf("GenerateSecret failed: %v", err)
+	}
+	if diff := pretty.Diff(secret, strings.Repeat("A", 32)); diff != nil {
+		t.Errorf("incorrect secret; -got +want: %s", diff)
+	}
+	if _, err := ParseSecret(secret); err != nil {
+		t.Errorf("generated secret failed to parse: %v", err)
+	}
+
+	if _, err := GenerateSecret(bytes.NewReader(nil)); err == nil {
+		t.Errorf("GenerateSecret succeeded with exhausted reader; want error")
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("SSH Agent", "deploy key", "gezd gnbv gy3t qojq")
+	want := "otpauth://totp/SSH%20Agent:deploy%20key?issuer=SSH+Agent&secret=GEZDGNBVGY3TQOJQ"
+	if diff := pretty.Diff(got, want); diff != nil {
+		t.Errorf("incorrect URI; -got +want: %s", diff)
+	}
+}
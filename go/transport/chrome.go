@@ -0,0 +1,165 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"io"
+	"log"
+
+	"github.com/google/chrome-ssh-agent/go/agentport"
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/gopherjs/gopherjs/js"
+)
+
+// Runtime provides access to Chrome's runtime API.  See chrome.C for details
+// on the methods; using this interface allows for alternate implementations
+// during testing.
+type Runtime interface {
+	// OnConnectExternal installs a callback that will be invoked when an
+	// external connection is received.
+	OnConnectExternal(callback func(port *js.Object))
+
+	// ConnectNative connects to the named native messaging host, and
+	// returns the Port used to communicate with it.
+	ConnectNative(application string) *js.Object
+
+	// Error returns the error (if any) from the last call.
+	Error() error
+}
+
+// portConn is a connection over a Chrome Port, using the message format of
+// Chrome's Secure Shell Extension (see agentport.New).
+type portConn struct {
+	io.ReadWriter
+	port      *js.Object
+	id        string
+	requester string
+	channel   string
+}
+
+func newPortConn(port *js.Object, id, requester, channel string) *portConn {
+	return &portConn{
+		ReadWriter: agentport.New(port),
+		port:       port,
+		id:         id,
+		requester:  requester,
+		channel:    channel,
+	}
+}
+
+func (p *portConn) ID() string        { return p.id }
+func (p *portConn) Requester() string { return p.requester }
+func (p *portConn) Channel() string   { return p.channel }
+func (p *portConn) Close()            { p.port.Call("disconnect") }
+
+// onDisconnect installs a callback invoked when the other end closes the
+// port.
+func (p *portConn) onDisconnect(callback func()) {
+	p.port.Get("onDisconnect").Call("addListener", callback)
+}
+
+// External is the transport over which other extensions (e.g., Chrome's
+// Secure Shell Extension) connect to the agent, using
+// chrome.runtime.connect.  Clients are identified by extension ID, and
+// select a channel by naming the port they open (e.g.,
+// chrome.runtime.connect(id, {name: 'work'})).
+type External struct {
+	rt Runtime
+}
+
+// NewExternal returns a transport accepting connections from other
+// extensions.
+func NewExternal(rt Runtime) *External {
+	return &External{rt: rt}
+}
+
+// Name implements Transport.Name.
+func (e *External) Name() string {
+	return "extension port"
+}
+
+// Listen implements Transport.Listen.
+func (e *External) Listen(accept func(c Conn), disconnected func(c Conn)) {
+	e.rt.OnConnectExternal(func(port *js.Object) {
+		id := port.Get("sender").Get("id").String()
+		c := newPortConn(port, id, audit.ExtensionRequester(id), port.Get("name").String())
+		c.onDisconnect(func() {
+			disconnected(c)
+		})
+		accept(c)
+	})
+}
+
+// Native is the transport over which local clients connect to the agent,
+// relayed by a native messaging host (see the nativemsg package).  Unlike
+// other transports, the extension connects to the host; Connect and
+// Disconnect start and stop the connection (e.g., as the user grants and
+// relinquishes the permission to use native messaging).  There is at most
+// one connection at a time, and it is always on the default channel.
+type Native struct {
+	rt           Runtime
+	host         string
+	accept       func(c Conn)
+	disconnected func(c Conn)
+	conn         *portConn
+}
+
+// NewNative returns a transport that connects to the named native messaging
+// host.
+func NewNative(rt Runtime, host string) *Native {
+	return &Native{
+		rt:   rt,
+		host: host,
+	}
+}
+
+// Name implements Transport.Name.
+func (n *Native) Name() string {
+	return "native messaging"
+}
+
+// Listen implements Transport.Listen.  Connections are only made once
+// Connect is invoked.
+func (n *Native) Listen(accept func(c Conn), disconnected func(c Conn)) {
+	n.accept = accept
+	n.disconnected = disconnected
+}
+
+// Connect connects to the native messaging host, unless already connected.
+func (n *Native) Connect() {
+	if n.conn != nil || n.accept == nil {
+		return
+	}
+	c := newPortConn(n.rt.ConnectNative(n.host), "", audit.NativeHostRequester, "")
+	n.conn = c
+	c.onDisconnect(func() {
+		log.Printf("Native messaging host disconnected: %v", n.rt.Error())
+		if n.conn == c {
+			n.conn = nil
+		}
+		n.disconnected(c)
+	})
+	n.accept(c)
+}
+
+// Disconnect disconnects from the native messaging host, if connected.
+func (n *Native) Disconnect() {
+	if n.conn == nil {
+		return
+	}
+	log.Printf("Disconnecting native messaging host")
+	n.conn.Close()
+	n.conn = nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transport serves the SSH agent to clients over any number of
+// transports (e.g., ports opened by other extensions, or a native messaging
+// host relaying local clients).  A transport only accepts connections; the
+// Server decides whether to serve each one using the ACL registered with the
+// transport, and serves the SSH agent protocol over those it admits.  Adding
+// a transport (e.g., a WebSocket) therefore requires no change to the code
+// that processes agent requests.
+//
+// Clients may connect on a named channel to select one of several logical
+// agents (e.g., a profile of keys; see keys.ProfileRouter).  The Server's
+// Router decides which agent serves each channel.
+package transport
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/google/chrome-ssh-agent/go/agentport"
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/replay"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Conn is a connection from a client over a transport.  Reads return the
+// client's framed requests, and writes deliver framed replies.
+type Conn interface {
+	io.ReadWriter
+
+	// ID identifies the client within the transport (e.g., the ID of
+	// the extension that connected), or is empty if the transport has
+	// only one client.
+	ID() string
+
+	// Requester identifies the client in the audit log.
+	Requester() string
+
+	// Channel names the agent the client asked to use (e.g., the name
+	// of the port it opened), or is empty if it did not name one.
+	Channel() string
+
+	// Close disconnects the client.
+	Close()
+}
+
+// Transport accepts connections from clients.
+type Transport interface {
+	// Name names the transport in logs (e.g., 'native messaging').
+	Name() string
+
+	// Listen starts accepting connections.  accept is invoked with each
+	// new connection, and disconnected when the client closes one.
+	Listen(accept func(c Conn), disconnected func(c Conn))
+}
+
+// ACL decides whether clients connected over a transport may use the agent.
+// Each transport is registered with its own ACL.
+type ACL interface {
+	// Admit decides whether the client may use the agent.  Exactly one
+	// of serve or refuse is invoked, possibly much later (e.g., once the
+	// user approves the client); neither is invoked if the client
+	// disconnects first.  reason describes why the client was refused.
+	Admit(c Conn, serve func(), refuse func(reason string))
+
+	// Disconnected is invoked when the client closes a connection.
+	Disconnected(c Conn)
+}
+
+// Limiter is implemented by ACLs that also limit the requests an admitted
+// client may make (e.g., to those the user permitted).
+type Limiter interface {
+	// Limit returns the agent to serve to the admitted client c in
+	// place of a.
+	Limit(c Conn, a agent.Agent) agent.Agent
+}
+
+// allowAll is an ACL that admits every client.
+type allowAll struct{}
+
+func (allowAll) Admit(c Conn, serve func(), refuse func(reason string)) { serve() }
+func (allowAll) Disconnected(c Conn)                                    {}
+
+// AllowAll is an ACL that admits every client.  It is suitable for
+// transports whose clients are authorized by other means (e.g., by the user
+// granting a permission).
+var AllowAll ACL = allowAll{}
+
+// Router selects the agent served to clients connected on each channel.
+type Router interface {
+	// Route invokes callback with the agent serving the named channel,
+	// or an error if clients may not connect on it.  The empty channel
+	// is used by clients that do not name one.
+	Route(channel string, callback func(a agent.Agent, err error))
+}
+
+// single is a Router that serves the same agent on the default channel, and
+// refuses all others.
+type single struct {
+	agent agent.Agent
+}
+
+func (s *single) Route(channel string, callback func(a agent.Agent, err error)) {
+	if channel != "" {
+		callback(nil, fmt.Errorf("unknown channel %q", channel))
+		return
+	}
+	callback(s.agent, nil)
+}
+
+// Single returns a Router that serves a to clients that do not name a
+// channel.  Clients naming a channel are refused.
+func Single(a agent.Agent) Router {
+	return &single{agent: a}
+}
+
+// Interceptor wraps the agent served over each connection (e.g., to hold
+// some requests until the user allows them).
+type Interceptor interface {
+	// Agent returns an agent that performs the requests made over the
+	// connection identified by conn using a.
+	Agent(a agent.Agent, conn string) agent.Agent
+}
+
+// Server serves the SSH agent to clients connected over its transports.
+type Server struct {
+	router       Router
+	audit        *audit.Log
+	parallelism  func() int
+	replays      *replay.Detector
+	interceptors []Interceptor
+	// conns is the number of connections served so far; it numbers each
+	// connection so that they can be told apart.
+	conns int
+}
+
+// NewServer returns a Server that serves the agents selected by router to
+// clients.  Signature requests and refused connections are recorded in
+// auditLog.  parallelism returns the number of sign requests processed
+// concurrently for a new connection (see agentport.Serve).
+func NewServer(router Router, auditLog *audit.Log, parallelism func() int) *Server {
+	return &Server{
+		router:      router,
+		audit:       auditLog,
+		parallelism: parallelism,
+	}
+}
+
+// DetectReplays checks the signature requests made over every connection
+// subsequently served for duplicates of requests made by other connections
+// (see the replay package).
+func (s *Server) DetectReplays(d *replay.Detector) {
+	s.replays = d
+}
+
+// Intercept wraps the agent served over every connection subsequently
+// served using i.  Requests pass through interceptors in the reverse of the
+// order in which they were added, after being checked for replays.
+func (s *Server) Intercept(i Interceptor) {
+	s.interceptors = append(s.interceptors, i)
+}
+
+// Add serves the agent over t.  Clients are admitted according to acl, and
+// then served the agent for the channel on which they connected, limited by
+// acl if it is a Limiter.
+func (s *Server) Add(t Transport, acl ACL) {
+	t.Listen(func(c Conn) {
+		acl.Admit(c, func() {
+			s.router.Route(c.Channel(), func(a agent.Agent, err error) {
+				if err != nil {
+					s.refuse(t, c, err.Error())
+					return
+				}
+				if l, ok := acl.(Limiter); ok {
+					a = l.Limit(c, a)
+				}
+				s.serve(t, c, a)
+			})
+		}, func(reason string) {
+			s.refuse(t, c, reason)
+		})
+	}, acl.Disconnected)
+}
+
+// serve serves agent a over a connection admitted by its ACL.
+func (s *Server) serve(t Transport, c Conn, a agent.Agent) {
+	if ch := c.Channel(); ch != "" {
+		log.Printf("Serving agent for channel %q over %s to %s", ch, t.Name(), c.Requester())
+	} else {
+		log.Printf("Serving agent over %s to %s", t.Name(), c.Requester())
+	}
+	s.conns++
+	conn := fmt.Sprintf("%s (connection %d)", c.Requester(), s.conns)
+	for _, i := range s.interceptors {
+		a = i.Agent(a, conn)
+	}
+	if s.replays != nil {
+		a = s.replays.Agent(a, conn)
+	}
+	go func() {
+		err := agentport.Serve(audit.NewAgent(a, s.audit, c.Requester()), c, s.parallelism())
+		if err != nil && err != io.EOF {
+			log.Printf("Stopped serving agent over %s to %s: %v", t.Name(), c.Requester(), err)
+		}
+	}()
+}
+
+// refuse disconnects a connection refused by its ACL.
+func (s *Server) refuse(t Transport, c Conn, reason string) {
+	log.Printf("Refusing connection over %s from %s: %s", t.Name(), c.Requester(), reason)
+	s.audit.Record(audit.NewEntry("connect", c.Requester(), "", false, reason), nil)
+	c.Close()
+}
@@ -0,0 +1,401 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/audit"
+	"github.com/google/chrome-ssh-agent/go/chrome/fakes"
+	"github.com/google/chrome-ssh-agent/go/replay"
+	"github.com/kr/pretty"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// fakeConn is a connection over a fakeTransport.  The client end of the
+// connection is read and written by the test.
+type fakeConn struct {
+	io.Reader
+	io.Writer
+	id      string
+	channel string
+	client  *fakeClient
+	closed  bool
+}
+
+func (f *fakeConn) ID() string        { return f.id }
+func (f *fakeConn) Requester() string { return "fake:" + f.id }
+func (f *fakeConn) Channel() string   { return f.channel }
+func (f *fakeConn) Close() {
+	f.closed = true
+	f.client.w.Close()
+}
+
+// fakeClient is the client end of a fakeConn.
+type fakeClient struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+// fakeTransport is a transport whose connections are made by the test.
+type fakeTransport struct {
+	accept       func(c Conn)
+	disconnected func(c Conn)
+}
+
+func (f *fakeTransport) Name() string { return "fake" }
+
+func (f *fakeTransport) Listen(accept func(c Conn), disconnected func(c Conn)) {
+	f.accept = accept
+	f.disconnected = disconnected
+}
+
+// connect makes a new connection from the client with the specified ID.
+func (f *fakeTransport) connect(id string) *fakeConn {
+	return f.connectChannel(id, "")
+}
+
+// connectChannel makes a new connection from the client with the specified
+// ID on the named channel.
+func (f *fakeTransport) connectChannel(id, channel string) *fakeConn {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	c := &fakeConn{
+		Reader:  sr,
+		Writer:  sw,
+		id:      id,
+		channel: channel,
+		client:  &fakeClient{r: cr, w: cw},
+	}
+	f.accept(c)
+	return c
+}
+
+// listKeys sends a request to list keys over c, and returns the reply.
+func listKeys(c *fakeConn) ([]byte, error) {
+	if _, err := c.client.w.Write([]byte{0, 0, 0, 1, 11}); err != nil {
+		return nil, err
+	}
+	rsp := make([]byte, 9)
+	if _, err := io.ReadFull(c.client.r, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// sign sends a request to sign data using key over c, and returns the type
+// of the reply.
+func sign(c *fakeConn, key ssh.PublicKey, data []byte) (byte, error) {
+	req := ssh.Marshal(struct {
+		Type  byte
+		Key   []byte
+		Data  []byte
+		Flags uint32
+	}{13, key.Marshal(), data, 0})
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(req)))
+	if _, err := c.client.w.Write(append(length[:], req...)); err != nil {
+		return 0, err
+	}
+	if _, err := io.ReadFull(c.client.r, length[:]); err != nil {
+		return 0, err
+	}
+	rsp := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := io.ReadFull(c.client.r, rsp); err != nil {
+		return 0, err
+	}
+	return rsp[0], nil
+}
+
+// fakeACL is an ACL that serves clients with the ID 'good', refuses all
+// others, and records disconnections.
+type fakeACL struct {
+	disconnected []string
+}
+
+func (f *fakeACL) Admit(c Conn, serve func(), refuse func(reason string)) {
+	if c.ID() == "good" {
+		serve()
+		return
+	}
+	refuse("not good")
+}
+
+func (f *fakeACL) Disconnected(c Conn) {
+	f.disconnected = append(f.disconnected, c.ID())
+}
+
+func TestServer(t *testing.T) {
+	log := audit.NewLog(fakes.NewMemStorage(), 10)
+	s := NewServer(Single(agent.NewKeyring()), log, func() int { return 1 })
+	tr := &fakeTransport{}
+	acl := &fakeACL{}
+	s.Add(tr, acl)
+
+	// An admitted client is served.
+	good := tr.connect("good")
+	rsp, err := listKeys(good)
+	if err != nil {
+		t.Errorf("failed to list keys: %v", err)
+	}
+	if diff := pretty.Diff(rsp, []byte{0, 0, 0, 5, 12, 0, 0, 0, 0}); diff != nil {
+		t.Errorf("incorrect reply; -got +want: %s", diff)
+	}
+	if good.closed {
+		t.Errorf("admitted connection closed")
+	}
+
+	// A refused client is disconnected, and the refusal recorded.
+	bad := tr.connect("bad")
+	if !bad.closed {
+		t.Errorf("refused connection not closed")
+	}
+	var entries []*audit.Entry
+	log.Entries(func(e []*audit.Entry, err error) {
+		if err != nil {
+			t.Errorf("failed to read audit log: %v", err)
+		}
+		entries = e
+	})
+	if len(entries) != 1 {
+		t.Fatalf("incorrect number of audit entries; got %d, want 1", len(entries))
+	}
+	got := []interface{}{entries[0].Action, entries[0].Requester, entries[0].Allowed, entries[0].Detail}
+	if diff := pretty.Diff(got, []interface{}{"connect", "fake:bad", false, "not good"}); diff != nil {
+		t.Errorf("incorrect audit entry; -got +want: %s", diff)
+	}
+
+	// Disconnections are passed to the ACL.
+	tr.disconnected(good)
+	if diff := pretty.Diff(acl.disconnected, []string{"good"}); diff != nil {
+		t.Errorf("incorrect disconnections; -got +want: %s", diff)
+	}
+	good.client.w.Close()
+}
+
+// limitingACL is an ACL that admits every client, and serves them limit
+// in place of the routed agent.
+type limitingACL struct {
+	limit agent.Agent
+}
+
+func (l *limitingACL) Admit(c Conn, serve func(), refuse func(reason string)) { serve() }
+func (l *limitingACL) Disconnected(c Conn)                                    {}
+func (l *limitingACL) Limit(c Conn, a agent.Agent) agent.Agent                { return l.limit }
+
+func TestServerLimiter(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	limited := agent.NewKeyring()
+	if err := limited.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	s := NewServer(Single(agent.NewKeyring()), audit.NewLog(fakes.NewMemStorage(), 10), func() int { return 1 })
+	tr := &fakeTransport{}
+	s.Add(tr, &limitingACL{limit: limited})
+
+	// The client is served the agent returned by the ACL.
+	c := tr.connect("good")
+	rsp, err := listKeys(c)
+	if err != nil {
+		t.Errorf("failed to list keys: %v", err)
+	}
+	if len(rsp) != 9 || rsp[8] != 1 {
+		t.Errorf("incorrect reply; got %v, want 1 key", rsp)
+	}
+	c.client.w.Close()
+}
+
+// recordingInterceptor is an Interceptor recording the connections whose
+// agents it wraps.
+type recordingInterceptor struct {
+	conns []string
+}
+
+func (r *recordingInterceptor) Agent(a agent.Agent, conn string) agent.Agent {
+	r.conns = append(r.conns, conn)
+	return a
+}
+
+func TestServerIntercept(t *testing.T) {
+	s := NewServer(Single(agent.NewKeyring()), audit.NewLog(fakes.NewMemStorage(), 10), func() int { return 1 })
+	icpt := &recordingInterceptor{}
+	s.Intercept(icpt)
+	tr := &fakeTransport{}
+	s.Add(tr, AllowAll)
+
+	// The agent served to the connection is wrapped, identifying the
+	// connection.
+	c := tr.connect("good")
+	if _, err := listKeys(c); err != nil {
+		t.Errorf("failed to list keys: %v", err)
+	}
+	c.client.w.Close()
+	if diff := pretty.Diff(icpt.conns, []string{"fake:good (connection 1)"}); diff != nil {
+		t.Errorf("incorrect intercepted connections; -got +want: %s", diff)
+	}
+}
+
+// fakeRouter is a Router serving a separate agent on each of its channels.
+type fakeRouter map[string]agent.Agent
+
+func (f fakeRouter) Route(channel string, callback func(a agent.Agent, err error)) {
+	a, ok := f[channel]
+	if !ok {
+		callback(nil, errors.New("no such channel"))
+		return
+	}
+	callback(a, nil)
+}
+
+func TestServerChannels(t *testing.T) {
+	log := audit.NewLog(fakes.NewMemStorage(), 10)
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	work := agent.NewKeyring()
+	if err := work.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	s := NewServer(fakeRouter{"": agent.NewKeyring(), "work": work}, log, func() int { return 1 })
+	tr := &fakeTransport{}
+	s.Add(tr, AllowAll)
+
+	// Each client is served the agent for its channel.
+	for _, tc := range []struct {
+		channel  string
+		wantKeys byte
+	}{
+		{channel: "", wantKeys: 0},
+		{channel: "work", wantKeys: 1},
+	} {
+		c := tr.connectChannel("good", tc.channel)
+		rsp, err := listKeys(c)
+		if err != nil {
+			t.Errorf("%s: failed to list keys: %v", tc.channel, err)
+		}
+		if len(rsp) != 9 || rsp[8] != tc.wantKeys {
+			t.Errorf("%s: incorrect reply; got %v, want %d keys", tc.channel, rsp, tc.wantKeys)
+		}
+		c.client.w.Close()
+	}
+
+	// A client naming an unknown channel is disconnected, and the
+	// refusal recorded.
+	bad := tr.connectChannel("good", "personal")
+	if !bad.closed {
+		t.Errorf("connection on unknown channel not closed")
+	}
+	var entries []*audit.Entry
+	log.Entries(func(e []*audit.Entry, err error) {
+		if err != nil {
+			t.Errorf("failed to read audit log: %v", err)
+		}
+		entries = e
+	})
+	if len(entries) != 1 {
+		t.Fatalf("incorrect number of audit entries; got %d, want 1", len(entries))
+	}
+	got := []interface{}{entries[0].Action, entries[0].Requester, entries[0].Allowed, entries[0].Detail}
+	if diff := pretty.Diff(got, []interface{}{"connect", "fake:good", false, "no such channel"}); diff != nil {
+		t.Errorf("incorrect audit entry; -got +want: %s", diff)
+	}
+}
+
+func TestServerReplays(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	a := agent.NewKeyring()
+	if err := a.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+
+	var duplicates []*replay.Duplicate
+	d := replay.NewDetector(func(dup *replay.Duplicate) {
+		duplicates = append(duplicates, dup)
+	})
+	d.Configure(&replay.Settings{Window: time.Minute, Block: true})
+	s := NewServer(Single(a), audit.NewLog(fakes.NewMemStorage(), 10), func() int { return 1 })
+	s.DetectReplays(d)
+	tr := &fakeTransport{}
+	s.Add(tr, AllowAll)
+
+	// The same request from a second connection is refused, even if
+	// made by the same client.
+	var got []byte
+	for i := 0; i < 2; i++ {
+		c := tr.connect("good")
+		rsp, err := sign(c, signer.PublicKey(), []byte("some-data"))
+		if err != nil {
+			t.Errorf("failed to sign: %v", err)
+		}
+		got = append(got, rsp)
+		c.client.w.Close()
+	}
+	if diff := pretty.Diff(got, []byte{14, 5}); diff != nil {
+		t.Errorf("incorrect replies; -got +want: %s", diff)
+	}
+	if len(duplicates) != 1 {
+		t.Fatalf("incorrect number of duplicates; got %d, want 1", len(duplicates))
+	}
+	gotConns := []string{duplicates[0].Original, duplicates[0].Conn}
+	if diff := pretty.Diff(gotConns, []string{"fake:good (connection 1)", "fake:good (connection 2)"}); diff != nil {
+		t.Errorf("incorrect connections; -got +want: %s", diff)
+	}
+}
+
+func TestSingle(t *testing.T) {
+	a := agent.NewKeyring()
+	Single(a).Route("", func(got agent.Agent, err error) {
+		if err != nil {
+			t.Errorf("failed to route default channel: %v", err)
+		}
+		if got != a {
+			t.Errorf("incorrect agent for default channel")
+		}
+	})
+	Single(a).Route("work", func(got agent.Agent, err error) {
+		if err == nil {
+			t.Errorf("unknown channel unexpectedly routed")
+		}
+	})
+}
+
+func TestAllowAll(t *testing.T) {
+	served := false
+	AllowAll.Admit(&fakeConn{}, func() {
+		served = true
+	}, func(reason string) {
+		t.Errorf("connection refused: %s", reason)
+	})
+	if !served {
+		t.Errorf("connection not served")
+	}
+}